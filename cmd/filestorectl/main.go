@@ -0,0 +1,118 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/compute/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/filestorectl"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+var (
+	project                         = flag.String("project", "", "Project of the Filestore instances. Required.")
+	location                        = flag.String("location", "-", "Zone or region of the instances listed by the instances command, - for all locations.")
+	driverName                      = flag.String("driver-name", "filestore.csi.storage.gke.io", "Name of the Filestore CSI driver whose instances and PVs are inspected.")
+	kubeconfig                      = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file of the cluster whose PVs are checked by the orphans and migrate commands.")
+	apply                           = flag.Bool("apply", false, "If set, the migrate command updates the PVs, otherwise it only prints the changes.")
+	labelPrefix                     = flag.String("label-prefix", "", "Label prefix of the driver, see the --label-prefix flag of the driver.")
+	driverInstanceID                = flag.String("driver-instance-id", "", "Driver instance ID of the driver, see the --driver-instance-id flag of the driver.")
+	primaryFilestoreServiceEndpoint = flag.String("primary-filestore-service-endpoint", "", "Primary endpoint for filestore service. This takes precedence over filestore-service-endpoint if present.")
+	testFilestoreServiceEndpoint    = flag.String("filestore-service-endpoint", "", "Endpoint for filestore service - used for testing only. Must be a well-known string.")
+
+	// This is set at compile time
+	version = "unknown"
+)
+
+const usage = `Usage: filestorectl --project <project> [flags] <command>
+
+Commands:
+  handle <volume handle>  Parse a volume handle and show the instance or share backing it.
+  instances               List the instances created by the driver and the packing state of the multishare instances.
+  orphans                 List the instances and shares backing no PV, and the PVs whose instance or share is missing.
+  migrate                 Hand the PVs of the legacy gcp-filestore provisioner over to the driver, with --apply.
+
+Flags:
+`
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	command, arg, err := filestorectl.ParseCommand(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *project == "" {
+		fmt.Fprintln(os.Stderr, "--project is required")
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	client, err := google.DefaultClient(ctx, compute.CloudPlatformScope)
+	if err != nil {
+		klog.Fatalf("Failed to create the Google Cloud client: %v", err)
+	}
+	fileService, err := file.NewGCFSService(version, client, *primaryFilestoreServiceEndpoint, *testFilestoreServiceEndpoint, file.OpPollConfig{})
+	if err != nil {
+		klog.Fatalf("Failed to initialize the Filestore service: %v", err)
+	}
+	fileService, err = file.NewLabelNamespacedService(fileService, *labelPrefix, *driverInstanceID)
+	if err != nil {
+		klog.Fatalf("Bad label namespace: %v", err)
+	}
+	inspector := filestorectl.NewInspector(fileService, *project, *driverName, os.Stdout)
+
+	switch command {
+	case "handle":
+		err = inspector.DescribeHandle(ctx, arg)
+	case "instances":
+		err = inspector.ListInstances(ctx, *location)
+	case "orphans", "migrate":
+		config, configErr := util.BuildConfig(*kubeconfig)
+		if configErr != nil {
+			klog.Fatalf("Failed to build the kubernetes client config: %v", configErr)
+		}
+		kubeClient, clientErr := kubernetes.NewForConfig(config)
+		if clientErr != nil {
+			klog.Fatalf("Failed to create the kubernetes client: %v", clientErr)
+		}
+		if command == "orphans" {
+			err = inspector.FindOrphans(ctx, kubeClient)
+		} else {
+			err = inspector.MigrateLegacyPVs(ctx, kubeClient, *apply)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", command, err)
+		os.Exit(1)
+	}
+}
@@ -19,76 +19,249 @@ package main
 import (
 	"context"
 	"flag"
+	"net/http"
 	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
+	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
 	metadataservice "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
 	driver "sigs.k8s.io/gcp-filestore-csi-driver/pkg/csi_driver"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/features"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
 	lockrelease "sigs.k8s.io/gcp-filestore-csi-driver/pkg/releaselock"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/supportbundle"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
 )
 
 var (
-	endpoint                        = flag.String("endpoint", "unix:/tmp/csi.sock", "CSI endpoint")
+	endpoint                        = flag.String("endpoint", "unix:/tmp/csi.sock", "CSI endpoint, either a unix domain socket (unix:/path/to/csi.sock) or, for test environments, a TCP address (tcp://host:port)")
 	nodeID                          = flag.String("nodeid", "", "node id")
 	runController                   = flag.Bool("controller", false, "run controller service")
 	runNode                         = flag.Bool("node", false, "run node service")
 	cloudConfigFilePath             = flag.String("cloud-config", "", "Path to GCE cloud provider config")
 	httpEndpoint                    = flag.String("http-endpoint", "", "The TCP network address where the prometheus metrics endpoint will listen (example: `:8080`). The default is empty string, which means metrics endpoint is disabled.")
+	enableProfiling                 = flag.Bool("enable-profiling", false, "If set, the Go runtime metrics and the pprof endpoints, under /debug/pprof/, are served on the metrics endpoint, see --http-endpoint. The profiles expose the internals of the driver, only enable it to diagnose a running driver.")
 	metricsPath                     = flag.String("metrics-path", "/metrics", "The HTTP path where prometheus metrics will be exposed. Default is `/metrics`.")
-	enableMultishare                = flag.Bool("enable-multishare", false, "if set to true, the driver will support multishare instance provisioning")
+	configFile                      = flag.String("config", "", "If non-empty, path to a YAML file setting the defaults of the driver flags, keyed by flag name, e.g. 'feature-gates: {Multishare: true}' or 'kube-api-qps: 10'. Lists are joined with commas and maps are joined as comma separated key=value pairs. The flags set on the command line take precedence.")
+	supportBundleLogLines           = flag.Int("support-bundle-log-lines", 10000, "Number of the last log lines of the controller included in the support bundles served on /debug/supportbundle of the debug endpoint. Zero excludes the logs, which are then not captured.")
+	debugEndpoint                   = flag.String("debug-endpoint", "", "The TCP network address where the debug endpoints, e.g. /featurez reporting the state of the feature gates, are served (example: `:8081`). The default is empty string, which means debug endpoints are disabled.")
+	enableMultishare                = flag.Bool("enable-multishare", false, "if set to true, the driver will support multishare instance provisioning. Deprecated, use --feature-gates=Multishare=true instead.")
 	testFilestoreServiceEndpoint    = flag.String("filestore-service-endpoint", "", "Endpoint for filestore service - used for testing only. Must be a well-known string.")
 	primaryFilestoreServiceEndpoint = flag.String("primary-filestore-service-endpoint", "", "Primary endpoint for filestore service. This takes precedence over filestore-service-endpoint if present.")
+	multishareListParallelism       = flag.Int("multishare-list-parallelism", 8, "Maximum number of concurrent per-instance share list calls when looking for an eligible multishare instance. Defaults to 8.")
+	instancePoolConfig              = flag.String("instance-pool-config", "", "If non-empty, path to a YAML file configuring the multishare operations of each instance pool, i.e. of the storage classes sharing an instance-storageclass-label: the max number of concurrent and queued CreateVolume, DeleteVolume and ControllerExpandVolume calls, and the packing policy of new shares.")
+	multishareStuckOpThreshold      = flag.Duration("multishare-stuck-op-threshold", time.Hour, "Age after which a running multishare instance or share operation is reported as stuck, with a metric and a warning event on the PVs of the operation. Defaults to 1 hour, 0 disables the reports.")
+	maxTotalProvisionedTBPerRegion  = flag.Int64("max-total-provisioned-tb-per-region", 0, "If non-zero, the maximum total capacity in TiB of the multishare instances managed by the driver in a region. The creations and expansions of instances over the limit fail with a ResourceExhausted error. Defaults to 0, which disables the limit.")
+	multishareListCacheTTL          = flag.Duration("multishare-list-cache-ttl", 0, "If non-zero, the controller caches the Filestore multishare instance and share lists for this duration. The cache is invalidated whenever the driver starts a Filestore operation. Defaults to 0, which disables the cache.")
+	opPollInterval                  = flag.Duration("op-poll-interval", file.DefaultOpPollConfig.Interval, "Interval at which the driver polls Filestore operations it waits on. Multishare operations configured with a slower interval are polled at this interval until op-poll-slowdown-after.")
+	opPollSlowInterval              = flag.Duration("op-poll-slow-interval", file.DefaultOpPollConfig.SlowInterval, "Interval at which the driver polls Filestore operations that have been running for longer than op-poll-slowdown-after.")
+	opPollSlowdownAfter             = flag.Duration("op-poll-slowdown-after", file.DefaultOpPollConfig.SlowdownAfter, "Duration after which the driver polls a running Filestore operation at op-poll-slow-interval instead of op-poll-interval. Set to 0 to poll at a fixed interval.")
+	maxConcurrentRPCs               = flag.Int("max-concurrent-rpcs", 0, "Maximum number of CSI controller and node RPCs handled at the same time. Further RPCs are rejected with ResourceExhausted and retried by the sidecars. Defaults to 0, which means no limit.")
+	rpcTimeout                      = flag.Duration("rpc-timeout", 0, "Deadline enforced by the driver on every CSI RPC, in addition to the deadline set by the caller. Defaults to 0, which means no server side deadline.")
+	createNFSFirewallRules          = flag.Bool("create-nfs-firewall-rules", false, "If set, the controller creates the firewall rule allowing the NFS traffic between the nodes and the Filestore instances of each VPC network at the first provisioning in the network, and adds the reserved range of the new instances to it. Requires the compute.firewalls.get, compute.firewalls.create and compute.firewalls.update permissions. Defaults to false.")
+	defaultTier                     = flag.String("default-tier", "", "Tier of the instance mode volumes whose StorageClass omits the tier parameter, e.g. enterprise. Defaults to empty, which keeps the standard tier. The multishare volumes are always enterprise.")
+	defaultNetwork                  = flag.String("default-network", "", "VPC network of the volumes whose StorageClass omits the network parameter. Defaults to empty, which keeps the default network.")
+	defaultConnectMode              = flag.String("default-connect-mode", "", "Connect mode, DIRECT_PEERING or PRIVATE_SERVICE_ACCESS, of the volumes whose StorageClass omits the connect-mode parameter. Defaults to empty, which keeps DIRECT_PEERING.")
+	instanceCreateTimeout           = flag.Duration("instance-create-timeout", 0, "Deadline of the wait of CreateVolume on the creation of the instance of a volume, overridden by the instance-create-timeout StorageClass parameter, e.g. to return before the timeout of the csi-provisioner on the enterprise instances. CreateVolume fails with DeadlineExceeded on the deadline while the creation keeps running, and its retries resume the wait. Defaults to 0, which keeps 5m for the instances and 1h for the multishare instances.")
+	shareCreateTimeout              = flag.Duration("share-create-timeout", 0, "Deadline of the wait of CreateVolume on the creation of the share of a multishare volume, overridden by the share-create-timeout StorageClass parameter. Defaults to 0, which keeps 10m.")
+	expandTimeout                   = flag.Duration("expand-timeout", 0, "Deadline of the wait of ControllerExpandVolume on the expansion of an instance or share, and of CreateVolume on the expansion of the multishare instance making room for a new share. Defaults to 0, which keeps 5m for the instances and 10m for the multishare instances and shares.")
+	allowedTiers                    = flag.String("allowed-tiers", "", "Comma separated tiers the volumes provisioned by the driver can be of, e.g. enterprise. CreateVolume rejects the volumes of other tiers with InvalidArgument. Defaults to empty, which allows all tiers.")
+	deniedTiers                     = flag.String("denied-tiers", "", "Comma separated tiers the volumes provisioned by the driver can't be of, e.g. high_scale_ssd. CreateVolume rejects the volumes of these tiers with InvalidArgument.")
+	nfsFirewallNodeCIDRs            = flag.String("nfs-firewall-node-cidrs", "", "Comma separated node CIDRs of the firewall rules created with create-nfs-firewall-rules, e.g. the node subnet range of the cluster. Defaults to empty, which allows the NFS traffic to all the instances of the network.")
+	auditLogPath                    = flag.String("audit-log-path", "", "Path of the file the mutating CSI RPCs are recorded to as JSON lines, with their caller, parameters with secrets redacted, volumes and outcome, or - for stdout. Defaults to empty, which disables the audit log.")
+	grpcDrainTimeout                = flag.Duration("grpc-drain-timeout", 30*time.Second, "Duration the driver waits for in-flight CSI RPCs to complete on SIGTERM before exiting. Defaults to 30 seconds.")
+	endpointSocketMode              = flag.String("endpoint-socket-mode", "", "If non-empty, octal file mode applied to the unix domain socket of the CSI endpoint, e.g. 0660.")
+	endpointSocketGroup             = flag.Int("endpoint-socket-group", -1, "If non-negative, group ID set as owner of the unix domain socket of the CSI endpoint.")
 	ecfsDescription                 = flag.String("ecfs-description", "", "Filestore multishare instance descrption. ecfs-version=<version>,image-project-id=<projectid>")
 	isRegional                      = flag.Bool("is-regional", false, "cluster is regional cluster")
 	gkeClusterName                  = flag.String("gke-cluster-name", "", "Cluster Name of the current GKE cluster driver is running on, required for multishare")
+	clusterName                     = flag.String("cluster-name", "", "Name of the cluster the driver is running on, used to label and match multishare instances. Takes precedence over gke-cluster-name, e.g. for self-managed clusters. Defaults to the "+clusterNameEnv+" environment variable, which can be set from the downward API.")
+	clusterLocation                 = flag.String("cluster-location", "", "Location of the cluster the driver is running on, used to label and match multishare instances. Defaults to the "+clusterLocationEnv+" environment variable, which can be set from the downward API, else to the zone of the driver, or its region if is-regional is set.")
+	clusterUID                      = flag.String("cluster-uid", "", "UID of the cluster the driver is running on, e.g. the UID of its kube-system namespace, recorded on the multishare instances it creates so that they are traced back to the cluster, and not reused by another cluster of the same name and location. Defaults to the "+clusterUIDEnv+" environment variable.")
+	sharedClusterGroup              = flag.String("shared-cluster-group", "", "If non-empty, ID of a group of clusters, e.g. blue/green clusters, sharing multishare instances. The instances created are labeled with the group ID, and the shares are packed onto the instances labeled with the same group ID regardless of the cluster that created them. Not supported with the stateful multishare controller.")
+	labelPrefix                     = flag.String("label-prefix", "", "Prefix of the keys of the labels the driver applies to the Filestore resources, storage_gke_io if empty. Drivers of the same project with different prefixes never adopt each other's instances.")
+	driverInstanceID                = flag.String("driver-instance-id", "", "If non-empty, ID recorded in a label of the Filestore resources created by the driver. The driver ignores the labels of the resources of another driver instance ID, e.g. of a test driver running in the same project, and never adopts their instances.")
 	extraVolumeLabelsStr            = flag.String("extra-labels", "", "Extra labels to attach to each volume created. It is a comma separated list of key value pairs like '<key1>=<value1>,<key2>=<value2>'. See https://cloud.google.com/compute/docs/labeling-resources for details")
+	volumeLocationAliasesStr        = flag.String("volume-location-aliases", "", "Comma separated list of <location>=<alias> pairs, e.g. 'us-central1-c=us-central1'. The instances of the instance mode volumes whose volume ID holds the location, and which are not found at that location, are looked up at the alias location instead, e.g. after the StorageClass of the volumes moved between zonal and regional tiers. The volume IDs of the existing PVs are unchanged.")
+	maxConcurrentMounts             = flag.Int("max-concurrent-mounts", 0, "Maximum number of NFS mounts of the volumes staged in parallel by the node driver, protecting the NFS client and rpcbind of the node from mount storms, e.g. when hundreds of pods are scheduled at once on a replaced node. 0, the default, does not limit the mounts.")
+	mountQueueTimeout               = flag.Duration("mount-queue-timeout", 2*time.Minute, "Maximum duration a mount waits for one of the --max-concurrent-mounts mounts to complete, after which its NodeStageVolume call fails with ResourceExhausted and is retried by the kubelet. Defaults to 2 minutes.")
+	verifyNodeExpansion             = flag.Bool("verify-node-expansion", false, "If set, the expansions of the volumes complete once the node drivers verified that the NFS filesystems of the volumes published on their node report the expanded capacity, instead of once the instance or share is expanded. Must be set on both the controller and the node driver.")
+	nfsMountHelper                  = flag.String("nfs-mount-helper", driver.MountHelperAuto, "NFS mount helper of the node driver: container runs the mounts with the mount.nfs of the driver image, host runs them chrooted to the root of the node mounted at host-root, with the mount.nfs of the node, and auto selects the container helper if the driver image ships one, else the host helper. If no helper is available, NodeStageVolume fails with FailedPrecondition naming the node OS. Defaults to auto.")
+	hostRoot                        = flag.String("host-root", "", "Path the root of the node is mounted at in the node driver container, with bidirectional mount propagation, e.g. /host. Used to detect the node OS and by the host NFS mount helper. Defaults to empty, which disables them.")
+	kerberosKeytabPath              = flag.String("kerberos-keytab-path", "", "Path of the keytab the node driver merges the krb5.keytab key of the node stage secret of the volumes with a Kerberos auth-flavor StorageClass parameter into, refusing to stage a volume whose keytab has another key for a principal, key version and encryption type of the keytab, e.g. /host/etc/krb5.keytab for the keytab of the node with the host NFS mount helper. Defaults to empty, with which the volumes whose secret holds a keytab fail to stage, and the others rely on the Kerberos configuration of the node.")
+	allowSoftMounts                 = flag.Bool("allow-soft-mounts", false, "If set, the volumes with the soft mount-policy StorageClass parameter, or mountPolicy volume attribute, are mounted with the soft NFS option, failing the I/O of the applications with an error instead of hanging when the instance is unreachable. Acknowledges the risk of data corruption of the applications not handling these errors. Must be set on both the controller and the node driver.")
+	clearDeletionProtection         = flag.Bool("clear-deletion-protection", false, "If set, DeleteVolume deletes the instances created with the deletion-protection StorageClass parameter instead of refusing to, e.g. to clean up a test cluster.")
+	backupBeforeExpandTimeout       = flag.Duration("backup-before-expand-timeout", 10*time.Minute, "Maximum duration ControllerExpandVolume waits for the backup of the volumes created with the backup-before-expand StorageClass parameter, after which the expansion is retried until the backup is ready.")
+	connectivityCheckTimeout        = flag.Duration("connectivity-check-timeout", 0, "If positive, CreateVolume verifies the NFS port of the instances of the new non-multishare volumes is reachable from the controller, through the pod network, within the duration, and fails with Unavailable otherwise, so that the peering and firewall misconfigurations are caught at provisioning rather than at the first mount. The instance is kept and checked again on the retries. Defaults to 0, which disables the check.")
 	resourceTagsStr                 = flag.String("resource-tags", "", "Resource tags to attach to each volume created. It is a comma separated list of tags of the form '<parentID_1>/<tagKey_1>/<tagValue_1>...<parentID_N>/<tagKey_N>/<tagValue_N>' where, parentID is the ID of Organization or Project resource where tag key and value resources exist, tagKey is the shortName of the tag key resource, tagValue is the shortName of the tag value resource. See https://cloud.google.com/resource-manager/docs/tags/tags-creating-and-managing for more details.")
 
 	// Feature lock release specific parameters, only take effect when feature-lock-release is set to true.
-	featureLockRelease    = flag.Bool("feature-lock-release", false, "if set to true, the node driver will support Filestore lock release.")
+	featureLockRelease    = flag.Bool("feature-lock-release", false, "if set to true, the node driver will support Filestore lock release. Deprecated, use --feature-gates=LockRelease=true instead.")
 	lockReleaseSyncPeriod = flag.Duration("lock-release-sync-period", 60*time.Second, "Duration, in seconds, the sync period of the lock release controller. Defaults to 60 seconds.")
 
+	// Feature mount health reporter specific parameters, only take effect when feature-mount-health-reporter is set to true.
+	featureMountHealth      = flag.Bool("feature-mount-health-reporter", false, "if set to true, the node driver will periodically probe staged Filestore mounts and expose their health as prometheus metrics on http-endpoint. Deprecated, use --feature-gates=MountHealthReporter=true instead.")
+	mountHealthProbePeriod  = flag.Duration("mount-health-probe-period", 60*time.Second, "Duration, in seconds, between two consecutive health probes of the staged mounts. Defaults to 60 seconds.")
+	mountHealthProbeTimeout = flag.Duration("mount-health-probe-timeout", 10*time.Second, "Duration, in seconds, after which a pending mount health probe marks the mount unreachable. Defaults to 10 seconds.")
+
+	// Feature instance events specific parameters, only take effect when the InstanceEvents feature gate is enabled.
+	instanceEventsPollPeriod = flag.Duration("instance-events-poll-period", 5*time.Minute, "Duration between two consecutive checks of the state of the Filestore instances backing the PVs, to publish events on the PVs and PVCs whose instance becomes unavailable. Defaults to 5 minutes.")
+
+	// Feature tier recommendations specific parameters, only take effect when the TierRecommendations feature gate is enabled.
+	tierAnalysisPeriod = flag.Duration("tier-analysis-period", time.Minute, "Duration between two consecutive samples of the NFS throughput and capacity usage of the staged volumes by the node driver. Defaults to 1 minute.")
+	tierAnalysisWindow = flag.Duration("tier-analysis-window", 7*24*time.Hour, "Duration over which the usage of a staged volume is observed by the node driver before a right-sizing recommendation is made. Defaults to 7 days.")
+
+	// Feature NFS stats specific parameters, only take effect when the NFSStats feature gate is enabled.
+	nfsStatsPeriod = flag.Duration("nfs-stats-period", 30*time.Second, "Duration between two consecutive reads of the NFS client statistics of the staged volumes by the node driver. Defaults to 30 seconds.")
+
+	// Feature instance IP refresh specific parameters, only take effect when the InstanceIPRefresh feature gate is enabled.
+	instanceIPRefreshPeriod    = flag.Duration("instance-ip-refresh-period", 10*time.Minute, "Duration between two consecutive refreshes of the IPs of the instances by the controller driver. Defaults to 10 minutes.")
+	instanceIPCacheTTL         = flag.Duration("instance-ip-cache-ttl", time.Minute, "Duration the node driver caches the IPs of the instances published by the controller driver. Defaults to 1 minute.")
+	instanceIPRefreshNamespace = flag.String("instance-ip-refresh-namespace", "gcp-filestore-csi-driver", "The namespace of the ConfigMap of the IPs of the instances published by the controller driver.")
+
+	// Feature deferred instance creation specific parameters, only take effect when the DeferredInstanceCreation feature gate is enabled.
+	instanceCreationDeferralWindow = flag.Duration("instance-creation-deferral-window", 30*time.Minute, "Maximum duration the controller driver defers the creation of the instance of a PVC no pod uses yet. Defaults to 30 minutes.")
+
+	// Feature cost estimation specific parameters, only take effect when the CostEstimation feature gate is enabled.
+	costEstimationPricing        = flag.String("cost-estimation-pricing", "", "Comma separated <tier>=<price> pairs overriding the estimated monthly price in US dollars of a GiB of capacity of the tiers, e.g. enterprise=0.45,zonal=0.3. The default prices approximate the list prices of us-central1.")
+	costEstimationAlertThreshold = flag.Float64("cost-estimation-alert-threshold", 0, "Estimated monthly cost in US dollars of a new instance above which a warning event is published on the PVC of its volume. Defaults to 0, which disables the events.")
+
+	// Feature share export annotations specific parameters, only take effect when the ShareExportAnnotations feature gate is enabled.
+	shareExportAnnotations = flag.String("share-export-annotations", "access-mode,ip-ranges", "Comma separated NFS export options the filestore.csi.storage.gke.io/export-<option> annotations of the PVCs can override on the shares of their new multishare volumes, among access-mode, ip-ranges, squash-mode, anon-uid and anon-gid. Defaults to access-mode,ip-ranges.")
+
+	// Feature capacity watermark specific parameters, only take effect when the CapacityWatermark feature gate is enabled.
+	capacityWatermarkPeriod  = flag.Duration("capacity-watermark-period", time.Minute, "Duration between two consecutive samples of the used capacity of the staged volumes by the node driver. Defaults to 1 minute.")
+	capacityWatermarkPercent = flag.Float64("capacity-watermark-percent", 90, "Percentage of the capacity of a staged volume used above which a warning event is published on its PVC by the node driver. Defaults to 90.")
+
+	// Feature auto expansion specific parameters, only take effect when the AutoExpansion feature gate is enabled.
+	autoExpansionPollPeriod = flag.Duration("auto-expansion-poll-period", time.Minute, "Duration between two consecutive checks of the used capacity of the PVCs annotated with filestore.csi/autoexpand. Defaults to 1 minute.")
+	autoExpansionThreshold  = flag.Float64("auto-expansion-threshold", 80, "Default percentage of its capacity used above which a PVC annotated with filestore.csi/autoexpand is expanded, overridden by its filestore.csi/autoexpand-threshold annotation. Defaults to 80.")
+
+	// Feature delete retry queue specific parameters, only take effect when the DeleteRetryQueue feature gate is enabled.
+	deleteRetryBaseDelay      = flag.Duration("delete-retry-base-delay", 10*time.Second, "Delay before the first background retry of a failed volume deletion, doubled on each failure. Defaults to 10 seconds.")
+	deleteRetryMaxDelay       = flag.Duration("delete-retry-max-delay", 30*time.Minute, "Maximum delay between two background retries of a failed volume deletion. Defaults to 30 minutes.")
+	deleteRetryStuckThreshold = flag.Duration("delete-retry-stuck-threshold", 6*time.Hour, "Duration after which a volume deletion still failing is reported as stuck. Defaults to 6 hours.")
+
+	// Feature share migration specific parameters, only take effect when the ShareMigration feature gate is enabled.
+	shareMigrationPollPeriod = flag.Duration("share-migration-poll-period", time.Minute, "Duration between two consecutive checks of the PVCs annotated to move their multishare share to another instance. Defaults to 1 minute.")
+
+	// Feature volume restore specific parameters, only take effect when the VolumeRestore feature gate is enabled.
+	volumeRestorePollPeriod = flag.Duration("volume-restore-poll-period", time.Minute, "Duration between two consecutive checks of the PVCs annotated to restore a backup in place to their volume. Defaults to 1 minute.")
+
+	// Feature replica promotion specific parameters, only take effect when the ReplicaPromotion feature gate is enabled.
+	replicaPromotionPollPeriod = flag.Duration("replica-promotion-poll-period", time.Minute, "Duration between two consecutive checks of the PVCs annotated to fail their volume over to a replica of its instance. Defaults to 1 minute.")
+
+	// Feature backup policy specific parameters, only take effect when the BackupPolicy feature gate is enabled.
+	backupPolicyPollPeriod = flag.Duration("backup-policy-poll-period", time.Minute, "Duration between two consecutive checks of the BackupPolicy resources for due backups. Defaults to 1 minute.")
+
+	// Feature restore progress specific parameters, only take effect when the RestoreProgress feature gate is enabled.
+	restoreProgressPeriod = flag.Duration("restore-progress-period", 5*time.Minute, "Duration between two consecutive progress events of a restore of a backup. Defaults to 5 minutes.")
+
+	// Feature multishare rebalancer specific parameters, only take effect when the MultishareRebalancer feature gate is enabled.
+	rebalancerPeriod               = flag.Duration("rebalancer-period", time.Hour, "Duration between two consecutive consolidation plannings of the multishare instances. Defaults to 1 hour.")
+	rebalancerUtilizationThreshold = flag.Float64("rebalancer-utilization-threshold", 0.3, "Fraction of its capacity used by the shares of a multishare instance below which the rebalancer plans to move the shares off the instance. Defaults to 0.3.")
+	rebalancerAutoApprove          = flag.Bool("rebalancer-auto-approve", false, "If set to true, the consolidation plans are executed as soon as they are proposed, without waiting for their approval.")
+	rebalancerNamespace            = flag.String("rebalancer-namespace", "gcp-filestore-csi-driver", "The namespace of the ConsolidationPlan resources published by the rebalancer.")
+
+	// Feature instance label reconciler specific parameters, only take effect when the InstanceLabelReconciler feature gate is enabled.
+	instanceLabelReconcilePeriod = flag.Duration("instance-label-reconcile-period", 30*time.Minute, "Duration between two consecutive reconciliations of the labels of the multishare instances. Defaults to 30 minutes.")
+
 	// Feature configurable shares per Filestore instance specific parameters.
-	featureMaxSharePerInstance = flag.Bool("feature-max-shares-per-instance", false, "If this feature flag is enabled, allows the user to configure max shares packed per Filestore instance")
+	featureMaxSharePerInstance = flag.Bool("feature-max-shares-per-instance", false, "If this feature flag is enabled, allows the user to configure max shares packed per Filestore instance. Deprecated, use --feature-gates=MaxSharesPerInstance=true instead.")
 	descOverrideMaxShareCount  = flag.String("desc-override-max-shares-per-instance", "", "If non-empty, the filestore instance description override is used to configure max share count per instance. This flag is ignored if 'feature-max-shares-per-instance' flag is false. Both 'desc-override-max-shares-per-instance' and 'desc-override-min-shares-size-gb' must be provided. 'ecfsDescription' is ignored, if this flag is provided.")
 	descOverrideMinShareSizeGB = flag.String("desc-override-min-shares-size-gb", "", "If non-empty, the filestore instance description override is used to configure min share size. This flag is ignored if 'feature-max-shares-per-instance' flag is false. Both 'desc-override-max-shares-per-instance' and 'desc-override-min-shares-size-gb' must be provided. 'ecfsDescription' is ignored, if this flag is provided.")
 	coreInformerResyncPeriod   = flag.Duration("core-informer-resync-repriod", 15*time.Minute, "Core informer resync period.")
 
 	// Feature multishare backups enabled
-	featureMultishareBackups        = flag.Bool("feature-multishare-backups", false, "if set to true, the multishare backups will be enabled. enable-multishare must be set to true as well")
-	featureNFSExportOptionsOnCreate = flag.Bool("feature-nfs-export-options", false, "if set to true, the driver will accpet nfs-export-options-on-create parameter and configure IP Access rules")
+	featureMultishareBackups        = flag.Bool("feature-multishare-backups", false, "if set to true, the multishare backups will be enabled. enable-multishare must be set to true as well. Deprecated, use --feature-gates=MultishareBackups=true instead.")
+	featureNFSExportOptionsOnCreate = flag.Bool("feature-nfs-export-options", false, "if set to true, the driver will accpet nfs-export-options-on-create parameter and configure IP Access rules. Deprecated, use --feature-gates=NFSExportOptionsOnCreate=true instead.")
 
 	// Feature stateful CSI driver specific parameters
-	featureStateful      = flag.Bool("feature-stateful-multishare", false, "if set to true, the controller will run stateful multishare controller, if set to true, enable-multishare must be set to true as well")
+	featureStateful      = flag.Bool("feature-stateful-multishare", false, "if set to true, the controller will run stateful multishare controller, if set to true, enable-multishare must be set to true as well. Deprecated, use --feature-gates=StatefulMultishare=true instead.")
 	statefulResyncPeriod = flag.Duration("stateful-resync-period", 15*time.Minute, "Resync interval of the stateful driver.")
 	kubeAPIQPS           = flag.Float64("kube-api-qps", 5, "QPS to use while communicating with the kubernetes apiserver. Defaults to 5.0.")
 	kubeAPIBurst         = flag.Int("kube-api-burst", 10, "Burst to use while communicating with the kubernetes apiserver. Defaults to 10.")
 	kubeconfig           = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Required only when running out of cluster.")
 
-	leaderElection              = flag.Bool("leader-election", false, "Enables leader election for stateful driver.")
+	leaderElection              = flag.Bool("leader-election", false, "Enables leader election for stateful driver and for the controller loops updating the Filestore resources, the PVs and the PVCs.")
 	leaderElectionNamespace     = flag.String("leader-election-namespace", "", "The namespace where the leader election resource exists. Defaults to the pod namespace if not set.")
 	leaderElectionLeaseDuration = flag.Duration("leader-election-lease-duration", 15*time.Second, "Duration, in seconds, that non-leader candidates will wait to force acquire leadership. Defaults to 15 seconds.")
 	leaderElectionRenewDeadline = flag.Duration("leader-election-renew-deadline", 10*time.Second, "Duration, in seconds, that the acting leader will retry refreshing leadership before giving up. Defaults to 10 seconds.")
 	leaderElectionRetryPeriod   = flag.Duration("leader-election-retry-period", 5*time.Second, "Duration, in seconds, the LeaderElector clients should wait between tries of actions. Defaults to 5 seconds.")
 
+	// featureGates is the --feature-gates flag, registered in main.
+	featureGates = features.NewFlag(features.FeatureGate)
+
 	// This is set at compile time
 	version = "unknown"
+	// This is set at compile time, or read from the VCS information of the binary
+	commit = ""
 )
 
-const driverName = "filestore.csi.storage.gke.io"
+const (
+	driverName = "filestore.csi.storage.gke.io"
+
+	// Environment variables the cluster identity defaults to, see --cluster-name and --cluster-location.
+	clusterNameEnv     = "CLUSTER_NAME"
+	clusterLocationEnv = "CLUSTER_LOCATION"
+	clusterUIDEnv      = "CLUSTER_UID"
+)
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Set("logtostderr", "true")
+	flag.Var(featureGates, "feature-gates", "A set of key=value pairs that describe feature gates for alpha/experimental features. Options are:\n"+strings.Join(features.FeatureGate.KnownFeatures(), "\n"))
 	flag.Parse()
 
+	if *configFile != "" {
+		if err := util.ApplyConfigFile(flag.CommandLine, *configFile); err != nil {
+			klog.Fatalf("Bad config file: %v", err)
+		}
+	}
+	if err := featureGates.ApplyDeprecatedFlags(flag.CommandLine, features.DeprecatedFlags); err != nil {
+		klog.Fatalf("Bad feature gates: %v", err)
+	}
+	// The feature flags are resolved from the feature gates from here on.
+	*enableMultishare = features.FeatureGate.Enabled(features.Multishare)
+	*featureMultishareBackups = features.FeatureGate.Enabled(features.MultishareBackups)
+	*featureMaxSharePerInstance = features.FeatureGate.Enabled(features.MaxSharesPerInstance)
+	*featureStateful = features.FeatureGate.Enabled(features.StatefulMultishare)
+	*featureLockRelease = features.FeatureGate.Enabled(features.LockRelease)
+	*featureNFSExportOptionsOnCreate = features.FeatureGate.Enabled(features.NFSExportOptionsOnCreate)
+	*featureMountHealth = features.FeatureGate.Enabled(features.MountHealthReporter)
+	features.LogFeatureGates(features.FeatureGate)
+
+	// debugMux serves the debug endpoints, the support bundles of the controller are added once
+	// the driver is initialized.
+	var debugMux *http.ServeMux
+	var bundleLogs *supportbundle.LogRing
+	if *debugEndpoint != "" {
+		if *runController {
+			bundleLogs = supportbundle.CaptureKlog(*supportBundleLogLines)
+		}
+		debugMux = http.NewServeMux()
+		debugMux.Handle("/featurez", features.Handler(features.FeatureGate))
+		go func() {
+			klog.Infof("Debug server listening at %q", *debugEndpoint)
+			if err := http.ListenAndServe(*debugEndpoint, debugMux); err != nil {
+				klog.Fatalf("Failed to start debug server at specified address (%q): %v", *debugEndpoint, err)
+			}
+		}()
+	}
+
 	var provider *cloud.Cloud
 	var err error
 	ctx, cancel := context.WithCancel(context.Background())
@@ -96,27 +269,92 @@ func main() {
 	var meta metadata.Service
 	var mm *metrics.MetricsManager
 	var extraVolumeLabels map[string]string
+	var volumeLocationAliases map[string]string
+	var nfsFirewallCIDRs []string
+	var parameterDefaults *driver.ParameterDefaults
+	var opTimeouts *driver.OpTimeouts
+	var tierPolicy *driver.TierPolicy
+	var tierPricing map[string]float64
+	var allowedShareExportAnnotations []string
 	var tagMgr cloud.TagService
 	if *runController {
 		if *httpEndpoint != "" && metrics.IsGKEComponentVersionAvailable() {
 			mm = metrics.NewMetricsManager()
 			mm.RegisterOperationSecondsMetric()
+			mm.RegisterExcludedInstanceMetric()
+			mm.RegisterRunningOpsMetrics()
+			mm.RegisterDeleteBatchingMetrics()
+			mm.RegisterInstancePoolMetrics()
+			mm.RegisterRegionalCapacityMetrics()
+			mm.RegisterNetworkExhaustionMetrics()
+			mm.RegisterLockContentionMetric()
+			mm.RegisterRPCDurationMetric()
+			if *enableProfiling {
+				mm.EnableProfiling()
+			}
 			mm.InitializeHttpHandler(*httpEndpoint, *metricsPath)
 			mm.EmitGKEComponentVersion()
 		}
 
+		*clusterName = resolveClusterIdentity(*clusterName, *gkeClusterName, clusterNameEnv)
+		*clusterLocation = resolveClusterIdentity(*clusterLocation, "", clusterLocationEnv)
+		*clusterUID = resolveClusterIdentity(*clusterUID, "", clusterUIDEnv)
 		if *enableMultishare {
-			if *gkeClusterName == "" {
-				klog.Fatalf("gke-cluster-name has to be set when multishare feature is enabled")
+			if *clusterName == "" {
+				klog.Fatalf("cluster-name or gke-cluster-name has to be set when multishare feature is enabled")
 			}
 		}
+		if *sharedClusterGroup != "" && *featureStateful {
+			klog.Fatalf("shared-cluster-group is not supported with the stateful multishare controller")
+		}
 
 		extraVolumeLabels, err = util.ConvertLabelsStringToMap(*extraVolumeLabelsStr)
 		if err != nil {
 			klog.Fatalf("Bad extra volume labels: %v", err.Error())
 		}
+		volumeLocationAliases, err = driver.ParseVolumeLocationAliases(*volumeLocationAliasesStr)
+		if err != nil {
+			klog.Fatalf("Bad volume location aliases: %v", err)
+		}
+		nfsFirewallCIDRs, err = driver.ParseNFSFirewallNodeCIDRs(*nfsFirewallNodeCIDRs)
+		if err != nil {
+			klog.Fatalf("Bad NFS firewall node CIDRs: %v", err)
+		}
+		parameterDefaults, err = driver.NewParameterDefaults(*defaultTier, *defaultNetwork, *defaultConnectMode)
+		if err != nil {
+			klog.Fatalf("Bad parameter defaults: %v", err)
+		}
+		opTimeouts, err = driver.NewOpTimeouts(*instanceCreateTimeout, *shareCreateTimeout, *expandTimeout)
+		if err != nil {
+			klog.Fatalf("Bad operation timeouts: %v", err)
+		}
+		tierPolicy, err = driver.NewTierPolicy(*allowedTiers, *deniedTiers)
+		if err != nil {
+			klog.Fatalf("Bad tier policy: %v", err)
+		}
+		tierPricing, err = driver.ParseTierPricing(*costEstimationPricing)
+		if err != nil {
+			klog.Fatalf("Bad cost estimation pricing: %v", err)
+		}
+		allowedShareExportAnnotations, err = driver.ParseShareExportAnnotations(*shareExportAnnotations)
+		if err != nil {
+			klog.Fatalf("Bad share export annotations: %v", err)
+		}
+		if features.FeatureGate.Enabled(features.AutoExpansion) && (*autoExpansionThreshold <= 0 || *autoExpansionThreshold > 100) {
+			klog.Fatalf("Bad --auto-expansion-threshold %v: must be above 0 and at most 100", *autoExpansionThreshold)
+		}
 
-		provider, err = cloud.NewCloud(ctx, version, *cloudConfigFilePath, *primaryFilestoreServiceEndpoint, *testFilestoreServiceEndpoint)
+		provider, err = cloud.NewCloud(ctx, version, *cloudConfigFilePath, *primaryFilestoreServiceEndpoint, *testFilestoreServiceEndpoint, file.OpPollConfig{
+			Interval:      *opPollInterval,
+			SlowInterval:  *opPollSlowInterval,
+			SlowdownAfter: *opPollSlowdownAfter,
+		})
+		if err != nil {
+			klog.Fatalf("Failed to initialize cloud provider: %v", err)
+		}
+		if err := provider.SetLabelNamespace(*labelPrefix, *driverInstanceID); err != nil {
+			klog.Fatalf("Bad label namespace: %v", err)
+		}
 
 		tagMgr = cloud.NewTagManager(provider)
 		tags, err := tagMgr.ValidateResourceTags(ctx, "command line", *resourceTagsStr)
@@ -134,6 +372,22 @@ func main() {
 		if len(*resourceTagsStr) > 0 {
 			klog.Fatalf("Resource tags provided but not running controller")
 		}
+		if len(*volumeLocationAliasesStr) > 0 {
+			klog.Fatalf("Volume location aliases provided but not running controller")
+		}
+
+		if features.FeatureGate.Enabled(features.CapacityWatermark) && (*capacityWatermarkPercent <= 0 || *capacityWatermarkPercent > 100) {
+			klog.Fatalf("Bad --capacity-watermark-percent %v: must be above 0 and at most 100", *capacityWatermarkPercent)
+		}
+		if *httpEndpoint != "" && (*featureMountHealth || features.FeatureGate.Enabled(features.TierRecommendations) || features.FeatureGate.Enabled(features.NFSStats) || features.FeatureGate.Enabled(features.CapacityWatermark)) {
+			// The metrics manager is shared with the lock release controller so both features can serve on the same endpoint.
+			mm = metrics.NewMetricsManager()
+			mm.RegisterRPCDurationMetric()
+			if *enableProfiling {
+				mm.EnableProfiling()
+			}
+			mm.InitializeHttpHandler(*httpEndpoint, *metricsPath)
+		}
 
 		meta, err = metadataservice.NewMetadataService()
 		if err != nil {
@@ -146,20 +400,8 @@ func main() {
 		klog.Fatalf("Failed to initialize cloud provider: %v", err)
 	}
 
-	var kubeClient *kubernetes.Clientset
-	if *featureMaxSharePerInstance && *runController && *enableMultishare {
-		clusterConfig, err := util.BuildConfig(*kubeconfig)
-		if err != nil {
-			klog.Error(err.Error())
-			os.Exit(1)
-		}
-		klog.Infof("cluster config created")
-
-		kubeClient, err = kubernetes.NewForConfig(clusterConfig)
-		if err != nil {
-			klog.Error(err.Error())
-			os.Exit(1)
-		}
+	if *runController && *enableMultishare && *multishareListCacheTTL > 0 {
+		provider.File = file.NewCachingService(provider.File, *multishareListCacheTTL)
 	}
 
 	featureOptions := &driver.GCFSDriverFeatureOptions{
@@ -172,13 +414,13 @@ func main() {
 				SyncPeriod:     *lockReleaseSyncPeriod,
 				MetricEndpoint: *httpEndpoint,
 				MetricPath:     *metricsPath,
+				MetricsManager: mm,
 			},
 		},
 		FeatureMaxSharesPerInstance: &driver.FeatureMaxSharesPerInstance{
 			Enabled:                          *featureMaxSharePerInstance,
 			DescOverrideMaxSharesPerInstance: *descOverrideMaxShareCount,
 			DescOverrideMinShareSizeGB:       *descOverrideMinShareSizeGB,
-			KubeClient:                       kubeClient,
 			CoreInformerResync:               *coreInformerResyncPeriod,
 		},
 		FeatureStateful: &driver.FeatureStateful{
@@ -199,33 +441,270 @@ func main() {
 		FeatureNFSExportOptionsOnCreate: &driver.FeatureNFSExportOptionsOnCreate{
 			Enabled: *featureNFSExportOptionsOnCreate,
 		},
+		FeatureInstanceEvents: &driver.FeatureInstanceEvents{
+			Enabled:    features.FeatureGate.Enabled(features.InstanceEvents) && *runController,
+			PollPeriod: *instanceEventsPollPeriod,
+		},
+		FeatureTierRecommendations: &driver.FeatureTierRecommendations{
+			Enabled:        features.FeatureGate.Enabled(features.TierRecommendations),
+			AnalysisPeriod: *tierAnalysisPeriod,
+			AnalysisWindow: *tierAnalysisWindow,
+		},
+		FeatureDeleteRetryQueue: &driver.FeatureDeleteRetryQueue{
+			Enabled:        features.FeatureGate.Enabled(features.DeleteRetryQueue) && *runController,
+			BaseDelay:      *deleteRetryBaseDelay,
+			MaxDelay:       *deleteRetryMaxDelay,
+			StuckThreshold: *deleteRetryStuckThreshold,
+		},
+		FeatureShareMigration: &driver.FeatureShareMigration{
+			Enabled:    features.FeatureGate.Enabled(features.ShareMigration) && *runController,
+			PollPeriod: *shareMigrationPollPeriod,
+		},
+		FeatureRebalancer: &driver.FeatureRebalancer{
+			Enabled:              features.FeatureGate.Enabled(features.MultishareRebalancer) && *runController,
+			Period:               *rebalancerPeriod,
+			UtilizationThreshold: *rebalancerUtilizationThreshold,
+			AutoApprove:          *rebalancerAutoApprove,
+			Namespace:            *rebalancerNamespace,
+		},
+		FeatureVolumeRestore: &driver.FeatureVolumeRestore{
+			Enabled:    features.FeatureGate.Enabled(features.VolumeRestore) && *runController,
+			PollPeriod: *volumeRestorePollPeriod,
+		},
+		FeatureMultishareDeleteBatching: &driver.FeatureMultishareDeleteBatching{
+			Enabled: features.FeatureGate.Enabled(features.MultishareDeleteBatching) && *runController,
+		},
+		FeatureInstanceLabelReconciler: &driver.FeatureInstanceLabelReconciler{
+			Enabled: features.FeatureGate.Enabled(features.InstanceLabelReconciler) && *runController,
+			Period:  *instanceLabelReconcilePeriod,
+		},
+		FeatureReplicaPromotion: &driver.FeatureReplicaPromotion{
+			Enabled:    features.FeatureGate.Enabled(features.ReplicaPromotion) && *runController,
+			PollPeriod: *replicaPromotionPollPeriod,
+		},
+		FeatureBackupPolicy: &driver.FeatureBackupPolicy{
+			Enabled:    features.FeatureGate.Enabled(features.BackupPolicy) && *runController,
+			PollPeriod: *backupPolicyPollPeriod,
+		},
+		FeatureRestoreProgress: &driver.FeatureRestoreProgress{
+			Enabled: features.FeatureGate.Enabled(features.RestoreProgress) && *runController,
+			Period:  *restoreProgressPeriod,
+		},
+		FeatureNFSStats: &driver.FeatureNFSStats{
+			Enabled:          features.FeatureGate.Enabled(features.NFSStats) && *runNode,
+			CollectionPeriod: *nfsStatsPeriod,
+		},
+		FeatureInstanceIPRefresh: &driver.FeatureInstanceIPRefresh{
+			Enabled:   features.FeatureGate.Enabled(features.InstanceIPRefresh),
+			Period:    *instanceIPRefreshPeriod,
+			CacheTTL:  *instanceIPCacheTTL,
+			Namespace: *instanceIPRefreshNamespace,
+		},
+		FeatureDeferredInstanceCreation: &driver.FeatureDeferredInstanceCreation{
+			Enabled: features.FeatureGate.Enabled(features.DeferredInstanceCreation) && *runController,
+			Window:  *instanceCreationDeferralWindow,
+		},
+		FeatureCostEstimation: &driver.FeatureCostEstimation{
+			Enabled:        features.FeatureGate.Enabled(features.CostEstimation) && *runController,
+			Pricing:        tierPricing,
+			AlertThreshold: *costEstimationAlertThreshold,
+		},
+		FeatureShareExportAnnotations: &driver.FeatureShareExportAnnotations{
+			Enabled: features.FeatureGate.Enabled(features.ShareExportAnnotations) && *runController,
+			Allowed: allowedShareExportAnnotations,
+		},
+		FeatureAutoExpansion: &driver.FeatureAutoExpansion{
+			Enabled:    features.FeatureGate.Enabled(features.AutoExpansion) && *runController,
+			PollPeriod: *autoExpansionPollPeriod,
+			Threshold:  *autoExpansionThreshold,
+		},
+		FeatureCapacityWatermark: &driver.FeatureCapacityWatermark{
+			Enabled: features.FeatureGate.Enabled(features.CapacityWatermark) && *runNode,
+			Period:  *capacityWatermarkPeriod,
+			Percent: *capacityWatermarkPercent,
+		},
+		FeatureMountHealth: &driver.FeatureMountHealth{
+			Enabled:      *featureMountHealth && *runNode,
+			ProbePeriod:  *mountHealthProbePeriod,
+			ProbeTimeout: *mountHealthProbeTimeout,
+		},
+	}
+
+	// The kubernetes client is shared by the features of the driver.
+	var kubeClient *kubernetes.Clientset
+	var driverClient *clientset.Clientset
+	var eventRecorder record.EventRecorder
+	if (*featureMaxSharePerInstance && *runController && *enableMultishare) || featureOptions.NeedsKubeClient() {
+		kubeClient, driverClient, eventRecorder, err = newKubeClients()
+		if err != nil {
+			klog.Fatalf("Failed to create kubernetes client: %v", err)
+		}
+		featureOptions.FeatureMaxSharesPerInstance.KubeClient = kubeClient
+	}
+
+	var instancePools *driver.InstancePoolsConfig
+	if *instancePoolConfig != "" {
+		instancePools, err = driver.LoadInstancePoolsConfig(*instancePoolConfig)
+		if err != nil {
+			klog.Fatalf("Bad instance pool config: %v", err)
+		}
 	}
 
 	mounter := mount.New("")
+	if *runNode {
+		mounter, err = driver.NewNodeMounter(*nfsMountHelper, *hostRoot)
+		if err != nil {
+			klog.Fatalf("Bad NFS mount helper: %v", err)
+		}
+	}
+	if *enableProfiling && mm == nil {
+		klog.Warningf("--enable-profiling is set but the metrics endpoint is not served, the profiles are not served")
+	}
+
 	config := &driver.GCFSDriverConfig{
-		Name:              driverName,
-		Version:           version,
-		NodeName:          *nodeID,
-		RunController:     *runController,
-		RunNode:           *runNode,
-		Mounter:           mounter,
-		Cloud:             provider,
-		MetadataService:   meta,
-		EnableMultishare:  *enableMultishare,
-		Metrics:           mm,
-		EcfsDescription:   *ecfsDescription,
-		IsRegional:        *isRegional,
-		ClusterName:       *gkeClusterName,
-		FeatureOptions:    featureOptions,
-		ExtraVolumeLabels: extraVolumeLabels,
-		TagManager:        tagMgr,
+		Name:                      driverName,
+		Version:                   version,
+		Commit:                    buildCommit(),
+		FeatureGates:              features.Enabled(features.FeatureGate),
+		NodeName:                  *nodeID,
+		RunController:             *runController,
+		RunNode:                   *runNode,
+		Mounter:                   mounter,
+		Cloud:                     provider,
+		MetadataService:           meta,
+		EnableMultishare:          *enableMultishare,
+		ListParallelism:           *multishareListParallelism,
+		StuckOpThreshold:          *multishareStuckOpThreshold,
+		MaxRegionalCapacityTB:     *maxTotalProvisionedTBPerRegion,
+		NFSFirewallRules:          *createNFSFirewallRules,
+		NFSFirewallNodeCIDRs:      nfsFirewallCIDRs,
+		ParameterDefaults:         parameterDefaults,
+		OpTimeouts:                opTimeouts,
+		TierPolicy:                tierPolicy,
+		InstancePools:             instancePools,
+		Metrics:                   mm,
+		EcfsDescription:           *ecfsDescription,
+		IsRegional:                *isRegional,
+		ClusterName:               *clusterName,
+		ClusterLocation:           *clusterLocation,
+		ClusterUID:                *clusterUID,
+		SharedClusterGroup:        *sharedClusterGroup,
+		FeatureOptions:            featureOptions,
+		ExtraVolumeLabels:         extraVolumeLabels,
+		ClearDeletionProtection:   *clearDeletionProtection,
+		VolumeLocationAliases:     volumeLocationAliases,
+		AllowSoftMounts:           *allowSoftMounts,
+		KerberosKeytabPath:        *kerberosKeytabPath,
+		MaxConcurrentMounts:       *maxConcurrentMounts,
+		MountQueueTimeout:         *mountQueueTimeout,
+		VerifyNodeExpansion:       *verifyNodeExpansion,
+		BackupBeforeExpandTimeout: *backupBeforeExpandTimeout,
+		ConnectivityCheckTimeout:  *connectivityCheckTimeout,
+		TagManager:                tagMgr,
+		ServerOptions: &driver.ServerOptions{
+			MaxConcurrentRPCs: *maxConcurrentRPCs,
+			RPCTimeout:        *rpcTimeout,
+			DrainTimeout:      *grpcDrainTimeout,
+			SocketGroup:       *endpointSocketGroup,
+			Metrics:           mm,
+		},
+	}
+
+	if kubeClient != nil {
+		config.KubeClient = kubeClient
+		config.DriverClient = driverClient
+		config.EventRecorder = eventRecorder
+	}
+
+	if *auditLogPath != "" {
+		auditLog, err := driver.OpenAuditLog(*auditLogPath)
+		if err != nil {
+			klog.Fatalf("Failed to open audit log %q: %v", *auditLogPath, err)
+		}
+		config.ServerOptions.AuditLog = auditLog
+	}
+
+	if *endpointSocketMode != "" {
+		mode, err := strconv.ParseUint(*endpointSocketMode, 8, 32)
+		if err != nil {
+			klog.Fatalf("Bad endpoint socket mode %q: %v", *endpointSocketMode, err)
+		}
+		config.ServerOptions.SocketMode = os.FileMode(mode)
 	}
 
 	gcfsDriver, err := driver.NewGCFSDriver(config)
 	if err != nil {
 		klog.Fatalf("Failed to initialize Cloud Filestore CSI Driver: %v", err)
 	}
+	if debugMux != nil && *runController {
+		collectors := gcfsDriver.SupportBundleCollectors()
+		if collectors == nil {
+			collectors = make(map[string]supportbundle.Collector)
+		}
+		collectors["featuregates.txt"] = supportbundle.Lines(func() []string { return features.Describe(features.FeatureGate) })
+		collectors["flags.txt"] = supportbundle.Flags(flag.CommandLine)
+		collectors["version.txt"] = supportbundle.Lines(func() []string { return []string{version} })
+		if bundleLogs != nil {
+			collectors["logs.txt"] = supportbundle.Lines(bundleLogs.Lines)
+		}
+		debugMux.Handle(supportbundle.Path, supportbundle.Handler(collectors))
+	}
 	klog.Infof("Running Google Cloud Filestore CSI driver version %v", version)
 	gcfsDriver.Run(*endpoint)
 	os.Exit(0)
 }
+
+// newKubeClients returns the clients and event recorder shared by the features, rate limited by --kube-api-qps and --kube-api-burst.
+func newKubeClients() (*kubernetes.Clientset, *clientset.Clientset, record.EventRecorder, error) {
+	clusterConfig, err := util.BuildConfig(*kubeconfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clusterConfig.QPS = float32(*kubeAPIQPS)
+	clusterConfig.Burst = *kubeAPIBurst
+	kubeClient, err := kubernetes.NewForConfig(clusterConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	driverClient, err := clientset.NewForConfig(clusterConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	source := v1.EventSource{Component: driverName}
+	if *runNode {
+		source.Host = *nodeID
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return kubeClient, driverClient, broadcaster.NewRecorder(scheme.Scheme, source), nil
+}
+
+// resolveClusterIdentity returns the first non-empty of the flag value, the fallback flag
+// value, e.g. of --gke-cluster-name, and the value of the environment variable env.
+func resolveClusterIdentity(value, fallback, env string) string {
+	if value != "" {
+		return value
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return os.Getenv(env)
+}
+
+// buildCommit returns the commit set at compile time, or else the VCS revision recorded in the
+// binary by the go tool.
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
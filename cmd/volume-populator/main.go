@@ -0,0 +1,101 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/leaderelection"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/populator"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+var (
+	kubeconfig    = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Required only when running out of cluster.")
+	driverName    = flag.String("driver-name", "filestore.csi.storage.gke.io", "Name of the Filestore CSI driver, the provisioner of the storage classes of the PVCs populated.")
+	namespace     = flag.String("namespace", "", "Deprecated and ignored, the prime PVCs and the transfer jobs are created in the namespace of the PVCs they populate.")
+	transferImage = flag.String("transfer-image", "gcr.io/google.com/cloudsdktool/google-cloud-cli:slim", "Image of the transfer pods copying the objects of the buckets to the volumes, which must provide gsutil.")
+	syncPeriod    = flag.Duration("sync-period", 30*time.Second, "Duration between two consecutive checks of the PVCs to populate. Defaults to 30 seconds.")
+	kubeAPIQPS    = flag.Float64("kube-api-qps", 5, "QPS to use while communicating with the kubernetes apiserver. Defaults to 5.0.")
+	kubeAPIBurst  = flag.Int("kube-api-burst", 10, "Burst to use while communicating with the kubernetes apiserver. Defaults to 10.")
+
+	leaderElection              = flag.Bool("leader-election", false, "Enables leader election, to run several replicas of the populator.")
+	leaderElectionNamespace     = flag.String("leader-election-namespace", "", "The namespace where the leader election resource exists. Defaults to the pod namespace if not set.")
+	leaderElectionLeaseDuration = flag.Duration("leader-election-lease-duration", 15*time.Second, "Duration, in seconds, that non-leader candidates will wait to force acquire leadership. Defaults to 15 seconds.")
+	leaderElectionRenewDeadline = flag.Duration("leader-election-renew-deadline", 10*time.Second, "Duration, in seconds, that the acting leader will retry refreshing leadership before giving up. Defaults to 10 seconds.")
+	leaderElectionRetryPeriod   = flag.Duration("leader-election-retry-period", 5*time.Second, "Duration, in seconds, the LeaderElector clients should wait between tries of actions. Defaults to 5 seconds.")
+
+	// This is set at compile time
+	version = "unknown"
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+	klog.Infof("Starting Filestore CSI volume populator version %s", version)
+	if *namespace != "" {
+		klog.Warningf("--namespace is deprecated and ignored, the prime PVCs and the transfer jobs are created in the namespace of the PVCs")
+	}
+
+	config, err := util.BuildConfig(*kubeconfig)
+	if err != nil {
+		klog.Fatalf("Failed to build the kubernetes client config: %v", err)
+	}
+	config.QPS = float32(*kubeAPIQPS)
+	config.Burst = *kubeAPIBurst
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed to create the kubernetes client: %v", err)
+	}
+	driverClient, err := clientset.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed to create the driver client: %v", err)
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "filestore-volume-populator"})
+
+	p := populator.NewGcsPopulator(kubeClient, driverClient, recorder, &populator.GcsPopulatorConfig{
+		DriverName:    *driverName,
+		TransferImage: *transferImage,
+		SyncPeriod:    *syncPeriod,
+	})
+	if !*leaderElection {
+		p.Run(context.Background())
+		return
+	}
+	le := leaderelection.NewLeaderElection(kubeClient, "filestore-volume-populator-leader", p.Run)
+	if *leaderElectionNamespace != "" {
+		le.WithNamespace(*leaderElectionNamespace)
+	}
+	le.WithLeaseDuration(*leaderElectionLeaseDuration)
+	le.WithRenewDeadline(*leaderElectionRenewDeadline)
+	le.WithRetryPeriod(*leaderElectionRetryPeriod)
+	if err := le.Run(); err != nil {
+		klog.Fatalf("Failed to initialize leader election: %v", err)
+	}
+}
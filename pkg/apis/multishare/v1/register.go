@@ -47,6 +47,10 @@ var (
 // Adds the list of known types to Scheme.
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
+		&BackupPolicy{},
+		&BackupPolicyList{},
+		&ConsolidationPlan{},
+		&ConsolidationPlanList{},
 		&ShareInfo{},
 		&ShareInfoList{},
 		&InstanceInfo{},
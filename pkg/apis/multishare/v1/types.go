@@ -110,3 +110,118 @@ type InstanceInfoList struct {
 
 	Items []InstanceInfo `json:"items"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ConsolidationPlan is a plan, proposed by the rebalancer, to move the shares off the
+// under-utilized multishare instances of an instance pool, for operator approval.
+type ConsolidationPlan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ConsolidationPlanSpec `json:"spec"`
+	// +optional
+	Status *ConsolidationPlanStatus `json:"status"`
+}
+
+// ConsolidationPlanSpec is the spec for a ConsolidationPlan resource
+type ConsolidationPlanSpec struct {
+	InstancePoolTag string `json:"instancePoolTag"`
+	Region          string `json:"region"`
+	// Approved is set by the operator to execute the moves of the plan.
+	Approved bool `json:"approved"`
+	// DrainedInstances are the handles of the instances left without shares by the plan.
+	DrainedInstances []string    `json:"drainedInstances,omitempty"`
+	Moves            []ShareMove `json:"moves,omitempty"`
+}
+
+// ShareMove is the move of the share of a PVC to another instance.
+type ShareMove struct {
+	PVCNamespace  string `json:"pvcNamespace"`
+	PVCName       string `json:"pvcName"`
+	VolumeName    string `json:"volumeName"`
+	CapacityBytes int64  `json:"capacityBytes"`
+	// SourceInstance and TargetInstance are in the form of projects/PROJECT/locations/LOCATION/instances/INSTANCE_NAME
+	SourceInstance string `json:"sourceInstance"`
+	TargetInstance string `json:"targetInstance"`
+}
+
+// ConsolidationPlanStatus is the status for a ConsolidationPlan resource
+type ConsolidationPlanStatus struct {
+	Phase          ConsolidationPlanPhase `json:"phase,omitempty"`
+	CompletedMoves int                    `json:"completedMoves,omitempty"`
+	Error          string                 `json:"error"`
+}
+
+// ConsolidationPlanPhase identifies the progress of a plan.
+type ConsolidationPlanPhase string
+
+// These are valid phases of a ConsolidationPlan.
+const (
+	PROPOSED  ConsolidationPlanPhase = "proposed"
+	EXECUTING ConsolidationPlanPhase = "executing"
+	COMPLETED ConsolidationPlanPhase = "completed"
+	FAILED    ConsolidationPlanPhase = "failed"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ConsolidationPlanList is a list of ConsolidationPlan resources
+type ConsolidationPlanList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ConsolidationPlan `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupPolicy schedules the Filestore backups of the PVCs of its namespace matching its
+// selector, and deletes the oldest backups beyond its retention count.
+type BackupPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BackupPolicySpec `json:"spec"`
+	// +optional
+	Status *BackupPolicyStatus `json:"status"`
+}
+
+// BackupPolicySpec is the spec for a BackupPolicy resource
+type BackupPolicySpec struct {
+	// Selector selects the PVCs of the namespace backed up by the policy. An empty selector
+	// selects all the PVCs of the namespace.
+	Selector *metav1.LabelSelector `json:"selector"`
+	// Schedule is the interval between two consecutive backups of each PVC, e.g. 24h.
+	Schedule metav1.Duration `json:"schedule"`
+	// RetentionCount is the number of backups kept for each PVC. Zero keeps all the backups.
+	RetentionCount int `json:"retentionCount,omitempty"`
+	// Region is the region of the backups, the region of the volumes if empty.
+	Region string `json:"region,omitempty"`
+}
+
+// BackupPolicyStatus is the status for a BackupPolicy resource
+type BackupPolicyStatus struct {
+	Volumes []VolumeBackups `json:"volumes,omitempty"`
+	Error   string          `json:"error"`
+}
+
+// VolumeBackups are the backups of a PVC taken by a BackupPolicy.
+type VolumeBackups struct {
+	PVCName        string      `json:"pvcName"`
+	LastBackupTime metav1.Time `json:"lastBackupTime,omitempty"`
+	// Backups are the retained backups, oldest first, in the form of projects/PROJECT/locations/REGION/backups/NAME
+	Backups []string `json:"backups,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupPolicyList is a list of BackupPolicy resources
+type BackupPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []BackupPolicy `json:"items"`
+}
@@ -22,9 +22,227 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupPolicy) DeepCopyInto(out *BackupPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	if in.Status != nil {
+		in, out := &in.Status, &out.Status
+		*out = new(BackupPolicyStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupPolicy.
+func (in *BackupPolicy) DeepCopy() *BackupPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *BackupPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupPolicyList) DeepCopyInto(out *BackupPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]BackupPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupPolicyList.
+func (in *BackupPolicyList) DeepCopy() *BackupPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *BackupPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupPolicySpec) DeepCopyInto(out *BackupPolicySpec) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Schedule = in.Schedule
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupPolicySpec.
+func (in *BackupPolicySpec) DeepCopy() *BackupPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupPolicyStatus) DeepCopyInto(out *BackupPolicyStatus) {
+	*out = *in
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]VolumeBackups, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupPolicyStatus.
+func (in *BackupPolicyStatus) DeepCopy() *BackupPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsolidationPlan) DeepCopyInto(out *ConsolidationPlan) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	if in.Status != nil {
+		in, out := &in.Status, &out.Status
+		*out = new(ConsolidationPlanStatus)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsolidationPlan.
+func (in *ConsolidationPlan) DeepCopy() *ConsolidationPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsolidationPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ConsolidationPlan) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsolidationPlanList) DeepCopyInto(out *ConsolidationPlanList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ConsolidationPlan, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsolidationPlanList.
+func (in *ConsolidationPlanList) DeepCopy() *ConsolidationPlanList {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsolidationPlanList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ConsolidationPlanList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsolidationPlanSpec) DeepCopyInto(out *ConsolidationPlanSpec) {
+	*out = *in
+	if in.DrainedInstances != nil {
+		in, out := &in.DrainedInstances, &out.DrainedInstances
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Moves != nil {
+		in, out := &in.Moves, &out.Moves
+		*out = make([]ShareMove, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsolidationPlanSpec.
+func (in *ConsolidationPlanSpec) DeepCopy() *ConsolidationPlanSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsolidationPlanSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsolidationPlanStatus) DeepCopyInto(out *ConsolidationPlanStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsolidationPlanStatus.
+func (in *ConsolidationPlanStatus) DeepCopy() *ConsolidationPlanStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsolidationPlanStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *InstanceInfo) DeepCopyInto(out *InstanceInfo) {
 	*out = *in
@@ -237,3 +455,41 @@ func (in *ShareInfoStatus) DeepCopy() *ShareInfoStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ShareMove) DeepCopyInto(out *ShareMove) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ShareMove.
+func (in *ShareMove) DeepCopy() *ShareMove {
+	if in == nil {
+		return nil
+	}
+	out := new(ShareMove)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeBackups) DeepCopyInto(out *VolumeBackups) {
+	*out = *in
+	in.LastBackupTime.DeepCopyInto(&out.LastBackupTime)
+	if in.Backups != nil {
+		in, out := &in.Backups, &out.Backups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeBackups.
+func (in *VolumeBackups) DeepCopy() *VolumeBackups {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeBackups)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GcsDataSource is a data source of a PVC, referenced by its spec.dataSourceRef, to
+// provision a Filestore volume seeded with the objects of a Cloud Storage bucket.
+type GcsDataSource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GcsDataSourceSpec `json:"spec"`
+}
+
+// GcsDataSourceSpec is the spec for a GcsDataSource resource
+type GcsDataSourceSpec struct {
+	// Bucket is the name of the Cloud Storage bucket, without the gs:// scheme.
+	Bucket string `json:"bucket"`
+	// Prefix restricts the copy to the objects under this path of the bucket, copied to the
+	// root of the volume. The whole bucket is copied if empty.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// ServiceAccountName is the kubernetes service account of the transfer pod, which runs in
+	// the namespace of the GcsDataSource, e.g. bound to a Google service account with read
+	// access to the bucket through workload identity. Defaults to the default service account
+	// of the namespace.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GcsDataSourceList is a list of GcsDataSource resources
+type GcsDataSourceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []GcsDataSource `json:"items"`
+}
@@ -26,18 +26,21 @@ import (
 	rest "k8s.io/client-go/rest"
 	flowcontrol "k8s.io/client-go/util/flowcontrol"
 	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/multishare/v1"
+	populatorv1alpha1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/populator/v1alpha1"
 )
 
 type Interface interface {
 	Discovery() discovery.DiscoveryInterface
 	MultishareV1() multisharev1.MultishareV1Interface
+	PopulatorV1alpha1() populatorv1alpha1.PopulatorV1alpha1Interface
 }
 
 // Clientset contains the clients for groups. Each group has exactly one
 // version included in a Clientset.
 type Clientset struct {
 	*discovery.DiscoveryClient
-	multishareV1 *multisharev1.MultishareV1Client
+	multishareV1      *multisharev1.MultishareV1Client
+	populatorV1alpha1 *populatorv1alpha1.PopulatorV1alpha1Client
 }
 
 // MultishareV1 retrieves the MultishareV1Client
@@ -45,6 +48,11 @@ func (c *Clientset) MultishareV1() multisharev1.MultishareV1Interface {
 	return c.multishareV1
 }
 
+// PopulatorV1alpha1 retrieves the PopulatorV1alpha1Client
+func (c *Clientset) PopulatorV1alpha1() populatorv1alpha1.PopulatorV1alpha1Interface {
+	return c.populatorV1alpha1
+}
+
 // Discovery retrieves the DiscoveryClient
 func (c *Clientset) Discovery() discovery.DiscoveryInterface {
 	if c == nil {
@@ -93,6 +101,10 @@ func NewForConfigAndClient(c *rest.Config, httpClient *http.Client) (*Clientset,
 	if err != nil {
 		return nil, err
 	}
+	cs.populatorV1alpha1, err = populatorv1alpha1.NewForConfigAndClient(&configShallowCopy, httpClient)
+	if err != nil {
+		return nil, err
+	}
 
 	cs.DiscoveryClient, err = discovery.NewDiscoveryClientForConfigAndClient(&configShallowCopy, httpClient)
 	if err != nil {
@@ -115,6 +127,7 @@ func NewForConfigOrDie(c *rest.Config) *Clientset {
 func New(c rest.Interface) *Clientset {
 	var cs Clientset
 	cs.multishareV1 = multisharev1.New(c)
+	cs.populatorV1alpha1 = populatorv1alpha1.New(c)
 
 	cs.DiscoveryClient = discovery.NewDiscoveryClient(c)
 	return &cs
@@ -27,6 +27,8 @@ import (
 	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
 	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/multishare/v1"
 	fakemultisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/multishare/v1/fake"
+	populatorv1alpha1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/populator/v1alpha1"
+	fakepopulatorv1alpha1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/populator/v1alpha1/fake"
 )
 
 // NewSimpleClientset returns a clientset that will respond with the provided objects.
@@ -83,3 +85,8 @@ var (
 func (c *Clientset) MultishareV1() multisharev1.MultishareV1Interface {
 	return &fakemultisharev1.FakeMultishareV1{Fake: &c.Fake}
 }
+
+// PopulatorV1alpha1 retrieves the PopulatorV1alpha1Client
+func (c *Clientset) PopulatorV1alpha1() populatorv1alpha1.PopulatorV1alpha1Interface {
+	return &fakepopulatorv1alpha1.FakePopulatorV1alpha1{Fake: &c.Fake}
+}
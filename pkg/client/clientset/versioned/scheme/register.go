@@ -25,6 +25,7 @@ import (
 	serializer "k8s.io/apimachinery/pkg/runtime/serializer"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	populatorv1alpha1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/populator/v1alpha1"
 )
 
 var Scheme = runtime.NewScheme()
@@ -32,6 +33,7 @@ var Codecs = serializer.NewCodecFactory(Scheme)
 var ParameterCodec = runtime.NewParameterCodec(Scheme)
 var localSchemeBuilder = runtime.SchemeBuilder{
 	multisharev1.AddToScheme,
+	populatorv1alpha1.AddToScheme,
 }
 
 // AddToScheme adds all types of this clientset into the given scheme. This allows composition
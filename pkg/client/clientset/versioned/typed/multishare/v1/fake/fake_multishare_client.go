@@ -28,6 +28,14 @@ type FakeMultishareV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeMultishareV1) BackupPolicies(namespace string) v1.BackupPolicyInterface {
+	return &FakeBackupPolicies{c, namespace}
+}
+
+func (c *FakeMultishareV1) ConsolidationPlans(namespace string) v1.ConsolidationPlanInterface {
+	return &FakeConsolidationPlans{c, namespace}
+}
+
 func (c *FakeMultishareV1) InstanceInfos(namespace string) v1.InstanceInfoInterface {
 	return &FakeInstanceInfos{c, namespace}
 }
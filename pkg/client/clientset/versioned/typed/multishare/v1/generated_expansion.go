@@ -18,6 +18,10 @@ limitations under the License.
 
 package v1
 
+type BackupPolicyExpansion interface{}
+
+type ConsolidationPlanExpansion interface{}
+
 type InstanceInfoExpansion interface{}
 
 type ShareInfoExpansion interface{}
@@ -28,6 +28,8 @@ import (
 
 type MultishareV1Interface interface {
 	RESTClient() rest.Interface
+	BackupPoliciesGetter
+	ConsolidationPlansGetter
 	InstanceInfosGetter
 	ShareInfosGetter
 }
@@ -37,6 +39,14 @@ type MultishareV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *MultishareV1Client) BackupPolicies(namespace string) BackupPolicyInterface {
+	return newBackupPolicies(c, namespace)
+}
+
+func (c *MultishareV1Client) ConsolidationPlans(namespace string) ConsolidationPlanInterface {
+	return newConsolidationPlans(c, namespace)
+}
+
 func (c *MultishareV1Client) InstanceInfos(namespace string) InstanceInfoInterface {
 	return newInstanceInfos(c, namespace)
 }
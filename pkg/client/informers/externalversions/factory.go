@@ -30,6 +30,7 @@ import (
 	versioned "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
 	internalinterfaces "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions/internalinterfaces"
 	multishare "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions/multishare"
+	populator "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions/populator"
 )
 
 // SharedInformerOption defines the functional option type for SharedInformerFactory.
@@ -173,8 +174,13 @@ type SharedInformerFactory interface {
 	WaitForCacheSync(stopCh <-chan struct{}) map[reflect.Type]bool
 
 	Multishare() multishare.Interface
+	Populator() populator.Interface
 }
 
 func (f *sharedInformerFactory) Multishare() multishare.Interface {
 	return multishare.New(f, f.namespace, f.tweakListOptions)
 }
+
+func (f *sharedInformerFactory) Populator() populator.Interface {
+	return populator.New(f, f.namespace, f.tweakListOptions)
+}
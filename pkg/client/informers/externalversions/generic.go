@@ -24,6 +24,7 @@ import (
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	cache "k8s.io/client-go/tools/cache"
 	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	v1alpha1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/populator/v1alpha1"
 )
 
 // GenericInformer is type of SharedIndexInformer which will locate and delegate to other
@@ -53,11 +54,19 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=multishare.filestore.csi.storage.gke.io, Version=v1
+	case v1.SchemeGroupVersion.WithResource("backuppolicies"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Multishare().V1().BackupPolicies().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("consolidationplans"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Multishare().V1().ConsolidationPlans().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("instanceinfos"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Multishare().V1().InstanceInfos().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("shareinfos"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Multishare().V1().ShareInfos().Informer()}, nil
 
+	// Group=populator.filestore.csi.storage.gke.io, Version=v1alpha1
+	case v1alpha1.SchemeGroupVersion.WithResource("gcsdatasources"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Populator().V1alpha1().GcsDataSources().Informer()}, nil
+
 	}
 
 	return nil, fmt.Errorf("no informer found for %v", resource)
@@ -24,6 +24,10 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// BackupPolicies returns a BackupPolicyInformer.
+	BackupPolicies() BackupPolicyInformer
+	// ConsolidationPlans returns a ConsolidationPlanInformer.
+	ConsolidationPlans() ConsolidationPlanInformer
 	// InstanceInfos returns a InstanceInfoInformer.
 	InstanceInfos() InstanceInfoInformer
 	// ShareInfos returns a ShareInfoInformer.
@@ -41,6 +45,16 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// BackupPolicies returns a BackupPolicyInformer.
+func (v *version) BackupPolicies() BackupPolicyInformer {
+	return &backupPolicyInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// ConsolidationPlans returns a ConsolidationPlanInformer.
+func (v *version) ConsolidationPlans() ConsolidationPlanInformer {
+	return &consolidationPlanInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // InstanceInfos returns a InstanceInfoInformer.
 func (v *version) InstanceInfos() InstanceInfoInformer {
 	return &instanceInfoInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
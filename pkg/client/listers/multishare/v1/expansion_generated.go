@@ -18,6 +18,22 @@ limitations under the License.
 
 package v1
 
+// BackupPolicyListerExpansion allows custom methods to be added to
+// BackupPolicyLister.
+type BackupPolicyListerExpansion interface{}
+
+// BackupPolicyNamespaceListerExpansion allows custom methods to be added to
+// BackupPolicyNamespaceLister.
+type BackupPolicyNamespaceListerExpansion interface{}
+
+// ConsolidationPlanListerExpansion allows custom methods to be added to
+// ConsolidationPlanLister.
+type ConsolidationPlanListerExpansion interface{}
+
+// ConsolidationPlanNamespaceListerExpansion allows custom methods to be added to
+// ConsolidationPlanNamespaceLister.
+type ConsolidationPlanNamespaceListerExpansion interface{}
+
 // InstanceInfoListerExpansion allows custom methods to be added to
 // InstanceInfoLister.
 type InstanceInfoListerExpansion interface{}
@@ -18,9 +18,13 @@ package cloud
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
@@ -30,14 +34,22 @@ import (
 	"gopkg.in/gcfg.v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
+	computeservice "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/compute"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
 )
 
 type Cloud struct {
 	Config  *ConfigFile
 	File    file.Service
+	Compute computeservice.Service
 	Project string
 	Zone    string
+
+	// newServices builds the services of a Cloud using other credentials, see WithCredentials.
+	newServices func(client *http.Client) (file.Service, computeservice.Service, error)
+	scopedMux   sync.Mutex
+	// scoped caches the Clouds returned by WithCredentials, by credentials and project.
+	scoped map[string]*Cloud
 }
 
 type ConfigFile struct {
@@ -51,7 +63,7 @@ type ConfigGlobal struct {
 	Zone      string `gcfg:"zone"`
 }
 
-func NewCloud(ctx context.Context, version, configPath, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint string) (*Cloud, error) {
+func NewCloud(ctx context.Context, version, configPath, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint string, pollConfig file.OpPollConfig) (*Cloud, error) {
 	configFile, err := maybeReadConfig(configPath)
 	if err != nil {
 		return nil, err
@@ -67,9 +79,9 @@ func NewCloud(ctx context.Context, version, configPath, primaryFilestoreServiceE
 		return nil, err
 	}
 
-	file, err := file.NewGCFSService(version, client, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint)
+	fileService, computeService, err := newServices(version, client, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint, pollConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Filestore service: %w", err)
+		return nil, err
 	}
 
 	project, zone, err := getProjectAndZone(configFile)
@@ -78,12 +90,111 @@ func NewCloud(ctx context.Context, version, configPath, primaryFilestoreServiceE
 	}
 	return &Cloud{
 		Config:  configFile,
-		File:    file,
+		File:    fileService,
+		Compute: computeService,
 		Project: project,
 		Zone:    zone,
+		newServices: func(client *http.Client) (file.Service, computeservice.Service, error) {
+			return newServices(version, client, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint, pollConfig)
+		},
 	}, nil
 }
 
+func newServices(version string, client *http.Client, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint string, pollConfig file.OpPollConfig) (file.Service, computeservice.Service, error) {
+	fileService, err := file.NewGCFSService(version, client, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint, pollConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize Filestore service: %w", err)
+	}
+	computeService, err := computeservice.NewComputeService(version, client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize Compute service: %w", err)
+	}
+	return fileService, computeService, nil
+}
+
+// SetLabelNamespace namespaces the labels of the Filestore resources managed by the driver, see
+// file.NewLabelNamespacedService, including the ones managed with other credentials.
+func (c *Cloud) SetLabelNamespace(prefix, instanceID string) error {
+	fileService, err := file.NewLabelNamespacedService(c.File, prefix, instanceID)
+	if err != nil {
+		return err
+	}
+	c.File = fileService
+	if newServices := c.newServices; newServices != nil {
+		c.newServices = func(client *http.Client) (file.Service, computeservice.Service, error) {
+			fileService, computeService, err := newServices(client)
+			if err != nil {
+				return nil, nil, err
+			}
+			fileService, err = file.NewLabelNamespacedService(fileService, prefix, instanceID)
+			return fileService, computeService, err
+		}
+	}
+	return nil
+}
+
+// serviceAccountKeyType is the type of the service account key credentials.
+const serviceAccountKeyType = "service_account"
+
+// WithCredentials returns a Cloud calling the Filestore and Compute APIs with the service
+// account key credentialsJSON instead of the driver's own credentials. Resources are managed
+// in project, or in the project of the key if project is empty. The Clouds are cached, so
+// that the clients of a key are built once.
+func (c *Cloud) WithCredentials(credentialsJSON []byte, project string) (*Cloud, error) {
+	if c.newServices == nil {
+		return nil, fmt.Errorf("per-volume credentials are not supported")
+	}
+	// Other credential types, e.g. the external_account configs reading local files or
+	// fetching URLs, must not be used on behalf of the volumes.
+	var keyType struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(credentialsJSON, &keyType); err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	if keyType.Type != serviceAccountKeyType {
+		return nil, fmt.Errorf("credentials of type %q are not a service account key", keyType.Type)
+	}
+	sum := sha256.Sum256(credentialsJSON)
+	key := hex.EncodeToString(sum[:]) + "/" + project
+
+	c.scopedMux.Lock()
+	defer c.scopedMux.Unlock()
+	if scoped, ok := c.scoped[key]; ok {
+		return scoped, nil
+	}
+
+	// The clients outlive the request, so their token source must not use its context.
+	ctx := context.Background()
+	creds, err := google.CredentialsFromJSON(ctx, credentialsJSON, compute.CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	if project == "" {
+		project = creds.ProjectID
+	}
+	if project == "" {
+		return nil, fmt.Errorf("service account key has no project, and none is given")
+	}
+	fileService, computeService, err := c.newServices(oauth2.NewClient(ctx, creds.TokenSource))
+	if err != nil {
+		return nil, err
+	}
+	scoped := &Cloud{
+		Config:  c.Config,
+		File:    fileService,
+		Compute: computeService,
+		Project: project,
+		Zone:    c.Zone,
+	}
+	if c.scoped == nil {
+		c.scoped = map[string]*Cloud{}
+	}
+	c.scoped[key] = scoped
+	klog.Infof("Using service account key credentials for project %q", project)
+	return scoped, nil
+}
+
 func maybeReadConfig(configPath string) (*ConfigFile, error) {
 	if configPath == "" {
 		return nil, nil
@@ -0,0 +1,79 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"testing"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func testServiceAccountKey(project string) []byte {
+	return []byte(`{"type": "service_account", "project_id": "` + project + `", "client_email": "sa@` + project + `.iam.gserviceaccount.com", "private_key": "key"}`)
+}
+
+func TestWithCredentials(t *testing.T) {
+	fakeFile, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	c, _ := NewFakeCloudWithFiler(fakeFile, "test-project", "us-central1-c")
+	tests := []struct {
+		name            string
+		key             []byte
+		project         string
+		expectedProject string
+		expectErr       bool
+	}{
+		{name: "project of the key", key: testServiceAccountKey("tenant-a"), expectedProject: "tenant-a"},
+		{name: "given project", key: testServiceAccountKey("tenant-a"), project: "tenant-b", expectedProject: "tenant-b"},
+		{name: "key without project", key: testServiceAccountKey(""), expectErr: true},
+		{name: "invalid key", key: []byte("not json"), expectErr: true},
+		{name: "not a service account key", key: []byte(`{"type": "unknown"}`), expectErr: true},
+		{name: "external account config", key: []byte(`{"type": "external_account", "audience": "aud", "subject_token_type": "urn:ietf:params:oauth:token-type:jwt", "token_url": "https://sts.googleapis.com/v1/token", "credential_source": {"file": "/etc/secret"}}`), project: "tenant-a", expectErr: true},
+		{name: "authorized user", key: []byte(`{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token"}`), project: "tenant-a", expectErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			scoped, err := c.WithCredentials(tc.key, tc.project)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if scoped.Project != tc.expectedProject || scoped.Zone != c.Zone {
+				t.Errorf("got project %q zone %q, expected %q %q", scoped.Project, scoped.Zone, tc.expectedProject, c.Zone)
+			}
+			// The clients of a key are built once.
+			again, err := c.WithCredentials(tc.key, tc.project)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if again != scoped {
+				t.Errorf("expected the cached cloud to be returned")
+			}
+		})
+	}
+
+	if _, err := (&Cloud{}).WithCredentials(testServiceAccountKey("tenant-a"), ""); err == nil {
+		t.Errorf("expected error for a cloud without per-volume credentials support, got none")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"runtime"
+
+	computev1 "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const (
+	vpcPeeringPurpose = "VPC_PEERING"
+	ingressDirection  = "INGRESS"
+)
+
+// AddressRange is a named internal IP address range allocated in a VPC network, e.g. for
+// private services access.
+type AddressRange struct {
+	Name string
+	// Network is the name of the VPC network the range is allocated in.
+	Network string
+	CIDR    string
+}
+
+// FirewallRule is an ingress firewall rule of a VPC network allowing the TCP and UDP traffic
+// of the given ports from the source ranges to the destination ranges, all the instances of
+// the network if empty.
+type FirewallRule struct {
+	Name        string
+	Description string
+	// Network is the URL of the VPC network of the rule, e.g. global/networks/default.
+	Network           string
+	SourceRanges      []string
+	DestinationRanges []string
+	Ports             []string
+}
+
+// Service looks up the IP address ranges allocated in the VPC networks of a project. The
+// driver uses them to resolve the named reserved-ip-range parameter and to not allocate
+// instance ranges overlapping with them. It also manages the firewall rules of the NFS
+// traffic to the instances.
+type Service interface {
+	// GetAddressRange returns the named address range.
+	GetAddressRange(ctx context.Context, project, name string) (*AddressRange, error)
+	// ListAddressRanges returns the address ranges allocated in the given VPC network.
+	ListAddressRanges(ctx context.Context, project, network string) ([]*AddressRange, error)
+	// GetFirewallRule returns the named ingress firewall rule.
+	GetFirewallRule(ctx context.Context, project, name string) (*FirewallRule, error)
+	// CreateFirewallRule creates an ingress firewall rule.
+	CreateFirewallRule(ctx context.Context, project string, rule *FirewallRule) error
+	// UpdateFirewallRuleSourceRanges replaces the source ranges of the named firewall rule.
+	UpdateFirewallRuleSourceRanges(ctx context.Context, project, name string, sourceRanges []string) error
+}
+
+type computeServiceManager struct {
+	globalAddressesService *computev1.GlobalAddressesService
+	firewallsService       *computev1.FirewallsService
+}
+
+var _ Service = &computeServiceManager{}
+
+func NewComputeService(version string, client *http.Client) (Service, error) {
+	service, err := computev1.NewService(context.Background(),
+		option.WithHTTPClient(client),
+		option.WithUserAgent(fmt.Sprintf("Google Cloud Filestore CSI Driver/%s (%s %s)", version, runtime.GOOS, runtime.GOARCH)))
+	if err != nil {
+		return nil, err
+	}
+	return &computeServiceManager{
+		globalAddressesService: computev1.NewGlobalAddressesService(service),
+		firewallsService:       computev1.NewFirewallsService(service),
+	}, nil
+}
+
+func (manager *computeServiceManager) GetAddressRange(ctx context.Context, project, name string) (*AddressRange, error) {
+	address, err := manager.globalAddressesService.Get(project, name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return addressRange(address)
+}
+
+func (manager *computeServiceManager) ListAddressRanges(ctx context.Context, project, network string) ([]*AddressRange, error) {
+	var ranges []*AddressRange
+	err := manager.globalAddressesService.List(project).Filter(fmt.Sprintf("purpose=%q", vpcPeeringPurpose)).Pages(ctx, func(list *computev1.AddressList) error {
+		for _, address := range list.Items {
+			r, err := addressRange(address)
+			if err != nil {
+				return err
+			}
+			if r.Network == network {
+				ranges = append(ranges, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+func (manager *computeServiceManager) GetFirewallRule(ctx context.Context, project, name string) (*FirewallRule, error) {
+	firewall, err := manager.firewallsService.Get(project, name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	rule := &FirewallRule{
+		Name:              firewall.Name,
+		Description:       firewall.Description,
+		Network:           firewall.Network,
+		SourceRanges:      firewall.SourceRanges,
+		DestinationRanges: firewall.DestinationRanges,
+	}
+	for _, allowed := range firewall.Allowed {
+		if allowed.IPProtocol == "tcp" {
+			rule.Ports = allowed.Ports
+		}
+	}
+	return rule, nil
+}
+
+func (manager *computeServiceManager) CreateFirewallRule(ctx context.Context, project string, rule *FirewallRule) error {
+	_, err := manager.firewallsService.Insert(project, &computev1.Firewall{
+		Name:              rule.Name,
+		Description:       rule.Description,
+		Network:           rule.Network,
+		Direction:         ingressDirection,
+		SourceRanges:      rule.SourceRanges,
+		DestinationRanges: rule.DestinationRanges,
+		Allowed: []*computev1.FirewallAllowed{
+			{IPProtocol: "tcp", Ports: rule.Ports},
+			{IPProtocol: "udp", Ports: rule.Ports},
+		},
+	}).Context(ctx).Do()
+	return err
+}
+
+func (manager *computeServiceManager) UpdateFirewallRuleSourceRanges(ctx context.Context, project, name string, sourceRanges []string) error {
+	_, err := manager.firewallsService.Patch(project, name, &computev1.Firewall{SourceRanges: sourceRanges}).Context(ctx).Do()
+	return err
+}
+
+// IsNotFoundErr returns true if err is the error of a Compute API resource not found.
+func IsNotFoundErr(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func addressRange(address *computev1.Address) (*AddressRange, error) {
+	if address.Purpose != vpcPeeringPurpose || address.PrefixLength == 0 {
+		return nil, fmt.Errorf("address %s is not an allocated IP address range", address.Name)
+	}
+	return &AddressRange{
+		Name:    address.Name,
+		Network: path.Base(address.Network),
+		CIDR:    fmt.Sprintf("%s/%d", address.Address, address.PrefixLength),
+	}, nil
+}
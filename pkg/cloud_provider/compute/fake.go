@@ -0,0 +1,105 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compute
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// FakeService serves the address ranges it was created with, and the firewall rules created
+// through it.
+type FakeService struct {
+	ranges []*AddressRange
+	// Rules are the firewall rules by name.
+	Rules map[string]*FirewallRule
+	// Err, if set, is returned by all calls, e.g. to simulate missing permissions or a
+	// network whose peering is broken.
+	Err error
+}
+
+var _ Service = &FakeService{}
+
+func NewFakeService(ranges []*AddressRange) *FakeService {
+	return &FakeService{ranges: ranges, Rules: make(map[string]*FirewallRule)}
+}
+
+func (s *FakeService) GetAddressRange(ctx context.Context, project, name string) (*AddressRange, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	for _, r := range s.ranges {
+		if r.Name == name {
+			c := *r
+			return &c, nil
+		}
+	}
+	return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "address range " + name + " not found"}
+}
+
+func (s *FakeService) ListAddressRanges(ctx context.Context, project, network string) ([]*AddressRange, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	var ranges []*AddressRange
+	for _, r := range s.ranges {
+		if r.Network == network {
+			c := *r
+			ranges = append(ranges, &c)
+		}
+	}
+	return ranges, nil
+}
+
+func (s *FakeService) GetFirewallRule(ctx context.Context, project, name string) (*FirewallRule, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	rule, ok := s.Rules[name]
+	if !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "firewall rule " + name + " not found"}
+	}
+	c := *rule
+	c.SourceRanges = append([]string(nil), rule.SourceRanges...)
+	return &c, nil
+}
+
+func (s *FakeService) CreateFirewallRule(ctx context.Context, project string, rule *FirewallRule) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	if _, ok := s.Rules[rule.Name]; ok {
+		return &googleapi.Error{Code: http.StatusConflict, Message: "firewall rule " + rule.Name + " already exists"}
+	}
+	c := *rule
+	s.Rules[rule.Name] = &c
+	return nil
+}
+
+func (s *FakeService) UpdateFirewallRuleSourceRanges(ctx context.Context, project, name string, sourceRanges []string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	rule, ok := s.Rules[name]
+	if !ok {
+		return &googleapi.Error{Code: http.StatusNotFound, Message: "firewall rule " + name + " not found"}
+	}
+	rule.SourceRanges = sourceRanges
+	return nil
+}
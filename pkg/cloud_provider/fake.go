@@ -28,6 +28,7 @@ import (
 	"strings"
 
 	"github.com/stretchr/testify/mock"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/compute"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
 )
 
@@ -51,18 +52,20 @@ func NewFakeCloud() (*Cloud, error) {
 		return nil, fmt.Errorf("failed to initialize Filestore service: %w", err)
 	}
 
-	return &Cloud{
-		File:    file,
-		Project: "test-project",
-		Zone:    "us-central1-c",
-	}, nil
+	return NewFakeCloudWithFiler(file, "test-project", "us-central1-c")
 }
 
 func NewFakeCloudWithFiler(filer file.Service, project, location string) (*Cloud, error) {
+	computeService := compute.NewFakeService(nil)
 	return &Cloud{
 		File:    filer,
+		Compute: computeService,
 		Project: project,
 		Zone:    location,
+		// Clouds with other credentials share the fake services.
+		newServices: func(*http.Client) (file.Service, compute.Service, error) {
+			return filer, computeService, nil
+		},
 	}, nil
 }
 
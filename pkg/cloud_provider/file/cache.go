@@ -0,0 +1,258 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+	"k8s.io/klog/v2"
+)
+
+type instanceListEntry struct {
+	instances []*MultishareInstance
+	expiry    time.Time
+}
+
+type shareListEntry struct {
+	shares []*Share
+	expiry time.Time
+}
+
+// cachingServiceManager wraps a Service and caches the results of ListMultishareInstances
+// and ListShares for a short TTL. Provisioning bursts otherwise list every instance and
+// every share once per CreateVolume call. All cached lists are dropped whenever the driver
+// starts a mutation or observes an operation completing, so the driver's own changes are
+// visible right away; changes made outside the driver are visible after at most one TTL.
+// The methods not changing instances or shares are passed through to the wrapped Service,
+// see TestCachingServiceMethods.
+type cachingServiceManager struct {
+	Service
+
+	ttl       time.Duration
+	mux       sync.Mutex
+	instances map[ListFilter]*instanceListEntry
+	shares    map[ListFilter]*shareListEntry
+	// generation is bumped on every invalidation, so that a list call racing with a
+	// mutation does not store its possibly stale result.
+	generation uint64
+	// For testing purposes.
+	now func() time.Time
+}
+
+var _ Service = &cachingServiceManager{}
+
+// NewCachingService returns a Service caching multishare instance and share lists of the
+// given service for ttl.
+func NewCachingService(service Service, ttl time.Duration) Service {
+	klog.Infof("Caching multishare instance and share lists for %v", ttl)
+	return &cachingServiceManager{
+		Service:   service,
+		ttl:       ttl,
+		instances: make(map[ListFilter]*instanceListEntry),
+		shares:    make(map[ListFilter]*shareListEntry),
+		now:       time.Now,
+	}
+}
+
+func (m *cachingServiceManager) invalidate() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.instances = make(map[ListFilter]*instanceListEntry)
+	m.shares = make(map[ListFilter]*shareListEntry)
+	m.generation++
+}
+
+func (m *cachingServiceManager) ListMultishareInstances(ctx context.Context, filter *ListFilter) ([]*MultishareInstance, error) {
+	if filter == nil {
+		return m.Service.ListMultishareInstances(ctx, filter)
+	}
+	m.mux.Lock()
+	entry, ok := m.instances[*filter]
+	generation := m.generation
+	m.mux.Unlock()
+	if ok && m.now().Before(entry.expiry) {
+		klog.V(5).Infof("Multishare instance list cache hit for filter %+v", *filter)
+		return copyMultishareInstances(entry.instances), nil
+	}
+
+	instances, err := m.Service.ListMultishareInstances(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	m.mux.Lock()
+	if generation == m.generation {
+		m.instances[*filter] = &instanceListEntry{instances: copyMultishareInstances(instances), expiry: m.now().Add(m.ttl)}
+	}
+	m.mux.Unlock()
+	return instances, nil
+}
+
+func (m *cachingServiceManager) ListShares(ctx context.Context, filter *ListFilter) ([]*Share, error) {
+	if filter == nil {
+		return m.Service.ListShares(ctx, filter)
+	}
+	m.mux.Lock()
+	entry, ok := m.shares[*filter]
+	generation := m.generation
+	m.mux.Unlock()
+	if ok && m.now().Before(entry.expiry) {
+		klog.V(5).Infof("Share list cache hit for filter %+v", *filter)
+		return copyShares(entry.shares), nil
+	}
+
+	shares, err := m.Service.ListShares(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	m.mux.Lock()
+	if generation == m.generation {
+		m.shares[*filter] = &shareListEntry{shares: copyShares(shares), expiry: m.now().Add(m.ttl)}
+	}
+	m.mux.Unlock()
+	return shares, nil
+}
+
+func (m *cachingServiceManager) CreateInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	defer m.invalidate()
+	return m.Service.CreateInstance(ctx, obj)
+}
+
+func (m *cachingServiceManager) DeleteInstance(ctx context.Context, obj *ServiceInstance) error {
+	defer m.invalidate()
+	return m.Service.DeleteInstance(ctx, obj)
+}
+
+func (m *cachingServiceManager) ResizeInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	defer m.invalidate()
+	return m.Service.ResizeInstance(ctx, obj)
+}
+
+func (m *cachingServiceManager) RestoreInstance(ctx context.Context, obj *ServiceInstance, backupUri string) (*ServiceInstance, error) {
+	defer m.invalidate()
+	return m.Service.RestoreInstance(ctx, obj, backupUri)
+}
+
+func (m *cachingServiceManager) PromoteReplica(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	defer m.invalidate()
+	return m.Service.PromoteReplica(ctx, obj)
+}
+
+func (m *cachingServiceManager) StartCreateMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	defer m.invalidate()
+	return m.Service.StartCreateMultishareInstanceOp(ctx, obj)
+}
+
+func (m *cachingServiceManager) StartDeleteMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	defer m.invalidate()
+	return m.Service.StartDeleteMultishareInstanceOp(ctx, obj)
+}
+
+func (m *cachingServiceManager) StartResizeMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	defer m.invalidate()
+	return m.Service.StartResizeMultishareInstanceOp(ctx, obj)
+}
+
+func (m *cachingServiceManager) StartCreateShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
+	defer m.invalidate()
+	return m.Service.StartCreateShareOp(ctx, obj)
+}
+
+func (m *cachingServiceManager) StartDeleteShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
+	defer m.invalidate()
+	return m.Service.StartDeleteShareOp(ctx, obj)
+}
+
+func (m *cachingServiceManager) StartResizeShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
+	defer m.invalidate()
+	return m.Service.StartResizeShareOp(ctx, obj)
+}
+
+func (m *cachingServiceManager) WaitForOpWithOpts(ctx context.Context, op string, opts PollOpts) error {
+	// The operation changed the instance or share it targets once it is done.
+	defer m.invalidate()
+	return m.Service.WaitForOpWithOpts(ctx, op, opts)
+}
+
+func (m *cachingServiceManager) GetOp(ctx context.Context, op string) (*filev1beta1multishare.Operation, error) {
+	o, err := m.Service.GetOp(ctx, op)
+	if err == nil && o != nil && o.Done {
+		m.invalidate()
+	}
+	return o, err
+}
+
+func (m *cachingServiceManager) IsOpDone(op *filev1beta1multishare.Operation) (bool, error) {
+	done, err := m.Service.IsOpDone(op)
+	if done {
+		m.invalidate()
+	}
+	return done, err
+}
+
+// copyMultishareInstances returns copies of the instances, so that callers updating the
+// returned objects do not modify the cached ones.
+func copyMultishareInstances(instances []*MultishareInstance) []*MultishareInstance {
+	if instances == nil {
+		return nil
+	}
+	copies := make([]*MultishareInstance, 0, len(instances))
+	for _, instance := range instances {
+		copies = append(copies, copyMultishareInstance(instance))
+	}
+	return copies
+}
+
+func copyMultishareInstance(instance *MultishareInstance) *MultishareInstance {
+	if instance == nil {
+		return nil
+	}
+	c := *instance
+	c.Labels = copyLabels(instance.Labels)
+	return &c
+}
+
+func copyShares(shares []*Share) []*Share {
+	if shares == nil {
+		return nil
+	}
+	copies := make([]*Share, 0, len(shares))
+	for _, share := range shares {
+		if share == nil {
+			copies = append(copies, nil)
+			continue
+		}
+		c := *share
+		c.Parent = copyMultishareInstance(share.Parent)
+		c.Labels = copyLabels(share.Labels)
+		copies = append(copies, &c)
+	}
+	return copies
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
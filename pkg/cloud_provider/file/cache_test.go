@@ -0,0 +1,202 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// countingService counts the list calls reaching the wrapped service.
+type countingService struct {
+	Service
+	instanceLists int
+	shareLists    int
+}
+
+func (s *countingService) ListMultishareInstances(ctx context.Context, filter *ListFilter) ([]*MultishareInstance, error) {
+	s.instanceLists++
+	return s.Service.ListMultishareInstances(ctx, filter)
+}
+
+func (s *countingService) ListShares(ctx context.Context, filter *ListFilter) ([]*Share, error) {
+	s.shareLists++
+	return s.Service.ListShares(ctx, filter)
+}
+
+func TestCachingService(t *testing.T) {
+	instance := &MultishareInstance{
+		Project:       "test-project",
+		Location:      "us-central1",
+		Name:          "fs-1",
+		CapacityBytes: util.Tb,
+		Labels:        map[string]string{"a": "b"},
+		State:         "READY",
+	}
+	share := &Share{
+		Name:          "pvc-1",
+		Parent:        instance,
+		CapacityBytes: 100 * util.Gb,
+	}
+	fake, err := NewFakeServiceForMultishare([]*MultishareInstance{instance}, []*Share{share}, nil)
+	if err != nil {
+		t.Fatalf("failed to init fake service: %v", err)
+	}
+	counting := &countingService{Service: fake}
+	now := time.Now()
+	cached := NewCachingService(counting, time.Minute).(*cachingServiceManager)
+	cached.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	filter := &ListFilter{Project: "test-project", Location: "us-central1"}
+	instances, err := cached.ListMultishareInstances(ctx, filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Callers updating the returned objects must not change the cached copies.
+	instances[0].CapacityBytes = 2 * util.Tb
+	instances, err = cached.ListMultishareInstances(ctx, filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if counting.instanceLists != 1 {
+		t.Errorf("expected 1 instance list call, got %d", counting.instanceLists)
+	}
+	if instances[0].CapacityBytes != util.Tb {
+		t.Errorf("cached instance was modified, got capacity %d", instances[0].CapacityBytes)
+	}
+
+	// A different filter is cached separately.
+	if _, err := cached.ListShares(ctx, &ListFilter{Project: "test-project", Location: "us-central1", InstanceName: "fs-1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cached.ListShares(ctx, &ListFilter{Project: "test-project", Location: "us-central1", InstanceName: "-"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if counting.shareLists != 2 {
+		t.Errorf("expected 2 share list calls, got %d", counting.shareLists)
+	}
+
+	// Entries expire after the ttl.
+	now = now.Add(2 * time.Minute)
+	if _, err := cached.ListMultishareInstances(ctx, filter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if counting.instanceLists != 2 {
+		t.Errorf("expected 2 instance list calls after expiry, got %d", counting.instanceLists)
+	}
+
+	// Mutations invalidate the cache.
+	if _, err := cached.StartResizeShareOp(ctx, share); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cached.ListMultishareInstances(ctx, filter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if counting.instanceLists != 3 {
+		t.Errorf("expected 3 instance list calls after invalidation, got %d", counting.instanceLists)
+	}
+}
+
+// passthroughMethods are the Service methods the caching service does not override, as they
+// neither change instances or shares nor observe operations completing.
+var passthroughMethods = map[string]bool{
+	"GetInstance":           true,
+	"ListInstances":         true,
+	"GetBackup":             true,
+	"CreateBackup":          true,
+	"DeleteBackup":          true,
+	"ListBackups":           true,
+	"HasOperations":         true,
+	"GetOperationProgress":  true,
+	"GetMultishareInstance": true,
+	"GetShare":              true,
+	"ListOps":               true,
+	"ListLocations":         true,
+}
+
+// TestCachingServiceMethods fails on a Service method which is neither overridden in cache.go
+// nor listed in passthroughMethods, so that new mutating methods invalidate the cached lists.
+func TestCachingServiceMethods(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "cache.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse cache.go: %v", err)
+	}
+	overridden := map[string]bool{}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 {
+			continue
+		}
+		if star, ok := fn.Recv.List[0].Type.(*ast.StarExpr); ok {
+			if ident, ok := star.X.(*ast.Ident); ok && ident.Name == "cachingServiceManager" {
+				overridden[fn.Name.Name] = true
+			}
+		}
+	}
+
+	service := reflect.TypeOf((*Service)(nil)).Elem()
+	for i := 0; i < service.NumMethod(); i++ {
+		name := service.Method(i).Name
+		if overridden[name] == passthroughMethods[name] {
+			t.Errorf("Service method %s must either be overridden by cachingServiceManager or listed in passthroughMethods", name)
+		}
+	}
+}
+
+func TestCachingServiceGetOp(t *testing.T) {
+	instance := &MultishareInstance{
+		Project:       "test-project",
+		Location:      "us-central1",
+		Name:          "fs-1",
+		CapacityBytes: util.Tb,
+		State:         "READY",
+	}
+	fake, err := NewFakeServiceForMultishare([]*MultishareInstance{instance}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to init fake service: %v", err)
+	}
+	counting := &countingService{Service: fake}
+	cache := NewCachingService(counting, time.Hour)
+	filter := &ListFilter{Project: instance.Project, Location: instance.Location}
+	ctx := context.Background()
+
+	op, err := cache.StartResizeMultishareInstanceOp(ctx, instance)
+	if err != nil {
+		t.Fatalf("failed to start resize: %v", err)
+	}
+	if _, err := cache.ListMultishareInstances(ctx, filter); err != nil {
+		t.Fatalf("failed to list instances: %v", err)
+	}
+	// The fake service returns the ops done.
+	if _, err := cache.GetOp(ctx, op.Name); err != nil {
+		t.Fatalf("failed to get op: %v", err)
+	}
+	if _, err := cache.ListMultishareInstances(ctx, filter); err != nil {
+		t.Fatalf("failed to list instances: %v", err)
+	}
+	if counting.instanceLists != 2 {
+		t.Errorf("got %d instance lists, expected the done op to invalidate the cached list", counting.instanceLists)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/google/uuid"
 	filev1beta1 "google.golang.org/api/file/v1beta1"
@@ -27,6 +28,7 @@ import (
 	"google.golang.org/api/googleapi"
 	"google.golang.org/genproto/googleapis/rpc/code"
 	"google.golang.org/grpc/codes"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
 )
 
 const (
@@ -39,11 +41,16 @@ const (
 )
 
 type fakeServiceManager struct {
+	// mux guards the fake resources below, so that the fake can serve concurrent calls.
+	mux                       sync.Mutex
 	createdInstances          map[string]*ServiceInstance
 	backups                   map[string]*Backup
 	createdMultishareInstance map[string]*MultishareInstance
 	createdMultishares        map[string]*Share
 	multishareops             []*filev1beta1multishare.Operation
+	// pendingShareOps maps the running share create and delete ops to their share. Like the
+	// Filestore API, the share is CREATING or DELETING until its op is done.
+	pendingShareOps map[string]string
 }
 
 var _ Service = &fakeServiceManager{}
@@ -54,6 +61,7 @@ func NewFakeService() (Service, error) {
 		backups:                   map[string]*Backup{},
 		createdMultishareInstance: make(map[string]*MultishareInstance),
 		createdMultishares:        make(map[string]*Share),
+		pendingShareOps:           make(map[string]string),
 	}, nil
 }
 
@@ -64,6 +72,7 @@ func NewFakeServiceForMultishare(instances []*MultishareInstance, shares []*Shar
 		createdMultishareInstance: make(map[string]*MultishareInstance),
 		createdMultishares:        make(map[string]*Share),
 		multishareops:             make([]*filev1beta1multishare.Operation, 0),
+		pendingShareOps:           make(map[string]string),
 	}
 
 	for _, instance := range instances {
@@ -77,6 +86,8 @@ func NewFakeServiceForMultishare(instances []*MultishareInstance, shares []*Shar
 }
 
 func (manager *fakeServiceManager) CreateInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	instance := &ServiceInstance{
 		Project:  defaultProject,
 		Location: defaultZone,
@@ -95,6 +106,7 @@ func (manager *fakeServiceManager) CreateInstance(ctx context.Context, obj *Serv
 		State:            "READY",
 		BackupSource:     obj.BackupSource,
 		NfsExportOptions: obj.NfsExportOptions,
+		Protocol:         obj.Protocol,
 	}
 
 	manager.createdInstances[obj.Name] = instance
@@ -106,6 +118,8 @@ func (manager *fakeServiceManager) DeleteInstance(ctx context.Context, obj *Serv
 }
 
 func (manager *fakeServiceManager) GetInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	instance, exists := manager.createdInstances[obj.Name]
 	if exists {
 		return instance, nil
@@ -148,6 +162,8 @@ func (manager *fakeServiceManager) ListInstances(ctx context.Context, obj *Servi
 }
 
 func (manager *fakeServiceManager) ResizeInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	instance, ok := manager.createdInstances[obj.Name]
 	if !ok {
 		return nil, fmt.Errorf("Instance %v not found", obj.Name)
@@ -158,7 +174,36 @@ func (manager *fakeServiceManager) ResizeInstance(ctx context.Context, obj *Serv
 	return instance, nil
 }
 
+// RestoreInstance records the restored backup as the backup source of the instance.
+func (manager *fakeServiceManager) RestoreInstance(ctx context.Context, obj *ServiceInstance, backupUri string) (*ServiceInstance, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
+	instance, ok := manager.createdInstances[obj.Name]
+	if !ok {
+		return nil, notFoundError()
+	}
+	if _, ok := manager.backups[backupUri]; !ok {
+		return nil, notFoundError()
+	}
+	instance.BackupSource = backupUri
+	return instance, nil
+}
+
+// PromoteReplica marks the instance ready, the fake does not track replicas.
+func (manager *fakeServiceManager) PromoteReplica(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
+	instance, ok := manager.createdInstances[obj.Name]
+	if !ok {
+		return nil, notFoundError()
+	}
+	instance.State = "READY"
+	return instance, nil
+}
+
 func (manager *fakeServiceManager) CreateBackup(ctx context.Context, backupInfo *BackupInfo) (*filev1beta1.Backup, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	if backupInfo.SourceInstanceName == "" || backupInfo.SourceShare == "" || backupInfo.SourceVolumeId == "" || backupInfo.BackupURI == "" {
 		return nil, fmt.Errorf("BackupInfo fields are not set %+v", backupInfo)
 	}
@@ -193,11 +238,15 @@ func (manager *fakeServiceManager) CreateBackup(ctx context.Context, backupInfo
 }
 
 func (manager *fakeServiceManager) DeleteBackup(ctx context.Context, backupName string) error {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	delete(manager.backups, backupName)
 	return nil
 }
 
 func (manager *fakeServiceManager) GetBackup(ctx context.Context, backupUri string) (*Backup, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	backupInfo, ok := manager.backups[backupUri]
 	if !ok || backupInfo.Backup == nil {
 		return nil, notFoundError()
@@ -206,10 +255,26 @@ func (manager *fakeServiceManager) GetBackup(ctx context.Context, backupUri stri
 	return backupInfo, nil
 }
 
+func (manager *fakeServiceManager) ListBackups(ctx context.Context, obj *ServiceInstance) ([]*Backup, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
+	var backups []*Backup
+	for _, backup := range manager.backups {
+		if IsBackupOfInstance(backup.SourceInstance, obj) {
+			backups = append(backups, backup)
+		}
+	}
+	return backups, nil
+}
+
 func (m *fakeServiceManager) HasOperations(ctx context.Context, obj *ServiceInstance, operationType string, done bool) (bool, error) {
 	return false, nil
 }
 
+func (m *fakeServiceManager) GetOperationProgress(ctx context.Context, obj *ServiceInstance, operationType string) (*OperationProgress, error) {
+	return nil, nil
+}
+
 func notFoundError() *googleapi.Error {
 	return &googleapi.Error{
 		Errors: []googleapi.ErrorItem{
@@ -257,6 +322,8 @@ func (m *fakeBlockingServiceManager) HasOperations(ctx context.Context, obj *Ser
 
 // Multishare fake functions defined here
 func (manager *fakeServiceManager) GetMultishareInstance(ctx context.Context, obj *MultishareInstance) (*MultishareInstance, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	instance, ok := manager.createdMultishareInstance[obj.Name]
 	if !ok {
 		return nil, &googleapi.Error{
@@ -268,18 +335,24 @@ func (manager *fakeServiceManager) GetMultishareInstance(ctx context.Context, ob
 			},
 		}
 	}
-	return instance, nil
+	i := *instance
+	return &i, nil
 }
 
 func (manager *fakeServiceManager) ListMultishareInstances(ctx context.Context, filter *ListFilter) ([]*MultishareInstance, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	var ilist []*MultishareInstance
 	for _, v := range manager.createdMultishareInstance {
-		ilist = append(ilist, v)
+		i := *v
+		ilist = append(ilist, &i)
 	}
 	return ilist, nil
 }
 
 func (manager *fakeServiceManager) StartCreateMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	instance := &MultishareInstance{
 		Project:       defaultProject,
 		Location:      obj.Location,
@@ -290,6 +363,15 @@ func (manager *fakeServiceManager) StartCreateMultishareInstanceOp(ctx context.C
 		KmsKeyName:    obj.KmsKeyName,
 		Labels:        obj.Labels,
 		State:         "READY",
+		Description:   obj.Description,
+		MaxShareCount: obj.MaxShareCount,
+	}
+	if instance.Network.Ip == "" {
+		instance.Network.Ip = "1.1.1.1"
+	}
+	instance.CapacityStepSizeGb = obj.CapacityStepSizeGb
+	if instance.CapacityStepSizeGb == 0 {
+		instance.CapacityStepSizeGb = util.DefaultStepSizeGb
 	}
 	manager.createdMultishareInstance[obj.Name] = instance
 	meta := &filev1beta1multishare.OperationMetadata{
@@ -312,6 +394,8 @@ type Signal struct {
 }
 
 func (manager *fakeServiceManager) StartDeleteMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	delete(manager.createdMultishareInstance, obj.Name)
 	meta := &filev1beta1multishare.OperationMetadata{
 		Target: fmt.Sprintf(instanceURIFmt, obj.Project, obj.Location, obj.Name),
@@ -326,7 +410,12 @@ func (manager *fakeServiceManager) StartDeleteMultishareInstanceOp(ctx context.C
 }
 
 func (manager *fakeServiceManager) StartResizeMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	manager.createdMultishareInstance[obj.Name].CapacityBytes = obj.CapacityBytes
+	if len(obj.Labels) > 0 {
+		manager.createdMultishareInstance[obj.Name].Labels = obj.Labels
+	}
 	meta := &filev1beta1multishare.OperationMetadata{
 		Target: fmt.Sprintf(instanceURIFmt, obj.Project, obj.Location, obj.Name),
 		Verb:   "update",
@@ -340,9 +429,16 @@ func (manager *fakeServiceManager) StartResizeMultishareInstanceOp(ctx context.C
 }
 
 func (manager *fakeServiceManager) StartCreateShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
-	if _, ok := manager.createdMultishareInstance[obj.Parent.Name]; !ok {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
+	instance, ok := manager.createdMultishareInstance[obj.Parent.Name]
+	if !ok {
 		return nil, fmt.Errorf("host instance %s not found", obj.Parent.Name)
 	}
+	// Like the Filestore API, reject shares that do not fit in the host instance.
+	if err := manager.checkShareFits(instance, obj); err != nil {
+		return nil, err
+	}
 
 	parent := &MultishareInstance{
 		Project:       obj.Parent.Project,
@@ -365,9 +461,12 @@ func (manager *fakeServiceManager) StartCreateShareOp(ctx context.Context, obj *
 		Labels:           obj.Labels,
 		MountPointName:   obj.Name,
 		BackupId:         obj.BackupId,
-		State:            "READY",
+		State:            ShareStateCreating,
 		NfsExportOptions: obj.NfsExportOptions,
 	}
+	if existing, ok := manager.createdMultishares[share.Name]; ok {
+		share.State = existing.State
+	}
 	manager.createdMultishares[share.Name] = share
 
 	meta := &filev1beta1.OperationMetadata{
@@ -379,12 +478,20 @@ func (manager *fakeServiceManager) StartCreateShareOp(ctx context.Context, obj *
 		Name:     "operation-" + uuid.New().String(),
 		Metadata: metaBytes,
 	}
+	if share.State == ShareStateCreating {
+		manager.pendingShareOps[op.Name] = share.Name
+	}
 
 	return op, nil
 }
 
 func (manager *fakeServiceManager) StartDeleteShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
-	delete(manager.createdMultishares, obj.Name)
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
+	share, ok := manager.createdMultishares[obj.Name]
+	if ok {
+		share.State = ShareStateDeleting
+	}
 
 	meta := &filev1beta1multishare.OperationMetadata{
 		Target: fmt.Sprintf(shareURIFmt, obj.Parent.Project, obj.Parent.Location, obj.Parent.Name, obj.Name),
@@ -395,11 +502,16 @@ func (manager *fakeServiceManager) StartDeleteShareOp(ctx context.Context, obj *
 		Name:     "operation-" + uuid.New().String(),
 		Metadata: metaBytes,
 	}
+	if ok {
+		manager.pendingShareOps[op.Name] = share.Name
+	}
 
 	return op, nil
 }
 
 func (manager *fakeServiceManager) StartResizeShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	manager.createdMultishares[obj.Name].CapacityBytes = obj.CapacityBytes
 	meta := &filev1beta1multishare.OperationMetadata{
 		Target: fmt.Sprintf(shareURIFmt, obj.Parent.Project, obj.Parent.Location, obj.Parent.Name, obj.Name),
@@ -415,10 +527,34 @@ func (manager *fakeServiceManager) StartResizeShareOp(ctx context.Context, obj *
 }
 
 func (manager *fakeServiceManager) WaitForOpWithOpts(ctx context.Context, op string, opts PollOpts) error {
+	manager.completeShareOp(op)
 	return nil
 }
 
+// completeShareOp completes a running share create or delete op: the created share becomes
+// READY and the deleted share is removed.
+func (manager *fakeServiceManager) completeShareOp(opName string) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
+	name, ok := manager.pendingShareOps[opName]
+	if !ok {
+		return
+	}
+	delete(manager.pendingShareOps, opName)
+	share, ok := manager.createdMultishares[name]
+	if !ok {
+		return
+	}
+	switch share.State {
+	case ShareStateCreating:
+		share.State = ShareStateReady
+	case ShareStateDeleting:
+		delete(manager.createdMultishares, name)
+	}
+}
+
 func (manager *fakeServiceManager) GetOp(ctx context.Context, opName string) (*filev1beta1multishare.Operation, error) {
+	manager.completeShareOp(opName)
 	op := &filev1beta1multishare.Operation{
 		Name: opName,
 		Done: true,
@@ -431,34 +567,98 @@ func (manager *fakeServiceManager) IsOpDone(*filev1beta1multishare.Operation) (b
 }
 
 func (manager *fakeServiceManager) GetShare(ctx context.Context, obj *Share) (*Share, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	share, ok := manager.createdMultishares[obj.Name]
 	if !ok {
 		return nil, notFoundError()
 	}
+	// Like the Filestore API, report the current state of the host instance, e.g. its IP.
+	if share.Parent != nil {
+		if instance, ok := manager.createdMultishareInstance[share.Parent.Name]; ok {
+			s := *share
+			s.Parent = instance
+			return &s, nil
+		}
+	}
 	return share, nil
 }
 
 func (manager *fakeServiceManager) ListShares(ctx context.Context, filter *ListFilter) ([]*Share, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	var slist []*Share
 	for _, v := range manager.createdMultishares {
-		slist = append(slist, v)
+		if filter != nil && filter.InstanceName != "" && filter.InstanceName != "-" && (v.Parent == nil || v.Parent.Name != filter.InstanceName) {
+			continue
+		}
+		s := *v
+		slist = append(slist, &s)
 	}
 	return slist, nil
 }
 
+// checkShareFits returns an error if the host instance has no room left for the share,
+// either in share count or in capacity. Shares already created are not checked again.
+func (manager *fakeServiceManager) checkShareFits(instance *MultishareInstance, obj *Share) error {
+	if _, ok := manager.createdMultishares[obj.Name]; ok {
+		return nil
+	}
+	maxShareCount := instance.MaxShareCount
+	if maxShareCount == 0 {
+		maxShareCount = util.MaxSharesPerInstance
+	}
+	count := 0
+	var sumShareBytes int64
+	for _, s := range manager.createdMultishares {
+		if s.Parent != nil && s.Parent.Name == instance.Name {
+			count++
+			sumShareBytes += s.CapacityBytes
+		}
+	}
+	if count >= maxShareCount {
+		return fmt.Errorf("host instance %s already has %d shares", instance.Name, count)
+	}
+	if instance.CapacityBytes > 0 && sumShareBytes+obj.CapacityBytes > instance.CapacityBytes {
+		return fmt.Errorf("host instance %s has %d bytes left, share %s needs %d bytes", instance.Name, instance.CapacityBytes-sumShareBytes, obj.Name, obj.CapacityBytes)
+	}
+	return nil
+}
+
 func (manager *fakeServiceManager) AddMultishareOps(ops []*filev1beta1multishare.Operation) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	manager.multishareops = append(manager.multishareops, ops...)
 }
 
 func (manager *fakeServiceManager) ListOps(ctx context.Context, resource *ListFilter) ([]*filev1beta1multishare.Operation, error) {
+	manager.mux.Lock()
+	defer manager.mux.Unlock()
 	return manager.multishareops, nil
 }
 
+// fakeLocations are the Filestore locations of the fake, the regions the tests use and their zones.
+var fakeLocations = func() []string {
+	var locations []string
+	for _, region := range []string{"us-central1", "us-east1", "us-west1"} {
+		locations = append(locations, region)
+		for _, zone := range []string{"a", "b", "c", "d", "f"} {
+			locations = append(locations, region+"-"+zone)
+		}
+	}
+	return locations
+}()
+
+func (manager *fakeServiceManager) ListLocations(ctx context.Context, project string) ([]string, error) {
+	return fakeLocations, nil
+}
+
 func NewFakeBlockingServiceForMultishare(unblocker chan chan Signal) (Service, error) {
 	return &fakeBlockingServiceManager{
 		fakeServiceManager: &fakeServiceManager{
 			createdMultishareInstance: make(map[string]*MultishareInstance),
 			createdMultishares:        make(map[string]*Share),
+			pendingShareOps:           make(map[string]string),
 		},
 		MultishareUnblocker: unblocker,
 	}, nil
@@ -0,0 +1,301 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+)
+
+// Fault is a failure injected into the calls of a Service method by a FaultInjector.
+type Fault struct {
+	// OnCall is the 1-based number of the call to the method that fails. Zero means every call.
+	OnCall int
+	// Err is returned by the failing call.
+	Err error
+	// OpError, if set on a method starting an operation, lets the call succeed but makes the
+	// started operation complete with this error status.
+	OpError *filev1beta1multishare.Status
+	// Latency is added to the failing call, or to every call if neither Err nor OpError is set.
+	Latency time.Duration
+}
+
+// FaultInjector scripts failures of Service methods, keyed by method name, e.g.
+// "StartCreateShareOp", so that retries and idempotency can be tested deterministically.
+type FaultInjector struct {
+	mux    sync.Mutex
+	faults map[string][]Fault
+	calls  map[string]int
+	// failedOps maps the names of the operations that complete with an error to their status.
+	failedOps map[string]*filev1beta1multishare.Status
+}
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		faults:    make(map[string][]Fault),
+		calls:     make(map[string]int),
+		failedOps: make(map[string]*filev1beta1multishare.Status),
+	}
+}
+
+// Inject adds a fault to the calls of the given method.
+func (f *FaultInjector) Inject(method string, fault Fault) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.faults[method] = append(f.faults[method], fault)
+}
+
+// Calls returns the number of calls made to the given method.
+func (f *FaultInjector) Calls(method string) int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.calls[method]
+}
+
+// intercept records a call to the method and applies the matching faults. It returns the
+// error the call fails with, or the status the operation started by the call completes with.
+func (f *FaultInjector) intercept(ctx context.Context, method string) (*filev1beta1multishare.Status, error) {
+	f.mux.Lock()
+	f.calls[method]++
+	call := f.calls[method]
+	var latency time.Duration
+	var opError *filev1beta1multishare.Status
+	var err error
+	for _, fault := range f.faults[method] {
+		if fault.OnCall != 0 && fault.OnCall != call {
+			continue
+		}
+		latency += fault.Latency
+		if fault.OpError != nil && opError == nil {
+			opError = fault.OpError
+		}
+		if fault.Err != nil && err == nil {
+			err = fault.Err
+		}
+	}
+	f.mux.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return opError, err
+}
+
+func (f *FaultInjector) startOp(ctx context.Context, method string, start func() (*filev1beta1multishare.Operation, error)) (*filev1beta1multishare.Operation, error) {
+	opError, err := f.intercept(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	op, err := start()
+	if err != nil || opError == nil || op == nil {
+		return op, err
+	}
+	f.mux.Lock()
+	f.failedOps[op.Name] = opError
+	f.mux.Unlock()
+	return op, nil
+}
+
+func (f *FaultInjector) opError(opName string) *filev1beta1multishare.Status {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.failedOps[opName]
+}
+
+// faultInjectingServiceManager wraps a Service and applies the faults of a FaultInjector to
+// the instance, share and operation methods.
+type faultInjectingServiceManager struct {
+	Service
+	faults *FaultInjector
+}
+
+var _ Service = &faultInjectingServiceManager{}
+
+// NewFakeServiceWithFaults returns the given fake service, e.g. one returned by
+// NewFakeServiceForMultishare or NewFakeBlockingServiceForMultishare, failing as scripted
+// by faults.
+func NewFakeServiceWithFaults(service Service, faults *FaultInjector) Service {
+	return &faultInjectingServiceManager{
+		Service: service,
+		faults:  faults,
+	}
+}
+
+func (m *faultInjectingServiceManager) CreateInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	if _, err := m.faults.intercept(ctx, "CreateInstance"); err != nil {
+		return nil, err
+	}
+	return m.Service.CreateInstance(ctx, obj)
+}
+
+func (m *faultInjectingServiceManager) DeleteInstance(ctx context.Context, obj *ServiceInstance) error {
+	if _, err := m.faults.intercept(ctx, "DeleteInstance"); err != nil {
+		return err
+	}
+	return m.Service.DeleteInstance(ctx, obj)
+}
+
+func (m *faultInjectingServiceManager) GetInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	if _, err := m.faults.intercept(ctx, "GetInstance"); err != nil {
+		return nil, err
+	}
+	return m.Service.GetInstance(ctx, obj)
+}
+
+func (m *faultInjectingServiceManager) ResizeInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	if _, err := m.faults.intercept(ctx, "ResizeInstance"); err != nil {
+		return nil, err
+	}
+	return m.Service.ResizeInstance(ctx, obj)
+}
+
+func (m *faultInjectingServiceManager) RestoreInstance(ctx context.Context, obj *ServiceInstance, backupUri string) (*ServiceInstance, error) {
+	if _, err := m.faults.intercept(ctx, "RestoreInstance"); err != nil {
+		return nil, err
+	}
+	return m.Service.RestoreInstance(ctx, obj, backupUri)
+}
+
+func (m *faultInjectingServiceManager) PromoteReplica(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	if _, err := m.faults.intercept(ctx, "PromoteReplica"); err != nil {
+		return nil, err
+	}
+	return m.Service.PromoteReplica(ctx, obj)
+}
+
+func (m *faultInjectingServiceManager) GetMultishareInstance(ctx context.Context, obj *MultishareInstance) (*MultishareInstance, error) {
+	if _, err := m.faults.intercept(ctx, "GetMultishareInstance"); err != nil {
+		return nil, err
+	}
+	return m.Service.GetMultishareInstance(ctx, obj)
+}
+
+func (m *faultInjectingServiceManager) ListMultishareInstances(ctx context.Context, filter *ListFilter) ([]*MultishareInstance, error) {
+	if _, err := m.faults.intercept(ctx, "ListMultishareInstances"); err != nil {
+		return nil, err
+	}
+	return m.Service.ListMultishareInstances(ctx, filter)
+}
+
+func (m *faultInjectingServiceManager) StartCreateMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	return m.faults.startOp(ctx, "StartCreateMultishareInstanceOp", func() (*filev1beta1multishare.Operation, error) {
+		return m.Service.StartCreateMultishareInstanceOp(ctx, obj)
+	})
+}
+
+func (m *faultInjectingServiceManager) StartDeleteMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	return m.faults.startOp(ctx, "StartDeleteMultishareInstanceOp", func() (*filev1beta1multishare.Operation, error) {
+		return m.Service.StartDeleteMultishareInstanceOp(ctx, obj)
+	})
+}
+
+func (m *faultInjectingServiceManager) StartResizeMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	return m.faults.startOp(ctx, "StartResizeMultishareInstanceOp", func() (*filev1beta1multishare.Operation, error) {
+		return m.Service.StartResizeMultishareInstanceOp(ctx, obj)
+	})
+}
+
+func (m *faultInjectingServiceManager) ListShares(ctx context.Context, filter *ListFilter) ([]*Share, error) {
+	if _, err := m.faults.intercept(ctx, "ListShares"); err != nil {
+		return nil, err
+	}
+	return m.Service.ListShares(ctx, filter)
+}
+
+func (m *faultInjectingServiceManager) GetShare(ctx context.Context, obj *Share) (*Share, error) {
+	if _, err := m.faults.intercept(ctx, "GetShare"); err != nil {
+		return nil, err
+	}
+	return m.Service.GetShare(ctx, obj)
+}
+
+func (m *faultInjectingServiceManager) StartCreateShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
+	return m.faults.startOp(ctx, "StartCreateShareOp", func() (*filev1beta1multishare.Operation, error) {
+		return m.Service.StartCreateShareOp(ctx, obj)
+	})
+}
+
+func (m *faultInjectingServiceManager) StartDeleteShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
+	return m.faults.startOp(ctx, "StartDeleteShareOp", func() (*filev1beta1multishare.Operation, error) {
+		return m.Service.StartDeleteShareOp(ctx, obj)
+	})
+}
+
+func (m *faultInjectingServiceManager) StartResizeShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
+	return m.faults.startOp(ctx, "StartResizeShareOp", func() (*filev1beta1multishare.Operation, error) {
+		return m.Service.StartResizeShareOp(ctx, obj)
+	})
+}
+
+func (m *faultInjectingServiceManager) WaitForOpWithOpts(ctx context.Context, op string, opts PollOpts) error {
+	if _, err := m.faults.intercept(ctx, "WaitForOpWithOpts"); err != nil {
+		return pendingOpError(op, err)
+	}
+	if err := m.Service.WaitForOpWithOpts(ctx, op, opts); err != nil {
+		return err
+	}
+	if opError := m.faults.opError(op); opError != nil {
+		return opFailedError(&filev1beta1multishare.Operation{Name: op, Error: opError})
+	}
+	return nil
+}
+
+func (m *faultInjectingServiceManager) GetOp(ctx context.Context, opName string) (*filev1beta1multishare.Operation, error) {
+	if _, err := m.faults.intercept(ctx, "GetOp"); err != nil {
+		return nil, err
+	}
+	op, err := m.Service.GetOp(ctx, opName)
+	if err != nil || op == nil {
+		return op, err
+	}
+	if opError := m.faults.opError(opName); opError != nil && op.Done {
+		op.Error = opError
+	}
+	return op, nil
+}
+
+func (m *faultInjectingServiceManager) IsOpDone(op *filev1beta1multishare.Operation) (bool, error) {
+	if _, err := m.faults.intercept(context.Background(), "IsOpDone"); err != nil {
+		return false, err
+	}
+	done, err := m.Service.IsOpDone(op)
+	if err != nil || !done || op == nil {
+		return done, err
+	}
+	opError := op.Error
+	if opError == nil {
+		opError = m.faults.opError(op.Name)
+	}
+	if opError != nil {
+		return true, opFailedError(&filev1beta1multishare.Operation{Name: op.Name, Metadata: op.Metadata, Error: opError})
+	}
+	return true, nil
+}
+
+func (m *faultInjectingServiceManager) ListOps(ctx context.Context, filter *ListFilter) ([]*filev1beta1multishare.Operation, error) {
+	if _, err := m.faults.intercept(ctx, "ListOps"); err != nil {
+		return nil, err
+	}
+	return m.Service.ListOps(ctx, filter)
+}
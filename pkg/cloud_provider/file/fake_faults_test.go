@@ -0,0 +1,85 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+)
+
+func TestFaultInjector(t *testing.T) {
+	ctx := context.Background()
+	instance := &MultishareInstance{Name: "instance-1", Project: defaultProject, Location: defaultRegion}
+	share := &Share{Name: "share-1", Parent: instance}
+	fake, err := NewFakeServiceForMultishare([]*MultishareInstance{instance}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create fake service: %v", err)
+	}
+	faults := NewFaultInjector()
+	service := NewFakeServiceWithFaults(fake, faults)
+
+	// Error on the second call only.
+	injected := errors.New("injected error")
+	faults.Inject("GetMultishareInstance", Fault{OnCall: 2, Err: injected})
+	for call, wantErr := range []error{nil, injected, nil} {
+		if _, err := service.GetMultishareInstance(ctx, instance); !errors.Is(err, wantErr) {
+			t.Errorf("call %d: got error %v, want %v", call+1, err, wantErr)
+		}
+	}
+	if calls := faults.Calls("GetMultishareInstance"); calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+
+	// An operation completing with an error status.
+	faults.Inject("StartCreateShareOp", Fault{OpError: &filev1beta1multishare.Status{Code: 13, Message: "internal error"}})
+	op, err := service.StartCreateShareOp(ctx, share)
+	if err != nil {
+		t.Fatalf("unexpected error starting operation: %v", err)
+	}
+	if err := service.WaitForOpWithOpts(ctx, op.Name, PollOpts{}); err == nil {
+		t.Errorf("expected operation %s to fail", op.Name)
+	}
+	polled, err := service.GetOp(ctx, op.Name)
+	if err != nil {
+		t.Fatalf("unexpected error getting operation: %v", err)
+	}
+	if polled.Error == nil || polled.Error.Code != 13 {
+		t.Errorf("got operation error %v, want code 13", polled.Error)
+	}
+	if done, err := service.IsOpDone(polled); !done || err == nil {
+		t.Errorf("got done %v and error %v, want done operation with error", done, err)
+	}
+
+	// Latency on every call.
+	faults.Inject("ListShares", Fault{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	if _, err := service.ListShares(ctx, &ListFilter{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("call returned after %v, want at least 20ms", elapsed)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := service.ListShares(cancelled, &ListFilter{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"testing"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestFakeShareStates(t *testing.T) {
+	ctx := context.Background()
+	instance := &MultishareInstance{Name: "instance-1", Project: defaultProject, Location: defaultRegion, CapacityBytes: 1 * util.Tb}
+	service, err := NewFakeServiceForMultishare([]*MultishareInstance{instance}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create fake service: %v", err)
+	}
+	share := &Share{Name: "share-1", Parent: instance, CapacityBytes: 100 * util.Gb}
+	expectState := func(step, expected string) {
+		t.Helper()
+		shares, err := service.ListShares(ctx, &ListFilter{Project: defaultProject, Location: defaultRegion, InstanceName: instance.Name})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		var state string
+		for _, s := range shares {
+			if s.Name == share.Name {
+				state = s.State
+			}
+		}
+		if state != expected {
+			t.Errorf("%s: got share state %q, expected %q", step, state, expected)
+		}
+	}
+
+	op, err := service.StartCreateShareOp(ctx, share)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectState("create started", ShareStateCreating)
+	if err := service.WaitForOpWithOpts(ctx, op.Name, PollOpts{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectState("create done", ShareStateReady)
+
+	op, err = service.StartDeleteShareOp(ctx, share)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectState("delete started", ShareStateDeleting)
+	if _, err := service.GetOp(ctx, op.Name); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectState("delete done", "")
+}
@@ -25,11 +25,14 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -49,15 +52,48 @@ const (
 type PollOpts struct {
 	Interval time.Duration
 	Timeout  time.Duration
+	// SlowInterval, if larger than Interval, is the poll interval used once the operation
+	// has been running for SlowdownAfter.
+	SlowInterval  time.Duration
+	SlowdownAfter time.Duration
 }
+
+// OpPollConfig configures how the service polls long-running operations. Operations are polled
+// every Interval during the first SlowdownAfter, and every SlowInterval afterwards. A zero
+// SlowdownAfter disables adaptive polling.
+type OpPollConfig struct {
+	Interval      time.Duration
+	SlowInterval  time.Duration
+	SlowdownAfter time.Duration
+}
+
+// DefaultOpPollConfig polls fast during the first minute, when most share operations complete,
+// and backs off afterwards to save API quota on long running instance operations.
+var DefaultOpPollConfig = OpPollConfig{
+	Interval:      5 * time.Second,
+	SlowInterval:  30 * time.Second,
+	SlowdownAfter: 1 * time.Minute,
+}
+
 type NfsExportOptions struct {
 	AccessMode string   `json:"accessMode,omitempty"`
 	AnonGid    int64    `json:"anonGid,omitempty,string"`
 	AnonUid    int64    `json:"anonUid,omitempty,string"`
 	IpRanges   []string `json:"ipRanges,omitempty"`
 	SquashMode string   `json:"squashMode,omitempty"`
+	// SecurityFlavors are the security flavors of the mounts of the clients, e.g. KRB5, AUTH_SYS
+	// if empty.
+	SecurityFlavors []string `json:"securityFlavors,omitempty"`
 }
 
+// The states of a share. A share holds its capacity on its instance from the start of its
+// create op until its delete op is done.
+const (
+	ShareStateCreating = "CREATING"
+	ShareStateReady    = "READY"
+	ShareStateDeleting = "DELETING"
+)
+
 type Share struct {
 	Name             string              // only the share name
 	Parent           *MultishareInstance // parent captures the project, location details.
@@ -83,6 +119,10 @@ type MultishareInstance struct {
 	KmsKeyName         string
 	Description        string
 	MaxShareCount      int
+	// StatusMessage is the additional information about the state of the instance, if any.
+	StatusMessage string
+	// SuspensionReasons are the reasons of a SUSPENDED instance, e.g. KMS_KEY_ISSUE.
+	SuspensionReasons []string
 }
 
 func (i *MultishareInstance) String() string {
@@ -93,6 +133,8 @@ type ListFilter struct {
 	Project      string
 	Location     string
 	InstanceName string
+	// OpFilter is a server-side filter expression applied by ListOps, e.g. "done=false".
+	OpFilter string
 }
 
 type ServiceInstance struct {
@@ -107,6 +149,8 @@ type ServiceInstance struct {
 	KmsKeyName       string
 	BackupSource     string
 	NfsExportOptions []*NfsExportOptions
+	// Protocol is the NFS protocol of the instance, e.g. NFS_V4_1, NFS_V3 if empty.
+	Protocol string
 }
 
 type Volume struct {
@@ -127,6 +171,18 @@ type Backup struct {
 	SourceShare    string
 }
 
+// OperationProgress is the progress of a long-running operation on an instance, as reported in
+// its metadata. Filestore reports no completion percentage, only a status detail.
+type OperationProgress struct {
+	Name         string
+	StartTime    time.Time
+	StatusDetail string
+	Done         bool
+	// EndTime and Err are set once the operation is done, Err if it failed.
+	EndTime time.Time
+	Err     error
+}
+
 type BackupInfo struct {
 	Name               string
 	SourceVolumeId     string
@@ -161,10 +217,15 @@ type Service interface {
 	GetInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error)
 	ListInstances(ctx context.Context, obj *ServiceInstance) ([]*ServiceInstance, error)
 	ResizeInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error)
+	RestoreInstance(ctx context.Context, obj *ServiceInstance, backupUri string) (*ServiceInstance, error)
+	PromoteReplica(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error)
 	GetBackup(ctx context.Context, backupUri string) (*Backup, error)
 	CreateBackup(ctx context.Context, backupInfo *BackupInfo) (*filev1beta1.Backup, error)
 	DeleteBackup(ctx context.Context, backupId string) error
+	// ListBackups returns the backups, of all the locations of its project, of the instance.
+	ListBackups(ctx context.Context, obj *ServiceInstance) ([]*Backup, error)
 	HasOperations(ctx context.Context, obj *ServiceInstance, operationType string, done bool) (bool, error)
+	GetOperationProgress(ctx context.Context, obj *ServiceInstance, operationType string) (*OperationProgress, error)
 	// Multishare ops
 	GetMultishareInstance(ctx context.Context, obj *MultishareInstance) (*MultishareInstance, error)
 	ListMultishareInstances(ctx context.Context, filter *ListFilter) ([]*MultishareInstance, error)
@@ -180,6 +241,8 @@ type Service interface {
 	GetOp(ctx context.Context, op string) (*filev1beta1multishare.Operation, error)
 	IsOpDone(op *filev1beta1multishare.Operation) (bool, error)
 	ListOps(ctx context.Context, resource *ListFilter) ([]*filev1beta1multishare.Operation, error)
+	// ListLocations returns the IDs of the zones and regions Filestore is available in for the project.
+	ListLocations(ctx context.Context, project string) ([]string, error)
 }
 
 type gcfsServiceManager struct {
@@ -193,6 +256,18 @@ type gcfsServiceManager struct {
 	multishareInstancesService       *filev1beta1multishare.ProjectsLocationsInstancesService
 	multishareInstancesSharesService *filev1beta1multishare.ProjectsLocationsInstancesSharesService
 	multishareOperationsServices     *filev1beta1multishare.ProjectsLocationsOperationsService
+
+	pollConfig OpPollConfig
+
+	// locations caches the Filestore locations of each project for locationsCacheTTL, they
+	// rarely change and are checked on every CreateVolume call.
+	locationsMux sync.Mutex
+	locations    map[string]*locationListEntry
+}
+
+type locationListEntry struct {
+	locations []string
+	expiry    time.Time
 }
 
 const (
@@ -205,7 +280,12 @@ const (
 	// Patch update masks
 	fileShareUpdateMask          = "file_shares"
 	multishareCapacityUpdateMask = "capacity_gb"
+	multishareLabelsUpdateMask   = "labels"
 	prodBasePath                 = "https://file.googleapis.com/"
+	// Page size used when listing operations.
+	opsListPageSize = 500
+	// locationsCacheTTL is how long the Filestore locations of a project are cached.
+	locationsCacheTTL = time.Hour
 )
 
 var _ Service = &gcfsServiceManager{}
@@ -215,7 +295,10 @@ var (
 	shareUriRegex    = regexp.MustCompile(`^projects/([^/]+)/locations/([^/]+)/instances/([^/]+)/shares/([^/]+)$`)
 )
 
-func NewGCFSService(version string, client *http.Client, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint string) (Service, error) {
+func NewGCFSService(version string, client *http.Client, primaryFilestoreServiceEndpoint, testFilestoreServiceEndpoint string, pollConfig OpPollConfig) (Service, error) {
+	if pollConfig.Interval <= 0 {
+		pollConfig = DefaultOpPollConfig
+	}
 	ctx := context.Background()
 
 	fsOpts := []option.ClientOption{
@@ -256,6 +339,8 @@ func NewGCFSService(version string, client *http.Client, primaryFilestoreService
 		multishareInstancesService:       filev1beta1multishare.NewProjectsLocationsInstancesService(fileMultishareService),
 		multishareInstancesSharesService: filev1beta1multishare.NewProjectsLocationsInstancesSharesService(fileMultishareService),
 		multishareOperationsServices:     filev1beta1multishare.NewProjectsLocationsOperationsService(fileMultishareService),
+		pollConfig:                       pollConfig,
+		locations:                        make(map[string]*locationListEntry),
 	}, nil
 }
 
@@ -281,6 +366,7 @@ func (manager *gcfsServiceManager) CreateInstance(ctx context.Context, obj *Serv
 		KmsKeyName: obj.KmsKeyName,
 		Labels:     obj.Labels,
 		State:      obj.State,
+		Protocol:   obj.Protocol,
 	}
 
 	klog.V(4).Infof("Creating instance %q: location %v, tier %q, capacity %v, network %q, ipRange %q, connectMode %q, KmsKeyName %q, labels %v backup source %q",
@@ -357,6 +443,7 @@ func cloudInstanceToServiceInstance(instance *filev1beta1.Instance) (*ServiceIns
 		Labels:       instance.Labels,
 		State:        instance.State,
 		BackupSource: instance.FileShares[0].SourceBackup,
+		Protocol:     instance.Protocol,
 	}, nil
 }
 
@@ -494,6 +581,55 @@ func (manager *gcfsServiceManager) ResizeInstance(ctx context.Context, obj *Serv
 	return instance, nil
 }
 
+// RestoreInstance restores a backup in place to the file share of an existing instance,
+// replacing its content.
+func (manager *gcfsServiceManager) RestoreInstance(ctx context.Context, obj *ServiceInstance, backupUri string) (*ServiceInstance, error) {
+	instanceuri := instanceURI(obj.Project, obj.Location, obj.Name)
+	klog.V(4).Infof("Restoring backup %s to file share %s of instance %s", backupUri, obj.Volume.Name, instanceuri)
+	op, err := manager.instancesService.Restore(instanceuri, &filev1beta1.RestoreInstanceRequest{
+		FileShare:    obj.Volume.Name,
+		SourceBackup: backupUri,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("restore operation failed: %w", err)
+	}
+
+	klog.V(4).Infof("For instance %s, waiting for restore op %v to complete", instanceuri, op.Name)
+	err = manager.waitForOp(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("WaitFor restore op %s failed: %w", op.Name, err)
+	}
+
+	instance, err := manager.GetInstance(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance after restore: %w", err)
+	}
+	return instance, nil
+}
+
+// PromoteReplica promotes a replica instance to a standalone instance serving its own file
+// share, and waits for the promotion to complete.
+func (manager *gcfsServiceManager) PromoteReplica(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	instanceuri := instanceURI(obj.Project, obj.Location, obj.Name)
+	klog.V(4).Infof("Promoting replica instance %s", instanceuri)
+	op, err := manager.instancesService.PromoteReplica(instanceuri, &filev1beta1.PromoteReplicaRequest{}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("promote replica operation failed: %w", err)
+	}
+
+	klog.V(4).Infof("For instance %s, waiting for promote replica op %v to complete", instanceuri, op.Name)
+	err = manager.waitForOp(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("WaitFor promote replica op %s failed: %w", op.Name, err)
+	}
+
+	instance, err := manager.GetInstance(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance after promotion: %w", err)
+	}
+	return instance, nil
+}
+
 func (manager *gcfsServiceManager) GetBackup(ctx context.Context, backupUri string) (*Backup, error) {
 	backup, err := manager.backupService.Get(backupUri).Context(ctx).Do()
 	if err != nil {
@@ -554,14 +690,203 @@ func (manager *gcfsServiceManager) DeleteBackup(ctx context.Context, backupId st
 	return nil
 }
 
+func (manager *gcfsServiceManager) ListBackups(ctx context.Context, obj *ServiceInstance) ([]*Backup, error) {
+	// - indicates we are looking for backups in all the locations for a project
+	lCall := manager.backupService.List(locationURI(obj.Project, "-")).Context(ctx)
+	nextPageToken := "pageToken"
+	var backups []*Backup
+
+	for nextPageToken != "" {
+		resp, err := lCall.Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, backup := range resp.Backups {
+			// The source instance is matched by location and name, its project may be
+			// reported by number.
+			if !IsBackupOfInstance(backup.SourceInstance, obj) {
+				continue
+			}
+			backups = append(backups, &Backup{
+				Backup:         backup,
+				SourceInstance: backup.SourceInstance,
+				SourceShare:    backup.SourceFileShare,
+			})
+		}
+		nextPageToken = resp.NextPageToken
+		lCall.PageToken(nextPageToken)
+	}
+	return backups, nil
+}
+
+// IsBackupOfInstance returns true if the source instance URI of a backup is the instance, matched
+// by location and name.
+func IsBackupOfInstance(sourceInstance string, obj *ServiceInstance) bool {
+	_, location, name, err := GetInstanceNameFromURI(sourceInstance)
+	return err == nil && location == obj.Location && name == obj.Name
+}
+
+// opWaitTimeoutKey is the context key of the deadline of the waits on operations.
+type opWaitTimeoutKey struct{}
+
+// WithOpWaitTimeout returns a context whose waits on operations end after timeout, instead of
+// the default timeout of the wait, with an OpPendingError.
+func WithOpWaitTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, opWaitTimeoutKey{}, timeout)
+}
+
+// OpWaitTimeout returns the timeout of the waits on operations set on the context, or
+// defaultTimeout if none is set.
+func OpWaitTimeout(ctx context.Context, defaultTimeout time.Duration) time.Duration {
+	if timeout, ok := ctx.Value(opWaitTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+		return timeout
+	}
+	return defaultTimeout
+}
+
 func (manager *gcfsServiceManager) waitForOp(ctx context.Context, op *filev1beta1.Operation) error {
-	return wait.Poll(5*time.Second, 5*time.Minute, func() (bool, error) {
+	opts := PollOpts{
+		Interval:      manager.pollConfig.Interval,
+		Timeout:       OpWaitTimeout(ctx, 5*time.Minute),
+		SlowInterval:  manager.pollConfig.SlowInterval,
+		SlowdownAfter: manager.pollConfig.SlowdownAfter,
+	}
+	return pendingOpError(op.Name, pollOp(ctx, opts, func() (bool, error) {
 		pollOp, err := manager.operationsService.Get(op.Name).Context(ctx).Do()
 		if err != nil {
 			return false, err
 		}
 		return isOpDone(pollOp)
-	})
+	}))
+}
+
+// OpPendingError is returned by a wait on an operation which ends, on the timeout of the wait or
+// with the context of the request, while the operation keeps running. The wait can be resumed
+// with the name of the operation instead of starting another operation.
+type OpPendingError struct {
+	OpName string
+	Err    error
+}
+
+func (e *OpPendingError) Error() string {
+	return fmt.Sprintf("operation %s still running: %v", e.OpName, e.Err)
+}
+
+func (e *OpPendingError) Unwrap() error {
+	return e.Err
+}
+
+// IsOpPendingErr returns true if the wait on an operation ended before the operation.
+func IsOpPendingErr(err error) bool {
+	var pendingErr *OpPendingError
+	return errors.As(err, &pendingErr)
+}
+
+// pendingOpError returns the error of a wait on an operation, as an OpPendingError if the wait
+// ended before the operation.
+func pendingOpError(opName string, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, wait.ErrWaitTimeout) {
+		return &OpPendingError{OpName: opName, Err: err}
+	}
+	return err
+}
+
+// OpFailedError is the error of an operation which completed with an error. It keeps the name
+// and target of the operation and the reason of the API error, passed on by StatusError as the
+// ErrorInfo detail of the gRPC status, so that a failure can be traced to the exact operation,
+// e.g. in a support case.
+type OpFailedError struct {
+	OpName  string
+	Target  string
+	Code    int64
+	Message string
+	Reason  string
+}
+
+func (e *OpFailedError) Error() string {
+	return fmt.Sprintf("operation %v failed (%v): %v", e.OpName, e.Code, e.Message)
+}
+
+// errorInfo returns the ErrorInfo detail of the error, its reason defaulting to the name of
+// the code of the error.
+func (e *OpFailedError) errorInfo() *errdetails.ErrorInfo {
+	reason := e.Reason
+	if reason == "" {
+		reason = code.Code_name[int32(e.Code)]
+	}
+	return &errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: prodEndpoint,
+		Metadata: map[string]string{
+			"operation": e.OpName,
+			"target":    e.Target,
+		},
+	}
+}
+
+// opFailedError returns the OpFailedError of an operation which completed with an error. The
+// target and reason are left empty if the metadata or the details of the error can't be read.
+func opFailedError(op *filev1beta1.Operation) error {
+	err := &OpFailedError{
+		OpName:  op.Name,
+		Code:    op.Error.Code,
+		Message: op.Error.Message,
+	}
+	var meta filev1beta1.OperationMetadata
+	if op.Metadata != nil && json.Unmarshal(op.Metadata, &meta) == nil {
+		err.Target = meta.Target
+	}
+	for _, detail := range op.Error.Details {
+		var info struct {
+			Type   string `json:"@type"`
+			Reason string `json:"reason"`
+		}
+		if json.Unmarshal(detail, &info) == nil && strings.HasSuffix(info.Type, "google.rpc.ErrorInfo") {
+			err.Reason = info.Reason
+			break
+		}
+	}
+	return err
+}
+
+// pollOp calls condition at the poll intervals of opts until it returns true or an error, the
+// timeout expires or the context is done. Like wait.Poll, the first check happens after the
+// first interval and wait.ErrWaitTimeout is returned on timeout.
+func pollOp(ctx context.Context, opts PollOpts, condition wait.ConditionFunc) error {
+	start := time.Now()
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= opts.Timeout {
+			return wait.ErrWaitTimeout
+		}
+		interval := nextPollInterval(opts, elapsed)
+		if remaining := opts.Timeout - elapsed; interval > remaining {
+			interval = remaining
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// nextPollInterval returns the interval to wait before the next poll of an operation that
+// has been running for elapsed.
+func nextPollInterval(opts PollOpts, elapsed time.Duration) time.Duration {
+	if opts.SlowdownAfter > 0 && elapsed >= opts.SlowdownAfter && opts.SlowInterval > opts.Interval {
+		return opts.SlowInterval
+	}
+	return opts.Interval
 }
 
 // TODO: unify this function behavior with IsOpDone
@@ -570,7 +895,7 @@ func isOpDone(op *filev1beta1.Operation) (bool, error) {
 		return false, nil
 	}
 	if op.Error != nil {
-		return true, fmt.Errorf("operation %v failed (%v): %v", op.Name, op.Error.Code, op.Error.Message)
+		return true, opFailedError(op)
 	}
 	return op.Done, nil
 }
@@ -581,7 +906,7 @@ func (manager *gcfsServiceManager) IsOpDone(op *filev1beta1multishare.Operation)
 		return true, nil
 	}
 	if op.Error != nil {
-		return true, fmt.Errorf("operation %v failed (%v): %v", op.Name, op.Error.Code, op.Error.Message)
+		return true, opFailedError(op)
 	}
 	return op.Done, nil
 }
@@ -609,6 +934,24 @@ func GetInstanceNameFromURI(uri string) (project, location, name string, err err
 	return substrings[1], substrings[2], substrings[3], nil
 }
 
+func GetBackupNameFromURI(uri string) (project, location, name string, err error) {
+	var uriRegex = regexp.MustCompile(`^projects/([^/]+)/locations/([^/]+)/backups/([^/]+)$`)
+
+	substrings := uriRegex.FindStringSubmatch(uri)
+	if substrings == nil {
+		err = fmt.Errorf("failed to parse uri %v", uri)
+		return
+	}
+	return substrings[1], substrings[2], substrings[3], nil
+}
+
+// IsAlreadyExistsErr returns whether err is the conflict error of the creation of a resource
+// whose name is taken.
+func IsAlreadyExistsErr(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict
+}
+
 func IsNotFoundErr(err error) bool {
 	var apiErr *googleapi.Error
 	if !errors.As(err, &apiErr) {
@@ -727,6 +1070,10 @@ func codeForError(err error) *codes.Code {
 	if errCode := isContextError(err); errCode != nil {
 		return errCode
 	}
+	if IsOpPendingErr(err) {
+		// The operation keeps running, the retries of the request resume the wait.
+		return util.ErrCodePtr(codes.DeadlineExceeded)
+	}
 
 	return util.ErrCodePtr(codes.Internal)
 }
@@ -737,7 +1084,70 @@ func StatusError(err error) error {
 	if err == nil {
 		return nil
 	}
-	return status.Error(*codeForError(err), err.Error())
+	st := status.New(*codeForError(err), err.Error())
+	var opErr *OpFailedError
+	if errors.As(err, &opErr) {
+		if withDetails, detailsErr := st.WithDetails(opErr.errorInfo()); detailsErr == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+// Reasons of the instance creations failed because the VPC network of the instance has run
+// out of the resources of its peering with the Filestore service.
+const (
+	// ReasonRangesExhausted is the reason of the errors of the allocated IP ranges of the
+	// network having no free range of the size of the instance.
+	ReasonRangesExhausted = "RANGES_EXHAUSTED"
+	// ReasonPeeringLimitExceeded is the reason of the errors of the network having reached
+	// its limit of VPC peerings, or of routes imported from its peers.
+	ReasonPeeringLimitExceeded = "PEERING_LIMIT_EXCEEDED"
+)
+
+var (
+	rangesExhaustedPattern = regexp.MustCompile(`(?i)ranges?_exhausted|ip_space_exhausted|no (free |available )?ip ranges?|(cannot|could not|unable to) find (a |an )?(free |available )?ip range|ip (space|ranges?)\b[^.]*\bexhausted|not enough (free )?ip (space|addresses)`)
+	peeringLimitPattern    = regexp.MustCompile(`(?i)peering_limit_exceeded|peerings_per_network|(maximum|max) number of (vpc )?(network )?peering|peering (connection |group )?limit|peering_routes_per_network|imported routes limit`)
+)
+
+// NetworkExhaustionReason returns ReasonRangesExhausted or ReasonPeeringLimitExceeded if err
+// is the error of an instance creation failed because the private services access ranges of
+// its network are exhausted or the network reached its peering limits, empty otherwise.
+func NetworkExhaustionReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	var opErr *OpFailedError
+	if errors.As(err, &opErr) {
+		msg = opErr.Reason + " " + msg
+	}
+	switch {
+	case rangesExhaustedPattern.MatchString(msg):
+		return ReasonRangesExhausted
+	case peeringLimitPattern.MatchString(msg):
+		return ReasonPeeringLimitExceeded
+	}
+	return ""
+}
+
+// zonalCapacityPattern matches the errors of the instance creations failed because their zone
+// is out of capacity for the tier, as opposed to the quota errors of the project.
+var zonalCapacityPattern = regexp.MustCompile(`(?i)zone_resource_pool_exhausted|stockout|(zone|location) [a-z0-9-]+ does not have (enough|sufficient) (resources|capacity)|(insufficient|not enough|out of) (zonal )?capacity`)
+
+// IsZonalCapacityErr returns whether err is the error of an instance creation failed because
+// the zone of the instance is out of capacity, so that the instance may be created in another
+// zone.
+func IsZonalCapacityErr(err error) bool {
+	if err == nil || NetworkExhaustionReason(err) != "" {
+		return false
+	}
+	msg := err.Error()
+	var opErr *OpFailedError
+	if errors.As(err, &opErr) {
+		msg = opErr.Reason + " " + msg
+	}
+	return zonalCapacityPattern.MatchString(msg)
 }
 
 // This function will process an existing backup
@@ -784,6 +1194,28 @@ func CheckBackupExists(backupInfo *Backup, err error) (bool, error) {
 	return true, nil
 }
 
+// GetSourceBackup returns the backup to restore to a new volume of the given project. The
+// backup may live in another project, e.g. a central backup project, Filestore then restores
+// it with its full URI as long as the service account of the driver can read the backups of
+// that project.
+func GetSourceBackup(ctx context.Context, service Service, backupUri, project string) (*Backup, error) {
+	backupProject, location, name, err := GetBackupNameFromURI(backupUri)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Unsupported volume content source %v", backupUri)
+	}
+	backup, err := service.GetBackup(ctx, backupURI(backupProject, location, name))
+	if err != nil {
+		if backupProject != project && *codeForError(err) == codes.PermissionDenied {
+			return nil, status.Errorf(codes.PermissionDenied, "backup %s is in project %s, the driver needs read access to the Filestore backups of that project to restore it in project %s: %v", backupUri, backupProject, project, err)
+		}
+		return nil, StatusError(err)
+	}
+	if backupProject != project {
+		klog.V(4).Infof("Restoring backup %s of project %s in project %s", backupUri, backupProject, project)
+	}
+	return backup, nil
+}
+
 // This function returns the backup URI, the region that was picked to be the backup resource location and error.
 func CreateBackupURI(serviceLocation, project, backupName, backupLocation string) (string, string, error) {
 	region, err := deduceRegion(serviceLocation, backupLocation)
@@ -848,6 +1280,61 @@ func (manager *gcfsServiceManager) HasOperations(ctx context.Context, obj *Servi
 	return len(totalFilteredOps) > 0, nil
 }
 
+// GetOperationProgress returns the progress of the most recent operation of the given type on
+// the instance, nil if there is none.
+func (manager *gcfsServiceManager) GetOperationProgress(ctx context.Context, obj *ServiceInstance, operationType string) (*OperationProgress, error) {
+	uri := instanceURI(obj.Project, obj.Location, obj.Name)
+	var latest *OperationProgress
+	var nextToken string
+	for {
+		resp, err := manager.operationsService.List(locationURI(obj.Project, obj.Location)).PageToken(nextToken).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("list operations for instance %q, token %q failed: %w", uri, nextToken, err)
+		}
+		for _, op := range resp.Operations {
+			if op.Metadata == nil {
+				continue
+			}
+			var meta filev1beta1.OperationMetadata
+			if err := json.Unmarshal(op.Metadata, &meta); err != nil {
+				return nil, err
+			}
+			if meta.Target != uri || meta.Verb != operationType {
+				continue
+			}
+			progress, err := operationProgress(op, &meta)
+			if err != nil {
+				return nil, err
+			}
+			if latest == nil || progress.StartTime.After(latest.StartTime) {
+				latest = progress
+			}
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		nextToken = resp.NextPageToken
+	}
+	return latest, nil
+}
+
+func operationProgress(op *filev1beta1.Operation, meta *filev1beta1.OperationMetadata) (*OperationProgress, error) {
+	progress := &OperationProgress{Name: op.Name, StatusDetail: meta.StatusDetail, Done: op.Done}
+	var err error
+	if progress.StartTime, err = time.Parse(time.RFC3339Nano, meta.CreateTime); err != nil {
+		return nil, fmt.Errorf("failed to parse create time of operation %s: %w", op.Name, err)
+	}
+	if meta.EndTime != "" {
+		if progress.EndTime, err = time.Parse(time.RFC3339Nano, meta.EndTime); err != nil {
+			return nil, fmt.Errorf("failed to parse end time of operation %s: %w", op.Name, err)
+		}
+	}
+	if op.Error != nil {
+		progress.Err = status.Error(codes.Code(op.Error.Code), op.Error.Message)
+	}
+	return progress, nil
+}
+
 func ApplyFilter(ops []*filev1beta1.Operation, uri string, opType string, done bool) ([]*filev1beta1.Operation, error) {
 	var res []*filev1beta1.Operation
 	for _, op := range ops {
@@ -954,7 +1441,12 @@ func (manager *gcfsServiceManager) StartResizeMultishareInstanceOp(ctx context.C
 		Labels:            obj.Labels,
 		Description:       obj.Description,
 	}
-	op, err := manager.multishareInstancesService.Patch(instanceuri, targetinstance).UpdateMask(multishareCapacityUpdateMask).Context(ctx).Do()
+	updateMask := multishareCapacityUpdateMask
+	if len(obj.Labels) > 0 {
+		// The labels record the driver version which last resized the instance.
+		updateMask += "," + multishareLabelsUpdateMask
+	}
+	op, err := manager.multishareInstancesService.Patch(instanceuri, targetinstance).UpdateMask(updateMask).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("patch operation failed: %w for instance %+v", err, targetinstance)
 	}
@@ -1007,13 +1499,24 @@ func (manager *gcfsServiceManager) StartResizeShareOp(ctx context.Context, share
 }
 
 func (manager *gcfsServiceManager) WaitForOpWithOpts(ctx context.Context, op string, opts PollOpts) error {
-	return wait.Poll(opts.Interval, opts.Timeout, func() (bool, error) {
+	if manager.pollConfig.SlowdownAfter > 0 && opts.SlowdownAfter == 0 {
+		// Poll fast at first and never slower than requested by the caller afterwards.
+		opts.SlowInterval = manager.pollConfig.SlowInterval
+		if opts.SlowInterval < opts.Interval {
+			opts.SlowInterval = opts.Interval
+		}
+		opts.SlowdownAfter = manager.pollConfig.SlowdownAfter
+		if manager.pollConfig.Interval > 0 && manager.pollConfig.Interval < opts.Interval {
+			opts.Interval = manager.pollConfig.Interval
+		}
+	}
+	return pendingOpError(op, pollOp(ctx, opts, func() (bool, error) {
 		pollOp, err := manager.multishareOperationsServices.Get(op).Context(ctx).Do()
 		if err != nil {
 			return false, err
 		}
 		return manager.IsOpDone(pollOp)
-	})
+	}))
 }
 
 func (manager *gcfsServiceManager) GetOp(ctx context.Context, op string) (*filev1beta1multishare.Operation, error) {
@@ -1202,6 +1705,8 @@ func cloudInstanceToMultishareInstance(instance *filev1beta1multishare.Instance)
 		CapacityStepSizeGb: instance.CapacityStepSizeGb,
 		Description:        instance.Description,
 		MaxShareCount:      int(instance.MaxShareCount),
+		StatusMessage:      instance.StatusMessage,
+		SuspensionReasons:  instance.SuspensionReasons,
 	}, nil
 }
 
@@ -1234,7 +1739,20 @@ func isValidEndpoint(endpoint string) bool {
 }
 
 func (manager *gcfsServiceManager) ListOps(ctx context.Context, filter *ListFilter) ([]*filev1beta1multishare.Operation, error) {
-	lCall := manager.multishareOperationsServices.List(locationURI(filter.Project, filter.Location)).Context(ctx)
+	ops, err := manager.listOps(ctx, filter.Project, filter.Location, filter.OpFilter)
+	if err != nil && filter.OpFilter != "" && isBadRequestErr(err) {
+		// Fall back to listing all ops, callers filter the result client side anyway.
+		klog.Warningf("Listing operations with filter %q failed, retrying without filter: %v", filter.OpFilter, err)
+		return manager.listOps(ctx, filter.Project, filter.Location, "")
+	}
+	return ops, err
+}
+
+func (manager *gcfsServiceManager) listOps(ctx context.Context, project, location, opFilter string) ([]*filev1beta1multishare.Operation, error) {
+	lCall := manager.multishareOperationsServices.List(locationURI(project, location)).PageSize(opsListPageSize).Context(ctx)
+	if opFilter != "" {
+		lCall = lCall.Filter(opFilter)
+	}
 	nextPageToken := "pageToken"
 	var activeOperations []*filev1beta1multishare.Operation
 
@@ -1252,6 +1770,46 @@ func (manager *gcfsServiceManager) ListOps(ctx context.Context, filter *ListFilt
 	return activeOperations, nil
 }
 
+func (manager *gcfsServiceManager) ListLocations(ctx context.Context, project string) ([]string, error) {
+	manager.locationsMux.Lock()
+	defer manager.locationsMux.Unlock()
+	entry := manager.locations[project]
+	if entry != nil && time.Now().Before(entry.expiry) {
+		return entry.locations, nil
+	}
+
+	lCall := manager.fileService.Projects.Locations.List("projects/" + project).Context(ctx)
+	nextPageToken := "pageToken"
+	var locations []string
+	for nextPageToken != "" {
+		resp, err := lCall.Do()
+		if err != nil {
+			if entry != nil {
+				// Keep using the expired list rather than failing the callers on a transient error.
+				klog.Warningf("Failed to list the Filestore locations of project %s, using the list cached until %v: %v", project, entry.expiry, err)
+				return entry.locations, nil
+			}
+			return nil, err
+		}
+		for _, location := range resp.Locations {
+			locations = append(locations, location.LocationId)
+		}
+		nextPageToken = resp.NextPageToken
+		lCall.PageToken(nextPageToken)
+	}
+	klog.V(4).Infof("Found %d Filestore locations in project %s", len(locations), project)
+	manager.locations[project] = &locationListEntry{locations: locations, expiry: time.Now().Add(locationsCacheTTL)}
+	return locations, nil
+}
+
+func isBadRequestErr(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == http.StatusBadRequest
+}
+
 func IsInstanceTarget(target string) bool {
 	return instanceUriRegex.MatchString(target)
 }
@@ -1293,11 +1851,12 @@ func extractNfsShareExportOptions(options []*NfsExportOptions) []*filev1beta1mul
 	for _, opt := range options {
 		filerOpts = append(filerOpts,
 			&filev1beta1multishare.NfsExportOptions{
-				AccessMode: opt.AccessMode,
-				AnonGid:    opt.AnonGid,
-				AnonUid:    opt.AnonUid,
-				IpRanges:   opt.IpRanges,
-				SquashMode: opt.SquashMode,
+				AccessMode:      opt.AccessMode,
+				AnonGid:         opt.AnonGid,
+				AnonUid:         opt.AnonUid,
+				IpRanges:        opt.IpRanges,
+				SquashMode:      opt.SquashMode,
+				SecurityFlavors: opt.SecurityFlavors,
 			})
 	}
 	return filerOpts
@@ -2,14 +2,21 @@ package file
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	filev1beta1 "google.golang.org/api/file/v1beta1"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
 )
 
@@ -566,6 +573,11 @@ func TestCodeForError(t *testing.T) {
 			err:             fmt.Errorf("unknown error"),
 			expectedErrCode: util.ErrCodePtr(codes.Internal),
 		},
+		{
+			name:            "wait timeout on a running operation",
+			err:             fmt.Errorf("create failed: %w", &OpPendingError{OpName: "op", Err: wait.ErrWaitTimeout}),
+			expectedErrCode: util.ErrCodePtr(codes.DeadlineExceeded),
+		},
 		{
 			name:            "404 googleapi error",
 			err:             &googleapi.Error{Code: http.StatusNotFound},
@@ -625,6 +637,129 @@ func TestStatusError(t *testing.T) {
 	}
 }
 
+func TestStatusErrorOpDetails(t *testing.T) {
+	cases := []struct {
+		name           string
+		op             *filev1beta1.Operation
+		expectedTarget string
+		expectedReason string
+	}{
+		{
+			name: "error info detail",
+			op: &filev1beta1.Operation{
+				Name:     "projects/test-project/locations/us-central1/operations/op-1",
+				Metadata: []byte(`{"target": "projects/test-project/locations/us-central1/instances/fs-1"}`),
+				Error: &filev1beta1.Status{
+					Code:    8,
+					Message: "quota exceeded",
+					Details: []googleapi.RawMessage{[]byte(`{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "QUOTA_EXCEEDED"}`)},
+				},
+			},
+			expectedTarget: "projects/test-project/locations/us-central1/instances/fs-1",
+			expectedReason: "QUOTA_EXCEEDED",
+		},
+		{
+			name: "no metadata nor details",
+			op: &filev1beta1.Operation{
+				Name:  "projects/test-project/locations/us-central1/operations/op-1",
+				Error: &filev1beta1.Status{Code: 13, Message: "internal error"},
+			},
+			expectedReason: "INTERNAL",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			done, err := isOpDone(tc.op)
+			if !done || err == nil {
+				t.Fatalf("got done %v, error %v, expected a failed operation", done, err)
+			}
+			st, _ := status.FromError(StatusError(fmt.Errorf("create instance failed: %w", err)))
+			if len(st.Details()) != 1 {
+				t.Fatalf("got details %v, expected an error info", st.Details())
+			}
+			info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+			if !ok {
+				t.Fatalf("got detail %T, expected an error info", st.Details()[0])
+			}
+			if info.Reason != tc.expectedReason {
+				t.Errorf("got reason %q, expected %q", info.Reason, tc.expectedReason)
+			}
+			if info.Metadata["operation"] != tc.op.Name || info.Metadata["target"] != tc.expectedTarget {
+				t.Errorf("got metadata %v, expected operation %q and target %q", info.Metadata, tc.op.Name, tc.expectedTarget)
+			}
+			if !strings.Contains(st.Message(), tc.op.Name) {
+				t.Errorf("got message %q, expected the operation name in it", st.Message())
+			}
+		})
+	}
+}
+
+// forbiddenBackupService fails to get any backup with a 403 error.
+type forbiddenBackupService struct {
+	Service
+}
+
+func (forbiddenBackupService) GetBackup(ctx context.Context, backupUri string) (*Backup, error) {
+	return nil, &googleapi.Error{Code: http.StatusForbidden}
+}
+
+func TestGetSourceBackup(t *testing.T) {
+	s, err := NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	for _, project := range []string{"test-project", "backup-project"} {
+		_, err := s.CreateBackup(context.Background(), &BackupInfo{
+			SourceInstanceName: "test-instance",
+			SourceShare:        "test-share",
+			SourceVolumeId:     "modeInstance/us-central1-c/test-instance/test-share",
+			BackupURI:          backupURI(project, "us-central1", "test-backup"),
+		})
+		if err != nil {
+			t.Fatalf("failed to create backup: %v", err)
+		}
+	}
+
+	cases := []struct {
+		name         string
+		service      Service
+		uri          string
+		expectedCode codes.Code
+	}{
+		{
+			name:    "backup in the volume project",
+			service: s,
+			uri:     "projects/test-project/locations/us-central1/backups/test-backup",
+		},
+		{
+			name:    "backup in another project",
+			service: s,
+			uri:     "projects/backup-project/locations/us-central1/backups/test-backup",
+		},
+		{
+			name:         "backup in another project not readable",
+			service:      forbiddenBackupService{s},
+			uri:          "projects/backup-project/locations/us-central1/backups/test-backup",
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "bad backup uri",
+			service:      s,
+			uri:          "projects/backup-project/locations/us-central1/snapshots/test-backup",
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+	for _, test := range cases {
+		backup, err := GetSourceBackup(context.Background(), test.service, test.uri, "test-project")
+		if code := status.Code(err); code != test.expectedCode {
+			t.Errorf("test %v failed: got code %v, expected %v (error %v)", test.name, code, test.expectedCode, err)
+		}
+		if err == nil && backup.Backup.Name != test.uri {
+			t.Errorf("test %v failed: got backup %v, expected %v", test.name, backup.Backup.Name, test.uri)
+		}
+	}
+}
+
 func TestIsUserError(t *testing.T) {
 	cases := []struct {
 		name            string
@@ -693,3 +828,269 @@ func TestIsUserError(t *testing.T) {
 		}
 	}
 }
+
+func TestIsBadRequestErr(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "400 http error",
+			err:      &googleapi.Error{Code: http.StatusBadRequest},
+			expected: true,
+		},
+		{
+			name:     "wrapped 400 http error",
+			err:      fmt.Errorf("got error: %w", &googleapi.Error{Code: http.StatusBadRequest}),
+			expected: true,
+		},
+		{
+			name: "403 http error",
+			err:  &googleapi.Error{Code: http.StatusForbidden},
+		},
+		{
+			name: "non googleapi error",
+			err:  fmt.Errorf("INVALID_ARGUMENT"),
+		},
+	}
+
+	for _, test := range cases {
+		if got := isBadRequestErr(test.err); got != test.expected {
+			t.Errorf("test %v failed: got %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestNextPollInterval(t *testing.T) {
+	adaptive := PollOpts{Interval: 5 * time.Second, SlowInterval: 30 * time.Second, SlowdownAfter: time.Minute}
+	cases := []struct {
+		name    string
+		opts    PollOpts
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{
+			name:    "fast phase",
+			opts:    adaptive,
+			elapsed: 30 * time.Second,
+			want:    5 * time.Second,
+		},
+		{
+			name:    "slow phase",
+			opts:    adaptive,
+			elapsed: time.Minute,
+			want:    30 * time.Second,
+		},
+		{
+			name:    "adaptive polling disabled",
+			opts:    PollOpts{Interval: 5 * time.Second, SlowInterval: 30 * time.Second},
+			elapsed: time.Hour,
+			want:    5 * time.Second,
+		},
+		{
+			name:    "slow interval faster than interval",
+			opts:    PollOpts{Interval: time.Minute, SlowInterval: 30 * time.Second, SlowdownAfter: time.Minute},
+			elapsed: time.Hour,
+			want:    time.Minute,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nextPollInterval(tc.opts, tc.elapsed); got != tc.want {
+				t.Errorf("got interval %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOperationProgress(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		name      string
+		op        *filev1beta1.Operation
+		meta      *filev1beta1.OperationMetadata
+		want      *OperationProgress
+		expectErr bool
+	}{
+		{
+			name: "running",
+			op:   &filev1beta1.Operation{Name: "op"},
+			meta: &filev1beta1.OperationMetadata{CreateTime: "2024-01-02T03:04:05Z", StatusDetail: "Restoring"},
+			want: &OperationProgress{Name: "op", StartTime: start, StatusDetail: "Restoring"},
+		},
+		{
+			name: "failed",
+			op:   &filev1beta1.Operation{Name: "op", Done: true, Error: &filev1beta1.Status{Code: int64(codes.NotFound), Message: "backup not found"}},
+			meta: &filev1beta1.OperationMetadata{CreateTime: "2024-01-02T03:04:05Z", EndTime: "2024-01-02T04:04:05.5Z"},
+			want: &OperationProgress{Name: "op", StartTime: start, Done: true, EndTime: start.Add(time.Hour + 500*time.Millisecond), Err: status.Error(codes.NotFound, "backup not found")},
+		},
+		{
+			name:      "invalid create time",
+			op:        &filev1beta1.Operation{Name: "op"},
+			meta:      &filev1beta1.OperationMetadata{CreateTime: "yesterday"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := operationProgress(tc.op, tc.meta)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got progress %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Err != nil || tc.want.Err != nil {
+				if got.Err == nil || tc.want.Err == nil || got.Err.Error() != tc.want.Err.Error() {
+					t.Errorf("got error %v, want %v", got.Err, tc.want.Err)
+				}
+				got.Err, tc.want.Err = nil, nil
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got progress %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPendingOpError(t *testing.T) {
+	cases := []struct {
+		name    string
+		err     error
+		pending bool
+	}{
+		{
+			name:    "context cancelled",
+			err:     context.Canceled,
+			pending: true,
+		},
+		{
+			name:    "context deadline exceeded",
+			err:     fmt.Errorf("poll: %w", context.DeadlineExceeded),
+			pending: true,
+		},
+		{
+			name:    "wait timeout",
+			err:     wait.ErrWaitTimeout,
+			pending: true,
+		},
+		{
+			name: "operation failed",
+			err:  status.Error(codes.Internal, "operation failed"),
+		},
+		{
+			name: "no error",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := pendingOpError("op-1", tc.err)
+			if got := IsOpPendingErr(err); got != tc.pending {
+				t.Errorf("got pending %v, want %v for error %v", got, tc.pending, err)
+			}
+			if !errors.Is(err, tc.err) {
+				t.Errorf("got error %v, want it to wrap %v", err, tc.err)
+			}
+			if tc.pending && !strings.Contains(err.Error(), "op-1") {
+				t.Errorf("got error %q, want the operation name in it", err.Error())
+			}
+		})
+	}
+}
+
+func TestListLocations(t *testing.T) {
+	calls, fail := 0, false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/projects/test-project/locations") {
+			t.Errorf("got request path %s, expected the locations of the project", r.URL.Path)
+		}
+		if r.URL.Query().Get("pageToken") == "" {
+			fmt.Fprint(w, `{"locations": [{"locationId": "us-central1"}, {"locationId": "us-central1-c"}], "nextPageToken": "page2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"locations": [{"locationId": "us-east1"}]}`)
+	}))
+	defer server.Close()
+
+	service, err := NewGCFSService("test", server.Client(), server.URL+"/", "", OpPollConfig{})
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	expected := []string{"us-central1", "us-central1-c", "us-east1"}
+	for i := 0; i < 2; i++ {
+		locations, err := service.ListLocations(context.Background(), "test-project")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(locations, expected) {
+			t.Errorf("got locations %v, expected %v", locations, expected)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d list calls, expected the 2 pages listed once", calls)
+	}
+
+	// An expired list is still used if the locations can't be listed.
+	service.(*gcfsServiceManager).locations["test-project"].expiry = time.Now().Add(-time.Minute)
+	fail = true
+	locations, err := service.ListLocations(context.Background(), "test-project")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("got locations %v, expected the expired list %v", locations, expected)
+	}
+	if _, err := service.ListLocations(context.Background(), "other-project"); err == nil {
+		t.Errorf("expected an error listing the locations of a project not listed before")
+	}
+}
+
+func TestNetworkExhaustionReason(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{err: &OpFailedError{Code: 8, Message: "quota exceeded", Reason: "RANGES_EXHAUSTED"}, expected: ReasonRangesExhausted},
+		{err: &OpFailedError{Code: 9, Message: "Cannot find an available IP range of size /26 in the allocated ranges"}, expected: ReasonRangesExhausted},
+		{err: &googleapi.Error{Code: 400, Message: "The IP space of the allocated ranges has been exhausted"}, expected: ReasonRangesExhausted},
+		{err: &googleapi.Error{Code: 400, Message: "Network default reached the maximum number of VPC peering connections"}, expected: ReasonPeeringLimitExceeded},
+		{err: &OpFailedError{Code: 8, Message: "Quota PEERINGS_PER_NETWORK exceeded"}, expected: ReasonPeeringLimitExceeded},
+		{err: &googleapi.Error{Code: 400, Message: "invalid tier"}},
+		{err: &OpFailedError{Code: 8, Message: "quota exceeded", Reason: "RESOURCE_EXHAUSTED"}},
+		{},
+	}
+	for _, tc := range cases {
+		if reason := NetworkExhaustionReason(tc.err); reason != tc.expected {
+			t.Errorf("%v: got reason %q, expected %q", tc.err, reason, tc.expected)
+		}
+	}
+}
+
+func TestIsZonalCapacityErr(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{err: &OpFailedError{Code: 8, Message: "resources exhausted", Reason: "ZONE_RESOURCE_POOL_EXHAUSTED"}, expected: true},
+		{err: &OpFailedError{Code: 14, Message: "Zone us-central1-a does not have enough resources available to fulfill the request. Try a different zone, or try again later."}, expected: true},
+		{err: &googleapi.Error{Code: 503, Message: "Insufficient capacity for tier ZONAL"}, expected: true},
+		{err: &OpFailedError{Code: 8, Message: "Quota FILESTORE_CAPACITY exceeded", Reason: "RESOURCE_EXHAUSTED"}},
+		{err: &OpFailedError{Code: 8, Message: "out of capacity", Reason: "RANGES_EXHAUSTED"}},
+		{err: &googleapi.Error{Code: 400, Message: "invalid tier"}},
+		{},
+	}
+	for _, tc := range cases {
+		if got := IsZonalCapacityErr(tc.err); got != tc.expected {
+			t.Errorf("IsZonalCapacityErr(%v) = %v, expected %v", tc.err, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	filev1beta1 "google.golang.org/api/file/v1beta1"
+)
+
+const (
+	// DefaultLabelPrefix is the prefix of the keys of the labels applied by the driver, e.g.
+	// storage_gke_io_created-by.
+	DefaultLabelPrefix = "storage_gke_io"
+
+	// driverInstanceIDLabel is the key, after the label prefix, of the label recording the
+	// driver instance ID of the resources of a namespaced driver.
+	driverInstanceIDLabel = "driver-instance-id"
+)
+
+var (
+	labelPrefixPattern     = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+	labelValuePattern      = regexp.MustCompile(`^[a-z0-9_-]{1,63}$`)
+	maxLabelPrefixLen      = 63 - len("_"+driverInstanceIDLabel)
+	defaultLabelKeysPrefix = DefaultLabelPrefix + "_"
+)
+
+// labelNamespace rewrites the keys of the labels applied by the driver from DefaultLabelPrefix
+// to prefix, and records instanceID on the resources, so that the differently configured
+// drivers of a project, e.g. a test and a production driver, each only see the labels of their
+// own resources.
+type labelNamespace struct {
+	prefix     string
+	instanceID string
+}
+
+// apply returns the labels written to the Filestore API, the labels of the driver moved to the
+// prefix of the namespace. The instance ID is only recorded along other labels, so that the
+// updates without labels, e.g. the resizes, don't update them.
+func (n labelNamespace) apply(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return labels
+	}
+	namespaced := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		if strings.HasPrefix(k, defaultLabelKeysPrefix) {
+			k = n.prefix + "_" + strings.TrimPrefix(k, defaultLabelKeysPrefix)
+		}
+		namespaced[k] = v
+	}
+	if n.instanceID != "" {
+		namespaced[n.prefix+"_"+driverInstanceIDLabel] = n.instanceID
+	}
+	return namespaced
+}
+
+// strip returns the labels of a resource read from the Filestore API as the driver expects
+// them, under DefaultLabelPrefix. The labels of the driver on the resources of another
+// namespace are dropped, the ones under other prefixes are left as user labels, so that the
+// resource is not matched by the driver, e.g. as a multishare instance hosting new shares.
+func (n labelNamespace) strip(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return labels
+	}
+	own := n.owns(labels)
+	stripped := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if k, ok := n.stripKey(k, own); ok {
+			stripped[k] = v
+		}
+	}
+	return stripped
+}
+
+// dropped returns the labels of a resource read from the Filestore API which strip drops.
+func (n labelNamespace) dropped(labels map[string]string) map[string]string {
+	own := n.owns(labels)
+	dropped := map[string]string{}
+	for k, v := range labels {
+		if _, ok := n.stripKey(k, own); !ok {
+			dropped[k] = v
+		}
+	}
+	return dropped
+}
+
+// owns returns whether the labels are those of a resource of the namespace. The resources
+// without a driver instance ID, e.g. the ones created before --driver-instance-id was set, are
+// adopted, only the ones of another driver instance ID belong to another namespace.
+func (n labelNamespace) owns(labels map[string]string) bool {
+	id, ok := labels[n.prefix+"_"+driverInstanceIDLabel]
+	return !ok || id == n.instanceID
+}
+
+// stripKey returns the key under DefaultLabelPrefix of a label of a resource, and false if the
+// label is dropped.
+func (n labelNamespace) stripKey(k string, own bool) (string, bool) {
+	switch {
+	case k == n.prefix+"_"+driverInstanceIDLabel:
+		return "", false
+	case strings.HasPrefix(k, n.prefix+"_"):
+		if !own {
+			return "", false
+		}
+		return defaultLabelKeysPrefix + strings.TrimPrefix(k, n.prefix+"_"), true
+	case strings.HasPrefix(k, defaultLabelKeysPrefix):
+		// The labels of a driver of the default prefix.
+		return "", false
+	}
+	return k, true
+}
+
+// labelNamespacedService namespaces the labels of the resources read and written by the
+// driver, see labelNamespace.
+type labelNamespacedService struct {
+	Service
+	namespace labelNamespace
+}
+
+var _ Service = &labelNamespacedService{}
+
+// NewLabelNamespacedService returns a service applying the labels of the driver under prefix,
+// instead of DefaultLabelPrefix, and recording instanceID on the resources, so that the
+// resources of the other drivers of the project are ignored. The service is returned unchanged
+// if neither is set.
+func NewLabelNamespacedService(service Service, prefix, instanceID string) (Service, error) {
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	if prefix == DefaultLabelPrefix && instanceID == "" {
+		return service, nil
+	}
+	if !labelPrefixPattern.MatchString(prefix) || len(prefix) > maxLabelPrefixLen {
+		return nil, fmt.Errorf("invalid label prefix %q, expected at most %d lowercase letters, digits, underscores and dashes, starting with a letter", prefix, maxLabelPrefixLen)
+	}
+	if instanceID != "" && !labelValuePattern.MatchString(instanceID) {
+		return nil, fmt.Errorf("invalid driver instance ID %q, expected at most 63 lowercase letters, digits, underscores and dashes", instanceID)
+	}
+	return &labelNamespacedService{
+		Service:   service,
+		namespace: labelNamespace{prefix: prefix, instanceID: instanceID},
+	}, nil
+}
+
+func (s *labelNamespacedService) instanceIn(obj *ServiceInstance) *ServiceInstance {
+	namespaced := *obj
+	namespaced.Labels = s.namespace.apply(obj.Labels)
+	return &namespaced
+}
+
+func (s *labelNamespacedService) instanceOut(instance *ServiceInstance, err error) (*ServiceInstance, error) {
+	if instance != nil {
+		instance.Labels = s.namespace.strip(instance.Labels)
+	}
+	return instance, err
+}
+
+func (s *labelNamespacedService) multishareInstanceIn(obj *MultishareInstance) *MultishareInstance {
+	namespaced := *obj
+	namespaced.Labels = s.namespace.apply(obj.Labels)
+	return &namespaced
+}
+
+func (s *labelNamespacedService) multishareInstanceOut(instance *MultishareInstance) *MultishareInstance {
+	if instance != nil {
+		instance.Labels = s.namespace.strip(instance.Labels)
+	}
+	return instance
+}
+
+func (s *labelNamespacedService) shareIn(obj *Share) *Share {
+	namespaced := *obj
+	namespaced.Labels = s.namespace.apply(obj.Labels)
+	return &namespaced
+}
+
+func (s *labelNamespacedService) shareOut(share *Share) *Share {
+	if share != nil {
+		share.Labels = s.namespace.strip(share.Labels)
+		share.Parent = s.multishareInstanceOut(share.Parent)
+	}
+	return share
+}
+
+func (s *labelNamespacedService) CreateInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	return s.instanceOut(s.Service.CreateInstance(ctx, s.instanceIn(obj)))
+}
+
+func (s *labelNamespacedService) GetInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	return s.instanceOut(s.Service.GetInstance(ctx, obj))
+}
+
+func (s *labelNamespacedService) ListInstances(ctx context.Context, obj *ServiceInstance) ([]*ServiceInstance, error) {
+	instances, err := s.Service.ListInstances(ctx, obj)
+	for _, instance := range instances {
+		s.instanceOut(instance, nil)
+	}
+	return instances, err
+}
+
+func (s *labelNamespacedService) ResizeInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	return s.instanceOut(s.Service.ResizeInstance(ctx, s.instanceIn(obj)))
+}
+
+func (s *labelNamespacedService) RestoreInstance(ctx context.Context, obj *ServiceInstance, backupUri string) (*ServiceInstance, error) {
+	return s.instanceOut(s.Service.RestoreInstance(ctx, s.instanceIn(obj), backupUri))
+}
+
+func (s *labelNamespacedService) PromoteReplica(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error) {
+	return s.instanceOut(s.Service.PromoteReplica(ctx, obj))
+}
+
+func (s *labelNamespacedService) CreateBackup(ctx context.Context, backupInfo *BackupInfo) (*filev1beta1.Backup, error) {
+	namespaced := *backupInfo
+	namespaced.Labels = s.namespace.apply(backupInfo.Labels)
+	return s.Service.CreateBackup(ctx, &namespaced)
+}
+
+func (s *labelNamespacedService) ListBackups(ctx context.Context, obj *ServiceInstance) ([]*Backup, error) {
+	backups, err := s.Service.ListBackups(ctx, obj)
+	for _, backup := range backups {
+		if backup.Backup != nil {
+			backup.Backup.Labels = s.namespace.strip(backup.Backup.Labels)
+		}
+	}
+	return backups, err
+}
+
+func (s *labelNamespacedService) GetMultishareInstance(ctx context.Context, obj *MultishareInstance) (*MultishareInstance, error) {
+	instance, err := s.Service.GetMultishareInstance(ctx, obj)
+	return s.multishareInstanceOut(instance), err
+}
+
+func (s *labelNamespacedService) ListMultishareInstances(ctx context.Context, filter *ListFilter) ([]*MultishareInstance, error) {
+	instances, err := s.Service.ListMultishareInstances(ctx, filter)
+	for _, instance := range instances {
+		s.multishareInstanceOut(instance)
+	}
+	return instances, err
+}
+
+func (s *labelNamespacedService) StartCreateMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1.Operation, error) {
+	return s.Service.StartCreateMultishareInstanceOp(ctx, s.multishareInstanceIn(obj))
+}
+
+// StartResizeMultishareInstanceOp resizes the instance. The labels, if any, replace the labels
+// of the instance, so the labels dropped by strip when the instance was read are written back
+// with them.
+func (s *labelNamespacedService) StartResizeMultishareInstanceOp(ctx context.Context, obj *MultishareInstance) (*filev1beta1.Operation, error) {
+	namespaced := s.multishareInstanceIn(obj)
+	if len(namespaced.Labels) > 0 {
+		current, err := s.Service.GetMultishareInstance(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range s.namespace.dropped(current.Labels) {
+			namespaced.Labels[k] = v
+		}
+	}
+	return s.Service.StartResizeMultishareInstanceOp(ctx, namespaced)
+}
+
+func (s *labelNamespacedService) ListShares(ctx context.Context, filter *ListFilter) ([]*Share, error) {
+	shares, err := s.Service.ListShares(ctx, filter)
+	for _, share := range shares {
+		s.shareOut(share)
+	}
+	return shares, err
+}
+
+func (s *labelNamespacedService) GetShare(ctx context.Context, obj *Share) (*Share, error) {
+	share, err := s.Service.GetShare(ctx, obj)
+	return s.shareOut(share), err
+}
+
+func (s *labelNamespacedService) StartCreateShareOp(ctx context.Context, obj *Share) (*filev1beta1.Operation, error) {
+	return s.Service.StartCreateShareOp(ctx, s.shareIn(obj))
+}
+
+func (s *labelNamespacedService) StartResizeShareOp(ctx context.Context, obj *Share) (*filev1beta1.Operation, error) {
+	return s.Service.StartResizeShareOp(ctx, s.shareIn(obj))
+}
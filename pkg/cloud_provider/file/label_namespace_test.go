@@ -0,0 +1,160 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNewLabelNamespacedService(t *testing.T) {
+	fake, err := NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to create fake service: %v", err)
+	}
+	cases := []struct {
+		prefix     string
+		instanceID string
+		wrapped    bool
+		expectErr  bool
+	}{
+		{},
+		{prefix: DefaultLabelPrefix},
+		{prefix: "test_filestore", wrapped: true},
+		{instanceID: "prod", wrapped: true},
+		{prefix: "Test", expectErr: true},
+		{prefix: "1test", expectErr: true},
+		{instanceID: "Prod.1", expectErr: true},
+	}
+	for _, tc := range cases {
+		service, err := NewLabelNamespacedService(fake, tc.prefix, tc.instanceID)
+		if gotErr := err != nil; gotErr != tc.expectErr {
+			t.Errorf("%q/%q: got error %v, expected error %t", tc.prefix, tc.instanceID, err, tc.expectErr)
+			continue
+		}
+		if _, wrapped := service.(*labelNamespacedService); err == nil && wrapped != tc.wrapped {
+			t.Errorf("%q/%q: got wrapped service %t, expected %t", tc.prefix, tc.instanceID, wrapped, tc.wrapped)
+		}
+	}
+}
+
+func TestLabelNamespacedServiceIsolation(t *testing.T) {
+	fake, err := NewFakeServiceForMultishare(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create fake service: %v", err)
+	}
+	prod, _ := NewLabelNamespacedService(fake, "", "prod")
+	test, _ := NewLabelNamespacedService(fake, "test_filestore", "")
+	labels := map[string]string{
+		"storage_gke_io_created-by":       "filestore_csi_storage_gke_io",
+		"storage_gke_io_storage-class-id": "sc-a",
+		"team":                            "storage",
+	}
+	for name, service := range map[string]Service{"fs-prod": prod, "fs-test": test, "fs-default": fake} {
+		if _, err := service.StartCreateMultishareInstanceOp(context.Background(), &MultishareInstance{Name: name, Location: "us-central1", Labels: labels}); err != nil {
+			t.Fatalf("failed to create instance %s: %v", name, err)
+		}
+	}
+
+	unlabeled := map[string]string{"team": "storage"}
+	stored := map[string]string{
+		"test_filestore_created-by":       "filestore_csi_storage_gke_io",
+		"test_filestore_storage-class-id": "sc-a",
+		"team":                            "storage",
+	}
+	expected := map[string]map[string]map[string]string{
+		"prod": {"fs-prod": labels, "fs-test": stored, "fs-default": labels},
+		"test": {"fs-prod": unlabeled, "fs-test": labels, "fs-default": unlabeled},
+	}
+	for name, service := range map[string]Service{"prod": prod, "test": test} {
+		instances, err := service.ListMultishareInstances(context.Background(), &ListFilter{Location: "us-central1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, instance := range instances {
+			if l := expected[name][instance.Name]; !reflect.DeepEqual(instance.Labels, l) {
+				t.Errorf("%s: got labels %v of instance %s, expected %v", name, instance.Labels, instance.Name, l)
+			}
+		}
+	}
+
+	instance, err := fake.GetMultishareInstance(context.Background(), &MultishareInstance{Name: "fs-test", Location: "us-central1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(instance.Labels, stored) {
+		t.Errorf("got stored labels %v, expected %v", instance.Labels, stored)
+	}
+}
+
+func TestLabelNamespacedServiceLegacyInstance(t *testing.T) {
+	fake, err := NewFakeServiceForMultishare(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create fake service: %v", err)
+	}
+	prod, _ := NewLabelNamespacedService(fake, "", "prod")
+	legacy := map[string]string{
+		"storage_gke_io_created-by":          "filestore_csi_storage_gke_io",
+		"storage_gke_io_deletion-protection": "true",
+		"storage_gke_io_storage-class-id":    "sc-a",
+		"team":                               "storage",
+	}
+	foreign := map[string]string{
+		"storage_gke_io_deletion-protection": "true",
+		"storage_gke_io_driver-instance-id":  "test",
+	}
+	for name, labels := range map[string]map[string]string{"fs-legacy": legacy, "fs-foreign": foreign} {
+		if _, err := fake.StartCreateMultishareInstanceOp(context.Background(), &MultishareInstance{Name: name, Location: "us-central1", Labels: labels}); err != nil {
+			t.Fatalf("failed to create instance %s: %v", name, err)
+		}
+	}
+
+	instance, err := prod.GetMultishareInstance(context.Background(), &MultishareInstance{Name: "fs-legacy", Location: "us-central1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(instance.Labels, legacy) {
+		t.Errorf("got labels %v of the unlabeled instance, expected %v", instance.Labels, legacy)
+	}
+	instance.Labels["storage_gke_io_driver-version"] = "v2"
+	if _, err := prod.StartResizeMultishareInstanceOp(context.Background(), instance); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, _ := fake.GetMultishareInstance(context.Background(), &MultishareInstance{Name: "fs-legacy", Location: "us-central1"})
+	if stored.Labels["storage_gke_io_deletion-protection"] != "true" || stored.Labels["storage_gke_io_driver-instance-id"] != "prod" {
+		t.Errorf("got stored labels %v of the adopted instance, expected the deletion protection and the driver instance ID", stored.Labels)
+	}
+
+	instance, err = prod.GetMultishareInstance(context.Background(), &MultishareInstance{Name: "fs-foreign", Location: "us-central1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(instance.Labels) != 0 {
+		t.Errorf("got labels %v of the instance of another driver instance ID, expected none", instance.Labels)
+	}
+	instance.Labels = map[string]string{"storage_gke_io_driver-version": "v2"}
+	if _, err := prod.StartResizeMultishareInstanceOp(context.Background(), instance); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, _ = fake.GetMultishareInstance(context.Background(), &MultishareInstance{Name: "fs-foreign", Location: "us-central1"})
+	for k, v := range foreign {
+		if stored.Labels[k] != v {
+			t.Errorf("got stored labels %v of the instance of another driver instance ID, expected %s=%s", stored.Labels, k, v)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	pbSanitizer "github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// auditedMethods are the mutating CSI RPCs recorded by the audit log.
+var auditedMethods = map[string]bool{
+	"/csi.v1.Controller/CreateVolume":              true,
+	"/csi.v1.Controller/DeleteVolume":              true,
+	"/csi.v1.Controller/ControllerPublishVolume":   true,
+	"/csi.v1.Controller/ControllerUnpublishVolume": true,
+	"/csi.v1.Controller/ControllerExpandVolume":    true,
+	"/csi.v1.Controller/CreateSnapshot":            true,
+	"/csi.v1.Controller/DeleteSnapshot":            true,
+	"/csi.v1.Node/NodeStageVolume":                 true,
+	"/csi.v1.Node/NodeUnstageVolume":               true,
+	"/csi.v1.Node/NodePublishVolume":               true,
+	"/csi.v1.Node/NodeUnpublishVolume":             true,
+	"/csi.v1.Node/NodeExpandVolume":                true,
+}
+
+// auditRecord is the JSON line of the audit log recording a mutating RPC.
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	// Peer is the address of the caller, UserAgent the name and version of the sidecar or
+	// kubelet calling the driver.
+	Peer      string `json:"peer,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// Request is the request with its secrets redacted.
+	Request json.RawMessage `json:"request"`
+	// Resources are the IDs of the volumes and snapshots, i.e. Filestore instances, shares
+	// and backups, the RPC acted on or created.
+	Resources  []string `json:"resources,omitempty"`
+	Code       string   `json:"code"`
+	Error      string   `json:"error,omitempty"`
+	DurationMs int64    `json:"durationMs"`
+}
+
+// OpenAuditLog opens the audit log at path, appended to if it exists, or returns stdout if
+// path is "-".
+func OpenAuditLog(path string) (io.Writer, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+// newAuditInterceptor returns an interceptor writing a JSON line to w for every mutating RPC,
+// once it returns, so that the provisioning of the storage can be audited.
+func newAuditInterceptor(w io.Writer) grpc.UnaryServerInterceptor {
+	var mux sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !auditedMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		record := &auditRecord{
+			Time:       start.UTC(),
+			Method:     info.FullMethod,
+			Request:    auditRequest(req),
+			Resources:  auditResources(req, resp),
+			Code:       status.Code(err).String(),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			record.Peer = p.Addr.String()
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if userAgent := md.Get("user-agent"); len(userAgent) > 0 {
+				record.UserAgent = userAgent[0]
+			}
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		if encodeErr := encoder.Encode(record); encodeErr != nil {
+			klog.Errorf("Failed to write the audit log record of %s: %v", info.FullMethod, encodeErr)
+		}
+		return resp, err
+	}
+}
+
+// auditRequest returns the JSON of the request with its secrets redacted.
+func auditRequest(req interface{}) json.RawMessage {
+	stripped := pbSanitizer.StripSecrets(req).String()
+	if !json.Valid([]byte(stripped)) {
+		// The sanitizer reports its failures as text.
+		text, _ := json.Marshal(stripped)
+		return text
+	}
+	return json.RawMessage(stripped)
+}
+
+// auditResources returns the IDs of the volumes and snapshots of the request and response.
+func auditResources(req, resp interface{}) []string {
+	var resources []string
+	add := func(id string) {
+		for _, r := range resources {
+			if r == id {
+				return
+			}
+		}
+		if id != "" {
+			resources = append(resources, id)
+		}
+	}
+	for _, msg := range []interface{}{req, resp} {
+		if m, ok := msg.(interface{ GetVolumeId() string }); ok {
+			add(m.GetVolumeId())
+		}
+		if m, ok := msg.(interface{ GetSnapshotId() string }); ok {
+			add(m.GetSnapshotId())
+		}
+		if m, ok := msg.(interface{ GetSourceVolumeId() string }); ok {
+			add(m.GetSourceVolumeId())
+		}
+	}
+	if resp, ok := resp.(interface {
+		GetVolume() *csi.Volume
+	}); ok {
+		add(resp.GetVolume().GetVolumeId())
+	}
+	if resp, ok := resp.(interface {
+		GetSnapshot() *csi.Snapshot
+	}); ok {
+		add(resp.GetSnapshot().GetSnapshotId())
+	}
+	return resources
+}
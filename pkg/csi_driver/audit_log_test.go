@@ -0,0 +1,94 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuditInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	interceptor := newAuditInterceptor(&buf)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "csi-provisioner/v3"))
+
+	createReq := &csi.CreateVolumeRequest{
+		Name:       testCSIVolume,
+		Parameters: map[string]string{paramTier: enterpriseTier},
+		Secrets:    map[string]string{"key": "secret-value"},
+	}
+	createResp := &csi.CreateVolumeResponse{Volume: &csi.Volume{VolumeId: testVolumeID}}
+	interceptor(ctx, createReq, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return createResp, nil
+	})
+	// Non-mutating RPCs are not audited.
+	interceptor(ctx, &csi.NodeGetInfoRequest{}, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeGetInfo"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &csi.NodeGetInfoResponse{}, nil
+	})
+	interceptor(ctx, &csi.DeleteVolumeRequest{VolumeId: testVolumeID}, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/DeleteVolume"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Internal, "delete failed")
+	})
+
+	if strings.Contains(buf.String(), "secret-value") {
+		t.Errorf("got secrets in the audit log %s", buf.String())
+	}
+	var records []auditRecord
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record auditRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("failed to decode the audit log: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d audit records, expected 2: %+v", len(records), records)
+	}
+
+	create := records[0]
+	if create.Method != "/csi.v1.Controller/CreateVolume" || create.Code != codes.OK.String() || create.UserAgent != "csi-provisioner/v3" {
+		t.Errorf("got record %+v, expected a successful CreateVolume of csi-provisioner", create)
+	}
+	if !reflect.DeepEqual(create.Resources, []string{testVolumeID}) {
+		t.Errorf("got resources %v, expected the created volume %s", create.Resources, testVolumeID)
+	}
+	var req map[string]interface{}
+	if err := json.Unmarshal(create.Request, &req); err != nil {
+		t.Fatalf("failed to decode the request %s: %v", create.Request, err)
+	}
+	if req["name"] != testCSIVolume {
+		t.Errorf("got request %v, expected the volume name %s", req, testCSIVolume)
+	}
+
+	del := records[1]
+	if del.Code != codes.Internal.String() || !strings.Contains(del.Error, "delete failed") {
+		t.Errorf("got record %+v, expected the failed DeleteVolume", del)
+	}
+	if !reflect.DeepEqual(del.Resources, []string{testVolumeID}) {
+		t.Errorf("got resources %v, expected the deleted volume %s", del.Resources, testVolumeID)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const (
+	// paramAuthFlavor is the StorageClass parameter setting the NFS security flavor of the
+	// volumes, and attrAuthFlavor the volume attribute passing it to the node driver, also set
+	// on pre-provisioned PVs.
+	paramAuthFlavor = "auth-flavor"
+	attrAuthFlavor  = "authFlavor"
+
+	authFlavorSys   = "sys"
+	authFlavorKrb5  = "krb5"
+	authFlavorKrb5i = "krb5i"
+	authFlavorKrb5p = "krb5p"
+
+	// nfsV41Protocol is the protocol of the instances of the Kerberos flavors.
+	nfsV41Protocol = "NFS_V4_1"
+
+	// secretKeyKeytab is the key of the node stage secret of a volume holding the Kerberos
+	// keytab of the nodes.
+	secretKeyKeytab = "krb5.keytab"
+)
+
+// authFlavorSecurityFlavors maps the auth flavors to the security flavors of the Filestore exports.
+var authFlavorSecurityFlavors = map[string]string{
+	authFlavorSys:   "AUTH_SYS",
+	authFlavorKrb5:  "KRB5",
+	authFlavorKrb5i: "KRB5I",
+	authFlavorKrb5p: "KRB5P",
+}
+
+// kerberosTiers are the tiers serving NFSv4.1, which the Kerberos flavors require.
+var kerberosTiers = map[string]bool{
+	zonalTier:      true,
+	enterpriseTier: true,
+}
+
+// conflictingAuthFlavorMountFlags are the mount flags of the volume capabilities conflicting
+// with the auth flavor of a volume.
+var conflictingAuthFlavorMountFlags = []string{"sec="}
+
+// versionMountFlags are the mount flags setting the NFS version, kept over the NFSv4.1 of the
+// Kerberos flavors.
+var versionMountFlags = []string{"vers=", "nfsvers="}
+
+// keytabLock serializes the writes of the keytab of the node.
+var keytabLock sync.Mutex
+
+func isKerberosFlavor(flavor string) bool {
+	return flavor != "" && flavor != authFlavorSys
+}
+
+// authFlavor validates an auth flavor and returns it lower cased.
+func authFlavor(value string) (string, error) {
+	flavor := strings.ToLower(value)
+	if _, ok := authFlavorSecurityFlavors[flavor]; !ok {
+		flavors := make([]string, 0, len(authFlavorSecurityFlavors))
+		for f := range authFlavorSecurityFlavors {
+			flavors = append(flavors, f)
+		}
+		sort.Strings(flavors)
+		return "", fmt.Errorf("invalid %v %q, must be one of %v", paramAuthFlavor, value, flavors)
+	}
+	return flavor, nil
+}
+
+// authFlavorVolumeContext validates the auth flavor parameter of CreateVolume and returns the
+// volume attributes passing it to the node driver, none for the default sys flavor.
+func authFlavorVolumeContext(params map[string]string) (map[string]string, error) {
+	for k, v := range params {
+		if strings.ToLower(k) != paramAuthFlavor {
+			continue
+		}
+		flavor, err := authFlavor(v)
+		if err != nil {
+			return nil, err
+		}
+		if !isKerberosFlavor(flavor) {
+			return nil, nil
+		}
+		return map[string]string{attrAuthFlavor: flavor}, nil
+	}
+	return nil, nil
+}
+
+// applyAuthFlavor sets the protocol and the export security flavors of a new instance of the
+// auth flavor. The export options without security flavors get the flavor, and an instance
+// without export options gets a read-write export of the flavor for all the clients.
+func applyAuthFlavor(instance *file.ServiceInstance, flavor string) error {
+	if !isKerberosFlavor(flavor) {
+		return nil
+	}
+	if !kerberosTiers[strings.ToLower(instance.Tier)] {
+		return fmt.Errorf("%v %q is only supported for the %s and %s tiers", paramAuthFlavor, flavor, zonalTier, enterpriseTier)
+	}
+	instance.Protocol = nfsV41Protocol
+	if len(instance.NfsExportOptions) == 0 {
+		instance.NfsExportOptions = []*file.NfsExportOptions{{AccessMode: "READ_WRITE", SquashMode: "NO_ROOT_SQUASH"}}
+	}
+	for _, opt := range instance.NfsExportOptions {
+		if len(opt.SecurityFlavors) == 0 {
+			opt.SecurityFlavors = []string{authFlavorSecurityFlavors[flavor]}
+		}
+	}
+	return nil
+}
+
+// authFlavorOptions returns the NFS mount options of the auth flavor of a volume with the given
+// attributes and capability, none for the sys flavor. The Kerberos flavors are mounted over
+// NFSv4.1 unless the mount flags set another version.
+func authFlavorOptions(attr map[string]string, capability *csi.VolumeCapability) ([]string, error) {
+	value, ok := attr[attrAuthFlavor]
+	if !ok {
+		return nil, nil
+	}
+	flavor, err := authFlavor(value)
+	if err != nil {
+		return nil, err
+	}
+	if !isKerberosFlavor(flavor) {
+		return nil, nil
+	}
+	versionSet := false
+	for _, flag := range capability.GetMount().GetMountFlags() {
+		for _, conflicting := range conflictingAuthFlavorMountFlags {
+			if strings.HasPrefix(flag, conflicting) {
+				return nil, fmt.Errorf("mount option %q conflicts with the auth flavor of the volume, set with %v", flag, attrAuthFlavor)
+			}
+		}
+		for _, version := range versionMountFlags {
+			if strings.HasPrefix(flag, version) {
+				versionSet = true
+			}
+		}
+	}
+	options := []string{"sec=" + flavor}
+	if !versionSet {
+		options = append(options, "vers=4.1")
+	}
+	return options, nil
+}
+
+// writeKeytab merges the Kerberos keytab of the node stage secret of a volume, if any, into
+// the keytab at keytabPath, read by the NFS client of the node, e.g. the /etc/krb5.keytab of
+// the node mounted in the driver container. The keys of the volumes staged on the node are
+// kept, and a keytab with another key for one of their principals is refused, see
+// mergeKeytabs. Without a keytab in the secret, the mounts rely on the Kerberos configuration
+// of the node.
+func writeKeytab(secrets map[string]string, keytabPath string) error {
+	keytab, ok := secrets[secretKeyKeytab]
+	if !ok {
+		return nil
+	}
+	if keytabPath == "" {
+		return fmt.Errorf("the node stage secret of the volume holds a %s, but the node driver runs without --kerberos-keytab-path", secretKeyKeytab)
+	}
+	keytabLock.Lock()
+	defer keytabLock.Unlock()
+	current, err := os.ReadFile(keytabPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read keytab %s: %w", keytabPath, err)
+	}
+	merged, changed, err := mergeKeytabs(current, []byte(keytab))
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(keytabPath), filepath.Base(keytabPath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write keytab %s: %w", keytabPath, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(merged); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keytab %s: %w", keytabPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write keytab %s: %w", keytabPath, err)
+	}
+	if err := os.Rename(tmp.Name(), keytabPath); err != nil {
+		return fmt.Errorf("failed to write keytab %s: %w", keytabPath, err)
+	}
+	return nil
+}
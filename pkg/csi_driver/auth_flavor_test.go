@@ -0,0 +1,307 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestAuthFlavorVolumeContext(t *testing.T) {
+	cases := []struct {
+		name     string
+		params   map[string]string
+		expected map[string]string
+		errorExp bool
+	}{
+		{
+			name:   "no auth flavor",
+			params: map[string]string{paramTier: zonalTier},
+		},
+		{
+			name:   "sys",
+			params: map[string]string{paramAuthFlavor: authFlavorSys},
+		},
+		{
+			name:     "kerberos",
+			params:   map[string]string{"Auth-Flavor": "KRB5P"},
+			expected: map[string]string{attrAuthFlavor: authFlavorKrb5p},
+		},
+		{
+			name:     "invalid",
+			params:   map[string]string{paramAuthFlavor: "ntlm"},
+			errorExp: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			attr, err := authFlavorVolumeContext(tc.params)
+			if tc.errorExp {
+				if err == nil {
+					t.Errorf("expected error, got attributes %v", attr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(attr, tc.expected) {
+				t.Errorf("got attributes %v, expected %v", attr, tc.expected)
+			}
+		})
+	}
+}
+
+func TestApplyAuthFlavor(t *testing.T) {
+	cases := []struct {
+		name             string
+		tier             string
+		flavor           string
+		options          []*file.NfsExportOptions
+		expectedProtocol string
+		expectedOptions  []*file.NfsExportOptions
+		errorExp         bool
+	}{
+		{
+			name: "sys",
+			tier: defaultTier,
+		},
+		{
+			name:             "kerberos without export options",
+			tier:             zonalTier,
+			flavor:           authFlavorKrb5,
+			expectedProtocol: nfsV41Protocol,
+			expectedOptions:  []*file.NfsExportOptions{{AccessMode: "READ_WRITE", SquashMode: "NO_ROOT_SQUASH", SecurityFlavors: []string{"KRB5"}}},
+		},
+		{
+			name:   "kerberos with export options",
+			tier:   "Enterprise",
+			flavor: authFlavorKrb5i,
+			options: []*file.NfsExportOptions{
+				{AccessMode: "READ_ONLY", IpRanges: []string{"10.0.0.0/24"}},
+				{AccessMode: "READ_WRITE", IpRanges: []string{"10.0.1.0/24"}, SecurityFlavors: []string{"KRB5P"}},
+			},
+			expectedProtocol: nfsV41Protocol,
+			expectedOptions: []*file.NfsExportOptions{
+				{AccessMode: "READ_ONLY", IpRanges: []string{"10.0.0.0/24"}, SecurityFlavors: []string{"KRB5I"}},
+				{AccessMode: "READ_WRITE", IpRanges: []string{"10.0.1.0/24"}, SecurityFlavors: []string{"KRB5P"}},
+			},
+		},
+		{
+			name:     "kerberos on a tier without NFSv4.1",
+			tier:     premiumTier,
+			flavor:   authFlavorKrb5,
+			errorExp: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			instance := &file.ServiceInstance{Tier: tc.tier, NfsExportOptions: tc.options}
+			err := applyAuthFlavor(instance, tc.flavor)
+			if tc.errorExp {
+				if err == nil {
+					t.Errorf("expected error, got instance %+v", instance)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if instance.Protocol != tc.expectedProtocol {
+				t.Errorf("got protocol %q, expected %q", instance.Protocol, tc.expectedProtocol)
+			}
+			if !reflect.DeepEqual(instance.NfsExportOptions, tc.expectedOptions) {
+				t.Errorf("got export options %+v, expected %+v", instance.NfsExportOptions, tc.expectedOptions)
+			}
+		})
+	}
+}
+
+func TestAuthFlavorOptions(t *testing.T) {
+	cases := []struct {
+		name     string
+		attr     map[string]string
+		flags    []string
+		expected []string
+		errorExp bool
+	}{
+		{
+			name: "no auth flavor",
+			attr: map[string]string{},
+		},
+		{
+			name: "sys",
+			attr: map[string]string{attrAuthFlavor: authFlavorSys},
+		},
+		{
+			name:     "kerberos",
+			attr:     map[string]string{attrAuthFlavor: authFlavorKrb5p},
+			expected: []string{"sec=krb5p", "vers=4.1"},
+		},
+		{
+			name:     "kerberos with an NFS version",
+			attr:     map[string]string{attrAuthFlavor: authFlavorKrb5},
+			flags:    []string{"nfsvers=4.2"},
+			expected: []string{"sec=krb5"},
+		},
+		{
+			name:     "conflicting security flavor",
+			attr:     map[string]string{attrAuthFlavor: authFlavorKrb5},
+			flags:    []string{"sec=sys"},
+			errorExp: true,
+		},
+		{
+			name:     "invalid",
+			attr:     map[string]string{attrAuthFlavor: "krb4"},
+			errorExp: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			options, err := authFlavorOptions(tc.attr, mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, tc.flags...))
+			if tc.errorExp {
+				if err == nil {
+					t.Errorf("expected error, got options %v", options)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(options, tc.expected) {
+				t.Errorf("got options %v, expected %v", options, tc.expected)
+			}
+		})
+	}
+}
+
+func TestWriteKeytab(t *testing.T) {
+	keytabPath := filepath.Join(t.TempDir(), "krb5.keytab")
+	hostA := testKeytab(testKeytabEntry{principal: "nfs/node-a@EXAMPLE.COM", vno: 2, keyType: 18, key: "key-a"})
+	hostB := testKeytab(testKeytabEntry{principal: "nfs/node-b@EXAMPLE.COM", vno: 2, keyType: 18, key: "key-b"})
+	if err := writeKeytab(map[string]string{}, ""); err != nil {
+		t.Errorf("unexpected error without keytab: %v", err)
+	}
+	if err := writeKeytab(map[string]string{secretKeyKeytab: string(hostA)}, ""); err == nil {
+		t.Errorf("expected error without keytab path")
+	}
+	if err := writeKeytab(map[string]string{secretKeyKeytab: string(hostA)}, keytabPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := os.Stat(keytabPath)
+	if err != nil {
+		t.Fatalf("keytab not written: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("got keytab mode %v, expected 0600", mode)
+	}
+
+	// The keytab of another volume is merged, and one replacing a key is refused.
+	if err := writeKeytab(map[string]string{secretKeyKeytab: string(hostB)}, keytabPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conflicting := testKeytab(testKeytabEntry{principal: "nfs/node-a@EXAMPLE.COM", vno: 2, keyType: 18, key: "stolen"})
+	if err := writeKeytab(map[string]string{secretKeyKeytab: string(conflicting)}, keytabPath); err == nil {
+		t.Errorf("expected error for a conflicting keytab")
+	}
+	content, _ := os.ReadFile(keytabPath)
+	expected := []string{"nfs/node-a@EXAMPLE.COM kvno 2 enctype 18", "nfs/node-b@EXAMPLE.COM kvno 2 enctype 18"}
+	if ids := keytabIDs(t, content); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("got keytab entries %v, expected %v", ids, expected)
+	}
+}
+
+func TestCreateVolumeAuthFlavor(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	req := &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		Parameters:         map[string]string{paramTier: premiumTier, paramAuthFlavor: authFlavorKrb5},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: highScaleTierMinSize},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	}
+
+	if _, err := cs.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, expected code %v on the %s tier", err, codes.InvalidArgument, premiumTier)
+	}
+	req.Parameters[paramTier] = zonalTier
+	resp, err := cs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if flavor := resp.Volume.VolumeContext[attrAuthFlavor]; flavor != authFlavorKrb5 {
+		t.Errorf("got auth flavor attribute %q, expected %q", flavor, authFlavorKrb5)
+	}
+	instance, err := cs.config.fileService.GetInstance(context.Background(), &file.ServiceInstance{Name: testCSIVolume, Location: testLocation})
+	if err != nil {
+		t.Fatalf("failed to get instance: %v", err)
+	}
+	if instance.Protocol != nfsV41Protocol {
+		t.Errorf("got protocol %q, expected %q", instance.Protocol, nfsV41Protocol)
+	}
+	if len(instance.NfsExportOptions) != 1 || !reflect.DeepEqual(instance.NfsExportOptions[0].SecurityFlavors, []string{"KRB5"}) {
+		t.Errorf("got export options %+v, expected the KRB5 security flavor", instance.NfsExportOptions)
+	}
+}
+
+func TestNodeStageVolumeAuthFlavor(t *testing.T) {
+	testEnv := initTestNodeServer(t)
+	ns := testEnv.ns.(*nodeServer)
+	stagingPath := t.TempDir()
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		VolumeContext: map[string]string{
+			attrIP:         "1.1.1.1",
+			attrVolume:     "vol1",
+			attrAuthFlavor: authFlavorKrb5i,
+		},
+		Secrets: map[string]string{secretKeyKeytab: string(testKeytab(testKeytabEntry{principal: "nfs/node@EXAMPLE.COM", vno: 1, keyType: 18, key: "key"}))},
+	}
+
+	if _, err := ns.NodeStageVolume(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("got error %v, expected code %v without --kerberos-keytab-path", err, codes.FailedPrecondition)
+	}
+	keytabPath := filepath.Join(t.TempDir(), "krb5.keytab")
+	ns.driver.config.KerberosKeytabPath = keytabPath
+	if _, err := ns.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	validateMountPoint(t, "kerberos mount", testEnv.fm, &mount.MountPoint{
+		Device: "1.1.1.1:/vol1",
+		Path:   stagingPath,
+		Type:   "nfs",
+		Opts:   []string{"sec=krb5i", "vers=4.1"},
+	})
+	if _, err := os.Stat(keytabPath); err != nil {
+		t.Errorf("keytab not written: %v", err)
+	}
+}
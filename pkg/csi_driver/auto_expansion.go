@@ -0,0 +1,318 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// annotationAutoExpand is set by the user on a bound PVC to expand it automatically by the
+	// given percentage of its capacity, e.g. "20%", when its used capacity crosses the threshold.
+	annotationAutoExpand = "filestore.csi/autoexpand"
+	// annotationAutoExpandThreshold overrides the percentage of the capacity used above which
+	// the PVC is expanded, e.g. "80%".
+	annotationAutoExpandThreshold = "filestore.csi/autoexpand-threshold"
+	// annotationAutoExpandMax is the size the PVC is never expanded beyond, e.g. "10Ti".
+	annotationAutoExpandMax = "filestore.csi/autoexpand-max"
+
+	// Reasons of the events published on the PVCs expanded automatically.
+	eventReasonAutoExpanding     = "FilestoreAutoExpanding"
+	eventReasonAutoExpandSkipped = "FilestoreAutoExpandSkipped"
+)
+
+// volumeUsage is the used and total capacity of a mounted volume.
+type volumeUsage struct {
+	usedBytes     int64
+	capacityBytes int64
+}
+
+// autoExpansionPolicy is the auto-expansion policy of a PVC, read from its annotations.
+type autoExpansionPolicy struct {
+	// incrementPercent is the percentage of its capacity the PVC is expanded by.
+	incrementPercent float64
+	// thresholdPercent is the percentage of its capacity used above which the PVC is expanded.
+	thresholdPercent float64
+	// maxBytes is the size the PVC is not expanded beyond, zero if unbounded.
+	maxBytes int64
+}
+
+// autoExpander expands the bound PVCs of the driver annotated with annotationAutoExpand when
+// their used capacity crosses the threshold, by patching the requested size of the PVCs. The
+// expansion itself goes through the external-resizer and ControllerExpandVolume, as if the
+// user patched the PVC, so the StorageClass must allow the volume expansion. The used capacity
+// of a PVC is read from the volume statistics of the kubelet of a node running a pod using it,
+// reported by NodeGetVolumeStats, so that the PVCs no pod uses are never expanded.
+type autoExpander struct {
+	driverName string
+	period     time.Duration
+	// threshold is the default percentage of its capacity used above which a PVC is expanded.
+	threshold  float64
+	kubeClient kubernetes.Interface
+	recorder   record.EventRecorder
+	// usageFunc returns the usage of the PVCs mounted on a node, by <namespace>/<name>.
+	usageFunc func(ctx context.Context, nodeName string) (map[string]volumeUsage, error)
+}
+
+func newAutoExpander(driverName string, period time.Duration, threshold float64, kubeClient kubernetes.Interface, recorder record.EventRecorder) *autoExpander {
+	return &autoExpander{
+		driverName: driverName,
+		period:     period,
+		threshold:  threshold,
+		kubeClient: kubeClient,
+		recorder:   recorder,
+		usageFunc: func(ctx context.Context, nodeName string) (map[string]volumeUsage, error) {
+			return kubeletVolumeUsage(ctx, kubeClient, nodeName)
+		},
+	}
+}
+
+// Run expands the annotated PVCs every period until stopCh is closed.
+func (e *autoExpander) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Filestore auto-expander with poll period %v and default threshold %.0f%%", e.period, e.threshold)
+	wait.Until(func() {
+		if err := e.expandAll(context.Background()); err != nil {
+			klog.Errorf("Failed to auto-expand the annotated PVCs: %v", err)
+		}
+	}, e.period, stopCh)
+}
+
+func (e *autoExpander) expandAll(ctx context.Context) error {
+	pvcs, err := e.kubeClient.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var annotated []*v1.PersistentVolumeClaim
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if _, ok := pvc.Annotations[annotationAutoExpand]; ok && pvc.Status.Phase == v1.ClaimBound && pvc.DeletionTimestamp == nil {
+			annotated = append(annotated, pvc)
+		}
+	}
+	if len(annotated) == 0 {
+		return nil
+	}
+
+	pvs, err := e.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	ownPVs := make(map[string]bool)
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == e.driverName {
+			ownPVs[pv.Name] = true
+		}
+	}
+	nodes, err := e.claimNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	// usages caches the usage of the PVCs of each node for the poll.
+	usages := make(map[string]map[string]volumeUsage)
+	for _, pvc := range annotated {
+		if !ownPVs[pvc.Spec.VolumeName] {
+			continue
+		}
+		key := pvc.Namespace + "/" + pvc.Name
+		node, ok := nodes[key]
+		if !ok {
+			klog.V(4).Infof("Skipping the auto-expansion of PVC %s, no running pod uses it", key)
+			continue
+		}
+		nodeUsages, ok := usages[node]
+		if !ok {
+			nodeUsages, err = e.usageFunc(ctx, node)
+			if err != nil {
+				klog.Warningf("Failed to get the volume usage of node %s: %v", node, err)
+			}
+			usages[node] = nodeUsages
+		}
+		usage, ok := nodeUsages[key]
+		if !ok {
+			continue
+		}
+		e.expand(ctx, pvc, usage)
+	}
+	return nil
+}
+
+// claimNodes returns the node of a running pod using each PVC, by <namespace>/<name>.
+func (e *autoExpander) claimNodes(ctx context.Context) (map[string]string, error) {
+	pods, err := e.kubeClient.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]string)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodRunning || pod.Spec.NodeName == "" {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				nodes[pod.Namespace+"/"+volume.PersistentVolumeClaim.ClaimName] = pod.Spec.NodeName
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// expand patches the requested size of the PVC if its usage is above the threshold of its
+// policy.
+func (e *autoExpander) expand(ctx context.Context, pvc *v1.PersistentVolumeClaim, usage volumeUsage) {
+	policy, err := parseAutoExpansionPolicy(pvc.Annotations, e.threshold)
+	if err != nil {
+		e.recorder.Event(pvc, v1.EventTypeWarning, eventReasonAutoExpandSkipped, err.Error())
+		return
+	}
+	requested := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	capacity := pvc.Status.Capacity[v1.ResourceStorage]
+	if capacity.Cmp(requested) < 0 {
+		klog.V(4).Infof("Skipping the auto-expansion of PVC %s/%s, its expansion to %s is in progress", pvc.Namespace, pvc.Name, requested.String())
+		return
+	}
+	if usage.capacityBytes <= 0 {
+		return
+	}
+	usedPercent := float64(usage.usedBytes) * 100 / float64(usage.capacityBytes)
+	if usedPercent < policy.thresholdPercent {
+		return
+	}
+
+	target := util.GbToBytes(util.RoundBytesToGb(capacity.Value() + int64(float64(capacity.Value())*policy.incrementPercent/100)))
+	if policy.maxBytes > 0 && target > policy.maxBytes {
+		target = policy.maxBytes
+	}
+	if target <= requested.Value() {
+		e.recorder.Eventf(pvc, v1.EventTypeWarning, eventReasonAutoExpandSkipped, "Volume is %.1f%% full, above the auto-expansion threshold of %.0f%%, but the PVC has reached the maximum size %s of annotation %s", usedPercent, policy.thresholdPercent, pvc.Annotations[annotationAutoExpandMax], annotationAutoExpandMax)
+		return
+	}
+
+	size := resource.NewQuantity(target, resource.BinarySI)
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"resources": map[string]interface{}{
+				"requests": map[string]string{string(v1.ResourceStorage): size.String()},
+			},
+		},
+	})
+	if err != nil {
+		klog.Errorf("Failed to build the auto-expansion patch of PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		return
+	}
+	if _, err := e.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(ctx, pvc.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		klog.Errorf("Failed to auto-expand PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		e.recorder.Eventf(pvc, v1.EventTypeWarning, eventReasonAutoExpandSkipped, "Failed to expand the PVC to %s: %v", size.String(), err)
+		return
+	}
+	klog.Infof("Auto-expanding PVC %s/%s from %s to %s, %.1f%% of its volume used", pvc.Namespace, pvc.Name, capacity.String(), size.String(), usedPercent)
+	e.recorder.Eventf(pvc, v1.EventTypeNormal, eventReasonAutoExpanding, "Volume is %.1f%% full, above the auto-expansion threshold of %.0f%%: expanding the PVC from %s to %s", usedPercent, policy.thresholdPercent, capacity.String(), size.String())
+}
+
+// parseAutoExpansionPolicy reads the auto-expansion policy of a PVC from its annotations.
+func parseAutoExpansionPolicy(annotations map[string]string, defaultThreshold float64) (*autoExpansionPolicy, error) {
+	policy := &autoExpansionPolicy{thresholdPercent: defaultThreshold}
+	var err error
+	if policy.incrementPercent, err = parsePercent(annotations[annotationAutoExpand]); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %w", annotationAutoExpand, err)
+	}
+	if value, ok := annotations[annotationAutoExpandThreshold]; ok {
+		if policy.thresholdPercent, err = parsePercent(value); err != nil || policy.thresholdPercent > 100 {
+			return nil, fmt.Errorf("invalid annotation %s: %q is not a percentage between 0%% and 100%%", annotationAutoExpandThreshold, value)
+		}
+	}
+	if value, ok := annotations[annotationAutoExpandMax]; ok {
+		maxSize, err := resource.ParseQuantity(value)
+		if err != nil || maxSize.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid annotation %s: %q is not a positive size", annotationAutoExpandMax, value)
+		}
+		policy.maxBytes = maxSize.Value()
+	}
+	return policy, nil
+}
+
+// parsePercent parses a positive percentage, e.g. "20%".
+func parsePercent(value string) (float64, error) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasSuffix(trimmed, "%") {
+		return 0, fmt.Errorf("%q is not a percentage, e.g. 20%%", value)
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(trimmed, "%"), 64)
+	if err != nil || percent <= 0 {
+		return 0, fmt.Errorf("%q is not a positive percentage", value)
+	}
+	return percent, nil
+}
+
+// kubeletSummary is the subset of the stats summary of the kubelet read by the auto-expander.
+type kubeletSummary struct {
+	Pods []struct {
+		Volumes []struct {
+			UsedBytes     *uint64 `json:"usedBytes"`
+			CapacityBytes *uint64 `json:"capacityBytes"`
+			PVCRef        *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+// kubeletVolumeUsage returns the usage of the PVCs mounted on a node, by <namespace>/<name>,
+// from the stats summary of its kubelet read through the API server proxy.
+func kubeletVolumeUsage(ctx context.Context, kubeClient kubernetes.Interface, nodeName string) (map[string]volumeUsage, error) {
+	raw, err := kubeClient.CoreV1().RESTClient().Get().Resource("nodes").Name(nodeName).SubResource("proxy").Suffix("stats/summary").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseKubeletVolumeUsage(raw)
+}
+
+func parseKubeletVolumeUsage(raw []byte) (map[string]volumeUsage, error) {
+	var summary kubeletSummary
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse the kubelet stats summary: %w", err)
+	}
+	usages := make(map[string]volumeUsage)
+	for _, pod := range summary.Pods {
+		for _, volume := range pod.Volumes {
+			if volume.PVCRef == nil || volume.UsedBytes == nil || volume.CapacityBytes == nil {
+				continue
+			}
+			usages[volume.PVCRef.Namespace+"/"+volume.PVCRef.Name] = volumeUsage{
+				usedBytes:     int64(*volume.UsedBytes),
+				capacityBytes: int64(*volume.CapacityBytes),
+			}
+		}
+	}
+	return usages, nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestAutoExpander(t *testing.T) {
+	const driverName = "filestore.csi.storage.gke.io"
+	cases := []struct {
+		name          string
+		annotations   map[string]string
+		requested     string
+		usedBytes     int64
+		noPod         bool
+		expectedSize  string
+		expectedEvent string
+	}{
+		{
+			name:         "below the threshold",
+			annotations:  map[string]string{annotationAutoExpand: "20%"},
+			usedBytes:    70 * util.Gb,
+			expectedSize: "100Gi",
+		},
+		{
+			name:          "above the threshold",
+			annotations:   map[string]string{annotationAutoExpand: "20%"},
+			usedBytes:     85 * util.Gb,
+			expectedSize:  "120Gi",
+			expectedEvent: eventReasonAutoExpanding,
+		},
+		{
+			name:          "above the threshold of the annotation",
+			annotations:   map[string]string{annotationAutoExpand: "50%", annotationAutoExpandThreshold: "60%"},
+			usedBytes:     65 * util.Gb,
+			expectedSize:  "150Gi",
+			expectedEvent: eventReasonAutoExpanding,
+		},
+		{
+			name:          "capped at the max",
+			annotations:   map[string]string{annotationAutoExpand: "20%", annotationAutoExpandMax: "110Gi"},
+			usedBytes:     85 * util.Gb,
+			expectedSize:  "110Gi",
+			expectedEvent: eventReasonAutoExpanding,
+		},
+		{
+			name:          "at the max",
+			annotations:   map[string]string{annotationAutoExpand: "20%", annotationAutoExpandMax: "100Gi"},
+			usedBytes:     95 * util.Gb,
+			expectedSize:  "100Gi",
+			expectedEvent: eventReasonAutoExpandSkipped,
+		},
+		{
+			name:         "expansion in progress",
+			annotations:  map[string]string{annotationAutoExpand: "20%"},
+			requested:    "120Gi",
+			usedBytes:    95 * util.Gb,
+			expectedSize: "120Gi",
+		},
+		{
+			name:          "invalid increment",
+			annotations:   map[string]string{annotationAutoExpand: "20"},
+			usedBytes:     95 * util.Gb,
+			expectedSize:  "100Gi",
+			expectedEvent: eventReasonAutoExpandSkipped,
+		},
+		{
+			name:         "not used by a pod",
+			annotations:  map[string]string{annotationAutoExpand: "20%"},
+			usedBytes:    95 * util.Gb,
+			noPod:        true,
+			expectedSize: "100Gi",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			requested := tc.requested
+			if requested == "" {
+				requested = "100Gi"
+			}
+			pv := &v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+				Spec: v1.PersistentVolumeSpec{
+					PersistentVolumeSource: v1.PersistentVolumeSource{
+						CSI: &v1.CSIPersistentVolumeSource{Driver: driverName, VolumeHandle: testVolumeID},
+					},
+				},
+			}
+			pvc := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pvc-1", Annotations: tc.annotations},
+				Spec: v1.PersistentVolumeClaimSpec{
+					VolumeName: pv.Name,
+					Resources:  v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(requested)}},
+				},
+				Status: v1.PersistentVolumeClaimStatus{
+					Phase:    v1.ClaimBound,
+					Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("100Gi")},
+				},
+			}
+			objects := []runtime.Object{pv, pvc}
+			if !tc.noPod {
+				objects = append(objects, &v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod-1"},
+					Spec: v1.PodSpec{
+						NodeName: "node-1",
+						Volumes: []v1.Volume{{
+							Name:         "data",
+							VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.Name}},
+						}},
+					},
+					Status: v1.PodStatus{Phase: v1.PodRunning},
+				})
+			}
+			client := fake.NewSimpleClientset(objects...)
+			recorder := record.NewFakeRecorder(10)
+			e := newAutoExpander(driverName, time.Minute, 80, client, recorder)
+			e.usageFunc = func(ctx context.Context, nodeName string) (map[string]volumeUsage, error) {
+				if nodeName != "node-1" {
+					t.Errorf("got usage of node %s, expected node-1", nodeName)
+				}
+				return map[string]volumeUsage{"default/pvc-1": {usedBytes: tc.usedBytes, capacityBytes: 100 * util.Gb}}, nil
+			}
+
+			if err := e.expandAll(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), pvc.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			size := got.Spec.Resources.Requests[v1.ResourceStorage]
+			if expected := resource.MustParse(tc.expectedSize); size.Cmp(expected) != 0 {
+				t.Errorf("got requested size %s, expected %s", size.String(), tc.expectedSize)
+			}
+			select {
+			case event := <-recorder.Events:
+				if tc.expectedEvent == "" || !strings.Contains(event, tc.expectedEvent) {
+					t.Errorf("got event %q, expected %q", event, tc.expectedEvent)
+				}
+			default:
+				if tc.expectedEvent != "" {
+					t.Errorf("expected event %q", tc.expectedEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestParseKubeletVolumeUsage(t *testing.T) {
+	raw := []byte(`{"node":{"nodeName":"node-1"},"pods":[{"podRef":{"name":"pod-1","namespace":"default"},"volume":[` +
+		`{"name":"data","usedBytes":85,"capacityBytes":100,"pvcRef":{"name":"pvc-1","namespace":"default"}},` +
+		`{"name":"kube-api-access","usedBytes":1,"capacityBytes":2}]}]}`)
+	usages, err := parseKubeletVolumeUsage(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]volumeUsage{"default/pvc-1": {usedBytes: 85, capacityBytes: 100}}
+	if !reflect.DeepEqual(usages, expected) {
+		t.Errorf("got usages %v, expected %v", usages, expected)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// Labels of the backups taken by a BackupPolicy, to find them once the policy is gone.
+	tagKeyBackupPolicyNamespace = "storage_gke_io_backup-policy-namespace"
+	tagKeyBackupPolicyName      = "storage_gke_io_backup-policy-name"
+
+	// maxBackupNamePrefixLength leaves room in the 63 characters of a backup name for the
+	// timestamp suffix.
+	maxBackupNamePrefixLength = 47
+	backupNameTimeFormat      = "20060102-150405"
+)
+
+// backupPolicyController takes the backups scheduled by the BackupPolicy resources. Every
+// period, it backs up each bound PVC of the driver selected by a policy whose last backup is
+// older than the schedule of the policy, through the CreateSnapshot flow, and deletes the
+// oldest backups of the PVC beyond the retention count of the policy. The backups taken by a
+// policy are tracked in its status, written after each backup so that a failed write loses at
+// most one backup, and labeled with the policy. They are kept when the PVC or the policy is
+// deleted.
+type backupPolicyController struct {
+	cs           *controllerServer
+	driverName   string
+	period       time.Duration
+	kubeClient   kubernetes.Interface
+	driverClient clientset.Interface
+	now          func() time.Time
+}
+
+func newBackupPolicyController(driverName string, period time.Duration, kubeClient kubernetes.Interface, driverClient clientset.Interface) *backupPolicyController {
+	return &backupPolicyController{
+		driverName:   driverName,
+		period:       period,
+		kubeClient:   kubeClient,
+		driverClient: driverClient,
+		now:          time.Now,
+	}
+}
+
+// Run takes the scheduled backups every period until stopCh is closed.
+func (c *backupPolicyController) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Filestore backup policy controller with poll period %v", c.period)
+	wait.Until(func() {
+		if err := c.runPolicies(context.Background()); err != nil {
+			klog.Errorf("Failed to run the backup policies: %v", err)
+		}
+	}, c.period, stopCh)
+}
+
+func (c *backupPolicyController) runPolicies(ctx context.Context) error {
+	policies, err := c.driverClient.MultishareV1().BackupPolicies("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if policy.DeletionTimestamp != nil {
+			continue
+		}
+		if err := c.runPolicy(ctx, policy); err != nil {
+			klog.Errorf("Failed to run backup policy %s/%s: %v", policy.Namespace, policy.Name, err)
+		}
+	}
+	return nil
+}
+
+// runPolicy takes the due backups of the PVCs selected by the policy, and updates its status.
+func (c *backupPolicyController) runPolicy(ctx context.Context, policy *multisharev1.BackupPolicy) error {
+	status := &multisharev1.BackupPolicyStatus{}
+	if policy.Status != nil {
+		status = policy.Status.DeepCopy()
+	}
+	message := ""
+	if err := c.backUpVolumes(ctx, policy, status); err != nil {
+		message = err.Error()
+	}
+	return c.updateStatus(ctx, policy, func(status *multisharev1.BackupPolicyStatus) {
+		status.Error = message
+	})
+}
+
+// updateStatus applies update to the status of the latest version of the policy and writes it
+// if changed, retrying on conflict, e.g. with an update of the spec of the policy.
+func (c *backupPolicyController) updateStatus(ctx context.Context, policy *multisharev1.BackupPolicy, update func(status *multisharev1.BackupPolicyStatus)) error {
+	policies := c.driverClient.MultishareV1().BackupPolicies(policy.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := policies.Get(ctx, policy.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		status := &multisharev1.BackupPolicyStatus{}
+		if latest.Status != nil {
+			status = latest.Status.DeepCopy()
+		}
+		update(status)
+		if reflect.DeepEqual(latest.Status, status) {
+			return nil
+		}
+		latest = latest.DeepCopy()
+		latest.Status = status
+		_, err = policies.UpdateStatus(ctx, latest, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// backUpVolumes backs up the PVCs selected by the policy whose last backup is due, and records
+// the backups in status and in the status of the policy after each PVC. It returns the first
+// error, after going through all the PVCs, or the error of a failed status write.
+func (c *backupPolicyController) backUpVolumes(ctx context.Context, policy *multisharev1.BackupPolicy, status *multisharev1.BackupPolicyStatus) error {
+	if policy.Spec.Schedule.Duration <= 0 {
+		return fmt.Errorf("schedule must be positive, got %v", policy.Spec.Schedule.Duration)
+	}
+	if policy.Spec.RetentionCount < 0 {
+		return fmt.Errorf("retentionCount must not be negative, got %d", policy.Spec.RetentionCount)
+	}
+	selector := labels.Everything()
+	if policy.Spec.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(policy.Spec.Selector); err != nil {
+			return fmt.Errorf("invalid selector: %w", err)
+		}
+	}
+	pvcs, err := c.kubeClient.CoreV1().PersistentVolumeClaims(policy.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Status.Phase != v1.ClaimBound || pvc.Spec.VolumeName == "" || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		previous := multisharev1.VolumeBackups{PVCName: pvc.Name}
+		if index := volumeBackupsIndex(status, pvc.Name); index >= 0 {
+			previous = status.Volumes[index]
+		}
+		backups := *previous.DeepCopy()
+		err := c.backUpVolume(ctx, policy, pvc, &backups)
+		if !reflect.DeepEqual(previous, backups) {
+			setVolumeBackups(status, backups)
+			if updateErr := c.updateStatus(ctx, policy, func(status *multisharev1.BackupPolicyStatus) {
+				setVolumeBackups(status, backups)
+			}); updateErr != nil {
+				return fmt.Errorf("failed to record the backups %v of PVC %s: %w", backups.Backups, pvc.Name, updateErr)
+			}
+		}
+		if err != nil {
+			klog.Errorf("Backup policy %s/%s failed to back up PVC %s: %v", policy.Namespace, policy.Name, pvc.Name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("PVC %s: %w", pvc.Name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// volumeBackupsIndex returns the index of the backups of a PVC in status, -1 if missing.
+func volumeBackupsIndex(status *multisharev1.BackupPolicyStatus, pvcName string) int {
+	for i := range status.Volumes {
+		if status.Volumes[i].PVCName == pvcName {
+			return i
+		}
+	}
+	return -1
+}
+
+// setVolumeBackups replaces the backups of a PVC in status, or adds them if missing.
+func setVolumeBackups(status *multisharev1.BackupPolicyStatus, backups multisharev1.VolumeBackups) {
+	if index := volumeBackupsIndex(status, backups.PVCName); index >= 0 {
+		status.Volumes[index] = backups
+	} else if len(backups.Backups) > 0 {
+		status.Volumes = append(status.Volumes, backups)
+	}
+}
+
+// backUpVolume takes a backup of the PVC if its last one is older than the schedule of the
+// policy, and deletes its oldest backups beyond the retention count.
+func (c *backupPolicyController) backUpVolume(ctx context.Context, policy *multisharev1.BackupPolicy, pvc *v1.PersistentVolumeClaim, backups *multisharev1.VolumeBackups) error {
+	now := c.now()
+	if !backups.LastBackupTime.IsZero() && now.Sub(backups.LastBackupTime.Time) < policy.Spec.Schedule.Duration {
+		return nil
+	}
+	pv, err := c.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != c.driverName {
+		// The policy selects the PVCs of the other drivers as well.
+		return nil
+	}
+
+	parameters := map[string]string{
+		util.VolumeSnapshotTypeKey: util.VolumeSnapshotTypeBackup,
+		ParameterKeyPVCName:        pvc.Name,
+		ParameterKeyPVCNamespace:   pvc.Namespace,
+		ParameterKeyPVName:         pv.Name,
+		ParameterKeyLabels: fmt.Sprintf("%s=%s,%s=%s",
+			tagKeyBackupPolicyNamespace, policy.Namespace,
+			tagKeyBackupPolicyName, strings.ReplaceAll(policy.Name, ".", "_")),
+	}
+	if policy.Spec.Region != "" {
+		parameters[util.VolumeSnapshotLocationKey] = policy.Spec.Region
+	}
+	name := backupName(pv.Name, now)
+	klog.Infof("Backup policy %s/%s backing up PVC %s to backup %s", policy.Namespace, policy.Name, pvc.Name, name)
+	resp, err := c.cs.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           name,
+		SourceVolumeId: pv.Spec.CSI.VolumeHandle,
+		Parameters:     parameters,
+	})
+	if err != nil {
+		return err
+	}
+	backups.Backups = append(backups.Backups, resp.Snapshot.SnapshotId)
+	backups.LastBackupTime = metav1.NewTime(now)
+
+	for policy.Spec.RetentionCount > 0 && len(backups.Backups) > policy.Spec.RetentionCount {
+		klog.Infof("Backup policy %s/%s deleting backup %s of PVC %s beyond its retention count %d", policy.Namespace, policy.Name, backups.Backups[0], pvc.Name, policy.Spec.RetentionCount)
+		if _, err := c.cs.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: backups.Backups[0]}); err != nil {
+			return err
+		}
+		backups.Backups = backups.Backups[1:]
+	}
+	return nil
+}
+
+// backupName returns the name of a backup of a PV taken at time t, e.g.
+// pvc-5f1c2f0e-8f5a-4d6e-9c3e-1a2b3c4d5e6f-20240102-030405.
+func backupName(volumeName string, t time.Time) string {
+	prefix := strings.ReplaceAll(strings.ToLower(volumeName), ".", "-")
+	if len(prefix) > maxBackupNamePrefixLength {
+		prefix = prefix[:maxBackupNamePrefixLength]
+	}
+	return strings.TrimRight(prefix, "-") + "-" + t.UTC().Format(backupNameTimeFormat)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestBackupPolicyController(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	ctx := context.Background()
+	selected := map[string]string{"backup": "daily"}
+	kubeClient := kubefake.NewSimpleClientset(
+		testPV("pv", testDriverName, testVolumeID, "claim"),
+		testPV("other-pv", "other-driver", testVolumeID, "other-driver-claim"),
+		testClaim("claim", "pv", nil, selected),
+		testClaim("other-driver-claim", "other-pv", nil, selected),
+		testClaim("unselected-claim", "pv", nil, nil),
+	)
+	driverClient := fake.NewSimpleClientset(&multisharev1.BackupPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "daily.policy", Namespace: "default"},
+		Spec: multisharev1.BackupPolicySpec{
+			Selector:       &metav1.LabelSelector{MatchLabels: selected},
+			Schedule:       metav1.Duration{Duration: 24 * time.Hour},
+			RetentionCount: 2,
+		},
+	})
+	c := newBackupPolicyController(testDriverName, time.Minute, kubeClient, driverClient)
+	c.cs = cs
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreBackUp, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var expectedBackups []string
+	for _, tc := range []struct {
+		elapsed   time.Duration
+		newBackup bool
+	}{
+		{elapsed: 0, newBackup: true},
+		{elapsed: time.Hour},
+		{elapsed: 24 * time.Hour, newBackup: true},
+		{elapsed: 48 * time.Hour, newBackup: true},
+	} {
+		now := start.Add(tc.elapsed)
+		c.now = func() time.Time { return now }
+		if err := c.runPolicies(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tc.newBackup {
+			expectedBackups = append(expectedBackups, "projects/test-project/locations/us-central1/backups/"+backupName("pv", now))
+		}
+
+		policy, err := driverClient.MultishareV1().BackupPolicies("default").Get(ctx, "daily.policy", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get policy: %v", err)
+		}
+		if policy.Status == nil || policy.Status.Error != "" || len(policy.Status.Volumes) != 1 {
+			t.Fatalf("after %v, got status %+v, expected the backups of PVC claim only", tc.elapsed, policy.Status)
+		}
+		got := policy.Status.Volumes[0]
+		// The backups beyond the retention count are deleted.
+		retained := expectedBackups
+		if len(retained) > 2 {
+			retained = retained[len(retained)-2:]
+		}
+		if got.PVCName != "claim" || strings.Join(got.Backups, ",") != strings.Join(retained, ",") {
+			t.Errorf("after %v, got backups %+v, expected %v", tc.elapsed, got, retained)
+		}
+		for i, uri := range expectedBackups {
+			backup, err := cs.config.fileService.GetBackup(ctx, uri)
+			if i < len(expectedBackups)-len(retained) {
+				if !file.IsNotFoundErr(err) {
+					t.Errorf("expected backup %s to be deleted, got error %v", uri, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("failed to get backup %s: %v", uri, err)
+			}
+			labels := backup.Backup.Labels
+			if labels[tagKeyBackupPolicyNamespace] != "default" || labels[tagKeyBackupPolicyName] != "daily_policy" || labels[tagKeyCreatedForClaimName] != "claim" {
+				t.Errorf("got backup labels %v", labels)
+			}
+		}
+	}
+}
+
+func TestBackupPolicyControllerStatusConflict(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	ctx := context.Background()
+	kubeClient := kubefake.NewSimpleClientset(
+		testPV("pv", testDriverName, testVolumeID, "claim"),
+		testPV("other-pv", testDriverName, testVolumeID, "other-claim"),
+		testClaim("claim", "pv", nil, nil),
+		testClaim("other-claim", "other-pv", nil, nil),
+	)
+	driverClient := fake.NewSimpleClientset(&multisharev1.BackupPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+		Spec:       multisharev1.BackupPolicySpec{Schedule: metav1.Duration{Duration: time.Hour}},
+	})
+	// Every other status write conflicts, e.g. with an update of the spec of the policy.
+	writes := 0
+	driverClient.PrependReactor("update", "backuppolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "status" {
+			return false, nil, nil
+		}
+		writes++
+		if writes%2 == 1 {
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "backuppolicies"}, "policy", nil)
+		}
+		return false, nil, nil
+	})
+	c := newBackupPolicyController(testDriverName, time.Minute, kubeClient, driverClient)
+	c.cs = cs
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreBackUp, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	if err := c.runPolicies(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policy, err := driverClient.MultishareV1().BackupPolicies("default").Get(ctx, "policy", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get policy: %v", err)
+	}
+	if policy.Status == nil || policy.Status.Error != "" || len(policy.Status.Volumes) != 2 {
+		t.Fatalf("got status %+v, expected the backups of both PVCs", policy.Status)
+	}
+	for i, pvName := range []string{"pv", "other-pv"} {
+		expected := "projects/test-project/locations/us-central1/backups/" + backupName(pvName, now)
+		if got := policy.Status.Volumes[i].Backups; strings.Join(got, ",") != expected {
+			t.Errorf("got backups %v, expected %v", got, expected)
+		}
+	}
+	// Each backup is recorded on its own, before the next one is taken.
+	if writes != 4 {
+		t.Errorf("got %d status writes, expected 4", writes)
+	}
+}
+
+func TestBackupPolicyControllerInvalidPolicy(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	ctx := context.Background()
+	kubeClient := kubefake.NewSimpleClientset(testPV("pv", testDriverName, testVolumeID, "claim"), testClaim("claim", "pv", nil, nil))
+	driverClient := fake.NewSimpleClientset(&multisharev1.BackupPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+		Spec:       multisharev1.BackupPolicySpec{RetentionCount: 2},
+	})
+	c := newBackupPolicyController(testDriverName, time.Minute, kubeClient, driverClient)
+	c.cs = cs
+
+	if err := c.runPolicies(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policy, err := driverClient.MultishareV1().BackupPolicies("default").Get(ctx, "policy", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get policy: %v", err)
+	}
+	if expected := "schedule must be positive, got 0s"; policy.Status == nil || policy.Status.Error != expected || len(policy.Status.Volumes) != 0 {
+		t.Errorf("got status %+v, expected error %q and no backups", policy.Status, expected)
+	}
+}
+
+func TestBackupName(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("UTC+1", 3600))
+	tests := []struct {
+		volumeName string
+		expected   string
+	}{
+		{volumeName: "pvc-5f1c2f0e-8f5a-4d6e-9c3e-1a2b3c4d5e6f", expected: "pvc-5f1c2f0e-8f5a-4d6e-9c3e-1a2b3c4d5e6f-20240102-020405"},
+		{volumeName: "static.volume", expected: "static-volume-20240102-020405"},
+		{volumeName: strings.Repeat("a", 46) + "-b", expected: strings.Repeat("a", 46) + "-20240102-020405"},
+	}
+	for _, tc := range tests {
+		if got := backupName(tc.volumeName, now); got != tc.expected {
+			t.Errorf("volume %q: got backup name %q, expected %q", tc.volumeName, got, tc.expected)
+		}
+	}
+}
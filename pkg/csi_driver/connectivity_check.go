@@ -0,0 +1,67 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+// nfsServerPort is the port of the NFS server of the instances.
+const nfsServerPort = "2049"
+
+// connectivityCheck verifies the NFS server of the new instances is reachable from the
+// controller, through the pod network, before their volumes are provisioned, so that the
+// peering and firewall misconfigurations fail the provisioning instead of the first mount.
+type connectivityCheck struct {
+	timeout time.Duration
+	dial    func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// newConnectivityCheck returns a check dialing the instances for at most timeout, or nil if
+// timeout is not positive.
+func newConnectivityCheck(timeout time.Duration) *connectivityCheck {
+	if timeout <= 0 {
+		return nil
+	}
+	return &connectivityCheck{timeout: timeout, dial: (&net.Dialer{}).DialContext}
+}
+
+// check dials the NFS port of the instance. It returns an Unavailable error if the instance
+// can't be reached, so that the provisioning is retried, the instance being kept.
+func (c *connectivityCheck) check(ctx context.Context, volumeID string, instance *file.ServiceInstance) error {
+	if c == nil || instance.Network.Ip == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	address := net.JoinHostPort(instance.Network.Ip, nfsServerPort)
+	conn, err := c.dial(ctx, "tcp", address)
+	if err != nil {
+		klog.Errorf("Instance %s/%s of volume %s is not reachable at %s from the controller: %v", instance.Location, instance.Name, volumeID, address, err)
+		return status.Errorf(codes.Unavailable, "instance %s/%s is ready but its NFS server %s is not reachable from the controller within %v, check the peering of network %q and the firewall rules allowing the NFS traffic: %v", instance.Location, instance.Name, address, c.timeout, instance.Network.Name, err)
+	}
+	conn.Close()
+	klog.V(4).Infof("Instance %s/%s of volume %s is reachable at %s", instance.Location, instance.Name, volumeID, address)
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestConnectivityCheck(t *testing.T) {
+	instance := &file.ServiceInstance{
+		Location: testLocation,
+		Name:     testCSIVolume,
+		Network:  file.Network{Name: "default", Ip: "1.1.1.1"},
+	}
+	cases := []struct {
+		name            string
+		check           *connectivityCheck
+		dialErr         error
+		expectedAddress string
+		expectedCode    codes.Code
+	}{
+		{
+			name:  "disabled",
+			check: newConnectivityCheck(0),
+		},
+		{
+			name:            "reachable",
+			check:           newConnectivityCheck(time.Second),
+			expectedAddress: "1.1.1.1:2049",
+		},
+		{
+			name:            "unreachable",
+			check:           newConnectivityCheck(time.Second),
+			dialErr:         errors.New("i/o timeout"),
+			expectedAddress: "1.1.1.1:2049",
+			expectedCode:    codes.Unavailable,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var dialed string
+			if tc.check != nil {
+				tc.check.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+					dialed = address
+					if tc.dialErr != nil {
+						return nil, tc.dialErr
+					}
+					client, server := net.Pipe()
+					server.Close()
+					return client, nil
+				}
+			}
+			err := tc.check.check(context.Background(), testVolumeID, instance)
+			if status.Code(err) != tc.expectedCode {
+				t.Errorf("expected code %v, got error %v", tc.expectedCode, err)
+			}
+			if dialed != tc.expectedAddress {
+				t.Errorf("expected dial of %q, got %q", tc.expectedAddress, dialed)
+			}
+		})
+	}
+}
@@ -19,11 +19,15 @@ package driver
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	pbSanitizer "github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -70,8 +74,17 @@ const (
 	attrIP                 = "ip"
 	attrVolume             = "volume"
 	attrSupportLockRelease = "supportLockRelease"
+	attrTier               = "tier"
+	// attrSubdir is an optional directory, relative to the root of the share, to mount instead of the whole share.
+	attrSubdir = "subdir"
+	// attrContextVersion is the volumeContextVersion of the controller which provisioned the volume.
+	attrContextVersion = "contextVersion"
 )
 
+// volumeContextVersion is the version of the volume attributes set by the controller, bumped
+// whenever older node drivers cannot mount the volumes with the new attributes.
+const volumeContextVersion = 1
+
 // CreateVolume parameters
 const (
 	paramTier                      = "tier"
@@ -85,11 +98,22 @@ const (
 	ParamMultishareInstanceScLabel = "instance-storageclass-label"
 	ParamNfsExportOptions          = "nfs-export-options-on-create"
 	paramMaxVolumeSize             = "max-volume-size"
+	paramMinInstanceSize           = "min-instance-size"
+	paramMaxInstanceSize           = "max-instance-size"
+	paramShareSpreadByNamespace    = "share-spread-by-namespace"
+	paramAllowNewInstances         = "allow-new-instances"
+	paramDeletionProtection        = "deletion-protection"
+	paramBackupBeforeExpand        = "backup-before-expand"
+	paramShareNameWithScPrefix     = "share-name-with-sc-prefix"
 
 	// Keys for PV and PVC parameters as reported by external-provisioner
 	ParameterKeyPVCName      = "csi.storage.k8s.io/pvc/name"
 	ParameterKeyPVCNamespace = "csi.storage.k8s.io/pvc/namespace"
 	ParameterKeyPVName       = "csi.storage.k8s.io/pv/name"
+	// ParameterKeyVolumeSnapshotNamespace is the namespace of the VolumeSnapshot of a
+	// CreateSnapshot request, as reported by the external-snapshotter, which is the namespace
+	// of the PVC of its source volume.
+	ParameterKeyVolumeSnapshotNamespace = "csi.storage.k8s.io/volumesnapshot/namespace"
 
 	// User provided labels
 	ParameterKeyLabels = "labels"
@@ -102,6 +126,34 @@ const (
 	tagKeySnapshotName             = "storage_gke_io_created-for_csi_snapshot_name"
 	TagKeyClusterName              = "storage_gke_io_cluster_name"
 	TagKeyClusterLocation          = "storage_gke_io_cluster_location"
+	// TagKeyClusterUID and TagKeyDriverVersion record the cluster, see --cluster-uid, and the
+	// version of the driver which created, or last resized, a multishare instance.
+	TagKeyClusterUID    = "storage_gke_io_cluster_uid"
+	TagKeyDriverVersion = "storage_gke_io_driver_version"
+	// TagKeyExcludeFromPacking is set to "true" by operators on the multishare instances on
+	// which no new share is placed, e.g. to drain them ahead of their decommissioning. Their
+	// existing shares keep being served, expanded and deleted.
+	TagKeyExcludeFromPacking = "exclude-from-packing"
+	// TagKeySharedClusterGroup is set on the multishare instances of the clusters sharing
+	// them, see --shared-cluster-group.
+	TagKeySharedClusterGroup = "storage_gke_io_shared_cluster_group"
+	// TagKeyDeletionProtection is set on the instances created with the deletion-protection
+	// parameter. DeleteVolume refuses to delete them unless the label is removed, or the
+	// controller clears the protection, see --clear-deletion-protection.
+	TagKeyDeletionProtection = "storage_gke_io_deletion-protection"
+	// TagKeyBackupBeforeExpand is set on the enterprise instances and the shares created with the
+	// backup-before-expand parameter. ControllerExpandVolume backs them up before expanding them.
+	TagKeyBackupBeforeExpand = "storage_gke_io_backup-before-expand"
+)
+
+// Keys of the provisioner secret, set with the csi.storage.k8s.io/provisioner-secret-* StorageClass
+// parameters to manage the basic instances of a StorageClass with another service account than the
+// driver's. The secret must also be set as the controller-expand secret for the volumes to expand.
+const (
+	// secretKeyServiceAccountKey is the JSON key of the service account.
+	secretKeyServiceAccountKey = "key.json"
+	// secretKeyProjectID is the project of the instances, the project of the key by default.
+	secretKeyProjectID = "project-id"
 )
 
 type capacityRangeForTier struct {
@@ -120,22 +172,81 @@ type controllerServerConfig struct {
 	cloud                *cloud.Cloud
 	ipAllocator          *util.IPAllocator
 	volumeLocks          *util.VolumeLocks
+	inFlightRequests     *util.InFlightRequests
 	enableMultishare     bool
 	statefulController   *MultishareStatefulController
 	multiShareController *MultishareController
-	reconciler           *MultishareReconciler
-	metricsManager       *metrics.MetricsManager
-	ecfsDescription      string
-	isRegional           bool
-	clusterName          string
-	features             *GCFSDriverFeatureOptions
-	extraVolumeLabels    map[string]string
-	tagManager           cloud.TagService
+	listParallelism      int // Max concurrent per-instance share list calls of the multishare ops manager
+	// stuckOpThreshold is the age after which a running multishare operation is reported stuck.
+	stuckOpThreshold   time.Duration
+	instancePools      *InstancePoolsConfig
+	reconciler         *MultishareReconciler
+	metricsManager     *metrics.MetricsManager
+	ecfsDescription    string
+	isRegional         bool
+	clusterName        string
+	clusterLocation    string
+	clusterUID         string
+	sharedClusterGroup string
+	features           *GCFSDriverFeatureOptions
+	extraVolumeLabels  map[string]string
+	// clearDeletionProtection deletes the volumes with deletion protection instead of refusing to.
+	clearDeletionProtection bool
+	// volumeLocationAliases maps the location of the volume IDs to the location their
+	// instances are looked up at when not found, see getVolumeInstance.
+	volumeLocationAliases map[string]string
+	// allowSoftMounts allows the soft mount policy, see mountPolicyOptions.
+	allowSoftMounts bool
+	// verifyNodeExpansion requires the node expansion of the expanded volumes, see NodeExpandVolume.
+	verifyNodeExpansion bool
+	// maxRegionalCapacityBytes, if non-zero, caps the total capacity of the multishare
+	// instances managed by the driver in a region, see checkRegionalCapacity.
+	maxRegionalCapacityBytes int64
+	// networkBackoff, if non-nil, backs off the instance creations in the exhausted networks.
+	networkBackoff *networkBackoff
+	// nfsFirewall, if non-nil, allows the NFS traffic of the new instances in their network.
+	nfsFirewall *nfsFirewall
+	// connectivityCheck, if non-nil, verifies the new instances are reachable from the controller.
+	connectivityCheck *connectivityCheck
+	// opTimeouts, if non-nil, are the deadlines of the waits on the operations of the volumes.
+	opTimeouts *OpTimeouts
+	// tierPolicy, if non-nil, restricts the tiers of the new volumes.
+	tierPolicy *TierPolicy
+	// costEstimator, if non-nil, estimates the monthly cost of the instances created and expanded.
+	costEstimator *costEstimator
+	// shareExportAnnotations, if non-nil, overrides the export options of the new shares with
+	// the annotations of their PVC.
+	shareExportAnnotations *shareExportAnnotations
+	// parameterDefaults, if non-nil, are merged into the parameters of the CreateVolume
+	// requests omitting them.
+	parameterDefaults *ParameterDefaults
+	// backupBeforeExpandTimeout bounds the wait for the backups taken before expansions.
+	backupBeforeExpandTimeout time.Duration
+	tagManager                cloud.TagService
+	instanceEvents            *instanceEventsReporter
+	deleteQueue               *deleteQueue
+	shareMigrator             *shareMigrator
+	shareRebalancer           *shareRebalancer
+	instanceLabelReconciler   *instanceLabelReconciler
+	instanceIPReconciler      *instanceIPReconciler
+	instanceCreationDeferrer  *instanceCreationDeferrer
+	volumeRestorer            *volumeRestorer
+	replicaPromoter           *replicaPromoter
+	backupPolicyController    *backupPolicyController
+	restoreProgress           *restoreProgressReporter
+	instanceIntents           *instanceIntents
+	autoExpander              *autoExpander
 }
 
 func newControllerServer(config *controllerServerConfig) csi.ControllerServer {
 	cs := &controllerServer{config: config}
 	config.ipAllocator = util.NewIPAllocator(make(map[string]bool))
+	config.inFlightRequests = util.NewInFlightRequests()
+	if config.features != nil && config.features.FeatureStateful != nil && config.features.FeatureStateful.Enabled {
+		config.instanceIntents = newInstanceIntents(config.features.FeatureStateful.DriverClientSet)
+	} else {
+		config.instanceIntents = newInstanceIntents(nil)
+	}
 	if config.enableMultishare {
 		config.multiShareController = NewMultishareController(config)
 		config.multiShareController.opsManager.controllerServer = cs
@@ -145,9 +256,33 @@ func newControllerServer(config *controllerServerConfig) csi.ControllerServer {
 				config.statefulController = NewMultishareStatefulController(config)
 				config.statefulController.mc = config.multiShareController
 			}
+			if config.shareMigrator != nil {
+				config.shareMigrator.mc = config.multiShareController
+			}
+			if config.shareRebalancer != nil {
+				config.shareRebalancer.mc = config.multiShareController
+			}
+			if config.instanceLabelReconciler != nil {
+				config.instanceLabelReconciler.mc = config.multiShareController
+			}
 
 		}
 	}
+	if config.features != nil && config.features.FeatureDeleteRetryQueue != nil && config.features.FeatureDeleteRetryQueue.Enabled {
+		config.deleteQueue = newDeleteQueue(config.features.FeatureDeleteRetryQueue, func(ctx context.Context, volumeID string) error {
+			_, err := cs.deleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+			return err
+		}, config.metricsManager)
+	}
+	if config.volumeRestorer != nil {
+		config.volumeRestorer.cs = cs
+	}
+	if config.replicaPromoter != nil {
+		config.replicaPromoter.cs = cs
+	}
+	if config.backupPolicyController != nil {
+		config.backupPolicyController.cs = cs
+	}
 	if config.reconciler != nil {
 		klog.Infof("stateful reconciler enabled, setting its controller server")
 		config.reconciler.controllerServer = cs
@@ -156,6 +291,12 @@ func newControllerServer(config *controllerServerConfig) csi.ControllerServer {
 }
 
 func (m *controllerServer) Run(stopCh <-chan struct{}) {
+	if m.config.instanceEvents != nil {
+		go m.config.instanceEvents.Run(stopCh)
+	}
+	if m.config.deleteQueue != nil {
+		go m.config.deleteQueue.Run(stopCh)
+	}
 	if m.config.multiShareController == nil {
 		return
 	}
@@ -163,12 +304,140 @@ func (m *controllerServer) Run(stopCh <-chan struct{}) {
 	m.config.multiShareController.Run(stopCh)
 }
 
+// hasLeaderLoops returns whether a loop of runLeaderLoops is enabled.
+func (m *controllerServer) hasLeaderLoops() bool {
+	multishare := m.config.multiShareController != nil
+	return m.config.volumeRestorer != nil || m.config.replicaPromoter != nil || m.config.backupPolicyController != nil ||
+		m.config.restoreProgress != nil || m.config.instanceIPReconciler != nil || m.config.autoExpander != nil ||
+		(multishare && (m.config.shareMigrator != nil || m.config.shareRebalancer != nil || m.config.instanceLabelReconciler != nil))
+}
+
+// runLeaderLoops runs the loops updating the Filestore resources, the PVs and the PVCs until
+// stopCh is closed. They run on a single controller replica, the leader, see GCFSDriver.Run.
+func (m *controllerServer) runLeaderLoops(stopCh <-chan struct{}) {
+	if m.config.volumeRestorer != nil {
+		go m.config.volumeRestorer.Run(stopCh)
+	}
+	if m.config.replicaPromoter != nil {
+		go m.config.replicaPromoter.Run(stopCh)
+	}
+	if m.config.backupPolicyController != nil {
+		go m.config.backupPolicyController.Run(stopCh)
+	}
+	if m.config.restoreProgress != nil {
+		go m.config.restoreProgress.Run(stopCh)
+	}
+	if m.config.instanceIPReconciler != nil {
+		go m.config.instanceIPReconciler.Run(stopCh)
+	}
+	if m.config.autoExpander != nil {
+		go m.config.autoExpander.Run(stopCh)
+	}
+	if m.config.multiShareController == nil {
+		return
+	}
+	if m.config.shareMigrator != nil {
+		go m.config.shareMigrator.Run(stopCh)
+	}
+	if m.config.shareRebalancer != nil {
+		go m.config.shareRebalancer.Run(stopCh)
+	}
+	if m.config.instanceLabelReconciler != nil {
+		go m.config.instanceLabelReconciler.Run(stopCh)
+	}
+}
+
+// joinInFlightRequest runs fn unless an identical request for the same key is already being
+// served, in which case the result of the ongoing request is returned. This lets aggressive
+// sidecar retries attach to the ongoing work instead of failing with Aborted.
+func (s *controllerServer) joinInFlightRequest(ctx context.Context, method, key string, req proto.Message, fn func() (interface{}, error)) (interface{}, error) {
+	if key == "" {
+		// Let the handler reject the request.
+		return fn()
+	}
+	requestKey := method + "/" + key
+	resp, err := s.config.inFlightRequests.Do(ctx, requestKey, req, fn)
+	if errors.Is(err, util.ErrConflictingInFlightRequest) {
+		return nil, volumeOperationInProgressError(s.config.metricsManager, method, key)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, status.FromContextError(err).Err()
+	}
+	return resp, err
+}
+
 // CreateVolume creates a GCFS instance
 func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	resp, err := s.joinInFlightRequest(ctx, methodCreateVolume, req.GetName(), req, func() (interface{}, error) {
+		return s.createVolume(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.CreateVolumeResponse), nil
+}
+
+func (s *controllerServer) createVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	mountPolicyAttr, err := mountPolicyVolumeContext(req.GetParameters(), req.GetVolumeCapabilities(), s.config.allowSoftMounts)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	template, err := hostnameTemplate(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	authFlavorAttr, err := authFlavorVolumeContext(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp, err := s.provisionVolume(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range mountPolicyAttr {
+		resp.Volume.VolumeContext[k] = v
+	}
+	for k, v := range authFlavorAttr {
+		resp.Volume.VolumeContext[k] = v
+	}
+	if template != "" {
+		instance, _, err := instanceOfVolume(resp.Volume.VolumeId, s.config.cloud.Project)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		hostname, err := renderHostname(template, instance.Project, instance.Location, instance.Name)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		resp.Volume.VolumeContext[attrHostname] = hostname
+	}
+	return resp, nil
+}
+
+// provisionVolume creates the instance or the share of a volume.
+func (s *controllerServer) provisionVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	req = s.withParameterDefaults(req)
+	if err := s.config.tierPolicy.check(effectiveTier(req.GetParameters())); err != nil {
+		return nil, err
+	}
+	parent, err := parentInstance(req.GetParameters(), s.config.cloud.Project)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if parent != nil {
+		return s.createInstanceShare(ctx, req, parent)
+	}
 	if strings.ToLower(req.GetParameters()[paramMultishare]) == "true" {
 		if s.config.multiShareController == nil {
 			return nil, status.Error(codes.InvalidArgument, "multishare controller not enabled")
 		}
+		if _, ok := req.GetSecrets()[secretKeyServiceAccountKey]; ok {
+			return nil, status.Error(codes.InvalidArgument, "provisioner secret credentials are not supported for multishare volumes")
+		}
+		req, err = s.config.shareExportAnnotations.apply(ctx, req)
+		if err != nil {
+			return nil, err
+		}
 		start := time.Now()
 		var response *csi.CreateVolumeResponse
 		var err error
@@ -181,14 +450,14 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		s.config.metricsManager.RecordOperationMetrics(err, methodCreateVolume, modeMultishare, duration)
 
 		if err != nil {
-			klog.Errorf("CreateVolume returned an error %v, for request %+v", err, req)
+			klog.Errorf("CreateVolume returned an error %v, for request %+v", err, pbSanitizer.StripSecrets(req))
 			return nil, err
 		}
-		klog.Infof("CreateVolume response %v, for request %+v", response, req)
+		klog.Infof("CreateVolume response %v, for request %+v", response, pbSanitizer.StripSecrets(req))
 		return response, nil
 	}
 
-	klog.V(4).Infof("CreateVolume called with request %+v", req)
+	klog.V(4).Infof("CreateVolume called with request %+v", pbSanitizer.StripSecrets(req))
 	name := req.GetName()
 	if len(name) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "CreateVolume name must be provided")
@@ -201,7 +470,7 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 	tier := getTierFromParams(req.GetParameters())
 	capBytes, err := getRequestCapacity(req.GetCapacityRange(), tier)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
 
 	// we do not yet support zonal small
@@ -215,10 +484,22 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	fileService, project, err := s.cloudForSecrets(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	newFiler.Project = project
+	if err := validateLocation(ctx, fileService, project, newFiler.Location); err != nil {
+		return nil, err
+	}
+	zones, err := instanceZones(newFiler, req.GetParameters(), req.GetAccessibilityRequirements())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	volumeID := getVolumeIDFromFileInstance(newFiler, modeInstance)
 	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+		return nil, volumeOperationInProgressError(s.config.metricsManager, methodCreateVolume, volumeID)
 	}
 	defer s.config.volumeLocks.Release(volumeID)
 
@@ -233,19 +514,19 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			if err != nil || !isBackupSource {
 				return nil, status.Errorf(codes.InvalidArgument, "Unsupported volume content source %v", id)
 			}
-			_, err = s.config.fileService.GetBackup(ctx, id)
+			_, err = file.GetSourceBackup(ctx, fileService, id, project)
 			if err != nil {
 				klog.Errorf("Failed to get volume %v source snapshot %v: %v", name, id, err.Error())
-				return nil, file.StatusError(err)
+				return nil, err
 			}
 			newFiler.BackupSource = id
 		}
 	}
 
-	// Check if the instance already exists
-	filer, err := s.config.fileService.GetInstance(ctx, newFiler)
+	// Check if the instance already exists, in any of the zones it may have been created in.
+	filer, zones, err := getInstanceInZones(ctx, fileService, newFiler, zones)
 	// No error is returned if the instance is not found during CreateVolume.
-	if err != nil && !file.IsNotFoundErr(err) {
+	if err != nil {
 		return nil, file.StatusError(err)
 	}
 
@@ -257,6 +538,7 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		}
 		// Check if the filestore instance is in the process of getting created.
 		if filer.State == "CREATING" {
+			s.trackRestore(fileService, newFiler, volumeID, req.GetParameters())
 			msg := fmt.Sprintf("Volume %v not ready, current state: %v", name, filer.State)
 			klog.V(4).Infof(msg)
 			return nil, status.Error(codes.DeadlineExceeded, msg)
@@ -267,6 +549,12 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			return nil, status.Error(codes.Internal, msg)
 		}
 	} else {
+		if err := s.config.instanceCreationDeferrer.check(ctx, req); err != nil {
+			return nil, err
+		}
+		if err := s.config.networkBackoff.check(project, newFiler.Network.Name); err != nil {
+			return nil, err
+		}
 		param := req.GetParameters()
 		// If we are creating a new instance, we need pick an unused CIDR range from reserved-ipv4-cidr
 		// If the param was not provided, we default reservedIPRange to "" and cloud provider takes care of the allocation
@@ -278,7 +566,7 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 				newFiler.Network.ReservedIpRange = reservedIPRange
 			}
 		} else if reservedIPV4CIDR, ok := param[ParamReservedIPV4CIDR]; ok {
-			reservedIPRange, err := s.reserveIPRange(ctx, newFiler, reservedIPV4CIDR)
+			reservedIPRange, err := s.reserveIPRange(ctx, fileService, newFiler, reservedIPV4CIDR)
 
 			// Possible cases are 1) CreateInstanceAborted, 2)CreateInstance running in background
 			// The ListInstances response will contain the reservedIPRange if the operation was started
@@ -297,29 +585,74 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		if err != nil {
 			return nil, file.StatusError(err)
 		}
+		if protect, _ := strconv.ParseBool(param[paramDeletionProtection]); protect {
+			labels[TagKeyDeletionProtection] = "true"
+		}
+		if backup, _ := strconv.ParseBool(param[paramBackupBeforeExpand]); backup {
+			labels[TagKeyBackupBeforeExpand] = "true"
+		}
+		if policy, ok := lookupParam(param, paramBackupsDeletionPolicy); ok && strings.EqualFold(policy, backupsDeletionPolicyBlock) {
+			labels[TagKeyBackupsDeletionPolicy] = backupsDeletionPolicyBlock
+		}
 		newFiler.Labels = labels
 
+		timeouts, err := s.config.opTimeouts.withParams(param)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
 		// Create the instance
+		s.trackRestore(fileService, newFiler, volumeID, param)
 		var createErr error
-		filer, createErr = s.config.fileService.CreateInstance(ctx, newFiler)
+		filer, createErr = createInstanceInZones(withOpTimeout(ctx, timeouts, util.InstanceCreate), fileService, newFiler, zones)
 		if createErr != nil {
 			klog.Errorf("Create volume for volume Id %s failed: %v", volumeID, createErr.Error())
+			if exhaustedErr := s.config.networkBackoff.failed(project, newFiler.Network.Name, createErr); exhaustedErr != nil {
+				return nil, exhaustedErr
+			}
 			return nil, file.StatusError(createErr)
 		}
+		s.config.networkBackoff.succeeded(project, newFiler.Network.Name)
+		s.config.costEstimator.instanceCreated(&file.MultishareInstance{Project: project, Location: filer.Location, Name: filer.Name, Tier: filer.Tier, CapacityBytes: filer.Volume.SizeBytes}, param)
 	}
 
 	if err := s.config.tagManager.AttachResourceTags(ctx, cloud.FilestoreInstance, filer.Name, filer.Location, req.GetName(), req.GetParameters()); err != nil {
 		return nil, status.Error(codes.Unavailable, err.Error())
 	}
+	if project == s.config.cloud.Project {
+		s.config.nfsFirewall.ensure(ctx, filer.Network)
+	}
+	if err := s.config.connectivityCheck.check(ctx, volumeID, filer); err != nil {
+		return nil, err
+	}
 	resp := &csi.CreateVolumeResponse{Volume: s.fileInstanceToCSIVolume(filer, modeInstance)}
+	if _, ok := lookupParam(req.GetParameters(), paramAllowedZones); ok {
+		// The volume is only accessible from the zone its instance was created in.
+		resp.Volume.AccessibleTopology = []*csi.Topology{{Segments: map[string]string{TopologyKeyZone: newFiler.Location}}}
+	}
 
 	klog.Infof("CreateVolume succeeded: %+v", resp)
 	return resp, nil
 }
 
+// cloudForSecrets returns the Filestore service and the project to manage basic instances with
+// for a request carrying the given secrets: those of the service account key of the provisioner
+// secret if set, the driver's own otherwise.
+func (s *controllerServer) cloudForSecrets(secrets map[string]string) (file.Service, string, error) {
+	key, ok := secrets[secretKeyServiceAccountKey]
+	if !ok {
+		return s.config.fileService, s.config.cloud.Project, nil
+	}
+	scoped, err := s.config.cloud.WithCredentials([]byte(key), secrets[secretKeyProjectID])
+	if err != nil {
+		return nil, "", status.Errorf(codes.InvalidArgument, "invalid provisioner secret: %v", err)
+	}
+	return scoped.File, scoped.Project, nil
+}
+
 // reserveIPRange returns the available IP in the cidr
-func (s *controllerServer) reserveIPRange(ctx context.Context, filer *file.ServiceInstance, cidr string) (string, error) {
-	cloudInstancesReservedIPRanges, err := s.getCloudInstancesReservedIPRanges(ctx, filer)
+func (s *controllerServer) reserveIPRange(ctx context.Context, fileService file.Service, filer *file.ServiceInstance, cidr string) (string, error) {
+	cloudInstancesReservedIPRanges, err := s.getCloudInstancesReservedIPRanges(ctx, fileService, filer)
 	if err != nil {
 		return "", err
 	}
@@ -338,15 +671,15 @@ func (s *controllerServer) reserveIPRange(ctx context.Context, filer *file.Servi
 }
 
 // getCloudInstancesReservedIPRanges gets the list of reservedIPRanges from cloud instances
-func (s *controllerServer) getCloudInstancesReservedIPRanges(ctx context.Context, filer *file.ServiceInstance) (map[string]bool, error) {
-	instances, err := s.config.fileService.ListInstances(ctx, filer)
+func (s *controllerServer) getCloudInstancesReservedIPRanges(ctx context.Context, fileService file.Service, filer *file.ServiceInstance) (map[string]bool, error) {
+	instances, err := fileService.ListInstances(ctx, filer)
 	if err != nil {
 		return nil, status.Error(codes.Aborted, err.Error())
 	}
 	// Due to unreachable location some instances may not show up here.
 	// TODO: create a new function to take a list of locations
 	// and return error if unreachable contained the region of interest.
-	multiShareInstances, err := s.config.fileService.ListMultishareInstances(ctx, &file.ListFilter{Project: filer.Project, Location: "-"})
+	multiShareInstances, err := fileService.ListMultishareInstances(ctx, &file.ListFilter{Project: filer.Project, Location: "-"})
 	if err != nil {
 		return nil, status.Error(codes.Aborted, err.Error())
 	}
@@ -365,16 +698,66 @@ func (s *controllerServer) getCloudInstancesReservedIPRanges(ctx context.Context
 			cloudInstancesReservedIPRanges[instance.Network.ReservedIpRange] = true
 		}
 	}
+	// Ranges allocated in the VPC network, e.g. for private services access, cannot be used either.
+	if s.config.cloud != nil && s.config.cloud.Compute != nil {
+		allocatedRanges, err := s.config.cloud.Compute.ListAddressRanges(ctx, filer.Project, filer.Network.Name)
+		if err != nil {
+			// Listing them requires Compute API permissions the driver may not have.
+			klog.Warningf("Failed to list the IP address ranges allocated in network %s, only the ranges of Filestore instances are excluded: %v", filer.Network.Name, err)
+		}
+		for _, r := range allocatedRanges {
+			cloudInstancesReservedIPRanges[r.CIDR] = true
+		}
+	}
 	return cloudInstancesReservedIPRanges, nil
 }
 
 // DeleteVolume deletes a GCFS instance
 func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	klog.Infof("DeleteVolume called with request %+v", req)
+	resp, err := s.joinInFlightRequest(ctx, methodDeleteVolume, req.GetVolumeId(), req, func() (interface{}, error) {
+		return s.deleteVolume(ctx, req)
+	})
+	var protectedErr *deletionProtectedError
+	var backupsErr *instanceBackupsError
+	if errors.As(err, &protectedErr) || errors.As(err, &backupsErr) {
+		// Retrying does not help, the protection or the backups must be removed first.
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		// The queue retries without the secrets, the volumes deleted with provisioner secret
+		// credentials are left to the external-provisioner retries.
+		if s.config.deleteQueue != nil && len(req.GetSecrets()) == 0 && isRetriableDeleteErr(err) {
+			// The error is still returned, the PV is kept until a retry of the CO succeeds.
+			s.config.deleteQueue.enqueue(req.GetVolumeId(), err)
+		}
+		return nil, err
+	}
+	return resp.(*csi.DeleteVolumeResponse), nil
+}
+
+// deletionProtectedError is returned by deleteVolume for the volumes with deletion protection.
+type deletionProtectedError struct {
+	volumeID string
+	instance string
+}
+
+func (e *deletionProtectedError) Error() string {
+	return fmt.Sprintf("volume %s has deletion protection, remove the label %s from instance %s to delete it", e.volumeID, TagKeyDeletionProtection, e.instance)
+}
+
+func (s *controllerServer) deleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	klog.Infof("DeleteVolume called with request %+v", pbSanitizer.StripSecrets(req))
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
 	}
+	volumeID, err := s.translateLegacyVolumeID(ctx, volumeID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		return nil, err
+	}
 
 	if isMultishareVolId(volumeID) {
 		if s.config.multiShareController == nil {
@@ -391,12 +774,15 @@ func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 		duration := time.Since(start)
 		s.config.metricsManager.RecordOperationMetrics(err, methodDeleteVolume, modeMultishare, duration)
 		if err != nil {
-			klog.Errorf("Deletevolume returned error %v, for request: %+v", err, req)
+			klog.Errorf("Deletevolume returned error %v, for request: %+v", err, pbSanitizer.StripSecrets(req))
 			return nil, file.StatusError(err)
 		}
-		klog.Infof("Deletevolume response %+v, for request: %+v", response, req)
+		klog.Infof("Deletevolume response %+v, for request: %+v", response, pbSanitizer.StripSecrets(req))
 		return response, nil
 	}
+	if isInstanceShareVolID(volumeID) {
+		return s.deleteInstanceShare(ctx, volumeID)
+	}
 
 	filer, _, err := getFileInstanceFromID(volumeID)
 	if err != nil {
@@ -406,12 +792,16 @@ func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 	}
 
 	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+		return nil, volumeOperationInProgressError(s.config.metricsManager, methodDeleteVolume, volumeID)
 	}
 	defer s.config.volumeLocks.Release(volumeID)
 
-	filer.Project = s.config.cloud.Project
-	filer, err = s.config.fileService.GetInstance(ctx, filer)
+	fileService, project, err := s.cloudForSecrets(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	filer.Project = project
+	filer, err = s.getVolumeInstance(ctx, fileService, filer)
 	if err != nil {
 		if file.IsNotFoundErr(err) {
 			return &csi.DeleteVolumeResponse{}, nil
@@ -423,7 +813,17 @@ func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 		return nil, status.Errorf(codes.DeadlineExceeded, "Volume %s is in state: %s", volumeID, filer.State)
 	}
 
-	err = s.config.fileService.DeleteInstance(ctx, filer)
+	if protect, _ := strconv.ParseBool(filer.Labels[TagKeyDeletionProtection]); protect {
+		if !s.config.clearDeletionProtection {
+			return nil, &deletionProtectedError{volumeID: volumeID, instance: filer.Name}
+		}
+		klog.Infof("Clearing the deletion protection of instance %s of volume %s", filer.Name, volumeID)
+	}
+	if err := s.checkInstanceBackups(ctx, fileService, volumeID, filer); err != nil {
+		return nil, err
+	}
+
+	err = fileService.DeleteInstance(ctx, filer)
 	if err != nil {
 		klog.Errorf("Delete volume for volume Id %s failed: %v", volumeID, err.Error())
 		return nil, file.StatusError(err)
@@ -451,7 +851,7 @@ func (s *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *
 	}
 
 	filer.Project = s.config.cloud.Project
-	newFiler, err := s.config.fileService.GetInstance(ctx, filer)
+	newFiler, err := s.getVolumeInstance(ctx, s.config.fileService, filer)
 	if err != nil && !file.IsNotFoundErr(err) {
 		return nil, file.StatusError(err)
 	}
@@ -491,7 +891,8 @@ func getTierFromParams(params map[string]string) string {
 	return defaultTier
 }
 
-// validator function to check for invalid capacity size requests
+// validator function to check for invalid capacity size requests. The ranges which can't be
+// satisfied by the tier, e.g. with a limit below its minimum size, fail with OutOfRange.
 func invalidCapacityRange(capRange *csi.CapacityRange, tier string, validRange *capacityRangeForTier) error {
 
 	requiredCap := capRange.GetRequiredBytes()
@@ -500,12 +901,12 @@ func invalidCapacityRange(capRange *csi.CapacityRange, tier string, validRange *
 	limitSet := limitCap > 0
 
 	if limitSet && requireSet && limitCap < requiredCap {
-		return fmt.Errorf("limit bytes %vTiB is less than required bytes %vTiB", float64(limitCap)/util.Tb, float64(requiredCap)/util.Tb)
+		return status.Errorf(codes.InvalidArgument, "limit bytes %vTiB is less than required bytes %vTiB", float64(limitCap)/util.Tb, float64(requiredCap)/util.Tb)
 	}
 
 	if requireSet {
 		if requiredCap > validRange.max {
-			return fmt.Errorf("request bytes %vTiB is more than maximum instance size bytes %vTiB for tier %s", float64(requiredCap)/util.Tb, float64(validRange.max)/util.Tb, tier)
+			return status.Errorf(codes.OutOfRange, "request bytes %vTiB is more than maximum instance size bytes %vTiB for tier %s", float64(requiredCap)/util.Tb, float64(validRange.max)/util.Tb, tier)
 		}
 
 		if !limitSet && requiredCap < validRange.min {
@@ -515,8 +916,7 @@ func invalidCapacityRange(capRange *csi.CapacityRange, tier string, validRange *
 	}
 	if limitSet {
 		if limitCap < validRange.min {
-			return fmt.Errorf("limit bytes %vTiB is less than minimum instance size bytes %vTiB for tier %s", float64(limitCap)/util.Tb, float64(validRange.min)/util.Tb, tier)
-
+			return status.Errorf(codes.OutOfRange, "limit bytes %vTiB is less than minimum instance size bytes %vTiB for tier %s", float64(limitCap)/util.Tb, float64(validRange.min)/util.Tb, tier)
 		}
 		if !requireSet && limitCap > validRange.max {
 			// Avoid surprising users by provisioning less than Requested
@@ -551,7 +951,8 @@ func provisionableCapacityForTier(tier string) *capacityRangeForTier {
 	return &validRange
 }
 
-// getRequestCapacity returns the volume size that should be provisioned
+// getRequestCapacity returns the volume size that should be provisioned. The instances are
+// provisioned in GiB, so the size rounded up to the GiB must not exceed the limit of the range.
 func getRequestCapacity(capRange *csi.CapacityRange, tier string) (int64, error) {
 	validRange := provisionableCapacityForTier(tier)
 
@@ -569,9 +970,18 @@ func getRequestCapacity(capRange *csi.CapacityRange, tier string) (int64, error)
 	limitSet := maxRequired > 0
 
 	if requireSet {
-		return util.Max(requiredCap, validRange.min), nil
+		capBytes := util.Max(requiredCap, validRange.min)
+		if limitSet && util.GbToBytes(util.RoundBytesToGb(capBytes)) > maxRequired {
+			return 0, status.Errorf(codes.OutOfRange, "request bytes %v rounded up to the GiB is more than limit bytes %v", capBytes, maxRequired)
+		}
+		return capBytes, nil
 	} else if limitSet {
-		return util.Min(maxRequired, validRange.max), nil
+		capBytes := util.Min(maxRequired, validRange.max)
+		if util.GbToBytes(util.RoundBytesToGb(capBytes)) > maxRequired {
+			// The limit is at least the minimum size of the tier, a multiple of the GiB.
+			capBytes = util.GbToBytes(util.BytesToGb(capBytes))
+		}
+		return capBytes, nil
 	} else {
 		return validRange.min, nil
 	}
@@ -591,6 +1001,7 @@ func (s *controllerServer) generateNewFileInstance(name string, capBytes int64,
 	network := defaultNetwork
 	connectMode := directPeering
 	kmsKeyName := ""
+	flavor := ""
 
 	// Validate parameters (case-insensitive).
 	for k, v := range params {
@@ -622,6 +1033,10 @@ func (s *controllerServer) generateNewFileInstance(name string, capBytes int64,
 			}
 		case ParamInstanceEncryptionKmsKey:
 			kmsKeyName = v
+		case paramAuthFlavor:
+			if flavor, err = authFlavor(v); err != nil {
+				return nil, err
+			}
 		// Ignore the cidr flag as it is not passed to the cloud provider
 		// It will be used to get unreserved IP in the reserveIPV4Range function
 		// ignore IPRange flag as it will be handled at the same place as cidr
@@ -629,13 +1044,43 @@ func (s *controllerServer) generateNewFileInstance(name string, capBytes int64,
 			continue
 		case cloud.ParameterKeyResourceTags:
 			continue
+		case paramDeletionProtection:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid value %q for parameter %q: %w", v, k, err)
+			}
+		case paramBackupBeforeExpand:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid value %q for parameter %q: %w", v, k, err)
+			}
+		case paramBackupsDeletionPolicy:
+			if _, err := parseBackupsDeletionPolicy(v); err != nil {
+				return nil, err
+			}
+		case paramInstanceCreateTimeout:
+			if _, err := parseOpTimeoutParam(k, v); err != nil {
+				return nil, err
+			}
+		// Validated by mountPolicyVolumeContext and hostnameTemplate.
+		case paramMountPolicy, paramSoftMountTimeo, paramHostnameTemplate:
+			continue
+		// Validated by instanceZones.
+		case paramAllowedZones:
+			continue
 		case ParameterKeyLabels, ParameterKeyPVCName, ParameterKeyPVCNamespace, ParameterKeyPVName:
 		case "csiprovisionersecretname", "csiprovisionersecretnamespace":
 		default:
 			return nil, fmt.Errorf("invalid parameter %q", k)
 		}
 	}
-	return &file.ServiceInstance{
+	if backup, _ := strconv.ParseBool(params[paramBackupBeforeExpand]); backup && strings.ToLower(tier) != enterpriseTier {
+		return nil, fmt.Errorf("parameter %q is only supported for the %s tier", paramBackupBeforeExpand, enterpriseTier)
+	}
+	if kmsKeyName != "" {
+		if kmsKeyName, err = normalizeKmsKeyName(kmsKeyName, location); err != nil {
+			return nil, err
+		}
+	}
+	instance := &file.ServiceInstance{
 		Project:  s.config.cloud.Project,
 		Name:     name,
 		Location: location,
@@ -650,7 +1095,11 @@ func (s *controllerServer) generateNewFileInstance(name string, capBytes int64,
 		},
 		KmsKeyName:       kmsKeyName,
 		NfsExportOptions: nfsExportOptions,
-	}, nil
+	}
+	if err := applyAuthFlavor(instance, flavor); err != nil {
+		return nil, err
+	}
+	return instance, nil
 }
 
 // fileInstanceToCSIVolume generates a CSI volume spec from the cloud Instance
@@ -659,8 +1108,9 @@ func (s *controllerServer) fileInstanceToCSIVolume(instance *file.ServiceInstanc
 		VolumeId:      getVolumeIDFromFileInstance(instance, mode),
 		CapacityBytes: instance.Volume.SizeBytes,
 		VolumeContext: map[string]string{
-			attrIP:     instance.Network.Ip,
-			attrVolume: instance.Volume.Name,
+			attrIP:             instance.Network.Ip,
+			attrVolume:         instance.Volume.Name,
+			attrContextVersion: strconv.Itoa(volumeContextVersion),
 		},
 	}
 	if instance.BackupSource != "" {
@@ -676,16 +1126,37 @@ func (s *controllerServer) fileInstanceToCSIVolume(instance *file.ServiceInstanc
 	if s.config.features.FeatureLockRelease.Enabled && strings.ToLower(instance.Tier) == enterpriseTier {
 		resp.VolumeContext[attrSupportLockRelease] = "true"
 	}
+	if s.config.features.FeatureTierRecommendations != nil && s.config.features.FeatureTierRecommendations.Enabled {
+		resp.VolumeContext[attrTier] = strings.ToLower(instance.Tier)
+	}
 	return resp
 }
 
 // ControllerExpandVolume expands a GCFS instance share.
 func (s *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	klog.V(4).Infof("ControllerExpandVolume called with request %+v", req)
+	resp, err := s.joinInFlightRequest(ctx, methodExpandVolume, req.GetVolumeId(), req, func() (interface{}, error) {
+		return s.controllerExpandVolume(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	response := resp.(*csi.ControllerExpandVolumeResponse)
+	if s.config.verifyNodeExpansion {
+		response.NodeExpansionRequired = true
+	}
+	return response, nil
+}
+
+func (s *controllerServer) controllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	klog.V(4).Infof("ControllerExpandVolume called with request %+v", pbSanitizer.StripSecrets(req))
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "ControllerExpandVolume volume ID must be provided")
 	}
+	volumeID, err := s.translateLegacyVolumeID(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
 
 	if isMultishareVolId(volumeID) {
 		if s.config.multiShareController == nil {
@@ -702,15 +1173,18 @@ func (s *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 		duration := time.Since(start)
 		s.config.metricsManager.RecordOperationMetrics(err, methodExpandVolume, modeMultishare, duration)
 		if err != nil {
-			klog.Errorf("ControllerExpandVolume returned error %v, for request: %+v", err, req)
+			klog.Errorf("ControllerExpandVolume returned error %v, for request: %+v", err, pbSanitizer.StripSecrets(req))
 			return nil, err
 		}
-		klog.Infof("ControllerExpandVolume response %+v, for request: %+v", response, req)
+		klog.Infof("ControllerExpandVolume response %+v, for request: %+v", response, pbSanitizer.StripSecrets(req))
 		return response, nil
 	}
+	if isInstanceShareVolID(volumeID) {
+		return s.expandInstanceShare(ctx, req)
+	}
 
 	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+		return nil, volumeOperationInProgressError(s.config.metricsManager, methodExpandVolume, volumeID)
 	}
 	defer s.config.volumeLocks.Release(volumeID)
 
@@ -721,14 +1195,27 @@ func (s *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 
 	reqBytes, err := getRequestCapacity(req.GetCapacityRange(), filer.Tier)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
 
-	filer.Project = s.config.cloud.Project
-	filer, err = s.config.fileService.GetInstance(ctx, filer)
+	fileService, project, err := s.cloudForSecrets(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	filer.Project = project
+	instanceURI := intentInstanceURI(filer.Project, filer.Location, filer.Name)
+	filer, err = s.getVolumeInstance(ctx, fileService, filer)
 	if err != nil {
 		return nil, file.StatusError(err)
 	}
+	// The expansion is serialized with the backups of the instance. Its intent is kept while
+	// its operation runs after the request.
+	release, err := s.config.instanceIntents.acquire(ctx, instanceURI, intentExpand, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	pending := false
+	defer func() { release(pending) }()
 	if filer.State != "READY" {
 		return nil, fmt.Errorf("lolume %q is not yet ready, current state %q", volumeID, filer.State)
 	}
@@ -741,20 +1228,39 @@ func (s *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 		}, nil
 	}
 
-	hasPendingOps, err := s.config.fileService.HasOperations(ctx, filer, "update", false /* done */)
+	hasPendingOps, err := fileService.HasOperations(ctx, filer, "update", false /* done */)
 	if err != nil {
 		return nil, file.StatusError(err)
 	}
 
 	if hasPendingOps {
+		pending = true
 		return nil, status.Errorf(codes.DeadlineExceeded, "Update operation ongoing for volume %v", volumeID)
 	}
 
+	if backup, _ := strconv.ParseBool(filer.Labels[TagKeyBackupBeforeExpand]); backup {
+		labels, err := extractLabels(nil, s.config.extraVolumeLabels, s.config.driver.config.Name)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		backupInfo, err := expansionBackupInfo(volumeID, filer.Project, filer.Location, filer.Name, filer.Volume.Name, reqBytes, labels)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		backupInfo.Tier = filer.Tier
+		if err := ensureExpansionBackup(ctx, fileService, backupInfo, s.config.backupBeforeExpandTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	fromBytes := filer.Volume.SizeBytes
 	filer.Volume.SizeBytes = reqBytes
-	newfiler, err := s.config.fileService.ResizeInstance(ctx, filer)
+	newfiler, err := fileService.ResizeInstance(withOpTimeout(ctx, s.config.opTimeouts, util.InstanceUpdate), filer)
 	if err != nil {
+		pending = file.IsOpPendingErr(err)
 		return nil, file.StatusError(err)
 	}
+	s.config.costEstimator.instanceExpanded(&file.MultishareInstance{Project: filer.Project, Location: filer.Location, Name: filer.Name, Tier: filer.Tier, CapacityBytes: newfiler.Volume.SizeBytes}, fromBytes)
 
 	klog.Infof("Controller expand volume succeeded for volume %v, new size(bytes): %v", volumeID, newfiler.Volume.SizeBytes)
 	return &csi.ControllerExpandVolumeResponse{
@@ -890,7 +1396,17 @@ func mergeLabels(scLabels, metadataLabels, cliLabels map[string]string) (map[str
 }
 
 func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	klog.V(4).Infof("CreateSnapshot called with request %+v", req)
+	resp, err := s.joinInFlightRequest(ctx, methodCreateSnapshot, req.GetName(), req, func() (interface{}, error) {
+		return s.createSnapshot(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.CreateSnapshotResponse), nil
+}
+
+func (s *controllerServer) createSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	klog.V(4).Infof("CreateSnapshot called with request %+v", pbSanitizer.StripSecrets(req))
 	if len(req.Name) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot name must be provided")
 	}
@@ -907,15 +1423,18 @@ func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 		duration := time.Since(start)
 		s.config.metricsManager.RecordOperationMetrics(err, methodCreateSnapshot, modeMultishare, duration)
 		if err != nil {
-			klog.Errorf("CreateSnapshot returned error %v, for request %+v", err, req)
+			klog.Errorf("CreateSnapshot returned error %v, for request %+v", err, pbSanitizer.StripSecrets(req))
 			return nil, err
 		}
-		klog.Infof("CreateSnapshot response %+v, for request %+v", response, req)
+		klog.Infof("CreateSnapshot response %+v, for request %+v", response, pbSanitizer.StripSecrets(req))
 		return response, nil
 	}
+	if isInstanceShareVolID(volumeID) {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshots of the file shares of a %s are not supported", paramParentInstance)
+	}
 
 	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+		return nil, volumeOperationInProgressError(s.config.metricsManager, methodCreateSnapshot, volumeID)
 	}
 	defer s.config.volumeLocks.Release(volumeID)
 
@@ -946,9 +1465,19 @@ func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 		return nil, file.StatusError(err)
 	}
 
+	// The backup is serialized with the expansions of the instance. Its intent is kept while its
+	// operation runs after the request.
+	release, err := s.config.instanceIntents.acquire(ctx, intentInstanceURI(backupInfo.Project, backupInfo.SourceVolumeLocation(), backupInfo.SourceInstanceName), intentBackup, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	pending := false
+	defer func() { release(pending) }()
+
 	var snapshotResponse *csi.CreateSnapshotResponse
 	if backupExists {
 		// process existing backup
+		pending = isBackupInProgress(existingBackup)
 		snapshot, err := file.ProcessExistingBackup(ctx, existingBackup, volumeID, modeInstance)
 		if err != nil {
 			return nil, err
@@ -967,6 +1496,7 @@ func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 
 		backupObj, err := s.config.fileService.CreateBackup(ctx, backupInfo)
 		if err != nil {
+			pending = file.IsOpPendingErr(err)
 			klog.Errorf("Create snapshot for volume Id %s failed: %v", volumeID, err.Error())
 			return nil, file.StatusError(err)
 		}
@@ -999,10 +1529,25 @@ func extractBackupLabels(parameters, cliLabels map[string]string, driverName str
 		return nil, err
 	}
 	labels[tagKeySnapshotName] = snapshotName
+	// The PVC namespace label of the backups restricts their in-place restores to the PVCs of
+	// the namespace, see volumeRestorer.
+	if ns := parameters[ParameterKeyVolumeSnapshotNamespace]; ns != "" && labels[tagKeyCreatedForClaimNamespace] == "" {
+		labels[tagKeyCreatedForClaimNamespace] = ns
+	}
 	return labels, nil
 }
 
 func (s *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	resp, err := s.joinInFlightRequest(ctx, methodDeleteSnapshot, req.GetSnapshotId(), req, func() (interface{}, error) {
+		return s.deleteSnapshot(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.DeleteSnapshotResponse), nil
+}
+
+func (s *controllerServer) deleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
 	id := req.GetSnapshotId()
 	if len(id) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot snapshot Id must be provided")
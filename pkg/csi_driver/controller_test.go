@@ -18,16 +18,22 @@ package driver
 
 import (
 	"fmt"
+	"net/http"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/compute"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
 )
@@ -144,8 +150,9 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 					CapacityBytes: defaultTierMinSize,
 					VolumeId:      testVolumeID,
 					VolumeContext: map[string]string{
-						attrIP:     testIP,
-						attrVolume: newInstanceVolume,
+						attrContextVersion: "1",
+						attrIP:             testIP,
+						attrVolume:         newInstanceVolume,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -191,8 +198,9 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 					CapacityBytes: premiumTierMinSize,
 					VolumeId:      testVolumeID,
 					VolumeContext: map[string]string{
-						attrIP:     testIP,
-						attrVolume: newInstanceVolume,
+						attrContextVersion: "1",
+						attrIP:             testIP,
+						attrVolume:         newInstanceVolume,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -238,8 +246,9 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 					CapacityBytes: testBytes,
 					VolumeId:      testVolumeID,
 					VolumeContext: map[string]string{
-						attrIP:     testIP,
-						attrVolume: newInstanceVolume,
+						attrContextVersion: "1",
+						attrIP:             testIP,
+						attrVolume:         newInstanceVolume,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -306,8 +315,9 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 					CapacityBytes: testBytes,
 					VolumeId:      testVolumeID,
 					VolumeContext: map[string]string{
-						attrIP:     testIP,
-						attrVolume: newInstanceVolume,
+						attrContextVersion: "1",
+						attrIP:             testIP,
+						attrVolume:         newInstanceVolume,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -348,6 +358,54 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 				SourceVolumeId: modeInstance + "/" + testRegion + "/" + instanceName + "/" + shareName,
 			},
 		},
+		{
+			name: "from snapshot in another project",
+			req: &csi.CreateVolumeRequest{
+				Name: testCSIVolume,
+				VolumeContentSource: &csi.VolumeContentSource{
+					Type: &csi.VolumeContentSource_Snapshot{
+						Snapshot: &csi.VolumeContentSource_SnapshotSource{
+							SnapshotId: "projects/backup-project/locations/us-central1/backups/mybackup",
+						},
+					},
+				},
+				Parameters:         map[string]string{"tier": defaultTier},
+				VolumeCapabilities: volumeCapabilities,
+			},
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: defaultTierMinSize,
+					VolumeId:      testVolumeID,
+					VolumeContext: map[string]string{
+						attrContextVersion: "1",
+						attrIP:             testIP,
+						attrVolume:         newInstanceVolume,
+					},
+					ContentSource: &csi.VolumeContentSource{
+						Type: &csi.VolumeContentSource_Snapshot{
+							Snapshot: &csi.VolumeContentSource_SnapshotSource{
+								SnapshotId: "projects/backup-project/locations/us-central1/backups/mybackup",
+							},
+						},
+					},
+				},
+			},
+			initialBackup: &BackupInfo{
+				s: &file.ServiceInstance{
+					Project:  "backup-project",
+					Location: testZone,
+					Name:     instanceName,
+					Tier:     defaultTier,
+					Volume: file.Volume{
+						Name:      shareName,
+						SizeBytes: defaultTierMinSize,
+					},
+				},
+				backupName:     backupName,
+				backupLocation: testRegion,
+				SourceVolumeId: modeInstance + "/" + testZone + "/" + instanceName + "/" + shareName,
+			},
+		},
 		{
 			name: "Parameters contain misconfigured labels(invalid KV separator(:) used)",
 			req: &csi.CreateVolumeRequest{
@@ -465,8 +523,9 @@ func TestCreateVolume(t *testing.T) {
 					CapacityBytes: 1 * util.Tb,
 					VolumeId:      testVolumeID,
 					VolumeContext: map[string]string{
-						attrIP:     testIP,
-						attrVolume: newInstanceVolume,
+						attrContextVersion: "1",
+						attrIP:             testIP,
+						attrVolume:         newInstanceVolume,
 					},
 				},
 			},
@@ -655,8 +714,9 @@ func TestCreateVolume(t *testing.T) {
 					CapacityBytes: 1 * util.Tb,
 					VolumeId:      testVolumeID,
 					VolumeContext: map[string]string{
-						attrIP:     testIP,
-						attrVolume: newInstanceVolume,
+						attrContextVersion: "1",
+						attrIP:             testIP,
+						attrVolume:         newInstanceVolume,
 					},
 				},
 			},
@@ -738,12 +798,307 @@ func TestDeleteVolume(t *testing.T) {
 	}
 }
 
+func TestDeleteVolumeDeletionProtection(t *testing.T) {
+	for _, clear := range []bool{false, true} {
+		cs := initTestController(t).(*controllerServer)
+		cs.config.clearDeletionProtection = clear
+		params := map[string]string{paramDeletionProtection: "true"}
+		cs.config.tagManager.(*cloud.FakeTagServiceManager).
+			On("AttachResourceTags", context.TODO(), cloud.FilestoreInstance, testCSIVolume, testLocation, testCSIVolume, params).
+			Return(nil)
+		_, err := cs.CreateVolume(context.TODO(), &csi.CreateVolumeRequest{
+			Name: testCSIVolume,
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			}},
+			Parameters: params,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		instance, err := cs.config.fileService.GetInstance(context.TODO(), &file.ServiceInstance{Name: testCSIVolume})
+		if err != nil {
+			t.Fatalf("failed to get instance: %v", err)
+		}
+		if instance.Labels[TagKeyDeletionProtection] != "true" {
+			t.Errorf("got instance labels %v, expected label %s", instance.Labels, TagKeyDeletionProtection)
+		}
+
+		_, err = cs.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: testVolumeID})
+		if clear && err != nil {
+			t.Errorf("clear %t: unexpected error: %v", clear, err)
+		}
+		if !clear && status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("clear %t: got error %v, expected code %v", clear, err, codes.FailedPrecondition)
+		}
+	}
+}
+
+// locatedService serves its instances at a single location only.
+type locatedService struct {
+	file.Service
+	location  string
+	lookups   []string
+	deletions []string
+}
+
+func (s *locatedService) GetInstance(ctx context.Context, obj *file.ServiceInstance) (*file.ServiceInstance, error) {
+	s.lookups = append(s.lookups, obj.Location)
+	if obj.Location != s.location {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Errors: []googleapi.ErrorItem{{Reason: "notFound"}}}
+	}
+	instance, err := s.Service.GetInstance(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	located := *instance
+	located.Location = s.location
+	return &located, nil
+}
+
+func (s *locatedService) DeleteInstance(ctx context.Context, obj *file.ServiceInstance) error {
+	s.deletions = append(s.deletions, obj.Location)
+	return s.Service.DeleteInstance(ctx, obj)
+}
+
+func TestVolumeLocationAliases(t *testing.T) {
+	cases := []struct {
+		name            string
+		aliases         map[string]string
+		location        string
+		expectFound     bool
+		expectedLookups []string
+	}{
+		{
+			name:            "instance at the volume ID location",
+			aliases:         map[string]string{testZone: testRegion},
+			location:        testZone,
+			expectFound:     true,
+			expectedLookups: []string{testZone},
+		},
+		{
+			name:            "instance at the alias location",
+			aliases:         map[string]string{testZone: testRegion},
+			location:        testRegion,
+			expectFound:     true,
+			expectedLookups: []string{testZone, testRegion},
+		},
+		{
+			name:            "no alias",
+			location:        testRegion,
+			expectedLookups: []string{testZone},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := initTestController(t).(*controllerServer)
+			cs.config.volumeLocationAliases = tc.aliases
+			ctx := context.TODO()
+			if _, err := cs.config.fileService.CreateInstance(ctx, &file.ServiceInstance{
+				Name:   testCSIVolume,
+				Volume: file.Volume{Name: newInstanceVolume, SizeBytes: util.Tb},
+			}); err != nil {
+				t.Fatalf("failed to create instance: %v", err)
+			}
+			service := &locatedService{Service: cs.config.fileService, location: tc.location}
+			cs.config.fileService = service
+
+			_, err := cs.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: testVolumeID,
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+				}},
+			})
+			if found := status.Code(err) != codes.NotFound; found != tc.expectFound {
+				t.Fatalf("got error %v, expected volume found %t", err, tc.expectFound)
+			}
+			if !reflect.DeepEqual(service.lookups, tc.expectedLookups) {
+				t.Errorf("got instance lookups at %v, expected %v", service.lookups, tc.expectedLookups)
+			}
+			if !tc.expectFound {
+				return
+			}
+
+			resp, err := cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+				VolumeId:      testVolumeID,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * util.Tb},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.CapacityBytes != 2*util.Tb {
+				t.Errorf("got capacity %d, expected %d", resp.CapacityBytes, 2*util.Tb)
+			}
+			if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: testVolumeID}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(service.deletions, []string{tc.location}) {
+				t.Errorf("got instance deletions at %v, expected %v", service.deletions, []string{tc.location})
+			}
+		})
+	}
+}
+
+func TestParseVolumeLocationAliases(t *testing.T) {
+	cases := []struct {
+		aliases   string
+		expected  map[string]string
+		expectErr bool
+	}{
+		{aliases: "", expected: map[string]string{}},
+		{aliases: "us-central1-c=us-central1, us-east1-b = us-east1", expected: map[string]string{"us-central1-c": "us-central1", "us-east1-b": "us-east1"}},
+		{aliases: "us-central1-c", expectErr: true},
+		{aliases: "us-central1-c=", expectErr: true},
+		{aliases: "us-central1=us-central1", expectErr: true},
+		{aliases: "us-central1-c=us-central1,us-central1-c=us-east1", expectErr: true},
+	}
+	for _, tc := range cases {
+		got, err := ParseVolumeLocationAliases(tc.aliases)
+		if tc.expectErr != (err != nil) {
+			t.Errorf("aliases %q: got error %v, expected error %t", tc.aliases, err, tc.expectErr)
+		}
+		if !tc.expectErr && !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("aliases %q: got %v, expected %v", tc.aliases, got, tc.expected)
+		}
+	}
+}
+
+func TestProvisionerSecretCredentials(t *testing.T) {
+	tenantFileService, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	cs := initTestController(t).(*controllerServer)
+	cs.config.cloud, _ = cloud.NewFakeCloudWithFiler(tenantFileService, testProject, testZone)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", context.TODO(), cloud.FilestoreInstance, testCSIVolume, testLocation, testCSIVolume, map[string]string(nil)).
+		Return(nil)
+	secrets := map[string]string{
+		secretKeyServiceAccountKey: `{"type": "service_account", "project_id": "tenant-project", "client_email": "sa@tenant-project.iam.gserviceaccount.com", "private_key": "key"}`,
+	}
+	ctx := context.TODO()
+
+	// The instance is created with the credentials of the secret.
+	req := &csi.CreateVolumeRequest{
+		Name: testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}},
+		Secrets: secrets,
+	}
+	if _, err := cs.CreateVolume(ctx, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := tenantFileService.GetInstance(ctx, &file.ServiceInstance{Name: testCSIVolume}); err != nil {
+		t.Errorf("expected the instance to be created with the secret credentials, got error %v", err)
+	}
+	if _, err := cs.config.fileService.GetInstance(ctx, &file.ServiceInstance{Name: testCSIVolume}); !file.IsNotFoundErr(err) {
+		t.Errorf("expected the instance not to be created with the driver credentials, got error %v", err)
+	}
+
+	resp, err := cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      testVolumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * util.Tb},
+		Secrets:       secrets,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.CapacityBytes != 2*util.Tb {
+		t.Errorf("got capacity %d, expected %d", resp.CapacityBytes, 2*util.Tb)
+	}
+	if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: testVolumeID, Secrets: secrets}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// An invalid secret is not retried with the driver credentials.
+	for _, key := range []string{
+		"not json",
+		`{"type": "external_account", "audience": "aud", "subject_token_type": "urn:ietf:params:oauth:token-type:jwt", "token_url": "https://sts.googleapis.com/v1/token", "credential_source": {"url": "http://169.254.169.254/token"}}`,
+	} {
+		req.Secrets = map[string]string{secretKeyServiceAccountKey: key, secretKeyProjectID: "tenant-project"}
+		_, err = cs.CreateVolume(ctx, req)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("got error %v, expected code %v for key %s", err, codes.InvalidArgument, key)
+		}
+	}
+}
+
 // TODO:
 func TestValidateVolumeCapabilities(t *testing.T) {
 }
 
-// TODO:
 func TestControllerGetCapabilities(t *testing.T) {
+	c, err := cloud.NewFakeCloud()
+	if err != nil {
+		t.Fatalf("failed to init cloud: %v", err)
+	}
+	driver, err := NewGCFSDriver(&GCFSDriverConfig{
+		Name:           "test-driver",
+		Version:        "test-version",
+		RunController:  true,
+		Cloud:          c,
+		FeatureOptions: &GCFSDriverFeatureOptions{},
+	})
+	if err != nil {
+		t.Fatalf("failed to init driver: %v", err)
+	}
+	resp, err := driver.cs.ControllerGetCapabilities(context.TODO(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []csi.ControllerServiceCapability_RPC_Type
+	for _, c := range resp.GetCapabilities() {
+		got = append(got, c.GetRpc().GetType())
+	}
+	expected := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got capabilities %v, expected %v", got, expected)
+	}
+	for _, rpc := range expected {
+		if err := driver.ValidateControllerServiceRequest(rpc); err != nil {
+			t.Errorf("unexpected error validating %v: %v", rpc, err)
+		}
+	}
+	if err := driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_GET_CAPACITY); err == nil {
+		t.Errorf("expected error validating %v, got none", csi.ControllerServiceCapability_RPC_GET_CAPACITY)
+	}
+}
+
+func TestControllerServiceCapabilitiesTierPolicy(t *testing.T) {
+	cases := []struct {
+		name      string
+		allowed   string
+		denied    string
+		snapshots bool
+	}{
+		{name: "no tier policy", snapshots: true},
+		{name: "enterprise only", allowed: enterpriseTier, snapshots: true},
+		{name: "high scale only", allowed: highScaleTier},
+		{name: "backup tiers denied", denied: strings.Join(backupTiers, ",")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := NewTierPolicy(tc.allowed, tc.denied)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			snapshots := false
+			for _, c := range controllerServiceCapabilities(&GCFSDriverConfig{TierPolicy: policy}) {
+				snapshots = snapshots || c == csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT
+			}
+			if snapshots != tc.snapshots {
+				t.Errorf("got snapshot capability %t, expected %t", snapshots, tc.snapshots)
+			}
+		})
+	}
 }
 
 // TODO:
@@ -1010,6 +1365,74 @@ func TestGetRequestCapacity(t *testing.T) {
 	}
 }
 
+func TestGetRequestCapacityLimit(t *testing.T) {
+	cases := []struct {
+		name         string
+		capRange     *csi.CapacityRange
+		tier         string
+		bytes        int64
+		expectedCode codes.Code
+	}{
+		{
+			name:         "limit below min default",
+			capRange:     &csi.CapacityRange{LimitBytes: 1*util.Tb - 1},
+			tier:         defaultTier,
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			name:         "required below min, limit below min enterprise",
+			capRange:     &csi.CapacityRange{RequiredBytes: 100 * util.Gb, LimitBytes: 512 * util.Gb},
+			tier:         enterpriseTier,
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			name:         "required rounded up above limit premium",
+			capRange:     &csi.CapacityRange{RequiredBytes: 3*util.Tb + 1, LimitBytes: 3*util.Tb + util.Gb/2},
+			tier:         premiumTier,
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			name:     "required rounded up within limit premium",
+			capRange: &csi.CapacityRange{RequiredBytes: 3*util.Tb + 1, LimitBytes: 3*util.Tb + util.Gb},
+			tier:     premiumTier,
+			bytes:    3*util.Tb + 1,
+		},
+		{
+			name:     "limit rounded down highScale",
+			capRange: &csi.CapacityRange{LimitBytes: 20*util.Tb + util.Gb/2},
+			tier:     highScaleTier,
+			bytes:    20 * util.Tb,
+		},
+		{
+			name:         "required above max zonal",
+			capRange:     &csi.CapacityRange{RequiredBytes: 200 * util.Tb, LimitBytes: 300 * util.Tb},
+			tier:         zonalTier,
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			name:         "limit below required basicHDD",
+			capRange:     &csi.CapacityRange{RequiredBytes: 2 * util.Tb, LimitBytes: 1 * util.Tb},
+			tier:         basicHDDTier,
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bytes, err := getRequestCapacity(tc.capRange, tc.tier)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("got code %v, expected %v: %v", code, tc.expectedCode, err)
+			}
+			if bytes != tc.bytes {
+				t.Errorf("got %v bytes, expected %v", bytes, tc.bytes)
+			}
+			if tc.bytes > 0 && util.GbToBytes(util.RoundBytesToGb(bytes)) > tc.capRange.GetLimitBytes() {
+				t.Errorf("got %v bytes rounded up above limit %v", bytes, tc.capRange.GetLimitBytes())
+			}
+		})
+	}
+}
+
 func TestGenerateNewFileInstance(t *testing.T) {
 	cases := []struct {
 		name      string
@@ -1194,7 +1617,7 @@ func TestGenerateNewFileInstance(t *testing.T) {
 			name: "custom params, customer kms key",
 			params: map[string]string{
 				paramTier:                       enterpriseTier,
-				ParamInstanceEncryptionKmsKey:   "foo-key",
+				ParamInstanceEncryptionKmsKey:   testKmsKey,
 				"csiProvisionerSecretName":      "foo-secret",
 				"csiProvisionerSecretNamespace": "foo-namespace",
 			},
@@ -1211,7 +1634,7 @@ func TestGenerateNewFileInstance(t *testing.T) {
 					Name:      newInstanceVolume,
 					SizeBytes: testBytes,
 				},
-				KmsKeyName: "foo-key",
+				KmsKeyName: testKmsKey,
 			},
 		},
 		{
@@ -1219,7 +1642,7 @@ func TestGenerateNewFileInstance(t *testing.T) {
 			name: "non-enterprise tier, customer kms key",
 			params: map[string]string{
 				paramTier:                       basicHDDTier,
-				ParamInstanceEncryptionKmsKey:   "foo-key",
+				ParamInstanceEncryptionKmsKey:   testKmsKey,
 				"csiProvisionerSecretName":      "foo-secret",
 				"csiProvisionerSecretNamespace": "foo-namespace",
 			},
@@ -1236,7 +1659,7 @@ func TestGenerateNewFileInstance(t *testing.T) {
 					Name:      newInstanceVolume,
 					SizeBytes: testBytes,
 				},
-				KmsKeyName: "foo-key",
+				KmsKeyName: testKmsKey,
 			},
 		},
 		{
@@ -1253,6 +1676,13 @@ func TestGenerateNewFileInstance(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "invalid deletion protection",
+			params: map[string]string{
+				paramDeletionProtection: "yes",
+			},
+			expectErr: true,
+		},
 	}
 
 	for _, test := range cases {
@@ -1503,10 +1933,22 @@ func TestVolumeOperationLocks(t *testing.T) {
 	resp := runRequest(&RequestConfig{CreateVolReq: req})
 	createOpUnblocker := <-operationUnblocker
 
-	// Second CreateVolume request on the same volume should fail to acquire lock and return Aborted error.
-	createResp2 := runRequest(&RequestConfig{CreateVolReq: req})
+	// Second CreateVolume request on the same volume with different content should return Aborted error.
+	conflictingReq := proto.Clone(req).(*csi.CreateVolumeRequest)
+	conflictingReq.Parameters = map[string]string{paramTier: enterpriseTier}
+	createResp2 := runRequest(&RequestConfig{CreateVolReq: conflictingReq})
 	ValidateExpectedError(t, createResp2, operationUnblocker, codes.Aborted)
 
+	// An identical CreateVolume request joins the ongoing one and gets its result once it completes.
+	dupCreateResp := runRequest(&RequestConfig{CreateVolReq: req})
+	select {
+	case err := <-dupCreateResp:
+		t.Errorf("Duplicate CreateVolume should wait for the ongoing request, got err: %v", err)
+	case <-operationUnblocker:
+		t.Errorf("Duplicate CreateVolume should have joined the ongoing request, but was started")
+	case <-time.After(100 * time.Millisecond):
+	}
+
 	// Delete Volume request on the same volume should fail to acquire lock and return Aborted error.
 	delResp := runRequest(&RequestConfig{DeleteVolReq: &csi.DeleteVolumeRequest{
 		VolumeId: testVolumeID,
@@ -1555,6 +1997,9 @@ func TestVolumeOperationLocks(t *testing.T) {
 	if err := <-resp; err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
+	if err := <-dupCreateResp; err != nil {
+		t.Errorf("Unexpected error for duplicate CreateVolume: %v", err)
+	}
 	// Delete the first volume, no error expected.
 	delResp = runRequest(&RequestConfig{DeleteVolReq: &csi.DeleteVolumeRequest{
 		VolumeId: testVolumeID,
@@ -2064,6 +2509,8 @@ func TestGetCloudInstancesReservedIPRanges(t *testing.T) {
 	cases := []struct {
 		name                       string
 		initMultishareInstanceList []*file.MultishareInstance
+		allocatedRanges            []*compute.AddressRange
+		computeErr                 error
 		instance                   *file.ServiceInstance
 		expectIPRange              map[string]bool
 		expectErr                  bool
@@ -2114,13 +2561,52 @@ func TestGetCloudInstancesReservedIPRanges(t *testing.T) {
 			},
 			expectIPRange: map[string]bool{"192.168.92.32/29": true, "192.168.92.40/29": true, "10.1.1.0/24": true},
 		},
+		{
+			name: "allocated ranges in the vpc network",
+			allocatedRanges: []*compute.AddressRange{
+				{Name: "psa-range", Network: defaultNetwork, CIDR: "10.2.0.0/20"},
+				{Name: "other-network-range", Network: testVPCNetwork, CIDR: "10.3.0.0/20"},
+			},
+			instance: &file.ServiceInstance{
+				Project:  testProject,
+				Name:     testCSIVolume,
+				Location: testLocation,
+				Tier:     defaultTier,
+				Network: file.Network{
+					Name:        defaultNetwork,
+					ConnectMode: directPeering,
+				},
+			},
+			expectIPRange: map[string]bool{"192.168.92.32/29": true, "192.168.92.40/29": true, "10.2.0.0/20": true},
+		},
+		{
+			name: "allocated ranges cannot be listed",
+			allocatedRanges: []*compute.AddressRange{
+				{Name: "psa-range", Network: defaultNetwork, CIDR: "10.2.0.0/20"},
+			},
+			computeErr: fmt.Errorf("permission denied"),
+			instance: &file.ServiceInstance{
+				Project:  testProject,
+				Name:     testCSIVolume,
+				Location: testLocation,
+				Tier:     defaultTier,
+				Network: file.Network{
+					Name:        defaultNetwork,
+					ConnectMode: directPeering,
+				},
+			},
+			expectIPRange: map[string]bool{"192.168.92.32/29": true, "192.168.92.40/29": true},
+		},
 	}
 	for _, test := range cases {
 		cs := initTestController(t).(*controllerServer)
+		fakeCompute := compute.NewFakeService(test.allocatedRanges)
+		fakeCompute.Err = test.computeErr
+		cs.config.cloud.Compute = fakeCompute
 		for _, i := range test.initMultishareInstanceList {
 			cs.config.fileService.StartCreateMultishareInstanceOp(context.Background(), i)
 		}
-		ipRange, err := cs.getCloudInstancesReservedIPRanges(context.Background(), test.instance)
+		ipRange, err := cs.getCloudInstancesReservedIPRanges(context.Background(), cs.config.fileService, test.instance)
 		if !test.expectErr && err != nil {
 			t.Errorf("test %q failed: %v", test.name, err)
 		}
@@ -2130,6 +2616,68 @@ func TestGetCloudInstancesReservedIPRanges(t *testing.T) {
 	}
 }
 
+func TestReserveIPRangeWithAllocatedRanges(t *testing.T) {
+	cases := []struct {
+		name            string
+		cidr            string
+		allocatedRanges []*compute.AddressRange
+		expectIPRange   string
+		expectErr       bool
+	}{
+		{
+			name: "allocated range overlapping the start of the cidr",
+			cidr: "10.2.0.0/28",
+			allocatedRanges: []*compute.AddressRange{
+				{Name: "psa-range", Network: defaultNetwork, CIDR: "10.2.0.0/29"},
+			},
+			expectIPRange: "10.2.0.8/29",
+		},
+		{
+			name: "cidr exhausted by an allocated range",
+			cidr: "10.2.0.0/28",
+			allocatedRanges: []*compute.AddressRange{
+				{Name: "psa-range", Network: defaultNetwork, CIDR: "10.2.0.0/24"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "allocated range in another network",
+			cidr: "10.2.0.0/28",
+			allocatedRanges: []*compute.AddressRange{
+				{Name: "psa-range", Network: testVPCNetwork, CIDR: "10.2.0.0/24"},
+			},
+			expectIPRange: "10.2.0.0/29",
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			cs := initTestController(t).(*controllerServer)
+			cs.config.cloud.Compute = compute.NewFakeService(test.allocatedRanges)
+			filer := &file.ServiceInstance{
+				Project:  testProject,
+				Name:     testCSIVolume,
+				Location: testLocation,
+				Tier:     defaultTier,
+				Network:  file.Network{Name: defaultNetwork},
+			}
+			ipRange, err := cs.reserveIPRange(context.Background(), cs.config.fileService, filer, test.cidr)
+			defer cs.config.ipAllocator.ReleaseIPRange(ipRange)
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got IP range %q", ipRange)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ipRange != test.expectIPRange {
+				t.Errorf("got IP range %q, want %q", ipRange, test.expectIPRange)
+			}
+		})
+	}
+}
+
 func TestParsingNfsExportOptions(t *testing.T) {
 	cases := []struct {
 		name            string
@@ -2546,6 +3094,17 @@ func TestExtractBackupLabels(t *testing.T) {
 				tagKeySnapshotName:             snapshotName,
 			},
 		},
+		{
+			name: "VolumeSnapshot namespace",
+			parameters: map[string]string{
+				ParameterKeyVolumeSnapshotNamespace: pvcNamespace,
+			},
+			expectLabels: map[string]string{
+				tagKeyCreatedForClaimNamespace: pvcNamespace,
+				tagKeyCreatedBy:                driverName,
+				tagKeySnapshotName:             snapshotName,
+			},
+		},
 	}
 	for _, test := range cases {
 		labels, err := extractBackupLabels(test.parameters, test.cliLabels, driverName, snapshotName)
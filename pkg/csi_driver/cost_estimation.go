@@ -0,0 +1,158 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// Reason of the events published on the PVCs whose new instance is estimated to cost more
+	// than the alert threshold.
+	eventReasonInstanceCostAboveThreshold = "FilestoreInstanceCostAboveThreshold"
+
+	// Values of the instance operation label of the estimated spend metric.
+	instanceOperationCreate = "create"
+	instanceOperationExpand = "expand"
+)
+
+// DefaultTierPricing is the estimated monthly price in US dollars of a GiB of capacity of each
+// tier, approximating the list prices of the us-central1 region. The prices vary by region and
+// change over time, they only give an order of magnitude of the spend of the driver.
+var DefaultTierPricing = map[string]float64{
+	defaultTier:    0.20,
+	premiumTier:    0.30,
+	highScaleTier:  0.30,
+	zonalTier:      0.35,
+	enterpriseTier: 0.60,
+}
+
+// ParseTierPricing parses the comma separated tier=price pairs of the --cost-estimation-pricing
+// flag, overriding the prices of DefaultTierPricing.
+func ParseTierPricing(s string) (map[string]float64, error) {
+	pricing := make(map[string]float64, len(DefaultTierPricing))
+	for tier, price := range DefaultTierPricing {
+		pricing[tier] = price
+	}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tier, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tier price %q, expected <tier>=<price>", pair)
+		}
+		tier = strings.ToLower(strings.TrimSpace(tier))
+		known := false
+		for _, t := range knownTiers {
+			known = known || tier == t
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown tier %q, expected one of %s", tier, strings.Join(knownTiers, ", "))
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || price < 0 {
+			return nil, fmt.Errorf("invalid price %q of tier %s, expected a non-negative number of dollars per GiB per month", value, tier)
+		}
+		pricing[canonicalTier(tier)] = price
+	}
+	return pricing, nil
+}
+
+// costEstimator estimates the monthly cost of the instances created and expanded by the
+// driver from a static per-tier pricing, records the cumulative estimated spend, and warns on
+// the PVC of a volume whose new instance costs more than the alert threshold.
+type costEstimator struct {
+	// pricing is the monthly price of a GiB of capacity, by canonical tier.
+	pricing map[string]float64
+	// alertThreshold is the monthly cost in dollars of a new instance above which a warning
+	// event is published on its PVC. Zero disables the events.
+	alertThreshold float64
+	recorder       record.EventRecorder
+	metricsManager *metrics.MetricsManager
+}
+
+func newCostEstimator(pricing map[string]float64, alertThreshold float64, recorder record.EventRecorder, mm *metrics.MetricsManager) *costEstimator {
+	if mm != nil {
+		mm.RegisterCostEstimationMetrics()
+	}
+	return &costEstimator{
+		pricing:        pricing,
+		alertThreshold: alertThreshold,
+		recorder:       recorder,
+		metricsManager: mm,
+	}
+}
+
+// monthlyCost returns the estimated monthly cost in dollars of capacityBytes of the tier, zero
+// if the tier has no price.
+func (e *costEstimator) monthlyCost(tier string, capacityBytes int64) float64 {
+	price, ok := e.pricing[canonicalTier(tier)]
+	if !ok {
+		klog.V(4).Infof("No price of tier %q, its instances are not counted in the estimated spend", tier)
+		return 0
+	}
+	return float64(capacityBytes) / float64(util.Gb) * price
+}
+
+// instanceCreated records the estimated monthly cost of an instance created for the volume of
+// the given CreateVolume parameters, and warns on its PVC if above the alert threshold.
+func (e *costEstimator) instanceCreated(instance *file.MultishareInstance, params map[string]string) {
+	if e == nil {
+		return
+	}
+	cost := e.monthlyCost(instance.Tier, instance.CapacityBytes)
+	uri, _ := file.GenerateMultishareInstanceURI(instance)
+	klog.Infof("Estimated monthly cost of new instance %s of tier %s and %d GiB: $%.2f", uri, instance.Tier, instance.CapacityBytes/util.Gb, cost)
+	if e.metricsManager != nil {
+		e.metricsManager.RecordEstimatedSpend(canonicalTier(instance.Tier), instanceOperationCreate, cost)
+	}
+	if e.alertThreshold <= 0 || cost <= e.alertThreshold {
+		return
+	}
+	message := fmt.Sprintf("Filestore instance %s of tier %s and %d GiB created for this PVC is estimated to cost $%.2f per month, above the alert threshold of $%.2f", uri, instance.Tier, instance.CapacityBytes/util.Gb, cost, e.alertThreshold)
+	klog.Warningf("%s: %s", eventReasonInstanceCostAboveThreshold, message)
+	pvcName, pvcNamespace := params[ParameterKeyPVCName], params[ParameterKeyPVCNamespace]
+	if e.recorder == nil || pvcName == "" || pvcNamespace == "" {
+		return
+	}
+	e.recorder.Event(&v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: pvcNamespace, Name: pvcName}, v1.EventTypeWarning, eventReasonInstanceCostAboveThreshold, message)
+}
+
+// instanceExpanded records the estimated monthly cost of the capacity added to an instance
+// expanded from fromBytes to its capacity. The shrinks are not deducted from the spend.
+func (e *costEstimator) instanceExpanded(instance *file.MultishareInstance, fromBytes int64) {
+	if e == nil || instance.CapacityBytes <= fromBytes {
+		return
+	}
+	cost := e.monthlyCost(instance.Tier, instance.CapacityBytes-fromBytes)
+	uri, _ := file.GenerateMultishareInstanceURI(instance)
+	klog.Infof("Estimated monthly cost of the expansion of instance %s of tier %s from %d GiB to %d GiB: $%.2f", uri, instance.Tier, fromBytes/util.Gb, instance.CapacityBytes/util.Gb, cost)
+	if e.metricsManager != nil {
+		e.metricsManager.RecordEstimatedSpend(canonicalTier(instance.Tier), instanceOperationExpand, cost)
+	}
+}
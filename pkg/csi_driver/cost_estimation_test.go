@@ -0,0 +1,156 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/client-go/tools/record"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestParseTierPricing(t *testing.T) {
+	pricing, err := ParseTierPricing("Enterprise=0.45, basic_ssd=0.25")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pricing[enterpriseTier] != 0.45 {
+		t.Errorf("got enterprise price %v, expected the overridden 0.45", pricing[enterpriseTier])
+	}
+	if pricing[premiumTier] != 0.25 {
+		t.Errorf("got premium price %v, expected the price of its basic_ssd alias", pricing[premiumTier])
+	}
+	if pricing[zonalTier] != DefaultTierPricing[zonalTier] {
+		t.Errorf("got zonal price %v, expected the default %v", pricing[zonalTier], DefaultTierPricing[zonalTier])
+	}
+	if DefaultTierPricing[enterpriseTier] == 0.45 {
+		t.Errorf("default pricing modified")
+	}
+
+	for _, bad := range []string{"enterprise", "gold=1", "enterprise=cheap", "enterprise=-1"} {
+		if _, err := ParseTierPricing(bad); err == nil {
+			t.Errorf("expected error for pricing %q", bad)
+		}
+	}
+}
+
+func TestCostEstimatorMonthlyCost(t *testing.T) {
+	e := newCostEstimator(map[string]float64{defaultTier: 0.2, enterpriseTier: 0.6}, 0, nil, nil)
+	cases := []struct {
+		tier     string
+		bytes    int64
+		expected float64
+	}{
+		{tier: defaultTier, bytes: 1024 * util.Gb, expected: 204.8},
+		{tier: basicHDDTier, bytes: 1024 * util.Gb, expected: 204.8},
+		{tier: "ENTERPRISE", bytes: 1024 * util.Gb, expected: 614.4},
+		{tier: zonalTier, bytes: 1024 * util.Gb, expected: 0},
+	}
+	for _, tc := range cases {
+		if got := e.monthlyCost(tc.tier, tc.bytes); math.Abs(got-tc.expected) > 1e-9 {
+			t.Errorf("monthlyCost(%s, %d) = %v, expected %v", tc.tier, tc.bytes, got, tc.expected)
+		}
+	}
+}
+
+func TestCostEstimatorAlert(t *testing.T) {
+	params := map[string]string{ParameterKeyPVCName: "pvc", ParameterKeyPVCNamespace: "ns"}
+	cases := []struct {
+		name        string
+		threshold   float64
+		capacity    int64
+		params      map[string]string
+		expectEvent bool
+	}{
+		{
+			name:        "above threshold",
+			threshold:   500,
+			capacity:    10 * 1024 * util.Gb,
+			params:      params,
+			expectEvent: true,
+		},
+		{
+			name:      "below threshold",
+			threshold: 500,
+			capacity:  1024 * util.Gb,
+			params:    params,
+		},
+		{
+			name:     "alerts disabled",
+			capacity: 10 * 1024 * util.Gb,
+			params:   params,
+		},
+		{
+			name:      "no PVC",
+			threshold: 500,
+			capacity:  10 * 1024 * util.Gb,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			e := newCostEstimator(DefaultTierPricing, tc.threshold, recorder, nil)
+			e.instanceCreated(&file.MultishareInstance{Project: testProject, Location: testLocation, Name: "instance", Tier: defaultTier, CapacityBytes: tc.capacity}, tc.params)
+			select {
+			case event := <-recorder.Events:
+				if !tc.expectEvent {
+					t.Fatalf("unexpected event %q", event)
+				}
+				if !strings.Contains(event, eventReasonInstanceCostAboveThreshold) || !strings.Contains(event, "$2048.00") {
+					t.Errorf("got event %q, expected the estimated cost above the threshold", event)
+				}
+			default:
+				if tc.expectEvent {
+					t.Fatalf("expected an event")
+				}
+			}
+		})
+	}
+}
+
+func TestCreateVolumeCostEstimation(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	recorder := record.NewFakeRecorder(10)
+	cs.config.costEstimator = newCostEstimator(DefaultTierPricing, 100, recorder, nil)
+
+	_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		Parameters:         map[string]string{ParameterKeyPVCName: "pvc", ParameterKeyPVCNamespace: "ns"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, eventReasonInstanceCostAboveThreshold) {
+			t.Errorf("got event %q, expected %s", event, eventReasonInstanceCostAboveThreshold)
+		}
+	default:
+		t.Fatalf("expected an event for the default 1 TiB standard instance")
+	}
+}
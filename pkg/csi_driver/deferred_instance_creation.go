@@ -0,0 +1,145 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// annotationSelectedNode is set by the scheduler on the PVCs of the WaitForFirstConsumer
+// storage classes once a pod using them is scheduled.
+const annotationSelectedNode = "volume.kubernetes.io/selected-node"
+
+// instanceCreationDeferrer defers the creation of the instances of the PVCs no pod uses yet,
+// for at most window, so that a PVC whose pod never schedules doesn't cost an instance, e.g. a
+// 1TiB enterprise instance. CreateVolume fails with Unavailable while the creation is deferred,
+// and is retried by the external-provisioner. Once window has elapsed since the first deferral
+// of a PVC, its instance is created anyway. The PVC and pod names are passed by the
+// external-provisioner --extra-create-metadata flag, the instances of the volumes without them
+// are never deferred.
+type instanceCreationDeferrer struct {
+	window     time.Duration
+	kubeClient kubernetes.Interface
+	now        func() time.Time
+
+	mu sync.Mutex
+	// deferred maps the namespace/name of the PVCs whose instance creation is deferred to the
+	// time of their first deferral.
+	deferred map[string]time.Time
+}
+
+func newInstanceCreationDeferrer(window time.Duration, kubeClient kubernetes.Interface) *instanceCreationDeferrer {
+	return &instanceCreationDeferrer{
+		window:     window,
+		kubeClient: kubeClient,
+		now:        time.Now,
+		deferred:   make(map[string]time.Time),
+	}
+}
+
+// check returns an Unavailable error if the creation of the instance of the volume must be
+// deferred, because no pod uses its PVC yet.
+func (d *instanceCreationDeferrer) check(ctx context.Context, req *csi.CreateVolumeRequest) error {
+	if d == nil {
+		return nil
+	}
+	namespace, name := req.GetParameters()[ParameterKeyPVCNamespace], req.GetParameters()[ParameterKeyPVCName]
+	if namespace == "" || name == "" {
+		return nil
+	}
+	key := namespace + "/" + name
+	consumed, err := d.hasConsumer(ctx, namespace, name)
+	if err != nil {
+		// The creation isn't held back by a failing lookup.
+		klog.Warningf("Failed to look up the consumers of PVC %s, creating the instance of volume %s: %v", key, req.GetName(), err)
+		d.forget(key)
+		return nil
+	}
+	if consumed {
+		d.forget(key)
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	d.prune(now)
+	first, ok := d.deferred[key]
+	if !ok {
+		first = now
+		d.deferred[key] = now
+	}
+	if elapsed := now.Sub(first); elapsed >= d.window {
+		klog.Infof("No pod uses PVC %s after %v, creating the instance of volume %s", key, elapsed.Round(time.Second), req.GetName())
+		delete(d.deferred, key)
+		return nil
+	}
+	return status.Errorf(codes.Unavailable, "creation of the instance of volume %s deferred until a pod uses PVC %s, for at most %v", req.GetName(), key, (d.window - now.Sub(first)).Round(time.Second))
+}
+
+// hasConsumer returns whether a pod uses the PVC, either scheduled with it through the
+// WaitForFirstConsumer binding mode, or referencing it.
+func (d *instanceCreationDeferrer) hasConsumer(ctx context.Context, namespace, name string) (bool, error) {
+	pvc, err := d.kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if pvc.Annotations[annotationSelectedNode] != "" {
+		return true, nil
+	}
+	pods, err := d.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (d *instanceCreationDeferrer) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.deferred, key)
+}
+
+// prune forgets the PVCs deferred for long enough that they are not retried anymore, e.g.
+// deleted before their instance was created. The caller must hold d.mu.
+func (d *instanceCreationDeferrer) prune(now time.Time) {
+	for key, first := range d.deferred {
+		if now.Sub(first) > 2*d.window {
+			delete(d.deferred, key)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInstanceCreationDeferrer(t *testing.T) {
+	pvc := func(name string, annotations map[string]string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations}}
+	}
+	pod := func(name, claimName string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1.PodSpec{Volumes: []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+			}}},
+			Status: v1.PodStatus{Phase: phase},
+		}
+	}
+	request := func(pvcName string) *csi.CreateVolumeRequest {
+		params := map[string]string{}
+		if pvcName != "" {
+			params[ParameterKeyPVCNamespace] = "default"
+			params[ParameterKeyPVCName] = pvcName
+		}
+		return &csi.CreateVolumeRequest{Name: "pvc-" + pvcName, Parameters: params}
+	}
+
+	cases := []struct {
+		name         string
+		objects      []runtime.Object
+		pvcName      string
+		expectedCode codes.Code
+	}{
+		{name: "no PVC metadata"},
+		{name: "PVC scheduled with a pod", pvcName: "scheduled", objects: []runtime.Object{pvc("scheduled", map[string]string{annotationSelectedNode: "node-1"})}},
+		{name: "PVC used by a pod", pvcName: "used", objects: []runtime.Object{pvc("used", nil), pod("pod-1", "used", v1.PodPending)}},
+		{name: "PVC used by a completed pod", pvcName: "completed", objects: []runtime.Object{pvc("completed", nil), pod("pod-1", "completed", v1.PodSucceeded)}, expectedCode: codes.Unavailable},
+		{name: "PVC without consumer", pvcName: "unused", objects: []runtime.Object{pvc("unused", nil), pod("pod-1", "other", v1.PodRunning)}, expectedCode: codes.Unavailable},
+		{name: "PVC lookup failure", pvcName: "missing"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newInstanceCreationDeferrer(time.Hour, fake.NewSimpleClientset(tc.objects...))
+			err := d.check(context.Background(), request(tc.pvcName))
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("got error %v, expected code %v", err, tc.expectedCode)
+			}
+		})
+	}
+
+	// The creation is deferred for at most the window.
+	now := time.Now()
+	d := newInstanceCreationDeferrer(time.Hour, fake.NewSimpleClientset(pvc("unused", nil)))
+	d.now = func() time.Time { return now }
+	for _, step := range []struct {
+		elapsed      time.Duration
+		expectedCode codes.Code
+	}{
+		{elapsed: 0, expectedCode: codes.Unavailable},
+		{elapsed: 30 * time.Minute, expectedCode: codes.Unavailable},
+		{elapsed: time.Hour, expectedCode: codes.OK},
+	} {
+		d.now = func() time.Time { return now.Add(step.elapsed) }
+		if code := status.Code(d.check(context.Background(), request("unused"))); code != step.expectedCode {
+			t.Errorf("after %v: got code %v, expected %v", step.elapsed, code, step.expectedCode)
+		}
+	}
+	if len(d.deferred) != 0 {
+		t.Errorf("got deferred PVCs %v, expected none", d.deferred)
+	}
+}
+
+func TestCreateVolumeDeferredInstanceCreation(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.instanceCreationDeferrer = newInstanceCreationDeferrer(time.Hour, fake.NewSimpleClientset(
+		&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "unused", Namespace: "default"}},
+	))
+	req := &csi.CreateVolumeRequest{
+		Name: testCSIVolume,
+		Parameters: map[string]string{
+			ParameterKeyPVCNamespace: "default",
+			ParameterKeyPVCName:      "unused",
+		},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	}
+	if _, err := cs.CreateVolume(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("got error %v, expected code %v", err, codes.Unavailable)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+// deleteQueue keeps retrying the deletions of the volumes whose DeleteVolume failed with a
+// retriable error, e.g. while the instance is unavailable, in between the retries of the CO.
+// The retries are delayed with an exponential backoff per volume, and a deletion that keeps
+// failing for stuckAfter is reported as stuck.
+//
+// The queue is held in memory only. DeleteVolume still fails with the error of the deletion,
+// so that the PV is kept and the CO retries the deletion after a controller restart; once the
+// queue deleted the volume, the next retry of the CO succeeds.
+type deleteQueue struct {
+	queue          workqueue.RateLimitingInterface
+	deleteFunc     func(ctx context.Context, volumeID string) error
+	stuckAfter     time.Duration
+	metricsManager *metrics.MetricsManager
+	now            func() time.Time
+
+	sync.Mutex
+	// firstFailures maps volume ID to the time of its first failed deletion.
+	firstFailures map[string]time.Time
+	// stuck is the set of volumes whose deletion is reported as stuck.
+	stuck map[string]bool
+}
+
+func newDeleteQueue(config *FeatureDeleteRetryQueue, deleteFunc func(ctx context.Context, volumeID string) error, mm *metrics.MetricsManager) *deleteQueue {
+	if mm != nil {
+		mm.RegisterDeleteQueueMetrics()
+	}
+	return &deleteQueue{
+		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(config.BaseDelay, config.MaxDelay), "delete-volume"),
+		deleteFunc:     deleteFunc,
+		stuckAfter:     config.StuckThreshold,
+		metricsManager: mm,
+		now:            time.Now,
+		firstFailures:  make(map[string]time.Time),
+		stuck:          make(map[string]bool),
+	}
+}
+
+// isRetriableDeleteErr returns true if a failed deletion may succeed later without any
+// change of the request, e.g. once the Filestore API is available again. The Internal and
+// Unknown errors are left to the CO, retrying them in the background would hide driver bugs.
+func isRetriableDeleteErr(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// enqueue adds the deletion of a volume that failed with err to the queue.
+func (q *deleteQueue) enqueue(volumeID string, err error) {
+	klog.Warningf("Deletion of volume %s failed: %v, retrying it in the background", volumeID, err)
+	q.Lock()
+	if _, ok := q.firstFailures[volumeID]; !ok {
+		q.firstFailures[volumeID] = q.now()
+	}
+	q.Unlock()
+	q.metricsManager.RecordDeleteQueueRetry(err)
+	q.queue.AddRateLimited(volumeID)
+	q.recordDepth()
+}
+
+// Run processes the queue until stopCh is closed.
+func (q *deleteQueue) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting delete queue")
+	go wait.Until(func() {
+		for q.processNextItem(context.Background()) {
+		}
+	}, time.Second, stopCh)
+	<-stopCh
+	q.queue.ShutDown()
+}
+
+func (q *deleteQueue) processNextItem(ctx context.Context) bool {
+	item, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(item)
+	volumeID := item.(string)
+
+	err := q.deleteFunc(ctx, volumeID)
+	if err == nil {
+		klog.Infof("Deletion of volume %s succeeded after %d retries", volumeID, q.queue.NumRequeues(volumeID))
+		q.forget(volumeID)
+		return true
+	}
+	if !isRetriableDeleteErr(err) && status.Code(err) != codes.Aborted {
+		klog.Errorf("Deletion of volume %s failed with a non retriable error, giving up: %v", volumeID, err)
+		q.forget(volumeID)
+		return true
+	}
+
+	q.metricsManager.RecordDeleteQueueRetry(err)
+	q.Lock()
+	failingFor := q.now().Sub(q.firstFailures[volumeID])
+	if failingFor >= q.stuckAfter && !q.stuck[volumeID] {
+		q.stuck[volumeID] = true
+		klog.Errorf("Deletion of volume %s is stuck, failing for %v: %v", volumeID, failingFor.Round(time.Second), err)
+	}
+	q.Unlock()
+	klog.V(4).Infof("Deletion of volume %s failed: %v, retry %d", volumeID, err, q.queue.NumRequeues(volumeID)+1)
+	q.queue.AddRateLimited(volumeID)
+	q.recordDepth()
+	return true
+}
+
+func (q *deleteQueue) forget(volumeID string) {
+	q.queue.Forget(volumeID)
+	q.Lock()
+	delete(q.firstFailures, volumeID)
+	delete(q.stuck, volumeID)
+	q.Unlock()
+	q.recordDepth()
+}
+
+func (q *deleteQueue) recordDepth() {
+	q.Lock()
+	defer q.Unlock()
+	q.metricsManager.RecordDeleteQueueDepth(len(q.firstFailures), len(q.stuck))
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestIsRetriableDeleteErr(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{err: status.Error(codes.Unavailable, "unavailable"), expected: true},
+		{err: status.Error(codes.DeadlineExceeded, "instance deleting"), expected: true},
+		{err: status.Error(codes.ResourceExhausted, "quota exceeded"), expected: true},
+		{err: status.Error(codes.FailedPrecondition, "backup in progress"), expected: false},
+		{err: status.Error(codes.Internal, "internal"), expected: false},
+		{err: fmt.Errorf("not a grpc error"), expected: false},
+		{err: status.Error(codes.InvalidArgument, "invalid volume id"), expected: false},
+		{err: status.Error(codes.Aborted, "operation in progress"), expected: false},
+		{err: status.Error(codes.PermissionDenied, "denied"), expected: false},
+	}
+	for _, tc := range cases {
+		if got := isRetriableDeleteErr(tc.err); got != tc.expected {
+			t.Errorf("isRetriableDeleteErr(%v) = %v, expected %v", tc.err, got, tc.expected)
+		}
+	}
+}
+
+func TestDeleteQueue(t *testing.T) {
+	cases := []struct {
+		name            string
+		errs            []error
+		expectedDeletes int
+		expectedStuck   bool
+		expectedQueued  bool
+	}{
+		{
+			name:            "succeeds on first retry",
+			errs:            []error{nil},
+			expectedDeletes: 1,
+		},
+		{
+			name: "succeeds after transient failures",
+			errs: []error{
+				status.Error(codes.Unavailable, "instance unavailable"),
+				status.Error(codes.Aborted, "operation in progress"),
+				nil,
+			},
+			expectedDeletes: 3,
+		},
+		{
+			name: "gives up on non retriable error",
+			errs: []error{
+				status.Error(codes.Unavailable, "instance unavailable"),
+				status.Error(codes.PermissionDenied, "denied"),
+			},
+			expectedDeletes: 2,
+		},
+		{
+			name: "stuck",
+			errs: []error{
+				status.Error(codes.Unavailable, "instance unavailable"),
+				status.Error(codes.Unavailable, "instance unavailable"),
+				status.Error(codes.Unavailable, "instance unavailable"),
+			},
+			expectedDeletes: 3,
+			expectedStuck:   true,
+			expectedQueued:  true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			deletes := 0
+			q := newDeleteQueue(&FeatureDeleteRetryQueue{
+				Enabled:        true,
+				BaseDelay:      time.Millisecond,
+				MaxDelay:       time.Millisecond,
+				StuckThreshold: time.Hour,
+			}, func(ctx context.Context, volumeID string) error {
+				err := tc.errs[deletes]
+				deletes++
+				return err
+			}, nil)
+			now := time.Now()
+			q.now = func() time.Time { return now }
+			defer q.queue.ShutDown()
+
+			q.enqueue(testVolumeID, status.Error(codes.Unavailable, "instance unavailable"))
+			for i := 0; i < len(tc.errs); i++ {
+				now = now.Add(30 * time.Minute)
+				q.processNextItem(context.Background())
+			}
+
+			if deletes != tc.expectedDeletes {
+				t.Errorf("got %d deletions, expected %d", deletes, tc.expectedDeletes)
+			}
+			q.Lock()
+			_, queued := q.firstFailures[testVolumeID]
+			stuck := q.stuck[testVolumeID]
+			q.Unlock()
+			if queued != tc.expectedQueued {
+				t.Errorf("got volume queued %v, expected %v", queued, tc.expectedQueued)
+			}
+			if stuck != tc.expectedStuck {
+				t.Errorf("got volume stuck %v, expected %v", stuck, tc.expectedStuck)
+			}
+		})
+	}
+}
+
+// unavailableDeleteService fails the instance deletions with an Unavailable error.
+type unavailableDeleteService struct {
+	file.Service
+}
+
+func (s *unavailableDeleteService) DeleteInstance(ctx context.Context, obj *file.ServiceInstance) error {
+	return status.Error(codes.Unavailable, "instance unavailable")
+}
+
+func TestDeleteVolumeRetriableErrorQueued(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create volume: %v", err)
+	}
+	fs := &unavailableDeleteService{Service: cs.config.fileService}
+	cs.config.fileService = fs
+	cs.config.cloud.File = fs
+	cs.config.deleteQueue = newDeleteQueue(&FeatureDeleteRetryQueue{Enabled: true, BaseDelay: time.Hour, MaxDelay: time.Hour, StuckThreshold: time.Hour}, func(ctx context.Context, volumeID string) error {
+		return nil
+	}, nil)
+	defer cs.config.deleteQueue.queue.ShutDown()
+
+	// The PV must be kept until the deletion succeeds, the queue only retries it in between.
+	volumeID := resp.GetVolume().GetVolumeId()
+	if _, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("got error %v, expected code %v", err, codes.Unavailable)
+	}
+	cs.config.deleteQueue.Lock()
+	_, queued := cs.config.deleteQueue.firstFailures[volumeID]
+	cs.config.deleteQueue.Unlock()
+	if !queued {
+		t.Errorf("volume %s not queued", volumeID)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// maxBackupNameLength is the maximum length of the name of a Filestore backup.
+const maxBackupNameLength = 63
+
+// expansionBackupName returns the name of the backup of source, an instance or a share, taken
+// before its expansion to capacityBytes. The retries of an expansion use the same backup.
+func expansionBackupName(source string, capacityBytes int64) string {
+	suffix := fmt.Sprintf("-expand-%dgib", util.BytesToGb(capacityBytes))
+	name := strings.ReplaceAll(strings.ToLower(source), "_", "-")
+	if len(name)+len(suffix) > maxBackupNameLength {
+		name = strings.TrimRight(name[:maxBackupNameLength-len(suffix)], "-")
+	}
+	return name + suffix
+}
+
+// expansionBackupInfo returns the backup to take of the file share of volumeID before its
+// expansion to capacityBytes.
+func expansionBackupInfo(volumeID, project, location, instance, fileShare string, capacityBytes int64, labels map[string]string) (*file.BackupInfo, error) {
+	source := instance
+	if isMultishareVolId(volumeID) {
+		source = fileShare
+	}
+	name := expansionBackupName(source, capacityBytes)
+	backupURI, region, err := file.CreateBackupURI(location, project, name, "")
+	if err != nil {
+		return nil, err
+	}
+	return &file.BackupInfo{
+		Name:               name,
+		SourceVolumeId:     volumeID,
+		BackupURI:          backupURI,
+		SourceInstanceName: instance,
+		SourceShare:        fileShare,
+		Project:            project,
+		Location:           region,
+		Labels:             labels,
+	}, nil
+}
+
+// ensureExpansionBackup takes the backup before an expansion, waiting at most timeout for it to
+// be ready. Once the wait times out, the retries of the expansion wait for the backup until it
+// is ready, and a failed backup must be deleted to retry the expansion.
+func ensureExpansionBackup(ctx context.Context, fileService file.Service, backupInfo *file.BackupInfo, timeout time.Duration) error {
+	existingBackup, err := fileService.GetBackup(ctx, backupInfo.BackupURI)
+	backupExists, err := file.CheckBackupExists(existingBackup, err)
+	if err != nil {
+		return err
+	}
+	if backupExists {
+		switch state := existingBackup.Backup.State; state {
+		case "READY":
+			return nil
+		case "CREATING", "FINALIZING":
+			return status.Errorf(codes.DeadlineExceeded, "backup %s taken before the expansion of volume %s is not ready yet, current state: %s", backupInfo.BackupURI, backupInfo.SourceVolumeId, state)
+		default:
+			return status.Errorf(codes.FailedPrecondition, "backup %s taken before the expansion of volume %s is in state %s, delete it to retry the expansion", backupInfo.BackupURI, backupInfo.SourceVolumeId, state)
+		}
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	klog.Infof("Creating backup %s before the expansion of volume %s", backupInfo.BackupURI, backupInfo.SourceVolumeId)
+	if _, err := fileService.CreateBackup(ctx, backupInfo); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return status.Errorf(codes.DeadlineExceeded, "backup %s taken before the expansion of volume %s is not ready after %v", backupInfo.BackupURI, backupInfo.SourceVolumeId, timeout)
+		}
+		return file.StatusError(err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestExpansionBackupName(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{source: "pvc-1234", expected: "pvc-1234-expand-2048gib"},
+		{source: "pvc_95066a34_63e7_4c9b_8555_dff91b1b6346", expected: "pvc-95066a34-63e7-4c9b-8555-dff91b1b6346-expand-2048gib"},
+		{source: strings.Repeat("a", 62) + "-b", expected: strings.Repeat("a", 48) + "-expand-2048gib"},
+		{source: strings.Repeat("a", 47) + "-b", expected: strings.Repeat("a", 47) + "-expand-2048gib"},
+	}
+	for _, tc := range cases {
+		name := expansionBackupName(tc.source, 2*util.Tb)
+		if name != tc.expected {
+			t.Errorf("source %q: got name %q, expected %q", tc.source, name, tc.expected)
+		}
+		if len(name) > maxBackupNameLength {
+			t.Errorf("source %q: name %q is longer than %d", tc.source, name, maxBackupNameLength)
+		}
+	}
+}
+
+func TestEnsureExpansionBackup(t *testing.T) {
+	cases := []struct {
+		name         string
+		initialState string
+		expectedCode codes.Code
+	}{
+		{name: "new backup"},
+		{name: "ready backup", initialState: "READY"},
+		{name: "backup in progress", initialState: "CREATING", expectedCode: codes.DeadlineExceeded},
+		{name: "failed backup", initialState: "INVALID", expectedCode: codes.FailedPrecondition},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := file.NewFakeService()
+			if err != nil {
+				t.Fatalf("failed to initialize GCFS service: %v", err)
+			}
+			ctx := context.Background()
+			backupInfo, err := expansionBackupInfo(testVolumeID, testProject, testZone, testCSIVolume, newInstanceVolume, 2*util.Tb, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.initialState != "" {
+				if _, err := s.CreateBackup(ctx, backupInfo); err != nil {
+					t.Fatalf("failed to create backup: %v", err)
+				}
+				backup, _ := s.GetBackup(ctx, backupInfo.BackupURI)
+				backup.Backup.State = tc.initialState
+			}
+
+			err = ensureExpansionBackup(ctx, s, backupInfo, time.Minute)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("got error %v, expected code %v", err, tc.expectedCode)
+			}
+			if _, err := s.GetBackup(ctx, backupInfo.BackupURI); err != nil {
+				t.Errorf("failed to get backup: %v", err)
+			}
+		})
+	}
+}
+
+func TestControllerExpandVolumeBackupBeforeExpand(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	params := map[string]string{paramTier: enterpriseTier, paramBackupBeforeExpand: "true"}
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", context.TODO(), cloud.FilestoreInstance, testCSIVolume, testLocation, testCSIVolume, params).
+		Return(nil)
+	ctx := context.TODO()
+	_, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}},
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	volumeID := modeInstance + "/" + testZone + "/" + testCSIVolume + "/" + newInstanceVolume
+	resp, err := cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      volumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * util.Tb},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.CapacityBytes != 2*util.Tb {
+		t.Errorf("got capacity %d, expected %d", resp.CapacityBytes, 2*util.Tb)
+	}
+	backupURI := "projects/" + testProject + "/locations/" + testRegion + "/backups/" + testCSIVolume + "-expand-2048gib"
+	backup, err := cs.config.fileService.GetBackup(ctx, backupURI)
+	if err != nil {
+		t.Fatalf("failed to get backup %s: %v", backupURI, err)
+	}
+	if backup.SourceInstance != "projects/"+testProject+"/locations/"+testZone+"/instances/"+testCSIVolume {
+		t.Errorf("got backup source %s", backup.SourceInstance)
+	}
+
+	// The parameter is only supported for enterprise instances.
+	params[paramTier] = defaultTier
+	if _, err := cs.generateNewFileInstance(testCSIVolume, testBytes, params, nil); err == nil {
+		t.Errorf("expected error for tier %s, got none", defaultTier)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"math"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
@@ -33,6 +34,7 @@ import (
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
@@ -54,23 +56,88 @@ const (
 )
 
 type GCFSDriverConfig struct {
-	Name              string          // Driver name
-	Version           string          // Driver version
-	NodeName          string          // Node name
-	RunController     bool            // Run CSI controller service
-	RunNode           bool            // Run CSI node service
-	Mounter           mount.Interface // Mount library
-	Cloud             *cloud.Cloud    // Cloud provider
-	MetadataService   metadataservice.Service
-	EnableMultishare  bool
-	Reconciler        *MultishareReconciler
-	Metrics           *metrics.MetricsManager
-	EcfsDescription   string
-	IsRegional        bool
-	ClusterName       string
-	FeatureOptions    *GCFSDriverFeatureOptions
-	ExtraVolumeLabels map[string]string
-	TagManager        cloud.TagService
+	Name             string          // Driver name
+	Version          string          // Driver version
+	Commit           string          // Driver source commit, if known
+	FeatureGates     []string        // Enabled feature gates
+	NodeName         string          // Node name
+	RunController    bool            // Run CSI controller service
+	RunNode          bool            // Run CSI node service
+	Mounter          mount.Interface // Mount library
+	Cloud            *cloud.Cloud    // Cloud provider
+	MetadataService  metadataservice.Service
+	EnableMultishare bool
+	ListParallelism  int // Max concurrent per-instance share list calls in multishare eligibility checks
+	// StuckOpThreshold, if non-zero, is the age after which a running multishare operation is
+	// reported as stuck.
+	StuckOpThreshold time.Duration
+	// MaxRegionalCapacityTB, if non-zero, caps the total capacity in TiB of the
+	// multishare instances managed by the driver in a region.
+	MaxRegionalCapacityTB int64
+	// NFSFirewallRules creates the firewall rules allowing the NFS traffic of the new
+	// instances in their VPC network.
+	NFSFirewallRules bool
+	// NFSFirewallNodeCIDRs are the node ranges of the NFS firewall rules, all the instances
+	// of the network if empty.
+	NFSFirewallNodeCIDRs []string
+	// ParameterDefaults, if non-nil, sets the tier, network and connect mode of the volumes
+	// whose StorageClass omits them.
+	ParameterDefaults *ParameterDefaults
+	// OpTimeouts, if non-nil, are the deadlines of the waits on the operations creating and
+	// expanding the instances and the shares of the volumes.
+	OpTimeouts *OpTimeouts
+	// TierPolicy, if non-nil, restricts the tiers of the volumes provisioned by the driver.
+	TierPolicy *TierPolicy
+	// InstancePools, if non-nil, configures the multishare operations of each instance pool.
+	InstancePools   *InstancePoolsConfig
+	Reconciler      *MultishareReconciler
+	Metrics         *metrics.MetricsManager
+	EcfsDescription string
+	IsRegional      bool
+	ClusterName     string
+	// ClusterLocation, if non-empty, overrides the cluster location derived from the zone of
+	// the driver, e.g. for self-managed clusters.
+	ClusterLocation string
+	// ClusterUID, if non-empty, is the UID of the cluster, recorded on the multishare instances.
+	ClusterUID string
+	// SharedClusterGroup, if non-empty, is the group of clusters sharing multishare instances.
+	// The instances are then matched by group instead of by cluster name and location.
+	SharedClusterGroup string
+	FeatureOptions     *GCFSDriverFeatureOptions
+	ExtraVolumeLabels  map[string]string
+	// ClearDeletionProtection deletes the volumes created with deletion protection instead of
+	// refusing to.
+	ClearDeletionProtection bool
+	// VolumeLocationAliases maps the location of the volume IDs of instance mode volumes to the
+	// location their instances are looked up at when not found at the former.
+	VolumeLocationAliases map[string]string
+	// AllowSoftMounts acknowledges the data integrity risk of the soft mounts of the volumes
+	// with the soft mount policy, refused otherwise.
+	AllowSoftMounts bool
+	// KerberosKeytabPath, if non-empty, is the path of the keytab the node driver merges the
+	// Kerberos keytabs of the node stage secrets of the volumes of the Kerberos auth flavors into.
+	KerberosKeytabPath string
+	// MaxConcurrentMounts, if positive, is the maximum number of NFS mounts run in parallel by
+	// the node driver. The mounts over the limit wait for at most MountQueueTimeout.
+	MaxConcurrentMounts int
+	MountQueueTimeout   time.Duration
+	// VerifyNodeExpansion has the expansions of the volumes complete once NodeExpandVolume
+	// verified the filesystems of the nodes report the expanded capacity.
+	VerifyNodeExpansion bool
+	// BackupBeforeExpandTimeout bounds the wait for the backups taken before the expansion of
+	// the volumes created with the backup-before-expand parameter.
+	BackupBeforeExpandTimeout time.Duration
+	// ConnectivityCheckTimeout, if positive, bounds the check that the NFS server of the new
+	// instances is reachable from the controller, before their volumes are provisioned.
+	ConnectivityCheckTimeout time.Duration
+	TagManager               cloud.TagService
+	ServerOptions            *ServerOptions // CSI gRPC server options, nil means no limits
+	// KubeClient is the kubernetes client shared by the features of the driver, required if
+	// FeatureOptions.NeedsKubeClient. DriverClient is the client of the custom resources of the
+	// driver, and EventRecorder records the events of the features.
+	KubeClient    kubernetes.Interface
+	DriverClient  clientset.Interface
+	EventRecorder record.EventRecorder
 }
 
 type GCFSDriver struct {
@@ -101,6 +168,75 @@ type GCFSDriverFeatureOptions struct {
 	FeatureStateful                 *FeatureStateful
 	FeatureMultishareBackups        *FeatureMultishareBackups
 	FeatureNFSExportOptionsOnCreate *FeatureNFSExportOptionsOnCreate
+	// FeatureMountHealth will enable the node driver to periodically probe staged mounts and report their health as metrics.
+	FeatureMountHealth *FeatureMountHealth
+	// FeatureInstanceEvents will enable the controller driver to publish events on the PVs whose Filestore instance becomes unavailable.
+	FeatureInstanceEvents *FeatureInstanceEvents
+	// FeatureTierRecommendations will enable the node driver to recommend cheaper tiers or smaller capacities for the staged volumes based on their observed usage.
+	FeatureTierRecommendations *FeatureTierRecommendations
+	// FeatureDeleteRetryQueue will enable the controller driver to keep retrying the failed volume deletions in the background.
+	FeatureDeleteRetryQueue *FeatureDeleteRetryQueue
+	// FeatureShareMigration will enable the controller driver to move the shares of the annotated multishare PVCs to another instance.
+	FeatureShareMigration *FeatureShareMigration
+	// FeatureRebalancer will enable the controller driver to plan, and execute once approved, the consolidation of the under-utilized multishare instances.
+	FeatureRebalancer *FeatureRebalancer
+	// FeatureVolumeRestore will enable the controller driver to restore backups in place to the volumes of the annotated PVCs.
+	FeatureVolumeRestore *FeatureVolumeRestore
+	// FeatureMultishareDeleteBatching will enable the controller driver to queue the share deletions per multishare instance.
+	FeatureMultishareDeleteBatching *FeatureMultishareDeleteBatching
+	// FeatureInstanceLabelReconciler will enable the controller driver to re-apply the labels removed or edited out of band on the multishare instances.
+	FeatureInstanceLabelReconciler *FeatureInstanceLabelReconciler
+	// FeatureReplicaPromotion will enable the controller driver to fail the volumes of the annotated PVCs over to a replica of their instance.
+	FeatureReplicaPromotion *FeatureReplicaPromotion
+	// FeatureBackupPolicy will enable the controller driver to take the backups scheduled by the BackupPolicy resources.
+	FeatureBackupPolicy *FeatureBackupPolicy
+	// FeatureRestoreProgress will enable the controller driver to report the progress of the restores of backups on their PVCs.
+	FeatureRestoreProgress *FeatureRestoreProgress
+	// FeatureNFSStats will enable the node driver to export the NFS client statistics of the staged mounts as metrics.
+	FeatureNFSStats *FeatureNFSStats
+	// FeatureInstanceIPRefresh will enable the controller driver to publish the current IPs of the instances, and the node driver to mount the volumes from them.
+	FeatureInstanceIPRefresh *FeatureInstanceIPRefresh
+	// FeatureDeferredInstanceCreation will enable the controller driver to defer the creation of the instances of the PVCs no pod uses yet.
+	FeatureDeferredInstanceCreation *FeatureDeferredInstanceCreation
+	// FeatureCostEstimation will enable the controller driver to estimate the monthly cost of the instances it creates and expands.
+	FeatureCostEstimation *FeatureCostEstimation
+	// FeatureShareExportAnnotations will enable the controller driver to override the NFS export options of the new shares with the annotations of their PVC.
+	FeatureShareExportAnnotations *FeatureShareExportAnnotations
+	// FeatureCapacityWatermark will enable the node driver to warn on the PVCs of the staged volumes fuller than the capacity watermark.
+	FeatureCapacityWatermark *FeatureCapacityWatermark
+	// FeatureAutoExpansion will enable the controller driver to expand the annotated PVCs whose used capacity crosses a threshold.
+	FeatureAutoExpansion *FeatureAutoExpansion
+}
+
+// NeedsKubeClient returns whether an enabled feature uses the KubeClient of the driver.
+func (o *GCFSDriverFeatureOptions) NeedsKubeClient() bool {
+	if o == nil {
+		return false
+	}
+	enabled := []bool{
+		o.FeatureLockRelease != nil && o.FeatureLockRelease.Enabled,
+		o.FeatureInstanceEvents != nil && o.FeatureInstanceEvents.Enabled,
+		o.FeatureTierRecommendations != nil && o.FeatureTierRecommendations.Enabled,
+		o.FeatureShareMigration != nil && o.FeatureShareMigration.Enabled,
+		o.FeatureRebalancer != nil && o.FeatureRebalancer.Enabled,
+		o.FeatureVolumeRestore != nil && o.FeatureVolumeRestore.Enabled,
+		o.FeatureInstanceLabelReconciler != nil && o.FeatureInstanceLabelReconciler.Enabled,
+		o.FeatureReplicaPromotion != nil && o.FeatureReplicaPromotion.Enabled,
+		o.FeatureBackupPolicy != nil && o.FeatureBackupPolicy.Enabled,
+		o.FeatureRestoreProgress != nil && o.FeatureRestoreProgress.Enabled,
+		o.FeatureInstanceIPRefresh != nil && o.FeatureInstanceIPRefresh.Enabled,
+		o.FeatureDeferredInstanceCreation != nil && o.FeatureDeferredInstanceCreation.Enabled,
+		o.FeatureCostEstimation != nil && o.FeatureCostEstimation.Enabled && o.FeatureCostEstimation.AlertThreshold > 0,
+		o.FeatureShareExportAnnotations != nil && o.FeatureShareExportAnnotations.Enabled,
+		o.FeatureCapacityWatermark != nil && o.FeatureCapacityWatermark.Enabled,
+		o.FeatureAutoExpansion != nil && o.FeatureAutoExpansion.Enabled,
+	}
+	for _, e := range enabled {
+		if e {
+			return true
+		}
+	}
+	return false
 }
 
 type FeatureMultishareBackups struct {
@@ -111,6 +247,202 @@ type FeatureNFSExportOptionsOnCreate struct {
 	Enabled bool
 }
 
+// FeatureMountHealth periodically probes the staged Filestore mounts on the node and
+// exposes their health as prometheus metrics.
+type FeatureMountHealth struct {
+	Enabled bool
+	// ProbePeriod is the interval between two consecutive walks over the staged mounts.
+	ProbePeriod time.Duration
+	// ProbeTimeout is the duration after which a statfs probe that has not returned
+	// marks the mount unreachable.
+	ProbeTimeout time.Duration
+}
+
+// FeatureNFSStats periodically reads the NFS client statistics of the staged Filestore mounts
+// on the node from /proc/self/mountstats, and exposes them as prometheus metrics per volume.
+type FeatureNFSStats struct {
+	Enabled bool
+	// CollectionPeriod is the interval between two consecutive reads of the statistics.
+	CollectionPeriod time.Duration
+}
+
+// FeatureInstanceIPRefresh keeps the volumes mountable when the IP of their instance changes,
+// e.g. when it is migrated from VPC peering to Private Service Connect. The controller driver
+// periodically publishes the current IPs of the instances backing the PVs in a ConfigMap, and
+// flags the PVs whose ip volume attribute is stale. The node driver mounts the volumes from
+// the IPs of the ConfigMap rather than from their volume attribute.
+type FeatureInstanceIPRefresh struct {
+	Enabled bool
+	// Period is the interval between two consecutive refreshes of the IPs by the controller.
+	Period time.Duration
+	// CacheTTL is the duration the node driver caches the IPs read from the ConfigMap.
+	CacheTTL time.Duration
+	// Namespace is the namespace of the ConfigMap.
+	Namespace string
+}
+
+// FeatureDeferredInstanceCreation defers the creation of a new instance for a volume whose PVC
+// no pod uses yet, e.g. of a storage class with the Immediate binding mode, until a pod uses it
+// or for at most Window, so that a PVC whose pod never schedules doesn't cost an instance.
+type FeatureDeferredInstanceCreation struct {
+	Enabled bool
+	// Window is the maximum duration the creation of an instance is deferred.
+	Window time.Duration
+}
+
+// FeatureCostEstimation estimates the monthly cost of the instances created and expanded by the
+// controller from a static per-tier pricing, exports the cumulative estimated spend as a metric,
+// and publishes a warning event on the PVC of a volume whose new instance is estimated to cost
+// more than AlertThreshold.
+type FeatureCostEstimation struct {
+	Enabled bool
+	// Pricing is the monthly price in dollars of a GiB of capacity, by tier.
+	Pricing map[string]float64
+	// AlertThreshold is the monthly cost in dollars of a new instance above which a warning
+	// event is published on its PVC. Zero disables the events.
+	AlertThreshold float64
+}
+
+// FeatureShareExportAnnotations overrides the NFS export options of the share of a new
+// multishare volume, set by its StorageClass, with the filestore.csi.storage.gke.io/export-<option>
+// annotations of its PVC.
+type FeatureShareExportAnnotations struct {
+	Enabled bool
+	// Allowed are the names of the export options the annotations can override, e.g. ip-ranges.
+	Allowed []string
+}
+
+// FeatureCapacityWatermark samples the used capacity of the staged Filestore volumes on the
+// node, exports it as metrics, and publishes a warning event on the PVC of a volume whose used
+// capacity crosses the watermark.
+type FeatureCapacityWatermark struct {
+	Enabled bool
+	// Period is the interval between two consecutive samples of the staged volumes.
+	Period time.Duration
+	// Percent is the percentage of the capacity of a volume used above which its PVC is warned.
+	Percent float64
+}
+
+// FeatureAutoExpansion periodically expands the PVCs annotated with filestore.csi/autoexpand
+// whose used capacity, reported by the kubelets, crosses a threshold, by patching their
+// requested size.
+type FeatureAutoExpansion struct {
+	Enabled bool
+	// PollPeriod is the interval between two consecutive checks of the annotated PVCs.
+	PollPeriod time.Duration
+	// Threshold is the default percentage of its capacity used above which a PVC is expanded.
+	Threshold float64
+}
+
+// FeatureInstanceEvents periodically checks the state of the Filestore instances backing the
+// PVs of the driver, and publishes events on the PVs and their PVCs when an instance becomes
+// unavailable, e.g. while it is being repaired, and when it is ready again.
+type FeatureInstanceEvents struct {
+	Enabled bool
+	// PollPeriod is the interval between two consecutive checks of the instances.
+	PollPeriod time.Duration
+}
+
+// FeatureTierRecommendations samples the NFS throughput and capacity usage of the staged
+// Filestore volumes on the node, and exports right-sizing recommendations as metrics and
+// as annotations on the PVs. The controller driver passes the tier of the volumes to the
+// node driver in the volume context.
+type FeatureTierRecommendations struct {
+	Enabled bool
+	// AnalysisPeriod is the interval between two consecutive samples of the staged volumes.
+	AnalysisPeriod time.Duration
+	// AnalysisWindow is the duration over which the peak throughput of a volume is observed
+	// before a recommendation is made.
+	AnalysisWindow time.Duration
+}
+
+// FeatureDeleteRetryQueue adds the volume deletions failing with a retriable error to a
+// controller-internal queue, which keeps retrying them with an exponential backoff in between
+// the retries of the CO.
+type FeatureDeleteRetryQueue struct {
+	Enabled bool
+	// BaseDelay is the delay before the first retry of a deletion, doubled on each failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two retries of a deletion.
+	MaxDelay time.Duration
+	// StuckThreshold is the duration after which a deletion still failing is reported stuck.
+	StuckThreshold time.Duration
+}
+
+// FeatureShareMigration moves the share of a multishare PVC annotated with the target instance
+// to that instance, by restoring a backup of the share once no pod uses the PVC, and rebinding
+// the PVC to a new PV of the restored share.
+type FeatureShareMigration struct {
+	Enabled bool
+	// PollPeriod is the interval between two consecutive checks of the annotated PVCs.
+	PollPeriod time.Duration
+}
+
+// FeatureRebalancer periodically plans to move the shares off the multishare instances used
+// below a threshold of their capacity, so that they are deleted. The plans are published as
+// ConsolidationPlan resources, executed with the share migration flow once approved.
+type FeatureRebalancer struct {
+	Enabled bool
+	// Period is the interval between two consecutive plannings.
+	Period time.Duration
+	// UtilizationThreshold is the fraction of its capacity used by the shares of an instance
+	// below which the instance is drained.
+	UtilizationThreshold float64
+	// AutoApprove approves the plans when they are proposed.
+	AutoApprove bool
+	// Namespace is the namespace of the ConsolidationPlan resources.
+	Namespace string
+}
+
+// FeatureVolumeRestore restores a backup in place to the volume of a PVC annotated with the
+// backup, once no pod uses the PVC.
+type FeatureVolumeRestore struct {
+	Enabled bool
+	// PollPeriod is the interval between two consecutive checks of the annotated PVCs.
+	PollPeriod time.Duration
+}
+
+// FeatureMultishareDeleteBatching queues the share deletions of each multishare instance, so
+// that a mass deletion, e.g. of a namespace, runs the deletions of an instance back to back
+// and shrinks or deletes the instance once for the whole batch, instead of having every
+// DeleteVolume call contend for the instance.
+type FeatureMultishareDeleteBatching struct {
+	Enabled bool
+}
+
+// FeatureInstanceLabelReconciler periodically verifies that the multishare instances backing
+// the PVs of the driver carry the instance pool tag and cluster labels they are matched with,
+// and re-applies the labels removed or edited out of band.
+type FeatureInstanceLabelReconciler struct {
+	Enabled bool
+	// Period is the interval between two consecutive reconciliations of the labels.
+	Period time.Duration
+}
+
+// FeatureReplicaPromotion promotes the replica of the instance of a PVC annotated with the
+// replica, and rebinds the PVC to the file share of the promoted instance.
+type FeatureReplicaPromotion struct {
+	Enabled bool
+	// PollPeriod is the interval between two consecutive checks of the annotated PVCs.
+	PollPeriod time.Duration
+}
+
+// FeatureBackupPolicy backs up the PVCs selected by the BackupPolicy resources on their
+// schedule, and deletes the backups beyond their retention count.
+type FeatureBackupPolicy struct {
+	Enabled bool
+	// PollPeriod is the interval between two consecutive checks of the policies.
+	PollPeriod time.Duration
+}
+
+// FeatureRestoreProgress reports the progress of the restores of backups, to new volumes or
+// in place, in events on their PVCs, and records the duration of the restores.
+type FeatureRestoreProgress struct {
+	Enabled bool
+	// Period is the interval between two consecutive progress events of a restore.
+	Period time.Duration
+}
+
 type FeatureStateful struct {
 	Enabled      bool
 	KubeAPIQPS   float64
@@ -151,6 +483,24 @@ func NewGCFSDriver(config *GCFSDriverConfig) (*GCFSDriver, error) {
 	if !config.RunController && !config.RunNode {
 		return nil, fmt.Errorf("must run at least one controller or node service")
 	}
+	if config.ClusterLocation != "" {
+		if err := util.CheckLabelValueRegex(config.ClusterLocation); err != nil {
+			return nil, fmt.Errorf("invalid cluster location: %w", err)
+		}
+	}
+	if config.ClusterUID != "" {
+		if err := util.CheckLabelValueRegex(config.ClusterUID); err != nil {
+			return nil, fmt.Errorf("invalid cluster UID: %w", err)
+		}
+	}
+	if config.SharedClusterGroup != "" {
+		if err := util.CheckLabelValueRegex(config.SharedClusterGroup); err != nil {
+			return nil, fmt.Errorf("invalid shared cluster group: %w", err)
+		}
+	}
+	if config.FeatureOptions.NeedsKubeClient() && config.KubeClient == nil {
+		return nil, fmt.Errorf("kubernetes client missing for the enabled features")
+	}
 
 	driver := &GCFSDriver{
 		config: config,
@@ -173,6 +523,9 @@ func NewGCFSDriver(config *GCFSDriverConfig) (*GCFSDriver, error) {
 			csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 			csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		}
+		if config.VerifyNodeExpansion {
+			nscap = append(nscap, csi.NodeServiceCapability_RPC_EXPAND_VOLUME)
+		}
 		ns, err := newNodeServer(driver, config.Mounter, config.MetadataService, config.FeatureOptions)
 		if err != nil {
 			return nil, err
@@ -181,37 +534,150 @@ func NewGCFSDriver(config *GCFSDriverConfig) (*GCFSDriver, error) {
 		driver.addNodeServiceCapabilities(nscap)
 	}
 	if config.RunController {
-		csc := []csi.ControllerServiceCapability_RPC_Type{
-			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
-		}
-		driver.addControllerServiceCapabilities(csc)
+		driver.addControllerServiceCapabilities(controllerServiceCapabilities(config))
 
 		if config.FeatureOptions.FeatureStateful != nil && config.FeatureOptions.FeatureStateful.Enabled {
 			driver.recon, driver.factory, driver.coreFactory, driver.driverFactory = initMultishareReconciler(config)
 		}
+		var instanceEvents *instanceEventsReporter
+		if config.FeatureOptions.FeatureInstanceEvents != nil && config.FeatureOptions.FeatureInstanceEvents.Enabled {
+			instanceEvents = newInstanceEventsReporter(config.Cloud.File, config.Cloud.Project, config.Name, config.FeatureOptions.FeatureInstanceEvents.PollPeriod, config.KubeClient, config.EventRecorder)
+		}
+		var shareMigrator *shareMigrator
+		if config.FeatureOptions.FeatureShareMigration != nil && config.FeatureOptions.FeatureShareMigration.Enabled {
+			shareMigrator = newShareMigrator(config.Name, config.FeatureOptions.FeatureShareMigration.PollPeriod, config.KubeClient, config.EventRecorder)
+		}
+		var volumeRestorer *volumeRestorer
+		if config.FeatureOptions.FeatureVolumeRestore != nil && config.FeatureOptions.FeatureVolumeRestore.Enabled {
+			volumeRestorer = newVolumeRestorer(config.Name, config.FeatureOptions.FeatureVolumeRestore.PollPeriod, config.KubeClient, config.EventRecorder)
+		}
+		var shareRebalancer *shareRebalancer
+		if config.FeatureOptions.FeatureRebalancer != nil && config.FeatureOptions.FeatureRebalancer.Enabled {
+			shareRebalancer = newShareRebalancer(config.FeatureOptions.FeatureRebalancer, config.Name, config.KubeClient, config.DriverClient, config.FeatureOptions.FeatureShareMigration != nil && config.FeatureOptions.FeatureShareMigration.Enabled)
+		}
+		var instanceLabelReconciler *instanceLabelReconciler
+		if config.FeatureOptions.FeatureInstanceLabelReconciler != nil && config.FeatureOptions.FeatureInstanceLabelReconciler.Enabled {
+			instanceLabelReconciler = newInstanceLabelReconciler(config.FeatureOptions.FeatureInstanceLabelReconciler, config.Name, config.KubeClient)
+		}
+		var instanceIPReconciler *instanceIPReconciler
+		if config.FeatureOptions.FeatureInstanceIPRefresh != nil && config.FeatureOptions.FeatureInstanceIPRefresh.Enabled {
+			instanceIPReconciler = newInstanceIPReconciler(config.FeatureOptions.FeatureInstanceIPRefresh, config.Cloud.File, config.Cloud.Project, config.Name, config.KubeClient, config.EventRecorder)
+		}
+		var instanceCreationDeferrer *instanceCreationDeferrer
+		if config.FeatureOptions.FeatureDeferredInstanceCreation != nil && config.FeatureOptions.FeatureDeferredInstanceCreation.Enabled {
+			instanceCreationDeferrer = newInstanceCreationDeferrer(config.FeatureOptions.FeatureDeferredInstanceCreation.Window, config.KubeClient)
+		}
+		var costEstimator *costEstimator
+		if feature := config.FeatureOptions.FeatureCostEstimation; feature != nil && feature.Enabled {
+			costEstimator = newCostEstimator(feature.Pricing, feature.AlertThreshold, config.EventRecorder, config.Metrics)
+		}
+		var shareExportAnnotations *shareExportAnnotations
+		if config.FeatureOptions.FeatureShareExportAnnotations != nil && config.FeatureOptions.FeatureShareExportAnnotations.Enabled {
+			shareExportAnnotations = newShareExportAnnotations(config.FeatureOptions.FeatureShareExportAnnotations.Allowed, config.KubeClient)
+		}
+		var replicaPromoter *replicaPromoter
+		if config.FeatureOptions.FeatureReplicaPromotion != nil && config.FeatureOptions.FeatureReplicaPromotion.Enabled {
+			replicaPromoter = newReplicaPromoter(config.Name, config.FeatureOptions.FeatureReplicaPromotion.PollPeriod, config.KubeClient, config.EventRecorder)
+		}
+		var backupPolicyController *backupPolicyController
+		if config.FeatureOptions.FeatureBackupPolicy != nil && config.FeatureOptions.FeatureBackupPolicy.Enabled {
+			backupPolicyController = newBackupPolicyController(config.Name, config.FeatureOptions.FeatureBackupPolicy.PollPeriod, config.KubeClient, config.DriverClient)
+		}
+		var restoreProgress *restoreProgressReporter
+		if config.FeatureOptions.FeatureRestoreProgress != nil && config.FeatureOptions.FeatureRestoreProgress.Enabled {
+			restoreProgress = newRestoreProgressReporter(config.FeatureOptions.FeatureRestoreProgress.Period, config.KubeClient, config.EventRecorder, config.Metrics)
+		}
+		var autoExpander *autoExpander
+		if feature := config.FeatureOptions.FeatureAutoExpansion; feature != nil && feature.Enabled {
+			autoExpander = newAutoExpander(config.Name, feature.PollPeriod, feature.Threshold, config.KubeClient, config.EventRecorder)
+		}
+		var firewall *nfsFirewall
+		if config.NFSFirewallRules {
+			firewall = newNFSFirewall(config.Cloud.Compute, config.Cloud.Project, config.NFSFirewallNodeCIDRs)
+		}
 		// Configure controller server
 		driver.cs = newControllerServer(&controllerServerConfig{
-			driver:            driver,
-			fileService:       config.Cloud.File,
-			cloud:             config.Cloud,
-			volumeLocks:       util.NewVolumeLocks(),
-			enableMultishare:  config.EnableMultishare,
-			reconciler:        config.Reconciler,
-			metricsManager:    config.Metrics,
-			ecfsDescription:   config.EcfsDescription,
-			isRegional:        config.IsRegional,
-			clusterName:       config.ClusterName,
-			features:          config.FeatureOptions,
-			extraVolumeLabels: config.ExtraVolumeLabels,
-			tagManager:        config.TagManager,
+			driver:                    driver,
+			fileService:               config.Cloud.File,
+			cloud:                     config.Cloud,
+			volumeLocks:               util.NewVolumeLocks(),
+			enableMultishare:          config.EnableMultishare,
+			listParallelism:           config.ListParallelism,
+			stuckOpThreshold:          config.StuckOpThreshold,
+			maxRegionalCapacityBytes:  config.MaxRegionalCapacityTB * util.Tb,
+			nfsFirewall:               firewall,
+			parameterDefaults:         config.ParameterDefaults,
+			opTimeouts:                config.OpTimeouts,
+			tierPolicy:                config.TierPolicy,
+			costEstimator:             costEstimator,
+			shareExportAnnotations:    shareExportAnnotations,
+			networkBackoff:            newNetworkBackoff(config.Metrics),
+			instancePools:             config.InstancePools,
+			reconciler:                config.Reconciler,
+			metricsManager:            config.Metrics,
+			ecfsDescription:           config.EcfsDescription,
+			isRegional:                config.IsRegional,
+			clusterName:               config.ClusterName,
+			clusterLocation:           config.ClusterLocation,
+			clusterUID:                config.ClusterUID,
+			sharedClusterGroup:        config.SharedClusterGroup,
+			features:                  config.FeatureOptions,
+			extraVolumeLabels:         config.ExtraVolumeLabels,
+			clearDeletionProtection:   config.ClearDeletionProtection,
+			volumeLocationAliases:     config.VolumeLocationAliases,
+			allowSoftMounts:           config.AllowSoftMounts,
+			verifyNodeExpansion:       config.VerifyNodeExpansion,
+			backupBeforeExpandTimeout: config.BackupBeforeExpandTimeout,
+			connectivityCheck:         newConnectivityCheck(config.ConnectivityCheckTimeout),
+			tagManager:                config.TagManager,
+			instanceEvents:            instanceEvents,
+			shareMigrator:             shareMigrator,
+			shareRebalancer:           shareRebalancer,
+			instanceLabelReconciler:   instanceLabelReconciler,
+			instanceIPReconciler:      instanceIPReconciler,
+			instanceCreationDeferrer:  instanceCreationDeferrer,
+			volumeRestorer:            volumeRestorer,
+			replicaPromoter:           replicaPromoter,
+			backupPolicyController:    backupPolicyController,
+			restoreProgress:           restoreProgress,
+			autoExpander:              autoExpander,
 		})
 	}
 
 	return driver, nil
 }
 
+// backupTiers are the tiers of the instances Filestore backs up.
+var backupTiers = []string{defaultTier, premiumTier, basicHDDTier, basicSSDTier, zonalTier, enterpriseTier}
+
+// controllerServiceCapabilities returns the controller service capabilities honored by the
+// deployment described by config, so that the sidecars don't issue RPCs it can't serve.
+// Instances of all the tiers, and multishare shares, are grown in place. Volumes are restored
+// from backups, not cloned from other volumes, and ListVolumes, GetCapacity, ListSnapshots and
+// ControllerGetVolume are not implemented.
+func controllerServiceCapabilities(config *GCFSDriverConfig) []csi.ControllerServiceCapability_RPC_Type {
+	caps := []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	}
+	if backupsEnabled(config) {
+		caps = append(caps, csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+	}
+	return caps
+}
+
+// backupsEnabled returns whether the tier policy of the deployment allows volumes of a tier
+// with backups. The multishare shares are enterprise volumes, backed up only if the
+// MultishareBackups feature is enabled, their snapshots fail otherwise.
+func backupsEnabled(config *GCFSDriverConfig) bool {
+	for _, tier := range backupTiers {
+		if config.TierPolicy.check(tier) == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (driver *GCFSDriver) addVolumeCapabilityAccessModes(vc []csi.VolumeCapability_AccessMode_Mode) error {
 	for _, c := range vc {
 		klog.Infof("Enabling volume access mode: %v", c.String())
@@ -321,15 +787,42 @@ func (driver *GCFSDriver) Run(endpoint string) {
 
 		klog.Infof("runcontroller %v", driver.config.RunController)
 		go run(context.TODO())
+
+		if cs := driver.cs.(*controllerServer); cs.hasLeaderLoops() {
+			runLeaderElected(controllerLeaderLockName, driver.config.KubeClient, driver.config.FeatureOptions.FeatureStateful, func(ctx context.Context) {
+				cs.runLeaderLoops(ctx.Done())
+				<-ctx.Done()
+			})
+		}
 	}
 
 	// Start the nonblocking GRPC.
-	s := NewNonBlockingGRPCServer()
+	s := NewNonBlockingGRPCServer(driver.config.ServerOptions)
 	s.Start(endpoint, driver.ids, driver.cs, driver.ns)
+	go func() {
+		// Drain in-flight RPCs on SIGTERM, so that they are not cut off when the pod is deleted.
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGTERM)
+		<-c
+		klog.Infof("Received SIGTERM, draining in-flight RPCs")
+		s.Stop()
+	}()
 	if driver.config.RunNode && driver.config.FeatureOptions.FeatureLockRelease.Enabled {
 		// Start the lock release controller on node driver.
 		driver.ns.(*nodeServer).lockReleaseController.Run(context.Background())
 	}
+	if driver.config.RunNode && driver.ns.(*nodeServer).mountHealthReporter != nil {
+		go driver.ns.(*nodeServer).mountHealthReporter.Run(make(chan struct{}))
+	}
+	if driver.config.RunNode && driver.ns.(*nodeServer).tierAnalyzer != nil {
+		go driver.ns.(*nodeServer).tierAnalyzer.Run(make(chan struct{}))
+	}
+	if driver.config.RunNode && driver.ns.(*nodeServer).nfsStatsCollector != nil {
+		go driver.ns.(*nodeServer).nfsStatsCollector.Run(make(chan struct{}))
+	}
+	if driver.config.RunNode && driver.ns.(*nodeServer).capacityWatermark != nil {
+		go driver.ns.(*nodeServer).capacityWatermark.Run(make(chan struct{}))
+	}
 	s.Wait()
 }
 
@@ -405,31 +898,45 @@ func runMultishareReconciler(driverConfig *GCFSDriverConfig, recon *MultishareRe
 	if !statefulConfig.LeaderElection {
 		go run(context.TODO())
 	} else {
-		go func() {
-			lockName := "filestore-stateful-leader"
-			config, err := util.BuildConfig(driverConfig.FeatureOptions.FeatureStateful.KubeConfig)
-			if err != nil {
-				klog.Fatal(err.Error())
-			}
-
-			leClient, err := kubernetes.NewForConfig(config)
-			if err != nil {
-				klog.Fatalf("Failed to create leaderelection client: %v", err)
-			}
-			le := leaderelection.NewLeaderElection(leClient, lockName, run)
-			if statefulConfig.LeaderElectionNamespace != "" {
-				le.WithNamespace(statefulConfig.LeaderElectionNamespace)
-			}
-			le.WithLeaseDuration(statefulConfig.LeaderElectionLeaseDuration)
-			le.WithRenewDeadline(statefulConfig.LeaderElectionRenewDeadline)
-			le.WithRetryPeriod(statefulConfig.LeaderElectionRetryPeriod)
-			if err := le.Run(); err != nil {
-				klog.Fatalf("Failed to initialize leader election: %v", err)
-			}
-		}()
+		config, err := util.BuildConfig(driverConfig.FeatureOptions.FeatureStateful.KubeConfig)
+		if err != nil {
+			klog.Fatal(err.Error())
+		}
+
+		leClient, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			klog.Fatalf("Failed to create leaderelection client: %v", err)
+		}
+		runLeaderElected("filestore-stateful-leader", leClient, statefulConfig, run)
 	}
 }
 
+// controllerLeaderLockName is the name of the lease of the controller replica running the loops
+// updating the Filestore resources, the PVs and the PVCs.
+const controllerLeaderLockName = "filestore-controller-leader"
+
+// runLeaderElected runs run in the background while holding the lease lockName, with the leader
+// election settings of the stateful feature, i.e. of the --leader-election flags. Without leader
+// election, run is run right away. The driver exits when it loses the lease.
+func runLeaderElected(lockName string, client kubernetes.Interface, statefulConfig *FeatureStateful, run func(context.Context)) {
+	if statefulConfig == nil || !statefulConfig.LeaderElection {
+		go run(context.TODO())
+		return
+	}
+	go func() {
+		le := leaderelection.NewLeaderElection(client, lockName, run)
+		if statefulConfig.LeaderElectionNamespace != "" {
+			le.WithNamespace(statefulConfig.LeaderElectionNamespace)
+		}
+		le.WithLeaseDuration(statefulConfig.LeaderElectionLeaseDuration)
+		le.WithRenewDeadline(statefulConfig.LeaderElectionRenewDeadline)
+		le.WithRetryPeriod(statefulConfig.LeaderElectionRetryPeriod)
+		if err := le.Run(); err != nil {
+			klog.Fatalf("Failed to initialize leader election: %v", err)
+		}
+	}()
+}
+
 // Checks that the ShareInfo v1 CRDs exist.
 func ensureCustomResourceDefinitionsExist(client *clientset.Clientset) error {
 	condition := func() (bool, error) {
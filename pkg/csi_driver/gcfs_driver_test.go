@@ -17,13 +17,18 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
 )
 
-func initTestDriver(t *testing.T) *GCFSDriver {
+func initTestDriver(t testing.TB) *GCFSDriver {
 	c, err := cloud.NewFakeCloud()
 	if err != nil {
 		t.Fatalf("Failed to init cloud")
@@ -251,3 +256,92 @@ func TestDriverValidateVolumeCapabilities(t *testing.T) {
 		}
 	}
 }
+
+func TestNewGCFSDriverSharedKubeClient(t *testing.T) {
+	c, err := cloud.NewFakeCloud()
+	if err != nil {
+		t.Fatalf("Failed to init cloud")
+	}
+	config := &GCFSDriverConfig{
+		Name:          "test-driver",
+		Version:       "test-version",
+		RunController: true,
+		Cloud:         c,
+		FeatureOptions: &GCFSDriverFeatureOptions{
+			FeatureInstanceEvents: &FeatureInstanceEvents{Enabled: true, PollPeriod: time.Minute},
+			FeatureAutoExpansion:  &FeatureAutoExpansion{Enabled: true, PollPeriod: time.Minute, Threshold: 80},
+		},
+	}
+	if _, err := NewGCFSDriver(config); err == nil {
+		t.Fatalf("expected error without a kubernetes client")
+	}
+
+	config.KubeClient = fake.NewSimpleClientset()
+	config.EventRecorder = record.NewFakeRecorder(10)
+	driver, err := NewGCFSDriver(config)
+	if err != nil {
+		t.Fatalf("failed to init driver: %v", err)
+	}
+	cs := driver.cs.(*controllerServer)
+	if cs.config.instanceEvents.kubeClient != config.KubeClient || cs.config.autoExpander.kubeClient != config.KubeClient {
+		t.Errorf("expected the features to share the kubernetes client of the driver")
+	}
+}
+
+func TestRunLeaderElected(t *testing.T) {
+	c, err := cloud.NewFakeCloud()
+	if err != nil {
+		t.Fatalf("Failed to init cloud")
+	}
+	config := &GCFSDriverConfig{
+		Name:          "test-driver",
+		Version:       "test-version",
+		RunController: true,
+		Cloud:         c,
+		KubeClient:    fake.NewSimpleClientset(),
+		EventRecorder: record.NewFakeRecorder(10),
+		FeatureOptions: &GCFSDriverFeatureOptions{
+			FeatureInstanceEvents: &FeatureInstanceEvents{Enabled: true, PollPeriod: time.Minute},
+		},
+	}
+	driver, err := NewGCFSDriver(config)
+	if err != nil {
+		t.Fatalf("failed to init driver: %v", err)
+	}
+	if driver.cs.(*controllerServer).hasLeaderLoops() {
+		t.Errorf("expected no leader loops with only the instance events")
+	}
+	config.FeatureOptions.FeatureAutoExpansion = &FeatureAutoExpansion{Enabled: true, PollPeriod: time.Minute, Threshold: 80}
+	driver, err = NewGCFSDriver(config)
+	if err != nil {
+		t.Fatalf("failed to init driver: %v", err)
+	}
+	if !driver.cs.(*controllerServer).hasLeaderLoops() {
+		t.Errorf("expected the auto expansion to run on the leader")
+	}
+
+	for _, statefulConfig := range []*FeatureStateful{
+		nil,
+		{},
+		{
+			LeaderElection:              true,
+			LeaderElectionNamespace:     "default",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			LeaderElectionRetryPeriod:   2 * time.Second,
+		},
+	} {
+		started := make(chan struct{})
+		runLeaderElected(controllerLeaderLockName, config.KubeClient, statefulConfig, func(ctx context.Context) {
+			close(started)
+		})
+		select {
+		case <-started:
+		case <-time.After(10 * time.Second):
+			t.Errorf("run not started with %+v", statefulConfig)
+		}
+	}
+	if _, err := config.KubeClient.CoordinationV1().Leases("default").Get(context.Background(), controllerLeaderLockName, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the lease of the controller: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// paramHostnameTemplate is the StorageClass parameter setting the DNS name the volumes are
+	// mounted by, e.g. the private Cloud DNS record of the Private Service Connect endpoint of
+	// their instance. Its placeholders are replaced by the project, location and name of the
+	// instance. attrHostname is the volume attribute passing the name to the node driver, also
+	// set on pre-provisioned PVs.
+	paramHostnameTemplate = "hostname-template"
+	attrHostname          = "hostname"
+
+	// hostnameLookupTimeout bounds the resolution of the hostname of a volume before it is
+	// mounted by IP.
+	hostnameLookupTimeout = 5 * time.Second
+)
+
+// hostnamePlaceholders are the placeholders of the hostname template.
+var hostnamePlaceholders = []string{"{project}", "{location}", "{instance}"}
+
+// lookupHost resolves a hostname, stubbed by tests.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// renderHostname returns the hostname of an instance from the hostname template.
+func renderHostname(template, project, location, name string) (string, error) {
+	hostname := strings.NewReplacer(
+		hostnamePlaceholders[0], project,
+		hostnamePlaceholders[1], location,
+		hostnamePlaceholders[2], name,
+	).Replace(strings.ToLower(template))
+	if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+		return "", fmt.Errorf("invalid %v %q, the hostname %q must be a DNS name with placeholders %v: %v", paramHostnameTemplate, template, hostname, hostnamePlaceholders, strings.Join(errs, ", "))
+	}
+	return hostname, nil
+}
+
+// hostnameTemplate returns the hostname template of the CreateVolume parameters, if any,
+// validated before the volume is provisioned.
+func hostnameTemplate(params map[string]string) (string, error) {
+	for k, v := range params {
+		if strings.ToLower(k) != paramHostnameTemplate {
+			continue
+		}
+		if _, err := renderHostname(v, "project", "location", "instance"); err != nil {
+			return "", err
+		}
+		return v, nil
+	}
+	return "", nil
+}
+
+// mountHost returns the host the node driver mounts a volume from: its hostname if set and
+// resolvable, otherwise ip. A hostname that can't be resolved, e.g. whose DNS record is not
+// created yet, is logged and the volume mounted by IP.
+func mountHost(ctx context.Context, volumeID, hostname, ip string) string {
+	if hostname == "" {
+		return ip
+	}
+	ctx, cancel := context.WithTimeout(ctx, hostnameLookupTimeout)
+	defer cancel()
+	if _, err := lookupHost(ctx, hostname); err != nil {
+		klog.Warningf("Failed to resolve hostname %s of volume %s, mounting by IP %s: %v", hostname, volumeID, ip, err)
+		return ip
+	}
+	return hostname
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+)
+
+func TestRenderHostname(t *testing.T) {
+	cases := []struct {
+		template  string
+		expected  string
+		expectErr bool
+	}{
+		{template: "{instance}.{location}.filestore.internal", expected: "pvc-a.us-central1.filestore.internal"},
+		{template: "Filestore-{Instance}.example.com", expected: "filestore-pvc-a.example.com"},
+		{template: "nfs.example.com", expected: "nfs.example.com"},
+		{template: "{share}.example.com", expectErr: true},
+		{template: "{instance}_{location}.example.com", expectErr: true},
+	}
+	for _, tc := range cases {
+		hostname, err := renderHostname(tc.template, testProject, testRegion, "pvc-a")
+		if gotErr := err != nil; gotErr != tc.expectErr {
+			t.Errorf("%s: got error %v, expected error %v", tc.template, err, tc.expectErr)
+			continue
+		}
+		if hostname != tc.expected {
+			t.Errorf("%s: got hostname %q, expected %q", tc.template, hostname, tc.expected)
+		}
+	}
+}
+
+func TestCreateVolumeHostname(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	req := &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		Parameters:         map[string]string{paramHostnameTemplate: "{share}.example.com"},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	}
+
+	if _, err := cs.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, expected code %v for an invalid template", err, codes.InvalidArgument)
+	}
+	req.Parameters[paramHostnameTemplate] = "{instance}.{location}.example.com"
+	resp, err := cs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	instance, _, err := instanceOfVolume(resp.Volume.VolumeId, testProject)
+	if err != nil {
+		t.Fatalf("unexpected volume ID %s: %v", resp.Volume.VolumeId, err)
+	}
+	expected := instance.Name + "." + instance.Location + ".example.com"
+	if hostname := resp.Volume.VolumeContext[attrHostname]; hostname != expected {
+		t.Errorf("got hostname %q, expected %q", hostname, expected)
+	}
+}
+
+func TestNodeStageVolumeHostname(t *testing.T) {
+	defer func(f func(context.Context, string) ([]string, error)) { lookupHost = f }(lookupHost)
+	resolvable := map[string]bool{"nfs.example.com": true}
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if resolvable[host] {
+			return []string{"10.0.0.2"}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	cases := []struct {
+		name           string
+		hostname       string
+		expectedDevice string
+		expectErr      bool
+	}{
+		{name: "resolvable hostname", hostname: "nfs.example.com", expectedDevice: "nfs.example.com:/vol1"},
+		{name: "unresolvable hostname", hostname: "missing.example.com", expectedDevice: "1.1.1.1:/vol1"},
+		{name: "invalid hostname", hostname: "nfs_example.com", expectErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testEnv := initTestNodeServer(t)
+			stagingPath := t.TempDir()
+			_, err := testEnv.ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+				VolumeContext:     map[string]string{attrIP: "1.1.1.1", attrVolume: "vol1", attrHostname: tc.hostname},
+			})
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if tc.expectErr {
+				return
+			}
+			validateMountPoint(t, tc.name, testEnv.fm, &mount.MountPoint{
+				Device: tc.expectedDevice,
+				Path:   stagingPath,
+				Type:   "nfs",
+				Opts:   []string{},
+			})
+		})
+	}
+}
@@ -17,10 +17,20 @@ limitations under the License.
 package driver
 
 import (
+	"strings"
+
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/net/context"
 )
 
+// Keys of the GetPluginInfo manifest, reporting the build and configuration of the driver.
+const (
+	manifestKeyVersion      = "version"
+	manifestKeyCommit       = "commit"
+	manifestKeyMode         = "mode"
+	manifestKeyFeatureGates = "feature-gates"
+)
+
 type identityServer struct {
 	driver *GCFSDriver
 }
@@ -33,9 +43,31 @@ func (s *identityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginIn
 	return &csi.GetPluginInfoResponse{
 		Name:          s.driver.config.Name,
 		VendorVersion: s.driver.config.Version,
+		Manifest:      pluginManifest(s.driver.config),
 	}, nil
 }
 
+// pluginManifest returns the manifest of the driver: its version and commit, the services it
+// runs as a comma separated "controller,node" mode, and the comma separated enabled feature gates.
+func pluginManifest(config *GCFSDriverConfig) map[string]string {
+	var mode []string
+	if config.RunController {
+		mode = append(mode, "controller")
+	}
+	if config.RunNode {
+		mode = append(mode, "node")
+	}
+	manifest := map[string]string{
+		manifestKeyVersion:      config.Version,
+		manifestKeyMode:         strings.Join(mode, ","),
+		manifestKeyFeatureGates: strings.Join(config.FeatureGates, ","),
+	}
+	if config.Commit != "" {
+		manifest[manifestKeyCommit] = config.Commit
+	}
+	return manifest
+}
+
 func (s *identityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
 	return &csi.GetPluginCapabilitiesResponse{
 		Capabilities: []*csi.PluginCapability{
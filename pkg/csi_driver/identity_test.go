@@ -17,6 +17,7 @@ limitations under the License.
 package driver
 
 import (
+	"reflect"
 	"testing"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
@@ -51,6 +52,34 @@ func TestGetPluginInfo(t *testing.T) {
 	if resp.VendorVersion != testVersion {
 		t.Errorf("got driver version %v", resp.Name)
 	}
+
+	expectedManifest := map[string]string{
+		manifestKeyVersion:      testVersion,
+		manifestKeyMode:         "node",
+		manifestKeyFeatureGates: "",
+	}
+	if !reflect.DeepEqual(resp.Manifest, expectedManifest) {
+		t.Errorf("got manifest %v, expected %v", resp.Manifest, expectedManifest)
+	}
+}
+
+func TestPluginManifest(t *testing.T) {
+	config := &GCFSDriverConfig{
+		Version:       testVersion,
+		Commit:        "0123abc",
+		RunController: true,
+		RunNode:       true,
+		FeatureGates:  []string{"LockRelease", "Multishare"},
+	}
+	expected := map[string]string{
+		manifestKeyVersion:      testVersion,
+		manifestKeyCommit:       "0123abc",
+		manifestKeyMode:         "controller,node",
+		manifestKeyFeatureGates: "LockRelease,Multishare",
+	}
+	if manifest := pluginManifest(config); !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("got manifest %v, expected %v", manifest, expected)
+	}
 }
 
 func TestGetPluginCapabilities(t *testing.T) {
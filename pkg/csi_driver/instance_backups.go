@@ -0,0 +1,107 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const (
+	// paramBackupsDeletionPolicy is the StorageClass parameter setting whether DeleteVolume
+	// deletes the basic instances whose backups created by the driver, i.e. the backups of the
+	// VolumeSnapshotContents of the volume, still exist.
+	paramBackupsDeletionPolicy = "backups-deletion-policy"
+
+	// backupsDeletionPolicyBlock refuses to delete the instances until their backups are deleted.
+	backupsDeletionPolicyBlock = "block"
+	// backupsDeletionPolicyProceed deletes the instances, their backups are kept and remain
+	// restorable. It is the default policy.
+	backupsDeletionPolicyProceed = "proceed"
+
+	// TagKeyBackupsDeletionPolicy is set on the basic instances created with the block
+	// backups-deletion-policy parameter.
+	TagKeyBackupsDeletionPolicy = "storage_gke_io_backups-deletion-policy"
+
+	// maxReportedBackups bounds the backups named in the errors and logs of DeleteVolume.
+	maxReportedBackups = 5
+)
+
+// parseBackupsDeletionPolicy validates the value of the backups-deletion-policy parameter.
+func parseBackupsDeletionPolicy(value string) (string, error) {
+	policy := strings.ToLower(value)
+	if policy != backupsDeletionPolicyBlock && policy != backupsDeletionPolicyProceed {
+		return "", fmt.Errorf("invalid value %q for parameter %q, expected %q or %q", value, paramBackupsDeletionPolicy, backupsDeletionPolicyBlock, backupsDeletionPolicyProceed)
+	}
+	return policy, nil
+}
+
+// instanceBackupsError is returned by deleteVolume for the volumes whose instance has backups
+// created by the driver and the block backups-deletion-policy.
+type instanceBackupsError struct {
+	volumeID string
+	instance string
+	backups  []string
+}
+
+func (e *instanceBackupsError) Error() string {
+	return fmt.Sprintf("volume %s has %d backups created by the driver, e.g. VolumeSnapshots, %s: delete them, or remove the label %s from instance %s, to delete it", e.volumeID, len(e.backups), reportedBackups(e.backups), TagKeyBackupsDeletionPolicy, e.instance)
+}
+
+// checkInstanceBackups returns an instanceBackupsError if the instance of a volume being deleted
+// has backups created by the driver and the block backups-deletion-policy. Otherwise, the
+// backups kept after the deletion are logged.
+func (s *controllerServer) checkInstanceBackups(ctx context.Context, fileService file.Service, volumeID string, filer *file.ServiceInstance) error {
+	block := filer.Labels[TagKeyBackupsDeletionPolicy] == backupsDeletionPolicyBlock
+	backups, err := fileService.ListBackups(ctx, filer)
+	if err != nil {
+		if block {
+			return status.Errorf(codes.Unavailable, "failed to list the backups of instance %s of volume %s: %v", filer.Name, volumeID, err)
+		}
+		klog.Warningf("Failed to list the backups of instance %s of volume %s: %v", filer.Name, volumeID, err)
+		return nil
+	}
+	var names []string
+	for _, backup := range backups {
+		if backup.Backup != nil && CreatedByDriver(backup.Backup.Labels, s.config.driver.config.Name) {
+			names = append(names, backup.Backup.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	if block {
+		return &instanceBackupsError{volumeID: volumeID, instance: filer.Name, backups: names}
+	}
+	klog.Infof("Deleting instance %s of volume %s, its %d backups created by the driver are kept and remain restorable: %s", filer.Name, volumeID, len(names), reportedBackups(names))
+	return nil
+}
+
+func reportedBackups(names []string) string {
+	if len(names) > maxReportedBackups {
+		return fmt.Sprintf("%s and %d more", strings.Join(names[:maxReportedBackups], ", "), len(names)-maxReportedBackups)
+	}
+	return strings.Join(names, ", ")
+}
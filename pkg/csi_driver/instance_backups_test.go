@@ -0,0 +1,134 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestDeleteVolumeBackupsDeletionPolicy(t *testing.T) {
+	cases := []struct {
+		name          string
+		params        map[string]string
+		backupLabels  func(driverName string) map[string]string
+		expectedCode  codes.Code
+		expectedLabel string
+	}{
+		{
+			name:   "block with backups of the driver",
+			params: map[string]string{"Backups-Deletion-Policy": "Block"},
+			backupLabels: func(driverName string) map[string]string {
+				return map[string]string{tagKeyCreatedBy: strings.ReplaceAll(driverName, ".", "_")}
+			},
+			expectedCode:  codes.FailedPrecondition,
+			expectedLabel: backupsDeletionPolicyBlock,
+		},
+		{
+			name:          "block with backups of the users",
+			params:        map[string]string{paramBackupsDeletionPolicy: backupsDeletionPolicyBlock},
+			backupLabels:  func(driverName string) map[string]string { return map[string]string{"team": "storage"} },
+			expectedCode:  codes.OK,
+			expectedLabel: backupsDeletionPolicyBlock,
+		},
+		{
+			name:   "proceed with backups of the driver",
+			params: map[string]string{paramBackupsDeletionPolicy: backupsDeletionPolicyProceed},
+			backupLabels: func(driverName string) map[string]string {
+				return map[string]string{tagKeyCreatedBy: strings.ReplaceAll(driverName, ".", "_")}
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name:   "default policy",
+			params: map[string]string{},
+			backupLabels: func(driverName string) map[string]string {
+				return map[string]string{tagKeyCreatedBy: strings.ReplaceAll(driverName, ".", "_")}
+			},
+			expectedCode: codes.OK,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := initTestController(t).(*controllerServer)
+			cs.config.tagManager.(*cloud.FakeTagServiceManager).
+				On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+				Return(nil)
+			ctx := context.Background()
+			if _, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+				Name:               testCSIVolume,
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+				Parameters:         tc.params,
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			instance, err := cs.config.fileService.GetInstance(ctx, &file.ServiceInstance{Name: testCSIVolume})
+			if err != nil {
+				t.Fatalf("failed to get instance: %v", err)
+			}
+			if instance.Labels[TagKeyBackupsDeletionPolicy] != tc.expectedLabel {
+				t.Errorf("got instance labels %v, expected label %s=%q", instance.Labels, TagKeyBackupsDeletionPolicy, tc.expectedLabel)
+			}
+			backupURI := "projects/test-project/locations/us-central1/backups/snapshot-1"
+			if _, err := cs.config.fileService.CreateBackup(ctx, &file.BackupInfo{
+				Project:            testProject,
+				Location:           testRegion,
+				SourceInstanceName: testCSIVolume,
+				SourceShare:        "vol1",
+				Name:               "snapshot-1",
+				BackupURI:          backupURI,
+				SourceVolumeId:     testVolumeID,
+				Labels:             tc.backupLabels(cs.config.driver.config.Name),
+			}); err != nil {
+				t.Fatalf("failed to create backup: %v", err)
+			}
+
+			_, err = cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: testVolumeID})
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("got error %v, expected code %v", err, tc.expectedCode)
+			}
+			if err == nil {
+				return
+			}
+			if !strings.Contains(err.Error(), backupURI) {
+				t.Errorf("got error %v, expected it to name backup %s", err, backupURI)
+			}
+			// The deletion proceeds once the backups are deleted.
+			if err := cs.config.fileService.DeleteBackup(ctx, backupURI); err != nil {
+				t.Fatalf("failed to delete backup: %v", err)
+			}
+			if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: testVolumeID}); err != nil {
+				t.Errorf("unexpected error after the backups are deleted: %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateNewFileInstanceBackupsDeletionPolicy(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	if _, err := cs.generateNewFileInstance(testCSIVolume, 1<<40, map[string]string{paramBackupsDeletionPolicy: "keep"}, nil); err == nil {
+		t.Errorf("expected error for an invalid %s parameter", paramBackupsDeletionPolicy)
+	}
+}
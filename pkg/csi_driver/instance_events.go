@@ -0,0 +1,201 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const (
+	// Reasons of the events published on the PVs and PVCs of the Filestore instances.
+	eventReasonInstanceUnavailable = "FilestoreInstanceUnavailable"
+	eventReasonInstanceReady       = "FilestoreInstanceReady"
+	// Reason of the events published on the PVCs whose share can't be placed on an instance.
+	eventReasonInstanceExcluded = "FilestoreInstanceExcluded"
+
+	instanceStateReady = "READY"
+)
+
+// instanceUnavailableStates are the states of a Filestore instance in which its shares may
+// serve I/O with interruptions, or not at all, e.g. while the instance is under maintenance.
+var instanceUnavailableStates = map[string]string{
+	"REPAIRING":  "is being repaired, I/O may be briefly interrupted",
+	"SUSPENDING": "is being suspended, I/O will be interrupted",
+	"SUSPENDED":  "is suspended, I/O is interrupted",
+	"ERROR":      "is in error, I/O may be interrupted",
+}
+
+// instanceEventsReporter periodically checks the state of the Filestore instances backing
+// the PVs of the driver, and publishes events on the PVs and their PVCs when an instance
+// becomes unavailable, e.g. during maintenance, and when it is ready again.
+type instanceEventsReporter struct {
+	fileService file.Service
+	project     string
+	driverName  string
+	period      time.Duration
+	kubeClient  kubernetes.Interface
+	recorder    record.EventRecorder
+
+	// states maps instance URI to the state of the instance at the last check.
+	states map[string]string
+}
+
+func newInstanceEventsReporter(fileService file.Service, project, driverName string, period time.Duration, kubeClient kubernetes.Interface, recorder record.EventRecorder) *instanceEventsReporter {
+	return &instanceEventsReporter{
+		fileService: fileService,
+		project:     project,
+		driverName:  driverName,
+		period:      period,
+		kubeClient:  kubeClient,
+		recorder:    recorder,
+		states:      make(map[string]string),
+	}
+}
+
+// Run checks the instances every period until stopCh is closed.
+func (r *instanceEventsReporter) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Filestore instance events reporter with poll period %v", r.period)
+	wait.Until(func() {
+		if err := r.checkAll(context.Background()); err != nil {
+			klog.Errorf("Failed to check the Filestore instances of the PVs: %v", err)
+		}
+	}, r.period, stopCh)
+}
+
+func (r *instanceEventsReporter) checkAll(ctx context.Context) error {
+	pvs, err := r.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	// Group the PVs by instance, so that each instance is fetched once per check.
+	type instancePVs struct {
+		instance   *file.MultishareInstance
+		multishare bool
+		pvs        []*v1.PersistentVolume
+	}
+	instances := make(map[string]*instancePVs)
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != r.driverName {
+			continue
+		}
+		instance, multishare, err := instanceOfVolume(pv.Spec.CSI.VolumeHandle, r.project)
+		if err != nil {
+			klog.V(4).Infof("Skipping PV %s: %v", pv.Name, err)
+			continue
+		}
+		uri, err := file.GenerateMultishareInstanceURI(instance)
+		if err != nil {
+			klog.V(4).Infof("Skipping PV %s: %v", pv.Name, err)
+			continue
+		}
+		if _, ok := instances[uri]; !ok {
+			instances[uri] = &instancePVs{instance: instance, multishare: multishare}
+		}
+		instances[uri].pvs = append(instances[uri].pvs, pv)
+	}
+
+	states := make(map[string]string, len(instances))
+	for uri, i := range instances {
+		state, err := r.getInstanceState(ctx, i.instance, i.multishare)
+		if err != nil {
+			klog.Warningf("Failed to get Filestore instance %s: %v", uri, err)
+			// Keep the last known state, so that no event is repeated once the instance can be fetched again.
+			if last, ok := r.states[uri]; ok {
+				states[uri] = last
+			}
+			continue
+		}
+		states[uri] = state
+		r.report(uri, r.states[uri], state, i.pvs)
+	}
+	// Forget the instances of the deleted PVs.
+	r.states = states
+	return nil
+}
+
+// report publishes an event on the PVs of an instance and their PVCs when the instance
+// becomes unavailable, or ready again after being reported unavailable.
+func (r *instanceEventsReporter) report(uri, lastState, state string, pvs []*v1.PersistentVolume) {
+	if state == lastState {
+		return
+	}
+	var eventType, reason, message string
+	if description, ok := instanceUnavailableStates[state]; ok {
+		eventType, reason = v1.EventTypeWarning, eventReasonInstanceUnavailable
+		message = fmt.Sprintf("Filestore instance %s %s (state %s)", uri, description, state)
+	} else if _, ok := instanceUnavailableStates[lastState]; ok && state == instanceStateReady {
+		eventType, reason = v1.EventTypeNormal, eventReasonInstanceReady
+		message = fmt.Sprintf("Filestore instance %s is ready", uri)
+	} else {
+		return
+	}
+	klog.Infof("%s: %s", reason, message)
+	for _, pv := range pvs {
+		r.recorder.Event(pv, eventType, reason, message)
+		if pv.Spec.ClaimRef != nil {
+			claimRef := pv.Spec.ClaimRef.DeepCopy()
+			if claimRef.Kind == "" {
+				claimRef.Kind = "PersistentVolumeClaim"
+			}
+			r.recorder.Event(claimRef, eventType, reason, message)
+		}
+	}
+}
+
+// instanceOfVolume returns the instance backing a volume, and whether it is a multishare instance.
+// The project of the instance mode volumes, absent from their ID, is the given one.
+func instanceOfVolume(volumeID, project string) (*file.MultishareInstance, bool, error) {
+	if isMultishareVolId(volumeID) {
+		_, project, location, name, _, err := parseMultishareVolId(volumeID)
+		if err != nil {
+			return nil, false, err
+		}
+		return &file.MultishareInstance{Project: project, Location: location, Name: name}, true, nil
+	}
+	filer, _, err := getFileInstanceFromID(volumeID)
+	if err != nil {
+		return nil, false, err
+	}
+	return &file.MultishareInstance{Project: project, Location: filer.Location, Name: filer.Name}, false, nil
+}
+
+func (r *instanceEventsReporter) getInstanceState(ctx context.Context, instance *file.MultishareInstance, multishare bool) (string, error) {
+	if multishare {
+		i, err := r.fileService.GetMultishareInstance(ctx, instance)
+		if err != nil {
+			return "", err
+		}
+		return i.State, nil
+	}
+	i, err := r.fileService.GetInstance(ctx, &file.ServiceInstance{Project: instance.Project, Location: instance.Location, Name: instance.Name})
+	if err != nil {
+		return "", err
+	}
+	return i.State, nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			sort.Strings(events)
+			return events
+		}
+	}
+}
+
+func TestInstanceEventsReporter(t *testing.T) {
+	multishareInstance := &file.MultishareInstance{
+		Project:  testProject,
+		Location: testRegion,
+		Name:     "test-multishare-instance",
+		State:    "READY",
+	}
+	fileService, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{multishareInstance}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	instance, err := fileService.CreateInstance(context.Background(), &file.ServiceInstance{
+		Project:  testProject,
+		Location: testZone,
+		Name:     "test-instance",
+		Volume:   file.Volume{Name: "vol1"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+
+	kubeClient := fake.NewSimpleClientset(
+		testPV("pv-instance", testDriverName, modeInstance+"/"+testZone+"/test-instance/vol1", "pvc-instance"),
+		testPV("pv-share-1", testDriverName, modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/test-multishare-instance/share1", "pvc-share-1"),
+		testPV("pv-share-2", testDriverName, modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/test-multishare-instance/share2", ""),
+		testPV("pv-other-driver", "other.csi.driver", modeInstance+"/"+testZone+"/test-instance/vol1", "pvc-other"),
+	)
+	recorder := record.NewFakeRecorder(100)
+	r := newInstanceEventsReporter(fileService, testProject, testDriverName, time.Minute, kubeClient, recorder)
+
+	multishareURI := "projects/test-project/locations/us-central1/instances/test-multishare-instance"
+	instanceURI := "projects/test-project/locations/us-central1-c/instances/test-instance"
+	tests := []struct {
+		name                    string
+		multishareInstanceState string
+		instanceState           string
+		expectedEvents          []string
+	}{
+		{
+			name:                    "ready instances",
+			multishareInstanceState: "READY",
+			instanceState:           "READY",
+		},
+		{
+			name:                    "multishare instance repairing",
+			multishareInstanceState: "REPAIRING",
+			instanceState:           "READY",
+			expectedEvents: []string{
+				"Warning FilestoreInstanceUnavailable Filestore instance " + multishareURI + " is being repaired, I/O may be briefly interrupted (state REPAIRING)",
+				"Warning FilestoreInstanceUnavailable Filestore instance " + multishareURI + " is being repaired, I/O may be briefly interrupted (state REPAIRING)",
+				"Warning FilestoreInstanceUnavailable Filestore instance " + multishareURI + " is being repaired, I/O may be briefly interrupted (state REPAIRING)",
+			},
+		},
+		{
+			name:                    "multishare instance still repairing, instance suspended",
+			multishareInstanceState: "REPAIRING",
+			instanceState:           "SUSPENDED",
+			expectedEvents: []string{
+				"Warning FilestoreInstanceUnavailable Filestore instance " + instanceURI + " is suspended, I/O is interrupted (state SUSPENDED)",
+				"Warning FilestoreInstanceUnavailable Filestore instance " + instanceURI + " is suspended, I/O is interrupted (state SUSPENDED)",
+			},
+		},
+		{
+			name:                    "multishare instance ready again",
+			multishareInstanceState: "READY",
+			instanceState:           "SUSPENDED",
+			expectedEvents: []string{
+				"Normal FilestoreInstanceReady Filestore instance " + multishareURI + " is ready",
+				"Normal FilestoreInstanceReady Filestore instance " + multishareURI + " is ready",
+				"Normal FilestoreInstanceReady Filestore instance " + multishareURI + " is ready",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			multishareInstance.State = tc.multishareInstanceState
+			instance.State = tc.instanceState
+			if err := r.checkAll(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			events := drainEvents(recorder)
+			if !reflect.DeepEqual(events, tc.expectedEvents) {
+				t.Errorf("got events %v, expected %v", events, tc.expectedEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// The operations serialized on an instance.
+	intentExpand = "expand"
+	intentBackup = "backup"
+
+	// annotationOperationIntent records the intent of an instance in stateful mode on the
+	// InstanceInfo of the instance, as <operation>,<expiry>, so that it survives the restarts of
+	// the controller.
+	annotationOperationIntent = "multishare.filestore.csi.storage.gke.io/operation-intent"
+
+	// pendingIntentTTL bounds how long the intent of an operation which outlived its request is
+	// kept, should the request not be retried.
+	pendingIntentTTL = time.Hour
+
+	intentInstanceURIFmt = "projects/%s/locations/%s/instances/%s"
+)
+
+// instanceIntent is the operation registered on an instance by the requests of its volumes.
+type instanceIntent struct {
+	op string
+	// holders maps the volume IDs holding the intent to the expiry of their hold, zero while
+	// their request runs.
+	holders map[string]time.Time
+}
+
+// instanceIntents serializes the expansions and the backups of the volumes of an instance,
+// which the Filestore API otherwise rejects with an opaque FailedPrecondition while another
+// operation runs on the instance. A request registers the intent of its operation on the
+// instance, and fails with Aborted while the intent of the other operation is registered, to
+// be retried by the sidecars. Several requests may hold the intent of the same operation, e.g.
+// the backups of the shares of a multishare instance. The intent of an operation which keeps
+// running after its request, e.g. on the timeout of the wait, is held until the retry of the
+// request or pendingIntentTTL.
+//
+// In stateful mode, the intents are also recorded on the InstanceInfo of the instance, if any.
+type instanceIntents struct {
+	clientset clientset.Interface
+	now       func() time.Time
+
+	mutex sync.Mutex
+	// intents maps the instance URI to its intent.
+	intents map[string]*instanceIntent
+}
+
+func newInstanceIntents(clientset clientset.Interface) *instanceIntents {
+	return &instanceIntents{
+		clientset: clientset,
+		now:       time.Now,
+		intents:   make(map[string]*instanceIntent),
+	}
+}
+
+func intentInstanceURI(project, location, name string) string {
+	return fmt.Sprintf(intentInstanceURIFmt, project, location, name)
+}
+
+// acquire registers the intent of an operation of a volume on its instance. It returns
+// Aborted if the intent of another operation is registered on the instance. The returned
+// release is called with whether the operation still runs after the request. A nil registry
+// registers nothing.
+func (r *instanceIntents) acquire(ctx context.Context, instanceURI, op, volumeID string) (func(pending bool), error) {
+	if r == nil {
+		return func(bool) {}, nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.now()
+	intent := r.intents[instanceURI]
+	if intent != nil {
+		for holder, expiry := range intent.holders {
+			if !expiry.IsZero() && now.After(expiry) {
+				delete(intent.holders, holder)
+			}
+		}
+		if len(intent.holders) == 0 {
+			delete(r.intents, instanceURI)
+			intent = nil
+		}
+	}
+	if intent != nil && intent.op != op {
+		return nil, conflictingIntentError(instanceURI, intent.op, op)
+	}
+	if intent == nil {
+		if err := r.persist(ctx, instanceURI, op); err != nil {
+			return nil, err
+		}
+		intent = &instanceIntent{op: op, holders: make(map[string]time.Time)}
+		r.intents[instanceURI] = intent
+	}
+	intent.holders[volumeID] = time.Time{}
+
+	return func(pending bool) {
+		r.release(instanceURI, intent, volumeID, pending)
+	}, nil
+}
+
+func (r *instanceIntents) release(instanceURI string, intent *instanceIntent, volumeID string, pending bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if pending {
+		klog.Infof("The %s operation of volume %s still runs, its intent on instance %s is kept", intent.op, volumeID, instanceURI)
+		intent.holders[volumeID] = r.now().Add(pendingIntentTTL)
+		return
+	}
+	delete(intent.holders, volumeID)
+	if len(intent.holders) > 0 || r.intents[instanceURI] != intent {
+		return
+	}
+	delete(r.intents, instanceURI)
+	if err := r.persist(context.Background(), instanceURI, ""); err != nil {
+		klog.Warningf("Failed to clear the %s intent on instance %s: %v", intent.op, instanceURI, err)
+	}
+}
+
+// persist records the intent of an operation on the InstanceInfo of the instance in stateful
+// mode, or clears it if op is empty. It returns Aborted if the InstanceInfo holds the unexpired
+// intent of another operation, e.g. recorded before a restart of the controller.
+func (r *instanceIntents) persist(ctx context.Context, instanceURI, op string) error {
+	if r.clientset == nil {
+		return nil
+	}
+	instanceInfos := r.clientset.MultishareV1().InstanceInfos(util.ManagedFilestoreCSINamespace)
+	name := util.InstanceURIToInstanceInfoName(instanceURI)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		instanceInfo, err := instanceInfos.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to get InstanceInfo %s: %v", name, err)
+		}
+		recorded, expiry := parseIntentAnnotation(instanceInfo.Annotations[annotationOperationIntent])
+		if op != "" && recorded != "" && recorded != op && r.now().Before(expiry) {
+			return conflictingIntentError(instanceURI, recorded, op)
+		}
+		instanceInfo = instanceInfo.DeepCopy()
+		if op == "" {
+			if _, ok := instanceInfo.Annotations[annotationOperationIntent]; !ok {
+				return nil
+			}
+			delete(instanceInfo.Annotations, annotationOperationIntent)
+		} else {
+			if instanceInfo.Annotations == nil {
+				instanceInfo.Annotations = make(map[string]string)
+			}
+			instanceInfo.Annotations[annotationOperationIntent] = op + "," + r.now().Add(pendingIntentTTL).UTC().Format(time.RFC3339)
+		}
+		_, err = instanceInfos.Update(ctx, instanceInfo, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// parseIntentAnnotation returns the operation and the expiry of an intent annotation, an empty
+// operation if the annotation is invalid.
+func parseIntentAnnotation(value string) (string, time.Time) {
+	tokens := strings.Split(value, ",")
+	if len(tokens) != 2 {
+		return "", time.Time{}
+	}
+	expiry, err := time.Parse(time.RFC3339, tokens[1])
+	if err != nil {
+		return "", time.Time{}
+	}
+	return tokens[0], expiry
+}
+
+// isBackupInProgress returns true if the operation creating an existing backup still runs.
+func isBackupInProgress(backup *file.Backup) bool {
+	return backup.Backup.State == "CREATING" || backup.Backup.State == "FINALIZING"
+}
+
+func conflictingIntentError(instanceURI, running, op string) error {
+	return status.Errorf(codes.Aborted, "instance %s has a %s operation in progress, the %s is retried once it is done", instanceURI, running, op)
+}
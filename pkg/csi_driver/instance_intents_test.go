@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const testIntentInstanceURI = "projects/test-project/locations/us-central1-c/instances/test-csi"
+
+func TestInstanceIntents(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := newInstanceIntents(nil)
+	r.now = func() time.Time { return now }
+	acquire := func(op, volumeID string, expectedCode codes.Code) func(bool) {
+		t.Helper()
+		release, err := r.acquire(ctx, testIntentInstanceURI, op, volumeID)
+		if status.Code(err) != expectedCode {
+			t.Fatalf("%s of volume %s: got error %v, expected code %v", op, volumeID, err, expectedCode)
+		}
+		return release
+	}
+
+	// The expansions of several volumes of the instance hold the intent together.
+	releaseA := acquire(intentExpand, "vol-a", codes.OK)
+	releaseB := acquire(intentExpand, "vol-b", codes.OK)
+	acquire(intentBackup, "vol-c", codes.Aborted)
+	releaseA(false)
+	acquire(intentBackup, "vol-c", codes.Aborted)
+	releaseB(false)
+	if len(r.intents) != 0 {
+		t.Fatalf("got intents %v, expected none", r.intents)
+	}
+
+	// The intent of an operation outliving its request is kept until the retry of the request.
+	acquire(intentBackup, "vol-c", codes.OK)(true)
+	acquire(intentExpand, "vol-a", codes.Aborted)
+	acquire(intentBackup, "vol-c", codes.OK)(false)
+	acquire(intentExpand, "vol-a", codes.OK)(true)
+
+	// Or until it expires.
+	now = now.Add(pendingIntentTTL + time.Minute)
+	acquire(intentBackup, "vol-c", codes.OK)(false)
+	if len(r.intents) != 0 {
+		t.Errorf("got intents %v, expected none", r.intents)
+	}
+
+	// A nil registry registers nothing.
+	var nilIntents *instanceIntents
+	release, err := nilIntents.acquire(ctx, testIntentInstanceURI, intentExpand, "vol-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release(false)
+}
+
+func TestInstanceIntentsStateful(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	name := util.InstanceURIToInstanceInfoName(testIntentInstanceURI)
+	driverClient := fake.NewSimpleClientset(&multisharev1.InstanceInfo{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: util.ManagedFilestoreCSINamespace},
+	})
+	newRegistry := func() *instanceIntents {
+		r := newInstanceIntents(driverClient)
+		r.now = func() time.Time { return now }
+		return r
+	}
+	annotation := func() string {
+		instanceInfo, err := driverClient.MultishareV1().InstanceInfos(util.ManagedFilestoreCSINamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get InstanceInfo: %v", err)
+		}
+		return instanceInfo.Annotations[annotationOperationIntent]
+	}
+
+	release, err := newRegistry().acquire(ctx, testIntentInstanceURI, intentExpand, "vol-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "expand,2024-01-02T04:04:05Z"; annotation() != expected {
+		t.Errorf("got annotation %q, expected %q", annotation(), expected)
+	}
+	// The intent survives the restart of the controller.
+	restarted := newRegistry()
+	if _, err := restarted.acquire(ctx, testIntentInstanceURI, intentBackup, "vol-b"); status.Code(err) != codes.Aborted {
+		t.Errorf("got error %v, expected code %v", err, codes.Aborted)
+	}
+	release(false)
+	if annotation() != "" {
+		t.Errorf("got annotation %q, expected none", annotation())
+	}
+	release, err = restarted.acquire(ctx, testIntentInstanceURI, intentBackup, "vol-b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release(false)
+
+	// An InstanceInfo not created yet records nothing.
+	if _, err := newRegistry().acquire(ctx, "projects/test-project/locations/us-central1-c/instances/other", intentBackup, "vol-b"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCreateSnapshotDuringExpansion(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	ctx := context.Background()
+	release, err := cs.config.instanceIntents.acquire(ctx, testIntentInstanceURI, intentExpand, testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release(true /* pending */)
+
+	_, err = cs.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "mybackup",
+		SourceVolumeId: testVolumeID,
+		Parameters:     map[string]string{util.VolumeSnapshotTypeKey: util.VolumeSnapshotTypeBackup},
+	})
+	if status.Code(err) != codes.Aborted || !strings.Contains(err.Error(), "expand operation in progress") {
+		t.Errorf("got error %v, expected code %v for the expand operation in progress", err, codes.Aborted)
+	}
+}
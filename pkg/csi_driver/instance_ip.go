@@ -0,0 +1,301 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const (
+	// instanceIPsConfigMapName is the name of the ConfigMap mapping the instances backing the
+	// PVs of the driver to their current IP, see instanceIPKey.
+	instanceIPsConfigMapName = "filestorecsi-instance-ips"
+	// annotationInstanceIP records the current IP of the instance of a PV whose ip volume
+	// attribute is stale. The volume attributes of a PV are immutable.
+	annotationInstanceIP = "filestore.csi.storage.gke.io/instance-ip"
+	// Reason of the events published on the PVs and PVCs whose instance IP changed.
+	eventReasonInstanceIPChanged = "FilestoreInstanceIPChanged"
+)
+
+// instanceIPKey returns the key of an instance in the ConfigMap of the instance IPs. The
+// colon of the domain-scoped projects is not allowed in ConfigMap keys.
+func instanceIPKey(instance *file.MultishareInstance) string {
+	return strings.Join([]string{strings.ReplaceAll(instance.Project, ":", "."), instance.Location, instance.Name}, ".")
+}
+
+// instanceIPReconciler periodically looks up the current IP of the instances backing the PVs
+// of the driver, and publishes them in a ConfigMap read by the node drivers. The IP of an
+// instance changes when it is migrated between connect modes, e.g. from VPC peering to
+// Private Service Connect, leaving the ip volume attribute of its PVs stale; since the volume
+// attributes of a PV are immutable, the stale PVs are annotated with the current IP instead,
+// and an event is published on them and their PVCs.
+type instanceIPReconciler struct {
+	fileService file.Service
+	project     string
+	driverName  string
+	namespace   string
+	period      time.Duration
+	kubeClient  kubernetes.Interface
+	recorder    record.EventRecorder
+}
+
+func newInstanceIPReconciler(feature *FeatureInstanceIPRefresh, fileService file.Service, project, driverName string, kubeClient kubernetes.Interface, recorder record.EventRecorder) *instanceIPReconciler {
+	return &instanceIPReconciler{
+		fileService: fileService,
+		project:     project,
+		driverName:  driverName,
+		namespace:   feature.Namespace,
+		period:      feature.Period,
+		kubeClient:  kubeClient,
+		recorder:    recorder,
+	}
+}
+
+// Run refreshes the IPs of the instances every period until stopCh is closed.
+func (r *instanceIPReconciler) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Filestore instance IP reconciler with period %v", r.period)
+	wait.Until(func() {
+		if err := r.reconcile(context.Background()); err != nil {
+			klog.Errorf("Failed to refresh the IPs of the Filestore instances: %v", err)
+		}
+	}, r.period, stopCh)
+}
+
+func (r *instanceIPReconciler) reconcile(ctx context.Context) error {
+	pvs, err := r.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	cm, err := r.kubeClient.CoreV1().ConfigMaps(r.namespace).Get(ctx, instanceIPsConfigMapName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	var published map[string]string
+	if err == nil {
+		published = cm.Data
+	} else {
+		cm = nil
+	}
+
+	type instancePVs struct {
+		instance   *file.MultishareInstance
+		multishare bool
+		pvs        []*v1.PersistentVolume
+	}
+	instances := make(map[string]*instancePVs)
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != r.driverName {
+			continue
+		}
+		instance, multishare, err := instanceOfVolume(pv.Spec.CSI.VolumeHandle, r.project)
+		if err != nil {
+			klog.V(4).Infof("Skipping PV %s: %v", pv.Name, err)
+			continue
+		}
+		key := instanceIPKey(instance)
+		if _, ok := instances[key]; !ok {
+			instances[key] = &instancePVs{instance: instance, multishare: multishare}
+		}
+		instances[key].pvs = append(instances[key].pvs, pv)
+	}
+
+	ips := make(map[string]string, len(instances))
+	for key, i := range instances {
+		ip, err := r.getInstanceIP(ctx, i.instance, i.multishare)
+		if err != nil || ip == "" {
+			klog.Warningf("Failed to get the IP of Filestore instance %s: %v", key, err)
+			// Keep the last published IP, the instance is looked up again on the next period.
+			if last, ok := published[key]; ok {
+				ips[key] = last
+			}
+			continue
+		}
+		ips[key] = ip
+		for _, pv := range i.pvs {
+			if err := r.reconcilePV(ctx, pv, ip); err != nil {
+				klog.Errorf("Failed to annotate PV %s with the IP of instance %s: %v", pv.Name, key, err)
+			}
+		}
+	}
+	// The IPs of the instances of the deleted PVs are dropped.
+	return r.publish(ctx, cm, ips)
+}
+
+func (r *instanceIPReconciler) getInstanceIP(ctx context.Context, instance *file.MultishareInstance, multishare bool) (string, error) {
+	if multishare {
+		i, err := r.fileService.GetMultishareInstance(ctx, instance)
+		if err != nil {
+			return "", err
+		}
+		return i.Network.Ip, nil
+	}
+	i, err := r.fileService.GetInstance(ctx, &file.ServiceInstance{Project: instance.Project, Location: instance.Location, Name: instance.Name})
+	if err != nil {
+		return "", err
+	}
+	return i.Network.Ip, nil
+}
+
+// reconcilePV annotates a PV whose ip volume attribute differs from the current IP of its
+// instance with the current IP, and removes the annotation once they match again, e.g. after
+// the PV was recreated.
+func (r *instanceIPReconciler) reconcilePV(ctx context.Context, pv *v1.PersistentVolume, ip string) error {
+	annotated, hasAnnotation := pv.Annotations[annotationInstanceIP]
+	var value interface{}
+	if pv.Spec.CSI.VolumeAttributes[attrIP] == ip {
+		if !hasAnnotation {
+			return nil
+		}
+		// A null value removes the annotation.
+		value = nil
+	} else {
+		if annotated == ip {
+			return nil
+		}
+		value = ip
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{annotationInstanceIP: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := r.kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+
+	message := fmt.Sprintf("Filestore instance IP changed from %s to %s, the volume is mounted from %s when staged again on a node", pv.Spec.CSI.VolumeAttributes[attrIP], ip, ip)
+	klog.Infof("%s: PV %s: %s", eventReasonInstanceIPChanged, pv.Name, message)
+	r.recorder.Event(pv, v1.EventTypeWarning, eventReasonInstanceIPChanged, message)
+	if pv.Spec.ClaimRef != nil {
+		claimRef := pv.Spec.ClaimRef.DeepCopy()
+		if claimRef.Kind == "" {
+			claimRef.Kind = "PersistentVolumeClaim"
+		}
+		r.recorder.Event(claimRef, v1.EventTypeWarning, eventReasonInstanceIPChanged, message)
+	}
+	return nil
+}
+
+// publish creates or updates the ConfigMap of the instance IPs, cm if it exists.
+func (r *instanceIPReconciler) publish(ctx context.Context, cm *v1.ConfigMap, ips map[string]string) error {
+	if cm == nil {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: instanceIPsConfigMapName, Namespace: r.namespace},
+			Data:       ips,
+		}
+		_, err := r.kubeClient.CoreV1().ConfigMaps(r.namespace).Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if reflect.DeepEqual(cm.Data, ips) || (len(cm.Data) == 0 && len(ips) == 0) {
+		return nil
+	}
+	cm = cm.DeepCopy()
+	cm.Data = ips
+	_, err := r.kubeClient.CoreV1().ConfigMaps(r.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// instanceIPResolver resolves the IP the node driver mounts a volume from, the current IP of
+// its instance published by the controller driver when known, or else the ip volume attribute.
+// The published IPs are cached for ttl.
+type instanceIPResolver struct {
+	sync.Mutex
+	kubeClient kubernetes.Interface
+	namespace  string
+	project    string
+	ttl        time.Duration
+
+	ips     map[string]string
+	fetched time.Time
+	now     func() time.Time
+}
+
+func newInstanceIPResolver(feature *FeatureInstanceIPRefresh, project string, kubeClient kubernetes.Interface) *instanceIPResolver {
+	return &instanceIPResolver{
+		kubeClient: kubeClient,
+		namespace:  feature.Namespace,
+		project:    project,
+		ttl:        feature.CacheTTL,
+		now:        time.Now,
+	}
+}
+
+// resolve returns the IP to mount the volume from, given the ip volume attribute. The
+// attribute is returned if the published IPs can't be read.
+func (r *instanceIPResolver) resolve(ctx context.Context, volumeID, ip string) string {
+	instance, _, err := instanceOfVolume(volumeID, r.project)
+	if err != nil {
+		return ip
+	}
+	ips, err := r.instanceIPs(ctx)
+	if err != nil {
+		klog.Warningf("Failed to read the IPs of the Filestore instances, mounting volume %s from %s: %v", volumeID, ip, err)
+		return ip
+	}
+	if current, ok := ips[instanceIPKey(instance)]; ok && current != ip {
+		klog.Infof("The IP of the instance of volume %s changed from %s to %s, mounting from %s", volumeID, ip, current, current)
+		return current
+	}
+	return ip
+}
+
+// instanceIPs returns the published IPs, read from the ConfigMap at most every ttl. No IPs
+// are published until the controller driver created the ConfigMap.
+func (r *instanceIPResolver) instanceIPs(ctx context.Context) (map[string]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.ips != nil && r.now().Sub(r.fetched) < r.ttl {
+		return r.ips, nil
+	}
+	cm, err := r.kubeClient.CoreV1().ConfigMaps(r.namespace).Get(ctx, instanceIPsConfigMapName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		r.ips = map[string]string{}
+	case err != nil:
+		return nil, err
+	default:
+		r.ips = cm.Data
+		if r.ips == nil {
+			r.ips = map[string]string{}
+		}
+	}
+	r.fetched = r.now()
+	return r.ips, nil
+}
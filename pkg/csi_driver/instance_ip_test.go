@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const testInstanceIPNamespace = "gcp-filestore-csi-driver"
+
+func TestInstanceIPReconciler(t *testing.T) {
+	multishareInstance := &file.MultishareInstance{
+		Project:  testProject,
+		Location: testRegion,
+		Name:     "test-multishare-instance",
+		State:    "READY",
+		Network:  file.Network{Ip: "10.0.0.2"},
+	}
+	fileService, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{multishareInstance}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	// The fake instances are served from 1.1.1.1.
+	if _, err := fileService.CreateInstance(context.Background(), &file.ServiceInstance{
+		Project:  testProject,
+		Location: testZone,
+		Name:     "test-instance",
+		Volume:   file.Volume{Name: "vol1"},
+	}); err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+
+	pvWithIP := func(name, volumeHandle, claimName, ip string) *v1.PersistentVolume {
+		pv := testPV(name, testDriverName, volumeHandle, claimName)
+		pv.Spec.CSI.VolumeAttributes = map[string]string{attrIP: ip}
+		return pv
+	}
+	stale := pvWithIP("pv-share-stale", modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/test-multishare-instance/share1", "pvc-share", "192.168.0.2")
+	recreated := pvWithIP("pv-instance", modeInstance+"/"+testZone+"/test-instance/vol1", "", "1.1.1.1")
+	recreated.Annotations = map[string]string{annotationInstanceIP: "1.1.1.1"}
+	kubeClient := fake.NewSimpleClientset(
+		stale,
+		recreated,
+		pvWithIP("pv-share", modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/test-multishare-instance/share2", "", "10.0.0.2"),
+		pvWithIP("pv-deleted-instance", modeInstance+"/"+testZone+"/deleted-instance/vol1", "", "1.2.3.4"),
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: instanceIPsConfigMapName, Namespace: testInstanceIPNamespace},
+			Data: map[string]string{
+				testProject + "." + testZone + ".deleted-instance": "1.2.3.4",
+				testProject + "." + testZone + ".gone-instance":    "1.2.3.5",
+			},
+		},
+	)
+	recorder := record.NewFakeRecorder(100)
+	feature := &FeatureInstanceIPRefresh{Enabled: true, Period: time.Minute, Namespace: testInstanceIPNamespace}
+	r := newInstanceIPReconciler(feature, fileService, testProject, testDriverName, kubeClient, recorder)
+
+	for i := 0; i < 2; i++ {
+		if err := r.reconcile(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cm, err := kubeClient.CoreV1().ConfigMaps(testInstanceIPNamespace).Get(context.Background(), instanceIPsConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get ConfigMap: %v", err)
+	}
+	expectedIPs := map[string]string{
+		testProject + "." + testRegion + ".test-multishare-instance": "10.0.0.2",
+		testProject + "." + testZone + ".test-instance":              "1.1.1.1",
+		// The last IP of an instance that can't be fetched is kept.
+		testProject + "." + testZone + ".deleted-instance": "1.2.3.4",
+	}
+	if !reflect.DeepEqual(cm.Data, expectedIPs) {
+		t.Errorf("got instance IPs %v, expected %v", cm.Data, expectedIPs)
+	}
+
+	expectedAnnotations := map[string]string{"pv-share-stale": "10.0.0.2", "pv-instance": "", "pv-share": ""}
+	for name, expected := range expectedAnnotations {
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get PV %s: %v", name, err)
+		}
+		if got := pv.Annotations[annotationInstanceIP]; got != expected {
+			t.Errorf("PV %s: got instance IP annotation %q, expected %q", name, got, expected)
+		}
+	}
+	// The events are published once, on the stale PV and its PVC.
+	message := "Warning FilestoreInstanceIPChanged Filestore instance IP changed from 192.168.0.2 to 10.0.0.2, the volume is mounted from 10.0.0.2 when staged again on a node"
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, []string{message, message}) {
+		t.Errorf("got events %v, expected %v", events, []string{message, message})
+	}
+}
+
+func TestInstanceIPResolver(t *testing.T) {
+	multishareVolumeID := modeMultishare + "/" + testInstanceScPrefix + "/" + testProject + "/" + testRegion + "/test-multishare-instance/share1"
+	kubeClient := fake.NewSimpleClientset()
+	feature := &FeatureInstanceIPRefresh{Enabled: true, CacheTTL: time.Minute, Namespace: testInstanceIPNamespace}
+	r := newInstanceIPResolver(feature, testProject, kubeClient)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	// No IPs are published before the controller creates the ConfigMap.
+	if ip := r.resolve(context.Background(), testVolumeID, "1.1.1.1"); ip != "1.1.1.1" {
+		t.Errorf("got IP %s without ConfigMap, expected the volume attribute 1.1.1.1", ip)
+	}
+	if _, err := kubeClient.CoreV1().ConfigMaps(testInstanceIPNamespace).Create(context.Background(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: instanceIPsConfigMapName, Namespace: testInstanceIPNamespace},
+		Data: map[string]string{
+			testProject + "." + testZone + ".test-csi":                   "10.0.0.3",
+			testProject + "." + testRegion + ".test-multishare-instance": "10.0.0.2",
+		},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create ConfigMap: %v", err)
+	}
+	if ip := r.resolve(context.Background(), testVolumeID, "1.1.1.1"); ip != "1.1.1.1" {
+		t.Errorf("got IP %s before the cache expired, expected the volume attribute 1.1.1.1", ip)
+	}
+
+	now = now.Add(time.Minute)
+	for volumeID, expected := range map[string]string{
+		testVolumeID:       "10.0.0.3",
+		multishareVolumeID: "10.0.0.2",
+		modeInstance + "/" + testZone + "/other/vol1": "1.1.1.1",
+		"invalid": "1.1.1.1",
+	} {
+		if ip := r.resolve(context.Background(), volumeID, "1.1.1.1"); ip != expected {
+			t.Errorf("volume %s: got IP %s, expected %s", volumeID, ip, expected)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// instanceLabelReconciler periodically verifies that the multishare instances backing the PVs
+// of the driver still carry the labels they are matched with, i.e. the instance pool tag and
+// the cluster labels, and re-applies the labels removed or edited out of band, e.g. in the
+// Cloud Console. An instance missing them is silently no longer considered for new shares,
+// nor by the rebalancer, and its shares are orphaned.
+type instanceLabelReconciler struct {
+	driverName string
+	period     time.Duration
+	kubeClient kubernetes.Interface
+
+	// mc is the multishare controller, set by the controller server.
+	mc *MultishareController
+}
+
+func newInstanceLabelReconciler(feature *FeatureInstanceLabelReconciler, driverName string, kubeClient kubernetes.Interface) *instanceLabelReconciler {
+	return &instanceLabelReconciler{
+		driverName: driverName,
+		period:     feature.Period,
+		kubeClient: kubeClient,
+	}
+}
+
+// Run reconciles the labels of the instances every period until stopCh is closed.
+func (r *instanceLabelReconciler) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting multishare instance label reconciler with period %v", r.period)
+	wait.Until(func() {
+		if err := r.reconcile(context.Background()); err != nil {
+			klog.Errorf("Failed to reconcile the labels of the multishare instances: %v", err)
+		}
+	}, r.period, stopCh)
+}
+
+// labeledInstance is an instance backing PVs of the driver, with the instance pool tags found
+// in the volume IDs of the PVs.
+type labeledInstance struct {
+	instance         *file.MultishareInstance
+	instancePoolTags map[string]bool
+}
+
+func (r *instanceLabelReconciler) reconcile(ctx context.Context) error {
+	instances, err := r.listInstances(ctx)
+	if err != nil {
+		return err
+	}
+	uris := make([]string, 0, len(instances))
+	for uri := range instances {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		i := instances[uri]
+		labels, err := r.requiredLabels(uri, i.instancePoolTags)
+		if err != nil {
+			return err
+		}
+		if err := r.reconcileInstance(ctx, i.instance, labels); err != nil {
+			if status.Code(err) == codes.Aborted {
+				// An op is running on the instance, its labels are checked on the next period.
+				klog.V(4).Infof("Skipping the labels of instance %s: %v", uri, err)
+				continue
+			}
+			klog.Errorf("Failed to reconcile the labels of instance %s: %v", uri, err)
+		}
+	}
+	return nil
+}
+
+// listInstances returns the multishare instances backing the PVs of the driver by instance URI.
+// The instances are found through the PVs rather than their labels, which may be gone.
+func (r *instanceLabelReconciler) listInstances(ctx context.Context) (map[string]*labeledInstance, error) {
+	pvs, err := r.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	instances := make(map[string]*labeledInstance)
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != r.driverName || !isMultishareVolId(pv.Spec.CSI.VolumeHandle) {
+			continue
+		}
+		instancePoolTag, project, location, name, _, err := parseMultishareVolId(pv.Spec.CSI.VolumeHandle)
+		if err != nil {
+			klog.V(4).Infof("Skipping PV %s: %v", pv.Name, err)
+			continue
+		}
+		instance := &file.MultishareInstance{Project: project, Location: location, Name: name}
+		uri, err := file.GenerateMultishareInstanceURI(instance)
+		if err != nil {
+			klog.V(4).Infof("Skipping PV %s: %v", pv.Name, err)
+			continue
+		}
+		if _, ok := instances[uri]; !ok {
+			instances[uri] = &labeledInstance{instance: instance, instancePoolTags: make(map[string]bool)}
+		}
+		instances[uri].instancePoolTags[instancePoolTag] = true
+	}
+	return instances, nil
+}
+
+// requiredLabels returns the labels an instance is matched with by the driver. The instance
+// pool tag is left out if the PVs of the instance disagree on it, e.g. for an instance shared
+// by storage classes, since the right one can't be told.
+func (r *instanceLabelReconciler) requiredLabels(uri string, instancePoolTags map[string]bool) (map[string]string, error) {
+	labels := make(map[string]string)
+	if len(instancePoolTags) == 1 {
+		for tag := range instancePoolTags {
+			labels[util.ParamMultishareInstanceScLabelKey] = tag
+		}
+	} else {
+		klog.Warningf("PVs of instance %s have different instance pool tags %v, not reconciling label %q", uri, instancePoolTags, util.ParamMultishareInstanceScLabelKey)
+	}
+	if r.mc.sharedClusterGroup != "" {
+		labels[TagKeySharedClusterGroup] = r.mc.sharedClusterGroup
+		return labels, nil
+	}
+	location, err := getClusterLocation(r.mc.cloud.Zone, r.mc.isRegional, r.mc.clusterLocation)
+	if err != nil {
+		return nil, err
+	}
+	labels[TagKeyClusterName] = r.mc.clustername
+	labels[TagKeyClusterLocation] = location
+	return labels, nil
+}
+
+// reconcileInstance re-applies the labels to the instance if needed, and waits for the update.
+func (r *instanceLabelReconciler) reconcileInstance(ctx context.Context, instance *file.MultishareInstance, labels map[string]string) error {
+	w, err := r.mc.opsManager.checkAndStartInstanceLabelsUpdateWorkflow(ctx, instance, labels)
+	if err != nil || w == nil {
+		return err
+	}
+	return r.mc.waitOnWorkflow(ctx, w)
+}
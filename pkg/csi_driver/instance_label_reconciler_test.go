@@ -0,0 +1,172 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestInstanceLabelReconciler(t *testing.T) {
+	volumeID := func(instancePoolTag, instance, share string) string {
+		return fmt.Sprintf("%s/%s/%s/%s/%s/%s", modeMultishare, instancePoolTag, testProject, testRegion, instance, share)
+	}
+	tests := []struct {
+		name               string
+		sharedClusterGroup string
+		labels             map[string]string
+		state              string
+		pvs                []runtime.Object
+		expectedLabels     map[string]string
+		expectedResizes    int
+	}{
+		{
+			name: "labels intact",
+			labels: map[string]string{
+				util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+				TagKeyClusterName:                      testClusterName,
+				TagKeyClusterLocation:                  testLocation,
+			},
+			pvs: []runtime.Object{testPV("pv-1", testDriverName, volumeID(testInstanceScPrefix, "instance-1", "share-1"), "")},
+			expectedLabels: map[string]string{
+				util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+				TagKeyClusterName:                      testClusterName,
+				TagKeyClusterLocation:                  testLocation,
+			},
+		},
+		{
+			name:   "removed and edited labels re-applied, other labels kept",
+			labels: map[string]string{TagKeyClusterName: "edited", "team": "storage"},
+			pvs:    []runtime.Object{testPV("pv-1", testDriverName, volumeID(testInstanceScPrefix, "instance-1", "share-1"), "")},
+			expectedLabels: map[string]string{
+				util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+				TagKeyClusterName:                      testClusterName,
+				TagKeyClusterLocation:                  testLocation,
+				TagKeyDriverVersion:                    "test-version",
+				"team":                                 "storage",
+			},
+			expectedResizes: 1,
+		},
+		{
+			name:               "shared cluster group re-applied instead of the cluster labels",
+			sharedClusterGroup: "group",
+			labels:             map[string]string{util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix},
+			pvs:                []runtime.Object{testPV("pv-1", testDriverName, volumeID(testInstanceScPrefix, "instance-1", "share-1"), "")},
+			expectedLabels: map[string]string{
+				util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+				TagKeySharedClusterGroup:               "group",
+				TagKeyDriverVersion:                    "test-version",
+			},
+			expectedResizes: 1,
+		},
+		{
+			name:   "instance pool tag left alone when the PVs disagree",
+			labels: map[string]string{},
+			pvs: []runtime.Object{
+				testPV("pv-1", testDriverName, volumeID(testInstanceScPrefix, "instance-1", "share-1"), ""),
+				testPV("pv-2", testDriverName, volumeID("other-prefix", "instance-1", "share-2"), ""),
+			},
+			expectedLabels: map[string]string{
+				TagKeyClusterName:     testClusterName,
+				TagKeyClusterLocation: testLocation,
+				TagKeyDriverVersion:   "test-version",
+			},
+			expectedResizes: 1,
+		},
+		{
+			name:           "instance not ready",
+			labels:         map[string]string{},
+			state:          "REPAIRING",
+			pvs:            []runtime.Object{testPV("pv-1", testDriverName, volumeID(testInstanceScPrefix, "instance-1", "share-1"), "")},
+			expectedLabels: map[string]string{},
+		},
+		{
+			name:   "instance of no PV of the driver",
+			labels: map[string]string{},
+			pvs: []runtime.Object{
+				testPV("pv-1", "other.csi.driver", volumeID(testInstanceScPrefix, "instance-1", "share-1"), ""),
+				testPV("pv-2", testDriverName, "modeInstance/us-central1-c/instance-2/vol1", ""),
+			},
+			expectedLabels: map[string]string{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state := tc.state
+			if state == "" {
+				state = "READY"
+			}
+			instance := &file.MultishareInstance{
+				Name:          "instance-1",
+				Location:      testRegion,
+				Project:       testProject,
+				Labels:        tc.labels,
+				CapacityBytes: 1 * util.Tb,
+				Tier:          enterpriseTier,
+				State:         state,
+			}
+			fakeService, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instance}, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to initialize GCFS service: %v", err)
+			}
+			faults := file.NewFaultInjector()
+			fileService := file.NewFakeServiceWithFaults(fakeService, faults)
+			cloudProvider, err := cloud.NewFakeCloudWithFiler(fileService, testProject, testLocation)
+			if err != nil {
+				t.Fatalf("failed to get cloud provider: %v", err)
+			}
+			mcs := NewMultishareController(&controllerServerConfig{
+				driver:             initTestDriver(t),
+				fileService:        fileService,
+				cloud:              cloudProvider,
+				volumeLocks:        util.NewVolumeLocks(),
+				clusterName:        testClusterName,
+				sharedClusterGroup: tc.sharedClusterGroup,
+			})
+			feature := &FeatureInstanceLabelReconciler{Period: time.Minute}
+			r := newInstanceLabelReconciler(feature, testDriverName, kubefake.NewSimpleClientset(tc.pvs...))
+			r.mc = mcs
+
+			ctx := context.Background()
+			if err := r.reconcile(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := fileService.GetMultishareInstance(ctx, &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "instance-1"})
+			if err != nil {
+				t.Fatalf("failed to get instance: %v", err)
+			}
+			if !reflect.DeepEqual(got.Labels, tc.expectedLabels) {
+				t.Errorf("got labels %v, expected %v", got.Labels, tc.expectedLabels)
+			}
+			if got.CapacityBytes != 1*util.Tb {
+				t.Errorf("got capacity %d, expected %d", got.CapacityBytes, 1*util.Tb)
+			}
+			if resizes := faults.Calls("StartResizeMultishareInstanceOp"); resizes != tc.expectedResizes {
+				t.Errorf("got %d instance update ops, expected %d", resizes, tc.expectedResizes)
+			}
+		})
+	}
+}
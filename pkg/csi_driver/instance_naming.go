@@ -0,0 +1,96 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+
+	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// maxInstanceNameAttempts bounds the names tried for a new multishare instance whose generated
+// names are taken by instances not created by the driver.
+const maxInstanceNameAttempts = 3
+
+// instanceNamer generates the names of the new multishare instances.
+type instanceNamer interface {
+	newInstanceName() string
+}
+
+// uuidInstanceNamer names the instances with the multishare instance prefix and a UUID suffix.
+type uuidInstanceNamer struct{}
+
+func (uuidInstanceNamer) newInstanceName() string {
+	return util.NewMultishareInstancePrefix + string(uuid.NewUUID())
+}
+
+// newInstanceName returns a name for a new multishare instance.
+func (m *MultishareOpsManager) newInstanceName() string {
+	if m.instanceNamer == nil {
+		return uuidInstanceNamer{}.newInstanceName()
+	}
+	return m.instanceNamer.newInstanceName()
+}
+
+// startCreateInstanceOp starts the creation of a new multishare instance. If its name is taken
+// by an instance not created by the driver, e.g. created out of band, the creation is retried
+// under a new name, at most maxInstanceNameAttempts times, and the name of the instance updated.
+// If the instance of the name carries the ownership labels of the new instance, it was created by
+// a previous attempt of the request: the request is aborted, and its retry places the share on
+// the instance instead of creating another one.
+func (m *MultishareOpsManager) startCreateInstanceOp(ctx context.Context, instance *file.MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	for attempt := 1; ; attempt++ {
+		op, err := m.cloud.File.StartCreateMultishareInstanceOp(ctx, instance)
+		if err == nil || !file.IsAlreadyExistsErr(err) {
+			return op, err
+		}
+		existing, getErr := m.cloud.File.GetMultishareInstance(ctx, instance)
+		if getErr != nil {
+			return nil, getErr
+		}
+		if ownsInstance(existing, instance) {
+			return nil, status.Errorf(codes.Aborted, "multishare instance %s was created by a previous attempt of the request, retry to use it", instance.Name)
+		}
+		if attempt == maxInstanceNameAttempts {
+			return nil, status.Errorf(codes.Aborted, "the last %d names of the new multishare instance, e.g. %s, are taken by instances not created by the driver", maxInstanceNameAttempts, instance.Name)
+		}
+		name := m.newInstanceName()
+		klog.Warningf("Multishare instance name %s is taken by an instance not created by the driver, creating the instance as %s", instance.Name, name)
+		instance.Name = name
+	}
+}
+
+// ownsInstance returns whether the existing instance carries the ownership labels of the new
+// instance of the same name, i.e. was created by the driver for the same volume, cluster and
+// instance pool.
+func ownsInstance(existing, instance *file.MultishareInstance) bool {
+	if instance.Labels[tagKeyCreatedForVolumeName] == "" {
+		return false
+	}
+	for _, key := range []string{tagKeyCreatedForVolumeName, TagKeyClusterUID, util.ParamMultishareInstanceScLabelKey} {
+		if existing.Labels[key] != instance.Labels[key] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// conflictingInstanceService fails the creation of the multishare instances whose name is taken
+// with a conflict error, like the Filestore API.
+type conflictingInstanceService struct {
+	file.Service
+}
+
+func (s *conflictingInstanceService) StartCreateMultishareInstanceOp(ctx context.Context, obj *file.MultishareInstance) (*filev1beta1multishare.Operation, error) {
+	if _, err := s.Service.GetMultishareInstance(ctx, obj); err == nil {
+		return nil, &googleapi.Error{Code: http.StatusConflict, Message: "instance " + obj.Name + " already exists"}
+	}
+	return s.Service.StartCreateMultishareInstanceOp(ctx, obj)
+}
+
+// sequenceInstanceNamer returns its names in order.
+type sequenceInstanceNamer struct {
+	names []string
+}
+
+func (n *sequenceInstanceNamer) newInstanceName() string {
+	name := n.names[0]
+	n.names = n.names[1:]
+	return name
+}
+
+func TestUUIDInstanceNamer(t *testing.T) {
+	name := uuidInstanceNamer{}.newInstanceName()
+	if !strings.HasPrefix(name, util.NewMultishareInstancePrefix) {
+		t.Errorf("got name %q, expected prefix %q", name, util.NewMultishareInstancePrefix)
+	}
+	if other := (uuidInstanceNamer{}).newInstanceName(); other == name {
+		t.Errorf("got name %q twice", name)
+	}
+}
+
+func TestStartCreateInstanceOp(t *testing.T) {
+	ownershipLabels := map[string]string{
+		tagKeyCreatedForVolumeName:             "pvc-1",
+		TagKeyClusterUID:                       "cluster-uid",
+		util.ParamMultishareInstanceScLabelKey: "sc-1",
+	}
+	cases := []struct {
+		name         string
+		existing     map[string]map[string]string
+		names        []string
+		expectedCode codes.Code
+		expectedName string
+	}{
+		{
+			name:         "name not taken",
+			names:        []string{"fs-2"},
+			expectedName: "fs-1",
+		},
+		{
+			name:         "name taken by an instance not created by the driver",
+			existing:     map[string]map[string]string{"fs-1": {"team": "storage"}},
+			names:        []string{"fs-2"},
+			expectedName: "fs-2",
+		},
+		{
+			name:         "name taken by an instance of another volume",
+			existing:     map[string]map[string]string{"fs-1": {tagKeyCreatedForVolumeName: "pvc-2", TagKeyClusterUID: "cluster-uid", util.ParamMultishareInstanceScLabelKey: "sc-1"}},
+			names:        []string{"fs-2"},
+			expectedName: "fs-2",
+		},
+		{
+			name:         "names taken until the last attempt",
+			existing:     map[string]map[string]string{"fs-1": nil, "fs-2": nil},
+			names:        []string{"fs-2", "fs-3"},
+			expectedName: "fs-3",
+		},
+		{
+			name:         "all attempts taken",
+			existing:     map[string]map[string]string{"fs-1": nil, "fs-2": nil, "fs-3": nil},
+			names:        []string{"fs-2", "fs-3", "fs-4"},
+			expectedCode: codes.Aborted,
+			expectedName: "fs-3",
+		},
+		{
+			name:         "name taken by the instance of a previous attempt",
+			existing:     map[string]map[string]string{"fs-1": ownershipLabels},
+			names:        []string{"fs-2"},
+			expectedCode: codes.Aborted,
+			expectedName: "fs-1",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := initTestMultishareController(t)
+			fs := &conflictingInstanceService{Service: m.fileService}
+			m.cloud.File = fs
+			m.opsManager.instanceNamer = &sequenceInstanceNamer{names: tc.names}
+			for name, labels := range tc.existing {
+				if _, err := fs.Service.StartCreateMultishareInstanceOp(context.Background(), &file.MultishareInstance{Name: name, Location: testRegion, Labels: labels}); err != nil {
+					t.Fatalf("failed to create instance %s: %v", name, err)
+				}
+			}
+
+			instance := &file.MultishareInstance{Name: "fs-1", Location: testRegion, Labels: ownershipLabels}
+			_, err := m.opsManager.startCreateInstanceOp(context.Background(), instance)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("got code %v, expected %v: %v", code, tc.expectedCode, err)
+			}
+			if instance.Name != tc.expectedName {
+				t.Errorf("got instance name %q, expected %q", instance.Name, tc.expectedName)
+			}
+			if err == nil {
+				if _, err := fs.GetMultishareInstance(context.Background(), instance); err != nil {
+					t.Errorf("instance %s not created: %v", instance.Name, err)
+				}
+			}
+		})
+	}
+}
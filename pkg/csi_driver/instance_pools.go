@@ -0,0 +1,251 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+	"sigs.k8s.io/yaml"
+)
+
+// Packing policies of the instance pools, choosing the eligible instance of a new share.
+const (
+	// packingPolicyRandom places the share on a random eligible instance.
+	packingPolicyRandom = "random"
+	// packingPolicyPack places the share on the eligible instance holding the most shares, so
+	// that the other instances are left empty and deleted.
+	packingPolicyPack = "pack"
+	// packingPolicySpread places the share on the eligible instance holding the fewest shares,
+	// so that an instance outage affects as few volumes as possible.
+	packingPolicySpread = "spread"
+)
+
+// InstancePoolsConfig configures the multishare operations of each instance pool, i.e. of the
+// storage classes sharing an instance-storageclass-label, so that the provisioning of one
+// storage class does not starve the others. It is loaded from the YAML file set with
+// --instance-pool-config, e.g.
+//
+//	default:
+//	  maxConcurrentOperations: 8
+//	instancePools:
+//	- instancePoolTag: tenant-a
+//	  maxConcurrentOperations: 4
+//	  maxQueuedOperations: 32
+//	  packingPolicy: spread
+type InstancePoolsConfig struct {
+	// Default is the policy of each instance pool not listed in InstancePools.
+	Default InstancePoolPolicy `json:"default"`
+	// InstancePools are the policies of the listed instance pools.
+	InstancePools []InstancePoolPolicy `json:"instancePools"`
+}
+
+// InstancePoolPolicy configures the multishare operations of an instance pool.
+type InstancePoolPolicy struct {
+	// InstancePoolTag is the instance-storageclass-label of the instance pool.
+	InstancePoolTag string `json:"instancePoolTag"`
+	// MaxConcurrentOperations is the maximum number of CreateVolume, DeleteVolume and
+	// ControllerExpandVolume calls of the instance pool served at the same time. Further
+	// calls wait in the queue of the pool. Zero means no limit.
+	MaxConcurrentOperations int `json:"maxConcurrentOperations"`
+	// MaxQueuedOperations is the maximum number of calls waiting in the queue of the pool.
+	// Further calls are rejected with ResourceExhausted, so that the sidecars retry them with
+	// backoff. Zero means no limit.
+	MaxQueuedOperations int `json:"maxQueuedOperations"`
+	// PackingPolicy is one of random, the default, pack or spread.
+	PackingPolicy string `json:"packingPolicy"`
+}
+
+// LoadInstancePoolsConfig reads and validates the instance pools config file at path.
+func LoadInstancePoolsConfig(path string) (*InstancePoolsConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance pool config %s: %w", path, err)
+	}
+	config := &InstancePoolsConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse instance pool config %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid instance pool config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c *InstancePoolsConfig) validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default policy: %w", err)
+	}
+	if c.Default.InstancePoolTag != "" {
+		return fmt.Errorf("default policy: instancePoolTag must not be set")
+	}
+	seen := make(map[string]bool, len(c.InstancePools))
+	for _, p := range c.InstancePools {
+		if err := util.CheckLabelValueRegex(p.InstancePoolTag); err != nil || p.InstancePoolTag == "" {
+			return fmt.Errorf("invalid instancePoolTag %q", p.InstancePoolTag)
+		}
+		if seen[p.InstancePoolTag] {
+			return fmt.Errorf("instance pool %q listed twice", p.InstancePoolTag)
+		}
+		seen[p.InstancePoolTag] = true
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("instance pool %q: %w", p.InstancePoolTag, err)
+		}
+	}
+	return nil
+}
+
+func (p *InstancePoolPolicy) validate() error {
+	if p.MaxConcurrentOperations < 0 {
+		return fmt.Errorf("maxConcurrentOperations must not be negative")
+	}
+	if p.MaxQueuedOperations < 0 {
+		return fmt.Errorf("maxQueuedOperations must not be negative")
+	}
+	switch p.PackingPolicy {
+	case "", packingPolicyRandom, packingPolicyPack, packingPolicySpread:
+	default:
+		return fmt.Errorf("unknown packingPolicy %q, must be one of %s, %s or %s", p.PackingPolicy, packingPolicyRandom, packingPolicyPack, packingPolicySpread)
+	}
+	return nil
+}
+
+// policy returns the policy of an instance pool.
+func (c *InstancePoolsConfig) policy(instancePoolTag string) InstancePoolPolicy {
+	if c != nil {
+		for _, p := range c.InstancePools {
+			if p.InstancePoolTag == instancePoolTag {
+				return p
+			}
+		}
+		p := c.Default
+		p.InstancePoolTag = instancePoolTag
+		return p
+	}
+	return InstancePoolPolicy{InstancePoolTag: instancePoolTag}
+}
+
+// instancePools admits the multishare operations of each instance pool through an independent
+// queue, bounded by the policy of the pool.
+type instancePools struct {
+	config         *InstancePoolsConfig
+	metricsManager *metrics.MetricsManager
+
+	mutex  sync.Mutex
+	queues map[string]*instancePoolQueue
+}
+
+// instancePoolQueue is the queue of the operations of an instance pool. slots holds a token
+// per operation being served.
+type instancePoolQueue struct {
+	policy InstancePoolPolicy
+	slots  chan struct{}
+	// queued is the number of operations waiting for a slot, guarded by the mutex of the pools.
+	queued int
+}
+
+func newInstancePools(config *InstancePoolsConfig, metricsManager *metrics.MetricsManager) *instancePools {
+	return &instancePools{
+		config:         config,
+		metricsManager: metricsManager,
+		queues:         make(map[string]*instancePoolQueue),
+	}
+}
+
+// packingPolicy returns the packing policy of an instance pool.
+func (p *instancePools) packingPolicy(instancePoolTag string) string {
+	if p == nil {
+		return packingPolicyRandom
+	}
+	if policy := p.config.policy(instancePoolTag).PackingPolicy; policy != "" {
+		return policy
+	}
+	return packingPolicyRandom
+}
+
+// acquire waits for the queue of the instance pool to admit an operation, and returns the
+// function releasing it once served. The operation is rejected with ResourceExhausted if the
+// queue is full, and with the error of ctx if it is done first.
+func (p *instancePools) acquire(ctx context.Context, instancePoolTag string) (func(), error) {
+	if p == nil {
+		return func() {}, nil
+	}
+	q := p.queue(instancePoolTag)
+	if q == nil {
+		return func() {}, nil
+	}
+
+	p.mutex.Lock()
+	if q.policy.MaxQueuedOperations > 0 && q.queued >= q.policy.MaxQueuedOperations {
+		p.mutex.Unlock()
+		p.metricsManager.RecordInstancePoolRejectedOperation(instancePoolTag)
+		return nil, status.Errorf(codes.ResourceExhausted, "instance pool %q has %d operations queued, retry later", instancePoolTag, q.queued)
+	}
+	q.queued++
+	p.recordQueue(instancePoolTag, q)
+	p.mutex.Unlock()
+
+	var err error
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		err = status.FromContextError(ctx.Err()).Err()
+	}
+
+	p.mutex.Lock()
+	q.queued--
+	p.recordQueue(instancePoolTag, q)
+	p.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	klog.V(5).Infof("Admitted operation of instance pool %q, %d of %d slots used", instancePoolTag, len(q.slots), cap(q.slots))
+	return func() {
+		<-q.slots
+		p.mutex.Lock()
+		p.recordQueue(instancePoolTag, q)
+		p.mutex.Unlock()
+	}, nil
+}
+
+// queue returns the queue of an instance pool, nil if its operations are not limited.
+func (p *instancePools) queue(instancePoolTag string) *instancePoolQueue {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	q, ok := p.queues[instancePoolTag]
+	if !ok {
+		policy := p.config.policy(instancePoolTag)
+		if policy.MaxConcurrentOperations > 0 {
+			q = &instancePoolQueue{policy: policy, slots: make(chan struct{}, policy.MaxConcurrentOperations)}
+		}
+		p.queues[instancePoolTag] = q
+	}
+	return q
+}
+
+// recordQueue records the operations served and queued of an instance pool. Must be called
+// with the mutex held.
+func (p *instancePools) recordQueue(instancePoolTag string, q *instancePoolQueue) {
+	p.metricsManager.RecordInstancePoolOperations(instancePoolTag, len(q.slots), q.queued)
+}
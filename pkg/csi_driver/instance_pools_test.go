@@ -0,0 +1,198 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestLoadInstancePoolsConfig(t *testing.T) {
+	cases := []struct {
+		name      string
+		config    string
+		expectErr bool
+		expected  *InstancePoolsConfig
+	}{
+		{
+			name: "default and listed pools",
+			config: `
+default:
+  maxConcurrentOperations: 8
+instancePools:
+- instancePoolTag: tenant-a
+  maxConcurrentOperations: 4
+  maxQueuedOperations: 32
+  packingPolicy: spread
+`,
+			expected: &InstancePoolsConfig{
+				Default: InstancePoolPolicy{MaxConcurrentOperations: 8},
+				InstancePools: []InstancePoolPolicy{
+					{InstancePoolTag: "tenant-a", MaxConcurrentOperations: 4, MaxQueuedOperations: 32, PackingPolicy: packingPolicySpread},
+				},
+			},
+		},
+		{
+			name:      "unknown field",
+			config:    "default:\n  maxConcurrentOps: 8\n",
+			expectErr: true,
+		},
+		{
+			name:      "unknown packing policy",
+			config:    "instancePools:\n- instancePoolTag: tenant-a\n  packingPolicy: best-fit\n",
+			expectErr: true,
+		},
+		{
+			name:      "negative limit",
+			config:    "default:\n  maxQueuedOperations: -1\n",
+			expectErr: true,
+		},
+		{
+			name:      "pool listed twice",
+			config:    "instancePools:\n- instancePoolTag: tenant-a\n- instancePoolTag: tenant-a\n",
+			expectErr: true,
+		},
+		{
+			name:      "invalid instance pool tag",
+			config:    "instancePools:\n- instancePoolTag: Tenant.A\n",
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "instance-pools.yaml")
+			if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+			config, err := LoadInstancePoolsConfig(path)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got config %+v", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(config, tc.expected) {
+				t.Errorf("got config %+v, expected %+v", config, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInstancePoolsAcquire(t *testing.T) {
+	pools := newInstancePools(&InstancePoolsConfig{
+		Default: InstancePoolPolicy{MaxConcurrentOperations: 1},
+		InstancePools: []InstancePoolPolicy{
+			{InstancePoolTag: "noisy", MaxConcurrentOperations: 1, MaxQueuedOperations: 1},
+			{InstancePoolTag: "unlimited"},
+		},
+	}, nil)
+	ctx := context.Background()
+
+	release, err := pools.acquire(ctx, "noisy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The second operation of the pool waits for the first one.
+	admitted := make(chan func())
+	go func() {
+		release, err := pools.acquire(ctx, "noisy")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		admitted <- release
+	}()
+	queued := false
+	for i := 0; i < 100 && !queued; i++ {
+		time.Sleep(10 * time.Millisecond)
+		pools.mutex.Lock()
+		queued = pools.queues["noisy"].queued == 1
+		pools.mutex.Unlock()
+	}
+	if !queued {
+		t.Fatalf("second operation not queued")
+	}
+	// The third one is rejected, the queue being full.
+	if _, err := pools.acquire(ctx, "noisy"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got error %v, expected ResourceExhausted", err)
+	}
+
+	// The other pools are not held up by the noisy one.
+	for _, tag := range []string{"quiet", "unlimited", "unlimited"} {
+		if _, err := pools.acquire(ctx, tag); err != nil {
+			t.Errorf("unexpected error for pool %s: %v", tag, err)
+		}
+	}
+	// An operation waiting past its deadline fails.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := pools.acquire(timeoutCtx, "quiet"); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got error %v, expected DeadlineExceeded", err)
+	}
+
+	release()
+	select {
+	case release := <-admitted:
+		release()
+	case <-time.After(time.Second):
+		t.Fatalf("second operation not admitted after the first one was released")
+	}
+}
+
+func TestPickEligibleInstance(t *testing.T) {
+	var instances []*file.MultishareInstance
+	var shares []*file.Share
+	for i, shareCount := range []int{2, 0, 5} {
+		instance := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: fmt.Sprintf("instance-%d", i), CapacityBytes: util.Tb, State: "READY"}
+		instances = append(instances, instance)
+		for s := 0; s < shareCount; s++ {
+			shares = append(shares, &file.Share{Name: fmt.Sprintf("share-%d-%d", i, s), Parent: instance, CapacityBytes: 100 * util.Gb})
+		}
+	}
+	s, err := file.NewFakeServiceForMultishare(instances, shares, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, err := cloud.NewFakeCloudWithFiler(s, testProject, testLocation)
+	if err != nil {
+		t.Fatalf("failed to get cloud provider: %v", err)
+	}
+	m := NewMultishareOpsManager(cloudProvider, nil)
+
+	for policy, expected := range map[string]int{packingPolicyPack: 2, packingPolicySpread: 1} {
+		index, err := m.pickEligibleInstance(context.Background(), instances, policy, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if index != expected {
+			t.Errorf("got instance %d with packing policy %s, expected %d", index, policy, expected)
+		}
+	}
+}
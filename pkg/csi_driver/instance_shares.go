@@ -0,0 +1,270 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// paramParentInstance is the StorageClass parameter provisioning the volumes as additional
+	// file shares of an existing enterprise instance, referenced by the volume handle of its
+	// PV, e.g. modeInstance/us-central1/my-instance/vol1, instead of creating an instance per
+	// volume. Unlike the multishare volumes, the instance is neither resized nor deleted by
+	// the driver.
+	paramParentInstance = "parent-instance"
+
+	// modeInstanceShare is the provisioning mode of the volume IDs of the file shares of a
+	// parent instance, of form modeInstanceShare/{location}/{instanceName}/{share}.
+	modeInstanceShare = "modeInstanceShare"
+)
+
+// singleShareTiers are the tiers of the instances holding a single file share.
+var singleShareTiers = []string{defaultTier, premiumTier, basicHDDTier, basicSSDTier, highScaleTier, zonalTier}
+
+// isInstanceShareVolID returns true if the volume is a file share of a parent instance.
+func isInstanceShareVolID(volumeID string) bool {
+	return strings.HasPrefix(volumeID, modeInstanceShare+"/")
+}
+
+// parentInstance returns the parent instance referenced by the parameters of a volume, nil if
+// the volume gets its own instance. A single share tier set along the parent instance is
+// rejected, as its instances can't hold additional file shares.
+func parentInstance(params map[string]string, project string) (*file.MultishareInstance, error) {
+	var handle, tier string
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case paramParentInstance:
+			handle = v
+		case paramTier:
+			tier = v
+		}
+	}
+	if handle == "" {
+		return nil, nil
+	}
+	for _, t := range singleShareTiers {
+		if strings.EqualFold(tier, t) {
+			return nil, fmt.Errorf("%s instances hold a single file share, %s requires an %s instance", tier, paramParentInstance, enterpriseTier)
+		}
+	}
+	filer, mode, err := getFileInstanceFromID(handle)
+	if err != nil || mode != modeInstance {
+		return nil, fmt.Errorf("invalid %s %q, expected the volume handle of the PV of an instance, e.g. %s/<location>/<instance>/<share>", paramParentInstance, handle, modeInstance)
+	}
+	return &file.MultishareInstance{Project: project, Location: filer.Location, Name: filer.Name}, nil
+}
+
+// createInstanceShare provisions the volume of the request as a file share of its parent
+// instance. The instance must be an enterprise instance created with multiple shares enabled,
+// the Filestore API doesn't allow additional file shares on the others.
+func (s *controllerServer) createInstanceShare(ctx context.Context, req *csi.CreateVolumeRequest, parent *file.MultishareInstance) (*csi.CreateVolumeResponse, error) {
+	name := req.GetName()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolume name must be provided")
+	}
+	if err := s.config.driver.validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.GetVolumeContentSource() != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume content sources are not supported with %s", paramParentInstance)
+	}
+	if _, ok := req.GetSecrets()[secretKeyServiceAccountKey]; ok {
+		return nil, status.Errorf(codes.InvalidArgument, "provisioner secret credentials are not supported with %s", paramParentInstance)
+	}
+	capBytes, err := getShareRequestCapacity(req.GetCapacityRange(), util.MinShareSizeBytes, util.MaxShareSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	timeouts, err := s.config.opTimeouts.withParams(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	instance, err := s.config.fileService.GetMultishareInstance(ctx, parent)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s %s not found", paramParentInstance, parent)
+		}
+		return nil, file.StatusError(err)
+	}
+	if !strings.EqualFold(instance.Tier, enterpriseTier) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s %s of tier %s holds a single file share, %s requires an %s instance", paramParentInstance, instance, instance.Tier, paramParentInstance, enterpriseTier)
+	}
+	if instance.MaxShareCount <= 1 {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s %s was not created with multiple shares enabled, Filestore doesn't allow additional file shares on it", paramParentInstance, instance)
+	}
+
+	labels, err := extractLabels(nil, s.config.extraVolumeLabels, s.config.driver.config.Name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	share := &file.Share{
+		Name:           util.ConvertVolToShareName(name),
+		Parent:         instance,
+		MountPointName: util.ConvertVolToShareName(name),
+		CapacityBytes:  capBytes,
+		Labels:         labels,
+	}
+	volumeID := getInstanceShareVolumeID(share)
+	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, volumeOperationInProgressError(s.config.metricsManager, methodCreateVolume, volumeID)
+	}
+	defer s.config.volumeLocks.Release(volumeID)
+
+	existing, err := s.config.fileService.GetShare(ctx, share)
+	if err != nil && !file.IsNotFoundErr(err) {
+		return nil, file.StatusError(err)
+	}
+	if existing == nil {
+		klog.Infof("Creating share %s of %s %s for volume %s", share.Name, paramParentInstance, instance, name)
+		op, err := s.config.fileService.StartCreateShareOp(ctx, share)
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+		if err := s.waitForShareOp(withOpTimeout(ctx, timeouts, util.ShareCreate), op.Name, util.ShareCreate); err != nil {
+			return nil, file.StatusError(err)
+		}
+		if existing, err = s.config.fileService.GetShare(ctx, share); err != nil {
+			return nil, file.StatusError(err)
+		}
+	}
+	if existing.State != file.ShareStateReady {
+		return nil, status.Errorf(codes.Aborted, "share %s of %s %s not ready, state %s", existing.Name, paramParentInstance, instance, existing.State)
+	}
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: existing.CapacityBytes,
+			VolumeContext: map[string]string{
+				attrIP:             instance.Network.Ip,
+				attrVolume:         existing.Name,
+				attrTier:           enterpriseTier,
+				attrContextVersion: strconv.Itoa(volumeContextVersion),
+			},
+		},
+	}, nil
+}
+
+// deleteInstanceShare deletes the file share of a parent instance, keeping the instance.
+func (s *controllerServer) deleteInstanceShare(ctx context.Context, volumeID string) (*csi.DeleteVolumeResponse, error) {
+	share, err := getInstanceShareFromID(volumeID, s.config.cloud.Project)
+	if err != nil {
+		// An invalid ID should be treated as doesn't exist
+		klog.V(5).Infof("failed to get share for volume %v deletion: %v", volumeID, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, volumeOperationInProgressError(s.config.metricsManager, methodDeleteVolume, volumeID)
+	}
+	defer s.config.volumeLocks.Release(volumeID)
+
+	if _, err := s.config.fileService.GetShare(ctx, share); err != nil {
+		if file.IsNotFoundErr(err) {
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		return nil, file.StatusError(err)
+	}
+	op, err := s.config.fileService.StartDeleteShareOp(ctx, share)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		return nil, file.StatusError(err)
+	}
+	if err := s.waitForShareOp(ctx, op.Name, util.ShareDelete); err != nil {
+		return nil, file.StatusError(err)
+	}
+	klog.Infof("DeleteVolume succeeded for volume %v", volumeID)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// expandInstanceShare expands the file share of a parent instance, within the free capacity
+// of the instance, which is not expanded.
+func (s *controllerServer) expandInstanceShare(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	share, err := getInstanceShareFromID(volumeID, s.config.cloud.Project)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	reqBytes, err := getShareRequestCapacity(req.GetCapacityRange(), util.MinShareSizeBytes, util.MaxShareSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, volumeOperationInProgressError(s.config.metricsManager, methodExpandVolume, volumeID)
+	}
+	defer s.config.volumeLocks.Release(volumeID)
+
+	share, err = s.config.fileService.GetShare(ctx, share)
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	if share.CapacityBytes < reqBytes {
+		share.CapacityBytes = reqBytes
+		op, err := s.config.fileService.StartResizeShareOp(ctx, share)
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+		if err := s.waitForShareOp(ctx, op.Name, util.ShareUpdate); err != nil {
+			return nil, file.StatusError(err)
+		}
+	}
+	klog.Infof("Controller expand volume succeeded for volume %v, new size(bytes): %v", volumeID, share.CapacityBytes)
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: share.CapacityBytes}, nil
+}
+
+func (s *controllerServer) waitForShareOp(ctx context.Context, opName string, opType util.OperationType) error {
+	timeout, pollInterval, err := util.GetMultishareOpsTimeoutConfig(opType)
+	if err != nil {
+		return err
+	}
+	if t := s.config.opTimeouts.forOp(opType); t > 0 {
+		timeout = t
+	}
+	return s.config.fileService.WaitForOpWithOpts(ctx, opName, file.PollOpts{Timeout: file.OpWaitTimeout(ctx, timeout), Interval: pollInterval})
+}
+
+// getInstanceShareVolumeID returns the volume ID of a file share of a parent instance.
+func getInstanceShareVolumeID(share *file.Share) string {
+	return strings.Join([]string{modeInstanceShare, share.Parent.Location, share.Parent.Name, share.Name}, "/")
+}
+
+// getInstanceShareFromID returns the file share of a parent instance from its volume ID.
+func getInstanceShareFromID(volumeID, project string) (*file.Share, error) {
+	filer, mode, err := getFileInstanceFromID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if mode != modeInstanceShare || filer.Location == "" || filer.Name == "" || filer.Volume.Name == "" {
+		return nil, fmt.Errorf("volume id %q is not the ID of a file share of a %s", volumeID, paramParentInstance)
+	}
+	return &file.Share{
+		Name:   filer.Volume.Name,
+		Parent: &file.MultishareInstance{Project: project, Location: filer.Location, Name: filer.Name},
+	}, nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestParentInstance(t *testing.T) {
+	cases := []struct {
+		name      string
+		params    map[string]string
+		expected  *file.MultishareInstance
+		expectErr bool
+	}{
+		{
+			name: "no parent instance",
+		},
+		{
+			name:     "parent instance",
+			params:   map[string]string{paramParentInstance: "modeInstance/us-central1/parent/vol1", paramTier: enterpriseTier},
+			expected: &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "parent"},
+		},
+		{
+			name:      "basic tier",
+			params:    map[string]string{paramParentInstance: "modeInstance/us-central1-c/parent/vol1", paramTier: basicHDDTier},
+			expectErr: true,
+		},
+		{
+			name:      "multishare volume handle",
+			params:    map[string]string{paramParentInstance: "modeMultishare/prefix/test-project/us-central1/parent/share"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			parent, err := parentInstance(tc.params, testProject)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if !reflect.DeepEqual(parent, tc.expected) {
+				t.Errorf("got parent instance %+v, expected %+v", parent, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInstanceShareLifecycle(t *testing.T) {
+	instances := []*file.MultishareInstance{
+		{Project: testProject, Location: testRegion, Name: "parent", Tier: "ENTERPRISE", State: "READY", CapacityBytes: 1 * util.Tb, MaxShareCount: 10, Network: file.Network{Ip: "1.1.1.1"}},
+		{Project: testProject, Location: testRegion, Name: "single-share", Tier: "ENTERPRISE", State: "READY", CapacityBytes: 1 * util.Tb, MaxShareCount: 1},
+		{Project: testProject, Location: testLocation, Name: "basic", Tier: "BASIC_HDD", State: "READY", CapacityBytes: 1 * util.Tb},
+	}
+	fileService, err := file.NewFakeServiceForMultishare(instances, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	cs := initTestController(t).(*controllerServer)
+	cs.config.fileService = fileService
+
+	createReq := func(handle string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:               testCSIVolume,
+			Parameters:         map[string]string{paramParentInstance: handle},
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: 100 * util.Gb},
+			VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		}
+	}
+	for handle, expectedCode := range map[string]codes.Code{
+		"modeInstance/us-central1/single-share/vol1": codes.FailedPrecondition,
+		"modeInstance/us-central1-c/basic/vol1":      codes.InvalidArgument,
+		"modeInstance/us-central1/missing/vol1":      codes.InvalidArgument,
+	} {
+		if _, err := cs.CreateVolume(context.Background(), createReq(handle)); status.Code(err) != expectedCode {
+			t.Errorf("parent instance %s: got error %v, expected code %v", handle, err, expectedCode)
+		}
+	}
+
+	resp, err := cs.CreateVolume(context.Background(), createReq("modeInstance/us-central1/parent/vol1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	shareName := util.ConvertVolToShareName(testCSIVolume)
+	expectedID := "modeInstanceShare/us-central1/parent/" + shareName
+	if resp.Volume.VolumeId != expectedID || resp.Volume.CapacityBytes != 100*util.Gb {
+		t.Fatalf("got volume %+v, expected ID %s of 100Gb", resp.Volume, expectedID)
+	}
+	if resp.Volume.VolumeContext[attrIP] != "1.1.1.1" || resp.Volume.VolumeContext[attrVolume] != shareName {
+		t.Errorf("got volume context %v, expected the IP of the parent instance and share %s", resp.Volume.VolumeContext, shareName)
+	}
+	// The request is idempotent.
+	if _, err := cs.CreateVolume(context.Background(), createReq("modeInstance/us-central1/parent/vol1")); err != nil {
+		t.Fatalf("unexpected error of a retried request: %v", err)
+	}
+
+	expandResp, err := cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      expectedID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 200 * util.Gb},
+	})
+	if err != nil {
+		t.Fatalf("unexpected expansion error: %v", err)
+	}
+	if expandResp.CapacityBytes != 200*util.Gb {
+		t.Errorf("got capacity %d, expected %d", expandResp.CapacityBytes, 200*util.Gb)
+	}
+
+	if _, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snapshot", SourceVolumeId: expectedID}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got snapshot error %v, expected code %v", err, codes.InvalidArgument)
+	}
+
+	if _, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: expectedID}); err != nil {
+		t.Fatalf("unexpected deletion error: %v", err)
+	}
+	parent := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "parent"}
+	if _, err := fileService.GetShare(context.Background(), &file.Share{Parent: parent, Name: shareName}); !file.IsNotFoundErr(err) {
+		t.Errorf("got error %v, expected the share deleted", err)
+	}
+	if _, err := fileService.GetMultishareInstance(context.Background(), parent); err != nil {
+		t.Errorf("got error %v, expected the parent instance kept", err)
+	}
+	// The share is already deleted.
+	if _, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: expectedID}); err != nil {
+		t.Fatalf("unexpected error deleting a deleted share: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// keytabHeader is the header of the version 2 keytabs, the format written by the MIT and
+// Heimdal Kerberos tools. The version 1 keytabs use the native byte order and are not
+// supported.
+var keytabHeader = []byte{0x05, 0x02}
+
+// keytabEntry is an entry of a keytab, the key of a principal for a key version and
+// encryption type.
+type keytabEntry struct {
+	// id is the principal, key version and encryption type of the entry.
+	id  string
+	key []byte
+	// raw is the entry as stored in the keytab, without its length.
+	raw []byte
+}
+
+// parseKeytab returns the entries of a version 2 keytab, skipping the holes left by the
+// removed entries.
+func parseKeytab(data []byte) ([]*keytabEntry, error) {
+	if !bytes.HasPrefix(data, keytabHeader) {
+		return nil, fmt.Errorf("not a version 2 keytab")
+	}
+	var entries []*keytabEntry
+	r := bytes.NewReader(data[len(keytabHeader):])
+	for r.Len() > 0 {
+		var length int32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("truncated keytab: %w", err)
+		}
+		if length == 0 {
+			break
+		}
+		size := length
+		if size < 0 {
+			size = -size
+		}
+		if int64(size) > int64(r.Len()) {
+			return nil, fmt.Errorf("truncated keytab entry")
+		}
+		raw := make([]byte, size)
+		r.Read(raw)
+		if length < 0 {
+			continue
+		}
+		entry, err := parseKeytabEntry(raw)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseKeytabEntry(raw []byte) (*keytabEntry, error) {
+	r := bytes.NewReader(raw)
+	readString := func() (string, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return "", err
+		}
+		if int(n) > r.Len() {
+			return "", fmt.Errorf("string of %d bytes overflows the entry", n)
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		return string(b), nil
+	}
+	var components uint16
+	if err := binary.Read(r, binary.BigEndian, &components); err != nil {
+		return nil, fmt.Errorf("invalid keytab entry: %w", err)
+	}
+	realm, err := readString()
+	if err != nil {
+		return nil, fmt.Errorf("invalid keytab entry realm: %w", err)
+	}
+	names := make([]string, 0, components)
+	for i := 0; i < int(components); i++ {
+		name, err := readString()
+		if err != nil {
+			return nil, fmt.Errorf("invalid keytab entry principal: %w", err)
+		}
+		names = append(names, name)
+	}
+	var header struct {
+		NameType  uint32
+		Timestamp uint32
+		Vno8      uint8
+		KeyType   uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("invalid keytab entry: %w", err)
+	}
+	key, err := readString()
+	if err != nil {
+		return nil, fmt.Errorf("invalid keytab entry key: %w", err)
+	}
+	// The 32 bits key version, if any, supersedes the 8 bits one.
+	vno := uint32(header.Vno8)
+	if r.Len() >= 4 {
+		var vno32 uint32
+		binary.Read(r, binary.BigEndian, &vno32)
+		if vno32 != 0 {
+			vno = vno32
+		}
+	}
+	principal := strings.Join(names, "/") + "@" + realm
+	return &keytabEntry{
+		id:  fmt.Sprintf("%s kvno %d enctype %d", principal, vno, header.KeyType),
+		key: []byte(key),
+		raw: raw,
+	}, nil
+}
+
+// mergeKeytabs returns the keytab of the entries of current and of the entries of added it
+// lacks, and whether it differs from current. An entry of added for the principal, key version
+// and encryption type of an entry of current with another key is a conflict, as a volume
+// staged on the node relies on the key of current.
+func mergeKeytabs(current, added []byte) ([]byte, bool, error) {
+	addedEntries, err := parseKeytab(added)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s: %w", secretKeyKeytab, err)
+	}
+	var entries []*keytabEntry
+	if len(current) > 0 {
+		if entries, err = parseKeytab(current); err != nil {
+			return nil, false, fmt.Errorf("invalid keytab of the node: %w", err)
+		}
+	}
+	keys := make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		keys[e.id] = e.key
+	}
+	changed := false
+	for _, e := range addedEntries {
+		key, ok := keys[e.id]
+		if ok && !bytes.Equal(key, e.key) {
+			return nil, false, fmt.Errorf("the key of %s conflicts with the keytab of the node", e.id)
+		}
+		if ok {
+			continue
+		}
+		keys[e.id] = e.key
+		entries = append(entries, e)
+		changed = true
+	}
+	if !changed {
+		return current, false, nil
+	}
+	var b bytes.Buffer
+	b.Write(keytabHeader)
+	for _, e := range entries {
+		binary.Write(&b, binary.BigEndian, int32(len(e.raw)))
+		b.Write(e.raw)
+	}
+	return b.Bytes(), true, nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testKeytabEntry is a key of a principal, e.g. nfs/host@REALM, in a test keytab.
+type testKeytabEntry struct {
+	principal string
+	vno       uint32
+	keyType   uint16
+	key       string
+}
+
+// testKeytab returns a version 2 keytab of the entries, with a hole before the first one.
+func testKeytab(entries ...testKeytabEntry) []byte {
+	var b bytes.Buffer
+	b.Write(keytabHeader)
+	binary.Write(&b, binary.BigEndian, int32(-3))
+	b.Write([]byte{0, 0, 0})
+	writeString := func(w *bytes.Buffer, s string) {
+		binary.Write(w, binary.BigEndian, uint16(len(s)))
+		w.WriteString(s)
+	}
+	for _, e := range entries {
+		var entry bytes.Buffer
+		name, realm, _ := strings.Cut(e.principal, "@")
+		components := strings.Split(name, "/")
+		binary.Write(&entry, binary.BigEndian, uint16(len(components)))
+		writeString(&entry, realm)
+		for _, c := range components {
+			writeString(&entry, c)
+		}
+		binary.Write(&entry, binary.BigEndian, uint32(1))
+		binary.Write(&entry, binary.BigEndian, uint32(1700000000))
+		entry.WriteByte(uint8(e.vno))
+		binary.Write(&entry, binary.BigEndian, e.keyType)
+		writeString(&entry, e.key)
+		binary.Write(&entry, binary.BigEndian, e.vno)
+		binary.Write(&b, binary.BigEndian, int32(entry.Len()))
+		b.Write(entry.Bytes())
+	}
+	return b.Bytes()
+}
+
+func keytabIDs(t *testing.T, keytab []byte) []string {
+	entries, err := parseKeytab(keytab)
+	if err != nil {
+		t.Fatalf("failed to parse keytab: %v", err)
+	}
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+func TestMergeKeytabs(t *testing.T) {
+	hostA := testKeytabEntry{principal: "nfs/node-a@EXAMPLE.COM", vno: 2, keyType: 18, key: "key-a"}
+	hostB := testKeytabEntry{principal: "nfs/node-b@EXAMPLE.COM", vno: 300, keyType: 18, key: "key-b"}
+	hostARotated := testKeytabEntry{principal: "nfs/node-a@EXAMPLE.COM", vno: 3, keyType: 18, key: "key-a-3"}
+	hostAOtherKey := testKeytabEntry{principal: "nfs/node-a@EXAMPLE.COM", vno: 2, keyType: 18, key: "stolen"}
+	cases := []struct {
+		name        string
+		current     []byte
+		added       []byte
+		expectedIDs []string
+		changed     bool
+		errorExp    bool
+	}{
+		{
+			name:        "no keytab on the node",
+			added:       testKeytab(hostA),
+			expectedIDs: []string{"nfs/node-a@EXAMPLE.COM kvno 2 enctype 18"},
+			changed:     true,
+		},
+		{
+			name:        "same keytab",
+			current:     testKeytab(hostA),
+			added:       testKeytab(hostA),
+			expectedIDs: []string{"nfs/node-a@EXAMPLE.COM kvno 2 enctype 18"},
+		},
+		{
+			name:    "other principal",
+			current: testKeytab(hostA),
+			added:   testKeytab(hostB, hostA),
+			expectedIDs: []string{
+				"nfs/node-a@EXAMPLE.COM kvno 2 enctype 18",
+				"nfs/node-b@EXAMPLE.COM kvno 300 enctype 18",
+			},
+			changed: true,
+		},
+		{
+			name:    "new key version",
+			current: testKeytab(hostA),
+			added:   testKeytab(hostARotated),
+			expectedIDs: []string{
+				"nfs/node-a@EXAMPLE.COM kvno 2 enctype 18",
+				"nfs/node-a@EXAMPLE.COM kvno 3 enctype 18",
+			},
+			changed: true,
+		},
+		{
+			name:     "conflicting key",
+			current:  testKeytab(hostA, hostB),
+			added:    testKeytab(hostAOtherKey),
+			errorExp: true,
+		},
+		{
+			name:     "invalid keytab",
+			current:  testKeytab(hostA),
+			added:    []byte("keytab"),
+			errorExp: true,
+		},
+		{
+			name:     "invalid keytab of the node",
+			current:  []byte("keytab"),
+			added:    testKeytab(hostA),
+			errorExp: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, changed, err := mergeKeytabs(tc.current, tc.added)
+			if tc.errorExp {
+				if err == nil {
+					t.Errorf("expected error, got keytab %v", keytabIDs(t, merged))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed != tc.changed {
+				t.Errorf("got changed %v, expected %v", changed, tc.changed)
+			}
+			if ids := keytabIDs(t, merged); !reflect.DeepEqual(ids, tc.expectedIDs) {
+				t.Errorf("got keytab entries %v, expected %v", ids, tc.expectedIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// kmsKeyNamePrefixes are the prefixes of the full resource names and URLs of the Cloud KMS keys,
+// stripped from the instance-encryption-kms-key parameter.
+var kmsKeyNamePrefixes = []string{"//cloudkms.googleapis.com/", "https://cloudkms.googleapis.com/v1/"}
+
+// normalizeKmsKeyName returns the relative resource name of the Cloud KMS key of the
+// instance-encryption-kms-key parameter, projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>,
+// and checks that the key is in the region of an instance of the given zone or region. The
+// Filestore API only accepts the keys of the region of the instance, and fails the creation
+// of the instances with other keys once the operation is started.
+func normalizeKmsKeyName(name, instanceLocation string) (string, error) {
+	normalized := strings.TrimSpace(name)
+	for _, prefix := range kmsKeyNamePrefixes {
+		normalized = strings.TrimPrefix(normalized, prefix)
+	}
+	normalized = strings.Trim(normalized, "/")
+	segments := strings.Split(normalized, "/")
+	if len(segments) == 10 && segments[8] == "cryptoKeyVersions" {
+		return "", fmt.Errorf("%s %q is a key version, expected the key %s", ParamInstanceEncryptionKmsKey, name, strings.Join(segments[:8], "/"))
+	}
+	valid := len(segments) == 8 && segments[0] == "projects" && segments[2] == "locations" && segments[4] == "keyRings" && segments[6] == "cryptoKeys"
+	for i := 1; valid && i < len(segments); i += 2 {
+		valid = segments[i] != ""
+	}
+	if !valid {
+		return "", fmt.Errorf("invalid %s %q, expected projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>", ParamInstanceEncryptionKmsKey, name)
+	}
+	segments[3] = strings.ToLower(segments[3])
+
+	region := instanceLocation
+	if strings.Count(instanceLocation, "-") == 2 {
+		var err error
+		if region, err = util.GetRegionFromZone(instanceLocation); err != nil {
+			return "", err
+		}
+	}
+	if segments[3] != region {
+		return "", fmt.Errorf("%s %q is in location %s, but the instance is in region %s: the key must be in the region of the instance, e.g. projects/%s/locations/%s/keyRings/<key ring>/cryptoKeys/<key>", ParamInstanceEncryptionKmsKey, name, segments[3], region, segments[1], region)
+	}
+	return strings.Join(segments, "/"), nil
+}
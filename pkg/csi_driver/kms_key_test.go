@@ -0,0 +1,128 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestNormalizeKmsKeyName(t *testing.T) {
+	cases := []struct {
+		name          string
+		key           string
+		location      string
+		expected      string
+		expectErrText string
+	}{
+		{
+			name:     "regional instance",
+			key:      testKmsKey,
+			location: "us-central1",
+			expected: testKmsKey,
+		},
+		{
+			name:     "zonal instance",
+			key:      testKmsKey,
+			location: "us-central1-c",
+			expected: testKmsKey,
+		},
+		{
+			name:     "full resource name",
+			key:      " //cloudkms.googleapis.com/projects/test-project/locations/US-CENTRAL1/keyRings/ring/cryptoKeys/key/",
+			location: "us-central1",
+			expected: testKmsKey,
+		},
+		{
+			name:     "URL",
+			key:      "https://cloudkms.googleapis.com/v1/" + testKmsKey,
+			location: "us-central1",
+			expected: testKmsKey,
+		},
+		{
+			name:          "other region",
+			key:           testKmsKey,
+			location:      "europe-west1-b",
+			expectErrText: "is in location us-central1, but the instance is in region europe-west1",
+		},
+		{
+			name:          "global key",
+			key:           "projects/test-project/locations/global/keyRings/ring/cryptoKeys/key",
+			location:      "us-central1",
+			expectErrText: "is in location global",
+		},
+		{
+			name:          "key version",
+			key:           testKmsKey + "/cryptoKeyVersions/1",
+			location:      "us-central1",
+			expectErrText: "is a key version, expected the key " + testKmsKey,
+		},
+		{
+			name:          "key ID only",
+			key:           "key",
+			location:      "us-central1",
+			expectErrText: "invalid",
+		},
+		{
+			name:          "empty key ring",
+			key:           "projects/test-project/locations/us-central1/keyRings//cryptoKeys/key",
+			location:      "us-central1",
+			expectErrText: "invalid",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := normalizeKmsKeyName(tc.key, tc.location)
+			if tc.expectErrText != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErrText) {
+					t.Fatalf("got error %v, expected %q", err, tc.expectErrText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("got key %q, expected %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeKmsKeyRegionMismatch(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		Parameters: map[string]string{
+			paramTier:                     enterpriseTier,
+			ParamInstanceEncryptionKmsKey: "projects/test-project/locations/europe-west1/keyRings/ring/cryptoKeys/key",
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "europe-west1") {
+		t.Fatalf("got error %v, expected InvalidArgument spelling out the region mismatch", err)
+	}
+	if _, err := cs.config.fileService.GetInstance(context.Background(), &file.ServiceInstance{Project: testProject, Location: testRegion, Name: testCSIVolume}); err == nil {
+		t.Errorf("instance created with the key of another region")
+	}
+}
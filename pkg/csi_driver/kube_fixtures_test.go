@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// testPV returns a PV of the CSI driver and volume handle, bound to the claim of the default
+// namespace, if any.
+func testPV(name, driver, volumeHandle, claimName string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:       driver,
+					VolumeHandle: volumeHandle,
+				},
+			},
+		},
+	}
+	if claimName != "" {
+		pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: "default", Name: claimName}
+	}
+	return pv
+}
+
+// testClaim returns a PVC of the default namespace bound to the PV volumeName.
+func testClaim(name, volumeName string, annotations, labels map[string]string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID(name + "-uid"),
+			Annotations: annotations,
+			Labels:      labels,
+		},
+		Spec:   v1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+		Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+}
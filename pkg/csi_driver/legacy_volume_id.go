@@ -0,0 +1,105 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+// LegacyProvisionerName is the name of the legacy gcp-filestore provisioner, recorded in the
+// pv.kubernetes.io/provisioned-by annotation of the PVs it provisioned.
+const LegacyProvisionerName = "gcp-filestore"
+
+// legacyInstanceNamePattern matches the volume handles of the legacy provisioner holding the
+// resource name of the instance, e.g. projects/p/locations/us-central1-c/instances/pvc-a.
+var legacyInstanceNamePattern = regexp.MustCompile(`^projects/([^/]+)/locations/([^/]+)/instances/([^/]+)$`)
+
+// legacyVolumeID is a parsed volume handle of the legacy provisioner. Share is empty for the
+// handles holding the resource name of the instance, the file share of the instance is then
+// looked up.
+type legacyVolumeID struct {
+	project  string
+	location string
+	instance string
+	share    string
+}
+
+// parseLegacyVolumeID parses the volume handles of the legacy provisioner:
+//   - projects/{project}/locations/{location}/instances/{instance}, the resource name of the
+//     instance;
+//   - {location}/{instance}/{share}, the instance mode volume ID without provisioning mode.
+//
+// It returns false for the other handles, including the volume IDs of the driver.
+func parseLegacyVolumeID(volumeID string) (*legacyVolumeID, bool) {
+	if m := legacyInstanceNamePattern.FindStringSubmatch(volumeID); m != nil {
+		return &legacyVolumeID{project: m[1], location: m[2], instance: m[3]}, true
+	}
+	tokens := strings.Split(volumeID, "/")
+	if len(tokens) != totalIDElements-1 || isMultishareVolId(volumeID) {
+		return nil, false
+	}
+	for _, t := range tokens {
+		if t == "" {
+			return nil, false
+		}
+	}
+	return &legacyVolumeID{location: tokens[0], instance: tokens[1], share: tokens[2]}, true
+}
+
+// translateLegacyVolumeID returns the instance mode volume ID of a volume handle of the legacy
+// provisioner, so that the PVs it provisioned are deleted and expanded by the driver in place,
+// or the volume ID unchanged if it is not a legacy handle. The instances of the legacy handles
+// of another project than the one of the driver can't be served, as the instance mode volume
+// IDs don't record their project.
+func (s *controllerServer) translateLegacyVolumeID(ctx context.Context, volumeID string) (string, error) {
+	legacy, ok := parseLegacyVolumeID(volumeID)
+	if !ok {
+		return volumeID, nil
+	}
+	project := s.config.cloud.Project
+	if legacy.project != "" && legacy.project != project {
+		return "", status.Errorf(codes.FailedPrecondition, "legacy volume %s is in project %s, the driver manages the instances of project %s", volumeID, legacy.project, project)
+	}
+	share := legacy.share
+	if share == "" {
+		instance, err := s.config.fileService.GetInstance(ctx, &file.ServiceInstance{Project: project, Location: legacy.location, Name: legacy.instance})
+		if file.IsNotFoundErr(err) {
+			return "", status.Errorf(codes.NotFound, "instance of legacy volume %s not found", volumeID)
+		}
+		if err != nil {
+			return "", file.StatusError(fmt.Errorf("failed to look up the file share of legacy volume %s: %w", volumeID, err))
+		}
+		share = instance.Volume.Name
+	}
+	translated := strings.Join([]string{modeInstance, legacy.location, legacy.instance, share}, "/")
+	klog.V(4).Infof("Translated legacy volume handle %s to volume ID %s", volumeID, translated)
+	return translated, nil
+}
+
+// IsLegacyVolumeHandle returns true if the volume handle was created by the legacy provisioner.
+func IsLegacyVolumeHandle(handle string) bool {
+	_, ok := parseLegacyVolumeID(handle)
+	return ok
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestParseLegacyVolumeID(t *testing.T) {
+	cases := []struct {
+		volumeID string
+		expected *legacyVolumeID
+	}{
+		{
+			volumeID: "projects/test-project/locations/us-central1-c/instances/pvc-a",
+			expected: &legacyVolumeID{project: testProject, location: testZone, instance: "pvc-a"},
+		},
+		{
+			volumeID: "us-central1-c/pvc-a/vol1",
+			expected: &legacyVolumeID{location: testZone, instance: "pvc-a", share: "vol1"},
+		},
+		{volumeID: testVolumeID},
+		{volumeID: "modeMultishare/sc-a/test-project/us-central1/fs-a/pvc-a"},
+		{volumeID: "us-central1-c//vol1"},
+		{volumeID: "projects/test-project/locations/us-central1-c/instances/pvc-a/shares/vol1"},
+	}
+	for _, tc := range cases {
+		legacy, ok := parseLegacyVolumeID(tc.volumeID)
+		if ok != (tc.expected != nil) || !reflect.DeepEqual(legacy, tc.expected) {
+			t.Errorf("%s: got %+v, %t, expected %+v", tc.volumeID, legacy, ok, tc.expected)
+		}
+	}
+}
+
+func TestLegacyVolumeDeleteExpand(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	_, err := cs.config.fileService.CreateInstance(context.Background(), &file.ServiceInstance{
+		Project:  testProject,
+		Location: testZone,
+		Name:     "pvc-legacy",
+		Tier:     defaultTier,
+		Volume:   file.Volume{Name: "vol1", SizeBytes: 1 * util.Tb},
+	})
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+
+	translated, err := cs.translateLegacyVolumeID(context.Background(), "projects/test-project/locations/us-central1-c/instances/pvc-legacy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "modeInstance/us-central1-c/pvc-legacy/vol1"; translated != expected {
+		t.Errorf("got volume ID %s, expected %s", translated, expected)
+	}
+	if _, err := cs.translateLegacyVolumeID(context.Background(), "projects/other-project/locations/us-central1-c/instances/pvc-legacy"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v, expected code %v for another project", err, codes.FailedPrecondition)
+	}
+
+	resp, err := cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "us-central1-c/pvc-legacy/vol1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * util.Tb},
+	})
+	if err != nil {
+		t.Fatalf("unexpected expansion error: %v", err)
+	}
+	if resp.CapacityBytes != 2*util.Tb {
+		t.Errorf("got capacity %d, expected %d", resp.CapacityBytes, 2*util.Tb)
+	}
+
+	for _, volumeID := range []string{
+		"projects/test-project/locations/us-central1-c/instances/pvc-legacy",
+		"projects/test-project/locations/us-central1-c/instances/pvc-gone",
+	} {
+		if _, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+			t.Errorf("%s: unexpected deletion error: %v", volumeID, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+// validateLocation returns an InvalidArgument error if location, picked from the allowed
+// topologies of the StorageClass, is not a Filestore location of the project, e.g. a typo'd
+// zone or region, so that CreateVolume fails right away rather than on the rejected instance
+// creation. The location is not validated if the Filestore locations can't be listed.
+func validateLocation(ctx context.Context, fileService file.Service, project, location string) error {
+	locations, err := fileService.ListLocations(ctx, project)
+	if err != nil {
+		klog.Warningf("Failed to list the Filestore locations of project %s, not validating location %s: %v", project, location, err)
+		return nil
+	}
+	if len(locations) == 0 {
+		return nil
+	}
+	for _, l := range locations {
+		if l == location {
+			return nil
+		}
+	}
+	return status.Errorf(codes.InvalidArgument, "location %q is not a Filestore location of project %s", location, project)
+}
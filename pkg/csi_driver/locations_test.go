@@ -0,0 +1,76 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+type failingLocationsService struct {
+	file.Service
+}
+
+func (s *failingLocationsService) ListLocations(ctx context.Context, project string) ([]string, error) {
+	return nil, fmt.Errorf("locations unavailable")
+}
+
+func TestValidateLocation(t *testing.T) {
+	fileService, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	cases := []struct {
+		name         string
+		service      file.Service
+		location     string
+		expectedCode codes.Code
+	}{
+		{name: "zone", service: fileService, location: testLocation},
+		{name: "region", service: fileService, location: testRegion},
+		{name: "typo'd zone", service: fileService, location: "us-centrall-c", expectedCode: codes.InvalidArgument},
+		{name: "locations unavailable", service: &failingLocationsService{fileService}, location: "us-centrall-c"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateLocation(context.Background(), tc.service, testProject, tc.location)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("got error %v, expected code %v", err, tc.expectedCode)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeInvalidLocation(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	req := &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{TopologyKeyZone: "us-centrall-c"}}},
+		},
+	}
+	if _, err := cs.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, expected code %v", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// mountLimiter bounds the NFS mounts run in parallel by the node driver, so that a mount
+// storm, e.g. hundreds of pods scheduled at once on a replaced node, doesn't overwhelm the
+// NFS client and rpcbind of the node. The mounts over the limit wait in line for at most
+// timeout, after which their NodeStageVolume call fails and is retried by the kubelet.
+type mountLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// newMountLimiter returns a limiter of maxConcurrent mounts, or nil, which does not limit the
+// mounts, if maxConcurrent is not positive.
+func newMountLimiter(maxConcurrent int, timeout time.Duration) *mountLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &mountLimiter{
+		slots:   make(chan struct{}, maxConcurrent),
+		timeout: timeout,
+	}
+}
+
+// acquire waits for a mount slot for the volume, until the timeout or ctx expires. The slot
+// must be released once the mount returns.
+func (l *mountLimiter) acquire(ctx context.Context, volumeID string) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	klog.V(4).Infof("Mount of volume %s waiting for one of the %d concurrent mounts to complete", volumeID, cap(l.slots))
+	start := time.Now()
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+	select {
+	case l.slots <- struct{}{}:
+		klog.V(4).Infof("Mount of volume %s waited %v", volumeID, time.Since(start))
+		return nil
+	case <-ctx.Done():
+		return status.Errorf(codes.ResourceExhausted, "mount of volume %s waited %v for one of the %d concurrent mounts of the node to complete: %v", volumeID, time.Since(start).Round(time.Millisecond), cap(l.slots), ctx.Err())
+	}
+}
+
+// release frees a mount slot acquired by acquire.
+func (l *mountLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
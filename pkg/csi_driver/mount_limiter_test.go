@@ -0,0 +1,71 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMountLimiter(t *testing.T) {
+	if l := newMountLimiter(0, time.Minute); l != nil {
+		t.Fatalf("got limiter %+v, expected no limit", l)
+	}
+	var unlimited *mountLimiter
+	if err := unlimited.acquire(context.Background(), "vol0"); err != nil {
+		t.Fatalf("unexpected error of an unlimited mount: %v", err)
+	}
+	unlimited.release()
+
+	l := newMountLimiter(2, 50*time.Millisecond)
+	for _, volumeID := range []string{"vol1", "vol2"} {
+		if err := l.acquire(context.Background(), volumeID); err != nil {
+			t.Fatalf("unexpected error of mount %s: %v", volumeID, err)
+		}
+	}
+	// The third mount times out while the first two are running.
+	if err := l.acquire(context.Background(), "vol3"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got error %v, expected code %v", err, codes.ResourceExhausted)
+	}
+
+	// A waiting mount proceeds once a running mount completes.
+	l.timeout = time.Minute
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(context.Background(), "vol4")
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("mount acquired a slot while the limit is reached: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("unexpected error of a waiting mount: %v", err)
+	}
+
+	// A canceled NodeStageVolume call stops waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.acquire(ctx, "vol5"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got error %v, expected code %v", err, codes.ResourceExhausted)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+const (
+	// paramMountPolicy is the StorageClass parameter setting the NFS mount policy of the
+	// volumes, and attrMountPolicy the volume attribute passing it to the node driver, also set
+	// on pre-provisioned PVs.
+	paramMountPolicy = "mount-policy"
+	attrMountPolicy  = "mountPolicy"
+	// paramSoftMountTimeo and attrSoftMountTimeo set the timeo of the soft mounts, in tenths of
+	// a second, after which an NFS request is retransmitted, and failed after its retransmissions.
+	paramSoftMountTimeo = "soft-mount-timeo"
+	attrSoftMountTimeo  = "softMountTimeo"
+
+	mountPolicyHard = "hard"
+	mountPolicySoft = "soft"
+
+	// defaultSoftMountTimeo is the NFS default timeo over TCP, 60 seconds.
+	defaultSoftMountTimeo = 600
+)
+
+// softMountForbiddenAccessModes are the access modes of the volumes which cannot be soft
+// mounted: the writes of one writer failing while those of the others succeed corrupt the data
+// they share.
+var softMountForbiddenAccessModes = map[csi.VolumeCapability_AccessMode_Mode]bool{
+	csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:  true,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER: true,
+}
+
+// conflictingMountFlags are the mount flags of the volume capabilities, i.e. the mountOptions
+// of the StorageClass or PV, conflicting with the mount policy of a volume.
+var conflictingMountFlags = []string{"hard", "soft", "softerr", "timeo="}
+
+// mountPolicyVolumeContext validates the mount policy parameters of CreateVolume and returns
+// the volume attributes passing them to the node driver, none for the default hard mounts.
+func mountPolicyVolumeContext(params map[string]string, caps []*csi.VolumeCapability, allowSoftMounts bool) (map[string]string, error) {
+	attr := make(map[string]string)
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case paramMountPolicy:
+			attr[attrMountPolicy] = strings.ToLower(v)
+		case paramSoftMountTimeo:
+			attr[attrSoftMountTimeo] = v
+		}
+	}
+	if len(attr) == 0 {
+		return nil, nil
+	}
+	if _, err := mountPolicyOptions(attr, caps, allowSoftMounts); err != nil {
+		return nil, err
+	}
+	return attr, nil
+}
+
+// mountPolicyOptions returns the NFS mount options of the mount policy of a volume with the
+// given attributes and capabilities. Soft mounts are refused unless allowSoftMounts, the
+// --allow-soft-mounts flag acknowledging their data integrity risk, is set.
+func mountPolicyOptions(attr map[string]string, caps []*csi.VolumeCapability, allowSoftMounts bool) ([]string, error) {
+	policy, ok := attr[attrMountPolicy]
+	timeo, timeoSet := attr[attrSoftMountTimeo]
+	if !ok && !timeoSet {
+		return nil, nil
+	}
+	for _, c := range caps {
+		for _, flag := range c.GetMount().GetMountFlags() {
+			for _, conflicting := range conflictingMountFlags {
+				if flag == conflicting || (strings.HasSuffix(conflicting, "=") && strings.HasPrefix(flag, conflicting)) {
+					return nil, fmt.Errorf("mount option %q conflicts with the mount policy of the volume, set with %v", flag, attrMountPolicy)
+				}
+			}
+		}
+	}
+
+	switch policy {
+	case "", mountPolicyHard:
+		if timeoSet {
+			return nil, fmt.Errorf("%v is only supported with the %s mount policy", attrSoftMountTimeo, mountPolicySoft)
+		}
+		return nil, nil
+	case mountPolicySoft:
+	default:
+		return nil, fmt.Errorf("invalid mount policy %q, must be %q or %q", policy, mountPolicyHard, mountPolicySoft)
+	}
+
+	if !allowSoftMounts {
+		return nil, fmt.Errorf("soft mounts may corrupt the data of the applications not handling their I/O errors, they are refused unless the driver runs with --allow-soft-mounts")
+	}
+	for _, c := range caps {
+		if mode := c.GetAccessMode().GetMode(); softMountForbiddenAccessModes[mode] {
+			return nil, fmt.Errorf("soft mounts are not supported with access mode %v", mode)
+		}
+	}
+	timeoValue := defaultSoftMountTimeo
+	if timeoSet {
+		v, err := strconv.Atoi(timeo)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid %v %q, must be a positive number of tenths of a second", attrSoftMountTimeo, timeo)
+		}
+		timeoValue = v
+	}
+	return []string{mountPolicySoft, fmt.Sprintf("timeo=%d", timeoValue)}, nil
+}
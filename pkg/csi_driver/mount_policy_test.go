@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+)
+
+func mountCapability(mode csi.VolumeCapability_AccessMode_Mode, flags ...string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestMountPolicyOptions(t *testing.T) {
+	singleWriter := mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	cases := []struct {
+		name            string
+		attr            map[string]string
+		caps            []*csi.VolumeCapability
+		allowSoftMounts bool
+		expected        []string
+		expectErr       bool
+	}{
+		{
+			name: "no mount policy",
+			caps: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, "soft")},
+		},
+		{
+			name: "hard mount policy",
+			attr: map[string]string{attrMountPolicy: mountPolicyHard},
+			caps: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		},
+		{
+			name:            "soft mount with default timeo",
+			attr:            map[string]string{attrMountPolicy: mountPolicySoft},
+			caps:            []*csi.VolumeCapability{singleWriter},
+			allowSoftMounts: true,
+			expected:        []string{"soft", "timeo=600"},
+		},
+		{
+			name:            "soft mount with timeo",
+			attr:            map[string]string{attrMountPolicy: mountPolicySoft, attrSoftMountTimeo: "150"},
+			caps:            []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, "nconnect=4")},
+			allowSoftMounts: true,
+			expected:        []string{"soft", "timeo=150"},
+		},
+		{
+			name:      "soft mount not allowed by the driver",
+			attr:      map[string]string{attrMountPolicy: mountPolicySoft},
+			caps:      []*csi.VolumeCapability{singleWriter},
+			expectErr: true,
+		},
+		{
+			name:            "soft mount of a multi writer volume",
+			attr:            map[string]string{attrMountPolicy: mountPolicySoft},
+			caps:            []*csi.VolumeCapability{singleWriter, mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+			allowSoftMounts: true,
+			expectErr:       true,
+		},
+		{
+			name:            "conflicting mount option",
+			attr:            map[string]string{attrMountPolicy: mountPolicySoft},
+			caps:            []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "timeo=100")},
+			allowSoftMounts: true,
+			expectErr:       true,
+		},
+		{
+			name:            "timeo of a hard mount",
+			attr:            map[string]string{attrSoftMountTimeo: "150"},
+			caps:            []*csi.VolumeCapability{singleWriter},
+			allowSoftMounts: true,
+			expectErr:       true,
+		},
+		{
+			name:            "invalid timeo",
+			attr:            map[string]string{attrMountPolicy: mountPolicySoft, attrSoftMountTimeo: "1m"},
+			caps:            []*csi.VolumeCapability{singleWriter},
+			allowSoftMounts: true,
+			expectErr:       true,
+		},
+		{
+			name:            "invalid mount policy",
+			attr:            map[string]string{attrMountPolicy: "intr"},
+			caps:            []*csi.VolumeCapability{singleWriter},
+			allowSoftMounts: true,
+			expectErr:       true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			options, err := mountPolicyOptions(tc.attr, tc.caps, tc.allowSoftMounts)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if !reflect.DeepEqual(options, tc.expected) {
+				t.Errorf("got options %v, expected %v", options, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeMountPolicy(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	req := &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		Parameters:         map[string]string{"Mount-Policy": "Soft", paramSoftMountTimeo: "150"},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+	}
+
+	if _, err := cs.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, expected code %v without --allow-soft-mounts", err, codes.InvalidArgument)
+	}
+	cs.config.allowSoftMounts = true
+	resp, err := cs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ctx := resp.Volume.VolumeContext; ctx[attrMountPolicy] != mountPolicySoft || ctx[attrSoftMountTimeo] != "150" {
+		t.Errorf("got volume context %v, expected the soft mount policy", ctx)
+	}
+}
+
+func TestNodeStageVolumeMountPolicy(t *testing.T) {
+	testEnv := initTestNodeServer(t)
+	ns := testEnv.ns.(*nodeServer)
+	stagingPath := t.TempDir()
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "nconnect=4"),
+		VolumeContext: map[string]string{
+			attrIP:             "1.1.1.1",
+			attrVolume:         "vol1",
+			attrMountPolicy:    mountPolicySoft,
+			attrSoftMountTimeo: "150",
+		},
+	}
+
+	if _, err := ns.NodeStageVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, expected code %v without --allow-soft-mounts", err, codes.InvalidArgument)
+	}
+	ns.driver.config.AllowSoftMounts = true
+	if _, err := ns.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	validateMountPoint(t, "soft mount", testEnv.fm, &mount.MountPoint{
+		Device: "1.1.1.1:/vol1",
+		Path:   stagingPath,
+		Type:   "nfs",
+		Opts:   []string{"nconnect=4", "soft", "timeo=150"},
+	})
+}
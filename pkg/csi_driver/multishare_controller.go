@@ -20,8 +20,10 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	pbSanitizer "github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -29,7 +31,6 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
@@ -37,6 +38,7 @@ import (
 	"k8s.io/klog/v2"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
 )
 
@@ -48,11 +50,15 @@ const (
 	methodExpandVolume              = "ExpandVolume"
 	methodCreateSnapshot            = "CreateSnapshot"
 	methodDeleteSnapshot            = "DeleteSnapshot"
+	methodRestoreVolume             = "RestoreVolume"
 	ecfsDataPlaneVersionFormat      = "GoogleReserved-CustomVMImage=clh.image.ems.path:projects/%s/global/images/ems-filestore-scaleout-%s"
 	ecfsCustom100sharesConfigFormat = "GoogleReservedOverrides={\"CustomMultiShareConfig\":{\"MaxShareCount\": %d, \"MinShareSizeGB\":%d}}"
 
 	// volume context attributes
 	attrMaxShareSize = "max-share-size"
+
+	// maxShareNameLength is the maximum length of the name of a Filestore share.
+	maxShareNameLength = 63
 )
 
 // MultishareController handles CSI calls for volumes which use Filestore multishare instances.
@@ -65,11 +71,26 @@ type MultishareController struct {
 	ecfsDescription                 string
 	isRegional                      bool
 	clustername                     string
+	clusterLocation                 string
+	clusterUID                      string
+	sharedClusterGroup              string
 	featureMaxSharePerInstance      bool
 	featureMultishareBackups        bool
 	featureNFSExportOptionsOnCreate bool
 	extraVolumeLabels               map[string]string
 	tagManager                      cloud.TagService
+	backupBeforeExpandTimeout       time.Duration
+	// instancePools admits the operations of each instance pool, nil if they are not limited.
+	instancePools *instancePools
+	// instanceIntents serializes the expansions and the backups of the shares of an instance.
+	instanceIntents *instanceIntents
+	// nfsFirewall, if non-nil, allows the NFS traffic of the instances in their network.
+	nfsFirewall *nfsFirewall
+	// networkBackoff, if non-nil, backs off the instance creations in the exhausted networks.
+	networkBackoff *networkBackoff
+	metricsManager *metrics.MetricsManager
+	// opTimeouts, if non-nil, are the deadlines of the waits on the operations of the volumes.
+	opTimeouts *OpTimeouts
 
 	// Filestore instance description overrides
 	descOverrideMaxSharesPerInstance string
@@ -83,17 +104,34 @@ type MultishareController struct {
 
 func NewMultishareController(config *controllerServerConfig) *MultishareController {
 	c := &MultishareController{
-		driver:            config.driver,
-		fileService:       config.fileService,
-		cloud:             config.cloud,
-		volumeLocks:       config.volumeLocks,
-		ecfsDescription:   config.ecfsDescription,
-		isRegional:        config.isRegional,
-		clustername:       config.clusterName,
-		extraVolumeLabels: config.extraVolumeLabels,
-		tagManager:        config.tagManager,
+		driver:             config.driver,
+		fileService:        config.fileService,
+		cloud:              config.cloud,
+		volumeLocks:        config.volumeLocks,
+		ecfsDescription:    config.ecfsDescription,
+		isRegional:         config.isRegional,
+		clustername:        config.clusterName,
+		clusterLocation:    config.clusterLocation,
+		clusterUID:         config.clusterUID,
+		sharedClusterGroup: config.sharedClusterGroup,
+		extraVolumeLabels:  config.extraVolumeLabels,
+		tagManager:         config.tagManager,
+		instanceIntents:    config.instanceIntents,
+		nfsFirewall:        config.nfsFirewall,
+		networkBackoff:     config.networkBackoff,
+		metricsManager:     config.metricsManager,
+		opTimeouts:         config.opTimeouts,
+
+		backupBeforeExpandTimeout: config.backupBeforeExpandTimeout,
 	}
 	c.opsManager = NewMultishareOpsManager(config.cloud, c)
+	c.opsManager.shareListParallelism = config.listParallelism
+	c.opsManager.stuckOpThreshold = config.stuckOpThreshold
+	c.opsManager.maxRegionalCapacityBytes = config.maxRegionalCapacityBytes
+	c.opsManager.instanceCreationDeferrer = config.instanceCreationDeferrer
+	if config.instancePools != nil {
+		c.instancePools = newInstancePools(config.instancePools, config.metricsManager)
+	}
 	if config.features != nil && config.features.FeatureMaxSharesPerInstance != nil {
 		c.featureMaxSharePerInstance = config.features.FeatureMaxSharesPerInstance.Enabled
 		c.descOverrideMaxSharesPerInstance = config.features.FeatureMaxSharesPerInstance.DescOverrideMaxSharesPerInstance
@@ -111,6 +149,9 @@ func NewMultishareController(config *controllerServerConfig) *MultishareControll
 	if config.features != nil && config.features.FeatureNFSExportOptionsOnCreate != nil {
 		c.featureNFSExportOptionsOnCreate = config.features.FeatureNFSExportOptionsOnCreate.Enabled
 	}
+	if config.features != nil && config.features.FeatureMultishareDeleteBatching != nil {
+		c.opsManager.deleteBatching = config.features.FeatureMultishareDeleteBatching.Enabled
+	}
 
 	return c
 }
@@ -129,7 +170,7 @@ func (m *MultishareController) Run(stopCh <-chan struct{}) {
 }
 
 func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	klog.Infof("CreateVolume called for multishare with request %+v", req)
+	klog.Infof("CreateVolume called for multishare with request %+v", pbSanitizer.StripSecrets(req))
 	name := req.GetName()
 	if len(name) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "CreateVolume name must be provided")
@@ -147,11 +188,19 @@ func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.Create
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	shareName, err := multishareShareName(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	maxSharesPerInstance, maxShareSizeSizeBytes, err := m.parseMaxVolumeSizeParam(req.GetParameters())
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	timeouts, err := m.opTimeouts.withParams(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	var reqBytes int64
 	if m.featureMaxSharePerInstance {
@@ -160,49 +209,79 @@ func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.Create
 		reqBytes, err = getShareRequestCapacity(req.GetCapacityRange(), util.MinShareSizeBytes, util.MaxShareSizeBytes)
 	}
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
 	if !util.IsAligned(reqBytes, util.Gb) {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("requested size(bytes) %d is not a multiple of 1GiB", reqBytes))
 	}
 	if acquired := m.volumeLocks.TryAcquire(name); !acquired {
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, name)
+		return nil, volumeOperationInProgressError(m.metricsManager, methodCreateVolume, name)
 	}
 	defer m.volumeLocks.Release(name)
-
-	// If no eligible instance found, the ops manager may decide to create a new instance. Prepare a multishare instance object for such a scenario.
-	instance, err := m.generateNewMultishareInstance(util.NewMultishareInstancePrefix+string(uuid.NewUUID()), req, maxSharesPerInstance)
+	release, err := m.instancePools.acquire(ctx, instanceScPrefix)
 	if err != nil {
-		return nil, file.StatusError(err)
+		return nil, err
 	}
+	defer release()
 
-	if m.featureMaxSharePerInstance && m.descOverrideMaxSharesPerInstance != "" && m.descOverrideMinShareSizeBytes != "" {
-		sharesPerInstance, err := strconv.Atoi(m.descOverrideMaxSharesPerInstance)
+	// Resume the wait on the operation of a previous attempt of the request, if it ended early.
+	workflow := m.opsManager.takePendingWorkflow(name)
+	if workflow != nil {
+		klog.Infof("Resuming the wait on operation %s (type %s) of volume %s", workflow.opName, workflow.opType.String(), name)
+	} else {
+		// If no eligible instance found, the ops manager may decide to create a new instance. Prepare a multishare instance object for such a scenario.
+		instance, err := m.generateNewMultishareInstance(m.opsManager.newInstanceName(), req, maxSharesPerInstance)
 		if err != nil {
-			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid description override value %s", m.descOverrideMaxSharesPerInstance))
+			return nil, file.StatusError(err)
 		}
-		minShareSizeGB, err := strconv.Atoi(m.descOverrideMinShareSizeBytes)
-		if err != nil {
-			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid description override value %s", m.descOverrideMinShareSizeBytes))
+		if err := validateLocation(ctx, m.fileService, instance.Project, instance.Location); err != nil {
+			return nil, err
+		}
+		if _, maxInstanceSizeBytes := instanceSizeBounds(instance); reqBytes > maxInstanceSizeBytes {
+			return nil, status.Errorf(codes.InvalidArgument, "requested size(bytes) %d is greater than the max instance size(bytes) %d", reqBytes, maxInstanceSizeBytes)
 		}
-		instance.Description = fmt.Sprintf(ecfsCustom100sharesConfigFormat, sharesPerInstance, minShareSizeGB)
-	}
 
-	workflow, share, err := m.opsManager.setupEligibleInstanceAndStartWorkflow(ctx, req, instance, sourceSnapshotId)
-	if err != nil {
-		return nil, file.StatusError(err)
-	}
+		if m.featureMaxSharePerInstance && m.descOverrideMaxSharesPerInstance != "" && m.descOverrideMinShareSizeBytes != "" {
+			sharesPerInstance, err := strconv.Atoi(m.descOverrideMaxSharesPerInstance)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid description override value %s", m.descOverrideMaxSharesPerInstance))
+			}
+			minShareSizeGB, err := strconv.Atoi(m.descOverrideMinShareSizeBytes)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid description override value %s", m.descOverrideMinShareSizeBytes))
+			}
+			instance.Description = fmt.Sprintf(ecfsCustom100sharesConfigFormat, sharesPerInstance, minShareSizeGB)
+		}
 
-	if share != nil {
-		resp, err := m.getShareAndGenerateCSICreateVolumeResponse(ctx, instanceScPrefix, share, maxShareSizeSizeBytes)
-		return resp, file.StatusError(err)
+		var share *file.Share
+		workflow, share, err = m.opsManager.setupEligibleInstanceAndStartWorkflow(ctx, req, instance, sourceSnapshotId)
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+
+		if share != nil {
+			if err := m.reconcileExistingShare(ctx, req, instanceScPrefix, share, reqBytes, sourceSnapshotId); err != nil {
+				return nil, file.StatusError(err)
+			}
+			resp, err := m.getShareAndGenerateCSICreateVolumeResponse(ctx, instanceScPrefix, share, maxShareSizeSizeBytes)
+			return resp, file.StatusError(err)
+		}
 	}
 
 	// lock released. poll for op.
-	err = m.waitOnWorkflow(ctx, workflow)
+	err = m.waitOnWorkflow(withOpTimeout(ctx, timeouts, workflow.opType), workflow)
 	if err != nil {
+		m.opsManager.recordPendingWorkflow(name, workflow, err)
+		if workflow.opType == util.InstanceCreate {
+			if exhaustedErr := m.networkBackoff.failed(workflow.instance.Project, workflow.instance.Network.Name, err); exhaustedErr != nil {
+				return nil, exhaustedErr
+			}
+		}
 		return nil, file.StatusError(fmt.Errorf("Create Volume failed, operation %q poll error: %w", workflow.opName, err))
 	}
+	if workflow.opType == util.InstanceCreate {
+		m.networkBackoff.succeeded(workflow.instance.Project, workflow.instance.Network.Name)
+	}
 
 	klog.Infof("Poll for operation %s (type %s) completed", workflow.opName, workflow.opType.String())
 	if workflow.opType == util.ShareCreate {
@@ -214,7 +293,7 @@ func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.Create
 	var newShare *file.Share
 	switch workflow.opType {
 	case util.InstanceCreate, util.InstanceUpdate:
-		newShare, err = generateNewShare(util.ConvertVolToShareName(req.Name), workflow.instance, req, sourceSnapshotId)
+		newShare, err = generateNewShare(shareName, workflow.instance, req, sourceSnapshotId)
 		if err != nil {
 			return nil, file.StatusError(err)
 		}
@@ -227,8 +306,9 @@ func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.Create
 	}
 
 	// lock released. poll for share create op.
-	err = m.waitOnWorkflow(ctx, shareCreateWorkflow)
+	err = m.waitOnWorkflow(withOpTimeout(ctx, timeouts, shareCreateWorkflow.opType), shareCreateWorkflow)
 	if err != nil {
+		m.opsManager.recordPendingWorkflow(name, shareCreateWorkflow, err)
 		return nil, file.StatusError(fmt.Errorf("%v operation %q poll error: %w", shareCreateWorkflow.opType, shareCreateWorkflow.opName, err))
 	}
 	resp, err := m.getShareAndGenerateCSICreateVolumeResponse(ctx, instanceScPrefix, newShare, maxShareSizeSizeBytes)
@@ -239,12 +319,12 @@ func (m *MultishareController) CreateSnapshot(ctx context.Context, req *csi.Crea
 	if !m.featureMultishareBackups {
 		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot is not supported for multishare backed volumes")
 	}
-	klog.Infof("CreateSnapshot called for multishare with request %+v", req)
+	klog.Infof("CreateSnapshot called for multishare with request %+v", pbSanitizer.StripSecrets(req))
 	name := req.GetName()
 	volumeID := req.GetSourceVolumeId()
 
 	if acquired := m.volumeLocks.TryAcquire(volumeID); !acquired {
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+		return nil, volumeOperationInProgressError(m.metricsManager, methodCreateSnapshot, volumeID)
 	}
 	defer m.volumeLocks.Release(volumeID)
 
@@ -272,9 +352,19 @@ func (m *MultishareController) CreateSnapshot(ctx context.Context, req *csi.Crea
 		return nil, file.StatusError(err)
 	}
 
+	// The backup is serialized with the expansions of the instance. Its intent is kept while its
+	// operation runs after the request.
+	release, err := m.instanceIntents.acquire(ctx, intentInstanceURI(project, location, instanceName), intentBackup, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	pending := false
+	defer func() { release(pending) }()
+
 	var snapshotResponse *csi.CreateSnapshotResponse
 	if backupExists {
 		// process existing backup
+		pending = isBackupInProgress(existingBackup)
 
 		snapshot, err := file.ProcessExistingBackup(ctx, existingBackup, volumeID, modeMultishare)
 		if err != nil {
@@ -303,7 +393,8 @@ func (m *MultishareController) CreateSnapshot(ctx context.Context, req *csi.Crea
 
 		snapshot, err := m.createNewBackup(ctx, backupInfo)
 		if err != nil {
-			return nil, err
+			pending = file.IsOpPendingErr(err)
+			return nil, file.StatusError(err)
 		}
 
 		snapshotResponse = &csi.CreateSnapshotResponse{
@@ -323,7 +414,7 @@ func (m *MultishareController) createNewBackup(ctx context.Context, backupInfo *
 	backupObj, err := m.cloud.File.CreateBackup(ctx, backupInfo)
 	if err != nil {
 		klog.Errorf("Create snapshot for volume Id %s failed: %v", backupInfo.SourceVolumeId, err.Error())
-		return nil, file.StatusError(err)
+		return nil, err
 	}
 	tp, err := util.ParseTimestamp(backupObj.CreateTime)
 	if err != nil {
@@ -340,29 +431,66 @@ func (m *MultishareController) createNewBackup(ctx context.Context, backupInfo *
 	return snapshot, nil
 }
 
+// reconcileExistingShare checks that the share found for a retried CreateVolume, e.g. after a
+// partial creation or a change of the requested capacity, is compatible with the request. A share
+// smaller than requested is grown, while a share on an instance of another instance pool, restored
+// from another backup, or larger than the capacity limit is reported as AlreadyExists.
+func (m *MultishareController) reconcileExistingShare(ctx context.Context, req *csi.CreateVolumeRequest, instanceScPrefix string, s *file.Share, reqBytes int64, sourceSnapshotId string) error {
+	share, err := m.cloud.File.GetShare(ctx, s)
+	if err != nil {
+		return err
+	}
+	if share.State != file.ShareStateReady {
+		return status.Errorf(codes.Aborted, "share %s not ready, state %s", share.Name, share.State)
+	}
+	if instancePoolTag := share.Parent.Labels[util.ParamMultishareInstanceScLabelKey]; instancePoolTag != instanceScPrefix {
+		return status.Errorf(codes.AlreadyExists, "share %s already exists on instance %s of instance pool %q, not %q", share.Name, share.Parent.Name, instancePoolTag, instanceScPrefix)
+	}
+	if share.BackupId != sourceSnapshotId {
+		return status.Errorf(codes.AlreadyExists, "share %s already exists with source backup %q, not %q", share.Name, share.BackupId, sourceSnapshotId)
+	}
+	if limitBytes := req.GetCapacityRange().GetLimitBytes(); limitBytes > 0 && share.CapacityBytes > limitBytes {
+		return status.Errorf(codes.AlreadyExists, "share %s already exists with size(bytes) %d greater than the limit(bytes) %d", share.Name, share.CapacityBytes, limitBytes)
+	}
+	if share.CapacityBytes >= reqBytes {
+		return nil
+	}
+
+	klog.Infof("Share %s already exists with size(bytes) %d, expanding it to the requested size(bytes) %d", share.Name, share.CapacityBytes, reqBytes)
+	return m.expandShare(ctx, share, reqBytes)
+}
+
 func (m *MultishareController) getShareAndGenerateCSICreateVolumeResponse(ctx context.Context, instancePrefix string, s *file.Share, maxShareSizeSizeBytes int64) (*csi.CreateVolumeResponse, error) {
 	share, err := m.cloud.File.GetShare(ctx, s)
 	if err != nil {
 		return nil, err
 	}
 
-	if share.State != "READY" {
+	if share.State != file.ShareStateReady {
 		return nil, status.Errorf(codes.Aborted, "share %s not ready, state %s", share.Name, share.State)
 	}
+	if share.Parent != nil {
+		m.nfsFirewall.ensure(ctx, share.Parent.Network)
+	}
 	return m.generateCSICreateVolumeResponse(instancePrefix, share, maxShareSizeSizeBytes)
 }
 
 func (m *MultishareController) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	_, project, location, instanceName, shareName, err := parseMultishareVolId(req.VolumeId)
+	instanceScPrefix, project, location, instanceName, shareName, err := parseMultishareVolId(req.VolumeId)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	klog.V(4).Infof("DeleteVolume called for multishare with request %+v", req)
+	klog.V(4).Infof("DeleteVolume called for multishare with request %+v", pbSanitizer.StripSecrets(req))
 
 	if acquired := m.volumeLocks.TryAcquire(req.VolumeId); !acquired {
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, req.VolumeId)
+		return nil, volumeOperationInProgressError(m.metricsManager, methodDeleteVolume, req.VolumeId)
 	}
 	defer m.volumeLocks.Release(req.VolumeId)
+	release, err := m.instancePools.acquire(ctx, instanceScPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	share, err := m.cloud.File.GetShare(ctx, &file.Share{
 		Parent: &file.MultishareInstance{
@@ -372,6 +500,16 @@ func (m *MultishareController) DeleteVolume(ctx context.Context, req *csi.Delete
 		},
 		Name: shareName,
 	})
+	if m.opsManager.deleteBatching && (err == nil || file.IsNotFoundErr(err)) {
+		// A share not found is queued as well, for the instance shrink or delete check.
+		if err != nil {
+			share = nil
+		}
+		if err := m.queueAndWaitForShareDelete(ctx, &file.MultishareInstance{Project: project, Location: location, Name: instanceName}, shareName, share); err != nil {
+			return nil, file.StatusError(err)
+		}
+		return &csi.DeleteVolumeResponse{}, nil
+	}
 	if err != nil {
 		// If share not found, proceed to instance/shrink check.
 		if file.IsNotFoundErr(err) {
@@ -411,12 +549,18 @@ func (m *MultishareController) startAndWaitForInstanceDeleteOrShrink(ctx context
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// Check whether instance can be shrinked or deleted.
-	workflow, err := m.opsManager.checkAndStartInstanceDeleteOrShrinkWorkflow(ctx, &file.MultishareInstance{
+	return m.waitForInstanceDeleteOrShrink(ctx, &file.MultishareInstance{
 		Project:  project,
 		Location: location,
 		Name:     instanceName,
 	})
+}
+
+// waitForInstanceDeleteOrShrink deletes the instance if it has no share left, or shrinks it
+// to the capacity of its shares, and waits for the op to complete.
+func (m *MultishareController) waitForInstanceDeleteOrShrink(ctx context.Context, instance *file.MultishareInstance) error {
+	// Check whether instance can be shrinked or deleted.
+	workflow, err := m.opsManager.checkAndStartInstanceDeleteOrShrinkWorkflow(ctx, instance)
 	if err != nil {
 		return err
 	}
@@ -432,6 +576,16 @@ func (m *MultishareController) startAndWaitForInstanceDeleteOrShrink(ctx context
 	return nil
 }
 
+// queueAndWaitForShareDelete queues the deletion of the share on the batch of its instance,
+// and waits for the deletion and the instance shrink or delete following it.
+func (m *MultishareController) queueAndWaitForShareDelete(ctx context.Context, instance *file.MultishareInstance, shareName string, share *file.Share) error {
+	d, err := m.opsManager.queueShareDelete(instance, shareName, share)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return waitForShareDeletion(ctx, d)
+}
+
 func (m *MultishareController) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
 	// Handle higher level csi params validation, try locks
 	// Initiate share workflow by calling Multishare OpsManager functions
@@ -453,21 +607,26 @@ func (m *MultishareController) ControllerExpandVolume(ctx context.Context, req *
 	}
 	reqBytes, err := getShareRequestCapacity(req.GetCapacityRange(), util.ConfigurablePackMinShareSizeBytes, maxShareSizeBytes)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
 	if !util.IsAligned(reqBytes, util.Gb) {
 		return nil, status.Errorf(codes.InvalidArgument, "requested size(bytes) %d is not a multiple of 1GiB", reqBytes)
 	}
-	_, project, location, instanceName, shareName, err := parseMultishareVolId(req.VolumeId)
+	instanceScPrefix, project, location, instanceName, shareName, err := parseMultishareVolId(req.VolumeId)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	klog.Infof("ControllerExpandVolume called for multishare with request %+v", req)
+	klog.Infof("ControllerExpandVolume called for multishare with request %+v", pbSanitizer.StripSecrets(req))
 	if acquired := m.volumeLocks.TryAcquire(volumeId); !acquired {
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeId)
+		return nil, volumeOperationInProgressError(m.metricsManager, methodExpandVolume, volumeId)
 	}
 	defer m.volumeLocks.Release(volumeId)
+	release, err := m.instancePools.acquire(ctx, instanceScPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	share, err := m.cloud.File.GetShare(ctx, &file.Share{
 		Parent: &file.MultishareInstance{
@@ -492,14 +651,47 @@ func (m *MultishareController) ControllerExpandVolume(ctx context.Context, req *
 		}, nil
 	}
 
-	workflow, err := m.opsManager.checkAndStartInstanceOrShareExpandWorkflow(ctx, share, reqBytes)
+	// The expansion is serialized with the backups of the instance. Its intent is kept while
+	// its operation runs after the request.
+	releaseIntent, err := m.instanceIntents.acquire(ctx, intentInstanceURI(project, location, instanceName), intentExpand, volumeId)
 	if err != nil {
+		return nil, err
+	}
+	pending := false
+	defer func() { releaseIntent(pending) }()
+
+	if backup, _ := strconv.ParseBool(share.Labels[TagKeyBackupBeforeExpand]); backup {
+		labels, err := extractLabels(nil, m.extraVolumeLabels, m.driver.config.Name)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		backupInfo, err := expansionBackupInfo(volumeId, project, location, instanceName, shareName, reqBytes, labels)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := ensureExpansionBackup(ctx, m.cloud.File, backupInfo, m.backupBeforeExpandTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.expandShare(ctx, share, reqBytes); err != nil {
+		pending = file.IsOpPendingErr(err)
 		return nil, file.StatusError(err)
 	}
+	resp, err := m.getShareAndGenerateCSIControllerExpandVolumeResponse(ctx, share, reqBytes)
+	return resp, file.StatusError(err)
+}
+
+// expandShare grows a share to reqBytes, expanding its instance first if the share doesn't fit.
+func (m *MultishareController) expandShare(ctx context.Context, share *file.Share, reqBytes int64) error {
+	workflow, err := m.opsManager.checkAndStartInstanceOrShareExpandWorkflow(ctx, share, reqBytes)
+	if err != nil {
+		return err
+	}
 
 	err = m.waitOnWorkflow(ctx, workflow)
 	if err != nil {
-		return nil, file.StatusError(fmt.Errorf("wait on %v operation %q failed with error: %w", workflow.opType, workflow.opName, err))
+		return fmt.Errorf("wait on %v operation %q failed with error: %w", workflow.opType, workflow.opName, err)
 	}
 	klog.Infof("Wait for operation %s (type %s) completed", workflow.opName, workflow.opType.String())
 
@@ -507,22 +699,19 @@ func (m *MultishareController) ControllerExpandVolume(ctx context.Context, req *
 	case util.InstanceUpdate:
 		workflow, err = m.opsManager.startShareExpandWorkflowSafe(ctx, share, reqBytes)
 		if err != nil {
-			return nil, file.StatusError(err)
+			return err
 		}
 	case util.ShareUpdate:
-		resp, err := m.getShareAndGenerateCSIControllerExpandVolumeResponse(ctx, share, reqBytes)
-		return resp, file.StatusError(err)
+		return nil
 	default:
-		return nil, status.Errorf(codes.Internal, "Controller Expand Volume failed, unknown workflow %v detected", workflow.opType)
+		return status.Errorf(codes.Internal, "share expansion failed, unknown workflow %v detected", workflow.opType)
 	}
 
 	err = m.waitOnWorkflow(ctx, workflow)
 	if err != nil {
-		return nil, file.StatusError(fmt.Errorf("wait on share expansion op %q failed with error: %w", workflow.opName, err))
+		return fmt.Errorf("wait on share expansion op %q failed with error: %w", workflow.opName, err)
 	}
-
-	resp, err := m.getShareAndGenerateCSIControllerExpandVolumeResponse(ctx, share, reqBytes)
-	return resp, file.StatusError(err)
+	return nil
 }
 
 func (m *MultishareController) getShareAndGenerateCSIControllerExpandVolumeResponse(ctx context.Context, share *file.Share, reqBytes int64) (*csi.ControllerExpandVolumeResponse, error) {
@@ -544,7 +733,10 @@ func (m *MultishareController) waitOnWorkflow(ctx context.Context, workflow *Wor
 	if err != nil {
 		return
 	}
-	err = m.cloud.File.WaitForOpWithOpts(ctx, workflow.opName, file.PollOpts{Timeout: timeout, Interval: pollInterval})
+	if t := m.opTimeouts.forOp(workflow.opType); t > 0 {
+		timeout = t
+	}
+	err = m.cloud.File.WaitForOpWithOpts(ctx, workflow.opName, file.PollOpts{Timeout: file.OpWaitTimeout(ctx, timeout), Interval: pollInterval})
 	return
 }
 
@@ -562,6 +754,33 @@ func getInstanceSCLabel(req *csi.CreateVolumeRequest) (string, error) {
 	return v, nil
 }
 
+// multishareShareName returns the name of the share of the volume of the request. With the
+// share-name-with-sc-prefix parameter set to true, the name is prefixed with the instance
+// storage class label, so that the volumes of the storage classes sharing instances, e.g.
+// adopted from another cluster, never collide.
+func multishareShareName(req *csi.CreateVolumeRequest) (string, error) {
+	v, ok := req.GetParameters()[paramShareNameWithScPrefix]
+	if !ok {
+		return util.ConvertVolToShareName(req.GetName()), nil
+	}
+	withPrefix, err := strconv.ParseBool(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid value %q for parameter %s: %w", v, paramShareNameWithScPrefix, err)
+	}
+	if !withPrefix {
+		return util.ConvertVolToShareName(req.GetName()), nil
+	}
+	prefix, err := getInstanceSCLabel(req)
+	if err != nil {
+		return "", err
+	}
+	shareName := util.ConvertVolToShareName(prefix + "_" + req.GetName())
+	if len(shareName) > maxShareNameLength {
+		return "", fmt.Errorf("share name %q with the instance storage class label prefix is longer than %d characters", shareName, maxShareNameLength)
+	}
+	return shareName, nil
+}
+
 func (m *MultishareController) generateNewMultishareInstance(instanceName string, req *csi.CreateVolumeRequest, maxShareCount int) (*file.MultishareInstance, error) {
 	region, err := m.pickRegion(req.GetAccessibilityRequirements())
 	if err != nil {
@@ -598,10 +817,25 @@ func (m *MultishareController) generateNewMultishareInstance(instanceName string
 			continue
 		case ParamMultishareInstanceScLabel:
 			continue
-		case paramMaxVolumeSize:
+		case paramMaxVolumeSize, paramMinInstanceSize, paramMaxInstanceSize:
+			continue
+		case paramInstanceCreateTimeout, paramShareCreateTimeout:
+			if _, err := parseOpTimeoutParam(k, v); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			continue
+		case paramAuthFlavor:
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %q is not supported for multishare volumes", k)
+		case paramShareSpreadByNamespace, paramBackupBeforeExpand, paramAllowNewInstances:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid value %q for parameter %q: %v", v, k, err)
+			}
 			continue
 		case cloud.ParameterKeyResourceTags:
 			continue
+		// Validated by mountPolicyVolumeContext and hostnameTemplate.
+		case paramMountPolicy, paramSoftMountTimeo, paramHostnameTemplate:
+			continue
 		case ParameterKeyLabels, ParameterKeyPVCName, ParameterKeyPVCNamespace, ParameterKeyPVName, paramMultishare:
 		case "csiprovisionersecretname", "csiprovisionersecretnamespace":
 		default:
@@ -612,23 +846,33 @@ func (m *MultishareController) generateNewMultishareInstance(instanceName string
 	if tier != enterpriseTier {
 		return nil, status.Errorf(codes.InvalidArgument, "tier %q not supported for multishare volumes", tier)
 	}
-
-	location := m.cloud.Zone
-	if m.isRegional {
-		location, err = util.GetRegionFromZone(location)
-		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "failed to get region for regional cluster: %v", err.Error())
+	if kmsKeyName != "" {
+		if kmsKeyName, err = normalizeKmsKeyName(kmsKeyName, region); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 	}
-	labels, err := extractInstanceLabels(req.GetParameters(), m.extraVolumeLabels, m.driver.config.Name, m.clustername, location)
+
+	location, err := getClusterLocation(m.cloud.Zone, m.isRegional, m.clusterLocation)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to get region for regional cluster: %v", err.Error())
+	}
+	labels, err := extractInstanceLabels(req.GetParameters(), m.extraVolumeLabels, m.driver.config.Name, m.clustername, location, m.sharedClusterGroup)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, err.Error())
 	}
+	for k, v := range instanceOwnershipLabels(m.driver.config.Version, m.clusterUID, req.GetParameters()) {
+		labels[k] = v
+	}
+
+	minInstanceSizeBytes, _, err := parseInstanceSizeBounds(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	f := &file.MultishareInstance{
 		Project:       m.cloud.Project,
 		Name:          instanceName,
-		CapacityBytes: util.MinMultishareInstanceSizeBytes,
+		CapacityBytes: minInstanceSizeBytes,
 		Location:      region,
 		Tier:          tier,
 		Network: file.Network{
@@ -660,10 +904,10 @@ func (m *MultishareController) checkVolumeContentSource(ctx context.Context, req
 			if err != nil || !isBackupSource {
 				return "", status.Errorf(codes.InvalidArgument, "Unsupported volume content source %v", id)
 			}
-			_, err = m.cloud.File.GetBackup(ctx, id)
+			_, err = file.GetSourceBackup(ctx, m.cloud.File, id, m.cloud.Project)
 			if err != nil {
 				klog.Errorf("Failed to get volume %v source snapshot %v: %v", req.GetName(), id, err.Error())
-				return "", file.StatusError(err)
+				return "", err
 			}
 			return id, nil
 		}
@@ -678,7 +922,7 @@ func generateNewShare(name string, parent *file.MultishareInstance, req *csi.Cre
 	// The share size request is already validated in CreateVolume call
 	targetSizeBytes, err := getShareRequestCapacity(req.CapacityRange, util.ConfigurablePackMinShareSizeBytes, util.MaxShareSizeBytes)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
 	var nfsExportOptions []*file.NfsExportOptions
 	if req.GetParameters()[ParamNfsExportOptions] != "" {
@@ -721,7 +965,22 @@ func (m *MultishareController) pickRegion(top *csi.TopologyRequirement) (string,
 	return region, nil
 }
 
-func extractInstanceLabels(parameters, cliLabels map[string]string, driverName, clusterName, location string) (map[string]string, error) {
+// getClusterLocation returns the location of the cluster the multishare instances are labeled
+// with: the override if non-empty, e.g. for non-GKE clusters, else the zone of the driver, or
+// its region for regional clusters.
+func getClusterLocation(zone string, isRegional bool, override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	if !isRegional {
+		return zone, nil
+	}
+	return util.GetRegionFromZone(zone)
+}
+
+// extractInstanceLabels returns the labels of a new multishare instance. If sharedClusterGroup
+// is non-empty, the instance is labeled with it so that the clusters of the group share it.
+func extractInstanceLabels(parameters, cliLabels map[string]string, driverName, clusterName, location, sharedClusterGroup string) (map[string]string, error) {
 	instanceLabels := make(map[string]string)
 	userProvidedLabels := make(map[string]string)
 	for k, v := range parameters {
@@ -744,6 +1003,19 @@ func extractInstanceLabels(parameters, cliLabels map[string]string, driverName,
 	instanceLabels[tagKeyCreatedBy] = strings.ReplaceAll(driverName, ".", "_")
 	instanceLabels[TagKeyClusterName] = clusterName
 	instanceLabels[TagKeyClusterLocation] = location
+	if sharedClusterGroup != "" {
+		instanceLabels[TagKeySharedClusterGroup] = sharedClusterGroup
+	}
+	minInstanceSizeBytes, maxInstanceSizeBytes, err := parseInstanceSizeBounds(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if minInstanceSizeBytes != util.MinMultishareInstanceSizeBytes {
+		instanceLabels[util.ParamMultishareInstanceMinSizeLabelKey] = strconv.FormatInt(util.BytesToGb(minInstanceSizeBytes), 10)
+	}
+	if maxInstanceSizeBytes != util.MaxMultishareInstanceSizeBytes {
+		instanceLabels[util.ParamMultishareInstanceMaxSizeLabelKey] = strconv.FormatInt(util.BytesToGb(maxInstanceSizeBytes), 10)
+	}
 	finalInstanceLabels, err := mergeLabels(userProvidedLabels, instanceLabels, cliLabels)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
@@ -751,6 +1023,20 @@ func extractInstanceLabels(parameters, cliLabels map[string]string, driverName,
 	return finalInstanceLabels, nil
 }
 
+// instanceOwnershipLabels returns the labels recording the cluster and the version of the driver
+// creating a multishare instance, and the volume it is created for, so that operators auditing
+// the instances trace them back to a cluster, and to a StorageClass through the instance pool tag.
+func instanceOwnershipLabels(driverVersion, clusterUID string, parameters map[string]string) map[string]string {
+	labels := map[string]string{TagKeyDriverVersion: util.SanitizeLabelValue(driverVersion)}
+	if clusterUID != "" {
+		labels[TagKeyClusterUID] = clusterUID
+	}
+	if pvName := parameters[ParameterKeyPVName]; pvName != "" {
+		labels[tagKeyCreatedForVolumeName] = util.SanitizeLabelValue(pvName)
+	}
+	return labels
+}
+
 func extractShareLabels(parameters map[string]string) map[string]string {
 	shareLabels := make(map[string]string)
 	for k, v := range parameters {
@@ -761,11 +1047,18 @@ func extractShareLabels(parameters map[string]string) map[string]string {
 			shareLabels[tagKeyCreatedForClaimNamespace] = v
 		case ParameterKeyPVName:
 			shareLabels[tagKeyCreatedForVolumeName] = v
+		case paramBackupBeforeExpand:
+			if backup, _ := strconv.ParseBool(v); backup {
+				shareLabels[TagKeyBackupBeforeExpand] = "true"
+			}
 		}
 	}
 	return shareLabels
 }
 
+// getShareRequestCapacity returns the share size that should be provisioned. The shares are
+// provisioned in GiB, so the limit of the range is rounded down to the GiB, and the ranges which
+// can't be satisfied by a share fail with OutOfRange.
 func getShareRequestCapacity(capRange *csi.CapacityRange, minShareSizeBytes, maxShareSizeBytes int64) (int64, error) {
 	if capRange == nil {
 		return minShareSizeBytes, nil
@@ -787,7 +1080,7 @@ func getShareRequestCapacity(capRange *csi.CapacityRange, minShareSizeBytes, max
 	// Check bounds of limit and request.
 	if lSet {
 		if lCap < minShareSizeBytes {
-			return 0, status.Errorf(codes.InvalidArgument, "Limit bytes %v is less than minimum share size bytes %v", lCap, minShareSizeBytes)
+			return 0, status.Errorf(codes.OutOfRange, "Limit bytes %v is less than minimum share size bytes %v", lCap, minShareSizeBytes)
 		}
 
 		if lCap > maxShareSizeBytes {
@@ -801,12 +1094,17 @@ func getShareRequestCapacity(capRange *csi.CapacityRange, minShareSizeBytes, max
 		}
 
 		if rCap > maxShareSizeBytes {
-			return 0, status.Errorf(codes.InvalidArgument, "Request bytes %v is greater than maximum share size bytes %v", rCap, maxShareSizeBytes)
+			return 0, status.Errorf(codes.OutOfRange, "Request bytes %v is greater than maximum share size bytes %v", rCap, maxShareSizeBytes)
 		}
 	}
 
 	if lSet {
-		return lCap, nil
+		// The minimum share size is a multiple of the GiB, so the limit rounded down is not below it.
+		capBytes := util.GbToBytes(util.BytesToGb(lCap))
+		if capBytes < rCap {
+			return 0, status.Errorf(codes.OutOfRange, "Request bytes %v rounded up to the GiB is greater than limit bytes %v", rCap, lCap)
+		}
+		return capBytes, nil
 	}
 
 	return rCap, nil
@@ -823,7 +1121,8 @@ func (m *MultishareController) generateCSICreateVolumeResponse(instancePrefix st
 			VolumeId:      volId,
 			CapacityBytes: s.CapacityBytes,
 			VolumeContext: map[string]string{
-				attrIP: s.Parent.Network.Ip,
+				attrIP:             s.Parent.Network.Ip,
+				attrContextVersion: strconv.Itoa(volumeContextVersion),
 			},
 		},
 	}
@@ -917,6 +1216,70 @@ func (m *MultishareController) parseMaxVolumeSizeParam(params map[string]string)
 	return sharesPerInstance, valBytes, nil
 }
 
+// parseInstanceSizeBounds returns the min and max size of the multishare instances of a
+// storage class, from its min-instance-size and max-instance-size parameters. The sizes
+// default to the enterprise tier limits, and must be multiples of 1GiB within them.
+func parseInstanceSizeBounds(params map[string]string) (int64, int64, error) {
+	minBytes, maxBytes := util.MinMultishareInstanceSizeBytes, util.MaxMultishareInstanceSizeBytes
+	for k, v := range params {
+		var bound *int64
+		switch strings.ToLower(k) {
+		case paramMinInstanceSize:
+			bound = &minBytes
+		case paramMaxInstanceSize:
+			bound = &maxBytes
+		default:
+			continue
+		}
+		val, err := resource.ParseQuantity(v)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %q value %q: %w", k, v, err)
+		}
+		valBytes := val.Value()
+		if !util.IsAligned(valBytes, util.Gb) {
+			return 0, 0, fmt.Errorf("%q value %q is not a multiple of 1Gi", k, v)
+		}
+		if valBytes < util.MinMultishareInstanceSizeBytes || valBytes > util.MaxMultishareInstanceSizeBytes {
+			return 0, 0, fmt.Errorf("%q value %q is out of the %s tier limits [%dGi, %dGi]", k, v, enterpriseTier, util.BytesToGb(util.MinMultishareInstanceSizeBytes), util.BytesToGb(util.MaxMultishareInstanceSizeBytes))
+		}
+		*bound = valBytes
+	}
+	if minBytes > maxBytes {
+		return 0, 0, fmt.Errorf("%q %dGi is greater than %q %dGi", paramMinInstanceSize, util.BytesToGb(minBytes), paramMaxInstanceSize, util.BytesToGb(maxBytes))
+	}
+	return minBytes, maxBytes, nil
+}
+
+// instanceSizeBounds returns the min and max size of a multishare instance from its labels,
+// or the limits of the instance for the instances created without bounds. The max capacity
+// reported by the instance is its limit, so that the instance generations with higher limits
+// are supported, else the enterprise tier limit.
+func instanceSizeBounds(instance *file.MultishareInstance) (int64, int64) {
+	minBytes, maxBytes := util.MinMultishareInstanceSizeBytes, util.MaxMultishareInstanceSizeBytes
+	if instance.MaxCapacityBytes > 0 {
+		maxBytes = instance.MaxCapacityBytes
+	}
+	if v, err := strconv.ParseInt(instance.Labels[util.ParamMultishareInstanceMinSizeLabelKey], 10, 64); err == nil {
+		minBytes = util.GbToBytes(v)
+	}
+	if v, err := strconv.ParseInt(instance.Labels[util.ParamMultishareInstanceMaxSizeLabelKey], 10, 64); err == nil {
+		maxBytes = util.GbToBytes(v)
+	}
+	if instance.MaxCapacityBytes > 0 && maxBytes > instance.MaxCapacityBytes {
+		maxBytes = instance.MaxCapacityBytes
+	}
+	return minBytes, maxBytes
+}
+
+// instanceMaxShareCount returns the max number of shares of a multishare instance, as reported
+// by the instance, else the limit of the instances which don't report it.
+func instanceMaxShareCount(instance *file.MultishareInstance) int {
+	if instance.MaxShareCount > 0 {
+		return instance.MaxShareCount
+	}
+	return util.MaxSharesPerInstance
+}
+
 func getSharesPerInstance(volSizeBytes int64) (int, error) {
 	if !isValidMaxVolSize(volSizeBytes) {
 		return 0, fmt.Errorf("unsupported max volume size %d, supported sizes: '128Gi', '256Gi', '512Gi', '1024Gi'", volSizeBytes)
@@ -27,6 +27,8 @@ import (
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
@@ -420,18 +422,127 @@ func TestGetShareRequestCapacity(t *testing.T) {
 	}
 }
 
+func TestGetShareRequestCapacityLimit(t *testing.T) {
+	tests := []struct {
+		name             string
+		cap              *csi.CapacityRange
+		minSizeBytes     int64
+		expectedCode     codes.Code
+		expectedCapacity int64
+	}{
+		{
+			name:             "limit rounded down",
+			cap:              &csi.CapacityRange{LimitBytes: 200*util.Gb + util.Gb/2},
+			minSizeBytes:     util.MinShareSizeBytes,
+			expectedCapacity: 200 * util.Gb,
+		},
+		{
+			name:             "req and limit in the same GiB",
+			cap:              &csi.CapacityRange{RequiredBytes: 20 * util.Gb, LimitBytes: 20*util.Gb + 1},
+			minSizeBytes:     util.ConfigurablePackMinShareSizeBytes,
+			expectedCapacity: 20 * util.Gb,
+		},
+		{
+			name:         "req rounded up above limit",
+			cap:          &csi.CapacityRange{RequiredBytes: 20*util.Gb + 1, LimitBytes: 20*util.Gb + util.Gb/2},
+			minSizeBytes: util.ConfigurablePackMinShareSizeBytes,
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			name:         "limit below minimum share size",
+			cap:          &csi.CapacityRange{LimitBytes: 100*util.Gb - 1},
+			minSizeBytes: util.MinShareSizeBytes,
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			name:         "req above maximum share size",
+			cap:          &csi.CapacityRange{RequiredBytes: util.MaxShareSizeBytes + util.Gb},
+			minSizeBytes: util.ConfigurablePackMinShareSizeBytes,
+			expectedCode: codes.OutOfRange,
+		},
+		{
+			name:         "limit below req",
+			cap:          &csi.CapacityRange{RequiredBytes: 200 * util.Gb, LimitBytes: 150 * util.Gb},
+			minSizeBytes: util.MinShareSizeBytes,
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			capacity, err := getShareRequestCapacity(tc.cap, tc.minSizeBytes, util.MaxShareSizeBytes)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("got code %v, want %v: %v", code, tc.expectedCode, err)
+			}
+			if tc.expectedCapacity != capacity {
+				t.Errorf("got %v, want %v", capacity, tc.expectedCapacity)
+			}
+		})
+	}
+}
+
+func TestGetClusterLocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		zone       string
+		isRegional bool
+		override   string
+		expected   string
+		expectErr  bool
+	}{
+		{
+			name:     "zonal cluster",
+			zone:     "us-central1-c",
+			expected: "us-central1-c",
+		},
+		{
+			name:       "regional cluster",
+			zone:       "us-central1-c",
+			isRegional: true,
+			expected:   "us-central1",
+		},
+		{
+			name:       "override",
+			zone:       "us-central1-c",
+			isRegional: true,
+			override:   "on-prem-dc1",
+			expected:   "on-prem-dc1",
+		},
+		{
+			name:       "invalid zone for regional cluster",
+			zone:       "uscentral1",
+			isRegional: true,
+			expectErr:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			location, err := getClusterLocation(tc.zone, tc.isRegional, tc.override)
+			if tc.expectErr && err == nil {
+				t.Errorf("expected error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if location != tc.expected {
+				t.Errorf("got location %q, expected %q", location, tc.expected)
+			}
+		})
+	}
+}
+
 func TestExtractInstanceLabels(t *testing.T) {
 	var (
 		parameterLabels = "key1=value1,key2=value2"
 	)
 
 	tests := []struct {
-		name          string
-		params        map[string]string
-		driver        string
-		cliLabels     map[string]string
-		expectedLabel map[string]string
-		expectErr     bool
+		name               string
+		params             map[string]string
+		driver             string
+		cliLabels          map[string]string
+		sharedClusterGroup string
+		expectedLabel      map[string]string
+		expectErr          bool
 	}{
 		{
 			name:   "empty params",
@@ -442,6 +553,17 @@ func TestExtractInstanceLabels(t *testing.T) {
 				TagKeyClusterLocation: testLocation,
 			},
 		},
+		{
+			name:               "shared cluster group",
+			driver:             testDriverName,
+			sharedClusterGroup: "blue-green",
+			expectedLabel: map[string]string{
+				tagKeyCreatedBy:          testDrivernameLabelValue,
+				TagKeyClusterName:        testClusterName,
+				TagKeyClusterLocation:    testLocation,
+				TagKeySharedClusterGroup: "blue-green",
+			},
+		},
 		{
 			name:   "user labels",
 			driver: testDriverName,
@@ -559,7 +681,7 @@ func TestExtractInstanceLabels(t *testing.T) {
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			label, err := extractInstanceLabels(tc.params, tc.cliLabels, tc.driver, testClusterName, testLocation)
+			label, err := extractInstanceLabels(tc.params, tc.cliLabels, tc.driver, testClusterName, testLocation, tc.sharedClusterGroup)
 			if tc.expectErr && err == nil {
 				t.Error("expected error, got none")
 			}
@@ -637,6 +759,7 @@ func TestGenerateNewMultishareInstance(t *testing.T) {
 	tests := []struct {
 		name             string
 		instanceName     string
+		clusterUID       string
 		req              *csi.CreateVolumeRequest
 		expectedInstance *file.MultishareInstance
 		expectErr        bool
@@ -688,14 +811,101 @@ func TestGenerateNewMultishareInstance(t *testing.T) {
 					tagKeyCreatedBy:                        "test-driver",
 					TagKeyClusterLocation:                  testRegion,
 					TagKeyClusterName:                      testClusterName,
+					TagKeyDriverVersion:                    "test-version",
 					util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
 				},
 			},
 		},
+		{
+			name:         "instance size bounds",
+			instanceName: testInstanceName,
+			req: &csi.CreateVolumeRequest{
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+					paramMinInstanceSize:           "2Ti",
+					paramMaxInstanceSize:           "5Ti",
+				},
+			},
+			expectedInstance: &file.MultishareInstance{
+				Project:       "test-project",
+				Location:      "us-central1",
+				Name:          testInstanceName,
+				CapacityBytes: 2 * util.Tb,
+				Network: file.Network{
+					Name:        "default",
+					ConnectMode: directPeering,
+				},
+				Tier: enterpriseTier,
+				Labels: map[string]string{
+					tagKeyCreatedBy:                             "test-driver",
+					TagKeyClusterLocation:                       testRegion,
+					TagKeyClusterName:                           testClusterName,
+					TagKeyDriverVersion:                         "test-version",
+					util.ParamMultishareInstanceScLabelKey:      testInstanceScPrefix,
+					util.ParamMultishareInstanceMinSizeLabelKey: "2048",
+					util.ParamMultishareInstanceMaxSizeLabelKey: "5120",
+				},
+			},
+		},
+		{
+			name:         "ownership labels",
+			instanceName: testInstanceName,
+			clusterUID:   "3f6d1c2a-8b7e-4a5f-9c0d-1e2f3a4b5c6d",
+			req: &csi.CreateVolumeRequest{
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+					ParameterKeyPVName:             "pvc-1234",
+				},
+			},
+			expectedInstance: &file.MultishareInstance{
+				Project:       "test-project",
+				Location:      "us-central1",
+				Name:          testInstanceName,
+				CapacityBytes: util.MinMultishareInstanceSizeBytes,
+				Network: file.Network{
+					Name:        "default",
+					ConnectMode: directPeering,
+				},
+				Tier: enterpriseTier,
+				Labels: map[string]string{
+					tagKeyCreatedBy:                        "test-driver",
+					tagKeyCreatedForVolumeName:             "pvc-1234",
+					TagKeyClusterLocation:                  testRegion,
+					TagKeyClusterName:                      testClusterName,
+					TagKeyClusterUID:                       "3f6d1c2a-8b7e-4a5f-9c0d-1e2f3a4b5c6d",
+					TagKeyDriverVersion:                    "test-version",
+					util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+				},
+			},
+		},
+		{
+			name:         "invalid share spread by namespace",
+			instanceName: testInstanceName,
+			req: &csi.CreateVolumeRequest{
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+					paramShareSpreadByNamespace:    "yes please",
+				},
+			},
+			expectErr: true,
+		},
+		{
+			name:         "invalid instance size bounds",
+			instanceName: testInstanceName,
+			req: &csi.CreateVolumeRequest{
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+					paramMinInstanceSize:           "5Ti",
+					paramMaxInstanceSize:           "2Ti",
+				},
+			},
+			expectErr: true,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			m := initTestMultishareController(t)
+			m.clusterUID = tc.clusterUID
 			filer, err := m.generateNewMultishareInstance(tc.instanceName, tc.req, 10)
 			if tc.expectErr && err == nil {
 				t.Error("expected error, got none")
@@ -758,7 +968,8 @@ func TestGenerateCSICreateVolumeResponse(t *testing.T) {
 					VolumeId:      modeMultishare + "/" + testInstanceScPrefix + "/" + testProject + "/" + testLocation + "/" + testInstanceName + "/" + testShareName,
 					CapacityBytes: 1 * util.Tb,
 					VolumeContext: map[string]string{
-						attrIP: "1.1.1.1",
+						attrContextVersion: "1",
+						attrIP:             "1.1.1.1",
 					},
 				},
 			},
@@ -789,8 +1000,9 @@ func TestGenerateCSICreateVolumeResponse(t *testing.T) {
 					VolumeId:      modeMultishare + "/" + testInstanceScPrefix + "/" + testProject + "/" + testLocation + "/" + testInstanceName + "/" + testShareName,
 					CapacityBytes: 1 * util.Tb,
 					VolumeContext: map[string]string{
-						attrIP:           "1.1.1.1",
-						attrMaxShareSize: strconv.Itoa(util.Tb),
+						attrContextVersion: "1",
+						attrIP:             "1.1.1.1",
+						attrMaxShareSize:   strconv.Itoa(util.Tb),
 					},
 				},
 			},
@@ -821,8 +1033,9 @@ func TestGenerateCSICreateVolumeResponse(t *testing.T) {
 					VolumeId:      modeMultishare + "/" + testInstanceScPrefix + "/" + testProject + "/" + testLocation + "/" + testInstanceName + "/" + testShareName,
 					CapacityBytes: 1 * util.Tb,
 					VolumeContext: map[string]string{
-						attrIP:           "1.1.1.1",
-						attrMaxShareSize: strconv.Itoa(100 * util.Gb),
+						attrContextVersion: "1",
+						attrIP:             "1.1.1.1",
+						attrMaxShareSize:   strconv.Itoa(100 * util.Gb),
 					},
 				},
 			},
@@ -892,6 +1105,52 @@ func TestGenerateInstanceDescFromEcfsDesc(t *testing.T) {
 	}
 }
 
+func TestMultishareShareName(t *testing.T) {
+	tests := []struct {
+		name          string
+		parameters    map[string]string
+		expectedName  string
+		errorExpected bool
+	}{
+		{
+			name:         "volume name",
+			parameters:   map[string]string{ParamMultishareInstanceScLabel: "pool-a"},
+			expectedName: "pvc_0123",
+		},
+		{
+			name:         "sc prefix disabled",
+			parameters:   map[string]string{ParamMultishareInstanceScLabel: "pool-a", paramShareNameWithScPrefix: "false"},
+			expectedName: "pvc_0123",
+		},
+		{
+			name:         "sc prefix",
+			parameters:   map[string]string{ParamMultishareInstanceScLabel: "pool-a", paramShareNameWithScPrefix: "true"},
+			expectedName: "pool_a_pvc_0123",
+		},
+		{
+			name:          "invalid sc prefix parameter",
+			parameters:    map[string]string{ParamMultishareInstanceScLabel: "pool-a", paramShareNameWithScPrefix: "yes"},
+			errorExpected: true,
+		},
+		{
+			name:          "sc prefix too long",
+			parameters:    map[string]string{ParamMultishareInstanceScLabel: strings.Repeat("a", 60), paramShareNameWithScPrefix: "true"},
+			errorExpected: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			name, err := multishareShareName(&csi.CreateVolumeRequest{Name: "pvc-0123", Parameters: tc.parameters})
+			if tc.errorExpected != (err != nil) {
+				t.Fatalf("got error %v, error expected %v", err, tc.errorExpected)
+			}
+			if name != tc.expectedName {
+				t.Errorf("got share name %q, expected %q", name, tc.expectedName)
+			}
+		})
+	}
+}
+
 func TestMultishareCreateVolume(t *testing.T) {
 	testVolName := "pvc-" + string(uuid.NewUUID())
 	testShareName := util.ConvertVolToShareName(testVolName)
@@ -1231,7 +1490,8 @@ func TestMultishareCreateVolume(t *testing.T) {
 					CapacityBytes: 100 * util.Gb,
 					VolumeId:      fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName1, testShareName),
 					VolumeContext: map[string]string{
-						attrIP: testIP,
+						attrContextVersion: "1",
+						attrIP:             testIP,
 					},
 				},
 			},
@@ -1349,11 +1609,211 @@ func TestMultishareCreateVolume(t *testing.T) {
 					CapacityBytes: 100 * util.Gb,
 					VolumeId:      fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName1, testShareName),
 					VolumeContext: map[string]string{
-						attrIP: testIP,
+						attrContextVersion: "1",
+						attrIP:             testIP,
 					},
 				},
 			},
 		},
+		{
+			name: "smaller share already exists, expand the share and return success",
+			initInstances: []*file.MultishareInstance{
+				{
+					Name:     testInstanceName1,
+					Location: "us-central1",
+					Project:  "test-project",
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      "",
+					},
+					CapacityBytes: 1 * util.Tb,
+					Tier:          "Enterprise",
+					Network: file.Network{
+						Ip: testIP,
+					},
+					State: "READY",
+				},
+			},
+			initShares: []*file.Share{
+				{
+					Name: testShareName,
+					Parent: &file.MultishareInstance{
+						Name:     testInstanceName1,
+						Location: "us-central1",
+						Project:  "test-project",
+						Labels: map[string]string{
+							util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						},
+						CapacityBytes: 1 * util.Tb,
+						Tier:          "Enterprise",
+						Network: file.Network{
+							Ip: testIP,
+						},
+						State: "READY",
+					},
+					CapacityBytes:  100 * util.Gb,
+					MountPointName: testShareName,
+					State:          "READY",
+				},
+			},
+			req: &csi.CreateVolumeRequest{
+				Name: testVolName,
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 200 * util.Gb,
+				},
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+			},
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: 200 * util.Gb,
+					VolumeId:      fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName1, testShareName),
+					VolumeContext: map[string]string{
+						attrContextVersion: "1",
+						attrIP:             testIP,
+					},
+				},
+			},
+		},
+		{
+			name: "share already exists on an instance of another instance pool, return already exists error",
+			initInstances: []*file.MultishareInstance{
+				{
+					Name:     testInstanceName1,
+					Location: "us-central1",
+					Project:  "test-project",
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: "other-pool",
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      "",
+					},
+					CapacityBytes: 1 * util.Tb,
+					Tier:          "Enterprise",
+					Network: file.Network{
+						Ip: testIP,
+					},
+					State: "READY",
+				},
+			},
+			initShares: []*file.Share{
+				{
+					Name: testShareName,
+					Parent: &file.MultishareInstance{
+						Name:     testInstanceName1,
+						Location: "us-central1",
+						Project:  "test-project",
+						Labels: map[string]string{
+							util.ParamMultishareInstanceScLabelKey: "other-pool",
+						},
+						CapacityBytes: 1 * util.Tb,
+						Tier:          "Enterprise",
+						Network: file.Network{
+							Ip: testIP,
+						},
+						State: "READY",
+					},
+					CapacityBytes:  100 * util.Gb,
+					MountPointName: testShareName,
+					State:          "READY",
+				},
+			},
+			req: &csi.CreateVolumeRequest{
+				Name: testVolName,
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 100 * util.Gb,
+				},
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+			},
+			errorExpected: true,
+		},
+		{
+			name: "larger share already exists, return already exists error",
+			initInstances: []*file.MultishareInstance{
+				{
+					Name:     testInstanceName1,
+					Location: "us-central1",
+					Project:  "test-project",
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      "",
+					},
+					CapacityBytes: 1 * util.Tb,
+					Tier:          "Enterprise",
+					Network: file.Network{
+						Ip: testIP,
+					},
+					State: "READY",
+				},
+			},
+			initShares: []*file.Share{
+				{
+					Name: testShareName,
+					Parent: &file.MultishareInstance{
+						Name:     testInstanceName1,
+						Location: "us-central1",
+						Project:  "test-project",
+						Labels: map[string]string{
+							util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						},
+						CapacityBytes: 1 * util.Tb,
+						Tier:          "Enterprise",
+						Network: file.Network{
+							Ip: testIP,
+						},
+						State: "READY",
+					},
+					CapacityBytes:  200 * util.Gb,
+					MountPointName: testShareName,
+					State:          "READY",
+				},
+			},
+			req: &csi.CreateVolumeRequest{
+				Name: testVolName,
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 100 * util.Gb,
+					LimitBytes:    100 * util.Gb,
+				},
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+			},
+			errorExpected: true,
+		},
 		// TODO: Add test cases for instance resize
 	}
 	for _, tc := range tests {
@@ -1569,7 +2029,8 @@ func TestMultishareCreateVolumeFromBackup(t *testing.T) {
 					CapacityBytes: 100 * util.Gb,
 					VolumeId:      fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName1, testShareName),
 					VolumeContext: map[string]string{
-						attrIP: testIP,
+						attrContextVersion: "1",
+						attrIP:             testIP,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -1608,7 +2069,8 @@ func TestMultishareCreateVolumeFromBackup(t *testing.T) {
 					CapacityBytes: 100 * util.Gb,
 					VolumeId:      fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName1, testShareName),
 					VolumeContext: map[string]string{
-						attrIP: testIP,
+						attrContextVersion: "1",
+						attrIP:             testIP,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -1693,7 +2155,8 @@ func TestMultishareCreateVolumeFromBackup(t *testing.T) {
 					CapacityBytes: 100 * util.Gb,
 					VolumeId:      fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName1, testShareName),
 					VolumeContext: map[string]string{
-						attrIP: testIP,
+						attrContextVersion: "1",
+						attrIP:             testIP,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -1752,7 +2215,8 @@ func TestMultishareCreateVolumeFromBackup(t *testing.T) {
 					CapacityBytes: 100 * util.Gb,
 					VolumeId:      fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName1, testShareName),
 					VolumeContext: map[string]string{
-						attrIP: testIP,
+						attrContextVersion: "1",
+						attrIP:             testIP,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -1831,7 +2295,8 @@ func TestMultishareCreateVolumeFromBackup(t *testing.T) {
 					CapacityBytes: 100 * util.Gb,
 					VolumeId:      fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName1, testShareName),
 					VolumeContext: map[string]string{
-						attrIP: testIP,
+						attrContextVersion: "1",
+						attrIP:             testIP,
 					},
 					ContentSource: &csi.VolumeContentSource{
 						Type: &csi.VolumeContentSource_Snapshot{
@@ -2639,6 +3104,133 @@ func TestIsValidMaxVolSize(t *testing.T) {
 	}
 }
 
+func TestParseInstanceSizeBounds(t *testing.T) {
+	tests := []struct {
+		name             string
+		params           map[string]string
+		expectedMinBytes int64
+		expectedMaxBytes int64
+		expectError      bool
+	}{
+		{
+			name:             "no bounds",
+			expectedMinBytes: util.MinMultishareInstanceSizeBytes,
+			expectedMaxBytes: util.MaxMultishareInstanceSizeBytes,
+		},
+		{
+			name:             "min bound",
+			params:           map[string]string{paramMinInstanceSize: "2Ti"},
+			expectedMinBytes: 2 * util.Tb,
+			expectedMaxBytes: util.MaxMultishareInstanceSizeBytes,
+		},
+		{
+			name:             "max bound",
+			params:           map[string]string{paramMaxInstanceSize: "5120Gi"},
+			expectedMinBytes: util.MinMultishareInstanceSizeBytes,
+			expectedMaxBytes: 5 * util.Tb,
+		},
+		{
+			name:             "equal bounds",
+			params:           map[string]string{paramMinInstanceSize: "5Ti", paramMaxInstanceSize: "5Ti"},
+			expectedMinBytes: 5 * util.Tb,
+			expectedMaxBytes: 5 * util.Tb,
+		},
+		{
+			name:        "min greater than max",
+			params:      map[string]string{paramMinInstanceSize: "5Ti", paramMaxInstanceSize: "2Ti"},
+			expectError: true,
+		},
+		{
+			name:        "below tier limit",
+			params:      map[string]string{paramMinInstanceSize: "512Gi"},
+			expectError: true,
+		},
+		{
+			name:        "above tier limit",
+			params:      map[string]string{paramMaxInstanceSize: "11Ti"},
+			expectError: true,
+		},
+		{
+			name:        "not a multiple of 1Gi",
+			params:      map[string]string{paramMaxInstanceSize: "2T"},
+			expectError: true,
+		},
+		{
+			name:        "invalid quantity",
+			params:      map[string]string{paramMaxInstanceSize: "five"},
+			expectError: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			minBytes, maxBytes, err := parseInstanceSizeBounds(tc.params)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if minBytes != tc.expectedMinBytes || maxBytes != tc.expectedMaxBytes {
+				t.Errorf("got bounds [%d, %d], expected [%d, %d]", minBytes, maxBytes, tc.expectedMinBytes, tc.expectedMaxBytes)
+			}
+		})
+	}
+}
+
+func TestInstanceSizeBounds(t *testing.T) {
+	tests := []struct {
+		name             string
+		instance         *file.MultishareInstance
+		expectedMinBytes int64
+		expectedMaxBytes int64
+	}{
+		{
+			name:             "no bounds nor reported max capacity",
+			instance:         &file.MultishareInstance{},
+			expectedMinBytes: util.MinMultishareInstanceSizeBytes,
+			expectedMaxBytes: util.MaxMultishareInstanceSizeBytes,
+		},
+		{
+			name:             "reported max capacity",
+			instance:         &file.MultishareInstance{MaxCapacityBytes: 100 * util.Tb},
+			expectedMinBytes: util.MinMultishareInstanceSizeBytes,
+			expectedMaxBytes: 100 * util.Tb,
+		},
+		{
+			name: "bounds within the reported max capacity",
+			instance: &file.MultishareInstance{
+				MaxCapacityBytes: 100 * util.Tb,
+				Labels: map[string]string{
+					util.ParamMultishareInstanceMinSizeLabelKey: "2048",
+					util.ParamMultishareInstanceMaxSizeLabelKey: "5120",
+				},
+			},
+			expectedMinBytes: 2 * util.Tb,
+			expectedMaxBytes: 5 * util.Tb,
+		},
+		{
+			name: "max bound above the reported max capacity",
+			instance: &file.MultishareInstance{
+				MaxCapacityBytes: 5 * util.Tb,
+				Labels:           map[string]string{util.ParamMultishareInstanceMaxSizeLabelKey: "10240"},
+			},
+			expectedMinBytes: util.MinMultishareInstanceSizeBytes,
+			expectedMaxBytes: 5 * util.Tb,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			minBytes, maxBytes := instanceSizeBounds(tc.instance)
+			if minBytes != tc.expectedMinBytes || maxBytes != tc.expectedMaxBytes {
+				t.Errorf("got bounds [%d, %d], expected [%d, %d]", minBytes, maxBytes, tc.expectedMinBytes, tc.expectedMaxBytes)
+			}
+		})
+	}
+}
+
 func TestParseMaxVolumeSizeParam(t *testing.T) {
 	tests := []struct {
 		name                          string
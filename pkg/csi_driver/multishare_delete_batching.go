@@ -0,0 +1,197 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+// instanceDeleteBatch is the queue of the share deletions of a multishare instance. A single
+// worker per instance runs the deletions back to back, so that they do not contend for the
+// one operation at a time allowed on the instance, and shrinks or deletes the instance once
+// the queue is drained instead of once per share.
+type instanceDeleteBatch struct {
+	instance *file.MultishareInstance
+	uri      string
+	// pending are the deletions not started yet, in arrival order.
+	pending []*shareDeletion
+	// deletions maps share name to the deletions pending or in flight, so that a DeleteVolume
+	// retried while its deletion is queued waits on the same deletion.
+	deletions map[string]*shareDeletion
+}
+
+// shareDeletion is a share deletion queued on the batch of its instance. done is closed once
+// the deletion, and the instance shrink or delete following it, completed with err.
+type shareDeletion struct {
+	shareName string
+	// share is nil if the share is already deleted, only the instance shrink or delete is left.
+	share *file.Share
+	done  chan struct{}
+	err   error
+}
+
+// queueShareDelete queues the deletion of a share of the instance, starting the worker of the
+// instance if none is running. share is nil if only the instance shrink or delete is needed.
+func (m *MultishareOpsManager) queueShareDelete(instance *file.MultishareInstance, shareName string, share *file.Share) (*shareDeletion, error) {
+	uri, err := file.GenerateMultishareInstanceURI(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	m.deleteBatchesLock.Lock()
+	defer m.deleteBatchesLock.Unlock()
+	batch, ok := m.deleteBatches[uri]
+	if !ok {
+		batch = &instanceDeleteBatch{
+			instance:  instance,
+			uri:       uri,
+			deletions: make(map[string]*shareDeletion),
+		}
+		m.deleteBatches[uri] = batch
+		go m.runDeleteBatch(batch)
+	}
+	if d, ok := batch.deletions[shareName]; ok {
+		return d, nil
+	}
+	d := &shareDeletion{shareName: shareName, share: share, done: make(chan struct{})}
+	batch.pending = append(batch.pending, d)
+	batch.deletions[shareName] = d
+	klog.V(4).Infof("Queued deletion of share %s of instance %s, %d deletions queued", shareName, uri, len(batch.deletions))
+	m.recordQueuedShareDeletions(batch)
+	return d, nil
+}
+
+// waitForShareDeletion waits for a queued deletion to complete. If ctx is done first, the
+// deletion keeps running and the retried DeleteVolume call waits for it again.
+func waitForShareDeletion(ctx context.Context, d *shareDeletion) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// runDeleteBatch runs the queued share deletions of an instance until its queue is drained.
+// The deletions are not bound to the context of the DeleteVolume calls queuing them, their
+// ops are bounded by the multishare op timeouts.
+func (m *MultishareOpsManager) runDeleteBatch(batch *instanceDeleteBatch) {
+	ctx := context.Background()
+	for {
+		var deleted []*shareDeletion
+		for d := m.nextShareDeletion(batch); d != nil; d = m.nextShareDeletion(batch) {
+			if err := m.deleteQueuedShare(ctx, d); err != nil {
+				m.completeShareDeletions(batch, []*shareDeletion{d}, err)
+				continue
+			}
+			deleted = append(deleted, d)
+		}
+
+		// The instance is shrunk or deleted once for all the shares deleted above.
+		var err error
+		if len(deleted) > 0 {
+			instance := *batch.instance
+			err = m.msControllerServer.waitForInstanceDeleteOrShrink(ctx, &instance)
+			if err != nil {
+				klog.Errorf("Failed to shrink or delete instance %s after %d share deletions: %v", batch.uri, len(deleted), err)
+			}
+		}
+		m.completeShareDeletions(batch, deleted, err)
+		if m.dropDrainedDeleteBatch(batch) {
+			return
+		}
+	}
+}
+
+// nextShareDeletion pops the next pending deletion of the batch, nil if none.
+func (m *MultishareOpsManager) nextShareDeletion(batch *instanceDeleteBatch) *shareDeletion {
+	m.deleteBatchesLock.Lock()
+	defer m.deleteBatchesLock.Unlock()
+	if len(batch.pending) == 0 {
+		return nil
+	}
+	d := batch.pending[0]
+	batch.pending = batch.pending[1:]
+	return d
+}
+
+// deleteQueuedShare deletes the share of a queued deletion, a share already deleted is not an
+// error.
+func (m *MultishareOpsManager) deleteQueuedShare(ctx context.Context, d *shareDeletion) error {
+	if d.share == nil {
+		return nil
+	}
+	workflow, err := m.checkAndStartShareDeleteWorkflow(ctx, d.share)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil
+		}
+		return err
+	}
+	if workflow == nil {
+		return nil
+	}
+	if err := m.msControllerServer.waitOnWorkflow(ctx, workflow); err != nil {
+		return fmt.Errorf("%v operation %q poll error: %w", workflow.opType, workflow.opName, err)
+	}
+	return nil
+}
+
+// completeShareDeletions completes the deletions with err.
+func (m *MultishareOpsManager) completeShareDeletions(batch *instanceDeleteBatch, deletions []*shareDeletion, err error) {
+	m.deleteBatchesLock.Lock()
+	defer m.deleteBatchesLock.Unlock()
+	for _, d := range deletions {
+		d.err = err
+		close(d.done)
+		delete(batch.deletions, d.shareName)
+		if m.controllerServer != nil {
+			m.controllerServer.config.metricsManager.RecordBatchedShareDeletion(err)
+		}
+	}
+	m.recordQueuedShareDeletions(batch)
+}
+
+// dropDrainedDeleteBatch drops the batch if no deletion was queued since its worker last
+// checked, and returns true if it did. The worker must then return, the next deletion of
+// the instance starts a new batch.
+func (m *MultishareOpsManager) dropDrainedDeleteBatch(batch *instanceDeleteBatch) bool {
+	m.deleteBatchesLock.Lock()
+	defer m.deleteBatchesLock.Unlock()
+	if len(batch.pending) > 0 {
+		return false
+	}
+	delete(m.deleteBatches, batch.uri)
+	if m.controllerServer != nil {
+		m.controllerServer.config.metricsManager.DeleteQueuedShareDeletions(batch.uri)
+	}
+	return true
+}
+
+// recordQueuedShareDeletions records the number of deletions pending or in flight of the
+// batch. Must be called with deleteBatchesLock held.
+func (m *MultishareOpsManager) recordQueuedShareDeletions(batch *instanceDeleteBatch) {
+	if m.controllerServer == nil {
+		return
+	}
+	m.controllerServer.config.metricsManager.RecordQueuedShareDeletions(batch.uri, len(batch.deletions))
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestMultishareDeleteVolumeBatching(t *testing.T) {
+	const shareCount = 10
+	tests := []struct {
+		name string
+		// fault is injected into the share deletions.
+		fault                   *file.Fault
+		expectedFailures        int
+		expectedInstanceDeletes int
+	}{
+		{
+			name:                    "all shares deleted, instance deleted once",
+			expectedInstanceDeletes: 1,
+		},
+		{
+			name:             "share delete failure reported to its volume only",
+			fault:            &file.Fault{OnCall: 2, Err: status.Error(codes.Internal, "injected")},
+			expectedFailures: 1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			instance := &file.MultishareInstance{
+				Name:          "instance-1",
+				Location:      testRegion,
+				Project:       testProject,
+				Labels:        map[string]string{util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix},
+				CapacityBytes: 1 * util.Tb,
+				Tier:          enterpriseTier,
+				State:         "READY",
+			}
+			var shares []*file.Share
+			var volumeIDs []string
+			for i := 0; i < shareCount; i++ {
+				name := fmt.Sprintf("pvc_%d", i)
+				shares = append(shares, &file.Share{Name: name, Parent: instance, CapacityBytes: 100 * util.Gb, State: "READY"})
+				volumeIDs = append(volumeIDs, fmt.Sprintf("%s/%s/%s/%s/%s/%s", modeMultishare, testInstanceScPrefix, testProject, testRegion, instance.Name, name))
+			}
+			fakeService, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instance}, shares, nil)
+			if err != nil {
+				t.Fatalf("failed to initialize GCFS service: %v", err)
+			}
+			faults := file.NewFaultInjector()
+			// The first share deletion is slow, so that the other ones queue behind it.
+			faults.Inject("StartDeleteShareOp", file.Fault{OnCall: 1, Latency: 100 * time.Millisecond})
+			if tc.fault != nil {
+				faults.Inject("StartDeleteShareOp", *tc.fault)
+			}
+			fileService := file.NewFakeServiceWithFaults(fakeService, faults)
+			cloudProvider, err := cloud.NewFakeCloudWithFiler(fileService, testProject, testLocation)
+			if err != nil {
+				t.Fatalf("failed to get cloud provider: %v", err)
+			}
+			mcs := NewMultishareController(&controllerServerConfig{
+				driver:      initTestDriver(t),
+				fileService: fileService,
+				cloud:       cloudProvider,
+				volumeLocks: util.NewVolumeLocks(),
+				features: &GCFSDriverFeatureOptions{
+					FeatureMultishareDeleteBatching: &FeatureMultishareDeleteBatching{Enabled: true},
+				},
+			})
+
+			var wg sync.WaitGroup
+			errs := make([]error, shareCount)
+			for i := range volumeIDs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, errs[i] = mcs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeIDs[i]})
+				}(i)
+			}
+			wg.Wait()
+			failures := 0
+			for _, err := range errs {
+				if err != nil {
+					failures++
+				}
+			}
+			if failures != tc.expectedFailures {
+				t.Errorf("got %d failed deletions %v, expected %d", failures, errs, tc.expectedFailures)
+			}
+
+			if got := faults.Calls("StartDeleteMultishareInstanceOp"); got != tc.expectedInstanceDeletes {
+				t.Errorf("got %d instance delete ops, expected %d", got, tc.expectedInstanceDeletes)
+			}
+			if got := faults.Calls("StartResizeMultishareInstanceOp"); got != 0 {
+				t.Errorf("got %d instance resize ops, expected none", got)
+			}
+			mcs.opsManager.deleteBatchesLock.Lock()
+			defer mcs.opsManager.deleteBatchesLock.Unlock()
+			if len(mcs.opsManager.deleteBatches) != 0 {
+				t.Errorf("got %d delete batches left, expected none", len(mcs.opsManager.deleteBatches))
+			}
+		})
+	}
+}
+
+func TestQueueShareDeleteRetried(t *testing.T) {
+	mcs := NewMultishareController(&controllerServerConfig{driver: initTestDriver(t)})
+	m := mcs.opsManager
+	instance := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "instance-1"}
+	share := &file.Share{Name: "pvc_1", Parent: instance}
+
+	// A batch without a worker, so that the queued deletion is not started.
+	m.deleteBatches["projects/test-project/locations/us-central1/instances/instance-1"] = &instanceDeleteBatch{
+		instance:  instance,
+		uri:       "projects/test-project/locations/us-central1/instances/instance-1",
+		deletions: make(map[string]*shareDeletion),
+	}
+
+	first, err := m.queueShareDelete(instance, share.Name, share)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// A DeleteVolume retried after its context expired waits on the queued deletion.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitForShareDeletion(ctx, first); status.Code(err) != codes.Canceled {
+		t.Errorf("got error %v, expected code %v", err, codes.Canceled)
+	}
+	retried, err := m.queueShareDelete(instance, share.Name, share)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if retried != first {
+		t.Errorf("retried deletion queued twice")
+	}
+	batch := m.deleteBatches["projects/test-project/locations/us-central1/instances/instance-1"]
+	if len(batch.pending) != 1 {
+		t.Errorf("got %d pending deletions, expected 1", len(batch.pending))
+	}
+}
@@ -21,23 +21,38 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	pbSanitizer "github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"golang.org/x/sync/errgroup"
 	filev1beta1multishare "google.golang.org/api/file/v1beta1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
 )
 
+// runningOpsFilter is the server-side filter expression matching operations that are not done.
+const runningOpsFilter = "done=false"
+
 type OpInfo struct {
 	Id     string
 	Type   util.OperationType
 	Target string
+	// CreateTime is the time the op was created, zero if not reported.
+	CreateTime time.Time
+	// TargetBytes is the capacity an instance update op started by the driver resizes the
+	// instance to, or the capacity of the share of a share create op started by the driver,
+	// zero if unknown, e.g. for the ops started before the driver restarted.
+	TargetBytes int64
 }
 
 // A workflow is defined as a sequence of steps to safely initiate instance or share operations.
@@ -54,22 +69,113 @@ type MultishareOpsManager struct {
 	cloud              *cloud.Cloud
 	controllerServer   *controllerServer
 	msControllerServer *MultishareController
+	// shareListParallelism bounds the number of concurrent per-instance share list calls.
+	shareListParallelism int
+	// excludedInstances maps instance URI to the state of the instances excluded from
+	// packing at the last eligible instance check which found them.
+	excludedInstances map[string]string
+	// stuckOpThreshold, if non-zero, is the age after which a running op is reported stuck.
+	stuckOpThreshold time.Duration
+	// stuckOps is the set of the IDs of the running ops already reported stuck.
+	stuckOps map[string]bool
+	// instanceCreationDeferrer, if non-nil, defers the creation of the instances of the PVCs
+	// no pod uses yet.
+	instanceCreationDeferrer *instanceCreationDeferrer
+	// maxRegionalCapacityBytes, if non-zero, caps the total capacity of the multishare
+	// instances of a region, see checkRegionalCapacity.
+	maxRegionalCapacityBytes int64
+	// deleteBatching queues the share deletions per instance, see instanceDeleteBatch.
+	deleteBatching bool
+	// deleteBatchesLock guards deleteBatches, it is not held while the queued deletions run.
+	deleteBatchesLock sync.Mutex
+	// deleteBatches maps instance URI to the share deletions queued on the instance.
+	deleteBatches map[string]*instanceDeleteBatch
+	// pendingWorkflowsLock guards pendingWorkflows, it is not held while waiting on the operations.
+	pendingWorkflowsLock sync.Mutex
+	// pendingWorkflows maps the name of a CreateVolume request to the workflow whose wait on its
+	// operation ended before the operation, see recordPendingWorkflow.
+	pendingWorkflows map[string]*Workflow
+	// opTargets maps the name of the instance update and share create ops started by the
+	// driver to their target capacity, which the Filestore ops don't report. Guarded by the ops
+	// manager lock.
+	opTargets map[string]int64
+	// instanceNamer generates the names of the new instances, with a UUID suffix if nil.
+	instanceNamer instanceNamer
+	// reservedIPRangesLock guards reservedIPRanges, it is not held while the ranges are looked up.
+	reservedIPRangesLock sync.Mutex
+	// reservedIPRanges maps <project>/<name> to the CIDR of the named reserved IP ranges, empty
+	// if their lookup failed, cached for reservedIPRangeCacheTTL.
+	reservedIPRanges map[string]*reservedIPRangeEntry
+	// For testing purposes.
+	now func() time.Time
+}
+
+// reservedIPRangeCacheTTL is the duration the CIDRs of the named reserved IP ranges are cached.
+const reservedIPRangeCacheTTL = 5 * time.Minute
+
+type reservedIPRangeEntry struct {
+	cidr   string
+	expiry time.Time
+}
+
+// instanceExcludedStates are the states of the multishare instances which are excluded from
+// packing until they recover, e.g. SUSPENDED when the CMEK key of the instance is revoked.
+var instanceExcludedStates = map[string]bool{
+	"ERROR":      true,
+	"SUSPENDING": true,
+	"SUSPENDED":  true,
 }
 
 func NewMultishareOpsManager(cloud *cloud.Cloud, mcs *MultishareController) *MultishareOpsManager {
 	return &MultishareOpsManager{
 		cloud:              cloud,
 		msControllerServer: mcs,
+		excludedInstances:  make(map[string]string),
+		stuckOps:           make(map[string]bool),
+		deleteBatches:      make(map[string]*instanceDeleteBatch),
+		pendingWorkflows:   make(map[string]*Workflow),
+
+		opTargets:        make(map[string]int64),
+		reservedIPRanges: make(map[string]*reservedIPRangeEntry),
+		now:              time.Now,
 	}
 }
 
+// recordPendingWorkflow records the workflow of a CreateVolume request whose wait ended with
+// err, if its operation is still running, e.g. when the request was cancelled. The retry of the
+// request resumes the wait on the operation, instead of failing while the operation runs.
+func (m *MultishareOpsManager) recordPendingWorkflow(name string, w *Workflow, err error) {
+	if !file.IsOpPendingErr(err) {
+		return
+	}
+	klog.Infof("Operation %s (type %s) of volume %s still running, its wait is resumed on retry", w.opName, w.opType.String(), name)
+	m.pendingWorkflowsLock.Lock()
+	defer m.pendingWorkflowsLock.Unlock()
+	m.pendingWorkflows[name] = w
+}
+
+// takePendingWorkflow returns and forgets the pending workflow of a CreateVolume request, nil
+// if there is none.
+func (m *MultishareOpsManager) takePendingWorkflow(name string) *Workflow {
+	m.pendingWorkflowsLock.Lock()
+	defer m.pendingWorkflowsLock.Unlock()
+	w := m.pendingWorkflows[name]
+	delete(m.pendingWorkflows, name)
+	return w
+}
+
 // setupEligibleInstanceAndStartWorkflow returns a workflow object (to indicate an instance or share level workflow is started), or a share object (if existing share already found), or error.
 func (m *MultishareOpsManager) setupEligibleInstanceAndStartWorkflow(ctx context.Context, req *csi.CreateVolumeRequest, instance *file.MultishareInstance, sourceSnapshotId string) (*Workflow, *file.Share, error) {
+	// The lookup of a named reserved IP range must not block the other requests.
+	reservedIPRangeCIDR := m.resolveReservedIPRange(ctx, req.GetParameters())
 	m.Lock()
 	defer m.Unlock()
 
 	// Check ShareCreateMap if a share create is already in progress.
-	shareName := util.ConvertVolToShareName(req.Name)
+	shareName, err := multishareShareName(req)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	ops, err := m.listMultishareResourceRunningOps(ctx)
 	if err != nil {
@@ -101,28 +207,35 @@ func (m *MultishareOpsManager) setupEligibleInstanceAndStartWorkflow(ctx context
 	}
 
 	// No share or running share create op found. Proceed to eligible instance check.
-	eligible, err := m.runEligibleInstanceCheck(ctx, req, ops, instance, regions)
+	eligible, err := m.runEligibleInstanceCheck(ctx, req, ops, instance, regions, reservedIPRangeCIDR)
 	if err != nil {
 		return nil, nil, status.Error(codes.Aborted, err.Error())
 	}
 
 	if len(eligible) > 0 {
-		// pick a random eligible instance
-		index := rand.Intn(len(eligible))
+		if spread, _ := strconv.ParseBool(req.GetParameters()[paramShareSpreadByNamespace]); spread {
+			eligible, err = m.spreadByNamespace(ctx, eligible, req.GetParameters()[ParameterKeyPVCNamespace])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		index, err := m.pickEligibleInstance(ctx, eligible, m.packingPolicy(req), ops)
+		if err != nil {
+			return nil, nil, err
+		}
 		klog.V(5).Infof("For share %s, using instance %s as placeholder", shareName, eligible[index].String())
 		share, err := generateNewShare(shareName, eligible[index], req, sourceSnapshotId)
 		if err != nil {
 			return nil, nil, status.Error(codes.Internal, err.Error())
 		}
 
-		needExpand, targetBytes, err := m.instanceNeedsExpand(ctx, share, share.CapacityBytes)
+		needExpand, targetBytes, err := m.instanceNeedsExpand(ctx, share, share.CapacityBytes, ops)
 		if err != nil {
 			return nil, nil, err
 		}
 
 		if needExpand {
-			eligible[index].CapacityBytes = targetBytes
-			w, err := m.startInstanceWorkflow(ctx, &Workflow{instance: eligible[index], opType: util.InstanceUpdate}, ops)
+			w, err := m.startInstanceExpansion(ctx, eligible[index], targetBytes, ops)
 			return w, nil, err
 		}
 
@@ -131,6 +244,13 @@ func (m *MultishareOpsManager) setupEligibleInstanceAndStartWorkflow(ctx context
 	}
 
 	param := req.GetParameters()
+	// Storage classes of a fixed, pre-created instance pool fail fast instead of creating an instance.
+	if allow, err := strconv.ParseBool(param[paramAllowNewInstances]); err == nil && !allow {
+		return nil, nil, status.Errorf(codes.ResourceExhausted, "no instance of instance pool %q can fit share %s and %s is false", param[ParamMultishareInstanceScLabel], shareName, paramAllowNewInstances)
+	}
+	if err := m.instanceCreationDeferrer.check(ctx, req); err != nil {
+		return nil, nil, err
+	}
 	// If we are creating a new instance, we need pick an unused CIDR range from reserved-ipv4-cidr
 	// If the param was not provided, we default reservedIPRange to "" and cloud provider takes care of the allocation
 	if instance.Network.ConnectMode == privateServiceAccess {
@@ -141,7 +261,7 @@ func (m *MultishareOpsManager) setupEligibleInstanceAndStartWorkflow(ctx context
 			instance.Network.ReservedIpRange = reservedIPRange
 		}
 	} else if reservedIPV4CIDR, ok := param[ParamReservedIPV4CIDR]; ok {
-		reservedIPRange, err := m.controllerServer.reserveIPRange(ctx, &file.ServiceInstance{
+		reservedIPRange, err := m.controllerServer.reserveIPRange(ctx, m.controllerServer.config.fileService, &file.ServiceInstance{
 			Project:  instance.Project,
 			Name:     instance.Name,
 			Location: instance.Location,
@@ -162,6 +282,9 @@ func (m *MultishareOpsManager) setupEligibleInstanceAndStartWorkflow(ctx context
 	}
 
 	w, err := m.startInstanceWorkflow(ctx, &Workflow{instance: instance, opType: util.InstanceCreate}, ops)
+	if err == nil {
+		m.costEstimator().instanceCreated(instance, param)
+	}
 	return w, nil, err
 }
 
@@ -210,6 +333,36 @@ func (m *MultishareOpsManager) startShareCreateWorkflowSafe(ctx context.Context,
 	return m.startShareWorkflow(ctx, &Workflow{share: share, opType: util.ShareCreate}, ops)
 }
 
+// checkAndStartShareCreateOnInstanceWorkflow starts the creation of a share on the instance
+// set as its parent, used when the share is moved off another instance. If the share does not
+// fit in the remaining capacity of the instance, an instance expand workflow is started instead.
+func (m *MultishareOpsManager) checkAndStartShareCreateOnInstanceWorkflow(ctx context.Context, share *file.Share) (*Workflow, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	ops, err := m.listMultishareResourceRunningOps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if createShareOp := containsOpWithShareName(share.Name, util.ShareCreate, ops); createShareOp != nil {
+		return &Workflow{share: share, opName: createShareOp.Id, opType: createShareOp.Type}, nil
+	}
+
+	needExpand, targetBytes, err := m.instanceNeedsExpand(ctx, share, share.CapacityBytes, ops)
+	if err != nil {
+		return nil, err
+	}
+	if op := runningInstanceUpdate(share.Parent, ops); op != nil && !needExpand {
+		// The share fits in the instance once expanded by the running op, wait on it.
+		return &Workflow{instance: share.Parent, opName: op.Id, opType: util.InstanceUpdate}, nil
+	}
+	if needExpand {
+		instance := *share.Parent
+		return m.startInstanceExpansion(ctx, &instance, targetBytes, ops)
+	}
+	return m.startShareWorkflow(ctx, &Workflow{share: share, opType: util.ShareCreate}, ops)
+}
+
 func (m *MultishareOpsManager) startInstanceWorkflow(ctx context.Context, w *Workflow, ops []*OpInfo) (*Workflow, error) {
 	// This function has 2 steps:
 	// 1. verify no instance ops or share (belonging to the instance) ops running for the given instance.
@@ -223,18 +376,38 @@ func (m *MultishareOpsManager) startInstanceWorkflow(ctx context.Context, w *Wor
 		return nil, err
 	}
 	switch w.opType {
+	case util.InstanceCreate, util.InstanceUpdate:
+		if err := m.checkRegionalCapacity(ctx, w.instance, w.instance.CapacityBytes, ops); err != nil {
+			return nil, err
+		}
+	}
+	switch w.opType {
 	case util.InstanceCreate:
-		op, err := m.cloud.File.StartCreateMultishareInstanceOp(ctx, w.instance)
+		var backoff *networkBackoff
+		if m.msControllerServer != nil {
+			backoff = m.msControllerServer.networkBackoff
+		}
+		if err := backoff.check(w.instance.Project, w.instance.Network.Name); err != nil {
+			return nil, err
+		}
+		op, err := m.startCreateInstanceOp(ctx, w.instance)
 		if err != nil {
+			if exhaustedErr := backoff.failed(w.instance.Project, w.instance.Network.Name, err); exhaustedErr != nil {
+				return nil, exhaustedErr
+			}
 			return nil, err
 		}
 		w.opName = op.Name
 	case util.InstanceUpdate:
+		if w.instance.Labels != nil && m.msControllerServer != nil {
+			w.instance.Labels[TagKeyDriverVersion] = util.SanitizeLabelValue(m.msControllerServer.driver.config.Version)
+		}
 		op, err := m.cloud.File.StartResizeMultishareInstanceOp(ctx, w.instance)
 		if err != nil {
 			return nil, err
 		}
 		w.opName = op.Name
+		m.opTargets[op.Name] = w.instance.CapacityBytes
 	case util.InstanceDelete:
 		op, err := m.cloud.File.StartDeleteMultishareInstanceOp(ctx, w.instance)
 		if err != nil {
@@ -248,6 +421,26 @@ func (m *MultishareOpsManager) startInstanceWorkflow(ctx context.Context, w *Wor
 	return w, nil
 }
 
+// startInstanceExpansion starts the expansion of an instance to targetBytes, and records the
+// estimated cost of the added capacity.
+func (m *MultishareOpsManager) startInstanceExpansion(ctx context.Context, instance *file.MultishareInstance, targetBytes int64, ops []*OpInfo) (*Workflow, error) {
+	fromBytes := instance.CapacityBytes
+	instance.CapacityBytes = targetBytes
+	w, err := m.startInstanceWorkflow(ctx, &Workflow{instance: instance, opType: util.InstanceUpdate}, ops)
+	if err == nil {
+		m.costEstimator().instanceExpanded(instance, fromBytes)
+	}
+	return w, err
+}
+
+// costEstimator returns the cost estimator of the controller, nil if disabled.
+func (m *MultishareOpsManager) costEstimator() *costEstimator {
+	if m.controllerServer == nil {
+		return nil
+	}
+	return m.controllerServer.config.costEstimator
+}
+
 func (m *MultishareOpsManager) verifyNoRunningInstanceOps(instance *file.MultishareInstance, ops []*OpInfo) error {
 	instanceUri, err := file.GenerateMultishareInstanceURI(instance)
 	if err != nil {
@@ -302,11 +495,15 @@ func (m *MultishareOpsManager) startShareWorkflow(ctx context.Context, w *Workfl
 	}
 	switch w.opType {
 	case util.ShareCreate:
+		if err := m.verifyShareNameUnique(ctx, w.share); err != nil {
+			return nil, err
+		}
 		op, err := m.cloud.File.StartCreateShareOp(ctx, w.share)
 		if err != nil {
 			return nil, err
 		}
 		w.opName = op.Name
+		m.opTargets[op.Name] = w.share.CapacityBytes
 	case util.ShareUpdate:
 		op, err := m.cloud.File.StartResizeShareOp(ctx, w.share)
 		if err != nil {
@@ -325,6 +522,21 @@ func (m *MultishareOpsManager) startShareWorkflow(ctx context.Context, w *Workfl
 	return w, nil
 }
 
+// verifyShareNameUnique returns AlreadyExists if a share of the same name already exists on the
+// instance of the share to create, whatever the storage class owning it. The shares of the
+// instances shared by several storage classes, e.g. adopted from another cluster, would
+// otherwise collide when their volume names convert to the same share name.
+func (m *MultishareOpsManager) verifyShareNameUnique(ctx context.Context, share *file.Share) error {
+	existing, err := m.cloud.File.GetShare(ctx, share)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil
+		}
+		return err
+	}
+	return status.Errorf(codes.AlreadyExists, "share %s already exists on instance %s, share names must be unique across the instance regardless of the storage class, see parameter %s", existing.Name, share.Parent.Name, paramShareNameWithScPrefix)
+}
+
 func (m *MultishareOpsManager) verifyNoRunningInstanceOrShareOpsForInstance(instance *file.MultishareInstance, ops []*OpInfo) error {
 	instanceUri, err := file.GenerateMultishareInstanceURI(instance)
 	if err != nil {
@@ -340,14 +552,15 @@ func (m *MultishareOpsManager) verifyNoRunningInstanceOrShareOpsForInstance(inst
 	return nil
 }
 
-// runEligibleInstanceCheck returns a list of ready and non-ready instances.
-func (m *MultishareOpsManager) runEligibleInstanceCheck(ctx context.Context, req *csi.CreateVolumeRequest, ops []*OpInfo, target *file.MultishareInstance, regions []string) ([]*file.MultishareInstance, error) {
-	klog.Infof("ListMultishareInstances call initiated for request %+v.", req)
-	instances, err := m.listMatchedInstances(ctx, req, target, regions)
+// runEligibleInstanceCheck returns a list of ready and non-ready instances. reservedIPRangeCIDR
+// is the CIDR of the reserved IP range of the request, see resolveReservedIPRange.
+func (m *MultishareOpsManager) runEligibleInstanceCheck(ctx context.Context, req *csi.CreateVolumeRequest, ops []*OpInfo, target *file.MultishareInstance, regions []string, reservedIPRangeCIDR string) ([]*file.MultishareInstance, error) {
+	klog.Infof("ListMultishareInstances call initiated for request %+v.", pbSanitizer.StripSecrets(req))
+	instances, err := m.listMatchedInstances(ctx, req, target, regions, reservedIPRangeCIDR)
 	if err != nil {
 		return nil, err
 	}
-	klog.Infof("ListMultishareInstances call returned successfully with %d instances for request %+v.", len(instances), req)
+	klog.Infof("ListMultishareInstances call returned successfully with %d instances for request %+v.", len(instances), pbSanitizer.StripSecrets(req))
 	// An instance is considered as eligible if and only if the state is 'READY', and there's no ops running against it.
 	var readyEligibleInstances []*file.MultishareInstance
 	// An instance is considered as non-ready if any of the following conditions are met:
@@ -355,17 +568,27 @@ func (m *MultishareOpsManager) runEligibleInstanceCheck(ctx context.Context, req
 	// 2. The instance state is 'READY', but running ops are found on it.
 	var nonReadyEligibleInstances []*file.MultishareInstance
 
+	// Instances in 'READY' state without running ops, whose shares need to be counted.
+	var candidates []*file.MultishareInstance
 	for _, instance := range instances {
 		klog.Infof("Found multishare instance %s/%s/%s with state %s and max share count %d", instance.Project, instance.Location, instance.Name, instance.State, instance.MaxShareCount)
+		if isExcludedFromPacking(instance) {
+			klog.Infof("Instance %s/%s/%s is excluded from packing by its %q label", instance.Project, instance.Location, instance.Name, TagKeyExcludeFromPacking)
+			continue
+		}
 		if instance.State == "CREATING" || instance.State == "REPAIRING" {
 			klog.Infof("Instance %s/%s/%s with state %s is not ready", instance.Project, instance.Location, instance.Name, instance.State)
 			nonReadyEligibleInstances = append(nonReadyEligibleInstances, instance)
 			continue
 		}
+		if instanceExcludedStates[instance.State] {
+			m.reportExcludedInstance(req, instance)
+			continue
+		}
+		m.clearExcludedInstance(instance)
 		if instance.State != "READY" {
 			klog.Infof("Instance %s/%s/%s with state %s is not eligible", instance.Project, instance.Location, instance.Name, instance.State)
 			continue
-			// TODO: If we saw instance states other than "CREATING" and "READY", we may need to do some special handlding in the future.
 		}
 
 		op, err := containsOpWithInstanceTargetPrefix(instance, ops)
@@ -375,24 +598,7 @@ func (m *MultishareOpsManager) runEligibleInstanceCheck(ctx context.Context, req
 		}
 
 		if op == nil {
-			shares, err := m.cloud.File.ListShares(ctx, &file.ListFilter{Project: instance.Project, Location: instance.Location, InstanceName: instance.Name})
-			if err != nil {
-				klog.Errorf("Failed to list shares of instance %s/%s/%s, err:%v", instance.Project, instance.Location, instance.Name, err.Error())
-				return nil, err
-			}
-
-			// If we encounter a scenario where the configurable shares per Filestore instance feature is disabled, CSI driver will continue to place max 10 shares per instance, irrespective of the actual max shares the Filestore instance can support.
-			// Alternately, if CSI max share features is enabled, but filestore disables the feature, the create volume may continue to fail beyond 10 shares per instance.
-			maxShareCount := util.MaxSharesPerInstance
-			if m.msControllerServer != nil && m.msControllerServer.featureMaxSharePerInstance {
-				maxShareCount = instance.MaxShareCount
-			}
-			if len(shares) >= maxShareCount {
-				continue
-			}
-
-			readyEligibleInstances = append(readyEligibleInstances, instance)
-			klog.Infof("Adding instance %s to eligible list", instance.String())
+			candidates = append(candidates, instance)
 			continue
 		}
 
@@ -402,6 +608,31 @@ func (m *MultishareOpsManager) runEligibleInstanceCheck(ctx context.Context, req
 		// TODO: If we see > 1 instances with 0 shares (these could be possibly leaked instances where the driver hit timeout during creation op was in progress), should we trigger delete op for such instances? Possibly yes. Given that instance create/delete and share create/delete is serialized, maybe yes.
 	}
 
+	shareCounts, shareBytes, err := m.countShares(ctx, candidates, ops)
+	if err != nil {
+		return nil, err
+	}
+	// The share is placed on an instance only if the instance can be expanded to fit it.
+	reqBytes, err := getShareRequestCapacity(req.GetCapacityRange(), util.ConfigurablePackMinShareSizeBytes, util.MaxShareSizeBytes)
+	if err != nil {
+		reqBytes = 0
+	}
+	for i, instance := range candidates {
+		// The max share count reported by the instance is used, so that the instance generations
+		// supporting more shares are packed without a driver update, whether or not the
+		// configurable shares per Filestore instance feature is enabled.
+		if shareCounts[i] >= instanceMaxShareCount(instance) {
+			continue
+		}
+		if _, maxInstanceSizeBytes := instanceSizeBounds(instance); shareBytes[i]+reqBytes > maxInstanceSizeBytes {
+			klog.Infof("Instance %s can't fit %d more bytes within its max size %d bytes", instance.String(), reqBytes, maxInstanceSizeBytes)
+			continue
+		}
+
+		readyEligibleInstances = append(readyEligibleInstances, instance)
+		klog.Infof("Adding instance %s to eligible list", instance.String())
+	}
+
 	if len(readyEligibleInstances) == 0 && len(nonReadyEligibleInstances) > 0 {
 		errorString := "All eligible filestore instances are busy.\n"
 
@@ -425,7 +656,252 @@ func (m *MultishareOpsManager) runEligibleInstanceCheck(ctx context.Context, req
 	return readyEligibleInstances, nil
 }
 
-func (m *MultishareOpsManager) instanceNeedsExpand(ctx context.Context, share *file.Share, capacityNeeded int64) (bool, int64, error) {
+// reportExcludedInstance reports an instance excluded from packing because of its state,
+// with a metric, and with an event on the PVC of the request when instance events are
+// enabled. Filestore has no API to resume an instance, a SUSPENDED instance is resumed
+// by Filestore once the cause, e.g. a revoked CMEK key, is cleared.
+func (m *MultishareOpsManager) reportExcludedInstance(req *csi.CreateVolumeRequest, instance *file.MultishareInstance) {
+	reason := instance.StatusMessage
+	if len(instance.SuspensionReasons) > 0 {
+		reason = strings.Join(instance.SuspensionReasons, ",")
+	}
+	klog.Warningf("Instance %s/%s/%s with state %s (reason %q) is excluded from packing until it recovers", instance.Project, instance.Location, instance.Name, instance.State, reason)
+
+	uri, err := file.GenerateMultishareInstanceURI(instance)
+	if err != nil {
+		klog.Errorf("failed to parse instance handle: %v", err)
+		return
+	}
+	if m.controllerServer == nil {
+		return
+	}
+	if state, ok := m.excludedInstances[uri]; ok && state != instance.State {
+		m.controllerServer.config.metricsManager.DeleteExcludedInstanceMetric(uri, state)
+	}
+	m.excludedInstances[uri] = instance.State
+	m.controllerServer.config.metricsManager.RecordExcludedInstanceMetric(uri, instance.State)
+
+	reporter := m.controllerServer.config.instanceEvents
+	pvcName, pvcNamespace := req.GetParameters()[ParameterKeyPVCName], req.GetParameters()[ParameterKeyPVCNamespace]
+	if reporter == nil || pvcName == "" || pvcNamespace == "" {
+		return
+	}
+	message := fmt.Sprintf("Filestore instance %s is in state %s, it is excluded from the placement of new shares until it recovers", uri, instance.State)
+	if reason != "" {
+		message = fmt.Sprintf("%s (reason %s)", message, reason)
+	}
+	reporter.recorder.Event(&v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: pvcNamespace, Name: pvcName}, v1.EventTypeWarning, eventReasonInstanceExcluded, message)
+}
+
+// clearExcludedInstance drops the metric of an instance previously excluded from packing.
+func (m *MultishareOpsManager) clearExcludedInstance(instance *file.MultishareInstance) {
+	uri, err := file.GenerateMultishareInstanceURI(instance)
+	if err != nil {
+		return
+	}
+	state, ok := m.excludedInstances[uri]
+	if !ok {
+		return
+	}
+	klog.Infof("Instance %s/%s/%s recovered from state %s to %s", instance.Project, instance.Location, instance.Name, state, instance.State)
+	delete(m.excludedInstances, uri)
+	if m.controllerServer != nil {
+		m.controllerServer.config.metricsManager.DeleteExcludedInstanceMetric(uri, state)
+	}
+}
+
+// spreadByNamespace returns the eligible instances holding the fewest shares of the given
+// PVC namespace, so that the shares of a namespace are spread over the instances and an
+// instance outage affects as few of its volumes as possible.
+func (m *MultishareOpsManager) spreadByNamespace(ctx context.Context, eligible []*file.MultishareInstance, namespace string) ([]*file.MultishareInstance, error) {
+	if namespace == "" || len(eligible) < 2 {
+		return eligible, nil
+	}
+	counts := make([]int, len(eligible))
+	g, gctx := errgroup.WithContext(ctx)
+	parallelism := m.shareListParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	g.SetLimit(parallelism)
+	for i, instance := range eligible {
+		i, instance := i, instance
+		g.Go(func() error {
+			shares, err := m.cloud.File.ListShares(gctx, &file.ListFilter{Project: instance.Project, Location: instance.Location, InstanceName: instance.Name})
+			if err != nil {
+				klog.Errorf("Failed to list shares of instance %s/%s/%s, err:%v", instance.Project, instance.Location, instance.Name, err.Error())
+				return err
+			}
+			for _, s := range shares {
+				if s.Labels[tagKeyCreatedForClaimNamespace] == namespace {
+					counts[i]++
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	minCount := counts[0]
+	for _, c := range counts[1:] {
+		if c < minCount {
+			minCount = c
+		}
+	}
+	var spread []*file.MultishareInstance
+	for i, instance := range eligible {
+		if counts[i] == minCount {
+			spread = append(spread, instance)
+		}
+	}
+	klog.V(4).Infof("%d of %d eligible instances hold the fewest (%d) shares of namespace %s", len(spread), len(eligible), minCount, namespace)
+	return spread, nil
+}
+
+// packingPolicy returns the packing policy of the instance pool of the request.
+func (m *MultishareOpsManager) packingPolicy(req *csi.CreateVolumeRequest) string {
+	if m.msControllerServer == nil {
+		return packingPolicyRandom
+	}
+	return m.msControllerServer.instancePools.packingPolicy(req.GetParameters()[ParamMultishareInstanceScLabel])
+}
+
+// pickEligibleInstance returns the index of the eligible instance of a new share, according to
+// the packing policy: a random one, the one holding the most shares, or the fewest shares. Ties
+// are broken randomly.
+func (m *MultishareOpsManager) pickEligibleInstance(ctx context.Context, eligible []*file.MultishareInstance, packingPolicy string, ops []*OpInfo) (int, error) {
+	if packingPolicy == packingPolicyRandom || len(eligible) < 2 {
+		return rand.Intn(len(eligible)), nil
+	}
+	counts, _, err := m.countShares(ctx, eligible, ops)
+	if err != nil {
+		return 0, err
+	}
+	var picked []int
+	for i, c := range counts {
+		if len(picked) > 0 {
+			best := counts[picked[0]]
+			if c == best {
+				picked = append(picked, i)
+				continue
+			}
+			if (packingPolicy == packingPolicyPack && c < best) || (packingPolicy == packingPolicySpread && c > best) {
+				continue
+			}
+		}
+		picked = []int{i}
+	}
+	klog.V(4).Infof("%d of %d eligible instances hold %d shares, picked with packing policy %s", len(picked), len(eligible), counts[picked[0]], packingPolicy)
+	return picked[rand.Intn(len(picked))], nil
+}
+
+// countShares lists the shares of the given instances with bounded concurrency and returns
+// the share count and the total share capacity of each instance, in the order of the given
+// instances, including the shares of the running ops, see shareUsage.
+func (m *MultishareOpsManager) countShares(ctx context.Context, instances []*file.MultishareInstance, ops []*OpInfo) ([]int, []int64, error) {
+	counts := make([]int, len(instances))
+	capacities := make([]int64, len(instances))
+	g, gctx := errgroup.WithContext(ctx)
+	parallelism := m.shareListParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	g.SetLimit(parallelism)
+	for i, instance := range instances {
+		i, instance := i, instance
+		g.Go(func() error {
+			shares, err := m.cloud.File.ListShares(gctx, &file.ListFilter{Project: instance.Project, Location: instance.Location, InstanceName: instance.Name})
+			if err != nil {
+				klog.Errorf("Failed to list shares of instance %s/%s/%s, err:%v", instance.Project, instance.Location, instance.Name, err.Error())
+				return err
+			}
+			counts[i], capacities[i] = shareUsage(instance, shares, ops)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	return counts, capacities, nil
+}
+
+// countSharesSafe is countShares, with the running ops listed under the ops manager lock.
+func (m *MultishareOpsManager) countSharesSafe(ctx context.Context, instances []*file.MultishareInstance) ([]int, []int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	ops, err := m.listMultishareResourceRunningOps(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.countShares(ctx, instances, ops)
+}
+
+// shareUsage returns the number of shares and the share capacity held on an instance by its
+// shares. A share holds its slot and capacity from the start of its create op, while CREATING,
+// until its delete op is done, while DELETING: during a burst of CreateVolume calls the shares
+// still being created must be counted, or more shares are placed on the instance than fit. The
+// shares of the running create ops of the instance which are not listed yet are counted too,
+// with the capacity recorded when the driver started the op, or the minimum share capacity if
+// unknown.
+func shareUsage(instance *file.MultishareInstance, shares []*file.Share, ops []*OpInfo) (int, int64) {
+	var count, creating, deleting int
+	var capacityBytes int64
+	listed := make(map[string]bool, len(shares))
+	for _, s := range shares {
+		switch s.State {
+		case file.ShareStateCreating:
+			creating++
+		case file.ShareStateDeleting:
+			deleting++
+		}
+		listed[s.Name] = true
+		count++
+		capacityBytes += s.CapacityBytes
+	}
+	for name, op := range runningShareCreates(instance, ops) {
+		if listed[name] {
+			continue
+		}
+		creating++
+		count++
+		if op.TargetBytes > 0 {
+			capacityBytes += op.TargetBytes
+		} else {
+			capacityBytes += util.ConfigurablePackMinShareSizeBytes
+		}
+	}
+	if creating > 0 || deleting > 0 {
+		klog.V(4).Infof("Instance %s/%s/%s holds %d shares of %d bytes, of which %d creating and %d deleting", instance.Project, instance.Location, instance.Name, count, capacityBytes, creating, deleting)
+	}
+	return count, capacityBytes
+}
+
+// runningShareCreates returns the running share create ops of the instance by share name.
+func runningShareCreates(instance *file.MultishareInstance, ops []*OpInfo) map[string]*OpInfo {
+	instanceUri, err := file.GenerateMultishareInstanceURI(instance)
+	if err != nil {
+		return nil
+	}
+	creates := map[string]*OpInfo{}
+	for _, op := range ops {
+		if op.Type != util.ShareCreate {
+			continue
+		}
+		prefix := instanceUri + "/shares/"
+		if strings.HasPrefix(op.Target, prefix) && len(op.Target) > len(prefix) {
+			creates[strings.TrimPrefix(op.Target, prefix)] = op
+		}
+	}
+	return creates
+}
+
+// instanceNeedsExpand returns whether the parent instance of the share must be expanded to fit
+// capacityNeeded more bytes, and the capacity to expand it to. The capacity of an instance being
+// expanded by a running op is the target capacity of the op, so that a share fitting in the
+// expanded instance doesn't trigger a second expansion.
+func (m *MultishareOpsManager) instanceNeedsExpand(ctx context.Context, share *file.Share, capacityNeeded int64, ops []*OpInfo) (bool, int64, error) {
 	if share == nil {
 		return false, 0, fmt.Errorf("empty share")
 	}
@@ -438,15 +914,16 @@ func (m *MultishareOpsManager) instanceNeedsExpand(ctx context.Context, share *f
 		return false, 0, err
 	}
 
-	var sumShareBytes int64
-	for _, s := range shares {
-		sumShareBytes = sumShareBytes + s.CapacityBytes
+	_, sumShareBytes := shareUsage(share.Parent, shares, ops)
+	capacityBytes := share.Parent.CapacityBytes
+	if op := runningInstanceUpdate(share.Parent, ops); op != nil && op.TargetBytes > capacityBytes {
+		klog.V(4).Infof("Instance %s is being expanded from %d to %d bytes by op %s", share.Parent.Name, capacityBytes, op.TargetBytes, op.Id)
+		capacityBytes = op.TargetBytes
 	}
-
-	remainingBytes := share.Parent.CapacityBytes - sumShareBytes
+	remainingBytes := capacityBytes - sumShareBytes
 	if remainingBytes < capacityNeeded {
-		alignBytes := util.AlignBytes(capacityNeeded+sumShareBytes, util.GbToBytes(share.Parent.CapacityStepSizeGb))
-		targetBytes := util.Min(alignBytes, util.MaxMultishareInstanceSizeBytes)
+		minInstanceSizeBytes, maxInstanceSizeBytes := instanceSizeBounds(share.Parent)
+		targetBytes := util.AlignInstanceCapacityBytes(capacityNeeded+sumShareBytes, share.Parent.CapacityStepSizeGb, minInstanceSizeBytes, maxInstanceSizeBytes)
 		return true, targetBytes, nil
 	}
 	return false, 0, nil
@@ -469,6 +946,17 @@ func (m *MultishareOpsManager) checkAndStartInstanceOrShareExpandWorkflow(ctx co
 		return &Workflow{share: share, opName: expandShareOp.Id, opType: expandShareOp.Type}, nil
 	}
 
+	// An instance expand in flight may already make room for the share expansion, wait on it.
+	if op := runningInstanceUpdate(share.Parent, ops); op != nil {
+		needExpand, _, err := m.instanceNeedsExpand(ctx, share, reqBytes-share.CapacityBytes, ops)
+		if err != nil {
+			return nil, err
+		}
+		if !needExpand {
+			return &Workflow{instance: share.Parent, opName: op.Id, opType: util.InstanceUpdate}, nil
+		}
+	}
+
 	// no existing share Expansion, proceed to instance check
 	err = m.verifyNoRunningInstanceOrShareOpsForInstance(share.Parent, ops)
 	if err != nil {
@@ -481,14 +969,12 @@ func (m *MultishareOpsManager) checkAndStartInstanceOrShareExpandWorkflow(ctx co
 		return nil, err
 	}
 
-	needExpand, targetBytes, err := m.instanceNeedsExpand(ctx, share, reqBytes-share.CapacityBytes)
+	needExpand, targetBytes, err := m.instanceNeedsExpand(ctx, share, reqBytes-share.CapacityBytes, ops)
 	if err != nil {
 		return nil, err
 	}
 	if needExpand {
-		instance.CapacityBytes = targetBytes
-		workflow, err := m.startInstanceWorkflow(ctx, &Workflow{instance: instance, opType: util.InstanceUpdate}, ops)
-		return workflow, err
+		return m.startInstanceExpansion(ctx, instance, targetBytes, ops)
 	}
 
 	share.CapacityBytes = reqBytes
@@ -575,13 +1061,10 @@ func (m *MultishareOpsManager) checkAndStartInstanceDeleteOrShrinkWorkflow(ctx c
 	}
 
 	// check for shrink
-	var totalShareCap int64
-	for _, share := range shares {
-		totalShareCap += share.CapacityBytes
-	}
-	if totalShareCap < instance.CapacityBytes && instance.CapacityBytes > util.MinMultishareInstanceSizeBytes {
-		targetShrinkSizeBytes := util.AlignBytes(totalShareCap, util.GbToBytes(instance.CapacityStepSizeGb))
-		targetShrinkSizeBytes = util.Max(targetShrinkSizeBytes, util.MinMultishareInstanceSizeBytes)
+	_, totalShareCap := shareUsage(instance, shares, ops)
+	minInstanceSizeBytes, maxInstanceSizeBytes := instanceSizeBounds(instance)
+	if totalShareCap < instance.CapacityBytes && instance.CapacityBytes > minInstanceSizeBytes {
+		targetShrinkSizeBytes := util.AlignInstanceCapacityBytes(totalShareCap, instance.CapacityStepSizeGb, minInstanceSizeBytes, maxInstanceSizeBytes)
 		if instance.CapacityBytes == targetShrinkSizeBytes {
 			return nil, nil
 		}
@@ -600,9 +1083,59 @@ func (m *MultishareOpsManager) checkAndStartInstanceDeleteOrShrinkWorkflow(ctx c
 	return nil, nil
 }
 
+// checkAndStartInstanceLabelsUpdateWorkflow re-applies the given labels to a ready multishare
+// instance on which any of them is missing or has another value, keeping its other labels. It
+// returns a nil workflow if the instance is gone, not ready, or already carries the labels.
+func (m *MultishareOpsManager) checkAndStartInstanceLabelsUpdateWorkflow(ctx context.Context, instance *file.MultishareInstance, labels map[string]string) (*Workflow, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	ops, err := m.listMultishareResourceRunningOps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.verifyNoRunningInstanceOrShareOpsForInstance(instance, ops)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err = m.cloud.File.GetMultishareInstance(ctx, instance)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if instance.State != instanceStateReady {
+		return nil, nil
+	}
+
+	updated := make(map[string]string, len(instance.Labels)+len(labels))
+	for k, v := range instance.Labels {
+		updated[k] = v
+	}
+	var fixed []string
+	for k, v := range labels {
+		if current, ok := updated[k]; !ok || current != v {
+			fixed = append(fixed, fmt.Sprintf("%s=%s (was %q)", k, v, current))
+			updated[k] = v
+		}
+	}
+	if len(fixed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(fixed)
+	klog.Infof("Re-applying labels %v to instance %s/%s/%s", fixed, instance.Project, instance.Location, instance.Name)
+	instance.Labels = updated
+	return m.startInstanceWorkflow(ctx, &Workflow{instance: instance, opType: util.InstanceUpdate}, ops)
+}
+
 // listMultishareOps reports all running ops related to multishare instances and share resources. The op target is of the form "projects/<>/locations/<>/instances/<>" or "projects/<>/locations/<>/instances/<>/shares/<>"
 func (m *MultishareOpsManager) listMultishareResourceRunningOps(ctx context.Context) ([]*OpInfo, error) {
-	ops, err := m.cloud.File.ListOps(ctx, &file.ListFilter{Project: m.cloud.Project, Location: "-"})
+	// Only running ops are of interest, let the server drop the done ones so that projects with a long operation
+	// history do not pay for listing it. The op target lives in the op metadata and is matched client side below.
+	ops, err := m.cloud.File.ListOps(ctx, &file.ListFilter{Project: m.cloud.Project, Location: "-", OpFilter: runningOpsFilter})
 	if err != nil {
 		return nil, err
 	}
@@ -623,16 +1156,56 @@ func (m *MultishareOpsManager) listMultishareResourceRunningOps(ctx context.Cont
 			continue
 		}
 
+		var createTime time.Time
+		if meta.CreateTime != "" {
+			if createTime, err = time.Parse(time.RFC3339, meta.CreateTime); err != nil {
+				klog.Warningf("Failed to parse create time %q of op %s: %v", meta.CreateTime, op.Name, err)
+			}
+		}
 		if file.IsInstanceTarget(meta.Target) {
-			finalops = append(finalops, &OpInfo{Id: op.Name, Target: meta.Target, Type: util.ConvertInstanceOpVerbToType(meta.Verb)})
+			finalops = append(finalops, &OpInfo{Id: op.Name, Target: meta.Target, Type: util.ConvertInstanceOpVerbToType(meta.Verb), CreateTime: createTime, TargetBytes: m.opTargets[op.Name]})
 		} else if file.IsShareTarget(meta.Target) {
-			finalops = append(finalops, &OpInfo{Id: op.Name, Target: meta.Target, Type: util.ConvertShareOpVerbToType(meta.Verb)})
+			finalops = append(finalops, &OpInfo{Id: op.Name, Target: meta.Target, Type: util.ConvertShareOpVerbToType(meta.Verb), CreateTime: createTime, TargetBytes: m.opTargets[op.Name]})
 		}
 		// TODO: Add other resource types if needed, when we support snapshot/backups.
 	}
+	m.reportRunningOps(ctx, finalops, time.Now())
+	m.forgetOpTargets(finalops)
 	return finalops, nil
 }
 
+// forgetOpTargets forgets the target capacity of the ops which are done. The caller must hold
+// the ops manager lock.
+func (m *MultishareOpsManager) forgetOpTargets(ops []*OpInfo) {
+	if len(m.opTargets) == 0 {
+		return
+	}
+	running := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		running[op.Id] = true
+	}
+	for name := range m.opTargets {
+		if !running[name] {
+			delete(m.opTargets, name)
+		}
+	}
+}
+
+// runningInstanceUpdate returns the running update op of the instance whose target capacity
+// is known, if any.
+func runningInstanceUpdate(instance *file.MultishareInstance, ops []*OpInfo) *OpInfo {
+	instanceUri, err := file.GenerateMultishareInstanceURI(instance)
+	if err != nil {
+		return nil
+	}
+	for _, op := range ops {
+		if op.Type == util.InstanceUpdate && op.Target == instanceUri && op.TargetBytes > 0 {
+			return op
+		}
+	}
+	return nil
+}
+
 // Whether there is any op with target that is the given share name
 func containsOpWithShareName(shareName string, opType util.OperationType, ops []*OpInfo) *OpInfo {
 	for _, op := range ops {
@@ -678,7 +1251,7 @@ func containsOpWithInstanceTargetPrefix(instance *file.MultishareInstance, ops [
 
 // listMatchedInstances lists all instances under allowed regions in current project,
 // but only matched instances will be returned.
-func (m *MultishareOpsManager) listMatchedInstances(ctx context.Context, req *csi.CreateVolumeRequest, target *file.MultishareInstance, regions []string) ([]*file.MultishareInstance, error) {
+func (m *MultishareOpsManager) listMatchedInstances(ctx context.Context, req *csi.CreateVolumeRequest, target *file.MultishareInstance, regions []string, reservedIPRangeCIDR string) ([]*file.MultishareInstance, error) {
 	var instances []*file.MultishareInstance
 	for _, region := range regions {
 		regionalInstances, err := m.cloud.File.ListMultishareInstances(ctx, &file.ListFilter{Project: m.cloud.Project, Location: region})
@@ -690,7 +1263,7 @@ func (m *MultishareOpsManager) listMatchedInstances(ctx context.Context, req *cs
 
 	var finalInstances []*file.MultishareInstance
 	for _, i := range instances {
-		matched, err := isMatchedInstance(i, target, req)
+		matched, err := isMatchedInstance(i, target, req, reservedIPRangeCIDR)
 		if err != nil {
 			return nil, err
 		}
@@ -702,6 +1275,50 @@ func (m *MultishareOpsManager) listMatchedInstances(ctx context.Context, req *cs
 	return finalInstances, nil
 }
 
+// resolveReservedIPRange returns the CIDR of the "reserved-ip-range" parameter, looking up
+// named address ranges with the Compute API. An empty string is returned if the parameter is
+// not set or the range cannot be looked up, in which case instances are not filtered by it.
+// The lookups are cached for reservedIPRangeCacheTTL and are made without the ops manager lock.
+func (m *MultishareOpsManager) resolveReservedIPRange(ctx context.Context, params map[string]string) string {
+	reservedIPRange, ok := params[ParamReservedIPRange]
+	if !ok || reservedIPRange == "" {
+		return ""
+	}
+	if IsCIDR(reservedIPRange) {
+		return reservedIPRange
+	}
+	if m.cloud.Compute == nil {
+		return ""
+	}
+	key := m.cloud.Project + "/" + reservedIPRange
+	m.reservedIPRangesLock.Lock()
+	entry, ok := m.reservedIPRanges[key]
+	m.reservedIPRangesLock.Unlock()
+	if ok && m.now().Before(entry.expiry) {
+		return entry.cidr
+	}
+
+	cidr := ""
+	r, err := m.cloud.Compute.GetAddressRange(ctx, m.cloud.Project, reservedIPRange)
+	if err != nil {
+		// The lookup requires Compute API permissions the driver may not have.
+		klog.Warningf("Failed to look up reserved IP range %q, not filtering instances by it: %v", reservedIPRange, err)
+	} else {
+		cidr = r.CIDR
+	}
+	m.reservedIPRangesLock.Lock()
+	m.reservedIPRanges[key] = &reservedIPRangeEntry{cidr: cidr, expiry: m.now().Add(reservedIPRangeCacheTTL)}
+	m.reservedIPRangesLock.Unlock()
+	return cidr
+}
+
+// isExcludedFromPacking returns true if the operator labeled the instance to place no new share
+// on it.
+func isExcludedFromPacking(instance *file.MultishareInstance) bool {
+	excluded, _ := strconv.ParseBool(instance.Labels[TagKeyExcludeFromPacking])
+	return excluded
+}
+
 // A source instance will be considered as "matched" with the target instance
 // if and only if the following requirements were met:
 //  1. Both source and target instance should have a label with key
@@ -709,7 +1326,8 @@ func (m *MultishareOpsManager) listMatchedInstances(ctx context.Context, req *cs
 //  2. (Check if exists) The ip address of the target instance should be
 //     within the ip range specified in "reserved-ipv4-cidr".
 //  3. (Check if exists) The ip address of the target instance should be
-//     within the ip range specified in "reserved-ip-range".
+//     within the ip range specified in "reserved-ip-range", whose CIDR is
+//     reservedIPRangeCIDR.
 //  4. Both source and target instance should be in the same location.
 //  5. Both source and target instance should be under the same tier.
 //  6. Both source and target instance should be in the same VPC network.
@@ -720,8 +1338,18 @@ func (m *MultishareOpsManager) listMatchedInstances(ctx context.Context, req *cs
 //     "gke_cluster_location", and the value should be the same.
 //  10. Both source and target instance should have a label with key
 //     "gke_cluster_name", and the value should be the same.
-func isMatchedInstance(source, target *file.MultishareInstance, req *csi.CreateVolumeRequest) (bool, error) {
-	matchLabels := [3]string{util.ParamMultishareInstanceScLabelKey, TagKeyClusterLocation, TagKeyClusterName}
+//  11. (Check if exists) Both source and target instance should have the same
+//     "storage_gke_io_cluster_uid" label value, so that the instances of a
+//     deleted cluster are not reused by a new cluster of the same name.
+//
+// If the target instance has a label with key "storage_gke_io_shared_cluster_group",
+// requirements 9 and 10 are replaced by the source instance having the same label
+// value, so that the instances are shared by the clusters of the group.
+func isMatchedInstance(source, target *file.MultishareInstance, req *csi.CreateVolumeRequest, reservedIPRangeCIDR string) (bool, error) {
+	matchLabels := []string{util.ParamMultishareInstanceScLabelKey, TagKeyClusterLocation, TagKeyClusterName}
+	if _, ok := target.Labels[TagKeySharedClusterGroup]; ok {
+		matchLabels = []string{util.ParamMultishareInstanceScLabelKey, TagKeySharedClusterGroup}
+	}
 	for _, labelKey := range matchLabels {
 		if _, ok := target.Labels[labelKey]; !ok {
 			return false, fmt.Errorf("label %q missing in target instance %+v", labelKey, target)
@@ -730,6 +1358,12 @@ func isMatchedInstance(source, target *file.MultishareInstance, req *csi.CreateV
 			return false, nil
 		}
 	}
+	if _, shared := target.Labels[TagKeySharedClusterGroup]; !shared {
+		sourceUID, targetUID := source.Labels[TagKeyClusterUID], target.Labels[TagKeyClusterUID]
+		if sourceUID != "" && targetUID != "" && sourceUID != targetUID {
+			return false, nil
+		}
+	}
 	params := req.GetParameters()
 	if instanceCIDR, ok := params[ParamReservedIPV4CIDR]; ok {
 		withinRange, err := IsIpWithinRange(source.Network.Ip, instanceCIDR)
@@ -740,8 +1374,15 @@ func isMatchedInstance(source, target *file.MultishareInstance, req *csi.CreateV
 			return false, nil
 		}
 	}
-	// Skip validation for parameter "reserved-ip-range" since it requires
-	// extra compute api auth and not clear if it's required.
+	if reservedIPRangeCIDR != "" {
+		withinRange, err := IsIpWithinRange(source.Network.Ip, reservedIPRangeCIDR)
+		if err != nil {
+			return false, err
+		}
+		if !withinRange {
+			return false, nil
+		}
+	}
 	if strings.EqualFold(source.Location, target.Location) &&
 		strings.EqualFold(source.Tier, target.Tier) &&
 		strings.EqualFold(source.Network.Name, target.Network.Name) &&
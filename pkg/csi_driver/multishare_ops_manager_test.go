@@ -19,12 +19,19 @@ package driver
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/net/context"
 	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/record"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/compute"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
 )
@@ -251,14 +258,15 @@ func TestInstanceNeedsExpand(t *testing.T) {
 				Name:          testShareName + "2",
 				CapacityBytes: 1 * util.Tb,
 				Parent: &file.MultishareInstance{
-					Project:       testProject,
-					Location:      testRegion,
-					Name:          testInstanceName,
-					CapacityBytes: 1 * util.Tb,
+					Project:            testProject,
+					Location:           testRegion,
+					Name:               testInstanceName,
+					CapacityBytes:      1 * util.Tb,
+					CapacityStepSizeGb: 256,
 				},
 			},
 			expectedNeedsExpand: true,
-			targetBytes:         1*util.Tb + (1*util.Tb - (1*util.Tb - 100*util.Gb)),
+			targetBytes:         1280 * util.Gb,
 		},
 		{
 			name:  "2 existing 100G share in 1 T instance,  new 900G share",
@@ -359,14 +367,15 @@ func TestInstanceNeedsExpand(t *testing.T) {
 				Name:          testShareName + "10",
 				CapacityBytes: 1 * util.Tb,
 				Parent: &file.MultishareInstance{
-					Project:       testProject,
-					Location:      testRegion,
-					Name:          testInstanceName,
-					CapacityBytes: 1 * util.Tb,
+					Project:            testProject,
+					Location:           testRegion,
+					Name:               testInstanceName,
+					CapacityBytes:      1 * util.Tb,
+					CapacityStepSizeGb: 256,
 				},
 			},
 			expectedNeedsExpand: true,
-			targetBytes:         1*util.Tb + (1*util.Tb - (1*util.Tb - 9*100*util.Gb)),
+			targetBytes:         2 * util.Tb,
 		},
 		{
 			name:  "9 existing 100G share in 1 T instance,  new 1T share",
@@ -397,14 +406,47 @@ func TestInstanceNeedsExpand(t *testing.T) {
 				Name:          testShareName + "3",
 				CapacityBytes: 900 * util.Gb,
 				Parent: &file.MultishareInstance{
-					Project:       testProject,
-					Location:      testRegion,
-					Name:          testInstanceName,
-					CapacityBytes: 1 * util.Tb,
+					Project:            testProject,
+					Location:           testRegion,
+					Name:               testInstanceName,
+					CapacityBytes:      1 * util.Tb,
+					CapacityStepSizeGb: 256,
+				},
+			},
+			expectedNeedsExpand: true,
+			targetBytes:         1280 * util.Gb,
+		},
+		{
+			name:  "1 existing 900G share in 1 T instance with 1.5 T max size, new 900G share",
+			scKey: testInstanceScPrefix,
+			initShares: []file.Share{
+				{
+					Name:          testShareName + "1",
+					CapacityBytes: 900 * util.Gb,
+					Parent: &file.MultishareInstance{
+						Project:       testProject,
+						Location:      testRegion,
+						Name:          testInstanceName,
+						CapacityBytes: 1 * util.Tb,
+					},
+				},
+			},
+			targetShareToAccomodate: &file.Share{
+				Name:          testShareName + "2",
+				CapacityBytes: 900 * util.Gb,
+				Parent: &file.MultishareInstance{
+					Project:            testProject,
+					Location:           testRegion,
+					Name:               testInstanceName,
+					CapacityBytes:      1 * util.Tb,
+					CapacityStepSizeGb: 256,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceMaxSizeLabelKey: "1536",
+					},
 				},
 			},
 			expectedNeedsExpand: true,
-			targetBytes:         1*util.Tb + (900*util.Gb - (1*util.Tb - 2*100*util.Gb)),
+			targetBytes:         1536 * util.Gb,
 		},
 	}
 	for _, tc := range tests {
@@ -419,7 +461,7 @@ func TestInstanceNeedsExpand(t *testing.T) {
 			runRequest := func(ctx context.Context, share *file.Share, capNeeded int64) <-chan Response {
 				responseChannel := make(chan Response)
 				go func() {
-					needsExpand, targetBytes, err := mcs.opsManager.instanceNeedsExpand(context.Background(), share, capNeeded)
+					needsExpand, targetBytes, err := mcs.opsManager.instanceNeedsExpand(context.Background(), share, capNeeded, nil)
 					responseChannel <- Response{
 						instanceNeedsExpand: needsExpand,
 						targetBytes:         targetBytes,
@@ -971,6 +1013,131 @@ func TestListMatchedInstances(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "shared cluster group, instances of other clusters of the group match",
+			req: &csi.CreateVolumeRequest{
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+			},
+			target: &file.MultishareInstance{
+				Name:     "test-target-instance",
+				Project:  testProject,
+				Location: testRegion,
+				Labels: map[string]string{
+					util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+					TagKeyClusterLocation:                  testLocation,
+					TagKeyClusterName:                      testClusterName,
+					TagKeySharedClusterGroup:               "blue-green",
+				},
+			},
+			initInstanceList: []*file.MultishareInstance{
+				{
+					Name:     "test-instance-other-cluster",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  "us-east1",
+						TagKeyClusterName:                      "other-cluster",
+						TagKeySharedClusterGroup:               "blue-green",
+					},
+				},
+				{
+					Name:     "test-instance-other-group",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      testClusterName,
+						TagKeySharedClusterGroup:               "other-group",
+					},
+				},
+				{
+					Name:     "test-instance-no-group",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      testClusterName,
+					},
+				},
+			},
+			expectedList: []*file.MultishareInstance{
+				{
+					Name:     "test-instance-other-cluster",
+					Project:  testProject,
+					Location: testRegion,
+				},
+			},
+		},
+		{
+			name: "instances of another cluster with the same name and location",
+			req: &csi.CreateVolumeRequest{
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+			},
+			target: &file.MultishareInstance{
+				Name:     "test-target-instance",
+				Project:  testProject,
+				Location: testRegion,
+				Labels: map[string]string{
+					util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+					TagKeyClusterLocation:                  testLocation,
+					TagKeyClusterName:                      testClusterName,
+					TagKeyClusterUID:                       "new-uid",
+				},
+			},
+			initInstanceList: []*file.MultishareInstance{
+				{
+					Name:     "test-instance-deleted-cluster",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      testClusterName,
+						TagKeyClusterUID:                       "old-uid",
+					},
+				},
+				{
+					Name:     "test-instance-same-cluster",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      testClusterName,
+						TagKeyClusterUID:                       "new-uid",
+					},
+				},
+				{
+					Name:     "test-instance-no-uid",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      testClusterName,
+					},
+				},
+			},
+			expectedList: []*file.MultishareInstance{
+				{
+					Name:     "test-instance-same-cluster",
+					Project:  testProject,
+					Location: testRegion,
+				},
+				{
+					Name:     "test-instance-no-uid",
+					Project:  testProject,
+					Location: testRegion,
+				},
+			},
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -987,13 +1154,16 @@ func TestListMatchedInstances(t *testing.T) {
 				cloud:  cloudProvider,
 			}
 			mcs := NewMultishareController(config)
-			filteredList, err := mcs.opsManager.listMatchedInstances(context.Background(), tc.req, tc.target, testRegions)
+			filteredList, err := mcs.opsManager.listMatchedInstances(context.Background(), tc.req, tc.target, testRegions, mcs.opsManager.resolveReservedIPRange(context.Background(), tc.req.GetParameters()))
 			if tc.expectError && err == nil {
 				t.Errorf("expected error: %v", err)
 			}
 			if !tc.expectError && err != nil {
 				t.Errorf("unexpectded error: %v", err)
 			}
+			if len(filteredList) != len(tc.expectedList) {
+				t.Errorf("got %d matched instances, expected %d", len(filteredList), len(tc.expectedList))
+			}
 			for _, fi := range filteredList {
 				if !found(tc.expectedList, fi) {
 					t.Errorf("Failed to find instance %+v", fi)
@@ -1003,6 +1173,97 @@ func TestListMatchedInstances(t *testing.T) {
 	}
 }
 
+func TestListMatchedInstancesReservedIPRange(t *testing.T) {
+	labels := map[string]string{
+		util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+		TagKeyClusterLocation:                  testLocation,
+		TagKeyClusterName:                      testClusterName,
+	}
+	newInstance := func(name, ip string) *file.MultishareInstance {
+		return &file.MultishareInstance{
+			Name:     name,
+			Project:  testProject,
+			Location: testRegion,
+			Labels:   labels,
+			Network: file.Network{
+				Name:        defaultNetwork,
+				ConnectMode: privateServiceAccess,
+				Ip:          ip,
+			},
+			Tier: enterpriseTier,
+		}
+	}
+	allocatedRanges := []*compute.AddressRange{
+		{Name: "psa-range-1", Network: defaultNetwork, CIDR: "10.1.0.0/20"},
+		{Name: "psa-range-2", Network: defaultNetwork, CIDR: "10.2.0.0/20"},
+	}
+
+	tests := []struct {
+		name            string
+		reservedIPRange string
+		computeErr      error
+		expectedNames   []string
+	}{
+		{
+			name:          "reserved-ip-range not set",
+			expectedNames: []string{"instance-1", "instance-2"},
+		},
+		{
+			name:            "named reserved-ip-range",
+			reservedIPRange: "psa-range-2",
+			expectedNames:   []string{"instance-2"},
+		},
+		{
+			name:            "reserved-ip-range not found",
+			reservedIPRange: "unknown-range",
+			expectedNames:   []string{"instance-1", "instance-2"},
+		},
+		{
+			name:            "reserved-ip-range lookup fails",
+			reservedIPRange: "psa-range-2",
+			computeErr:      fmt.Errorf("permission denied"),
+			expectedNames:   []string{"instance-1", "instance-2"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cloudProvider, err := cloud.NewFakeCloud()
+			if err != nil {
+				t.Fatalf("failed to initialize Provider: %v", err)
+			}
+			fakeCompute := compute.NewFakeService(allocatedRanges)
+			fakeCompute.Err = tc.computeErr
+			cloudProvider.Compute = fakeCompute
+			for _, i := range []*file.MultishareInstance{newInstance("instance-1", "10.1.0.2"), newInstance("instance-2", "10.2.0.2")} {
+				cloudProvider.File.StartCreateMultishareInstanceOp(context.Background(), i)
+			}
+			config := &controllerServerConfig{
+				driver: initTestDriver(t),
+				cloud:  cloudProvider,
+			}
+			mcs := NewMultishareController(config)
+
+			params := map[string]string{ParamMultishareInstanceScLabel: testInstanceScPrefix}
+			if tc.reservedIPRange != "" {
+				params[ParamReservedIPRange] = tc.reservedIPRange
+			}
+			cidr := mcs.opsManager.resolveReservedIPRange(context.Background(), params)
+			instances, err := mcs.opsManager.listMatchedInstances(context.Background(), &csi.CreateVolumeRequest{Parameters: params}, newInstance("target", ""), []string{testRegion}, cidr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, i := range instances {
+				names = append(names, i.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tc.expectedNames) {
+				t.Errorf("got matched instances %v, want %v", names, tc.expectedNames)
+			}
+		})
+	}
+}
+
 func TestContainsOpWithInstanceTargetPrefix(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -1624,6 +1885,17 @@ func TestRunEligibleInstanceCheck(t *testing.T) {
 		}
 		return false
 	}
+	// shares returns count shares of the instance of the given name.
+	shares := func(instanceName string, count int) []*file.Share {
+		var list []*file.Share
+		for n := 0; n < count; n++ {
+			list = append(list, &file.Share{
+				Name:   fmt.Sprintf("%s-share-%d", instanceName, n),
+				Parent: &file.MultishareInstance{Name: instanceName, Project: testProject, Location: testRegion},
+			})
+		}
+		return list
+	}
 	tests := []struct {
 		name                  string
 		ops                   []*OpInfo
@@ -1706,6 +1978,56 @@ func TestRunEligibleInstanceCheck(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "instance excluded from packing",
+			req: &csi.CreateVolumeRequest{
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+			},
+			target: &file.MultishareInstance{
+				Name:     "test-target-instance",
+				Project:  testProject,
+				Location: testRegion,
+				Labels: map[string]string{
+					util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+					TagKeyClusterLocation:                  testLocation,
+					TagKeyClusterName:                      testClusterName,
+				},
+			},
+			initInstances: []*file.MultishareInstance{
+				{
+					Name:     "test-instance-1",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      testClusterName,
+						TagKeyExcludeFromPacking:               "true",
+					},
+					State: "READY",
+				},
+				{
+					Name:     "test-instance-2",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      testClusterName,
+					},
+					State: "READY",
+				},
+			},
+			expectedReadyInstance: []*file.MultishareInstance{
+				{
+					Name:     "test-instance-2",
+					Project:  testProject,
+					Location: testRegion,
+				},
+			},
+		},
 		{
 			name: "non-ready instances (instance update)",
 			req: &csi.CreateVolumeRequest{
@@ -2356,6 +2678,90 @@ func TestRunEligibleInstanceCheck(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "instance reporting more max shares than the default, ready instance found",
+			req: &csi.CreateVolumeRequest{
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+			},
+			target: &file.MultishareInstance{
+				Name:     "test-target-instance",
+				Project:  testProject,
+				Location: testRegion,
+				Labels: map[string]string{
+					util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+					TagKeyClusterLocation:                  testLocation,
+					TagKeyClusterName:                      testClusterName,
+				},
+			},
+			initInstances: []*file.MultishareInstance{
+				{
+					Name:     "instance-1",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+						TagKeyClusterLocation:                  testLocation,
+						TagKeyClusterName:                      testClusterName,
+					},
+					State:         "READY",
+					MaxShareCount: 20,
+				},
+			},
+			initShares: shares("instance-1", util.MaxSharesPerInstance),
+			expectedReadyInstance: []*file.MultishareInstance{
+				{
+					Name:     "instance-1",
+					Project:  testProject,
+					Location: testRegion,
+				},
+			},
+		},
+		{
+			name: "instance exhausted with max instance size, no ready instance found",
+			req: &csi.CreateVolumeRequest{
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 100 * util.Gb},
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+			},
+			target: &file.MultishareInstance{
+				Name:     "test-target-instance",
+				Project:  testProject,
+				Location: testRegion,
+				Labels: map[string]string{
+					util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+					TagKeyClusterLocation:                  testLocation,
+					TagKeyClusterName:                      testClusterName,
+				},
+			},
+			initInstances: []*file.MultishareInstance{
+				{
+					Name:     "instance-1",
+					Project:  testProject,
+					Location: testRegion,
+					Labels: map[string]string{
+						util.ParamMultishareInstanceScLabelKey:      testInstanceScPrefix,
+						util.ParamMultishareInstanceMaxSizeLabelKey: "1024",
+						TagKeyClusterLocation:                       testLocation,
+						TagKeyClusterName:                           testClusterName,
+					},
+					State: "READY",
+				},
+			},
+			initShares: []*file.Share{
+				{
+					Name:          "share-1",
+					CapacityBytes: 1 * util.Tb,
+					Parent: &file.MultishareInstance{
+						Name:     "instance-1",
+						Project:  testProject,
+						Location: testRegion,
+					},
+				},
+			},
+		},
 		{
 			name: "1 instance exhausted with max shares, 1 ready instance with less than max share count",
 			features: &GCFSDriverFeatureOptions{
@@ -2461,37 +2867,604 @@ func TestRunEligibleInstanceCheck(t *testing.T) {
 			},
 		},
 	}
+	for _, tc := range tests {
+		for _, parallelism := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s/parallelism-%d", tc.name, parallelism), func(t *testing.T) {
+				s, err := file.NewFakeServiceForMultishare(tc.initInstances, tc.initShares, nil)
+				if err != nil {
+					t.Fatalf("failed to fake service: %v", err)
+				}
+				cloudProvider, _ := cloud.NewFakeCloud()
+				cloudProvider.File = s
+				config := &controllerServerConfig{
+					driver:          initTestDriver(t),
+					fileService:     s,
+					cloud:           cloudProvider,
+					features:        tc.features,
+					listParallelism: parallelism,
+				}
+				mcs := NewMultishareController(config)
+				ready, err := mcs.opsManager.runEligibleInstanceCheck(context.Background(), tc.req, tc.ops, tc.target, testRegions, mcs.opsManager.resolveReservedIPRange(context.Background(), tc.req.GetParameters()))
+				if err != nil && !tc.expectError {
+					t.Errorf("unexpected error")
+				}
+
+				if tc.expectError && err == nil {
+					t.Errorf("expected error")
+				}
+				if len(ready) != len(tc.expectedReadyInstance) {
+					t.Errorf("Mismatch in expected ready instances count, ready %d, expected %d", len(ready), len(tc.expectedReadyInstance))
+				}
+				for _, r := range ready {
+					if !found(tc.expectedReadyInstance, r) {
+						t.Errorf("expected instance not ready")
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRunEligibleInstanceCheckExcludedInstances(t *testing.T) {
+	labels := map[string]string{
+		util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+		TagKeyClusterLocation:                  testLocation,
+		TagKeyClusterName:                      testClusterName,
+	}
+	suspended := &file.MultishareInstance{
+		Name:              "test-instance-suspended",
+		Project:           testProject,
+		Location:          testRegion,
+		Labels:            labels,
+		State:             "SUSPENDED",
+		SuspensionReasons: []string{"KMS_KEY_ISSUE"},
+	}
+	ready := &file.MultishareInstance{
+		Name:     "test-instance-ready",
+		Project:  testProject,
+		Location: testRegion,
+		Labels:   labels,
+		State:    "READY",
+	}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{suspended, ready}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	recorder := record.NewFakeRecorder(10)
+	config := &controllerServerConfig{
+		driver:         initTestDriver(t),
+		fileService:    s,
+		cloud:          cloudProvider,
+		instanceEvents: &instanceEventsReporter{recorder: recorder},
+	}
+	mcs := NewMultishareController(config)
+	mcs.opsManager.controllerServer = &controllerServer{config: config}
+	req := &csi.CreateVolumeRequest{
+		Parameters: map[string]string{
+			ParamMultishareInstanceScLabel: testInstanceScPrefix,
+			ParameterKeyPVCName:            "test-pvc",
+			ParameterKeyPVCNamespace:       "default",
+		},
+	}
+	target := &file.MultishareInstance{Name: "test-target-instance", Project: testProject, Location: testRegion, Labels: labels}
+	suspendedURI := "projects/test-project/locations/us-central1/instances/test-instance-suspended"
+
+	eligible, err := mcs.opsManager.runEligibleInstanceCheck(context.Background(), req, nil, target, testRegions, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(eligible) != 1 || eligible[0].Name != ready.Name {
+		t.Errorf("got eligible instances %v, expected only %s", eligible, ready.Name)
+	}
+	if state := mcs.opsManager.excludedInstances[suspendedURI]; state != "SUSPENDED" {
+		t.Errorf("got excluded instance state %q, expected SUSPENDED", state)
+	}
+	expectedEvent := "Warning FilestoreInstanceExcluded Filestore instance " + suspendedURI + " is in state SUSPENDED, it is excluded from the placement of new shares until it recovers (reason KMS_KEY_ISSUE)"
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, []string{expectedEvent}) {
+		t.Errorf("got events %v, expected %v", events, []string{expectedEvent})
+	}
+
+	suspended.State = "READY"
+	eligible, err = mcs.opsManager.runEligibleInstanceCheck(context.Background(), req, nil, target, testRegions, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(eligible) != 2 {
+		t.Errorf("got %d eligible instances, expected 2", len(eligible))
+	}
+	if _, ok := mcs.opsManager.excludedInstances[suspendedURI]; ok {
+		t.Errorf("expected instance %s not to be excluded anymore", suspendedURI)
+	}
+}
+
+func TestRunEligibleInstanceCheckCreatingShares(t *testing.T) {
+	labels := map[string]string{
+		util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+		TagKeyClusterLocation:                  testLocation,
+		TagKeyClusterName:                      testClusterName,
+	}
+	newInstance := func(name string) *file.MultishareInstance {
+		return &file.MultishareInstance{
+			Name:          name,
+			Project:       testProject,
+			Location:      testRegion,
+			Labels:        labels,
+			State:         "READY",
+			CapacityBytes: 1 * util.Tb,
+			MaxShareCount: 2,
+		}
+	}
+	full, partial := newInstance("test-instance-full"), newInstance("test-instance-partial")
+	shares := []*file.Share{
+		// The shares being created hold their slot on the instance during a burst of creations.
+		{Name: "share-ready", Parent: full, CapacityBytes: 100 * util.Gb, State: file.ShareStateReady},
+		{Name: "share-creating", Parent: full, CapacityBytes: 100 * util.Gb, State: file.ShareStateCreating},
+		{Name: "share-deleting", Parent: partial, CapacityBytes: 100 * util.Gb, State: file.ShareStateDeleting},
+	}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{full, partial}, shares, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	mcs := NewMultishareController(&controllerServerConfig{
+		driver:      initTestDriver(t),
+		fileService: s,
+		cloud:       cloudProvider,
+	})
+	req := &csi.CreateVolumeRequest{
+		Parameters: map[string]string{
+			ParamMultishareInstanceScLabel: testInstanceScPrefix,
+		},
+	}
+	target := &file.MultishareInstance{Name: "test-target-instance", Project: testProject, Location: testRegion, Labels: labels}
+
+	eligible, err := mcs.opsManager.runEligibleInstanceCheck(context.Background(), req, nil, target, testRegions, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(eligible) != 1 || eligible[0].Name != partial.Name {
+		t.Errorf("got eligible instances %v, expected only %s", eligible, partial.Name)
+	}
+
+	// The capacity of the shares being created is counted to size the instance.
+	needsExpand, targetBytes, err := mcs.opsManager.instanceNeedsExpand(context.Background(), &file.Share{Name: "share-new", Parent: full}, 900*util.Gb, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !needsExpand || targetBytes != 2*util.Tb {
+		t.Errorf("got needs expand %v to %d bytes, expected expansion to %d bytes", needsExpand, targetBytes, 2*util.Tb)
+	}
+}
+
+func TestInstanceExpandInFlight(t *testing.T) {
+	instance := &file.MultishareInstance{
+		Name:          testInstanceName,
+		Project:       testProject,
+		Location:      testRegion,
+		State:         "READY",
+		CapacityBytes: 1 * util.Tb,
+	}
+	instanceURI := "projects/test-project/locations/us-central1/instances/" + testInstanceName
+	var ops []*filev1beta1multishare.Operation
+	for _, op := range []struct {
+		name string
+		done bool
+	}{{name: "op-expand"}, {name: "op-done", done: true}} {
+		meta, _ := json.Marshal(filev1beta1multishare.OperationMetadata{Target: instanceURI, Verb: "update"})
+		ops = append(ops, &filev1beta1multishare.Operation{Name: op.name, Done: op.done, Metadata: meta})
+	}
+	shares := []*file.Share{{Name: "share-1", Parent: instance, CapacityBytes: 900 * util.Gb, State: file.ShareStateReady}}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instance}, shares, ops)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	mcs := NewMultishareController(&controllerServerConfig{
+		driver:      initTestDriver(t),
+		fileService: s,
+		cloud:       cloudProvider,
+	})
+	m := mcs.opsManager
+	m.opTargets["op-expand"] = 2 * util.Tb
+	m.opTargets["op-done"] = 3 * util.Tb
+
+	running, err := m.listMultishareResourceRunningOps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(running) != 1 || running[0].TargetBytes != 2*util.Tb {
+		t.Fatalf("got running ops %+v, expected op-expand with target bytes %d", running, 2*util.Tb)
+	}
+	if _, ok := m.opTargets["op-done"]; ok {
+		t.Errorf("expected the target bytes of the done op to be forgotten")
+	}
+
+	share := &file.Share{Name: "share-2", Parent: instance, CapacityBytes: 500 * util.Gb}
+	if needsExpand, _, err := m.instanceNeedsExpand(context.Background(), share, share.CapacityBytes, nil); err != nil || !needsExpand {
+		t.Errorf("got needs expand %v, error %v, expected an expansion of the current capacity", needsExpand, err)
+	}
+	if needsExpand, _, err := m.instanceNeedsExpand(context.Background(), share, share.CapacityBytes, running); err != nil || needsExpand {
+		t.Errorf("got needs expand %v, error %v, expected the share to fit in the expanding instance", needsExpand, err)
+	}
+
+	// A share fitting in the expanding instance waits on the running expansion.
+	w, err := m.checkAndStartShareCreateOnInstanceWorkflow(context.Background(), share)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.opName != "op-expand" || w.opType != util.InstanceUpdate {
+		t.Errorf("got workflow %+v, expected to wait on op-expand", w)
+	}
+	// A share not fitting in the expanding instance is retried once the expansion is done.
+	share.CapacityBytes = 1500 * util.Gb
+	if _, err := m.checkAndStartShareCreateOnInstanceWorkflow(context.Background(), share); status.Code(err) != codes.Aborted {
+		t.Errorf("got error %v, expected code %v", err, codes.Aborted)
+	}
+}
+
+func TestShareUsageRunningShareCreates(t *testing.T) {
+	instance := &file.MultishareInstance{
+		Name:          testInstanceName,
+		Project:       testProject,
+		Location:      testRegion,
+		State:         "READY",
+		CapacityBytes: 1 * util.Tb,
+		MaxShareCount: 3,
+	}
+	instanceURI := "projects/test-project/locations/us-central1/instances/" + testInstanceName
+	var ops []*filev1beta1multishare.Operation
+	for name, target := range map[string]string{
+		"op-listed":         instanceURI + "/shares/share-1",
+		"op-unlisted":       instanceURI + "/shares/share-2",
+		"op-unknown":        instanceURI + "/shares/share-3",
+		"op-other-instance": "projects/test-project/locations/us-central1/instances/other/shares/share-4",
+	} {
+		meta, _ := json.Marshal(filev1beta1multishare.OperationMetadata{Target: target, Verb: "create"})
+		ops = append(ops, &filev1beta1multishare.Operation{Name: name, Metadata: meta})
+	}
+	shares := []*file.Share{{Name: "share-1", Parent: instance, CapacityBytes: 400 * util.Gb, State: file.ShareStateCreating}}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instance}, shares, ops)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	mcs := NewMultishareController(&controllerServerConfig{
+		driver:      initTestDriver(t),
+		fileService: s,
+		cloud:       cloudProvider,
+	})
+	m := mcs.opsManager
+	m.opTargets["op-listed"] = 400 * util.Gb
+	m.opTargets["op-unlisted"] = 300 * util.Gb
+
+	running, err := m.listMultishareResourceRunningOps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The shares of the burst not listed yet hold their slot and capacity, the listed share
+	// of a running op is counted once, and the unknown capacity is the minimum share capacity.
+	counts, capacities, err := m.countShares(context.Background(), []*file.MultishareInstance{instance}, running)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedBytes := 700*util.Gb + util.ConfigurablePackMinShareSizeBytes
+	if counts[0] != 3 || capacities[0] != expectedBytes {
+		t.Errorf("got %d shares of %d bytes, expected 3 shares of %d bytes", counts[0], capacities[0], expectedBytes)
+	}
+	if counts[0] < instanceMaxShareCount(instance) {
+		t.Errorf("got %d shares, expected the instance to be full with %d shares", counts[0], instanceMaxShareCount(instance))
+	}
+
+	share := &file.Share{Name: "share-5", Parent: instance, CapacityBytes: 400 * util.Gb}
+	if needsExpand, _, err := m.instanceNeedsExpand(context.Background(), share, share.CapacityBytes, nil); err != nil || needsExpand {
+		t.Errorf("got needs expand %v, error %v, expected the share to fit without the running ops", needsExpand, err)
+	}
+	if needsExpand, _, err := m.instanceNeedsExpand(context.Background(), share, share.CapacityBytes, running); err != nil || !needsExpand {
+		t.Errorf("got needs expand %v, error %v, expected an expansion for the shares being created", needsExpand, err)
+	}
+}
+
+func TestSpreadByNamespace(t *testing.T) {
+	instance := func(name string) *file.MultishareInstance {
+		return &file.MultishareInstance{Name: name, Project: testProject, Location: testRegion, State: "READY"}
+	}
+	share := func(name, instanceName, namespace string) *file.Share {
+		return &file.Share{
+			Name:   name,
+			Parent: instance(instanceName),
+			Labels: map[string]string{tagKeyCreatedForClaimNamespace: namespace},
+		}
+	}
+	tests := []struct {
+		name              string
+		namespace         string
+		instances         []string
+		shares            []*file.Share
+		expectedInstances []string
+	}{
+		{
+			name:              "no namespace",
+			instances:         []string{"instance-1", "instance-2"},
+			shares:            []*file.Share{share("share-1", "instance-1", "ns1")},
+			expectedInstances: []string{"instance-1", "instance-2"},
+		},
+		{
+			name:              "single instance",
+			namespace:         "ns1",
+			instances:         []string{"instance-1"},
+			shares:            []*file.Share{share("share-1", "instance-1", "ns1")},
+			expectedInstances: []string{"instance-1"},
+		},
+		{
+			name:      "instance without shares of the namespace",
+			namespace: "ns1",
+			instances: []string{"instance-1", "instance-2", "instance-3"},
+			shares: []*file.Share{
+				share("share-1", "instance-1", "ns1"),
+				share("share-2", "instance-2", "ns2"),
+				share("share-3", "instance-3", "ns1"),
+			},
+			expectedInstances: []string{"instance-2"},
+		},
+		{
+			name:      "instances with the fewest shares of the namespace",
+			namespace: "ns1",
+			instances: []string{"instance-1", "instance-2", "instance-3"},
+			shares: []*file.Share{
+				share("share-1", "instance-1", "ns1"),
+				share("share-2", "instance-1", "ns1"),
+				share("share-3", "instance-2", "ns1"),
+				share("share-4", "instance-3", "ns1"),
+			},
+			expectedInstances: []string{"instance-2", "instance-3"},
+		},
+	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			s, err := file.NewFakeServiceForMultishare(tc.initInstances, tc.initShares, nil)
+			var instances []*file.MultishareInstance
+			for _, name := range tc.instances {
+				instances = append(instances, instance(name))
+			}
+			s, err := file.NewFakeServiceForMultishare(instances, tc.shares, nil)
 			if err != nil {
 				t.Fatalf("failed to fake service: %v", err)
 			}
 			cloudProvider, _ := cloud.NewFakeCloud()
 			cloudProvider.File = s
-			config := &controllerServerConfig{
+			mcs := NewMultishareController(&controllerServerConfig{
 				driver:      initTestDriver(t),
 				fileService: s,
 				cloud:       cloudProvider,
-				features:    tc.features,
+			})
+			spread, err := mcs.opsManager.spreadByNamespace(context.Background(), instances, tc.namespace)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
 			}
-			mcs := NewMultishareController(config)
-			ready, err := mcs.opsManager.runEligibleInstanceCheck(context.Background(), tc.req, tc.ops, tc.target, testRegions)
-			if err != nil && !tc.expectError {
-				t.Errorf("unexpected error")
+			var names []string
+			for _, i := range spread {
+				names = append(names, i.Name)
+			}
+			if !reflect.DeepEqual(names, tc.expectedInstances) {
+				t.Errorf("got instances %v, expected %v", names, tc.expectedInstances)
 			}
+		})
+	}
+}
 
-			if tc.expectError && err == nil {
-				t.Errorf("expected error")
+func TestStartShareCreateWorkflowNameCollision(t *testing.T) {
+	instance := &file.MultishareInstance{
+		Name:     "instance-1",
+		Project:  testProject,
+		Location: testRegion,
+		Labels:   map[string]string{util.ParamMultishareInstanceScLabelKey: "pool-a"},
+		State:    "READY",
+	}
+	// The share of another storage class sharing the instance.
+	existing := &file.Share{Name: "pvc_1", Parent: instance, Labels: map[string]string{tagKeyCreatedForClaimNamespace: "ns1"}}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instance}, []*file.Share{existing}, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	mcs := NewMultishareController(&controllerServerConfig{
+		driver:      initTestDriver(t),
+		fileService: s,
+		cloud:       cloudProvider,
+	})
+	ctx := context.Background()
+
+	_, err = mcs.opsManager.startShareCreateWorkflowSafe(ctx, &file.Share{Name: "pvc_1", Parent: instance, CapacityBytes: 100 * util.Gb})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("got error %v, expected code %v", err, codes.AlreadyExists)
+	}
+	w, err := mcs.opsManager.startShareCreateWorkflowSafe(ctx, &file.Share{Name: "pool_a_pvc_1", Parent: instance, CapacityBytes: 100 * util.Gb})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.opType != util.ShareCreate {
+		t.Errorf("got workflow %v, expected %v", w.opType, util.ShareCreate)
+	}
+}
+
+func TestSetupEligibleInstanceAllowNewInstances(t *testing.T) {
+	labels := map[string]string{
+		util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+		TagKeyClusterLocation:                  testLocation,
+		TagKeyClusterName:                      testClusterName,
+	}
+	full := &file.MultishareInstance{
+		Name:          "instance-full",
+		Project:       testProject,
+		Location:      testRegion,
+		Labels:        labels,
+		State:         "READY",
+		CapacityBytes: 1 * util.Tb,
+		MaxShareCount: 1,
+	}
+	shares := []*file.Share{{Name: "pvc_1", Parent: full, CapacityBytes: 100 * util.Gb, State: file.ShareStateReady}}
+
+	cases := []struct {
+		name              string
+		allowNewInstances string
+		expectedCode      codes.Code
+	}{
+		{name: "default"},
+		{name: "new instances allowed", allowNewInstances: "true"},
+		{name: "new instances not allowed", allowNewInstances: "false", expectedCode: codes.ResourceExhausted},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{full}, shares, nil)
+			if err != nil {
+				t.Fatalf("failed to fake service: %v", err)
 			}
-			if len(ready) != len(tc.expectedReadyInstance) {
-				t.Errorf("Mismatch in expected ready instances count, ready %d, expected %d", len(ready), len(tc.expectedReadyInstance))
+			cloudProvider, _ := cloud.NewFakeCloud()
+			cloudProvider.File = s
+			mcs := NewMultishareController(&controllerServerConfig{
+				driver:      initTestDriver(t),
+				fileService: s,
+				cloud:       cloudProvider,
+			})
+			params := map[string]string{ParamMultishareInstanceScLabel: testInstanceScPrefix}
+			if tc.allowNewInstances != "" {
+				params[paramAllowNewInstances] = tc.allowNewInstances
 			}
-			for _, r := range ready {
-				if !found(tc.expectedReadyInstance, r) {
-					t.Errorf("expected instance not ready")
-				}
+			req := &csi.CreateVolumeRequest{
+				Name:          "pvc_2",
+				Parameters:    params,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 100 * util.Gb},
+			}
+			target := &file.MultishareInstance{Name: "instance-new", Project: testProject, Location: testRegion, Labels: labels, CapacityBytes: 1 * util.Tb}
+
+			w, _, err := mcs.opsManager.setupEligibleInstanceAndStartWorkflow(context.Background(), req, target, "")
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("got error %v, expected code %v", err, tc.expectedCode)
+			}
+			if err == nil && w.opType != util.InstanceCreate {
+				t.Errorf("got workflow %v, expected %v", w.opType, util.InstanceCreate)
 			}
 		})
 	}
 }
+
+func TestMultishareCreateVolumeResumesPendingWait(t *testing.T) {
+	fakeService, err := file.NewFakeServiceForMultishare(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	faults := file.NewFaultInjector()
+	// The wait on the instance create operation outlives the first attempt of the request.
+	faults.Inject("WaitForOpWithOpts", file.Fault{OnCall: 1, Latency: time.Second})
+	fileService := file.NewFakeServiceWithFaults(fakeService, faults)
+	cloudProvider, err := cloud.NewFakeCloudWithFiler(fileService, testProject, testLocation)
+	if err != nil {
+		t.Fatalf("failed to get cloud provider: %v", err)
+	}
+	mcs := NewMultishareController(&controllerServerConfig{
+		driver:      initTestDriver(t),
+		fileService: fileService,
+		cloud:       cloudProvider,
+		volumeLocks: util.NewVolumeLocks(),
+		isRegional:  true,
+		clusterName: testClusterName,
+		tagManager:  cloud.NewFakeTagManager(),
+	})
+	req := &csi.CreateVolumeRequest{
+		Name:          "pvc-resumed",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 100 * util.Gb},
+		Parameters:    map[string]string{ParamMultishareInstanceScLabel: testInstanceScPrefix},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := mcs.CreateVolume(ctx, req); err == nil {
+		t.Fatalf("expected the first attempt to fail")
+	}
+	if w := mcs.opsManager.pendingWorkflows[req.Name]; w == nil || w.opType != util.InstanceCreate {
+		t.Fatalf("got pending workflow %+v, expected the instance create workflow", w)
+	}
+
+	if _, err := mcs.CreateVolume(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := faults.Calls("StartCreateMultishareInstanceOp"); got != 1 {
+		t.Errorf("got %d instance create ops, expected 1", got)
+	}
+	if got := faults.Calls("StartCreateShareOp"); got != 1 {
+		t.Errorf("got %d share create ops, expected 1", got)
+	}
+	if len(mcs.opsManager.pendingWorkflows) != 0 {
+		t.Errorf("got pending workflows %v, expected none", mcs.opsManager.pendingWorkflows)
+	}
+}
+
+func TestStartInstanceUpdateWorkflowWithoutController(t *testing.T) {
+	instance := &file.MultishareInstance{
+		Name:          "instance-1",
+		Project:       testProject,
+		Location:      testRegion,
+		CapacityBytes: 2 * util.Tb,
+		Labels:        map[string]string{util.ParamMultishareInstanceScLabelKey: "pool-a"},
+		State:         "READY",
+	}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instance}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	m := NewMultishareOpsManager(cloudProvider, nil)
+
+	w, err := m.startInstanceWorkflow(context.Background(), &Workflow{instance: instance, opType: util.InstanceUpdate}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.opName == "" {
+		t.Errorf("expected the resize op to be started")
+	}
+	if _, ok := instance.Labels[TagKeyDriverVersion]; ok {
+		t.Errorf("got driver version label %q without a driver", instance.Labels[TagKeyDriverVersion])
+	}
+}
+
+func TestResolveReservedIPRangeCache(t *testing.T) {
+	cloudProvider, err := cloud.NewFakeCloud()
+	if err != nil {
+		t.Fatalf("failed to initialize Provider: %v", err)
+	}
+	fakeCompute := compute.NewFakeService([]*compute.AddressRange{{Name: "psa-range", Network: defaultNetwork, CIDR: "10.2.0.0/20"}})
+	cloudProvider.Compute = fakeCompute
+	m := NewMultishareOpsManager(cloudProvider, nil)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+	params := map[string]string{ParamReservedIPRange: "psa-range"}
+
+	if cidr := m.resolveReservedIPRange(ctx, params); cidr != "10.2.0.0/20" {
+		t.Fatalf("got CIDR %q, expected %q", cidr, "10.2.0.0/20")
+	}
+	// The cached CIDR is returned without a lookup until it expires.
+	fakeCompute.Err = fmt.Errorf("permission denied")
+	if cidr := m.resolveReservedIPRange(ctx, params); cidr != "10.2.0.0/20" {
+		t.Errorf("got CIDR %q, expected the cached %q", cidr, "10.2.0.0/20")
+	}
+	now = now.Add(reservedIPRangeCacheTTL)
+	if cidr := m.resolveReservedIPRange(ctx, params); cidr != "" {
+		t.Errorf("got CIDR %q after the cache expired, expected none", cidr)
+	}
+	// The failed lookup is cached too.
+	fakeCompute.Err = nil
+	if cidr := m.resolveReservedIPRange(ctx, params); cidr != "" {
+		t.Errorf("got CIDR %q, expected the cached failed lookup", cidr)
+	}
+}
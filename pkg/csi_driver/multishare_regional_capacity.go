@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// checkRegionalCapacity verifies that creating or expanding the instance to targetBytes keeps
+// the total capacity of the multishare instances managed by the driver in the region of the
+// instance within the regional limit, so that a runaway workload can't consume the whole
+// Filestore quota of the project. The instances being expanded by a running op count with the
+// target capacity of the op. A ResourceExhausted error is returned if the limit is hit.
+// The caller must hold the ops manager lock.
+func (m *MultishareOpsManager) checkRegionalCapacity(ctx context.Context, instance *file.MultishareInstance, targetBytes int64, ops []*OpInfo) error {
+	if m.maxRegionalCapacityBytes <= 0 {
+		return nil
+	}
+	instances, err := m.cloud.File.ListMultishareInstances(ctx, &file.ListFilter{Project: instance.Project, Location: instance.Location})
+	if err != nil {
+		return err
+	}
+	totalBytes := targetBytes
+	for _, i := range instances {
+		if i.Name == instance.Name {
+			if i.CapacityBytes > targetBytes {
+				// Shrinking an instance is never limited.
+				return nil
+			}
+			continue
+		}
+		if _, ok := i.Labels[util.ParamMultishareInstanceScLabelKey]; !ok {
+			continue
+		}
+		capacityBytes := i.CapacityBytes
+		if op := runningInstanceUpdate(i, ops); op != nil && op.TargetBytes > capacityBytes {
+			capacityBytes = op.TargetBytes
+		}
+		totalBytes += capacityBytes
+	}
+	if totalBytes <= m.maxRegionalCapacityBytes {
+		return nil
+	}
+
+	klog.Warningf("Instance %s can't be sized to %d bytes, the multishare instances of region %s would total %d bytes over the limit of %d bytes", instance.Name, targetBytes, instance.Location, totalBytes, m.maxRegionalCapacityBytes)
+	if m.controllerServer != nil {
+		m.controllerServer.config.metricsManager.RecordRegionalCapacityRejectedOperation(instance.Location)
+	}
+	return status.Errorf(codes.ResourceExhausted, "sizing instance %s to %d bytes would bring the multishare instances of region %s to %d bytes, over the limit of %d bytes", instance.Name, targetBytes, instance.Location, totalBytes, m.maxRegionalCapacityBytes)
+}
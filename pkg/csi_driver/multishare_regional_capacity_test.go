@@ -0,0 +1,99 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestCheckRegionalCapacity(t *testing.T) {
+	labels := map[string]string{util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix}
+	newInstance := func(name string, capacityBytes int64, labels map[string]string) *file.MultishareInstance {
+		return &file.MultishareInstance{Name: name, Project: testProject, Location: testRegion, CapacityBytes: capacityBytes, Labels: labels, State: "READY"}
+	}
+	instanceA := newInstance("instance-a", 2*util.Tb, labels)
+	instanceB := newInstance("instance-b", 1*util.Tb, labels)
+	// Instances not managed by the driver are not counted.
+	unmanaged := newInstance("instance-unmanaged", 5*util.Tb, nil)
+	// Instance b is being expanded to 2 TiB.
+	ops := []*OpInfo{{Id: "op-expand", Type: util.InstanceUpdate, Target: "projects/test-project/locations/us-central1/instances/instance-b", TargetBytes: 2 * util.Tb}}
+
+	cases := []struct {
+		name         string
+		limitBytes   int64
+		instance     *file.MultishareInstance
+		targetBytes  int64
+		expectedCode codes.Code
+	}{
+		{name: "no limit", instance: newInstance("instance-new", 10*util.Tb, labels), targetBytes: 10 * util.Tb},
+		{name: "new instance within limit", limitBytes: 5 * util.Tb, instance: newInstance("instance-new", 1*util.Tb, labels), targetBytes: 1 * util.Tb},
+		{name: "new instance over limit", limitBytes: 5 * util.Tb, instance: newInstance("instance-new", 2*util.Tb, labels), targetBytes: 2 * util.Tb, expectedCode: codes.ResourceExhausted},
+		{name: "expansion within limit", limitBytes: 5 * util.Tb, instance: instanceA, targetBytes: 3 * util.Tb},
+		{name: "expansion over limit", limitBytes: 5 * util.Tb, instance: instanceA, targetBytes: 4 * util.Tb, expectedCode: codes.ResourceExhausted},
+		{name: "shrink over limit", limitBytes: 1 * util.Tb, instance: instanceA, targetBytes: 1 * util.Tb},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instanceA, instanceB, unmanaged}, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to fake service: %v", err)
+			}
+			cloudProvider, _ := cloud.NewFakeCloud()
+			cloudProvider.File = s
+			mcs := NewMultishareController(&controllerServerConfig{
+				driver:                   initTestDriver(t),
+				fileService:              s,
+				cloud:                    cloudProvider,
+				maxRegionalCapacityBytes: tc.limitBytes,
+			})
+			err = mcs.opsManager.checkRegionalCapacity(context.Background(), tc.instance, tc.targetBytes, ops)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("got error %v, expected code %v", err, tc.expectedCode)
+			}
+		})
+	}
+}
+
+func TestStartInstanceWorkflowRegionalCapacity(t *testing.T) {
+	s, err := file.NewFakeServiceForMultishare(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	mcs := NewMultishareController(&controllerServerConfig{
+		driver:                   initTestDriver(t),
+		fileService:              s,
+		cloud:                    cloudProvider,
+		maxRegionalCapacityBytes: 1 * util.Tb,
+	})
+	instance := &file.MultishareInstance{Name: "instance-new", Project: testProject, Location: testRegion, CapacityBytes: 2 * util.Tb}
+	_, err = mcs.opsManager.startInstanceWorkflow(context.Background(), &Workflow{instance: instance, opType: util.InstanceCreate}, nil)
+	if code := status.Code(err); code != codes.ResourceExhausted {
+		t.Fatalf("got error %v, expected code %v", err, codes.ResourceExhausted)
+	}
+	if _, err := s.GetMultishareInstance(context.Background(), instance); !file.IsNotFoundErr(err) {
+		t.Errorf("got error %v, expected the instance not to be created", err)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const eventReasonOperationStuck = "FilestoreOperationStuck"
+
+// reportRunningOps records the running ops of each type with their age, and reports once
+// each op running for longer than the stuck threshold, with a log and with a warning event
+// on the PVs of the op when instance events are enabled. A stuck op tells a slow or stuck
+// Filestore backend apart from a driver which stopped reconciling: the driver waits on the
+// op, and retries the CSI calls until the op is done.
+// The caller must hold the ops manager lock.
+func (m *MultishareOpsManager) reportRunningOps(ctx context.Context, ops []*OpInfo, now time.Time) {
+	stats := make(map[string]metrics.RunningOpsStats)
+	running := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		running[op.Id] = true
+		s := stats[op.Type.String()]
+		s.Running++
+		var age time.Duration
+		if !op.CreateTime.IsZero() {
+			age = now.Sub(op.CreateTime)
+		}
+		if age > s.OldestAge {
+			s.OldestAge = age
+		}
+		if m.stuckOpThreshold > 0 && age >= m.stuckOpThreshold {
+			s.Stuck++
+			if !m.stuckOps[op.Id] {
+				m.stuckOps[op.Id] = true
+				m.reportStuckOp(ctx, op, age)
+			}
+		}
+		stats[op.Type.String()] = s
+	}
+	// Forget the ops which are done, so that the set does not grow with the op history.
+	for id := range m.stuckOps {
+		if !running[id] {
+			klog.Infof("Op %s reported stuck is done", id)
+			delete(m.stuckOps, id)
+		}
+	}
+	if m.controllerServer != nil {
+		m.controllerServer.config.metricsManager.RecordRunningOpsMetrics(stats)
+	}
+}
+
+// reportStuckOp logs a stuck op, and publishes a warning event on the PV of a share op, or
+// on the PVs of the shares of the instance of an instance op.
+func (m *MultishareOpsManager) reportStuckOp(ctx context.Context, op *OpInfo, age time.Duration) {
+	message := fmt.Sprintf("Filestore operation %s of type %s on %s has been running for %v", op.Id, op.Type.String(), op.Target, age.Round(time.Second))
+	klog.Warningf("%s, longer than the stuck threshold %v", message, m.stuckOpThreshold)
+	if m.controllerServer == nil || m.controllerServer.config.instanceEvents == nil {
+		return
+	}
+	pvNames, err := m.volumesOfOpTarget(ctx, op)
+	if err != nil {
+		klog.Errorf("Failed to list the volumes of op %s: %v", op.Id, err)
+		return
+	}
+	recorder := m.controllerServer.config.instanceEvents.recorder
+	for _, pvName := range pvNames {
+		recorder.Event(&v1.ObjectReference{Kind: "PersistentVolume", Name: pvName}, v1.EventTypeWarning, eventReasonOperationStuck, message)
+	}
+}
+
+// volumesOfOpTarget returns the names of the PVs of the shares targeted by an op. The PV
+// name is derived from the share name, see util.ConvertVolToShareName.
+func (m *MultishareOpsManager) volumesOfOpTarget(ctx context.Context, op *OpInfo) ([]string, error) {
+	if file.IsShareTarget(op.Target) {
+		_, _, _, shareName, err := util.ParseShareURI(op.Target)
+		if err != nil {
+			return nil, err
+		}
+		return []string{shareVolumeName(shareName)}, nil
+	}
+	project, location, instanceName, err := util.ParseInstanceURI(op.Target)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := m.cloud.File.ListShares(ctx, &file.ListFilter{Project: project, Location: location, InstanceName: instanceName})
+	if err != nil {
+		return nil, err
+	}
+	var pvNames []string
+	for _, s := range shares {
+		pvNames = append(pvNames, shareVolumeName(s.Name))
+	}
+	return pvNames, nil
+}
+
+// shareVolumeName returns the name of the PV of a share. Kubernetes object names have no
+// underscores, so the conversion of the PV name to the share name is reversible.
+func shareVolumeName(shareName string) string {
+	return strings.ReplaceAll(shareName, "_", "-")
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+	"k8s.io/client-go/tools/record"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestListMultishareResourceRunningOpsCreateTime(t *testing.T) {
+	meta, _ := json.Marshal(filev1beta1multishare.OperationMetadata{
+		Target:     "projects/test-project/locations/us-central1/instances/test-instance",
+		Verb:       "update",
+		CreateTime: "2024-01-02T03:04:05.123456789Z",
+	})
+	s, err := file.NewFakeServiceForMultishare(nil, nil, []*filev1beta1multishare.Operation{{Name: "op1", Metadata: meta}})
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	mcs := NewMultishareController(&controllerServerConfig{driver: initTestDriver(t), fileService: s, cloud: cloudProvider})
+	ops, err := mcs.opsManager.listMultishareResourceRunningOps(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if len(ops) != 1 || !ops[0].CreateTime.Equal(expected) {
+		t.Errorf("got ops %+v, expected one op created at %v", ops, expected)
+	}
+}
+
+func TestReportRunningOps(t *testing.T) {
+	instance := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "test-instance"}
+	shares := []*file.Share{
+		{Name: "pvc_1", Parent: instance},
+		{Name: "pvc_2", Parent: instance},
+	}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instance}, shares, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	recorder := record.NewFakeRecorder(10)
+	config := &controllerServerConfig{
+		driver:           initTestDriver(t),
+		fileService:      s,
+		cloud:            cloudProvider,
+		instanceEvents:   &instanceEventsReporter{recorder: recorder},
+		stuckOpThreshold: time.Hour,
+	}
+	mcs := NewMultishareController(config)
+	m := mcs.opsManager
+	m.controllerServer = &controllerServer{config: config}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	instanceURI := "projects/test-project/locations/us-central1/instances/test-instance"
+	shareOp := &OpInfo{Id: "op-share", Type: util.ShareCreate, Target: instanceURI + "/shares/pvc_3", CreateTime: now.Add(-2 * time.Hour)}
+	instanceOp := &OpInfo{Id: "op-instance", Type: util.InstanceUpdate, Target: instanceURI, CreateTime: now.Add(-90 * time.Minute)}
+	recentOp := &OpInfo{Id: "op-recent", Type: util.ShareDelete, Target: instanceURI + "/shares/pvc_1", CreateTime: now.Add(-time.Minute)}
+	ctx := context.Background()
+
+	m.reportRunningOps(ctx, []*OpInfo{shareOp, instanceOp, recentOp}, now)
+	expectedEvents := []string{
+		"Warning FilestoreOperationStuck Filestore operation op-instance of type instanceupdate on " + instanceURI + " has been running for 1h30m0s",
+		"Warning FilestoreOperationStuck Filestore operation op-instance of type instanceupdate on " + instanceURI + " has been running for 1h30m0s",
+		"Warning FilestoreOperationStuck Filestore operation op-share of type sharecreate on " + shareOp.Target + " has been running for 2h0m0s",
+	}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+	if expected := map[string]bool{"op-share": true, "op-instance": true}; !reflect.DeepEqual(m.stuckOps, expected) {
+		t.Errorf("got stuck ops %v, expected %v", m.stuckOps, expected)
+	}
+
+	// A stuck op is reported once, and forgotten once done.
+	m.reportRunningOps(ctx, []*OpInfo{shareOp, recentOp}, now.Add(time.Hour))
+	expectedEvents = []string{
+		"Warning FilestoreOperationStuck Filestore operation op-recent of type sharedelete on " + recentOp.Target + " has been running for 1h1m0s",
+	}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+	if expected := map[string]bool{"op-share": true, "op-recent": true}; !reflect.DeepEqual(m.stuckOps, expected) {
+		t.Errorf("got stuck ops %v, expected %v", m.stuckOps, expected)
+	}
+
+	// No op is reported stuck without a threshold.
+	m.stuckOpThreshold = 0
+	m.reportRunningOps(ctx, nil, now)
+	m.reportRunningOps(ctx, []*OpInfo{instanceOp}, now)
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Errorf("got events %v, expected none", events)
+	}
+	if len(m.stuckOps) != 0 {
+		t.Errorf("got stuck ops %v, expected none", m.stuckOps)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// scaleTestVolumes is the number of concurrent CreateVolume calls of a simulated burst.
+	scaleTestVolumes = 500
+	// scaleTestMaxAttempts bounds the retries of a CreateVolume call, which the external
+	// provisioner would otherwise retry forever.
+	scaleTestMaxAttempts = 50
+)
+
+// scaleTestMethods are the Filestore API methods whose calls are reported by the simulation.
+var scaleTestMethods = []string{
+	"ListOps",
+	"ListMultishareInstances",
+	"ListShares",
+	"GetShare",
+	"StartCreateMultishareInstanceOp",
+	"StartResizeMultishareInstanceOp",
+	"StartCreateShareOp",
+	"WaitForOpWithOpts",
+}
+
+// scaleTestResult summarizes a simulated burst of CreateVolume calls.
+type scaleTestResult struct {
+	instances int
+	retries   int64
+	calls     map[string]int
+}
+
+// runCreateVolumeBurst issues the given number of concurrent CreateVolume calls for shares
+// of the given size against the multishare fakes, retrying failed calls like the external
+// provisioner. It fails if a volume is not created, or if an instance is packed beyond its
+// share count or capacity.
+func runCreateVolumeBurst(tb testing.TB, volumes int, shareBytes int64) *scaleTestResult {
+	fakeService, err := file.NewFakeServiceForMultishare(nil, nil, nil)
+	if err != nil {
+		tb.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	faults := file.NewFaultInjector()
+	fileService := file.NewFakeServiceWithFaults(fakeService, faults)
+	cloudProvider, err := cloud.NewFakeCloudWithFiler(fileService, "test-project", "us-central1-c")
+	if err != nil {
+		tb.Fatalf("failed to get cloud provider: %v", err)
+	}
+	mcs := NewMultishareController(&controllerServerConfig{
+		driver:      initTestDriver(tb),
+		fileService: fileService,
+		cloud:       cloudProvider,
+		volumeLocks: util.NewVolumeLocks(),
+		isRegional:  true,
+		clusterName: testClusterName,
+		tagManager:  cloud.NewFakeTagManager(),
+	})
+
+	var retries int64
+	var wg sync.WaitGroup
+	errs := make([]error, volumes)
+	for i := 0; i < volumes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := &csi.CreateVolumeRequest{
+				Name: fmt.Sprintf("pvc-scale-%d", i),
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: shareBytes,
+				},
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+			}
+			for attempt := 1; attempt <= scaleTestMaxAttempts; attempt++ {
+				if _, errs[i] = mcs.CreateVolume(context.Background(), req); errs[i] == nil {
+					return
+				}
+				atomic.AddInt64(&retries, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			tb.Fatalf("volume %d not created after %d attempts: %v", i, scaleTestMaxAttempts, err)
+		}
+	}
+
+	result := &scaleTestResult{
+		retries: retries,
+		calls:   make(map[string]int),
+	}
+	for _, method := range scaleTestMethods {
+		result.calls[method] = faults.Calls(method)
+	}
+
+	// Inspect the packing through the unwrapped fake, so that the call counts are not skewed.
+	instances, err := fakeService.ListMultishareInstances(context.Background(), &file.ListFilter{Project: "test-project", Location: "-"})
+	if err != nil {
+		tb.Fatalf("failed to list instances: %v", err)
+	}
+	result.instances = len(instances)
+	totalShares := 0
+	for _, instance := range instances {
+		shares, err := fakeService.ListShares(context.Background(), &file.ListFilter{Project: instance.Project, Location: instance.Location, InstanceName: instance.Name})
+		if err != nil {
+			tb.Fatalf("failed to list shares of instance %s: %v", instance.Name, err)
+		}
+		var sumShareBytes int64
+		for _, s := range shares {
+			sumShareBytes += s.CapacityBytes
+		}
+		if len(shares) > util.MaxSharesPerInstance {
+			tb.Errorf("instance %s has %d shares, want at most %d", instance.Name, len(shares), util.MaxSharesPerInstance)
+		}
+		if sumShareBytes > instance.CapacityBytes {
+			tb.Errorf("instance %s has %d bytes of shares, exceeding its capacity of %d bytes", instance.Name, sumShareBytes, instance.CapacityBytes)
+		}
+		totalShares += len(shares)
+	}
+	if totalShares != volumes {
+		tb.Errorf("found %d shares, want %d", totalShares, volumes)
+	}
+	return result
+}
+
+func TestMultishareCreateVolumeScale(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping scale simulation in short mode")
+	}
+	// Placement is serialized by the ops manager, so an instance is only created once all
+	// the others are full, and the packing is deterministic despite the concurrency.
+	minInstances := (scaleTestVolumes + util.MaxSharesPerInstance - 1) / util.MaxSharesPerInstance
+	tests := []struct {
+		name              string
+		shareBytes        int64
+		expectedInstances int
+		expectedResizes   int
+	}{
+		{
+			name:              "shares fit in the initial instance capacity",
+			shareBytes:        100 * util.Gb,
+			expectedInstances: minInstances,
+		},
+		{
+			// 5 shares of 200Gi fill a 1Ti instance, the 6th expands it to 2Ti, which fits all 10.
+			name:              "shares expand each instance once",
+			shareBytes:        200 * util.Gb,
+			expectedInstances: minInstances,
+			expectedResizes:   minInstances,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := runCreateVolumeBurst(t, scaleTestVolumes, tc.shareBytes)
+			if result.instances != tc.expectedInstances {
+				t.Errorf("got %d instances, want %d", result.instances, tc.expectedInstances)
+			}
+			if got := result.calls["StartCreateMultishareInstanceOp"]; got != tc.expectedInstances {
+				t.Errorf("got %d instance create ops, want %d", got, tc.expectedInstances)
+			}
+			if got := result.calls["StartResizeMultishareInstanceOp"]; got != tc.expectedResizes {
+				t.Errorf("got %d instance resize ops, want %d", got, tc.expectedResizes)
+			}
+			// Every share create op either creates a volume or is retried.
+			if got := result.calls["StartCreateShareOp"]; got < scaleTestVolumes || int64(got) > scaleTestVolumes+result.retries {
+				t.Errorf("got %d share create ops for %d volumes and %d retries", got, scaleTestVolumes, result.retries)
+			}
+			for _, method := range scaleTestMethods {
+				t.Logf("%s: %d calls", method, result.calls[method])
+			}
+			t.Logf("CreateVolume retries: %d", result.retries)
+		})
+	}
+}
+
+// BenchmarkMultishareCreateVolume reports the Filestore API calls made per volume by a burst
+// of concurrent CreateVolume calls, to quantify the API QPS of placement changes.
+func BenchmarkMultishareCreateVolume(b *testing.B) {
+	for _, shareBytes := range []int64{100 * util.Gb, 200 * util.Gb} {
+		b.Run(fmt.Sprintf("share-%dGi", shareBytes/util.Gb), func(b *testing.B) {
+			calls := make(map[string]int)
+			var retries int64
+			for i := 0; i < b.N; i++ {
+				result := runCreateVolumeBurst(b, scaleTestVolumes, shareBytes)
+				for method, n := range result.calls {
+					calls[method] += n
+				}
+				retries += result.retries
+			}
+			volumes := float64(b.N * scaleTestVolumes)
+			for method, n := range calls {
+				b.ReportMetric(float64(n)/volumes, method+"/vol")
+			}
+			b.ReportMetric(float64(retries)/volumes, "retries/vol")
+		})
+	}
+}
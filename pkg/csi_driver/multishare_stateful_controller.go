@@ -18,6 +18,7 @@ package driver
 
 import (
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	pbSanitizer "github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -55,7 +56,7 @@ func NewMultishareStatefulController(config *controllerServerConfig) *Multishare
 }
 
 func (m *MultishareStatefulController) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	klog.Infof("CreateVolume called for multishare with request %+v", req)
+	klog.Infof("CreateVolume called for multishare with request %+v", pbSanitizer.StripSecrets(req))
 	pvName := req.GetName()
 	if len(pvName) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "CreateVolume name must be provided")
@@ -85,7 +86,7 @@ func (m *MultishareStatefulController) CreateVolume(ctx context.Context, req *cs
 	}
 
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
 	if !util.IsAligned(reqBytes, util.Gb) {
 		return nil, status.Errorf(codes.InvalidArgument, "requested size(bytes) %d is not a multiple of 1GiB", reqBytes)
@@ -158,7 +159,7 @@ func (m *MultishareStatefulController) DeleteVolume(ctx context.Context, req *cs
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	klog.V(4).Infof("DeleteVolume called for multishare with request %+v", req)
+	klog.V(4).Infof("DeleteVolume called for multishare with request %+v", pbSanitizer.StripSecrets(req))
 
 	siName := util.ShareToShareInfoName(shareName)
 	shareInfo, err := m.shareLister.ShareInfos(util.ManagedFilestoreCSINamespace).Get(siName)
@@ -261,16 +262,25 @@ func (m *MultishareStatefulController) ControllerExpandVolume(ctx context.Contex
 	}
 	reqBytes, err := getShareRequestCapacity(req.GetCapacityRange(), util.ConfigurablePackMinShareSizeBytes, maxShareSizeBytes)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
 	if !util.IsAligned(reqBytes, util.Gb) {
 		return nil, status.Errorf(codes.InvalidArgument, "requested size(bytes) %d is not a multiple of 1GiB", reqBytes)
 	}
-	_, _, _, _, shareName, err := parseMultishareVolId(req.VolumeId)
+	_, project, location, instanceName, shareName, err := parseMultishareVolId(req.VolumeId)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	klog.Infof("ControllerExpandVolume called for multishare with request %+v", req)
+	klog.Infof("ControllerExpandVolume called for multishare with request %+v", pbSanitizer.StripSecrets(req))
+
+	// The expansion is serialized with the backups of the instance. Its intent is kept while the
+	// reconciler expands the share.
+	release, err := m.mc.instanceIntents.acquire(ctx, intentInstanceURI(project, location, instanceName), intentExpand, volumeId)
+	if err != nil {
+		return nil, err
+	}
+	pending := false
+	defer func() { release(pending) }()
 
 	siName := util.ShareToShareInfoName(shareName)
 	shareInfo, err := m.shareLister.ShareInfos(util.ManagedFilestoreCSINamespace).Get(siName)
@@ -291,6 +301,7 @@ func (m *MultishareStatefulController) ControllerExpandVolume(ctx context.Contex
 			klog.Errorf("failed to update shareInfo %s: %s", siName, err.Error())
 			return nil, status.Errorf(codes.Internal, "error expanding volume %s due to failed internal update", siName)
 		}
+		pending = true
 		return nil, status.Errorf(codes.Aborted, "expressed intent for volume %s to be expanded", siName)
 	}
 
@@ -313,6 +324,7 @@ func (m *MultishareStatefulController) ControllerExpandVolume(ctx context.Contex
 		return nil, status.Errorf(codes.Internal, "internal error: %s", shareInfo.Status.Error)
 	}
 
+	pending = true
 	return nil, status.Errorf(codes.Aborted, "waiting for volume %s to be expanded", siName)
 }
 
@@ -0,0 +1,159 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+const (
+	// networkBackoffInitial is the first backoff of the instance creations in a network after
+	// an exhaustion error, much longer than the backoff of the external-provisioner, as the
+	// exhaustion only resolves with an intervention on the network.
+	networkBackoffInitial = 5 * time.Minute
+	networkBackoffMax     = time.Hour
+)
+
+// networkExhaustionRemediation are the remediation steps of the exhaustion reasons.
+var networkExhaustionRemediation = map[string]string{
+	file.ReasonRangesExhausted: "allocate an additional IP range to the private services access connection of the network " +
+		"(gcloud compute addresses create --global --purpose=VPC_PEERING, then gcloud services vpc-peerings update " +
+		"--service=servicenetworking.googleapis.com --ranges=<all the ranges>), set the reserved-ipv4-cidr or " +
+		"reserved-ip-range parameter of the StorageClass to a range with free space, or delete the unused Filestore instances",
+	file.ReasonPeeringLimitExceeded: "remove the unused VPC peerings of the network, request an increase of its peering " +
+		"and imported routes quotas, or provision the instances with connect-mode PRIVATE_SERVICE_ACCESS, which shares " +
+		"the single service networking peering of the network",
+}
+
+// networkBackoff backs off the instance creations in the VPC networks whose last creation
+// failed because the private services access ranges of the network are exhausted or the
+// network reached its peering limits. Retrying them at the rate of the external-provisioner
+// would only hammer the Filestore API, so they are rejected with a ResourceExhausted error
+// carrying the remediation steps, recorded as a ProvisioningFailed event on the PVC, until the
+// backoff of the network expires. The backoff doubles with every failure, up to
+// networkBackoffMax, and is reset by a successful creation.
+type networkBackoff struct {
+	metricsManager *metrics.MetricsManager
+	now            func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*networkBackoffEntry
+}
+
+type networkBackoffEntry struct {
+	reason string
+	err    string
+	delay  time.Duration
+	until  time.Time
+}
+
+func newNetworkBackoff(metricsManager *metrics.MetricsManager) *networkBackoff {
+	return &networkBackoff{
+		metricsManager: metricsManager,
+		now:            time.Now,
+		entries:        make(map[string]*networkBackoffEntry),
+	}
+}
+
+// check returns a ResourceExhausted error if the instance creations in the network of the
+// project are backed off.
+func (b *networkBackoff) check(project, network string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[networkKey(project, network)]
+	if !ok {
+		return nil
+	}
+	remaining := e.until.Sub(b.now())
+	if remaining <= 0 {
+		return nil
+	}
+	return networkExhaustionError(network, e.reason, e.err, remaining)
+}
+
+// failed backs off the network of the project if err, the error of an instance creation in
+// it, is a network exhaustion error, and returns the ResourceExhausted error with the
+// remediation steps replacing it. It returns nil for the other errors.
+func (b *networkBackoff) failed(project, network string, err error) error {
+	reason := file.NetworkExhaustionReason(err)
+	if b == nil || reason == "" {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := networkKey(project, network)
+	e, ok := b.entries[key]
+	if !ok {
+		e = &networkBackoffEntry{}
+		b.entries[key] = e
+	}
+	e.delay *= 2
+	if e.delay < networkBackoffInitial {
+		e.delay = networkBackoffInitial
+	}
+	if e.delay > networkBackoffMax {
+		e.delay = networkBackoffMax
+	}
+	e.until = b.now().Add(e.delay)
+	e.reason = reason
+	e.err = err.Error()
+	b.metricsManager.RecordNetworkExhaustionError(network, reason)
+	klog.Warningf("Instance creation in network %s failed with %s, backing off the creations in the network for %v: %v", network, reason, e.delay, err)
+	return networkExhaustionError(network, reason, e.err, e.delay)
+}
+
+// succeeded resets the backoff of the network of the project.
+func (b *networkBackoff) succeeded(project, network string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, networkKey(project, network))
+}
+
+func networkKey(project, network string) string {
+	return project + "/" + network
+}
+
+// networkExhaustionError returns the ResourceExhausted error of an exhaustion of a network,
+// with the remediation steps of the reason, its reason as ErrorInfo detail and the delay
+// after which the creations are retried as RetryInfo detail.
+func networkExhaustionError(network, reason, cause string, retryIn time.Duration) error {
+	msg := fmt.Sprintf("instance creation in VPC network %s failed with %s: %s. To fix it, %s. The instance creations in the network are backed off for %v", network, reason, cause, networkExhaustionRemediation[reason], retryIn.Round(time.Second))
+	st := status.New(codes.ResourceExhausted, msg)
+	if withDetails, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: reason, Domain: "servicenetworking.googleapis.com", Metadata: map[string]string{"network": network}},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryIn)},
+	); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestNetworkBackoff(t *testing.T) {
+	var disabled *networkBackoff
+	if err := disabled.check(testProject, defaultNetwork); err != nil {
+		t.Fatalf("unexpected error of a disabled backoff: %v", err)
+	}
+
+	now := time.Now()
+	b := newNetworkBackoff(nil)
+	b.now = func() time.Time { return now }
+	exhausted := errors.New("operation failed: RANGES_EXHAUSTED: no available IP ranges of size /29")
+
+	if err := b.failed(testProject, defaultNetwork, errors.New("internal error")); err != nil {
+		t.Fatalf("got error %v for a non exhaustion error, expected nil", err)
+	}
+	if err := b.check(testProject, defaultNetwork); err != nil {
+		t.Fatalf("network backed off after a non exhaustion error: %v", err)
+	}
+
+	for i, expectedDelay := range []time.Duration{5 * time.Minute, 10 * time.Minute, 20 * time.Minute, 40 * time.Minute, time.Hour, time.Hour} {
+		err := b.failed(testProject, defaultNetwork, exhausted)
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("failure %d: got error %v, expected code %v", i, err, codes.ResourceExhausted)
+		}
+		var retryInfo *errdetails.RetryInfo
+		for _, detail := range status.Convert(err).Details() {
+			if d, ok := detail.(*errdetails.RetryInfo); ok {
+				retryInfo = d
+			}
+		}
+		if retryInfo == nil || retryInfo.RetryDelay.AsDuration() != expectedDelay {
+			t.Fatalf("failure %d: got retry info %v, expected a delay of %v", i, retryInfo, expectedDelay)
+		}
+	}
+	err := b.check(testProject, defaultNetwork)
+	if status.Code(err) != codes.ResourceExhausted || !strings.Contains(err.Error(), "allocate an additional IP range") {
+		t.Fatalf("got error %v, expected the remediation steps of %s", err, file.ReasonRangesExhausted)
+	}
+	if err := b.check(testProject, "other-network"); err != nil {
+		t.Fatalf("unexpected error of another network: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := b.check(testProject, defaultNetwork); err != nil {
+		t.Fatalf("unexpected error after the backoff expired: %v", err)
+	}
+	b.succeeded(testProject, defaultNetwork)
+	if err := b.failed(testProject, defaultNetwork, exhausted); !strings.Contains(err.Error(), "backed off for 5m0s") {
+		t.Fatalf("got error %v, expected the backoff to be reset by a success", err)
+	}
+}
+
+func TestCreateVolumeNetworkExhaustion(t *testing.T) {
+	faults := file.NewFaultInjector()
+	faults.Inject("CreateInstance", file.Fault{OnCall: 1, Err: &googleapi.Error{
+		Code:    http.StatusBadRequest,
+		Message: "Cannot find an available IP range of size /29 in the allocated ranges of network default",
+	}})
+	fakeService, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	fileService := file.NewFakeServiceWithFaults(fakeService, faults)
+	cs := initTestController(t).(*controllerServer)
+	cs.config.fileService = fileService
+	cs.config.cloud.File = fileService
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	now := time.Now()
+	cs.config.networkBackoff = newNetworkBackoff(nil)
+	cs.config.networkBackoff.now = func() time.Time { return now }
+	req := &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	}
+
+	if _, err := cs.CreateVolume(context.Background(), req); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got error %v, expected code %v", err, codes.ResourceExhausted)
+	}
+	// The retries of the provisioner are rejected without calling the Filestore API.
+	if _, err := cs.CreateVolume(context.Background(), req); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got error %v, expected code %v", err, codes.ResourceExhausted)
+	}
+	if calls := faults.Calls("CreateInstance"); calls != 1 {
+		t.Fatalf("got %d instance creations, expected 1 while backed off", calls)
+	}
+
+	now = now.Add(networkBackoffInitial)
+	if _, err := cs.CreateVolume(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error after the backoff expired: %v", err)
+	}
+	if _, ok := cs.config.networkBackoff.entries[networkKey(testProject, defaultNetwork)]; ok {
+		t.Errorf("backoff of network %s not reset by a successful creation", defaultNetwork)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/compute"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const (
+	nfsFirewallRulePrefix = "filestore-csi-nfs-"
+	// maxFirewallRuleNameLength is the maximum length of the name of a firewall rule.
+	maxFirewallRuleNameLength = 63
+)
+
+// nfsPorts are the ports of the NFS traffic between the clients and the Filestore instances:
+// rpcbind, statd, nfsd, mountd and the lock manager.
+var nfsPorts = []string{"111", "2046", "2049", "2050", "4045"}
+
+// nfsFirewall creates the firewall rule of the NFS traffic between the nodes and the
+// Filestore instances of a VPC network at the first provisioning in the network, and adds
+// the reserved range of the new instances to it. New VPC networks routinely miss the rule,
+// which makes the mounts time out without a clear cause. The rules already verified are
+// cached, so that the Compute API is only called once per network and range.
+type nfsFirewall struct {
+	compute compute.Service
+	project string
+	// nodeCIDRs are the destination ranges of the rules, all the instances of the network if
+	// empty.
+	nodeCIDRs []string
+
+	mu       sync.Mutex
+	verified map[string]bool
+}
+
+// newNFSFirewall returns the firewall rule manager of the project, nil if the Compute client
+// is not available.
+func newNFSFirewall(computeService compute.Service, project string, nodeCIDRs []string) *nfsFirewall {
+	if computeService == nil {
+		klog.Warningf("The Compute client is not available, the NFS firewall rules are not created")
+		return nil
+	}
+	return &nfsFirewall{
+		compute:   computeService,
+		project:   project,
+		nodeCIDRs: nodeCIDRs,
+		verified:  make(map[string]bool),
+	}
+}
+
+// ensure creates or updates the firewall rule of the network of the instance to allow the NFS
+// traffic of its reserved range. Failures are logged, the instance remains usable if the
+// network already allows the traffic.
+func (f *nfsFirewall) ensure(ctx context.Context, network file.Network) {
+	if f == nil || network.Name == "" {
+		return
+	}
+	sourceRange := nfsSourceRange(network)
+	if sourceRange == "" {
+		return
+	}
+	key := network.Name + "/" + sourceRange
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.verified[key] {
+		return
+	}
+	if err := f.ensureRule(ctx, network.Name, sourceRange); err != nil {
+		klog.Warningf("Failed to allow the NFS traffic of range %s in network %s, mounts may time out if the network firewall blocks it: %v", sourceRange, network.Name, err)
+		return
+	}
+	f.verified[key] = true
+}
+
+func (f *nfsFirewall) ensureRule(ctx context.Context, network, sourceRange string) error {
+	name := nfsFirewallRuleName(network)
+	rule, err := f.compute.GetFirewallRule(ctx, f.project, name)
+	if err != nil {
+		if !compute.IsNotFoundErr(err) {
+			return err
+		}
+		klog.Infof("Creating firewall rule %s allowing the NFS traffic of range %s in network %s", name, sourceRange, network)
+		return f.compute.CreateFirewallRule(ctx, f.project, &compute.FirewallRule{
+			Name:              name,
+			Description:       fmt.Sprintf("Allows the NFS traffic of the Filestore instances of network %s, managed by the Filestore CSI driver", network),
+			Network:           networkURL(network),
+			SourceRanges:      []string{sourceRange},
+			DestinationRanges: f.nodeCIDRs,
+			Ports:             nfsPorts,
+		})
+	}
+	for _, r := range rule.SourceRanges {
+		if r == sourceRange {
+			return nil
+		}
+	}
+	klog.Infof("Adding range %s to firewall rule %s of network %s", sourceRange, name, network)
+	return f.compute.UpdateFirewallRuleSourceRanges(ctx, f.project, name, append(rule.SourceRanges, sourceRange))
+}
+
+// ParseNFSFirewallNodeCIDRs parses the comma separated node ranges of the NFS firewall rules.
+func ParseNFSFirewallNodeCIDRs(s string) ([]string, error) {
+	var cidrs []string
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !IsCIDR(cidr) {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// nfsSourceRange returns the range the NFS traffic of an instance originates from: its reserved
+// range if a CIDR, its IP otherwise, e.g. for a named private services access range.
+func nfsSourceRange(network file.Network) string {
+	if IsCIDR(network.ReservedIpRange) {
+		return network.ReservedIpRange
+	}
+	if ip := net.ParseIP(network.Ip); ip != nil && ip.To4() != nil {
+		return network.Ip + "/32"
+	}
+	return ""
+}
+
+// nfsFirewallRuleName returns the name of the firewall rule of a network.
+func nfsFirewallRuleName(network string) string {
+	name := nfsFirewallRulePrefix + strings.ToLower(path.Base(network))
+	if len(name) > maxFirewallRuleNameLength {
+		name = strings.TrimRight(name[:maxFirewallRuleNameLength], "-")
+	}
+	return name
+}
+
+// networkURL returns the URL of a network given by name or URL.
+func networkURL(network string) string {
+	if strings.Contains(network, "/") {
+		return network
+	}
+	return "global/networks/" + network
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/compute"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestNFSFirewallEnsure(t *testing.T) {
+	var disabled *nfsFirewall
+	disabled.ensure(context.Background(), file.Network{Name: "default", ReservedIpRange: "10.0.0.0/29"})
+
+	fakeCompute := compute.NewFakeService(nil)
+	f := newNFSFirewall(fakeCompute, testProject, []string{"10.128.0.0/20"})
+	ruleName := nfsFirewallRuleName("default")
+
+	f.ensure(context.Background(), file.Network{Name: "default", ReservedIpRange: "10.0.0.0/29"})
+	rule, ok := fakeCompute.Rules[ruleName]
+	if !ok {
+		t.Fatalf("firewall rule %s not created", ruleName)
+	}
+	expected := &compute.FirewallRule{
+		Name:              ruleName,
+		Description:       rule.Description,
+		Network:           "global/networks/default",
+		SourceRanges:      []string{"10.0.0.0/29"},
+		DestinationRanges: []string{"10.128.0.0/20"},
+		Ports:             nfsPorts,
+	}
+	if !reflect.DeepEqual(rule, expected) {
+		t.Errorf("got firewall rule %+v, expected %+v", rule, expected)
+	}
+
+	// The range of an instance of a named private services access range is its IP.
+	f.ensure(context.Background(), file.Network{Name: "default", ReservedIpRange: "psa-range", Ip: "10.1.0.2"})
+	if sourceRanges := fakeCompute.Rules[ruleName].SourceRanges; !reflect.DeepEqual(sourceRanges, []string{"10.0.0.0/29", "10.1.0.2/32"}) {
+		t.Errorf("got source ranges %v, expected the IP of the instance to be added", sourceRanges)
+	}
+
+	// The verified ranges are not looked up again, the failures are retried.
+	fakeCompute.Err = errors.New("permission denied")
+	f.ensure(context.Background(), file.Network{Name: "default", ReservedIpRange: "10.0.0.0/29"})
+	f.ensure(context.Background(), file.Network{Name: "default", ReservedIpRange: "10.0.0.8/29"})
+	fakeCompute.Err = nil
+	f.ensure(context.Background(), file.Network{Name: "default", ReservedIpRange: "10.0.0.8/29"})
+	if sourceRanges := fakeCompute.Rules[ruleName].SourceRanges; len(sourceRanges) != 3 {
+		t.Errorf("got source ranges %v, expected the failed range to be added on retry", sourceRanges)
+	}
+}
+
+func TestNFSFirewallRuleName(t *testing.T) {
+	cases := map[string]string{
+		"default": "filestore-csi-nfs-default",
+		"projects/host-project/global/networks/Shared-VPC": "filestore-csi-nfs-shared-vpc",
+		strings.Repeat("n", 60):                            "filestore-csi-nfs-" + strings.Repeat("n", 45),
+	}
+	for network, expected := range cases {
+		if name := nfsFirewallRuleName(network); name != expected {
+			t.Errorf("%s: got rule name %q, expected %q", network, name, expected)
+		}
+	}
+}
+
+func TestParseNFSFirewallNodeCIDRs(t *testing.T) {
+	cidrs, err := ParseNFSFirewallNodeCIDRs("10.128.0.0/20, 10.4.0.0/14,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cidrs, []string{"10.128.0.0/20", "10.4.0.0/14"}) {
+		t.Errorf("got CIDRs %v", cidrs)
+	}
+	if _, err := ParseNFSFirewallNodeCIDRs("10.128.0.0"); err == nil {
+		t.Errorf("expected error for an IP")
+	}
+}
+
+func TestCreateVolumeNFSFirewall(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	fakeCompute := compute.NewFakeService(nil)
+	cs.config.nfsFirewall = newNFSFirewall(fakeCompute, testProject, nil)
+
+	_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rule, ok := fakeCompute.Rules[nfsFirewallRuleName(defaultNetwork)]
+	if !ok {
+		t.Fatalf("firewall rule of network %s not created, got rules %v", defaultNetwork, fakeCompute.Rules)
+	}
+	if len(rule.SourceRanges) != 1 {
+		t.Errorf("got source ranges %v, expected the range of the instance", rule.SourceRanges)
+	}
+}
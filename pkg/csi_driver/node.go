@@ -17,10 +17,13 @@ limitations under the License.
 package driver
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,8 +32,7 @@ import (
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
@@ -44,6 +46,27 @@ const (
 	optionSmbPassword = "smbPassword"
 )
 
+var (
+	// knownVolumeAttributes are the volume attributes set by the controller or documented for
+	// pre-provisioned volumes.
+	knownVolumeAttributes = map[string]bool{
+		attrIP:                 true,
+		attrVolume:             true,
+		attrSupportLockRelease: true,
+		attrTier:               true,
+		attrSubdir:             true,
+		attrContextVersion:     true,
+		attrMaxShareSize:       true,
+		attrMountPolicy:        true,
+		attrSoftMountTimeo:     true,
+		attrHostname:           true,
+		attrProtocol:           true,
+		attrAuthFlavor:         true,
+	}
+	// Prefixes of the volume attributes added by the Kubernetes sidecars and kubelet.
+	kubernetesVolumeAttributePrefixes = []string{"csi.storage.k8s.io/", "storage.kubernetes.io/"}
+)
+
 var (
 	// For testing purposes
 	goOs = runtime.GOOS
@@ -56,6 +79,13 @@ type nodeServer struct {
 	metaService           metadata.Service
 	volumeLocks           *util.VolumeLocks
 	lockReleaseController *lockrelease.LockReleaseController
+	mountHealthReporter   *mountHealthReporter
+	tierAnalyzer          *tierAnalyzer
+	nfsStatsCollector     *nfsStatsCollector
+	capacityWatermark     *capacityWatermark
+	instanceIPResolver    *instanceIPResolver
+	mountLimiter          *mountLimiter
+	mountOptions          *mountOptionProber
 	features              *GCFSDriverFeatureOptions
 }
 
@@ -67,20 +97,36 @@ func newNodeServer(driver *GCFSDriver, mounter mount.Interface, metaService meta
 		volumeLocks: util.NewVolumeLocks(),
 		features:    featureOptions,
 	}
+	ns.mountLimiter = newMountLimiter(driver.config.MaxConcurrentMounts, driver.config.MountQueueTimeout)
+	ns.mountOptions = newMountOptionProber(runtime.GOARCH)
 	if ns.features.FeatureLockRelease.Enabled {
-		config, err := rest.InClusterConfig()
-		if err != nil {
-			return nil, err
-		}
-		client, err := kubernetes.NewForConfig(config)
+		lc, err := lockrelease.NewLockReleaseController(driver.config.KubeClient, ns.features.FeatureLockRelease.Config)
 		if err != nil {
 			return nil, err
 		}
-		lc, err := lockrelease.NewLockReleaseController(client, ns.features.FeatureLockRelease.Config)
+		ns.lockReleaseController = lc
+	}
+	if ns.features.FeatureMountHealth != nil && ns.features.FeatureMountHealth.Enabled {
+		ns.mountHealthReporter = newMountHealthReporter(ns.features.FeatureMountHealth, driver.config.Metrics)
+	}
+	if ns.features.FeatureTierRecommendations != nil && ns.features.FeatureTierRecommendations.Enabled {
+		ns.tierAnalyzer = newTierAnalyzer(ns.features.FeatureTierRecommendations, driver.config.NodeName, driver.config.Name, driver.config.KubeClient, driver.config.Metrics)
+	}
+	if ns.features.FeatureNFSStats != nil && ns.features.FeatureNFSStats.Enabled {
+		ns.nfsStatsCollector = newNFSStatsCollector(ns.features.FeatureNFSStats, driver.config.Metrics)
+	}
+	if ns.features.FeatureCapacityWatermark != nil && ns.features.FeatureCapacityWatermark.Enabled {
+		ns.capacityWatermark = newCapacityWatermark(ns.features.FeatureCapacityWatermark, driver.config.NodeName, driver.config.Name, driver.config.KubeClient, driver.config.EventRecorder, driver.config.Metrics)
+	}
+	if ns.features.FeatureInstanceIPRefresh != nil && ns.features.FeatureInstanceIPRefresh.Enabled {
+		ns.instanceIPResolver = newInstanceIPResolver(ns.features.FeatureInstanceIPRefresh, metaService.GetProject(), driver.config.KubeClient)
+	}
+	if ns.mountHealthReporter != nil {
+		staged, err := stagedVolumes(mounter, driver.config.Name)
 		if err != nil {
-			return nil, err
+			klog.Warningf("Failed to find the volumes staged before the driver started, their mounts are probed after their next NodeStageVolume: %v", err)
 		}
-		ns.lockReleaseController = lc
+		ns.mountHealthReporter.seed(staged)
 	}
 	return ns, nil
 }
@@ -88,7 +134,7 @@ func newNodeServer(driver *GCFSDriver, mounter mount.Interface, metaService meta
 // NodePublishVolume bind mounts from the source staging path, where the GCFS volume is mounted.
 func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	// Validate arguments
-	readOnly := req.GetReadonly()
+	readOnly := req.GetReadonly() || readerOnlyAccessModes[req.GetVolumeCapability().GetAccessMode().GetMode()]
 	targetPath := req.GetTargetPath()
 	stagingTargetPath := req.GetStagingTargetPath()
 	if len(targetPath) == 0 {
@@ -102,6 +148,11 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	// The volume is mounted from the staging path, but a PV edited since staging must not be published.
+	if err := validateVolumeContext(req.GetVolumeId(), req.GetVolumeContext()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	// Acquire a lock on the target path instead of volumeID, since we do not want to serialize multiple node publish calls on the same volume.
 	if acquired := s.volumeLocks.TryAcquire(targetPath); !acquired {
 		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, targetPath)
@@ -139,7 +190,22 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 			return nil, err
 		}
 		if mounted {
-			return &csi.NodePublishVolumeResponse{}, nil
+			mountedReadOnly, err := s.isMountedReadOnly(targetPath)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to check the mount options of %s: %v", targetPath, err)
+			}
+			switch {
+			case readOnly == mountedReadOnly:
+				return &csi.NodePublishVolumeResponse{}, nil
+			case mountedReadOnly:
+				return nil, status.Errorf(codes.AlreadyExists, "volume %s is published read-only at %s, not read-write", req.GetVolumeId(), targetPath)
+			}
+			// A read-write bind mount may be left by a publish whose read-only remount failed,
+			// it is replaced rather than silently granting write access.
+			klog.Warningf("Volume %s is published read-write at %s, publishing it read-only", req.GetVolumeId(), targetPath)
+			if err := s.mounter.Unmount(targetPath); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to unmount read-write mount %s: %v", targetPath, err)
+			}
 		}
 		if os.IsNotExist(err) {
 			if mkdirErr := os.MkdirAll(targetPath, 0750); mkdirErr != nil {
@@ -157,8 +223,10 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 
 	err = s.mounter.Mount(stagingTargetPath, targetPath, fstype, options)
 	if err != nil {
+		// The read-only bind mounts are remounted read-only after the bind mount, which is left
+		// read-write if the remount fails.
 		klog.Errorf("Mount %q failed, cleaning up", targetPath)
-		if unmntErr := mount.CleanupMountPoint(stagingTargetPath, s.mounter, false /* extensiveMountPointCheck */); unmntErr != nil {
+		if unmntErr := mount.CleanupMountPoint(targetPath, s.mounter, false /* extensiveMountPointCheck */); unmntErr != nil {
 			klog.Errorf("Unmount %q failed: %v", targetPath, unmntErr.Error())
 		}
 
@@ -169,6 +237,35 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// readerOnlyAccessModes are the access modes whose volumes are published read-only, whatever
+// the readonly flag of the NodePublishVolume request.
+var readerOnlyAccessModes = map[csi.VolumeCapability_AccessMode_Mode]bool{
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY: true,
+	csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:  true,
+}
+
+// isMountedReadOnly returns whether the last mount at path, which must be a mount point, is
+// read-only.
+func (s *nodeServer) isMountedReadOnly(path string) (bool, error) {
+	mps, err := s.mounter.List()
+	if err != nil {
+		return false, err
+	}
+	readOnly := false
+	for _, mp := range mps {
+		if mp.Path != path {
+			continue
+		}
+		readOnly = false
+		for _, opt := range mp.Opts {
+			if opt == "ro" {
+				readOnly = true
+			}
+		}
+	}
+	return readOnly, nil
+}
+
 // NodeUnpublishVolume unmounts the GCFS volume
 func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	// Validate arguments
@@ -267,20 +364,34 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 	// Validate volume attributes
 	var source string
 	attr := req.GetVolumeContext()
+	if err := validateVolumeContext(volumeID, attr); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	policyOptions, err := mountPolicyOptions(attr, []*csi.VolumeCapability{volumeCapability}, s.driver.config.AllowSoftMounts)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", volumeID, err)
+	}
+	authOptions, err := authFlavorOptions(attr, volumeCapability)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", volumeID, err)
+	}
+	protocolMounter, err := mounterForProtocol(attr)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", volumeID, err)
+	}
+	ip := attr[attrIP]
+	if s.instanceIPResolver != nil {
+		ip = s.instanceIPResolver.resolve(ctx, volumeID, ip)
+	}
+	host := mountHost(ctx, volumeID, attr[attrHostname], ip)
 	if isMultishareVolId(volumeID) {
-		if err := validateMultishareVolumeAttributes(attr); err != nil {
-			return nil, status.Error(codes.InvalidArgument, err.Error())
-		}
 		_, _, _, _, shareName, err := parseMultishareVolId(volumeID)
 		if err != nil {
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
-		source = fmt.Sprintf("%s:/%s", attr[attrIP], shareName)
+		source = protocolMounter.mountSource(host, shareName, attr[attrSubdir])
 	} else {
-		if err := validateVolumeAttributes(attr); err != nil {
-			return nil, status.Error(codes.InvalidArgument, err.Error())
-		}
-		source = fmt.Sprintf("%s:/%s", attr[attrIP], attr[attrVolume])
+		source = protocolMounter.mountSource(host, attr[attrVolume], attr[attrSubdir])
 	}
 
 	if acquired := s.volumeLocks.TryAcquire(volumeID); !acquired {
@@ -306,6 +417,18 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 				return nil, status.Errorf(codes.Internal, "failed to store lock info after NodeStageVolume succeeded on volume %v to path %s: %v", volumeID, stagingTargetPath, err.Error())
 			}
 		}
+		if s.mountHealthReporter != nil {
+			s.mountHealthReporter.track(volumeID, stagingTargetPath)
+		}
+		if s.tierAnalyzer != nil {
+			s.tierAnalyzer.track(volumeID, stagingTargetPath, volumeTier(volumeID, attr))
+		}
+		if s.nfsStatsCollector != nil {
+			s.nfsStatsCollector.track(volumeID, stagingTargetPath)
+		}
+		if s.capacityWatermark != nil {
+			s.capacityWatermark.track(volumeID, stagingTargetPath)
+		}
 		klog.V(4).Infof("NodeStageVolume succeeded on volume %v to staging target path %s, mount already exists.", volumeID, stagingTargetPath)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
@@ -317,16 +440,39 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 		}
 	}
 
-	fstype := "nfs"
+	fstype := protocolMounter.fsType()
 	options := []string{}
 	if mnt := volumeCapability.GetMount(); mnt != nil {
 		for _, flag := range mnt.MountFlags {
 			options = append(options, flag)
 		}
 	}
+	options = append(options, policyOptions...)
+	options = append(options, authOptions...)
+	if len(authOptions) > 0 {
+		if err := writeKeytab(req.GetSecrets(), s.driver.config.KerberosKeytabPath); err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s: %v", volumeID, err)
+		}
+	}
+	options, dropped := s.mountOptions.filter(options)
+	if len(dropped) > 0 {
+		klog.Warningf("Dropping mount options %v of volume %s, not supported by the NFS client of the %s node", dropped, volumeID, runtime.GOARCH)
+	}
 
+	if err := s.mountLimiter.acquire(ctx, volumeID); err != nil {
+		return nil, err
+	}
 	err = s.mounter.Mount(source, stagingTargetPath, fstype, options)
+	if retryOptions, retry := s.mountOptions.retryWithout(options, err); retry {
+		klog.Warningf("Mount of volume %s failed with options %v, retrying without the options not supported by the NFS client of the %s node: %v", volumeID, options, runtime.GOARCH, err)
+		err = s.mounter.Mount(source, stagingTargetPath, fstype, retryOptions)
+	}
+	s.mountLimiter.release()
 	if err != nil {
+		var unsupportedErr *unsupportedNodeOSError
+		if errors.As(err, &unsupportedErr) {
+			return nil, status.Errorf(codes.FailedPrecondition, "mount %q failed: %v", stagingTargetPath, err)
+		}
 		klog.Errorf("Mount %q failed, cleaning up", stagingTargetPath)
 		if unmntErr := mount.CleanupMountPoint(stagingTargetPath, s.mounter, false /* extensiveMountPointCheck */); unmntErr != nil {
 			klog.Errorf("Unmount %q failed: %v", stagingTargetPath, unmntErr.Error())
@@ -341,6 +487,19 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 		}
 	}
 
+	if s.mountHealthReporter != nil {
+		s.mountHealthReporter.track(volumeID, stagingTargetPath)
+	}
+	if s.tierAnalyzer != nil {
+		s.tierAnalyzer.track(volumeID, stagingTargetPath, volumeTier(volumeID, attr))
+	}
+	if s.nfsStatsCollector != nil {
+		s.nfsStatsCollector.track(volumeID, stagingTargetPath)
+	}
+	if s.capacityWatermark != nil {
+		s.capacityWatermark.track(volumeID, stagingTargetPath)
+	}
+
 	klog.V(4).Infof("NodeStageVolume succeeded on volume %v to path %s", volumeID, stagingTargetPath)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
@@ -365,6 +524,19 @@ func (s *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstage
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if s.mountHealthReporter != nil {
+		s.mountHealthReporter.untrack(volumeID)
+	}
+	if s.tierAnalyzer != nil {
+		s.tierAnalyzer.untrack(volumeID)
+	}
+	if s.nfsStatsCollector != nil {
+		s.nfsStatsCollector.untrack(volumeID)
+	}
+	if s.capacityWatermark != nil {
+		s.capacityWatermark.untrack(volumeID)
+	}
+
 	if s.features.FeatureLockRelease.Enabled {
 		klog.V(4).Infof("NodeUnstageVolume succeeded on volume %v from staging target path %s, proceed to lock info configmap updates", volumeID, stagingTargetPath)
 		if err := s.nodeUnstageVolumeUpdateLockInfo(ctx, req); err != nil {
@@ -414,18 +586,84 @@ func validateAccessMode(am *csi.VolumeCapability_AccessMode) error {
 	return nil
 }
 
-// validateVolumeAttributes checks for all the necessary fields for mounting the volume
-func validateVolumeAttributes(attr map[string]string) error {
+// volumeTier returns the tier of a volume, empty if unknown. Multishare volumes are always
+// of the enterprise tier, the tier of the other volumes is passed in the volume context.
+func volumeTier(volumeID string, attr map[string]string) string {
+	if isMultishareVolId(volumeID) {
+		return enterpriseTier
+	}
+	return attr[attrTier]
+}
+
+// validateVolumeContext checks the volume attributes of volumeID, so that a PV corrupted or
+// edited by hand fails with the faulty attribute instead of a mount error.
+func validateVolumeContext(volumeID string, attr map[string]string) error {
+	if v, ok := attr[attrContextVersion]; ok {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			return fmt.Errorf("volume %s: invalid volume attribute %v %q, must be a positive integer", volumeID, attrContextVersion, v)
+		}
+		if version > volumeContextVersion {
+			return fmt.Errorf("volume %s: volume attribute %v %d is not supported by this driver version, which supports up to %d", volumeID, attrContextVersion, version, volumeContextVersion)
+		}
+	}
+	if v, ok := attr[attrHostname]; ok {
+		if errs := validation.IsDNS1123Subdomain(v); len(errs) > 0 {
+			return fmt.Errorf("volume %s: invalid volume attribute %v %q, must be a DNS name: %v", volumeID, attrHostname, v, strings.Join(errs, ", "))
+		}
+	}
+	for k := range attr {
+		if !knownVolumeAttributes[k] && !isKubernetesVolumeAttribute(k) {
+			klog.Warningf("Ignoring unknown volume attribute %q of volume %s", k, volumeID)
+		}
+	}
+	if v, ok := attr[attrSupportLockRelease]; ok {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("volume %s: invalid volume attribute %v %q, must be a boolean", volumeID, attrSupportLockRelease, v)
+		}
+	}
+
+	var err error
+	if isMultishareVolId(volumeID) {
+		err = validateMultishareVolumeAttributes(attr)
+	} else {
+		err = validateVolumeAttributes(attr)
+	}
+	if err != nil {
+		return fmt.Errorf("volume %s: %w", volumeID, err)
+	}
+	return nil
+}
+
+func isKubernetesVolumeAttribute(key string) bool {
+	for _, prefix := range kubernetesVolumeAttributePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateInstanceIP checks that the ip volume attribute is set to an IPv4 address, the only
+// addresses of Filestore instances.
+func validateInstanceIP(attr map[string]string) error {
 	instanceip, ok := attr[attrIP]
 	if !ok {
 		return fmt.Errorf("volume attribute key %v not set", attrIP)
 	}
-	// Check for valid IPV4 address.
-	if net.ParseIP(instanceip) == nil {
-		return fmt.Errorf("invalid IP address %v in volume attributes", instanceip)
+	if ip := net.ParseIP(instanceip); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid IPv4 address %q in volume attribute %v", instanceip, attrIP)
 	}
+	return nil
+}
 
-	_, ok = attr[attrVolume]
+// validateVolumeAttributes checks for all the necessary fields for mounting the volume
+func validateVolumeAttributes(attr map[string]string) error {
+	if err := validateInstanceIP(attr); err != nil {
+		return err
+	}
+
+	_, ok := attr[attrVolume]
 	if !ok {
 		return fmt.Errorf("volume attribute key %v not set", attrVolume)
 	}
@@ -433,7 +671,7 @@ func validateVolumeAttributes(attr map[string]string) error {
 	if attr[attrVolume] == "" {
 		return fmt.Errorf("volume attribute %v not set", attrVolume)
 	}
-	return nil
+	return validateSubdir(attr)
 }
 
 func validateSmbNodePublishSecrets(secrets map[string]string) error {
@@ -464,17 +702,44 @@ func (s *nodeServer) isDirMounted(targetPath string) (bool, error) {
 }
 
 func validateMultishareVolumeAttributes(attr map[string]string) error {
-	instanceip, ok := attr[attrIP]
+	if err := validateInstanceIP(attr); err != nil {
+		return err
+	}
+	if v, ok := attr[attrMaxShareSize]; ok {
+		if size, err := strconv.ParseInt(v, 10, 64); err != nil || size <= 0 {
+			return fmt.Errorf("invalid volume attribute %v %q, must be a positive number of bytes", attrMaxShareSize, v)
+		}
+	}
+	return validateSubdir(attr)
+}
+
+// validateSubdir checks that the optional subdir volume attribute stays within the share.
+func validateSubdir(attr map[string]string) error {
+	subdir, ok := attr[attrSubdir]
 	if !ok {
-		return fmt.Errorf("volume attribute key %v not set", attrIP)
+		return nil
+	}
+	if subdir == "" {
+		return fmt.Errorf("volume attribute %v must not be empty if set", attrSubdir)
+	}
+	if path.IsAbs(subdir) {
+		return fmt.Errorf("volume attribute %v %q must be a path relative to the share", attrSubdir, subdir)
 	}
-	// Check for valid IPV4 address.
-	if net.ParseIP(instanceip) == nil {
-		return fmt.Errorf("invalid IP address %v in volume attributes", instanceip)
+	cleaned := path.Clean(subdir)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("volume attribute %v %q must point to a directory inside the share", attrSubdir, subdir)
 	}
 	return nil
 }
 
+// nfsMountSource returns the NFS source <host>:/<export>[/<subdir>] to mount, host being an IP or a DNS name.
+func nfsMountSource(host, export, subdir string) string {
+	if subdir == "" {
+		return fmt.Sprintf("%s:/%s", host, export)
+	}
+	return fmt.Sprintf("%s:/%s/%s", host, export, path.Clean(subdir))
+}
+
 func getFSStat(path string) (available, capacity, used, inodesFree, inodes, inodesUsed int64, err error) {
 	statfs := &unix.Statfs_t{}
 	err = unix.Statfs(path, statfs)
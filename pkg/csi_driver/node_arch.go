@@ -0,0 +1,170 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// kernelReleasePath is the release of the running kernel.
+var kernelReleasePath = "/proc/sys/kernel/osrelease"
+
+// kernelVersion is the major and minor version of a Linux kernel.
+type kernelVersion struct {
+	major, minor int
+}
+
+func (v kernelVersion) atLeast(o kernelVersion) bool {
+	return v.major > o.major || (v.major == o.major && v.minor >= o.minor)
+}
+
+var kernelReleasePattern = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+// parseKernelRelease returns the version of a kernel release, e.g. 6.1.75+ or
+// 5.15.0-1057-gke, false if it can't be parsed.
+func parseKernelRelease(release string) (kernelVersion, bool) {
+	m := kernelReleasePattern.FindStringSubmatch(strings.TrimSpace(release))
+	if m == nil {
+		return kernelVersion{}, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return kernelVersion{major: major, minor: minor}, true
+}
+
+// archMountOptions are the NFS mount options whose support depends on the architecture and
+// kernel of the node, keyed by option name, with the minimum kernel version supporting them
+// per architecture. The option is assumed supported on the other architectures, until a
+// mount fails with it. nconnect shipped in 5.3, but not every arm64 kernel build enables it,
+// which the probing on mount detects.
+var archMountOptions = map[string]map[string]kernelVersion{
+	"nconnect": {
+		"amd64": {major: 5, minor: 3},
+		"arm64": {major: 5, minor: 3},
+	},
+}
+
+// invalidMountOptionPattern matches the errors of mount.nfs and of the kernel rejecting an
+// option of a mount.
+var invalidMountOptionPattern = regexp.MustCompile(`(?i)incorrect mount option|unknown mount option|invalid argument|bad option`)
+
+// mountOptionProber drops the NFS mount options the NFS client of the node doesn't support,
+// instead of failing the mounts using them, e.g. the nconnect option of the StorageClasses
+// shared by amd64 and arm64 node pools. The options are dropped if the kernel of the node is
+// known not to support them on its architecture, or once a mount using them failed with an
+// invalid option error, after which the mount is retried without them.
+type mountOptionProber struct {
+	arch   string
+	kernel kernelVersion
+	// kernelKnown is false if the kernel release couldn't be read, the options are then only
+	// dropped after a failed mount.
+	kernelKnown bool
+
+	mu sync.Mutex
+	// unsupported are the names of the options probed unsupported by failed mounts.
+	unsupported map[string]bool
+}
+
+// newMountOptionProber returns the prober of the node of the given architecture, reading
+// the release of its kernel.
+func newMountOptionProber(arch string) *mountOptionProber {
+	release, err := os.ReadFile(kernelReleasePath)
+	if err != nil {
+		klog.Warningf("Failed to read the kernel release of the node, the NFS mount options are probed on mount: %v", err)
+	}
+	p := newMountOptionProberForKernel(arch, string(release))
+	klog.Infof("Node architecture %s, kernel %s", arch, strings.TrimSpace(string(release)))
+	return p
+}
+
+func newMountOptionProberForKernel(arch, release string) *mountOptionProber {
+	p := &mountOptionProber{arch: arch, unsupported: make(map[string]bool)}
+	p.kernel, p.kernelKnown = parseKernelRelease(release)
+	return p
+}
+
+// supported returns false if the node is known not to support the mount option name.
+func (p *mountOptionProber) supported(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.unsupported[name] {
+		return false
+	}
+	minKernel, ok := archMountOptions[name][p.arch]
+	return !ok || !p.kernelKnown || p.kernel.atLeast(minKernel)
+}
+
+// filter returns the options without those the node is known not to support, and the
+// dropped options.
+func (p *mountOptionProber) filter(options []string) (kept, dropped []string) {
+	if p == nil {
+		return options, nil
+	}
+	for _, o := range splitMountOptions(options) {
+		if _, ok := archMountOptions[mountOptionName(o)]; ok && !p.supported(mountOptionName(o)) {
+			dropped = append(dropped, o)
+			continue
+		}
+		kept = append(kept, o)
+	}
+	return kept, dropped
+}
+
+// retryWithout returns the options of a mount which failed with err without the
+// architecture dependent options, which are recorded unsupported, and true if the mount
+// should be retried with them, i.e. if err is an invalid option error of a mount using such
+// options.
+func (p *mountOptionProber) retryWithout(options []string, err error) ([]string, bool) {
+	if p == nil || err == nil || !invalidMountOptionPattern.MatchString(err.Error()) {
+		return nil, false
+	}
+	var kept []string
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	retry := false
+	for _, o := range splitMountOptions(options) {
+		name := mountOptionName(o)
+		if _, ok := archMountOptions[name]; ok {
+			p.unsupported[name] = true
+			retry = true
+			continue
+		}
+		kept = append(kept, o)
+	}
+	return kept, retry
+}
+
+// splitMountOptions splits the comma separated options of the mount flags, e.g. hard,nconnect=4.
+func splitMountOptions(options []string) []string {
+	var split []string
+	for _, o := range options {
+		split = append(split, strings.Split(o, ",")...)
+	}
+	return split
+}
+
+// mountOptionName returns the name of a mount option, e.g. nconnect for nconnect=4.
+func mountOptionName(option string) string {
+	name, _, _ := strings.Cut(option, "=")
+	return strings.ToLower(strings.TrimSpace(name))
+}
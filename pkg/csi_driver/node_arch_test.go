@@ -0,0 +1,133 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	mount "k8s.io/mount-utils"
+)
+
+func TestParseKernelRelease(t *testing.T) {
+	cases := map[string]kernelVersion{
+		"6.1.75+":           {major: 6, minor: 1},
+		"5.15.0-1057-gke\n": {major: 5, minor: 15},
+		"4.19.112+":         {major: 4, minor: 19},
+	}
+	for release, expected := range cases {
+		if v, ok := parseKernelRelease(release); !ok || v != expected {
+			t.Errorf("%q: got version %v, %v, expected %v", release, v, ok, expected)
+		}
+	}
+	if _, ok := parseKernelRelease("unknown"); ok {
+		t.Errorf("expected an invalid release not to be parsed")
+	}
+}
+
+func TestMountOptionProberFilter(t *testing.T) {
+	options := []string{"hard", "nconnect=4", "timeo=600"}
+	cases := []struct {
+		arch            string
+		release         string
+		expectedKept    []string
+		expectedDropped []string
+	}{
+		{arch: "amd64", release: "6.1.75+", expectedKept: options},
+		{arch: "arm64", release: "6.1.75+", expectedKept: options},
+		{arch: "amd64", release: "4.19.112+", expectedKept: []string{"hard", "timeo=600"}, expectedDropped: []string{"nconnect=4"}},
+		{arch: "arm64", release: "4.19.112+", expectedKept: []string{"hard", "timeo=600"}, expectedDropped: []string{"nconnect=4"}},
+		// The options are kept on unknown kernels and architectures, until a mount fails with them.
+		{arch: "arm64", release: "", expectedKept: options},
+		{arch: "s390x", release: "4.19.112+", expectedKept: options},
+	}
+	for _, tc := range cases {
+		p := newMountOptionProberForKernel(tc.arch, tc.release)
+		kept, dropped := p.filter(options)
+		if !reflect.DeepEqual(kept, tc.expectedKept) || !reflect.DeepEqual(dropped, tc.expectedDropped) {
+			t.Errorf("%s %q: got kept %v, dropped %v, expected kept %v, dropped %v", tc.arch, tc.release, kept, dropped, tc.expectedKept, tc.expectedDropped)
+		}
+	}
+
+	var disabled *mountOptionProber
+	if kept, dropped := disabled.filter(options); !reflect.DeepEqual(kept, options) || dropped != nil {
+		t.Errorf("got kept %v, dropped %v of a nil prober, expected the options unchanged", kept, dropped)
+	}
+}
+
+func TestMountOptionProberRetryWithout(t *testing.T) {
+	p := newMountOptionProberForKernel("arm64", "6.1.75+")
+	options := []string{"hard,nconnect=8", "timeo=600"}
+
+	if _, retry := p.retryWithout(options, errors.New("mount.nfs: Connection timed out")); retry {
+		t.Fatalf("got retry for a mount error not caused by its options")
+	}
+	if _, retry := p.retryWithout([]string{"hard"}, errors.New("mount.nfs: an incorrect mount option was specified")); retry {
+		t.Fatalf("got retry for a mount without architecture dependent options")
+	}
+	retryOptions, retry := p.retryWithout(options, errors.New("mount.nfs: an incorrect mount option was specified"))
+	if !retry || !reflect.DeepEqual(retryOptions, []string{"hard", "timeo=600"}) {
+		t.Fatalf("got retry %v with options %v, expected a retry without nconnect", retry, retryOptions)
+	}
+	// The next mounts of the node drop the option probed unsupported upfront.
+	if kept, dropped := p.filter([]string{"nconnect=4"}); len(kept) != 0 || len(dropped) != 1 {
+		t.Errorf("got kept %v, dropped %v, expected nconnect to be dropped once probed unsupported", kept, dropped)
+	}
+}
+
+// nconnectRejectingMounter fails the mounts using nconnect, like the NFS client of a kernel
+// built without it.
+type nconnectRejectingMounter struct {
+	*mount.FakeMounter
+}
+
+func (m *nconnectRejectingMounter) Mount(source, target, fstype string, options []string) error {
+	for _, o := range options {
+		if strings.HasPrefix(o, "nconnect") {
+			return errors.New("mount failed: exit status 32\nOutput: mount.nfs: an incorrect mount option was specified")
+		}
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestNodeStageVolumeUnsupportedMountOption(t *testing.T) {
+	testEnv := initTestNodeServer(t)
+	ns := testEnv.ns.(*nodeServer)
+	ns.mounter = &nconnectRejectingMounter{FakeMounter: testEnv.fm}
+	ns.mountOptions = newMountOptionProberForKernel("arm64", "6.1.75+")
+	stagingPath := t.TempDir()
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, "nconnect=4", "hard"),
+		VolumeContext:     map[string]string{attrIP: "1.1.1.1", attrVolume: "vol1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	validateMountPoint(t, "retried mount", testEnv.fm, &mount.MountPoint{
+		Device: "1.1.1.1:/vol1",
+		Path:   stagingPath,
+		Type:   "nfs",
+		Opts:   []string{"hard"},
+	})
+}
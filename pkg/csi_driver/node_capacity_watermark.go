@@ -0,0 +1,195 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// eventReasonVolumeCapacityAboveWatermark is the reason of the events published on the PVCs of
+// the volumes whose used capacity crosses the watermark.
+const eventReasonVolumeCapacityAboveWatermark = "VolumeCapacityAboveWatermark"
+
+type watermarkVolume struct {
+	path string
+	// above is true once the used capacity of the volume crossed the watermark, until it falls
+	// back below it, so that a crossing is only warned about once.
+	above bool
+}
+
+// capacityWatermark keeps track of the volumes staged by this node driver, samples their used
+// capacity with statfs and publishes a warning event on the PVCs of the volumes whose used
+// capacity crosses the watermark, before their applications fail with ENOSPC. The used
+// capacities are exported as metrics. Each node staging a volume warns about it, the repeated
+// events are aggregated by the event recorder.
+type capacityWatermark struct {
+	sync.Mutex
+	// volumes maps volume ID to its watermark state.
+	volumes map[string]*watermarkVolume
+
+	nodeName       string
+	driverName     string
+	period         time.Duration
+	percent        float64
+	kubeClient     kubernetes.Interface
+	recorder       record.EventRecorder
+	metricsManager *metrics.MetricsManager
+	// statFunc returns the capacity and used bytes of the filesystem mounted at path.
+	statFunc func(path string) (int64, int64, error)
+}
+
+func newCapacityWatermark(config *FeatureCapacityWatermark, nodeName, driverName string, kubeClient kubernetes.Interface, recorder record.EventRecorder, mm *metrics.MetricsManager) *capacityWatermark {
+	if mm != nil {
+		mm.RegisterCapacityWatermarkMetrics()
+	} else {
+		klog.Warningf("Capacity watermark is enabled but metrics endpoint is not configured, only the events are published")
+	}
+	return &capacityWatermark{
+		volumes:        make(map[string]*watermarkVolume),
+		nodeName:       nodeName,
+		driverName:     driverName,
+		period:         config.Period,
+		percent:        config.Percent,
+		kubeClient:     kubeClient,
+		recorder:       recorder,
+		metricsManager: mm,
+		statFunc: func(path string) (int64, int64, error) {
+			_, capacity, used, _, _, _, err := getFSStat(path)
+			return capacity, used, err
+		},
+	}
+}
+
+// track records a volume as staged at the given path.
+func (w *capacityWatermark) track(volumeID, stagingTargetPath string) {
+	w.Lock()
+	defer w.Unlock()
+	if v, ok := w.volumes[volumeID]; ok && v.path == stagingTargetPath {
+		return
+	}
+	w.volumes[volumeID] = &watermarkVolume{path: stagingTargetPath}
+}
+
+// untrack stops sampling the volume and drops its metrics.
+func (w *capacityWatermark) untrack(volumeID string) {
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.volumes[volumeID]; !ok {
+		return
+	}
+	delete(w.volumes, volumeID)
+	if w.metricsManager != nil {
+		w.metricsManager.DeleteCapacityWatermarkMetrics(volumeID)
+	}
+}
+
+// Run samples all staged volumes every period until stopCh is closed.
+func (w *capacityWatermark) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting capacity watermark with period %v and watermark %.0f%%", w.period, w.percent)
+	wait.Until(func() {
+		w.checkAll(context.Background())
+	}, w.period, stopCh)
+}
+
+func (w *capacityWatermark) checkAll(ctx context.Context) {
+	w.Lock()
+	paths := make(map[string]string, len(w.volumes))
+	for volumeID, v := range w.volumes {
+		paths[volumeID] = v.path
+	}
+	w.Unlock()
+
+	// crossed maps volume ID to the message of the event of its crossing.
+	crossed := make(map[string]string)
+	for volumeID, path := range paths {
+		// A hung mount blocks the check until it recovers, the mount health reporter reports
+		// it in the meantime.
+		capacity, used, err := w.statFunc(path)
+		if err != nil {
+			klog.Warningf("Capacity watermark failed to stat volume %s on path %s: %v", volumeID, path, err)
+			continue
+		}
+		if message, ok := w.record(volumeID, path, capacity, used); ok {
+			crossed[volumeID] = message
+		}
+	}
+	if len(crossed) > 0 {
+		if err := w.warnPVCs(ctx, crossed); err != nil {
+			klog.Errorf("Capacity watermark failed to publish the events of the PVCs: %v", err)
+		}
+	}
+}
+
+// record updates the used capacity of a volume. It returns the message of the event if the
+// used capacity crossed the watermark.
+func (w *capacityWatermark) record(volumeID, path string, capacity, used int64) (string, bool) {
+	w.Lock()
+	defer w.Unlock()
+	// The volume may have been unstaged while it was sampled.
+	v, ok := w.volumes[volumeID]
+	if !ok || v.path != path || capacity <= 0 {
+		return "", false
+	}
+	usedPercent := float64(used) * 100 / float64(capacity)
+	above := usedPercent >= w.percent
+	if w.metricsManager != nil {
+		w.metricsManager.RecordCapacityWatermarkMetrics(volumeID, usedPercent, above)
+	}
+	if above == v.above {
+		return "", false
+	}
+	v.above = above
+	if !above {
+		klog.Infof("Volume %s is %.1f%% full, back below the capacity watermark of %.0f%%", volumeID, usedPercent, w.percent)
+		return "", false
+	}
+	message := fmt.Sprintf("Volume %s is %.1f%% full, %dGi used of %dGi, above the capacity watermark of %.0f%% observed by node %s: expand the PVC or free up space before the applications fail to write with ENOSPC", volumeID, usedPercent, used/util.Gb, capacity/util.Gb, w.percent, w.nodeName)
+	klog.Warningf("%s: %s", eventReasonVolumeCapacityAboveWatermark, message)
+	return message, true
+}
+
+// warnPVCs publishes the events of the crossed volumes on the PVCs bound to their PVs.
+func (w *capacityWatermark) warnPVCs(ctx context.Context, crossed map[string]string) error {
+	pvs, err := w.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != w.driverName || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		message, ok := crossed[pv.Spec.CSI.VolumeHandle]
+		if !ok {
+			continue
+		}
+		claim := pv.Spec.ClaimRef
+		w.recorder.Event(&v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: claim.Namespace, Name: claim.Name, UID: claim.UID}, v1.EventTypeWarning, eventReasonVolumeCapacityAboveWatermark, message)
+	}
+	return nil
+}
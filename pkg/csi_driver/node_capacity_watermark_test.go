@@ -0,0 +1,87 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestCapacityWatermark(t *testing.T) {
+	const driverName = "filestore.csi.storage.gke.io"
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: driverName, VolumeHandle: testVolumeID},
+			},
+			ClaimRef: &v1.ObjectReference{Namespace: "default", Name: "pvc-1"},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	w := newCapacityWatermark(&FeatureCapacityWatermark{Period: time.Minute, Percent: 90}, "node-1", driverName, fake.NewSimpleClientset(pv), recorder, nil)
+	used := int64(50 * util.Gb)
+	w.statFunc = func(path string) (int64, int64, error) {
+		return 100 * util.Gb, used, nil
+	}
+	w.track(testVolumeID, "/staging/vol1")
+
+	for _, step := range []struct {
+		name        string
+		used        int64
+		expectEvent bool
+	}{
+		{name: "below the watermark", used: 50 * util.Gb},
+		{name: "crossing the watermark", used: 95 * util.Gb, expectEvent: true},
+		{name: "still above the watermark", used: 97 * util.Gb},
+		{name: "back below the watermark", used: 60 * util.Gb},
+		{name: "crossing the watermark again", used: 90 * util.Gb, expectEvent: true},
+	} {
+		used = step.used
+		w.checkAll(context.Background())
+		select {
+		case event := <-recorder.Events:
+			if !step.expectEvent {
+				t.Errorf("%s: unexpected event %q", step.name, event)
+			}
+			if !strings.Contains(event, eventReasonVolumeCapacityAboveWatermark) || !strings.Contains(event, "node-1") {
+				t.Errorf("%s: got event %q, expected reason %s naming the node", step.name, event, eventReasonVolumeCapacityAboveWatermark)
+			}
+		default:
+			if step.expectEvent {
+				t.Errorf("%s: expected event", step.name)
+			}
+		}
+	}
+
+	w.untrack(testVolumeID)
+	used = 99 * util.Gb
+	w.checkAll(context.Background())
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("unexpected event %q for an unstaged volume", event)
+	default:
+	}
+}
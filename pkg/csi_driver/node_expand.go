@@ -0,0 +1,99 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// nodeExpandCapacityTolerance is the fraction of the expanded capacity the NFS filesystem
+	// may report less of, e.g. the metadata of the basic tier instances.
+	nodeExpandCapacityTolerance = 0.05
+)
+
+var (
+	// nodeExpandPollInterval and nodeExpandTimeout bound the wait for the NFS filesystem of
+	// an expanded volume to report its new capacity, propagated by the instance after the
+	// expansion completes.
+	nodeExpandPollInterval = 5 * time.Second
+	nodeExpandTimeout      = 2 * time.Minute
+
+	// fsCapacity returns the capacity in bytes reported by the filesystem at path.
+	fsCapacity = func(path string) (int64, error) {
+		_, capacity, _, _, _, _, err := getFSStat(path)
+		return capacity, err
+	}
+)
+
+// NodeExpandVolume has nothing to expand, the NFS filesystem grows with the instance or share
+// expanded by ControllerExpandVolume. It verifies that the filesystem published at the volume
+// path reports the expanded capacity, so that the expansion is only reported complete once
+// the applications see it.
+func (s *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume volume ID must be provided")
+	}
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume volume path must be provided")
+	}
+	if vc := req.GetVolumeCapability(); vc != nil {
+		if err := validateVolumeCapability(vc); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "VolumeCapability is invalid: %v", err)
+		}
+	}
+	required := req.GetCapacityRange().GetRequiredBytes()
+	if limit := req.GetCapacityRange().GetLimitBytes(); limit > 0 && required > limit {
+		return nil, status.Errorf(codes.InvalidArgument, "NodeExpandVolume required bytes %d exceed limit bytes %d", required, limit)
+	}
+	if _, err := os.Stat(volumePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %s is not published at path %s", volumeID, volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to stat volume path %s: %v", volumePath, err)
+	}
+
+	// The capacity may be reported short of the expanded capacity by the tolerance.
+	expected := required - int64(float64(required)*nodeExpandCapacityTolerance)
+	ctx, cancel := context.WithTimeout(ctx, nodeExpandTimeout)
+	defer cancel()
+	for {
+		capacity, err := fsCapacity(volumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get the capacity of volume %s at path %s: %v", volumeID, volumePath, err)
+		}
+		if capacity >= expected {
+			klog.V(4).Infof("NodeExpandVolume verified volume %s at path %s reports %d bytes, expanded to %d bytes", volumeID, volumePath, capacity, required)
+			return &csi.NodeExpandVolumeResponse{CapacityBytes: capacity}, nil
+		}
+		klog.V(4).Infof("NodeExpandVolume waiting for volume %s at path %s reporting %d bytes to report its expanded capacity of %d bytes", volumeID, volumePath, capacity, required)
+		select {
+		case <-ctx.Done():
+			return nil, status.Errorf(codes.Unavailable, "volume %s at path %s reports %d bytes, not its expanded capacity of %d bytes: the expansion is not propagated yet: %v", volumeID, volumePath, capacity, required, ctx.Err())
+		case <-time.After(nodeExpandPollInterval):
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestNodeExpandVolume(t *testing.T) {
+	defer func(f func(string) (int64, error), interval, timeout time.Duration) {
+		fsCapacity, nodeExpandPollInterval, nodeExpandTimeout = f, interval, timeout
+	}(fsCapacity, nodeExpandPollInterval, nodeExpandTimeout)
+	nodeExpandPollInterval, nodeExpandTimeout = time.Millisecond, 50*time.Millisecond
+	volumePath := t.TempDir()
+
+	cases := []struct {
+		name             string
+		req              *csi.NodeExpandVolumeRequest
+		capacities       []int64 // reported by successive statfs calls, the last one repeated
+		expectedCapacity int64
+		expectedCode     codes.Code
+	}{
+		{
+			name:             "expansion propagated",
+			req:              &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID, VolumePath: volumePath, CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * util.Tb}},
+			capacities:       []int64{2 * util.Tb},
+			expectedCapacity: 2 * util.Tb,
+		},
+		{
+			name:             "filesystem metadata within tolerance",
+			req:              &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID, VolumePath: volumePath, CapacityRange: &csi.CapacityRange{RequiredBytes: 1 * util.Tb}},
+			capacities:       []int64{1007 * util.Gb},
+			expectedCapacity: 1007 * util.Gb,
+		},
+		{
+			name:             "expansion propagated after retries",
+			req:              &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID, VolumePath: volumePath, CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * util.Tb}},
+			capacities:       []int64{1 * util.Tb, 1 * util.Tb, 2 * util.Tb},
+			expectedCapacity: 2 * util.Tb,
+		},
+		{
+			name:             "no capacity range",
+			req:              &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID, VolumePath: volumePath},
+			capacities:       []int64{1 * util.Tb},
+			expectedCapacity: 1 * util.Tb,
+		},
+		{
+			name:         "expansion not propagated",
+			req:          &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID, VolumePath: volumePath, CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * util.Tb}},
+			capacities:   []int64{1 * util.Tb},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			name:         "volume path not found",
+			req:          &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID, VolumePath: volumePath + "/not-found"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "empty volume ID",
+			req:          &csi.NodeExpandVolumeRequest{VolumePath: volumePath},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "empty volume path",
+			req:          &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "block volume",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:   testVolumeID,
+				VolumePath: volumePath,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "required bytes over limit",
+			req:          &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID, VolumePath: volumePath, CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * util.Tb, LimitBytes: 1 * util.Tb}},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			fsCapacity = func(path string) (int64, error) {
+				if path != volumePath {
+					t.Errorf("got statfs of path %s, expected %s", path, volumePath)
+				}
+				capacity := tc.capacities[len(tc.capacities)-1]
+				if calls < len(tc.capacities) {
+					capacity = tc.capacities[calls]
+				}
+				calls++
+				return capacity, nil
+			}
+			testEnv := initTestNodeServer(t)
+			resp, err := testEnv.ns.NodeExpandVolume(context.Background(), tc.req)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("got error %v, expected code %v", err, tc.expectedCode)
+			}
+			if err == nil && resp.GetCapacityBytes() != tc.expectedCapacity {
+				t.Errorf("got capacity %d, expected %d", resp.GetCapacityBytes(), tc.expectedCapacity)
+			}
+		})
+	}
+}
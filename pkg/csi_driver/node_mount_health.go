@@ -0,0 +1,225 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+type mountHealthResult struct {
+	reachable bool
+	latency   time.Duration
+	usedBytes int64
+}
+
+// mountHealthReporter keeps track of the volumes staged by this node driver and probes
+// each staging path with statfs. A hung NFS mount blocks statfs indefinitely, so every
+// probe runs in its own goroutine and at most one probe is in flight per volume.
+type mountHealthReporter struct {
+	sync.Mutex
+	// staged maps volume ID to its staging target path.
+	staged map[string]string
+	// inflight contains the volume IDs whose previous probe has not returned yet.
+	inflight map[string]bool
+
+	period         time.Duration
+	timeout        time.Duration
+	metricsManager *metrics.MetricsManager
+	// statFunc returns the used bytes of the filesystem mounted at path.
+	statFunc func(path string) (int64, error)
+}
+
+func newMountHealthReporter(config *FeatureMountHealth, mm *metrics.MetricsManager) *mountHealthReporter {
+	if mm != nil {
+		mm.RegisterMountHealthMetrics()
+	} else {
+		klog.Warningf("Mount health reporter is enabled but metrics endpoint is not configured, probe results will only be logged")
+	}
+	return &mountHealthReporter{
+		staged:         make(map[string]string),
+		inflight:       make(map[string]bool),
+		period:         config.ProbePeriod,
+		timeout:        config.ProbeTimeout,
+		metricsManager: mm,
+		statFunc: func(path string) (int64, error) {
+			_, _, used, _, _, _, err := getFSStat(path)
+			return used, err
+		},
+	}
+}
+
+// track records a volume as staged at the given path.
+func (r *mountHealthReporter) track(volumeID, stagingTargetPath string) {
+	r.Lock()
+	defer r.Unlock()
+	r.staged[volumeID] = stagingTargetPath
+}
+
+// seed tracks the volumes staged before the node driver started, so that they are probed
+// without waiting for their next NodeStageVolume call.
+func (r *mountHealthReporter) seed(staged map[string]string) {
+	for volumeID, path := range staged {
+		r.track(volumeID, path)
+	}
+}
+
+// untrack stops probing the volume and drops its metrics.
+func (r *mountHealthReporter) untrack(volumeID string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.staged, volumeID)
+	if r.metricsManager != nil {
+		r.metricsManager.DeleteMountHealthMetrics(volumeID)
+	}
+}
+
+// Run probes all staged mounts every period until stopCh is closed.
+func (r *mountHealthReporter) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting mount health reporter with probe period %v and probe timeout %v", r.period, r.timeout)
+	wait.Until(r.probeAll, r.period, stopCh)
+}
+
+func (r *mountHealthReporter) probeAll() {
+	r.Lock()
+	staged := make(map[string]string, len(r.staged))
+	for volumeID, path := range r.staged {
+		staged[volumeID] = path
+	}
+	r.Unlock()
+
+	var wg sync.WaitGroup
+	for volumeID, path := range staged {
+		wg.Add(1)
+		go func(volumeID, path string) {
+			defer wg.Done()
+			result := r.probe(volumeID, path)
+			r.record(volumeID, path, result)
+		}(volumeID, path)
+	}
+	wg.Wait()
+}
+
+// probe runs statfs on the path and waits for at most the probe timeout. If the
+// previous probe on the volume is still stuck, no new probe is issued and the mount
+// is reported unreachable right away.
+func (r *mountHealthReporter) probe(volumeID, path string) mountHealthResult {
+	r.Lock()
+	if r.inflight[volumeID] {
+		r.Unlock()
+		klog.V(4).Infof("Previous mount health probe for volume %s on path %s has not returned yet", volumeID, path)
+		return mountHealthResult{reachable: false, latency: r.timeout}
+	}
+	r.inflight[volumeID] = true
+	r.Unlock()
+
+	type statResult struct {
+		used int64
+		err  error
+	}
+	done := make(chan statResult, 1)
+	start := time.Now()
+	go func() {
+		used, err := r.statFunc(path)
+		r.Lock()
+		delete(r.inflight, volumeID)
+		r.Unlock()
+		done <- statResult{used: used, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		latency := time.Since(start)
+		if res.err != nil {
+			klog.Warningf("Mount health probe for volume %s on path %s failed: %v", volumeID, path, res.err)
+			return mountHealthResult{reachable: false, latency: latency}
+		}
+		return mountHealthResult{reachable: true, latency: latency, usedBytes: res.used}
+	case <-time.After(r.timeout):
+		klog.Warningf("Mount health probe for volume %s on path %s timed out after %v", volumeID, path, r.timeout)
+		return mountHealthResult{reachable: false, latency: r.timeout}
+	}
+}
+
+func (r *mountHealthReporter) record(volumeID, path string, result mountHealthResult) {
+	r.Lock()
+	defer r.Unlock()
+	// The volume may have been unstaged while the probe was running.
+	if _, ok := r.staged[volumeID]; !ok {
+		return
+	}
+	klog.V(5).Infof("Mount health probe for volume %s on path %s: %+v", volumeID, path, result)
+	if r.metricsManager != nil {
+		r.metricsManager.RecordMountHealthMetrics(volumeID, result.reachable, result.latency, result.usedBytes)
+	}
+}
+
+const (
+	// kubeletCSIPluginDir is the part of the staging target paths of the CSI volumes in the
+	// kubelet root directory, <root>/plugins/kubernetes.io/csi/<driver or pv>/<dir>/globalmount.
+	kubeletCSIPluginDir = "/plugins/kubernetes.io/csi/"
+	stagingDirName      = "globalmount"
+	// kubeletVolDataFile is the file the kubelet writes next to the staging target path of
+	// a volume, holding its driver and volume handle.
+	kubeletVolDataFile = "vol_data.json"
+)
+
+// kubeletVolData is the subset of the vol_data.json of a staged volume read by the driver.
+type kubeletVolData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+}
+
+// stagedVolumes returns the staging target paths, by volume ID, of the volumes of the driver
+// mounted on the node, read from the mount table and the vol_data.json files of the kubelet.
+func stagedVolumes(mounter mount.Interface, driverName string) (map[string]string, error) {
+	mountPoints, err := mounter.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the mounts: %w", err)
+	}
+	staged := make(map[string]string)
+	for _, mp := range mountPoints {
+		if !strings.Contains(mp.Path, kubeletCSIPluginDir) || filepath.Base(mp.Path) != stagingDirName {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(filepath.Dir(mp.Path), kubeletVolDataFile))
+		if err != nil {
+			klog.V(4).Infof("Skipping staging mount %s: %v", mp.Path, err)
+			continue
+		}
+		var data kubeletVolData
+		if err := json.Unmarshal(raw, &data); err != nil {
+			klog.Warningf("Skipping staging mount %s, failed to parse %s: %v", mp.Path, kubeletVolDataFile, err)
+			continue
+		}
+		if data.DriverName != driverName || data.VolumeHandle == "" {
+			continue
+		}
+		staged[data.VolumeHandle] = mp.Path
+	}
+	return staged, nil
+}
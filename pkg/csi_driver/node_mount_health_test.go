@@ -0,0 +1,143 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	mount "k8s.io/mount-utils"
+)
+
+const testMountHealthStagingPath = "/var/lib/kubelet/plugins/kubernetes.io/csi/filestore.csi.storage.gke.io/globalmount"
+
+func TestMountHealthProbe(t *testing.T) {
+	cases := []struct {
+		name          string
+		statFunc      func(path string) (int64, error)
+		inflight      bool
+		wantReachable bool
+		wantUsed      int64
+	}{
+		{
+			name: "reachable mount",
+			statFunc: func(path string) (int64, error) {
+				return 100, nil
+			},
+			wantReachable: true,
+			wantUsed:      100,
+		},
+		{
+			name: "statfs error",
+			statFunc: func(path string) (int64, error) {
+				return 0, fmt.Errorf("stale file handle")
+			},
+		},
+		{
+			name: "hung mount",
+			statFunc: func(path string) (int64, error) {
+				time.Sleep(time.Second)
+				return 100, nil
+			},
+		},
+		{
+			name: "previous probe still in flight",
+			statFunc: func(path string) (int64, error) {
+				return 100, nil
+			},
+			inflight: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newMountHealthReporter(&FeatureMountHealth{Enabled: true, ProbePeriod: time.Minute, ProbeTimeout: 50 * time.Millisecond}, nil)
+			r.statFunc = tc.statFunc
+			r.track(testVolumeID, testMountHealthStagingPath)
+			if tc.inflight {
+				r.inflight[testVolumeID] = true
+			}
+
+			result := r.probe(testVolumeID, testMountHealthStagingPath)
+			if result.reachable != tc.wantReachable {
+				t.Errorf("got reachable %v, want %v", result.reachable, tc.wantReachable)
+			}
+			if result.usedBytes != tc.wantUsed {
+				t.Errorf("got used bytes %d, want %d", result.usedBytes, tc.wantUsed)
+			}
+		})
+	}
+}
+
+func TestMountHealthTrackUntrack(t *testing.T) {
+	r := newMountHealthReporter(&FeatureMountHealth{Enabled: true, ProbePeriod: time.Minute, ProbeTimeout: time.Second}, nil)
+	r.track(testVolumeID, testMountHealthStagingPath)
+	if path := r.staged[testVolumeID]; path != testMountHealthStagingPath {
+		t.Errorf("got staging path %q, want %q", path, testMountHealthStagingPath)
+	}
+	r.untrack(testVolumeID)
+	if _, ok := r.staged[testVolumeID]; ok {
+		t.Errorf("volume %s still tracked after untrack", testVolumeID)
+	}
+}
+
+func TestMountHealthSeed(t *testing.T) {
+	pluginDir := filepath.Join(t.TempDir(), "plugins/kubernetes.io/csi")
+	volumes := []struct {
+		dir     string
+		volData string
+	}{
+		{dir: "filestore.csi.storage.gke.io/hash-1", volData: `{"driverName":"` + testDriverName + `","volumeHandle":"` + testVolumeID + `"}`},
+		{dir: "pv/pv-2", volData: `{"driverName":"` + testDriverName + `","volumeHandle":"modeInstance/us-central1-c/other/vol1"}`},
+		{dir: "pd.csi.storage.gke.io/hash-3", volData: `{"driverName":"pd.csi.storage.gke.io","volumeHandle":"disk"}`},
+		{dir: "filestore.csi.storage.gke.io/hash-4"},
+	}
+	mountPoints := []mount.MountPoint{{Device: "1.1.1.1:/vol1", Path: "/mnt/not-staged", Type: "nfs"}}
+	for _, v := range volumes {
+		dir := filepath.Join(pluginDir, v.dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+		if v.volData != "" {
+			if err := os.WriteFile(filepath.Join(dir, kubeletVolDataFile), []byte(v.volData), 0644); err != nil {
+				t.Fatalf("failed to write vol data: %v", err)
+			}
+		}
+		mountPoints = append(mountPoints, mount.MountPoint{Device: "1.1.1.1:/vol1", Path: filepath.Join(dir, stagingDirName), Type: "nfs"})
+	}
+
+	staged, err := stagedVolumes(mount.NewFakeMounter(mountPoints), testDriverName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		testVolumeID:                            filepath.Join(pluginDir, volumes[0].dir, stagingDirName),
+		"modeInstance/us-central1-c/other/vol1": filepath.Join(pluginDir, volumes[1].dir, stagingDirName),
+	}
+	if !reflect.DeepEqual(staged, expected) {
+		t.Errorf("got staged volumes %v, expected %v", staged, expected)
+	}
+	r := newMountHealthReporter(&FeatureMountHealth{Enabled: true, ProbePeriod: time.Minute, ProbeTimeout: time.Second}, nil)
+	r.seed(staged)
+	if !reflect.DeepEqual(r.staged, expected) {
+		t.Errorf("got tracked volumes %v, expected %v", r.staged, expected)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+)
+
+// The NFS mount helpers, selected with the --nfs-mount-helper flag.
+const (
+	// MountHelperAuto selects the container helper if the driver image ships one, else the
+	// helper of the node.
+	MountHelperAuto = "auto"
+	// MountHelperContainer runs the NFS mounts in the namespace of the driver, with the
+	// mount.nfs of the nfs-common package of the driver image.
+	MountHelperContainer = "container"
+	// MountHelperHost runs the NFS mounts chrooted to the root of the node, with the
+	// mount.nfs of its nfs-utils, e.g. to use the NFS client configuration of the node.
+	MountHelperHost = "host"
+)
+
+// nfsMountHelperPaths are the paths of mount.nfs relative to the root of a system.
+var nfsMountHelperPaths = []string{"sbin/mount.nfs", "usr/sbin/mount.nfs"}
+
+// NodeOS is the operating system of a node, read from its os-release file.
+type NodeOS struct {
+	ID         string
+	IDLike     string
+	VersionID  string
+	PrettyName string
+}
+
+func (o *NodeOS) String() string {
+	if o == nil {
+		return "unknown"
+	}
+	if o.PrettyName != "" {
+		return o.PrettyName
+	}
+	return strings.TrimSpace(o.ID + " " + o.VersionID)
+}
+
+// nfsUtilsHint returns how to provide a mount.nfs on the nodes of the OS.
+func (o *NodeOS) nfsUtilsHint() string {
+	if o == nil {
+		return "install the NFS client utilities on the node, or use a driver image shipping nfs-common"
+	}
+	family := " " + o.ID + " " + o.IDLike + " "
+	switch {
+	case o.ID == "cos":
+		return "Container-Optimized OS doesn't ship the NFS client utilities, use a driver image shipping nfs-common"
+	case strings.Contains(family, " ubuntu ") || strings.Contains(family, " debian "):
+		return "install the nfs-common package on the node, or use a driver image shipping nfs-common"
+	case strings.Contains(family, " rhel ") || strings.Contains(family, " fedora ") || strings.Contains(family, " centos "):
+		return "install the nfs-utils package on the node, or use a driver image shipping nfs-common"
+	}
+	return "install the NFS client utilities on the node, or use a driver image shipping nfs-common"
+}
+
+// DetectNodeOS reads the OS of the node from the os-release file of its root, mounted at
+// hostRoot in the driver container.
+func DetectNodeOS(hostRoot string) (*NodeOS, error) {
+	var f *os.File
+	var err error
+	for _, path := range []string{"etc/os-release", "usr/lib/os-release"} {
+		if f, err = os.Open(filepath.Join(hostRoot, path)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	o := &NodeOS{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			o.ID = strings.ToLower(value)
+		case "ID_LIKE":
+			o.IDLike = strings.ToLower(value)
+		case "VERSION_ID":
+			o.VersionID = value
+		case "PRETTY_NAME":
+			o.PrettyName = value
+		}
+	}
+	return o, scanner.Err()
+}
+
+// nfsMounter runs the NFS mounts of the node driver with the mount helper selected for the
+// node, the other mounts, e.g. the bind mounts of NodePublishVolume, with the wrapped mounter.
+// If no helper is available, the NFS mounts fail with the unsupported error, naming the node
+// OS and how to provide a helper, instead of the generic error of mount.
+type nfsMounter struct {
+	mount.Interface
+	helper      string
+	hostRoot    string
+	unsupported error
+	run         func(name string, args ...string) ([]byte, error)
+}
+
+// NewNodeMounter returns the mounter of the node driver running the NFS mounts with the given
+// helper, see MountHelperAuto. The node OS is detected from its root mounted at hostRoot, if
+// set, which the host helper requires.
+func NewNodeMounter(helper, hostRoot string) (mount.Interface, error) {
+	var nodeOS *NodeOS
+	if hostRoot != "" {
+		var err error
+		if nodeOS, err = DetectNodeOS(hostRoot); err != nil {
+			klog.Warningf("Failed to detect the node OS from %s: %v", hostRoot, err)
+		}
+	}
+	m, err := newNFSMounter(mount.New(""), helper, hostRoot, nodeOS, exec.LookPath, fileExists)
+	if err != nil {
+		return nil, err
+	}
+	if m.unsupported != nil {
+		klog.Errorf("Node OS %s is not supported: %v", nodeOS, m.unsupported)
+	} else {
+		klog.Infof("Node OS %s, running the NFS mounts with the %s mount helper", nodeOS, m.helper)
+	}
+	return m, nil
+}
+
+func newNFSMounter(mounter mount.Interface, helper, hostRoot string, nodeOS *NodeOS, lookPath func(string) (string, error), exists func(string) bool) (*nfsMounter, error) {
+	m := &nfsMounter{
+		Interface: mounter,
+		hostRoot:  hostRoot,
+		run: func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).CombinedOutput()
+		},
+	}
+	_, lookErr := lookPath("mount.nfs")
+	containerHelper := lookErr == nil
+	hostHelper := false
+	if hostRoot != "" {
+		for _, path := range nfsMountHelperPaths {
+			if exists(filepath.Join(hostRoot, path)) {
+				hostHelper = true
+				break
+			}
+		}
+	}
+
+	switch helper {
+	case MountHelperContainer:
+		if !containerHelper {
+			return nil, errors.New("the driver image has no mount.nfs, the container mount helper is not available")
+		}
+		m.helper = MountHelperContainer
+	case MountHelperHost:
+		if hostRoot == "" {
+			return nil, errors.New("the host mount helper requires the root of the node to be mounted with --host-root")
+		}
+		if !hostHelper {
+			return nil, fmt.Errorf("node OS %s has no mount.nfs, the host mount helper is not available: %s", nodeOS, nodeOS.nfsUtilsHint())
+		}
+		m.helper = MountHelperHost
+	case MountHelperAuto, "":
+		switch {
+		case containerHelper:
+			m.helper = MountHelperContainer
+		case hostHelper:
+			m.helper = MountHelperHost
+		default:
+			m.unsupported = fmt.Errorf("no NFS mount helper is available on node OS %s, mount.nfs is missing from both the driver image and the node: %s", nodeOS, nodeOS.nfsUtilsHint())
+		}
+	default:
+		return nil, fmt.Errorf("unknown NFS mount helper %q, expected one of %s, %s or %s", helper, MountHelperAuto, MountHelperContainer, MountHelperHost)
+	}
+	return m, nil
+}
+
+func (m *nfsMounter) Mount(source, target, fstype string, options []string) error {
+	return m.MountSensitive(source, target, fstype, options, nil)
+}
+
+func (m *nfsMounter) MountSensitive(source, target, fstype string, options []string, sensitiveOptions []string) error {
+	if !strings.HasPrefix(fstype, "nfs") {
+		return m.Interface.MountSensitive(source, target, fstype, options, sensitiveOptions)
+	}
+	if m.unsupported != nil {
+		return &unsupportedNodeOSError{err: m.unsupported}
+	}
+	if m.helper != MountHelperHost {
+		return m.Interface.MountSensitive(source, target, fstype, options, sensitiveOptions)
+	}
+	args, logArgs := mount.MakeMountArgsSensitive(source, target, fstype, options, sensitiveOptions)
+	klog.V(4).Infof("Mounting with the mount helper of the node: chroot %s mount %s", m.hostRoot, logArgs)
+	if output, err := m.run("chroot", append([]string{m.hostRoot, "mount"}, args...)...); err != nil {
+		return fmt.Errorf("mount failed: %v\nMounting command: chroot %s mount %s\nOutput: %s", err, m.hostRoot, logArgs, output)
+	}
+	return nil
+}
+
+// unsupportedNodeOSError is the error of the NFS mounts of a node without mount helper.
+type unsupportedNodeOSError struct {
+	err error
+}
+
+func (e *unsupportedNodeOSError) Error() string {
+	return e.err.Error()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
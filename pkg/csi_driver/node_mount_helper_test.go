@@ -0,0 +1,159 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+)
+
+func TestDetectNodeOS(t *testing.T) {
+	hostRoot := t.TempDir()
+	if _, err := DetectNodeOS(hostRoot); err == nil {
+		t.Fatalf("expected error for a root without os-release")
+	}
+	if err := os.MkdirAll(filepath.Join(hostRoot, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	osRelease := "NAME=\"Rocky Linux\"\n# comment\nID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nVERSION_ID=\"9.3\"\nPRETTY_NAME=\"Rocky Linux 9.3 (Blue Onyx)\"\n"
+	if err := os.WriteFile(filepath.Join(hostRoot, "etc/os-release"), []byte(osRelease), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodeOS, err := DetectNodeOS(hostRoot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &NodeOS{ID: "rocky", IDLike: "rhel centos fedora", VersionID: "9.3", PrettyName: "Rocky Linux 9.3 (Blue Onyx)"}
+	if !reflect.DeepEqual(nodeOS, expected) {
+		t.Errorf("got node OS %+v, expected %+v", nodeOS, expected)
+	}
+	if hint := nodeOS.nfsUtilsHint(); !strings.Contains(hint, "nfs-utils") {
+		t.Errorf("got hint %q, expected nfs-utils to be installed on a RHEL node", hint)
+	}
+}
+
+func TestNewNFSMounter(t *testing.T) {
+	cos := &NodeOS{ID: "cos", PrettyName: "Container-Optimized OS from Google"}
+	ubuntu := &NodeOS{ID: "ubuntu", IDLike: "debian", PrettyName: "Ubuntu 22.04.4 LTS"}
+	cases := []struct {
+		name            string
+		helper          string
+		hostRoot        string
+		nodeOS          *NodeOS
+		containerHelper bool
+		hostHelper      bool
+		expectedHelper  string
+		expectErr       bool
+		unsupported     string
+	}{
+		{name: "auto with container helper", helper: MountHelperAuto, hostRoot: "/host", nodeOS: cos, containerHelper: true, expectedHelper: MountHelperContainer},
+		{name: "auto falls back to host helper", helper: MountHelperAuto, hostRoot: "/host", nodeOS: ubuntu, hostHelper: true, expectedHelper: MountHelperHost},
+		{name: "auto without host root", helper: MountHelperAuto, hostHelper: true, unsupported: "install the NFS client utilities"},
+		{name: "auto without helper on cos", helper: MountHelperAuto, hostRoot: "/host", nodeOS: cos, unsupported: "Container-Optimized OS doesn't ship"},
+		{name: "auto without helper on ubuntu", helper: MountHelperAuto, hostRoot: "/host", nodeOS: ubuntu, unsupported: "install the nfs-common package"},
+		{name: "container", helper: MountHelperContainer, containerHelper: true, hostHelper: true, expectedHelper: MountHelperContainer},
+		{name: "container without helper", helper: MountHelperContainer, hostRoot: "/host", hostHelper: true, expectErr: true},
+		{name: "host", helper: MountHelperHost, hostRoot: "/host", nodeOS: ubuntu, containerHelper: true, hostHelper: true, expectedHelper: MountHelperHost},
+		{name: "host without host root", helper: MountHelperHost, containerHelper: true, expectErr: true},
+		{name: "host without helper", helper: MountHelperHost, hostRoot: "/host", nodeOS: cos, containerHelper: true, expectErr: true},
+		{name: "unknown helper", helper: "fuse", containerHelper: true, expectErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lookPath := func(file string) (string, error) {
+				if tc.containerHelper {
+					return "/sbin/" + file, nil
+				}
+				return "", errors.New("not found")
+			}
+			exists := func(path string) bool {
+				return tc.hostHelper && path == filepath.Join(tc.hostRoot, "sbin/mount.nfs")
+			}
+			m, err := newNFSMounter(mount.NewFakeMounter(nil), tc.helper, tc.hostRoot, tc.nodeOS, lookPath, exists)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if tc.unsupported != "" {
+				if m.unsupported == nil || !strings.Contains(m.unsupported.Error(), tc.unsupported) {
+					t.Fatalf("got unsupported error %v, expected %q", m.unsupported, tc.unsupported)
+				}
+				return
+			}
+			if m.unsupported != nil || m.helper != tc.expectedHelper {
+				t.Errorf("got helper %q, unsupported error %v, expected helper %q", m.helper, m.unsupported, tc.expectedHelper)
+			}
+		})
+	}
+}
+
+func TestNFSMounterHostHelper(t *testing.T) {
+	fm := mount.NewFakeMounter(nil)
+	var ran []string
+	m := &nfsMounter{
+		Interface: fm,
+		helper:    MountHelperHost,
+		hostRoot:  "/host",
+		run: func(name string, args ...string) ([]byte, error) {
+			ran = append([]string{name}, args...)
+			return nil, nil
+		},
+	}
+	if err := m.Mount("10.0.0.2:/vol1", "/var/lib/kubelet/staging", "nfs", []string{"hard"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"chroot", "/host", "mount", "-t", "nfs", "-o", "hard", "10.0.0.2:/vol1", "/var/lib/kubelet/staging"}
+	if !reflect.DeepEqual(ran, expected) {
+		t.Errorf("got command %v, expected %v", ran, expected)
+	}
+	// The bind mounts are not run by the NFS helper.
+	if err := m.Mount("/var/lib/kubelet/staging", "/var/lib/kubelet/target", "", []string{"bind"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mountPoints, _ := fm.List(); len(mountPoints) != 1 || mountPoints[0].Path != "/var/lib/kubelet/target" {
+		t.Errorf("got mount points %v, expected the bind mount", mountPoints)
+	}
+}
+
+func TestNodeStageVolumeUnsupportedNodeOS(t *testing.T) {
+	testEnv := initTestNodeServer(t)
+	testEnv.ns.(*nodeServer).mounter = &nfsMounter{
+		Interface:   testEnv.fm,
+		unsupported: errors.New("no NFS mount helper is available on node OS Container-Optimized OS from Google"),
+	}
+	_, err := testEnv.ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: t.TempDir(),
+		VolumeCapability:  mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		VolumeContext:     map[string]string{attrIP: "1.1.1.1", attrVolume: "vol1"},
+	})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "Container-Optimized OS") {
+		t.Fatalf("got error %v, expected code %v naming the node OS", err, codes.FailedPrecondition)
+	}
+}
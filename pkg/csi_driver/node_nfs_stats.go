@@ -0,0 +1,245 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+// nfsStatsCollector keeps track of the volumes staged by this node driver and exports the
+// NFS client statistics of their staging mounts, i.e. the bytes transferred and the count,
+// retransmissions and round trip time of the requests of each NFS operation. The kernel keeps
+// these counters in memory, so reading them does not block on a hung mount.
+type nfsStatsCollector struct {
+	sync.Mutex
+	// staged maps volume ID to its staging target path.
+	staged map[string]string
+	// operations maps volume ID to the NFS operations whose series were recorded.
+	operations map[string][]string
+
+	period         time.Duration
+	metricsManager *metrics.MetricsManager
+	// mountStatsFunc returns the NFS client statistics of the NFS mounts of the node keyed by
+	// mount point.
+	mountStatsFunc func() (map[string]*metrics.NFSMountStats, error)
+}
+
+func newNFSStatsCollector(config *FeatureNFSStats, mm *metrics.MetricsManager) *nfsStatsCollector {
+	if mm != nil {
+		mm.RegisterNFSStatsMetrics()
+	} else {
+		klog.Warningf("NFS statistics collector is enabled but metrics endpoint is not configured, the statistics will only be logged")
+	}
+	return &nfsStatsCollector{
+		staged:         make(map[string]string),
+		operations:     make(map[string][]string),
+		period:         config.CollectionPeriod,
+		metricsManager: mm,
+		mountStatsFunc: func() (map[string]*metrics.NFSMountStats, error) {
+			f, err := os.Open(nfsMountStatsPath)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return parseNFSMountStats(f)
+		},
+	}
+}
+
+// track records a volume as staged at the given path.
+func (c *nfsStatsCollector) track(volumeID, stagingTargetPath string) {
+	c.Lock()
+	defer c.Unlock()
+	c.staged[volumeID] = stagingTargetPath
+}
+
+// untrack stops collecting the statistics of the volume and drops its metrics.
+func (c *nfsStatsCollector) untrack(volumeID string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.staged, volumeID)
+	if c.metricsManager != nil {
+		c.metricsManager.DeleteNFSStatsMetrics(volumeID, c.operations[volumeID])
+	}
+	delete(c.operations, volumeID)
+}
+
+// Run collects the statistics of all staged mounts every period until stopCh is closed.
+func (c *nfsStatsCollector) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting NFS statistics collector with collection period %v", c.period)
+	wait.Until(c.collectAll, c.period, stopCh)
+}
+
+func (c *nfsStatsCollector) collectAll() {
+	stats, err := c.mountStatsFunc()
+	if err != nil {
+		klog.Warningf("NFS statistics collector failed to read %s: %v", nfsMountStatsPath, err)
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for volumeID, path := range c.staged {
+		s, ok := stats[path]
+		if !ok {
+			klog.Warningf("No NFS statistics found for volume %s on path %s", volumeID, path)
+			continue
+		}
+		klog.V(5).Infof("NFS statistics of volume %s on path %s: %+v", volumeID, path, s)
+		if c.metricsManager == nil {
+			continue
+		}
+		c.metricsManager.RecordNFSStatsMetrics(volumeID, s)
+		c.operations[volumeID] = mergeOperations(c.operations[volumeID], s.Operations)
+	}
+}
+
+// mergeOperations returns the sorted union of the recorded operations and of the operations
+// of new statistics.
+func mergeOperations(recorded []string, operations map[string]metrics.NFSOperationStats) []string {
+	merged := make(map[string]bool, len(recorded)+len(operations))
+	for _, op := range recorded {
+		merged[op] = true
+	}
+	for op := range operations {
+		merged[op] = true
+	}
+	result := make([]string, 0, len(merged))
+	for op := range merged {
+		result = append(result, op)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// parseNFSMountStats returns the NFS client statistics of the NFS mounts keyed by mount point,
+// from the content of /proc/self/mountstats, whose NFS mounts read
+//
+//	device 10.0.0.2:/vol1 mounted on /mnt/vol1 with fstype nfs statvers=1.1
+//		...
+//		bytes:	<normal read> <normal write> <direct read> <direct write> <server read> <server write> ...
+//		...
+//		per-op statistics
+//		        READ: <ops> <transmissions> <major timeouts> <bytes sent> <bytes received> <queue ms> <rtt ms> <execute ms> ...
+//
+// Only the operations with requests are returned. The mounts whose statistics are malformed
+// are skipped.
+func parseNFSMountStats(r io.Reader) (map[string]*metrics.NFSMountStats, error) {
+	result := make(map[string]*metrics.NFSMountStats)
+	scanner := bufio.NewScanner(r)
+	var mountPoint string
+	var current *metrics.NFSMountStats
+	var hasBytes, perOp bool
+	done := func() {
+		if current != nil && hasBytes {
+			result[mountPoint] = current
+		}
+		current, hasBytes, perOp = nil, false, false
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "device" {
+			done()
+			// device <source> mounted on <mount point> with fstype <fstype> ...
+			if len(fields) >= 8 && fields[3] == "on" && strings.HasPrefix(fields[7], "nfs") {
+				mountPoint = fields[4]
+				current = &metrics.NFSMountStats{Operations: make(map[string]metrics.NFSOperationStats)}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if err := parseNFSMountStatsLine(current, fields, &hasBytes, &perOp); err != nil {
+			klog.V(4).Infof("Skipping the NFS statistics of mount %s: %v", mountPoint, err)
+			current = nil
+		}
+	}
+	done()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseNFSMountStatsLine adds the statistics of a line of an NFS mount to its statistics.
+func parseNFSMountStatsLine(stats *metrics.NFSMountStats, fields []string, hasBytes, perOp *bool) error {
+	switch {
+	case fields[0] == "bytes:":
+		values, err := parseInts(fields[1:], 4)
+		if err != nil {
+			return fmt.Errorf("unexpected NFS byte statistics %q: %w", strings.Join(fields, " "), err)
+		}
+		stats.ReadBytes = values[0] + values[2]
+		stats.WriteBytes = values[1] + values[3]
+		*hasBytes = true
+	case len(fields) == 2 && fields[0] == "per-op" && fields[1] == "statistics":
+		*perOp = true
+	case *perOp && strings.HasSuffix(fields[0], ":"):
+		values, err := parseInts(fields[1:], 8)
+		if err != nil {
+			return fmt.Errorf("unexpected NFS operation statistics %q: %w", strings.Join(fields, " "), err)
+		}
+		if values[0] == 0 {
+			return nil
+		}
+		op := strings.TrimSuffix(fields[0], ":")
+		// The transmissions of the requests still in flight are counted, not their completion.
+		retransmissions := values[1] - values[0]
+		if retransmissions < 0 {
+			retransmissions = 0
+		}
+		stats.Operations[op] = metrics.NFSOperationStats{
+			Ops:             values[0],
+			Retransmissions: retransmissions,
+			RTT:             time.Duration(values[6]) * time.Millisecond,
+		}
+	}
+	return nil
+}
+
+// parseInts parses the first n fields as integers.
+func parseInts(fields []string, n int) ([]int64, error) {
+	if len(fields) < n {
+		return nil, fmt.Errorf("expected at least %d values, got %d", n, len(fields))
+	}
+	values := make([]int64, n)
+	for i := range values {
+		v, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+func TestParseNFSMountStats(t *testing.T) {
+	mountStats := `device rootfs mounted on / with fstype rootfs
+device 10.0.0.2:/vol1 mounted on /staging/vol1 with fstype nfs statvers=1.1
+	opts:	rw,vers=3,rsize=1048576,wsize=1048576
+	age:	3600
+	bytes:	100 200 30 40 500 600 7 8
+	RPC iostats version: 1.1  p/v: 100003/3 (nfs)
+	xprt:	tcp 0 0 1 0 11 1040 1040 0 1040 0 2 0 0
+	per-op statistics
+	        NULL: 0 0 0 0 0 0 0 0
+	     GETATTR: 10 10 0 1200 1120 2 30 35
+	        READ: 20 23 1 2400 1048576 5 400 420 0
+	       WRITE: 5 5 0 1048576 600 1 100 110 0
+device 10.0.0.3:/vol2 mounted on /staging/vol2 with fstype nfs4 statvers=1.1
+	bytes:	1 2 3 4 5 6 7 8
+	per-op statistics
+	        READ: 3 2 0 0 0 0 9 9
+device 10.0.0.4:/vol3 mounted on /staging/vol3 with fstype nfs statvers=1.1
+	bytes:	1 2 3 4 5 6 7 8
+	per-op statistics
+	        READ: 1 x 0 0 0 0 9 9
+device /dev/sda1 mounted on /var/lib/kubelet with fstype ext4
+`
+	got, err := parseNFSMountStats(strings.NewReader(mountStats))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]*metrics.NFSMountStats{
+		"/staging/vol1": {
+			ReadBytes:  130,
+			WriteBytes: 240,
+			Operations: map[string]metrics.NFSOperationStats{
+				"GETATTR": {Ops: 10, RTT: 30 * time.Millisecond},
+				"READ":    {Ops: 20, Retransmissions: 3, RTT: 400 * time.Millisecond},
+				"WRITE":   {Ops: 5, RTT: 100 * time.Millisecond},
+			},
+		},
+		"/staging/vol2": {
+			ReadBytes:  4,
+			WriteBytes: 6,
+			// The transmissions of a request in flight may be counted before the completion of another.
+			Operations: map[string]metrics.NFSOperationStats{"READ": {Ops: 3, RTT: 9 * time.Millisecond}},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got stats %+v, expected %+v", got, expected)
+	}
+}
+
+func TestNFSStatsCollector(t *testing.T) {
+	mm := metrics.NewMetricsManager()
+	c := newNFSStatsCollector(&FeatureNFSStats{Enabled: true, CollectionPeriod: time.Minute}, mm)
+	stats := map[string]*metrics.NFSMountStats{
+		testMountHealthStagingPath: {
+			ReadBytes:  1000,
+			WriteBytes: 2000,
+			Operations: map[string]metrics.NFSOperationStats{
+				"READ": {Ops: 20, Retransmissions: 3, RTT: 400 * time.Millisecond},
+			},
+		},
+	}
+	c.mountStatsFunc = func() (map[string]*metrics.NFSMountStats, error) { return stats, nil }
+	// gathered returns the value of the series of a metric for the given NFS operation, if any.
+	gathered := func(name, op string) (float64, bool) {
+		t.Helper()
+		families, err := mm.GetRegistry().Gather()
+		if err != nil {
+			t.Fatalf("failed to gather metrics: %v", err)
+		}
+		for _, family := range families {
+			if family.GetName() != "filestorecsi_"+name {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labels["volume_id"] == testVolumeID && labels["nfs_operation"] == op {
+					return m.GetGauge().GetValue(), true
+				}
+			}
+		}
+		return 0, false
+	}
+
+	c.track(testVolumeID, testMountHealthStagingPath)
+	c.collectAll()
+	for name, expected := range map[string]float64{"nfs_operations": 20, "nfs_retransmissions": 3, "nfs_rtt_seconds": 0.4} {
+		if got, ok := gathered(name, "READ"); !ok || got != expected {
+			t.Errorf("got %s %v (found %v), expected %v", name, got, ok, expected)
+		}
+	}
+
+	// The series of the operations seen once are dropped with the volume.
+	stats[testMountHealthStagingPath].Operations = map[string]metrics.NFSOperationStats{"WRITE": {Ops: 1}}
+	c.collectAll()
+	if !reflect.DeepEqual(c.operations[testVolumeID], []string{"READ", "WRITE"}) {
+		t.Errorf("got recorded operations %v, expected READ and WRITE", c.operations[testVolumeID])
+	}
+	c.untrack(testVolumeID)
+	for _, op := range []string{"READ", "WRITE"} {
+		if _, ok := gathered("nfs_operations", op); ok {
+			t.Errorf("got nfs_operations series of %s after the volume was unstaged", op)
+		}
+	}
+	if _, ok := c.operations[testVolumeID]; ok {
+		t.Errorf("got recorded operations after the volume was unstaged")
+	}
+}
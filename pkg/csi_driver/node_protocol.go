@@ -0,0 +1,84 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// attrProtocol is the file protocol the volume is served over, defaulting to protocolNFS
+	// for the volumes provisioned before the attribute, and for the pre-provisioned PVs.
+	attrProtocol = "protocol"
+	protocolNFS  = "nfs"
+)
+
+// protocolMounter builds the mounts of the volumes of a file protocol on the node.
+type protocolMounter interface {
+	// mountSource returns the source to mount the export of the server at host, host being an
+	// IP or a DNS name, and subdir an optional directory relative to the root of the export.
+	mountSource(host, export, subdir string) string
+	// fsType returns the filesystem type of the mounts.
+	fsType() string
+}
+
+// protocolMounters are the mounters of the protocols supported by the node driver, by the
+// value of attrProtocol.
+var protocolMounters = map[string]protocolMounter{
+	protocolNFS: nfsProtocolMounter{},
+}
+
+// mounterForProtocol returns the mounter of the protocol of the volume attributes.
+func mounterForProtocol(attr map[string]string) (protocolMounter, error) {
+	protocol := volumeProtocol(attr)
+	m, ok := protocolMounters[protocol]
+	if !ok {
+		return nil, fmt.Errorf("unsupported volume attribute %v %q, expected one of %s", attrProtocol, attr[attrProtocol], strings.Join(supportedProtocols(), ", "))
+	}
+	return m, nil
+}
+
+// volumeProtocol returns the protocol of the volume attributes, lowercased.
+func volumeProtocol(attr map[string]string) string {
+	protocol := strings.ToLower(attr[attrProtocol])
+	if protocol == "" {
+		return protocolNFS
+	}
+	return protocol
+}
+
+func supportedProtocols() []string {
+	protocols := make([]string, 0, len(protocolMounters))
+	for p := range protocolMounters {
+		protocols = append(protocols, p)
+	}
+	sort.Strings(protocols)
+	return protocols
+}
+
+// nfsProtocolMounter mounts the NFS exports of the Filestore instances and shares.
+type nfsProtocolMounter struct{}
+
+func (nfsProtocolMounter) mountSource(host, export, subdir string) string {
+	return nfsMountSource(host, export, subdir)
+}
+
+func (nfsProtocolMounter) fsType() string {
+	return "nfs"
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+)
+
+func TestMounterForProtocol(t *testing.T) {
+	cases := []struct {
+		name           string
+		attrs          map[string]string
+		expectedSource string
+		expectErr      bool
+	}{
+		{
+			name:           "no protocol defaults to nfs",
+			attrs:          map[string]string{attrIP: "1.1.1.1", attrVolume: "vol1"},
+			expectedSource: "1.1.1.1:/vol1",
+		},
+		{
+			name:           "nfs protocol",
+			attrs:          map[string]string{attrIP: "1.1.1.1", attrVolume: "vol1", attrProtocol: "NFS"},
+			expectedSource: "1.1.1.1:/vol1",
+		},
+		{
+			name:      "unsupported protocol",
+			attrs:     map[string]string{attrIP: "1.1.1.1", attrVolume: "vol1", attrProtocol: "smb"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := mounterForProtocol(tc.attrs)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got mounter %v", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if source := m.mountSource(tc.attrs[attrIP], tc.attrs[attrVolume], ""); source != tc.expectedSource {
+				t.Errorf("expected source %q, got %q", tc.expectedSource, source)
+			}
+			if m.fsType() != "nfs" {
+				t.Errorf("expected fs type nfs, got %q", m.fsType())
+			}
+		})
+	}
+}
@@ -197,6 +197,61 @@ func TestNodePublishVolume(t *testing.T) {
 			actions:       []mount.FakeAction{{Action: mount.FakeActionMount}},
 			expectedMount: &mount.MountPoint{Device: stagingTargetPath, Path: testTargetPath, Type: "nfs", Opts: []string{"bind", "ro"}},
 		},
+		{
+			name: "valid request reader only access mode",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: stagingTargetPath,
+				TargetPath:        testTargetPath,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+				},
+				VolumeContext: testVolumeAttributes,
+			},
+			actions:       []mount.FakeAction{{Action: mount.FakeActionMount}},
+			expectedMount: &mount.MountPoint{Device: stagingTargetPath, Path: testTargetPath, Type: "nfs", Opts: []string{"bind", "ro"}},
+		},
+		{
+			name:   "read only request already mounted read only",
+			mounts: []mount.MountPoint{{Device: "/test-device", Path: testTargetPath, Opts: []string{"bind", "ro"}}},
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: stagingTargetPath,
+				TargetPath:        testTargetPath,
+				VolumeCapability:  testVolumeCapability,
+				VolumeContext:     testVolumeAttributes,
+				Readonly:          true,
+			},
+			expectedMount: &mount.MountPoint{Device: "/test-device", Path: testTargetPath, Opts: []string{"bind", "ro"}},
+		},
+		{
+			name:   "read only request already mounted read write",
+			mounts: []mount.MountPoint{{Device: "/test-device", Path: testTargetPath, Opts: []string{"bind"}}},
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: stagingTargetPath,
+				TargetPath:        testTargetPath,
+				VolumeCapability:  testVolumeCapability,
+				VolumeContext:     testVolumeAttributes,
+				Readonly:          true,
+			},
+			actions:       []mount.FakeAction{{Action: mount.FakeActionUnmount}, {Action: mount.FakeActionMount}},
+			expectedMount: &mount.MountPoint{Device: stagingTargetPath, Path: testTargetPath, Type: "nfs", Opts: []string{"bind", "ro"}},
+		},
+		{
+			name:   "read write request already mounted read only",
+			mounts: []mount.MountPoint{{Device: "/test-device", Path: testTargetPath, Opts: []string{"bind", "ro"}}},
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: stagingTargetPath,
+				TargetPath:        testTargetPath,
+				VolumeCapability:  testVolumeCapability,
+				VolumeContext:     testVolumeAttributes,
+			},
+			expectedMount: &mount.MountPoint{Device: "/test-device", Path: testTargetPath, Opts: []string{"bind", "ro"}},
+			expectErr:     true,
+		},
 		{
 			name: "empty target path",
 			req: &csi.NodePublishVolumeRequest{
@@ -235,6 +290,17 @@ func TestNodePublishVolume(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "invalid ip volume attribute",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: stagingTargetPath,
+				TargetPath:        testTargetPath,
+				VolumeCapability:  testVolumeCapability,
+				VolumeContext:     map[string]string{attrIP: "1.1.1", attrVolume: "test-volume"},
+			},
+			expectErr: true,
+		},
 		// multishare
 		{
 			name: "multishare volid request not already mounted",
@@ -344,8 +410,6 @@ func TestNodePublishVolume(t *testing.T) {
 		// 	},
 		// 	expectErr: true,
 		// },
-		// TODO add a test case for mount failure.
-		// need to modify FakeMounter to be able to fail the mount call (and unmount)
 	}
 
 	for _, test := range cases {
@@ -367,6 +431,49 @@ func TestNodePublishVolume(t *testing.T) {
 	}
 }
 
+// readOnlyRemountFailingMounter leaves the read-write bind mount of the read-only bind mounts,
+// whose read-only remount fails.
+type readOnlyRemountFailingMounter struct {
+	*mount.FakeMounter
+}
+
+func (m *readOnlyRemountFailingMounter) Mount(source, target, fstype string, options []string) error {
+	for _, opt := range options {
+		if opt == "ro" {
+			if err := m.FakeMounter.Mount(source, target, fstype, []string{"bind"}); err != nil {
+				return err
+			}
+			return fmt.Errorf("remount of %s failed", target)
+		}
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestNodePublishVolumeReadOnlyRemountFailure(t *testing.T) {
+	base := t.TempDir()
+	targetPath := filepath.Join(base, "mount")
+	stagingTargetPath := filepath.Join(base, "staging")
+	testEnv := initTestNodeServer(t)
+	testEnv.fm.MountPoints = []mount.MountPoint{{Device: "1.1.1.1:/test-volume", Path: stagingTargetPath, Type: "nfs"}}
+	testEnv.ns.(*nodeServer).mounter = &readOnlyRemountFailingMounter{FakeMounter: testEnv.fm}
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingTargetPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     testVolumeAttributes,
+		Readonly:          true,
+	}
+
+	// The retries of the publish must not find the read-write mount left by the failed one.
+	for i := 0; i < 2; i++ {
+		if _, err := testEnv.ns.NodePublishVolume(context.TODO(), req); err == nil {
+			t.Fatalf("publish %d: got success, expected the remount failure", i)
+		}
+		validateMountPoint(t, "read only remount failure", testEnv.fm, &mount.MountPoint{Device: "1.1.1.1:/test-volume", Path: stagingTargetPath, Type: "nfs"})
+	}
+}
+
 // TODO: Revisit windows tests
 func testWindowsNodePublishVolume(t *testing.T) {
 	defaultPerm := os.FileMode(0750) + os.ModeDir
@@ -570,6 +677,41 @@ func TestValidateVolumeAttributes(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "valid subdir",
+			attrs: map[string]string{
+				attrIP:     "1.1.1.1",
+				attrVolume: "vol1",
+				attrSubdir: "team-a/data",
+			},
+		},
+		{
+			name: "empty subdir",
+			attrs: map[string]string{
+				attrIP:     "1.1.1.1",
+				attrVolume: "vol1",
+				attrSubdir: "",
+			},
+			expectErr: true,
+		},
+		{
+			name: "absolute subdir",
+			attrs: map[string]string{
+				attrIP:     "1.1.1.1",
+				attrVolume: "vol1",
+				attrSubdir: "/team-a",
+			},
+			expectErr: true,
+		},
+		{
+			name: "subdir escaping the share",
+			attrs: map[string]string{
+				attrIP:     "1.1.1.1",
+				attrVolume: "vol1",
+				attrSubdir: "team-a/../../other",
+			},
+			expectErr: true,
+		},
 	}
 
 	for _, test := range cases {
@@ -583,6 +725,144 @@ func TestValidateVolumeAttributes(t *testing.T) {
 	}
 }
 
+func TestValidateVolumeContext(t *testing.T) {
+	cases := []struct {
+		name      string
+		volumeID  string
+		attrs     map[string]string
+		expectErr bool
+	}{
+		{
+			name:     "valid attributes",
+			volumeID: testVolumeID,
+			attrs: map[string]string{
+				attrIP:             "1.1.1.1",
+				attrVolume:         "vol1",
+				attrContextVersion: "1",
+			},
+		},
+		{
+			name:     "attributes without version",
+			volumeID: testVolumeID,
+			attrs: map[string]string{
+				attrIP:     "1.1.1.1",
+				attrVolume: "vol1",
+			},
+		},
+		{
+			name:     "unknown and kubernetes attributes are ignored",
+			volumeID: testVolumeID,
+			attrs: map[string]string{
+				attrIP:     "1.1.1.1",
+				attrVolume: "vol1",
+				"storage.kubernetes.io/csiProvisionerIdentity": "1234-filestore.csi.storage.gke.io",
+				"csi.storage.k8s.io/pod.name":                  "pod",
+				"unknown":                                      "value",
+			},
+		},
+		{
+			name:     "newer version",
+			volumeID: testVolumeID,
+			attrs: map[string]string{
+				attrIP:             "1.1.1.1",
+				attrVolume:         "vol1",
+				attrContextVersion: "2",
+			},
+			expectErr: true,
+		},
+		{
+			name:     "invalid version",
+			volumeID: testVolumeID,
+			attrs: map[string]string{
+				attrIP:             "1.1.1.1",
+				attrVolume:         "vol1",
+				attrContextVersion: "v1",
+			},
+			expectErr: true,
+		},
+		{
+			name:     "IPv6 address",
+			volumeID: testVolumeID,
+			attrs: map[string]string{
+				attrIP:     "2001:db8::1",
+				attrVolume: "vol1",
+			},
+			expectErr: true,
+		},
+		{
+			name:     "invalid lock release attribute",
+			volumeID: testVolumeID,
+			attrs: map[string]string{
+				attrIP:                 "1.1.1.1",
+				attrVolume:             "vol1",
+				attrSupportLockRelease: "yes",
+			},
+			expectErr: true,
+		},
+		{
+			name:     "valid multishare attributes",
+			volumeID: testMultishareVolumeID,
+			attrs: map[string]string{
+				attrIP:             "1.1.1.1",
+				attrMaxShareSize:   "107374182400",
+				attrContextVersion: "1",
+			},
+		},
+		{
+			name:     "invalid multishare max share size",
+			volumeID: testMultishareVolumeID,
+			attrs: map[string]string{
+				attrIP:           "1.1.1.1",
+				attrMaxShareSize: "100Gi",
+			},
+			expectErr: true,
+		},
+		{
+			name:      "missing multishare ip",
+			volumeID:  testMultishareVolumeID,
+			attrs:     map[string]string{},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range cases {
+		err := validateVolumeContext(test.volumeID, test.attrs)
+		if !test.expectErr && err != nil {
+			t.Errorf("test %q failed: %v", test.name, err)
+		}
+		if test.expectErr && err == nil {
+			t.Errorf("test %q failed: got success", test.name)
+		}
+	}
+}
+
+func TestNfsMountSource(t *testing.T) {
+	cases := []struct {
+		name     string
+		export   string
+		subdir   string
+		expected string
+	}{
+		{
+			name:     "no subdir",
+			export:   "vol1",
+			expected: "1.1.1.1:/vol1",
+		},
+		{
+			name:     "subdir",
+			export:   "vol1",
+			subdir:   "team-a/data/",
+			expected: "1.1.1.1:/vol1/team-a/data",
+		},
+	}
+
+	for _, test := range cases {
+		if source := nfsMountSource("1.1.1.1", test.export, test.subdir); source != test.expected {
+			t.Errorf("test %q failed: got source %q, expected %q", test.name, source, test.expected)
+		}
+	}
+}
+
 // TODO
 func TestNodeGetId(t *testing.T) {
 }
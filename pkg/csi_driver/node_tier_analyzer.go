@@ -0,0 +1,335 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// Annotations set on the PVs analyzed by the tier analyzer.
+	annotationTierRecommendation        = "filestore.csi.storage.gke.io/tier-recommendation"
+	annotationTierRecommendationDetails = "filestore.csi.storage.gke.io/tier-recommendation-details"
+
+	recommendationNone             = "none"
+	recommendationBasicHDD         = "basic-hdd-sufficient"
+	recommendationReduceCapacity   = "reduce-capacity-to-%dGi"
+	tierUnknown                    = "unknown"
+	nfsMountStatsPath              = "/proc/self/mountstats"
+	basicHDDLargeCapacityBytes     = 10 * util.Tb
+	basicHDDThroughputBytes        = 100 * util.Mb
+	basicHDDLargeThroughputBytes   = 120 * util.Mb
+	tierAnalyzerThroughputHeadroom = 0.5
+)
+
+type throughputSample struct {
+	time           time.Time
+	bytesPerSecond float64
+}
+
+type analyzedVolume struct {
+	tier       string
+	multishare bool
+	path       string
+	// since is the time the volume was first sampled, no recommendation is made before a
+	// full analysis window is observed.
+	since      time.Time
+	lastBytes  int64
+	lastSample time.Time
+	samples    []throughputSample
+	// recommendation is the last recommendation made, empty if none yet.
+	recommendation string
+}
+
+// tierAnalyzer keeps track of the volumes staged by this node driver, samples their NFS
+// throughput and capacity usage, and recommends a cheaper tier or a smaller capacity when
+// the usage observed over the analysis window allows it. The recommendations are exported
+// as metrics and as annotations on the PVs.
+type tierAnalyzer struct {
+	sync.Mutex
+	// volumes maps volume ID to its analysis.
+	volumes map[string]*analyzedVolume
+
+	nodeName       string
+	driverName     string
+	period         time.Duration
+	window         time.Duration
+	kubeClient     kubernetes.Interface
+	metricsManager *metrics.MetricsManager
+	// statFunc returns the capacity and used bytes of the filesystem mounted at path.
+	statFunc func(path string) (int64, int64, error)
+	// nfsBytesFunc returns the bytes read and written through the NFS mount at path.
+	nfsBytesFunc func(path string) (int64, error)
+	now          func() time.Time
+}
+
+func newTierAnalyzer(config *FeatureTierRecommendations, nodeName, driverName string, kubeClient kubernetes.Interface, mm *metrics.MetricsManager) *tierAnalyzer {
+	if mm != nil {
+		mm.RegisterTierRecommendationMetrics()
+	} else {
+		klog.Warningf("Tier analyzer is enabled but metrics endpoint is not configured, recommendations will only be annotated on the PVs")
+	}
+	return &tierAnalyzer{
+		volumes:        make(map[string]*analyzedVolume),
+		nodeName:       nodeName,
+		driverName:     driverName,
+		period:         config.AnalysisPeriod,
+		window:         config.AnalysisWindow,
+		kubeClient:     kubeClient,
+		metricsManager: mm,
+		statFunc: func(path string) (int64, int64, error) {
+			_, capacity, used, _, _, _, err := getFSStat(path)
+			return capacity, used, err
+		},
+		nfsBytesFunc: func(path string) (int64, error) {
+			f, err := os.Open(nfsMountStatsPath)
+			if err != nil {
+				return 0, err
+			}
+			defer f.Close()
+			return parseNFSMountStatsBytes(f, path)
+		},
+		now: time.Now,
+	}
+}
+
+// track records a volume of the given tier as staged at the given path.
+func (a *tierAnalyzer) track(volumeID, stagingTargetPath, tier string) {
+	a.Lock()
+	defer a.Unlock()
+	if v, ok := a.volumes[volumeID]; ok && v.path == stagingTargetPath {
+		return
+	}
+	if tier == "" {
+		tier = tierUnknown
+	}
+	a.volumes[volumeID] = &analyzedVolume{
+		tier:       tier,
+		multishare: isMultishareVolId(volumeID),
+		path:       stagingTargetPath,
+	}
+}
+
+// untrack stops analyzing the volume and drops its metrics.
+func (a *tierAnalyzer) untrack(volumeID string) {
+	a.Lock()
+	defer a.Unlock()
+	v, ok := a.volumes[volumeID]
+	if !ok {
+		return
+	}
+	delete(a.volumes, volumeID)
+	if a.metricsManager != nil {
+		a.metricsManager.DeleteTierRecommendationMetrics(volumeID, v.tier, v.recommendation, true)
+	}
+}
+
+// Run analyzes all staged volumes every period until stopCh is closed.
+func (a *tierAnalyzer) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting tier analyzer with analysis period %v and window %v", a.period, a.window)
+	wait.Until(func() {
+		a.analyzeAll(context.Background())
+	}, a.period, stopCh)
+}
+
+func (a *tierAnalyzer) analyzeAll(ctx context.Context) {
+	a.Lock()
+	paths := make(map[string]string, len(a.volumes))
+	for volumeID, v := range a.volumes {
+		paths[volumeID] = v.path
+	}
+	a.Unlock()
+
+	// changed maps volume ID to the details of its new recommendation.
+	changed := make(map[string]string)
+	for volumeID, path := range paths {
+		// A hung mount blocks the analysis until it recovers, the mount health reporter
+		// reports it in the meantime.
+		capacity, used, err := a.statFunc(path)
+		if err != nil {
+			klog.Warningf("Tier analyzer failed to stat volume %s on path %s: %v", volumeID, path, err)
+			continue
+		}
+		nfsBytes, err := a.nfsBytesFunc(path)
+		if err != nil {
+			klog.Warningf("Tier analyzer failed to get the NFS statistics of volume %s on path %s: %v", volumeID, path, err)
+			continue
+		}
+		if details, ok := a.record(volumeID, path, capacity, used, nfsBytes); ok {
+			changed[volumeID] = details
+		}
+	}
+	if len(changed) > 0 {
+		if err := a.annotatePVs(ctx, changed); err != nil {
+			klog.Errorf("Tier analyzer failed to annotate the PVs: %v", err)
+		}
+	}
+}
+
+// record adds a sample to the analysis of a volume. It returns the details of the
+// recommendation if it changed.
+func (a *tierAnalyzer) record(volumeID, path string, capacity, used, nfsBytes int64) (string, bool) {
+	a.Lock()
+	defer a.Unlock()
+	// The volume may have been unstaged while it was sampled.
+	v, ok := a.volumes[volumeID]
+	if !ok || v.path != path {
+		return "", false
+	}
+	now := a.now()
+	if v.since.IsZero() {
+		v.since = now
+	} else if nfsBytes >= v.lastBytes && now.After(v.lastSample) {
+		v.samples = append(v.samples, throughputSample{
+			time:           now,
+			bytesPerSecond: float64(nfsBytes-v.lastBytes) / now.Sub(v.lastSample).Seconds(),
+		})
+	}
+	v.lastBytes, v.lastSample = nfsBytes, now
+	for len(v.samples) > 0 && now.Sub(v.samples[0].time) > a.window {
+		v.samples = v.samples[1:]
+	}
+	if now.Sub(v.since) < a.window {
+		return "", false
+	}
+
+	var peak float64
+	for _, s := range v.samples {
+		if s.bytesPerSecond > peak {
+			peak = s.bytesPerSecond
+		}
+	}
+	recommendation := recommendTier(v.tier, v.multishare, capacity, used, peak)
+	if a.metricsManager != nil {
+		if v.recommendation != "" && v.recommendation != recommendation {
+			a.metricsManager.DeleteTierRecommendationMetrics(volumeID, v.tier, v.recommendation, false)
+		}
+		a.metricsManager.RecordTierRecommendationMetrics(volumeID, v.tier, recommendation, peak)
+	}
+	if recommendation == v.recommendation {
+		return "", false
+	}
+	klog.Infof("Tier recommendation for volume %s of tier %s changed from %q to %q", volumeID, v.tier, v.recommendation, recommendation)
+	v.recommendation = recommendation
+	return fmt.Sprintf("observed by node %s over %v: peak throughput %.1fMiB/s, %dGi used of %dGi", a.nodeName, a.window, peak/util.Mb, used/util.Gb, capacity/util.Gb), true
+}
+
+// annotatePVs sets the recommendation annotations on the PVs of the changed volumes.
+func (a *tierAnalyzer) annotatePVs(ctx context.Context, changed map[string]string) error {
+	pvs, err := a.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != a.driverName {
+			continue
+		}
+		details, ok := changed[pv.Spec.CSI.VolumeHandle]
+		if !ok {
+			continue
+		}
+		a.Lock()
+		v, ok := a.volumes[pv.Spec.CSI.VolumeHandle]
+		var recommendation string
+		if ok {
+			recommendation = v.recommendation
+		}
+		a.Unlock()
+		if !ok {
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{
+					annotationTierRecommendation:        recommendation,
+					annotationTierRecommendationDetails: details,
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := a.kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			klog.Errorf("Tier analyzer failed to annotate PV %s: %v", pv.Name, err)
+		}
+	}
+	return nil
+}
+
+// recommendTier returns the comma separated right-sizing recommendations for a volume of
+// the given tier, capacity, used bytes and peak throughput, or "none" if it is right-sized.
+//   - A premium or basic SSD volume whose peak throughput is well within the basic HDD throughput is
+//     recommended to use the basic HDD tier.
+//   - A volume using less than a quarter of its capacity is recommended to use half of the
+//     capacity or less, twice its used bytes but no less than the minimum size of the tier.
+func recommendTier(tier string, multishare bool, capacity, used int64, peakThroughput float64) string {
+	var recommendations []string
+	if tier == premiumTier || tier == basicSSDTier {
+		maxThroughput := basicHDDThroughputBytes
+		if capacity >= basicHDDLargeCapacityBytes {
+			maxThroughput = basicHDDLargeThroughputBytes
+		}
+		if peakThroughput < tierAnalyzerThroughputHeadroom*float64(maxThroughput) {
+			recommendations = append(recommendations, recommendationBasicHDD)
+		}
+	}
+
+	minCapacity := provisionableCapacityForTier(tier).min
+	if multishare {
+		minCapacity = util.MinShareSizeBytes
+	}
+	if used*4 < capacity {
+		target := util.Max(minCapacity, util.GbToBytes(util.RoundBytesToGb(2*used)))
+		if 2*target <= capacity {
+			recommendations = append(recommendations, fmt.Sprintf(recommendationReduceCapacity, util.BytesToGb(target)))
+		}
+	}
+
+	if len(recommendations) == 0 {
+		return recommendationNone
+	}
+	return strings.Join(recommendations, ",")
+}
+
+// parseNFSMountStatsBytes returns the bytes read and written by the applications through the
+// NFS mount at mountPoint, from the content of /proc/self/mountstats.
+func parseNFSMountStatsBytes(r io.Reader, mountPoint string) (int64, error) {
+	stats, err := parseNFSMountStats(r)
+	if err != nil {
+		return 0, err
+	}
+	s, ok := stats[mountPoint]
+	if !ok {
+		return 0, fmt.Errorf("no NFS byte statistics found for mount %s", mountPoint)
+	}
+	return s.ReadBytes + s.WriteBytes, nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestRecommendTier(t *testing.T) {
+	cases := []struct {
+		name           string
+		tier           string
+		multishare     bool
+		capacity       int64
+		used           int64
+		peakThroughput float64
+		expected       string
+	}{
+		{
+			name:           "standard right-sized",
+			tier:           defaultTier,
+			capacity:       1 * util.Tb,
+			used:           10 * util.Gb,
+			peakThroughput: 50 * util.Mb,
+			expected:       recommendationNone,
+		},
+		{
+			name:           "standard over-provisioned",
+			tier:           defaultTier,
+			capacity:       4 * util.Tb,
+			used:           100 * util.Gb,
+			peakThroughput: 50 * util.Mb,
+			expected:       "reduce-capacity-to-1024Gi",
+		},
+		{
+			name:           "basic ssd with low throughput",
+			tier:           basicSSDTier,
+			capacity:       25 * util.Tb / 10,
+			used:           2 * util.Tb,
+			peakThroughput: 10 * util.Mb,
+			expected:       recommendationBasicHDD,
+		},
+		{
+			name:           "basic ssd with high throughput",
+			tier:           basicSSDTier,
+			capacity:       25 * util.Tb / 10,
+			used:           2 * util.Tb,
+			peakThroughput: 80 * util.Mb,
+			expected:       recommendationNone,
+		},
+		{
+			name:           "large premium with low throughput over-provisioned",
+			tier:           premiumTier,
+			capacity:       10 * util.Tb,
+			used:           1 * util.Tb,
+			peakThroughput: 55 * util.Mb,
+			expected:       "basic-hdd-sufficient,reduce-capacity-to-2560Gi",
+		},
+		{
+			name:           "multishare over-provisioned",
+			tier:           enterpriseTier,
+			multishare:     true,
+			capacity:       500 * util.Gb,
+			used:           10 * util.Gb,
+			peakThroughput: 10 * util.Mb,
+			expected:       "reduce-capacity-to-100Gi",
+		},
+		{
+			name:           "unknown tier over-provisioned",
+			tier:           tierUnknown,
+			capacity:       4 * util.Tb,
+			used:           600 * util.Gb,
+			peakThroughput: 10 * util.Mb,
+			expected:       "reduce-capacity-to-1200Gi",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := recommendTier(tc.tier, tc.multishare, tc.capacity, tc.used, tc.peakThroughput)
+			if got != tc.expected {
+				t.Errorf("got recommendation %q, expected %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseNFSMountStatsBytes(t *testing.T) {
+	mountStats := `device rootfs mounted on / with fstype rootfs
+device 10.0.0.2:/vol1 mounted on /staging/vol1 with fstype nfs statvers=1.1
+	opts:	rw,vers=3,rsize=1048576,wsize=1048576
+	bytes:	100 200 30 40 500 600 7 8
+	RPC iostats version: 1.1  p/v: 100003/3 (nfs)
+device 10.0.0.3:/vol2 mounted on /staging/vol2 with fstype nfs statvers=1.1
+	bytes:	1 2 3 4 5 6 7 8
+device 10.0.0.4:/vol3 mounted on /staging/vol3 with fstype nfs statvers=1.1
+	bytes:	1 2 x 4 5 6 7 8
+`
+	cases := []struct {
+		mountPoint string
+		expected   int64
+		expectErr  bool
+	}{
+		{mountPoint: "/staging/vol1", expected: 370},
+		{mountPoint: "/staging/vol2", expected: 10},
+		{mountPoint: "/staging/vol3", expectErr: true},
+		{mountPoint: "/staging/vol4", expectErr: true},
+		{mountPoint: "/", expectErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.mountPoint, func(t *testing.T) {
+			got, err := parseNFSMountStatsBytes(strings.NewReader(mountStats), tc.mountPoint)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("got %d bytes, expected %d", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTierAnalyzer(t *testing.T) {
+	volumeID := modeInstance + "/" + testZone + "/test-instance/vol1"
+	kubeClient := fake.NewSimpleClientset(testPV("pv-1", testDriverName, volumeID, "pvc-1"))
+	a := newTierAnalyzer(&FeatureTierRecommendations{
+		Enabled:        true,
+		AnalysisPeriod: time.Minute,
+		AnalysisWindow: time.Hour,
+	}, "test-node", testDriverName, kubeClient, nil)
+
+	now := time.Now()
+	var nfsBytes int64
+	a.now = func() time.Time { return now }
+	a.statFunc = func(path string) (int64, int64, error) { return 6 * util.Tb, 100 * util.Gb, nil }
+	a.nfsBytesFunc = func(path string) (int64, error) { return nfsBytes, nil }
+	a.track(volumeID, "/staging/vol1", basicSSDTier)
+
+	pvAnnotation := func() string {
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get PV: %v", err)
+		}
+		return pv.Annotations[annotationTierRecommendation]
+	}
+
+	// No recommendation before a full window is observed.
+	for i := 0; i < 60; i++ {
+		a.analyzeAll(context.Background())
+		now = now.Add(time.Minute)
+		nfsBytes += 60 * util.Mb
+	}
+	if got := pvAnnotation(); got != "" {
+		t.Errorf("got recommendation %q before the end of the analysis window, expected none", got)
+	}
+
+	// Peak throughput of 1MiB/s.
+	a.analyzeAll(context.Background())
+	if got, expected := pvAnnotation(), "basic-hdd-sufficient,reduce-capacity-to-2560Gi"; got != expected {
+		t.Errorf("got recommendation %q, expected %q", got, expected)
+	}
+
+	// Peak throughput of 100MiB/s.
+	now = now.Add(time.Minute)
+	nfsBytes += 6000 * util.Mb
+	a.analyzeAll(context.Background())
+	if got, expected := pvAnnotation(), "reduce-capacity-to-2560Gi"; got != expected {
+		t.Errorf("got recommendation %q, expected %q", got, expected)
+	}
+
+	a.untrack(volumeID)
+	if len(a.volumes) != 0 {
+		t.Errorf("expected no volume analyzed after untrack, got %d", len(a.volumes))
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// The StorageClass parameters overriding the deadlines of the waits on the operations creating
+// the instance and the share of a volume.
+const (
+	paramInstanceCreateTimeout = "instance-create-timeout"
+	paramShareCreateTimeout    = "share-create-timeout"
+)
+
+// OpTimeouts are the deadlines of the waits of the controller on the Filestore operations
+// creating instances, creating shares and expanding instances and shares, e.g. to return
+// before the timeout of the sidecars on the creations of enterprise instances. A wait ending
+// on its deadline fails the request with DeadlineExceeded while the operation keeps running,
+// and the retries of the request resume the wait. A zero deadline keeps the built-in deadline
+// of the operation.
+type OpTimeouts struct {
+	InstanceCreate time.Duration
+	ShareCreate    time.Duration
+	// Expand is also the deadline of the expansions of the multishare instances making room for
+	// new shares.
+	Expand time.Duration
+}
+
+// NewOpTimeouts validates the deadlines of the driver flags, and returns nil if none is set.
+func NewOpTimeouts(instanceCreate, shareCreate, expand time.Duration) (*OpTimeouts, error) {
+	if instanceCreate < 0 || shareCreate < 0 || expand < 0 {
+		return nil, fmt.Errorf("operation timeouts can't be negative")
+	}
+	if instanceCreate == 0 && shareCreate == 0 && expand == 0 {
+		return nil, nil
+	}
+	return &OpTimeouts{InstanceCreate: instanceCreate, ShareCreate: shareCreate, Expand: expand}, nil
+}
+
+// forOp returns the deadline of the waits on the operations of the type, zero if not set.
+func (t *OpTimeouts) forOp(opType util.OperationType) time.Duration {
+	if t == nil {
+		return 0
+	}
+	switch opType {
+	case util.InstanceCreate:
+		return t.InstanceCreate
+	case util.ShareCreate:
+		return t.ShareCreate
+	case util.InstanceUpdate, util.ShareUpdate:
+		return t.Expand
+	}
+	return 0
+}
+
+// withParams returns the deadlines overridden by the StorageClass parameters of a volume.
+func (t *OpTimeouts) withParams(params map[string]string) (*OpTimeouts, error) {
+	var timeouts OpTimeouts
+	if t != nil {
+		timeouts = *t
+	}
+	overridden := false
+	for k, v := range params {
+		var timeout *time.Duration
+		switch strings.ToLower(k) {
+		case paramInstanceCreateTimeout:
+			timeout = &timeouts.InstanceCreate
+		case paramShareCreateTimeout:
+			timeout = &timeouts.ShareCreate
+		default:
+			continue
+		}
+		d, err := parseOpTimeoutParam(k, v)
+		if err != nil {
+			return nil, err
+		}
+		*timeout = d
+		overridden = true
+	}
+	if !overridden {
+		return t, nil
+	}
+	return &timeouts, nil
+}
+
+// parseOpTimeoutParam parses the value of a timeout StorageClass parameter, a positive duration.
+func parseOpTimeoutParam(key, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid value %q for parameter %q, expected a positive duration, e.g. 20m", value, key)
+	}
+	return d, nil
+}
+
+// withOpTimeout returns the context of the waits on the operations of the type, with their
+// deadline if set.
+func withOpTimeout(ctx context.Context, timeouts *OpTimeouts, opType util.OperationType) context.Context {
+	if timeout := timeouts.forOp(opType); timeout > 0 {
+		return file.WithOpWaitTimeout(ctx, timeout)
+	}
+	return ctx
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// pendingCreateService creates the instances in the CREATING state, and ends the wait on their
+// creation with an OpPendingError, recording the timeouts of the waits.
+type pendingCreateService struct {
+	file.Service
+	created  []*file.ServiceInstance
+	timeouts []time.Duration
+}
+
+func (s *pendingCreateService) CreateInstance(ctx context.Context, obj *file.ServiceInstance) (*file.ServiceInstance, error) {
+	s.timeouts = append(s.timeouts, file.OpWaitTimeout(ctx, 0))
+	instance, err := s.Service.CreateInstance(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	instance.State = "CREATING"
+	s.created = append(s.created, instance)
+	return nil, &file.OpPendingError{OpName: "op-create", Err: wait.ErrWaitTimeout}
+}
+
+func TestOpTimeoutsWithParams(t *testing.T) {
+	driverTimeouts := &OpTimeouts{InstanceCreate: 10 * time.Minute, Expand: 5 * time.Minute}
+	cases := []struct {
+		name     string
+		timeouts *OpTimeouts
+		params   map[string]string
+		expected *OpTimeouts
+		errorExp bool
+	}{
+		{
+			name:   "no timeouts",
+			params: map[string]string{paramTier: enterpriseTier},
+		},
+		{
+			name:     "driver timeouts",
+			timeouts: driverTimeouts,
+			params:   map[string]string{},
+			expected: driverTimeouts,
+		},
+		{
+			name:     "parameters override the driver timeouts",
+			timeouts: driverTimeouts,
+			params:   map[string]string{"Instance-Create-Timeout": "20m", paramShareCreateTimeout: "90s"},
+			expected: &OpTimeouts{InstanceCreate: 20 * time.Minute, ShareCreate: 90 * time.Second, Expand: 5 * time.Minute},
+		},
+		{
+			name:     "invalid duration",
+			params:   map[string]string{paramInstanceCreateTimeout: "20"},
+			errorExp: true,
+		},
+		{
+			name:     "negative duration",
+			params:   map[string]string{paramShareCreateTimeout: "-1m"},
+			errorExp: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			timeouts, err := tc.timeouts.withParams(tc.params)
+			if tc.errorExp {
+				if err == nil {
+					t.Errorf("expected error, got timeouts %+v", timeouts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(timeouts, tc.expected) {
+				t.Errorf("got timeouts %+v, expected %+v", timeouts, tc.expected)
+			}
+		})
+	}
+}
+
+func TestOpTimeoutsForOp(t *testing.T) {
+	timeouts := &OpTimeouts{InstanceCreate: time.Hour, ShareCreate: time.Minute, Expand: time.Second}
+	for opType, expected := range map[util.OperationType]time.Duration{
+		util.InstanceCreate: time.Hour,
+		util.ShareCreate:    time.Minute,
+		util.InstanceUpdate: time.Second,
+		util.ShareUpdate:    time.Second,
+		util.InstanceDelete: 0,
+	} {
+		if timeout := timeouts.forOp(opType); timeout != expected {
+			t.Errorf("got timeout %v for op type %v, expected %v", timeout, opType, expected)
+		}
+		ctx := withOpTimeout(context.Background(), timeouts, opType)
+		if timeout := file.OpWaitTimeout(ctx, 42); (expected == 0 && timeout != 42) || (expected != 0 && timeout != expected) {
+			t.Errorf("got wait timeout %v for op type %v, expected %v", timeout, opType, expected)
+		}
+	}
+}
+
+func TestCreateVolumeResumesPendingInstanceCreation(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	cs.config.opTimeouts = &OpTimeouts{InstanceCreate: 10 * time.Minute}
+	fs := &pendingCreateService{Service: cs.config.fileService}
+	cs.config.fileService = fs
+	cs.config.cloud.File = fs
+
+	req := &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		Parameters:         map[string]string{paramInstanceCreateTimeout: "20m"},
+	}
+
+	// The wait on the creation ends on the deadline of the StorageClass.
+	_, err := cs.CreateVolume(context.Background(), req)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded error, got %v", err)
+	}
+	if !reflect.DeepEqual(fs.timeouts, []time.Duration{20 * time.Minute}) {
+		t.Errorf("got wait timeouts %v, expected [20m]", fs.timeouts)
+	}
+
+	// The retry finds the instance still being created, without creating another instance.
+	_, err = cs.CreateVolume(context.Background(), req)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded error on retry, got %v", err)
+	}
+	if len(fs.created) != 1 {
+		t.Fatalf("got %d instance creations, expected 1", len(fs.created))
+	}
+
+	// The retry succeeds once the instance is ready.
+	fs.created[0].State = "READY"
+	resp, err := cs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error once the instance is ready: %v", err)
+	}
+	if resp.GetVolume().GetVolumeId() != testVolumeID {
+		t.Errorf("got volume ID %q, expected %q", resp.GetVolume().GetVolumeId(), testVolumeID)
+	}
+	if len(fs.created) != 1 {
+		t.Errorf("got %d instance creations, expected 1", len(fs.created))
+	}
+}
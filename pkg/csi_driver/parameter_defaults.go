@@ -0,0 +1,133 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+// knownTiers are the tiers accepted as the default tier of the driver.
+var knownTiers = []string{defaultTier, enterpriseTier, premiumTier, basicHDDTier, basicSSDTier, highScaleTier, zonalTier}
+
+// ParameterDefaults are the values of the tier, network and connect-mode StorageClass parameters
+// of the volumes whose StorageClass omits them, set per driver deployment, e.g. so that the
+// volumes of a platform are enterprise instances on its shared VPC by default. An empty value
+// keeps the built-in default of the parameter.
+type ParameterDefaults struct {
+	Tier        string
+	Network     string
+	ConnectMode string
+}
+
+// NewParameterDefaults validates the parameter defaults of the driver flags, and returns nil if
+// none is set.
+func NewParameterDefaults(tier, network, connectMode string) (*ParameterDefaults, error) {
+	if tier == "" && network == "" && connectMode == "" {
+		return nil, nil
+	}
+	d := &ParameterDefaults{Tier: strings.ToLower(tier), Network: network, ConnectMode: strings.ToUpper(connectMode)}
+	if d.Tier != "" {
+		known := false
+		for _, t := range knownTiers {
+			known = known || d.Tier == t
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown default tier %q, expected one of %s", tier, strings.Join(knownTiers, ", "))
+		}
+	}
+	if d.ConnectMode != "" && d.ConnectMode != directPeering && d.ConnectMode != privateServiceAccess {
+		return nil, fmt.Errorf("default connect mode can only be one of %q or %q", directPeering, privateServiceAccess)
+	}
+	return d, nil
+}
+
+// apply returns the request with the defaults merged into the parameters it omits, matched
+// case-insensitively, and the names of the defaulted parameters. The request is returned
+// unchanged if no parameter is defaulted. The default tier is not applied to the multishare
+// volumes and to the file shares of a parent instance, whose tier is enterprise.
+func (d *ParameterDefaults) apply(req *csi.CreateVolumeRequest) (*csi.CreateVolumeRequest, []string) {
+	if d == nil {
+		return req, nil
+	}
+	params := req.GetParameters()
+	multishare := strings.EqualFold(params[paramMultishare], "true")
+	_, hasParent := lookupParam(params, paramParentInstance)
+
+	var defaulted []string
+	merged := make(map[string]string, len(params)+3)
+	for k, v := range params {
+		merged[k] = v
+	}
+	for _, p := range []struct{ key, value string }{
+		{paramTier, d.Tier},
+		{paramNetwork, d.Network},
+		{ParamConnectMode, d.ConnectMode},
+	} {
+		if p.value == "" || (p.key == paramTier && (multishare || hasParent)) {
+			continue
+		}
+		if _, ok := lookupParam(params, p.key); ok {
+			continue
+		}
+		merged[p.key] = p.value
+		defaulted = append(defaulted, p.key)
+	}
+	if len(defaulted) == 0 {
+		return req, nil
+	}
+	// The request is compared with the retries joining it, it is not modified.
+	req = proto.Clone(req).(*csi.CreateVolumeRequest)
+	req.Parameters = merged
+	return req, defaulted
+}
+
+// withParameterDefaults returns the request with the parameter defaults of the driver, and
+// logs the effective tier, network and connect mode of the volume.
+func (s *controllerServer) withParameterDefaults(req *csi.CreateVolumeRequest) *csi.CreateVolumeRequest {
+	if s.config.parameterDefaults == nil {
+		return req
+	}
+	req, defaulted := s.config.parameterDefaults.apply(req)
+	params := req.GetParameters()
+	tier := effectiveTier(params)
+	network, ok := lookupParam(params, paramNetwork)
+	if !ok {
+		network = defaultNetwork
+	}
+	connectMode, ok := lookupParam(params, ParamConnectMode)
+	if !ok {
+		connectMode = directPeering
+	}
+	klog.Infof("Effective parameters of volume %s: tier=%s network=%s connect-mode=%s, defaulted by the driver: %v", req.GetName(), tier, network, connectMode, defaulted)
+	return req
+}
+
+// lookupParam returns the value of a StorageClass parameter, whose key is matched
+// case-insensitively.
+func lookupParam(params map[string]string, key string) (string, bool) {
+	for k, v := range params {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
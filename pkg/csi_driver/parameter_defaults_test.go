@@ -0,0 +1,183 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+func TestNewParameterDefaults(t *testing.T) {
+	cases := []struct {
+		name                       string
+		tier, network, connectMode string
+		expected                   *ParameterDefaults
+		expectErr                  bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:        "all",
+			tier:        "Enterprise",
+			network:     "shared-vpc",
+			connectMode: "private_service_access",
+			expected:    &ParameterDefaults{Tier: enterpriseTier, Network: "shared-vpc", ConnectMode: privateServiceAccess},
+		},
+		{
+			name:     "network only",
+			network:  "shared-vpc",
+			expected: &ParameterDefaults{Network: "shared-vpc"},
+		},
+		{
+			name:      "unknown tier",
+			tier:      "gold",
+			expectErr: true,
+		},
+		{
+			name:        "unknown connect mode",
+			connectMode: "VPN",
+			expectErr:   true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := NewParameterDefaults(tc.tier, tc.network, tc.connectMode)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got defaults %+v", d)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(d, tc.expected) {
+				t.Errorf("got defaults %+v, expected %+v", d, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParameterDefaultsApply(t *testing.T) {
+	defaults := &ParameterDefaults{Tier: enterpriseTier, Network: "shared-vpc", ConnectMode: privateServiceAccess}
+	cases := []struct {
+		name              string
+		defaults          *ParameterDefaults
+		params            map[string]string
+		expectedParams    map[string]string
+		expectedDefaulted []string
+	}{
+		{
+			name:           "no defaults",
+			params:         map[string]string{paramTier: premiumTier},
+			expectedParams: map[string]string{paramTier: premiumTier},
+		},
+		{
+			name:     "all defaulted",
+			defaults: defaults,
+			expectedParams: map[string]string{
+				paramTier:        enterpriseTier,
+				paramNetwork:     "shared-vpc",
+				ParamConnectMode: privateServiceAccess,
+			},
+			expectedDefaulted: []string{paramTier, paramNetwork, ParamConnectMode},
+		},
+		{
+			name:     "set parameters kept, case-insensitively",
+			defaults: defaults,
+			params:   map[string]string{"Tier": premiumTier, paramNetwork: "other-vpc"},
+			expectedParams: map[string]string{
+				"Tier":           premiumTier,
+				paramNetwork:     "other-vpc",
+				ParamConnectMode: privateServiceAccess,
+			},
+			expectedDefaulted: []string{ParamConnectMode},
+		},
+		{
+			name:     "multishare tier not defaulted",
+			defaults: defaults,
+			params:   map[string]string{paramMultishare: "True"},
+			expectedParams: map[string]string{
+				paramMultishare:  "True",
+				paramNetwork:     "shared-vpc",
+				ParamConnectMode: privateServiceAccess,
+			},
+			expectedDefaulted: []string{paramNetwork, ParamConnectMode},
+		},
+		{
+			name:     "parent instance tier not defaulted",
+			defaults: &ParameterDefaults{Tier: enterpriseTier},
+			params:   map[string]string{paramParentInstance: "us-central1/parent"},
+			expectedParams: map[string]string{
+				paramParentInstance: "us-central1/parent",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &csi.CreateVolumeRequest{Name: testCSIVolume, Parameters: tc.params}
+			original := map[string]string{}
+			for k, v := range tc.params {
+				original[k] = v
+			}
+			got, defaulted := tc.defaults.apply(req)
+			if !reflect.DeepEqual(got.GetParameters(), tc.expectedParams) && !(len(got.GetParameters()) == 0 && len(tc.expectedParams) == 0) {
+				t.Errorf("got parameters %v, expected %v", got.GetParameters(), tc.expectedParams)
+			}
+			if !reflect.DeepEqual(defaulted, tc.expectedDefaulted) {
+				t.Errorf("got defaulted parameters %v, expected %v", defaulted, tc.expectedDefaulted)
+			}
+			if len(req.GetParameters()) != len(original) || (len(original) > 0 && !reflect.DeepEqual(req.GetParameters(), original)) {
+				t.Errorf("request parameters modified to %v, expected %v", req.GetParameters(), original)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeParameterDefaults(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	cs.config.parameterDefaults = &ParameterDefaults{Tier: premiumTier, Network: "shared-vpc"}
+
+	_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+		Parameters:         map[string]string{"Network": "team-vpc"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	instance, err := cs.config.fileService.GetInstance(context.Background(), &file.ServiceInstance{Project: testProject, Location: testLocation, Name: testCSIVolume})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if instance.Tier != premiumTier {
+		t.Errorf("got tier %q, expected the default tier %q", instance.Tier, premiumTier)
+	}
+	if instance.Network.Name != "team-vpc" {
+		t.Errorf("got network %q, expected the network of the StorageClass", instance.Network.Name)
+	}
+}
@@ -468,6 +468,7 @@ func (recon *MultishareReconciler) generateNewMultishareInstance(instanceInfo *v
 		case ParamInstanceEncryptionKmsKey:
 			kmsKeyName = v
 		case ParamReservedIPV4CIDR, ParamReservedIPRange:
+		case paramMinInstanceSize, paramMaxInstanceSize, paramShareSpreadByNamespace, paramAllowNewInstances:
 		case cloud.ParameterKeyResourceTags:
 		case ParamMultishareInstanceScLabel, ParameterKeyLabels, ParameterKeyPVCName, ParameterKeyPVCNamespace, ParameterKeyPVName, paramMultishare:
 		case "csiprovisionersecretname", "csiprovisionersecretnamespace":
@@ -476,19 +477,24 @@ func (recon *MultishareReconciler) generateNewMultishareInstance(instanceInfo *v
 		}
 	}
 
-	clusterLocation := recon.cloud.Zone
-	if recon.config.IsRegional {
-		var err error
-		clusterLocation, err = util.GetRegionFromZone(clusterLocation)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get region for regional cluster: %v", err.Error())
+	if kmsKeyName != "" {
+		if kmsKeyName, err = normalizeKmsKeyName(kmsKeyName, instanceRegion); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 	}
 
-	labels, err := extractInstanceLabels(params, recon.config.ExtraVolumeLabels, recon.config.Name, recon.config.ClusterName, clusterLocation)
+	clusterLocation, err := getClusterLocation(recon.cloud.Zone, recon.config.IsRegional, recon.config.ClusterLocation)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get region for regional cluster: %v", err.Error())
+	}
+
+	labels, err := extractInstanceLabels(params, recon.config.ExtraVolumeLabels, recon.config.Name, recon.config.ClusterName, clusterLocation, "")
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, err.Error())
 	}
+	for k, v := range instanceOwnershipLabels(recon.config.Version, recon.config.ClusterUID, params) {
+		labels[k] = v
+	}
 
 	instance := &file.MultishareInstance{
 		Project:       project,
@@ -524,7 +530,7 @@ func (recon *MultishareReconciler) generateNewMultishareInstance(instanceInfo *v
 		} else {
 			klog.Infof("instanceInfo %s doesn't already have cidr, reserving IP range", instanceInfo.Name)
 			var err error
-			reservedIPRange, err = recon.controllerServer.reserveIPRange(context.TODO(), &file.ServiceInstance{
+			reservedIPRange, err = recon.controllerServer.reserveIPRange(context.TODO(), recon.controllerServer.config.fileService, &file.ServiceInstance{
 				Project:  instance.Project,
 				Name:     instance.Name,
 				Location: instance.Location,
@@ -741,6 +747,10 @@ func (recon *MultishareReconciler) generateInstanceInfo(instanceURI string, scTa
 	if err != nil {
 		return nil, err
 	}
+	minInstanceSizeBytes, _, err := parseInstanceSizeBounds(shareParams)
+	if err != nil {
+		return nil, err
+	}
 	newInstanceInfo := &v1.InstanceInfo{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:       util.InstanceURIToInstanceInfoName(instanceURI),
@@ -750,7 +760,7 @@ func (recon *MultishareReconciler) generateInstanceInfo(instanceURI string, scTa
 			},
 		},
 		Spec: v1.InstanceInfoSpec{
-			CapacityBytes:    util.MinMultishareInstanceSizeBytes,
+			CapacityBytes:    minInstanceSizeBytes,
 			StorageClassName: storageClass.Name,
 			Parameters:       shareParams,
 		},
@@ -970,11 +980,6 @@ func (recon *MultishareReconciler) instanceInfoNewCapacity(instanceInfoClone *v1
 	if instanceInfoClone.Status == nil || len(instanceInfoClone.Status.ShareNames) == 0 {
 		return instanceInfoClone, false
 	}
-	// if we don't know what's the step size, use the min instance size as step size.
-	stepSizeGb := util.DefaultStepSizeGb
-	if instanceInfoClone.Status.CapacityStepSizeGb != 0 {
-		stepSizeGb = instanceInfoClone.Status.CapacityStepSizeGb
-	}
 	var targetInstanceSizeByte int64 = 0
 	for _, shareName := range instanceInfoClone.Status.ShareNames {
 		shareInfo, err := recon.shareLister.ShareInfos(util.ManagedFilestoreCSINamespace).Get(shareName)
@@ -984,11 +989,14 @@ func (recon *MultishareReconciler) instanceInfoNewCapacity(instanceInfoClone *v1
 		}
 		targetInstanceSizeByte += shareInfo.Spec.CapacityBytes
 	}
-	targetInstanceSizeByte = util.AlignBytes(targetInstanceSizeByte, util.GbToBytes(stepSizeGb))
-
-	// bound InstanceSizeByte to max and min of Multishare instance size
-	targetInstanceSizeByte = util.Max(targetInstanceSizeByte, util.MinMultishareInstanceSizeBytes)
-	targetInstanceSizeByte = util.Min(targetInstanceSizeByte, util.MaxMultishareInstanceSizeBytes)
+	// bound InstanceSizeByte to max and min of Multishare instance size, aligned to the step
+	// size, the min instance size being used as step size if we don't know it.
+	minInstanceSizeBytes, maxInstanceSizeBytes, err := parseInstanceSizeBounds(instanceInfoClone.Spec.Parameters)
+	if err != nil {
+		klog.Warningf("Invalid instance size bounds of instanceInfo %q, using the tier limits: %v", instanceInfoClone.Name, err)
+		minInstanceSizeBytes, maxInstanceSizeBytes = util.MinMultishareInstanceSizeBytes, util.MaxMultishareInstanceSizeBytes
+	}
+	targetInstanceSizeByte = util.AlignInstanceCapacityBytes(targetInstanceSizeByte, instanceInfoClone.Status.CapacityStepSizeGb, minInstanceSizeBytes, maxInstanceSizeBytes)
 
 	if targetInstanceSizeByte == instanceInfoClone.Spec.CapacityBytes {
 		return instanceInfoClone, false
@@ -1165,13 +1173,9 @@ func (recon *MultishareReconciler) managedInstanceAndShare(instances []*file.Mul
 	var managedShares []*file.Share
 
 	instanceShare := make(map[string][]*file.Share)
-	clusterLocation := recon.cloud.Zone
-	if recon.config.IsRegional {
-		var err error
-		clusterLocation, err = util.GetRegionFromZone(clusterLocation)
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to get region for regional cluster: %v", err.Error())
-		}
+	clusterLocation, err := getClusterLocation(recon.cloud.Zone, recon.config.IsRegional, recon.config.ClusterLocation)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get region for regional cluster: %v", err.Error())
 	}
 
 	for _, instance := range instances {
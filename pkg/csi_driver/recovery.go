@@ -0,0 +1,62 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+// newRecoveryInterceptor returns an interceptor failing the RPCs whose handler panics with
+// Internal, instead of crashing the driver, e.g. on a malformed request. The stack of the panic
+// is logged, not returned to the caller. The errors of the handlers which are not status
+// errors, e.g. the wrapped errors of the Filestore API, are converted to status errors with
+// the code of their cause, and the duration of every RPC is recorded by method if mm is
+// non-nil.
+func newRecoveryInterceptor(mm *metrics.MetricsManager) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		start := time.Now()
+		defer func() {
+			if r := recover(); r != nil {
+				klog.Errorf("Recovered from panic in %s: %v\n%s", info.FullMethod, r, debug.Stack())
+				resp, err = nil, status.Errorf(codes.Internal, "%s failed on an internal error of the driver, see the driver logs", info.FullMethod)
+			} else if err != nil {
+				err = normalizeError(err)
+			}
+			if mm != nil {
+				mm.RecordRPCMetrics(err, info.FullMethod, time.Since(start))
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// normalizeError returns err as a status error, with the code of its cause if it is not one.
+func normalizeError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return file.StatusError(err)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+func TestRecoveryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	cases := []struct {
+		name         string
+		handler      grpc.UnaryHandler
+		expectedCode codes.Code
+		expectedResp interface{}
+	}{
+		{
+			name: "success",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) {
+				return "resp", nil
+			},
+			expectedCode: codes.OK,
+			expectedResp: "resp",
+		},
+		{
+			name: "panic",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) {
+				var params map[string]string
+				params["secret-token"] = "value"
+				return "resp", nil
+			},
+			expectedCode: codes.Internal,
+		},
+		{
+			name: "status error",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, status.Error(codes.AlreadyExists, "exists")
+			},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			name: "wrapped cloud error",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, fmt.Errorf("failed to get instance: %w", &googleapi.Error{Code: http.StatusNotFound, Message: "not found"})
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "plain error",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, fmt.Errorf("unexpected")
+			},
+			expectedCode: codes.Internal,
+		},
+	}
+	for _, mm := range []*metrics.MetricsManager{nil, metrics.NewMetricsManager()} {
+		interceptor := newRecoveryInterceptor(mm)
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				resp, err := interceptor(context.Background(), nil, info, tc.handler)
+				st, ok := status.FromError(err)
+				if !ok {
+					t.Fatalf("got non-status error %v", err)
+				}
+				if st.Code() != tc.expectedCode {
+					t.Errorf("got code %v, expected %v: %v", st.Code(), tc.expectedCode, err)
+				}
+				if resp != tc.expectedResp {
+					t.Errorf("got response %v, expected %v", resp, tc.expectedResp)
+				}
+				if strings.Contains(st.Message(), "assignment to entry in nil map") {
+					t.Errorf("got the panic in the message %q, expected it only logged", st.Message())
+				}
+			})
+		}
+	}
+}
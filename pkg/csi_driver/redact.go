@@ -0,0 +1,79 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// redacted replaces the secrets in the errors, like the protosanitizer does in the logged requests.
+const redacted = "***stripped***"
+
+var (
+	// privateKeyFieldPattern matches the private key fields of service account JSON keys.
+	privateKeyFieldPattern = regexp.MustCompile(`("private_key(?:_id)?"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	// privateKeyPattern matches PEM private keys, e.g. of a service account key quoted in an error.
+	privateKeyPattern = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+// redactSecrets returns s with the values of the request secrets and the private keys of
+// service accounts replaced. Other values, e.g. the KMS key names of the parameters, which
+// are resource names rather than secrets, are kept.
+func redactSecrets(s string, secrets map[string]string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	s = privateKeyFieldPattern.ReplaceAllString(s, `${1}"`+redacted+`"`)
+	return privateKeyPattern.ReplaceAllString(s, redacted)
+}
+
+// redactError returns err with the secrets redacted from its message. The code and details
+// of a gRPC status error are kept.
+func redactError(err error, secrets map[string]string) error {
+	st, _ := status.FromError(err)
+	msg := redactSecrets(st.Message(), secrets)
+	if msg == st.Message() {
+		return err
+	}
+	p := st.Proto()
+	p.Message = msg
+	return status.FromProto(p).Err()
+}
+
+// newRedactionInterceptor returns an interceptor redacting the secrets of the request from
+// the error of every RPC, before it is logged by the interceptors above it or returned to the
+// caller, e.g. a secret quoted in an error of the Filestore API.
+func newRedactionInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		var secrets map[string]string
+		if r, ok := req.(interface{ GetSecrets() map[string]string }); ok {
+			secrets = r.GetSecrets()
+		}
+		return resp, redactError(err, secrets)
+	}
+}
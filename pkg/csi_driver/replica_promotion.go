@@ -0,0 +1,273 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const (
+	// annotationPromoteReplica is set by the user on a bound PVC to fail its volume over to a
+	// replica of its instance, either "<instance>" in the location of the current instance, or
+	// "<location>/<instance>".
+	annotationPromoteReplica = "filestore.csi.storage.gke.io/promote-replica"
+	// annotationPromotionStatus reports the progress of the promotion on the PVC.
+	annotationPromotionStatus = "filestore.csi.storage.gke.io/promotion-status"
+	// annotationPromotionVolume is the name of the PV of the promoted replica.
+	annotationPromotionVolume = "filestore.csi.storage.gke.io/promotion-volume"
+
+	promotionStatusInProgress = "InProgress"
+	promotionStatusPromoted   = "Promoted"
+	promotionStatusCompleted  = "Completed"
+	promotionStatusFailed     = "Failed"
+
+	// Reasons of the events published on the PVCs being failed over.
+	eventReasonPromotionFailed = "FilestorePromotionFailed"
+	eventReasonReplicaPromoted = "FilestoreReplicaPromoted"
+
+	instanceStatePromoting = "PROMOTING"
+)
+
+// replicaPromoter fails the volume of a PVC annotated with annotationPromoteReplica over to a
+// replica of its instance. The replica is promoted right away, without waiting for the pods
+// using the PVC since the primary instance is presumably unavailable, and a PV is created for
+// the file share of the promoted instance, which has its own IP. Since the volume handle and
+// attributes of a PV are immutable, the PVC is then deleted and recreated bound to the new PV
+// once the pods using it are deleted, as for the share migrations. The original PV is
+// released, and its instance deleted by the provisioner if its reclaim policy is Delete.
+//
+// The replicas are not created by the driver, the Filestore API used by the driver does not
+// expose the replication settings of the instances. A replica is only promoted if it carries
+// the PV name and PVC namespace labels of the instance of the PV, set at its creation, so that
+// the users annotating PVCs can't promote the replicas of the other volumes.
+type replicaPromoter struct {
+	cs         *controllerServer
+	driverName string
+	period     time.Duration
+	kubeClient kubernetes.Interface
+	recorder   record.EventRecorder
+}
+
+func newReplicaPromoter(driverName string, period time.Duration, kubeClient kubernetes.Interface, recorder record.EventRecorder) *replicaPromoter {
+	return &replicaPromoter{
+		driverName: driverName,
+		period:     period,
+		kubeClient: kubeClient,
+		recorder:   recorder,
+	}
+}
+
+// Run promotes the replicas of the annotated PVCs every period until stopCh is closed.
+func (p *replicaPromoter) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Filestore replica promoter with poll period %v", p.period)
+	wait.Until(func() {
+		if err := p.promoteAll(context.Background()); err != nil {
+			klog.Errorf("Failed to promote the replicas of the annotated PVCs: %v", err)
+		}
+	}, p.period, stopCh)
+}
+
+func (p *replicaPromoter) promoteAll(ctx context.Context) error {
+	pvcs, err := p.kubeClient.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Annotations[annotationPromoteReplica] == "" || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		if s := pvc.Annotations[annotationPromotionStatus]; s == promotionStatusCompleted || s == promotionStatusFailed {
+			continue
+		}
+		if err := p.promote(ctx, pvc); err != nil {
+			if !isPermanentMigrationErr(err) && status.Code(err) != codes.PermissionDenied {
+				klog.Warningf("Promotion of the replica of PVC %s/%s will be retried: %v", pvc.Namespace, pvc.Name, err)
+				continue
+			}
+			klog.Errorf("Promotion of the replica of PVC %s/%s failed: %v", pvc.Namespace, pvc.Name, err)
+			p.recorder.Event(pvc, v1.EventTypeWarning, eventReasonPromotionFailed, err.Error())
+			if _, err := p.setStatus(ctx, pvc, promotionStatusFailed, ""); err != nil {
+				klog.Errorf("Failed to set the promotion status of PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+			}
+		}
+	}
+
+	// Recreate the PVCs deleted by the promotions once their pods are gone.
+	return recreateMigratedClaims(ctx, p.kubeClient, p.recorder)
+}
+
+// promote runs the next steps of the failover of a PVC. A promotion interrupted e.g. by a
+// restart of the driver is started again once the replica is no longer promoting.
+func (p *replicaPromoter) promote(ctx context.Context, pvc *v1.PersistentVolumeClaim) error {
+	if pvc.Status.Phase != v1.ClaimBound || pvc.Spec.VolumeName == "" {
+		return status.Errorf(codes.FailedPrecondition, "PVC %s/%s is not bound", pvc.Namespace, pvc.Name)
+	}
+	pv, err := p.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != p.driverName {
+		return status.Errorf(codes.InvalidArgument, "PV %s is not a volume of driver %s", pv.Name, p.driverName)
+	}
+	if isMultishareVolId(pv.Spec.CSI.VolumeHandle) {
+		return status.Errorf(codes.InvalidArgument, "PV %s is a multishare volume, the replicas of multishare instances are not supported", pv.Name)
+	}
+	filer, mode, err := getFileInstanceFromID(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	replicaLocation, replicaName, err := parseTargetInstance(annotationPromoteReplica, pvc.Annotations[annotationPromoteReplica], filer.Location)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if replicaLocation == filer.Location && replicaName == filer.Name {
+		return status.Errorf(codes.InvalidArgument, "PV %s is already on instance %s/%s", pv.Name, replicaLocation, replicaName)
+	}
+
+	fileService := p.cs.config.fileService
+	replica, err := fileService.GetInstance(ctx, &file.ServiceInstance{Project: p.cs.config.cloud.Project, Location: replicaLocation, Name: replicaName})
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return status.Errorf(codes.InvalidArgument, "replica instance %s/%s not found", replicaLocation, replicaName)
+		}
+		return file.StatusError(err)
+	}
+	if replica.Labels[tagKeyCreatedForVolumeName] != pv.Name || replica.Labels[tagKeyCreatedForClaimNamespace] != pvc.Namespace {
+		return status.Errorf(codes.PermissionDenied, "instance %s/%s is not labeled as a replica of PV %s of namespace %s, with the %s and %s labels", replicaLocation, replicaName, pv.Name, pvc.Namespace, tagKeyCreatedForVolumeName, tagKeyCreatedForClaimNamespace)
+	}
+	if replica.Volume.Name != filer.Volume.Name {
+		return status.Errorf(codes.InvalidArgument, "instance %s/%s serves file share %q, not file share %q of PV %s", replicaLocation, replicaName, replica.Volume.Name, filer.Volume.Name, pv.Name)
+	}
+
+	volumeName := pvc.Annotations[annotationPromotionVolume]
+	if volumeName == "" {
+		volumeName = "pvc-" + string(uuid.NewUUID())
+	}
+	if pvc.Annotations[annotationPromotionStatus] != promotionStatusPromoted {
+		if replica.State == instanceStatePromoting {
+			return status.Errorf(codes.Aborted, "instance %s/%s is being promoted", replicaLocation, replicaName)
+		}
+		if replica.State != "READY" {
+			return status.Errorf(codes.Unavailable, "instance %s/%s not ready, state %s", replicaLocation, replicaName, replica.State)
+		}
+		if pvc.Annotations[annotationPromotionStatus] != promotionStatusInProgress || pvc.Annotations[annotationPromotionVolume] != volumeName {
+			if pvc, err = p.setStatus(ctx, pvc, promotionStatusInProgress, volumeName); err != nil {
+				return err
+			}
+		}
+		klog.Infof("Promoting replica instance %s/%s of PV %s of PVC %s/%s", replicaLocation, replicaName, pv.Name, pvc.Namespace, pvc.Name)
+		if replica, err = fileService.PromoteReplica(ctx, replica); err != nil {
+			return file.StatusError(err)
+		}
+		if pvc, err = p.setStatus(ctx, pvc, promotionStatusPromoted, volumeName); err != nil {
+			return err
+		}
+		p.recorder.Eventf(pvc, v1.EventTypeNormal, eventReasonReplicaPromoted, "Filestore instance %s/%s promoted, the PVC is bound to PV %s once the pods using it are deleted", replicaLocation, replicaName, volumeName)
+	}
+
+	promoted := &file.ServiceInstance{Location: replicaLocation, Name: replicaName, Volume: replica.Volume, Network: replica.Network}
+	if err := p.ensurePromotedVolume(ctx, pvc, pv, promoted, mode, volumeName); err != nil {
+		return err
+	}
+
+	// The PVC is recreated bound to the new PV once it is deleted.
+	klog.Infof("Deleting PVC %s/%s to bind it to PV %s of promoted instance %s/%s", pvc.Namespace, pvc.Name, volumeName, replicaLocation, replicaName)
+	uid := pvc.UID
+	err = p.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// setStatus sets the promotion status of the PVC, and the name of the PV of the promoted
+// replica if not empty.
+func (p *replicaPromoter) setStatus(ctx context.Context, pvc *v1.PersistentVolumeClaim, promotionStatus, volumeName string) (*v1.PersistentVolumeClaim, error) {
+	pvc = pvc.DeepCopy()
+	if pvc.Annotations == nil {
+		pvc.Annotations = make(map[string]string)
+	}
+	pvc.Annotations[annotationPromotionStatus] = promotionStatus
+	if volumeName != "" {
+		pvc.Annotations[annotationPromotionVolume] = volumeName
+	}
+	return p.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(ctx, pvc, metav1.UpdateOptions{})
+}
+
+// ensurePromotedVolume creates the PV of the file share of the promoted instance, pre-bound to
+// the PVC, unless it already exists. The PV holds the PVC to recreate once the original PVC is
+// deleted.
+func (p *replicaPromoter) ensurePromotedVolume(ctx context.Context, pvc *v1.PersistentVolumeClaim, pv *v1.PersistentVolume, replica *file.ServiceInstance, mode, volumeName string) error {
+	_, err := p.kubeClient.CoreV1().PersistentVolumes().Get(ctx, volumeName, metav1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	claim := migratedClaim(pvc, pv.Name, volumeName)
+	delete(claim.Annotations, annotationMigrationStatus)
+	delete(claim.Annotations, annotationPromoteReplica)
+	delete(claim.Annotations, annotationPromotionVolume)
+	claim.Annotations[annotationPromotionStatus] = promotionStatusCompleted
+	claimJSON, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+
+	volumeHandle := getVolumeIDFromFileInstance(replica, mode)
+	newPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   volumeName,
+			Labels: pv.Labels,
+			Annotations: map[string]string{
+				annotationProvisionedBy:  p.driverName,
+				annotationMigratedFrom:   pv.Name,
+				annotationMigrationClaim: string(claimJSON),
+			},
+		},
+		Spec: *pv.Spec.DeepCopy(),
+	}
+	newPV.Spec.CSI.VolumeHandle = volumeHandle
+	newPV.Spec.CSI.VolumeAttributes = make(map[string]string, len(pv.Spec.CSI.VolumeAttributes))
+	for k, v := range pv.Spec.CSI.VolumeAttributes {
+		newPV.Spec.CSI.VolumeAttributes[k] = v
+	}
+	newPV.Spec.CSI.VolumeAttributes[attrIP] = replica.Network.Ip
+	newPV.Spec.ClaimRef = &v1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  pvc.Namespace,
+		Name:       pvc.Name,
+	}
+	klog.Infof("Creating PV %s of promoted instance %s failed over from PV %s", volumeName, volumeHandle, pv.Name)
+	_, err = p.kubeClient.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestReplicaPromoter(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	ctx := context.Background()
+	for _, name := range []string{testCSIVolume, "test-csi-replica"} {
+		if _, err := cs.config.fileService.CreateInstance(ctx, &file.ServiceInstance{
+			Name:   name,
+			Volume: file.Volume{Name: newInstanceVolume, SizeBytes: util.Tb},
+			Labels: map[string]string{tagKeyCreatedForVolumeName: "pv", tagKeyCreatedForClaimNamespace: "default"},
+		}); err != nil {
+			t.Fatalf("failed to create instance: %v", err)
+		}
+	}
+
+	pv := testPV("pv", testDriverName, testVolumeID, "claim")
+	pv.Spec.CSI.VolumeAttributes = map[string]string{attrIP: "10.0.0.1", attrVolume: newInstanceVolume}
+	kubeClient := fake.NewSimpleClientset(pv, testClaim("claim", "pv", map[string]string{annotationPromoteReplica: "us-central1-b/test-csi-replica"}, nil))
+	recorder := record.NewFakeRecorder(10)
+	p := newReplicaPromoter(testDriverName, time.Minute, kubeClient, recorder)
+	p.cs = cs
+
+	// The replica is promoted and the PVC rebound, the completed failover is not repeated.
+	for i := 0; i < 2; i++ {
+		if err := p.promoteAll(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	got, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	newVolumeName := got.Spec.VolumeName
+	if newVolumeName == "" || newVolumeName == "pv" {
+		t.Fatalf("got PVC volume name %q, expected a new PV", newVolumeName)
+	}
+	expectedAnnotations := map[string]string{annotationPromotionStatus: promotionStatusCompleted, annotationMigratedFrom: "pv"}
+	if !reflect.DeepEqual(got.Annotations, expectedAnnotations) {
+		t.Errorf("got PVC annotations %v, expected %v", got.Annotations, expectedAnnotations)
+	}
+
+	newPV, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, newVolumeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get new PV: %v", err)
+	}
+	if expectedHandle := "modeInstance/us-central1-b/test-csi-replica/vol1"; newPV.Spec.CSI.VolumeHandle != expectedHandle {
+		t.Errorf("got volume handle %q, expected %q", newPV.Spec.CSI.VolumeHandle, expectedHandle)
+	}
+	expectedAttributes := map[string]string{attrIP: "1.1.1.1", attrVolume: newInstanceVolume}
+	if !reflect.DeepEqual(newPV.Spec.CSI.VolumeAttributes, expectedAttributes) {
+		t.Errorf("got volume attributes %v, expected %v", newPV.Spec.CSI.VolumeAttributes, expectedAttributes)
+	}
+	expectedAnnotations = map[string]string{annotationProvisionedBy: testDriverName, annotationMigratedFrom: "pv"}
+	if !reflect.DeepEqual(newPV.Annotations, expectedAnnotations) {
+		t.Errorf("got PV annotations %v, expected %v", newPV.Annotations, expectedAnnotations)
+	}
+	expectedEvents := []string{
+		"Normal FilestoreMigrationCompleted Filestore share moved from PV pv to PV " + newVolumeName,
+		"Normal FilestoreReplicaPromoted Filestore instance us-central1-b/test-csi-replica promoted, the PVC is bound to PV " + newVolumeName + " once the pods using it are deleted",
+	}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+}
+
+func TestReplicaPromoterFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		pv            *v1.PersistentVolume
+		replica       string
+		expectedEvent string
+	}{
+		{
+			name:          "multishare volume",
+			pv:            testPV("pv", testDriverName, modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/instance-a/pvc_a", "claim"),
+			replica:       "test-csi-replica",
+			expectedEvent: "Warning FilestorePromotionFailed rpc error: code = InvalidArgument desc = PV pv is a multishare volume, the replicas of multishare instances are not supported",
+		},
+		{
+			name:          "replica not found",
+			pv:            testPV("pv", testDriverName, testVolumeID, "claim"),
+			replica:       "us-central1-b/missing",
+			expectedEvent: "Warning FilestorePromotionFailed rpc error: code = InvalidArgument desc = replica instance us-central1-b/missing not found",
+		},
+		{
+			name:          "replica of another file share",
+			pv:            testPV("pv", testDriverName, "modeInstance/us-central1-c/test-csi/other", "claim"),
+			replica:       "test-csi-replica",
+			expectedEvent: `Warning FilestorePromotionFailed rpc error: code = InvalidArgument desc = instance us-central1-c/test-csi-replica serves file share "vol1", not file share "other" of PV pv`,
+		},
+		{
+			name:          "replica of another volume",
+			pv:            testPV("pv", testDriverName, testVolumeID, "claim"),
+			replica:       "test-csi-other",
+			expectedEvent: "Warning FilestorePromotionFailed rpc error: code = PermissionDenied desc = instance us-central1-c/test-csi-other is not labeled as a replica of PV pv of namespace default, with the kubernetes_io_created-for_pv_name and kubernetes_io_created-for_pvc_namespace labels",
+		},
+		{
+			name:          "instance of the volume",
+			pv:            testPV("pv", testDriverName, testVolumeID, "claim"),
+			replica:       "us-central1-c/test-csi",
+			expectedEvent: "Warning FilestorePromotionFailed rpc error: code = InvalidArgument desc = PV pv is already on instance us-central1-c/test-csi",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := initTestController(t).(*controllerServer)
+			ctx := context.Background()
+			for name, pvName := range map[string]string{"test-csi-replica": "pv", "test-csi-other": "other-pv"} {
+				if _, err := cs.config.fileService.CreateInstance(ctx, &file.ServiceInstance{
+					Name:   name,
+					Volume: file.Volume{Name: newInstanceVolume, SizeBytes: util.Tb},
+					Labels: map[string]string{tagKeyCreatedForVolumeName: pvName, tagKeyCreatedForClaimNamespace: "default"},
+				}); err != nil {
+					t.Fatalf("failed to create instance: %v", err)
+				}
+			}
+			kubeClient := fake.NewSimpleClientset(tc.pv, testClaim("claim", "pv", map[string]string{annotationPromoteReplica: tc.replica}, nil))
+			recorder := record.NewFakeRecorder(10)
+			p := newReplicaPromoter(testDriverName, time.Minute, kubeClient, recorder)
+			p.cs = cs
+
+			// A failed promotion is not retried.
+			for i := 0; i < 2; i++ {
+				if err := p.promoteAll(ctx); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			got, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get PVC: %v", err)
+			}
+			if got.Annotations[annotationPromotionStatus] != promotionStatusFailed {
+				t.Errorf("got promotion status %q, expected %q", got.Annotations[annotationPromotionStatus], promotionStatusFailed)
+			}
+			if events := drainEvents(recorder); !reflect.DeepEqual(events, []string{tc.expectedEvent}) {
+				t.Errorf("got events %v, expected %v", events, []string{tc.expectedEvent})
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+const (
+	// Types of the restores, with the verb of their Filestore operation.
+	restoreTypeNewVolume = "new_volume"
+	restoreTypeInPlace   = "in_place"
+	opVerbCreate         = "create"
+	opVerbRestore        = "restore"
+
+	eventReasonRestoreProgress = "FilestoreRestoreProgress"
+)
+
+// trackedRestore is a restore of a backup to an instance whose operation is reported.
+type trackedRestore struct {
+	fileService  file.Service
+	instance     *file.ServiceInstance
+	restoreType  string
+	backupURI    string
+	pvcNamespace string
+	pvcName      string
+	// since is when the tracking started, the operations done before are not the restore's.
+	since time.Time
+}
+
+// restoreProgressReporter reports the progress of the restores of backups, which take hours
+// for multi-TiB backups while CreateVolume and the in-place restores only wait a few minutes
+// for their operation. Every period, it emits an event on the PVC of each running restore with
+// its elapsed time and the status detail of its operation, Filestore reporting no completion
+// percentage. The duration of the restore operations is recorded once they are done.
+type restoreProgressReporter struct {
+	period         time.Duration
+	kubeClient     kubernetes.Interface
+	recorder       record.EventRecorder
+	metricsManager *metrics.MetricsManager
+	now            func() time.Time
+
+	mutex sync.Mutex
+	// restores maps the volume ID to its restore being reported.
+	restores map[string]*trackedRestore
+}
+
+func newRestoreProgressReporter(period time.Duration, kubeClient kubernetes.Interface, recorder record.EventRecorder, mm *metrics.MetricsManager) *restoreProgressReporter {
+	if mm != nil {
+		mm.RegisterRestoreMetrics()
+	}
+	return &restoreProgressReporter{
+		period:         period,
+		kubeClient:     kubeClient,
+		recorder:       recorder,
+		metricsManager: mm,
+		now:            time.Now,
+		restores:       make(map[string]*trackedRestore),
+	}
+}
+
+// Run reports the progress of the tracked restores every period until stopCh is closed.
+func (r *restoreProgressReporter) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Filestore restore progress reporter with period %v", r.period)
+	wait.Until(func() {
+		r.reportAll(context.Background())
+	}, r.period, stopCh)
+}
+
+// track reports the restore of a backup to the instance of a volume, until its operation is
+// done. A restore already tracked is left as is.
+func (r *restoreProgressReporter) track(volumeID string, restore *trackedRestore) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.restores[volumeID]; ok {
+		return
+	}
+	restore.since = r.now()
+	r.restores[volumeID] = restore
+}
+
+func (r *restoreProgressReporter) reportAll(ctx context.Context) {
+	r.mutex.Lock()
+	restores := make(map[string]*trackedRestore, len(r.restores))
+	for volumeID, restore := range r.restores {
+		restores[volumeID] = restore
+	}
+	r.mutex.Unlock()
+
+	for volumeID, restore := range restores {
+		if err := r.report(ctx, volumeID, restore); err != nil {
+			klog.Warningf("Failed to report the progress of the restore of backup %s to volume %s: %v", restore.backupURI, volumeID, err)
+		}
+	}
+}
+
+// report emits an event with the progress of a running restore, or records the duration of a
+// done one and stops tracking it.
+func (r *restoreProgressReporter) report(ctx context.Context, volumeID string, restore *trackedRestore) error {
+	verb := opVerbCreate
+	if restore.restoreType == restoreTypeInPlace {
+		verb = opVerbRestore
+	}
+	progress, err := restore.fileService.GetOperationProgress(ctx, restore.instance, verb)
+	if err != nil {
+		return err
+	}
+	if progress == nil || (progress.Done && progress.EndTime.Before(restore.since)) {
+		// The operation of the restore is not listed yet.
+		return nil
+	}
+	if progress.Done {
+		duration := progress.EndTime.Sub(progress.StartTime)
+		r.metricsManager.RecordRestoreMetrics(progress.Err, restore.restoreType, duration)
+		if progress.Err != nil {
+			klog.Errorf("Restore of backup %s to volume %s failed after %v: %v", restore.backupURI, volumeID, duration, progress.Err)
+		} else {
+			klog.Infof("Restore of backup %s to volume %s completed in %v", restore.backupURI, volumeID, duration)
+		}
+		r.mutex.Lock()
+		delete(r.restores, volumeID)
+		r.mutex.Unlock()
+		return nil
+	}
+
+	if restore.pvcName == "" {
+		// The provisioner does not pass the PVC of the volume to CreateVolume.
+		return nil
+	}
+	pvc, err := r.kubeClient.CoreV1().PersistentVolumeClaims(restore.pvcNamespace).Get(ctx, restore.pvcName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	message := fmt.Sprintf("Restoring Filestore backup %s, running for %v", restore.backupURI, r.now().Sub(progress.StartTime).Round(time.Second))
+	if progress.StatusDetail != "" {
+		message += ": " + progress.StatusDetail
+	}
+	r.recorder.Event(pvc, v1.EventTypeNormal, eventReasonRestoreProgress, message)
+	return nil
+}
+
+// trackRestore reports the progress of the restore of the backup source of a new instance, if
+// the restore progress reporter is enabled.
+func (s *controllerServer) trackRestore(fileService file.Service, filer *file.ServiceInstance, volumeID string, params map[string]string) {
+	if s.config.restoreProgress == nil || filer.BackupSource == "" {
+		return
+	}
+	s.config.restoreProgress.track(volumeID, &trackedRestore{
+		fileService:  fileService,
+		instance:     filer,
+		restoreType:  restoreTypeNewVolume,
+		backupURI:    filer.BackupSource,
+		pvcNamespace: params[ParameterKeyPVCNamespace],
+		pvcName:      params[ParameterKeyPVCName],
+	})
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const testBackupURI = "projects/test-project/locations/us-central1/backups/mybackup"
+
+// progressService reports the given progress for the operations of the given verb.
+type progressService struct {
+	file.Service
+	verb     string
+	progress *file.OperationProgress
+}
+
+func (s *progressService) GetOperationProgress(ctx context.Context, obj *file.ServiceInstance, operationType string) (*file.OperationProgress, error) {
+	if operationType != s.verb {
+		return nil, nil
+	}
+	return s.progress, nil
+}
+
+func TestRestoreProgressReporter(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	kubeClient := fake.NewSimpleClientset(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default"}})
+	recorder := record.NewFakeRecorder(10)
+	r := newRestoreProgressReporter(time.Minute, kubeClient, recorder, nil)
+	now := start
+	r.now = func() time.Time { return now }
+	service := &progressService{verb: opVerbRestore}
+	r.track(testVolumeID, &trackedRestore{
+		fileService:  service,
+		instance:     &file.ServiceInstance{Project: testProject, Location: testZone, Name: testCSIVolume},
+		restoreType:  restoreTypeInPlace,
+		backupURI:    testBackupURI,
+		pvcNamespace: "default",
+		pvcName:      "claim",
+	})
+
+	steps := []struct {
+		name           string
+		progress       *file.OperationProgress
+		expectedEvents []string
+		tracked        bool
+	}{
+		{
+			name:    "operation not listed yet",
+			tracked: true,
+		},
+		{
+			name:    "operation of a previous restore",
+			tracked: true,
+			progress: &file.OperationProgress{
+				Name:      "previous",
+				StartTime: start.Add(-2 * time.Hour),
+				Done:      true,
+				EndTime:   start.Add(-time.Hour),
+			},
+		},
+		{
+			name:     "running",
+			tracked:  true,
+			progress: &file.OperationProgress{Name: "op", StartTime: start, StatusDetail: "Restoring file share"},
+			expectedEvents: []string{
+				"Normal FilestoreRestoreProgress Restoring Filestore backup " + testBackupURI + ", running for 1h30m0s: Restoring file share",
+			},
+		},
+		{
+			name:     "done",
+			progress: &file.OperationProgress{Name: "op", StartTime: start, Done: true, EndTime: start.Add(2 * time.Hour), Err: status.Error(codes.Internal, "restore failed")},
+		},
+	}
+	for _, step := range steps {
+		now = now.Add(30 * time.Minute)
+		service.progress = step.progress
+		r.reportAll(context.Background())
+		if events := drainEvents(recorder); !reflect.DeepEqual(events, step.expectedEvents) {
+			t.Errorf("%s: got events %v, expected %v", step.name, events, step.expectedEvents)
+		}
+		if _, tracked := r.restores[testVolumeID]; tracked != step.tracked {
+			t.Errorf("%s: got restore tracked %v, expected %v", step.name, tracked, step.tracked)
+		}
+	}
+}
+
+func TestCreateVolumeTracksRestore(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	cs.config.restoreProgress = newRestoreProgressReporter(time.Minute, fake.NewSimpleClientset(), record.NewFakeRecorder(10), nil)
+	cs.config.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil)
+	ctx := context.Background()
+	if _, err := cs.config.fileService.CreateBackup(ctx, &file.BackupInfo{
+		Project:            testProject,
+		Location:           testRegion,
+		Name:               "mybackup",
+		BackupURI:          testBackupURI,
+		SourceInstanceName: "myinstance",
+		SourceShare:        "myshare",
+		SourceVolumeId:     modeInstance + "/" + testZone + "/myinstance/myshare",
+	}); err != nil {
+		t.Fatalf("failed to create backup: %v", err)
+	}
+
+	_, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: testCSIVolume,
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: testBackupURI}},
+		},
+		Parameters: map[string]string{ParameterKeyPVCName: "claim", ParameterKeyPVCNamespace: "default"},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restore, ok := cs.config.restoreProgress.restores[testVolumeID]
+	if !ok {
+		t.Fatalf("restore of volume %s not tracked", testVolumeID)
+	}
+	if restore.restoreType != restoreTypeNewVolume || restore.backupURI != testBackupURI || restore.pvcNamespace != "default" || restore.pvcName != "claim" || restore.instance.Name != testCSIVolume {
+		t.Errorf("got tracked restore %+v", restore)
+	}
+}
@@ -17,13 +17,21 @@ limitations under the License.
 package driver
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 )
@@ -40,21 +48,73 @@ type NonBlockingGRPCServer interface {
 	ForceStop()
 }
 
-func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
-	return &nonBlockingGRPCServer{}
+// ServerOptions configures how the CSI gRPC server handles load.
+type ServerOptions struct {
+	// MaxConcurrentRPCs is the maximum number of controller and node RPCs handled at the
+	// same time. Further RPCs are rejected with ResourceExhausted, so that the sidecars retry
+	// them with backoff. Identity RPCs are never rejected. Zero means no limit.
+	MaxConcurrentRPCs int
+	// RPCTimeout is the deadline enforced on every RPC, in addition to the deadline set by
+	// the client. Zero means no server side deadline.
+	RPCTimeout time.Duration
+	// DrainTimeout is how long Stop waits for in-flight RPCs before closing their connections.
+	DrainTimeout time.Duration
+	// SocketMode, if non-zero, is applied to the unix domain socket of the endpoint.
+	SocketMode os.FileMode
+	// SocketGroup, if non-negative, is the group ID owning the unix domain socket of the endpoint.
+	SocketGroup int
+	// AuditLog, if non-nil, is written a JSON line for every mutating RPC.
+	AuditLog io.Writer
+	// Metrics, if non-nil, records the duration of every RPC.
+	Metrics *metrics.MetricsManager
+}
+
+func NewNonBlockingGRPCServer(opts *ServerOptions) NonBlockingGRPCServer {
+	if opts == nil {
+		opts = &ServerOptions{SocketGroup: -1}
+	}
+	return &nonBlockingGRPCServer{opts: *opts}
 }
 
 // NonBlocking server
 type nonBlockingGRPCServer struct {
 	wg     sync.WaitGroup
 	server *grpc.Server
+	opts   ServerOptions
 }
 
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	interceptors := []grpc.UnaryServerInterceptor{logGRPC}
+	if s.opts.AuditLog != nil {
+		// Audit the RPCs rejected by the interceptors below too.
+		interceptors = append(interceptors, newAuditInterceptor(s.opts.AuditLog))
+	}
+	interceptors = append(interceptors, newRedactionInterceptor())
+	if s.opts.MaxConcurrentRPCs > 0 {
+		interceptors = append(interceptors, newConcurrencyLimiter(s.opts.MaxConcurrentRPCs))
+	}
+	if s.opts.RPCTimeout > 0 {
+		interceptors = append(interceptors, newTimeoutInterceptor(s.opts.RPCTimeout))
+	}
+	// Innermost, so that the panics and the errors of the handlers reach the interceptors
+	// above it as status errors.
+	interceptors = append(interceptors, newRecoveryInterceptor(s.opts.Metrics))
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	s.server = server
+
+	if ids != nil {
+		csi.RegisterIdentityServer(server, ids)
+	}
+	if cs != nil {
+		csi.RegisterControllerServer(server, cs)
+	}
+	if ns != nil {
+		csi.RegisterNodeServer(server, ns)
+	}
 
 	s.wg.Add(1)
 
-	go s.serve(endpoint, ids, cs, ns)
+	go s.serve(endpoint)
 
 	return
 }
@@ -63,56 +123,179 @@ func (s *nonBlockingGRPCServer) Wait() {
 	s.wg.Wait()
 }
 
+// Stop stops accepting new RPCs and waits for the in-flight ones to complete. If they do
+// not complete within the drain timeout, the server is stopped forcefully.
 func (s *nonBlockingGRPCServer) Stop() {
-	s.server.GracefulStop()
+	if s.opts.DrainTimeout <= 0 {
+		s.server.GracefulStop()
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(s.opts.DrainTimeout):
+		klog.Warningf("In-flight RPCs did not complete within %v, stopping the server forcefully", s.opts.DrainTimeout)
+		s.server.Stop()
+	}
 }
 
 func (s *nonBlockingGRPCServer) ForceStop() {
 	s.server.Stop()
 }
 
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
-	u, err := url.Parse(endpoint)
+func (s *nonBlockingGRPCServer) serve(endpoint string) {
+	defer s.wg.Done()
+
+	scheme, addr, err := parseEndpoint(endpoint)
 	if err != nil {
 		klog.Fatal(err.Error())
 	}
+	if scheme == "unix" {
+		if err := removeStaleSocket(addr); err != nil {
+			klog.Fatal(err.Error())
+		}
+	}
+
+	klog.Infof("Start listening with scheme %v, addr %v", scheme, addr)
+	listener, err := net.Listen(scheme, addr)
+	if err != nil {
+		klog.Fatalf("Failed to listen: %v", err.Error())
+	}
+	if scheme == "unix" {
+		socket, err := s.setupSocket(listener.(*net.UnixListener), addr)
+		if err != nil {
+			klog.Fatal(err.Error())
+		}
+		defer removeSocket(addr, socket)
+	}
+
+	klog.Infof("Listening for connections on address: %#v", listener.Addr())
+
+	if err := s.server.Serve(listener); err != nil {
+		klog.Errorf("Failed to serve: %v", err)
+	}
+}
 
+// parseEndpoint returns the network and address of a unix or tcp endpoint. Both the
+// unix:/path and unix:///path forms, and both tcp://host:port and tcp:host:port are accepted.
+func parseEndpoint(endpoint string) (string, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
 	var addr string
-	if u.Scheme == "unix" {
+	switch u.Scheme {
+	case "unix":
 		addr = u.Path
-		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
-			klog.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
+		if addr == "" {
+			addr = u.Opaque
 		}
-	} else if u.Scheme == "tcp" {
+	case "tcp":
 		addr = u.Host
-	} else {
-		klog.Fatalf("%v endpoint scheme not supported", u.Scheme)
+		if addr == "" {
+			addr = u.Opaque
+		}
+	default:
+		return "", "", fmt.Errorf("%v endpoint scheme not supported", u.Scheme)
+	}
+	if addr == "" {
+		return "", "", fmt.Errorf("invalid endpoint %q: missing address", endpoint)
 	}
+	return u.Scheme, addr, nil
+}
 
-	klog.Infof("Start listening with scheme %v, addr %v", u.Scheme, addr)
-	listener, err := net.Listen(u.Scheme, addr)
+// removeStaleSocket removes the socket left at path by a previous instance of the driver. It
+// refuses to remove anything that is not a socket, to not delete a file on a misconfigured path.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
-		klog.Fatalf("Failed to listen: %v", err.Error())
+		return fmt.Errorf("failed to stat %s: %w", path, err)
 	}
-
-	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("refusing to remove %s: not a socket (mode %v)", path, info.Mode())
 	}
-	server := grpc.NewServer(opts...)
-	s.server = server
+	klog.Infof("Removing stale socket %s", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
 
-	if ids != nil {
-		csi.RegisterIdentityServer(server, ids)
+// setupSocket applies the configured permissions and group to the socket the listener
+// created at path and returns its file info, which identifies the socket on cleanup.
+func (s *nonBlockingGRPCServer) setupSocket(listener *net.UnixListener, path string) (os.FileInfo, error) {
+	// The socket is removed by removeSocket instead, which verifies it is still ours.
+	listener.SetUnlinkOnClose(false)
+	if s.opts.SocketMode != 0 {
+		if err := os.Chmod(path, s.opts.SocketMode); err != nil {
+			return nil, fmt.Errorf("failed to set mode %v on socket %s: %w", s.opts.SocketMode, path, err)
+		}
 	}
-	if cs != nil {
-		csi.RegisterControllerServer(server, cs)
+	if s.opts.SocketGroup >= 0 {
+		if err := os.Chown(path, -1, s.opts.SocketGroup); err != nil {
+			return nil, fmt.Errorf("failed to set group %d on socket %s: %w", s.opts.SocketGroup, path, err)
+		}
 	}
-	if ns != nil {
-		csi.RegisterNodeServer(server, ns)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat socket %s: %w", path, err)
 	}
+	return info, nil
+}
 
-	klog.Infof("Listening for connections on address: %#v", listener.Addr())
+// removeSocket removes the socket at path, unless it has been replaced in the meantime, e.g.
+// by a new instance of the driver that started before this one stopped.
+func removeSocket(path string, socket os.FileInfo) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Failed to stat socket %s: %v", path, err)
+		}
+		return
+	}
+	// Inode numbers are reused quickly on some filesystems, the modification time tells a new
+	// socket apart from ours.
+	if !os.SameFile(info, socket) || !info.ModTime().Equal(socket.ModTime()) {
+		klog.Infof("Socket %s has been replaced, not removing it", path)
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("Failed to remove socket %s: %v", path, err)
+	}
+}
 
-	server.Serve(listener)
+// newConcurrencyLimiter returns an interceptor that rejects controller and node RPCs with
+// ResourceExhausted while max of them are already in flight. Identity RPCs, which include
+// the liveness probe, bypass the limit.
+func newConcurrencyLimiter(max int) grpc.UnaryServerInterceptor {
+	sem := make(chan struct{}, max)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, "/csi.v1.Identity/") {
+			return handler(ctx, req)
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent RPCs, %s rejected", info.FullMethod)
+		}
+		defer func() { <-sem }()
+		return handler(ctx, req)
+	}
+}
 
+// newTimeoutInterceptor returns an interceptor that cancels the context of every RPC after
+// timeout, unless the client set an earlier deadline.
+func newTimeoutInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
 }
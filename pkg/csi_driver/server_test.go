@@ -0,0 +1,170 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := newConcurrencyLimiter(1)
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		limiter(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(started)
+			<-unblock
+			return nil, nil
+		})
+	}()
+	<-started
+
+	noop := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	_, err := limiter(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/DeleteVolume"}, noop)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected error code %v while at the limit, got %v", codes.ResourceExhausted, err)
+	}
+	if _, err := limiter(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Identity/Probe"}, noop); err != nil {
+		t.Errorf("expected identity RPC to bypass the limit, got error %v", err)
+	}
+
+	close(unblock)
+	<-done
+	if _, err := limiter(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/DeleteVolume"}, noop); err != nil {
+		t.Errorf("expected RPC to be admitted after the in-flight one completed, got error %v", err)
+	}
+}
+
+func TestTimeoutInterceptor(t *testing.T) {
+	interceptor := newTimeoutInterceptor(10 * time.Millisecond)
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// An earlier client deadline is kept.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	interceptor = newTimeoutInterceptor(time.Hour)
+	interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if got, _ := ctx.Deadline(); !got.Equal(want) {
+			t.Errorf("got deadline %v, want %v", got, want)
+		}
+		return nil, nil
+	})
+}
+
+func TestParseEndpoint(t *testing.T) {
+	cases := []struct {
+		endpoint   string
+		wantScheme string
+		wantAddr   string
+		expectErr  bool
+	}{
+		{endpoint: "unix:/tmp/csi.sock", wantScheme: "unix", wantAddr: "/tmp/csi.sock"},
+		{endpoint: "unix:///tmp/csi.sock", wantScheme: "unix", wantAddr: "/tmp/csi.sock"},
+		{endpoint: "tcp://127.0.0.1:10000", wantScheme: "tcp", wantAddr: "127.0.0.1:10000"},
+		{endpoint: "tcp:127.0.0.1:10000", wantScheme: "tcp", wantAddr: "127.0.0.1:10000"},
+		{endpoint: "udp://127.0.0.1:10000", expectErr: true},
+		{endpoint: "unix:", expectErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			scheme, addr, err := parseEndpoint(tc.endpoint)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got scheme %q addr %q", scheme, addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if scheme != tc.wantScheme || addr != tc.wantAddr {
+				t.Errorf("got scheme %q addr %q, want scheme %q addr %q", scheme, addr, tc.wantScheme, tc.wantAddr)
+			}
+		})
+	}
+}
+
+func TestSocketCleanup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "csi.sock")
+
+	// Regular files are never removed.
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket(path); err == nil {
+		t.Errorf("expected error removing a regular file")
+	}
+	os.Remove(path)
+
+	s := &nonBlockingGRPCServer{opts: ServerOptions{SocketMode: 0660, SocketGroup: -1}}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket, err := s.setupSocket(listener.(*net.UnixListener), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	if socket.Mode().Perm() != 0660 {
+		t.Errorf("got socket mode %v, want %v", socket.Mode().Perm(), os.FileMode(0660))
+	}
+
+	// A socket of a new driver instance replacing ours is not removed on cleanup.
+	if err := removeStaleSocket(path); err != nil {
+		t.Fatalf("unexpected error removing stale socket: %v", err)
+	}
+	replacement, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replacement.Close()
+	removeSocket(path, socket)
+	if _, err := os.Lstat(path); err != nil {
+		t.Errorf("expected replacement socket to be kept, got %v", err)
+	}
+
+	current, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeSocket(path, current)
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed, got %v", err)
+	}
+}
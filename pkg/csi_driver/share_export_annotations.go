@@ -0,0 +1,278 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+const (
+	// annotationShareExportPrefix is the prefix of the PVC annotations overriding the NFS export
+	// options of the share of a multishare volume, followed by one of the export option names.
+	annotationShareExportPrefix = "filestore.csi.storage.gke.io/export-"
+
+	// Names of the export options the PVC annotations override.
+	exportOptionAccessMode = "access-mode"
+	exportOptionIPRanges   = "ip-ranges"
+	exportOptionSquashMode = "squash-mode"
+	exportOptionAnonUID    = "anon-uid"
+	exportOptionAnonGID    = "anon-gid"
+)
+
+// shareExportOptions are the names of the export options the PVC annotations can override.
+var shareExportOptions = []string{exportOptionAccessMode, exportOptionIPRanges, exportOptionSquashMode, exportOptionAnonUID, exportOptionAnonGID}
+
+// ParseShareExportAnnotations parses the comma separated export options of the
+// --share-export-annotations flag.
+func ParseShareExportAnnotations(s string) ([]string, error) {
+	var options []string
+	for _, option := range strings.Split(s, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		known := false
+		for _, o := range shareExportOptions {
+			known = known || option == o
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown export option %q, expected one of %s", option, strings.Join(shareExportOptions, ", "))
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// shareExportAnnotations overrides the NFS export options of the share of a new multishare
+// volume, set by the nfs-export-options-on-create parameter of its StorageClass, with the
+// filestore.csi.storage.gke.io/export-<option> annotations of its PVC, so that the security
+// policy of the volumes is set per volume rather than per StorageClass. Only the allowed
+// options can be overridden, e.g. not the squash mode, which would let a PVC author gain root
+// access to the share. The annotated IP ranges must be within the ranges of the StorageClass,
+// if any, so that they only restrict the clients of the share. The PVC names are passed by the
+// external-provisioner --extra-create-metadata flag.
+type shareExportAnnotations struct {
+	allowed    map[string]bool
+	kubeClient kubernetes.Interface
+}
+
+func newShareExportAnnotations(allowed []string, kubeClient kubernetes.Interface) *shareExportAnnotations {
+	a := &shareExportAnnotations{allowed: make(map[string]bool, len(allowed)), kubeClient: kubeClient}
+	for _, option := range allowed {
+		a.allowed[option] = true
+	}
+	return a
+}
+
+// apply returns the request with the export options of its PVC annotations merged into its
+// nfs-export-options-on-create parameter. The request is returned unchanged if the PVC has no
+// export annotation.
+func (a *shareExportAnnotations) apply(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeRequest, error) {
+	if a == nil {
+		return req, nil
+	}
+	params := req.GetParameters()
+	namespace, name := params[ParameterKeyPVCNamespace], params[ParameterKeyPVCName]
+	if namespace == "" || name == "" {
+		return req, nil
+	}
+	pvc, err := a.kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		// The share isn't created without the export options requested for it.
+		return nil, status.Errorf(codes.Unavailable, "failed to get the export annotations of PVC %s/%s: %v", namespace, name, err)
+	}
+	overrides := map[string]string{}
+	for k, v := range pvc.Annotations {
+		if option := strings.TrimPrefix(k, annotationShareExportPrefix); option != k {
+			overrides[option] = v
+		}
+	}
+	if len(overrides) == 0 {
+		return req, nil
+	}
+	options, err := parseNfsExportOptions(params[ParamNfsExportOptions])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to parse %s %s: %v", ParamNfsExportOptions, params[ParamNfsExportOptions], err)
+	}
+	options, err = a.override(options, overrides)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "export annotations of PVC %s/%s: %v", namespace, name, err)
+	}
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	klog.Infof("Export options of the share of volume %s overridden by the annotations of PVC %s/%s: %s", req.GetName(), namespace, name, encoded)
+	// The request is compared with the retries joining it, it is not modified.
+	req = proto.Clone(req).(*csi.CreateVolumeRequest)
+	req.Parameters[ParamNfsExportOptions] = string(encoded)
+	return req, nil
+}
+
+// override returns the export options of a StorageClass with the overrides of the PVC
+// annotations, by export option name. The annotated IP ranges replace those of the StorageClass
+// options, each kept on the options whose ranges contain it, and the options left without
+// ranges are dropped. The other overrides apply to all the options.
+func (a *shareExportAnnotations) override(options []*file.NfsExportOptions, overrides map[string]string) ([]*file.NfsExportOptions, error) {
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		known := false
+		for _, o := range shareExportOptions {
+			known = known || name == o
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown annotation %s%s, expected one of the export options %s", annotationShareExportPrefix, name, strings.Join(shareExportOptions, ", "))
+		}
+		if !a.allowed[name] {
+			return nil, fmt.Errorf("annotation %s%s is not allowed by the driver (--share-export-annotations=%s)", annotationShareExportPrefix, name, strings.Join(sortedKeys(a.allowed), ","))
+		}
+	}
+
+	overridden := make([]*file.NfsExportOptions, 0, len(options))
+	for _, o := range options {
+		copied := *o
+		overridden = append(overridden, &copied)
+	}
+	if v, ok := overrides[exportOptionIPRanges]; ok {
+		var err error
+		if overridden, err = restrictIPRanges(overridden, v); err != nil {
+			return nil, err
+		}
+	}
+	if len(overridden) == 0 {
+		return nil, fmt.Errorf("the StorageClass sets no %s, annotation %s%s is required", ParamNfsExportOptions, annotationShareExportPrefix, exportOptionIPRanges)
+	}
+	for _, o := range overridden {
+		for _, name := range names {
+			v := overrides[name]
+			switch name {
+			case exportOptionAccessMode:
+				if v != "READ_ONLY" && v != "READ_WRITE" {
+					return nil, fmt.Errorf("invalid access mode %q, expected READ_ONLY or READ_WRITE", v)
+				}
+				o.AccessMode = v
+			case exportOptionSquashMode:
+				if v != "ROOT_SQUASH" && v != "NO_ROOT_SQUASH" {
+					return nil, fmt.Errorf("invalid squash mode %q, expected ROOT_SQUASH or NO_ROOT_SQUASH", v)
+				}
+				o.SquashMode = v
+			case exportOptionAnonUID, exportOptionAnonGID:
+				id, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || id < 0 {
+					return nil, fmt.Errorf("invalid %s %q, expected a non-negative integer", name, v)
+				}
+				if name == exportOptionAnonUID {
+					o.AnonUid = id
+				} else {
+					o.AnonGid = id
+				}
+			}
+		}
+	}
+	return overridden, nil
+}
+
+// restrictIPRanges returns the export options restricted to the comma separated IP ranges, or a
+// single option of the ranges if there is none.
+func restrictIPRanges(options []*file.NfsExportOptions, value string) ([]*file.NfsExportOptions, error) {
+	var ranges []string
+	for _, r := range strings.Split(value, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			if _, err := parseIPRange(r); err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no IP range in annotation %s%s", annotationShareExportPrefix, exportOptionIPRanges)
+	}
+	if len(options) == 0 {
+		return []*file.NfsExportOptions{{IpRanges: ranges}}, nil
+	}
+	contained := make(map[string]bool, len(ranges))
+	var restricted []*file.NfsExportOptions
+	for _, o := range options {
+		var kept []string
+		for _, r := range ranges {
+			if ipRangeWithin(r, o.IpRanges) {
+				kept = append(kept, r)
+				contained[r] = true
+			}
+		}
+		if len(kept) != 0 {
+			o.IpRanges = kept
+			restricted = append(restricted, o)
+		}
+	}
+	for _, r := range ranges {
+		if !contained[r] {
+			return nil, fmt.Errorf("IP range %s is not within the IP ranges of the StorageClass", r)
+		}
+	}
+	return restricted, nil
+}
+
+// parseIPRange parses an IPv4 address or CIDR range.
+func parseIPRange(r string) (*net.IPNet, error) {
+	if ip := net.ParseIP(r); ip != nil && ip.To4() != nil {
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(r)
+	if err != nil || ipNet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid IP range %q, expected an IPv4 address or CIDR range", r)
+	}
+	return ipNet, nil
+}
+
+// ipRangeWithin returns whether the IP range r is within one of the ranges.
+func ipRangeWithin(r string, ranges []string) bool {
+	inner, err := parseIPRange(r)
+	if err != nil {
+		return false
+	}
+	innerOnes, _ := inner.Mask.Size()
+	for _, o := range ranges {
+		outer, err := parseIPRange(o)
+		if err != nil {
+			continue
+		}
+		if outerOnes, _ := outer.Mask.Size(); outerOnes <= innerOnes && outer.Contains(inner.IP) {
+			return true
+		}
+	}
+	return false
+}
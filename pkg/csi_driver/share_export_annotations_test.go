@@ -0,0 +1,201 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestParseShareExportAnnotations(t *testing.T) {
+	options, err := ParseShareExportAnnotations("access-mode, ip-ranges")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(options, []string{exportOptionAccessMode, exportOptionIPRanges}) {
+		t.Errorf("got options %v", options)
+	}
+	if _, err := ParseShareExportAnnotations("access-mode,root"); err == nil {
+		t.Errorf("expected error for unknown export option")
+	}
+}
+
+func TestShareExportAnnotationsOverride(t *testing.T) {
+	scOptions := []*file.NfsExportOptions{
+		{AccessMode: "READ_WRITE", SquashMode: "NO_ROOT_SQUASH", IpRanges: []string{"10.0.0.0/16"}},
+		{AccessMode: "READ_ONLY", SquashMode: "NO_ROOT_SQUASH", IpRanges: []string{"10.1.0.0/16"}},
+	}
+	cases := []struct {
+		name      string
+		allowed   []string
+		options   []*file.NfsExportOptions
+		overrides map[string]string
+		expected  []*file.NfsExportOptions
+		expectErr bool
+	}{
+		{
+			name:      "read only",
+			allowed:   []string{exportOptionAccessMode},
+			options:   scOptions,
+			overrides: map[string]string{exportOptionAccessMode: "READ_ONLY"},
+			expected: []*file.NfsExportOptions{
+				{AccessMode: "READ_ONLY", SquashMode: "NO_ROOT_SQUASH", IpRanges: []string{"10.0.0.0/16"}},
+				{AccessMode: "READ_ONLY", SquashMode: "NO_ROOT_SQUASH", IpRanges: []string{"10.1.0.0/16"}},
+			},
+		},
+		{
+			name:      "restricted client range",
+			allowed:   []string{exportOptionIPRanges},
+			options:   scOptions,
+			overrides: map[string]string{exportOptionIPRanges: "10.0.4.0/24, 10.0.5.6"},
+			expected: []*file.NfsExportOptions{
+				{AccessMode: "READ_WRITE", SquashMode: "NO_ROOT_SQUASH", IpRanges: []string{"10.0.4.0/24", "10.0.5.6"}},
+			},
+		},
+		{
+			name:      "range outside the StorageClass ranges",
+			allowed:   []string{exportOptionIPRanges},
+			options:   scOptions,
+			overrides: map[string]string{exportOptionIPRanges: "10.0.0.0/8"},
+			expectErr: true,
+		},
+		{
+			name:      "no StorageClass options",
+			allowed:   []string{exportOptionAccessMode, exportOptionIPRanges},
+			overrides: map[string]string{exportOptionIPRanges: "192.168.0.0/24", exportOptionAccessMode: "READ_ONLY"},
+			expected: []*file.NfsExportOptions{
+				{AccessMode: "READ_ONLY", IpRanges: []string{"192.168.0.0/24"}},
+			},
+		},
+		{
+			name:      "no StorageClass options nor ranges",
+			allowed:   []string{exportOptionAccessMode},
+			overrides: map[string]string{exportOptionAccessMode: "READ_ONLY"},
+			expectErr: true,
+		},
+		{
+			name:      "not allowed",
+			allowed:   []string{exportOptionAccessMode, exportOptionIPRanges},
+			options:   scOptions,
+			overrides: map[string]string{exportOptionSquashMode: "NO_ROOT_SQUASH"},
+			expectErr: true,
+		},
+		{
+			name:      "unknown",
+			allowed:   shareExportOptions,
+			options:   scOptions,
+			overrides: map[string]string{"root": "true"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid access mode",
+			allowed:   shareExportOptions,
+			options:   scOptions,
+			overrides: map[string]string{exportOptionAccessMode: "WRITE_ONLY"},
+			expectErr: true,
+		},
+		{
+			name:      "root squash",
+			allowed:   shareExportOptions,
+			options:   scOptions[:1],
+			overrides: map[string]string{exportOptionSquashMode: "ROOT_SQUASH", exportOptionAnonUID: "1000", exportOptionAnonGID: "2000"},
+			expected: []*file.NfsExportOptions{
+				{AccessMode: "READ_WRITE", SquashMode: "ROOT_SQUASH", AnonUid: 1000, AnonGid: 2000, IpRanges: []string{"10.0.0.0/16"}},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newShareExportAnnotations(tc.allowed, nil)
+			got, err := a.override(tc.options, tc.overrides)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got options %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got options %+v, expected %+v", got, tc.expected)
+			}
+		})
+	}
+	if scOptions[0].AccessMode != "READ_WRITE" || len(scOptions) != 2 {
+		t.Errorf("StorageClass options modified: %+v", scOptions)
+	}
+}
+
+func TestShareExportAnnotationsApply(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "ns",
+			Name:        "restricted",
+			Annotations: map[string]string{annotationShareExportPrefix + exportOptionIPRanges: "10.0.4.0/24"},
+		},
+	}
+	plain := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "plain"}}
+	a := newShareExportAnnotations([]string{exportOptionIPRanges}, fake.NewSimpleClientset(pvc, plain))
+	scOptions := `[{"accessMode":"READ_WRITE","ipRanges":["10.0.0.0/16"]}]`
+
+	req := &csi.CreateVolumeRequest{
+		Name:          testCSIVolume,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 100 * util.Gb},
+		Parameters: map[string]string{
+			paramMultishare:          "true",
+			ParamNfsExportOptions:    scOptions,
+			ParameterKeyPVCNamespace: "ns",
+			ParameterKeyPVCName:      "restricted",
+		},
+	}
+	got, err := a.apply(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Parameters[ParamNfsExportOptions] != scOptions {
+		t.Errorf("request modified: %v", req.Parameters)
+	}
+	share, err := generateNewShare("share", &file.MultishareInstance{Name: "instance"}, got, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []*file.NfsExportOptions{{AccessMode: "READ_WRITE", IpRanges: []string{"10.0.4.0/24"}}}
+	if !reflect.DeepEqual(share.NfsExportOptions, expected) {
+		t.Errorf("got share export options %+v, expected %+v", share.NfsExportOptions, expected)
+	}
+
+	req.Parameters[ParameterKeyPVCName] = "plain"
+	if got, err := a.apply(context.Background(), req); err != nil || got != req {
+		t.Errorf("got request %v, error %v, expected the request of the PVC without annotations unchanged", got, err)
+	}
+
+	req.Parameters[ParameterKeyPVCName] = "missing"
+	if _, err := a.apply(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Errorf("got error %v, expected Unavailable for a missing PVC", err)
+	}
+}
@@ -0,0 +1,549 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// annotationMigrateToInstance is set by the user on a bound PVC of a multishare volume to
+	// move its share to another instance, either "<instance>" in the location of the current
+	// instance, or "<location>/<instance>".
+	annotationMigrateToInstance = "filestore.csi.storage.gke.io/migrate-to-instance"
+	// annotationMigrationStatus reports the progress of the migration on the PVC.
+	annotationMigrationStatus = "filestore.csi.storage.gke.io/migration-status"
+	// annotationMigrationVolume is the name of the PV the PVC is migrated to.
+	annotationMigrationVolume = "filestore.csi.storage.gke.io/migration-volume"
+	// annotationMigratedFrom is the name of the PV a migrated PV and PVC were moved from.
+	annotationMigratedFrom = "filestore.csi.storage.gke.io/migrated-from"
+	// annotationMigrationClaim holds the PVC to recreate bound to a migrated PV, once the
+	// original PVC is deleted.
+	annotationMigrationClaim = "filestore.csi.storage.gke.io/migration-claim"
+
+	annotationProvisionedBy = "pv.kubernetes.io/provisioned-by"
+
+	migrationStatusWaitingForPods = "WaitingForPods"
+	migrationStatusInProgress     = "InProgress"
+	migrationStatusCompleted      = "Completed"
+	migrationStatusFailed         = "Failed"
+
+	// Reasons of the events published on the PVCs being migrated.
+	eventReasonMigrationWaiting   = "FilestoreMigrationWaiting"
+	eventReasonMigrationFailed    = "FilestoreMigrationFailed"
+	eventReasonMigrationCompleted = "FilestoreMigrationCompleted"
+)
+
+// migrationClaimAnnotations are the annotations of the original PVC not carried over to the
+// PVC recreated bound to the migrated PV.
+var migrationClaimAnnotations = []string{
+	annotationMigrateToInstance,
+	annotationMigrationStatus,
+	annotationMigrationVolume,
+	"pv.kubernetes.io/bind-completed",
+	"pv.kubernetes.io/bound-by-controller",
+}
+
+// shareMigrator moves the share of a multishare volume to another instance when its PVC is
+// annotated with annotationMigrateToInstance. The move is offline: it waits until no pod uses
+// the PVC, backs the share up, restores the backup to a new share on the target instance, and
+// creates a PV for the new share. Since the volume handle of a PV and the volume name of a
+// PVC are immutable, the PVC is then deleted and recreated bound to the new PV. The original
+// PV is released, and deleted along with the source share by the provisioner if its reclaim
+// policy is Delete.
+type shareMigrator struct {
+	mc         *MultishareController
+	driverName string
+	period     time.Duration
+	kubeClient kubernetes.Interface
+	recorder   record.EventRecorder
+}
+
+func newShareMigrator(driverName string, period time.Duration, kubeClient kubernetes.Interface, recorder record.EventRecorder) *shareMigrator {
+	return &shareMigrator{
+		driverName: driverName,
+		period:     period,
+		kubeClient: kubeClient,
+		recorder:   recorder,
+	}
+}
+
+// Run migrates the annotated PVCs every period until stopCh is closed.
+func (m *shareMigrator) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Filestore share migrator with poll period %v", m.period)
+	wait.Until(func() {
+		if err := m.migrateAll(context.Background()); err != nil {
+			klog.Errorf("Failed to migrate the annotated PVCs: %v", err)
+		}
+	}, m.period, stopCh)
+}
+
+func (m *shareMigrator) migrateAll(ctx context.Context) error {
+	pvcs, err := m.kubeClient.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Annotations[annotationMigrateToInstance] == "" || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		if s := pvc.Annotations[annotationMigrationStatus]; s == migrationStatusCompleted || s == migrationStatusFailed {
+			continue
+		}
+		if err := m.migrate(ctx, pvc); err != nil {
+			if !isPermanentMigrationErr(err) {
+				klog.Warningf("Migration of PVC %s/%s will be retried: %v", pvc.Namespace, pvc.Name, err)
+				continue
+			}
+			klog.Errorf("Migration of PVC %s/%s failed: %v", pvc.Namespace, pvc.Name, err)
+			m.recorder.Event(pvc, v1.EventTypeWarning, eventReasonMigrationFailed, err.Error())
+			if _, err := m.setStatus(ctx, pvc, migrationStatusFailed, ""); err != nil {
+				klog.Errorf("Failed to set the migration status of PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+			}
+		}
+	}
+
+	// Recreate the PVCs deleted by the migrations, including those of the previous checks.
+	return recreateMigratedClaims(ctx, m.kubeClient, m.recorder)
+}
+
+// recreateMigratedClaims recreates the PVCs of the PVs annotated with annotationMigrationClaim
+// whose original PVC is deleted.
+func recreateMigratedClaims(ctx context.Context, kubeClient kubernetes.Interface, recorder record.EventRecorder) error {
+	pvs, err := kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Annotations[annotationMigrationClaim] == "" {
+			continue
+		}
+		if err := recreateClaim(ctx, kubeClient, recorder, pv); err != nil {
+			klog.Errorf("Failed to recreate the PVC of migrated PV %s: %v", pv.Name, err)
+		}
+	}
+	return nil
+}
+
+// isPermanentMigrationErr returns true if retrying the migration can't succeed until the user
+// changes the PVC or the target instance.
+func isPermanentMigrationErr(err error) bool {
+	code := status.Code(err)
+	return code == codes.InvalidArgument || code == codes.FailedPrecondition
+}
+
+// migrate runs the next steps of the migration of a PVC. Each step checks whether it was
+// already done, so that a migration interrupted e.g. by a restart of the driver is resumed.
+func (m *shareMigrator) migrate(ctx context.Context, pvc *v1.PersistentVolumeClaim) error {
+	if pvc.Status.Phase != v1.ClaimBound || pvc.Spec.VolumeName == "" {
+		return status.Errorf(codes.FailedPrecondition, "PVC %s/%s is not bound", pvc.Namespace, pvc.Name)
+	}
+	pv, err := m.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != m.driverName || !isMultishareVolId(pv.Spec.CSI.VolumeHandle) {
+		return status.Errorf(codes.InvalidArgument, "PV %s is not a multishare volume of driver %s", pv.Name, m.driverName)
+	}
+	instancePrefix, project, location, instanceName, shareName, err := parseMultishareVolId(pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	targetLocation, targetName, err := parseTargetInstance(annotationMigrateToInstance, pvc.Annotations[annotationMigrateToInstance], location)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if targetLocation == location && targetName == instanceName {
+		return status.Errorf(codes.InvalidArgument, "PV %s is already on instance %s/%s", pv.Name, targetLocation, targetName)
+	}
+
+	inUse, err := claimInUse(ctx, m.kubeClient, pvc)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		if pvc.Annotations[annotationMigrationStatus] != migrationStatusWaitingForPods {
+			m.recorder.Event(pvc, v1.EventTypeWarning, eventReasonMigrationWaiting, "Waiting for the pods using the PVC to stop before moving its Filestore share")
+			_, err = m.setStatus(ctx, pvc, migrationStatusWaitingForPods, "")
+		}
+		return err
+	}
+
+	volumeName := pvc.Annotations[annotationMigrationVolume]
+	if volumeName == "" {
+		volumeName = "pvc-" + string(uuid.NewUUID())
+	}
+	if pvc.Annotations[annotationMigrationStatus] != migrationStatusInProgress || pvc.Annotations[annotationMigrationVolume] != volumeName {
+		if pvc, err = m.setStatus(ctx, pvc, migrationStatusInProgress, volumeName); err != nil {
+			return err
+		}
+	}
+
+	sourceShare, err := m.mc.cloud.File.GetShare(ctx, &file.Share{
+		Parent: &file.MultishareInstance{Project: project, Location: location, Name: instanceName},
+		Name:   shareName,
+	})
+	if err != nil {
+		return file.StatusError(err)
+	}
+	share, err := m.ensureTargetShare(ctx, pvc, pv, sourceShare, volumeName, &file.MultishareInstance{Project: project, Location: targetLocation, Name: targetName})
+	if err != nil {
+		return err
+	}
+	if err := m.ensureTargetVolume(ctx, pvc, pv, share, instancePrefix, volumeName); err != nil {
+		return err
+	}
+	if err := m.deleteBackup(ctx, volumeName, location, project); err != nil {
+		return err
+	}
+
+	// The PVC is recreated bound to the new PV once it is deleted.
+	klog.Infof("Deleting PVC %s/%s to bind it to migrated PV %s", pvc.Namespace, pvc.Name, volumeName)
+	uid := pvc.UID
+	err = m.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// parseTargetInstance parses the target instance set in an annotation, "<instance>" in the
+// default location or "<location>/<instance>".
+func parseTargetInstance(annotation, target, defaultLocation string) (string, string, error) {
+	tokens := strings.Split(target, "/")
+	switch {
+	case len(tokens) == 1 && tokens[0] != "":
+		return defaultLocation, tokens[0], nil
+	case len(tokens) == 2 && tokens[0] != "" && tokens[1] != "":
+		return tokens[0], tokens[1], nil
+	}
+	return "", "", fmt.Errorf("invalid %s annotation %q, expected <instance> or <location>/<instance>", annotation, target)
+}
+
+// claimInUse returns true if a pod which is not terminated uses the PVC.
+func claimInUse(ctx context.Context, kubeClient kubernetes.Interface, pvc *v1.PersistentVolumeClaim) (bool, error) {
+	pods, err := kubeClient.CoreV1().Pods(pvc.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvc.Name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// setStatus sets the migration status of the PVC, and the name of the PV it is migrated to if not empty.
+func (m *shareMigrator) setStatus(ctx context.Context, pvc *v1.PersistentVolumeClaim, migrationStatus, volumeName string) (*v1.PersistentVolumeClaim, error) {
+	pvc = pvc.DeepCopy()
+	if pvc.Annotations == nil {
+		pvc.Annotations = make(map[string]string)
+	}
+	pvc.Annotations[annotationMigrationStatus] = migrationStatus
+	if volumeName != "" {
+		pvc.Annotations[annotationMigrationVolume] = volumeName
+	}
+	return m.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(ctx, pvc, metav1.UpdateOptions{})
+}
+
+// ensureTargetShare restores a backup of the source share to a new share named after the new
+// PV on the target instance, unless the new share already exists, and returns the new share.
+func (m *shareMigrator) ensureTargetShare(ctx context.Context, pvc *v1.PersistentVolumeClaim, pv *v1.PersistentVolume, sourceShare *file.Share, volumeName string, target *file.MultishareInstance) (*file.Share, error) {
+	targetShare := &file.Share{
+		Name:   util.ConvertVolToShareName(volumeName),
+		Parent: target,
+	}
+	share, err := m.mc.cloud.File.GetShare(ctx, targetShare)
+	if err == nil {
+		if share.State != file.ShareStateReady {
+			return nil, status.Errorf(codes.Aborted, "share %s not ready, state %s", share.Name, share.State)
+		}
+		return share, nil
+	}
+	if !file.IsNotFoundErr(err) {
+		return nil, file.StatusError(err)
+	}
+
+	targetInstance, err := m.mc.cloud.File.GetMultishareInstance(ctx, target)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil, status.Errorf(codes.InvalidArgument, "target instance %s not found", target.String())
+		}
+		return nil, file.StatusError(err)
+	}
+	if err := m.checkTargetInstance(ctx, sourceShare, targetInstance); err != nil {
+		return nil, err
+	}
+
+	backupURI, err := m.ensureBackup(ctx, pv.Spec.CSI.VolumeHandle, sourceShare, volumeName)
+	if err != nil {
+		return nil, err
+	}
+
+	targetShare.Parent = targetInstance
+	targetShare.CapacityBytes = sourceShare.CapacityBytes
+	targetShare.MountPointName = targetShare.Name
+	targetShare.BackupId = backupURI
+	targetShare.Labels = make(map[string]string, len(sourceShare.Labels))
+	for k, v := range sourceShare.Labels {
+		targetShare.Labels[k] = v
+	}
+	if _, ok := targetShare.Labels[tagKeyCreatedForVolumeName]; ok {
+		targetShare.Labels[tagKeyCreatedForVolumeName] = volumeName
+	}
+	// The NFS export options of a share are not returned by the Filestore API, take them from
+	// the storage class of the PVC.
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		sc, err := m.kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && sc.Parameters[ParamNfsExportOptions] != "" {
+			targetShare.NfsExportOptions, err = parseNfsExportOptions(sc.Parameters[ParamNfsExportOptions])
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+
+	klog.Infof("Restoring backup %s of share %s to share %s on instance %s", backupURI, sourceShare.Name, targetShare.Name, targetInstance.String())
+	workflow, err := m.mc.opsManager.checkAndStartShareCreateOnInstanceWorkflow(ctx, targetShare)
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	if err := m.mc.waitOnWorkflow(ctx, workflow); err != nil {
+		return nil, file.StatusError(fmt.Errorf("%v operation %q poll error: %w", workflow.opType, workflow.opName, err))
+	}
+	if workflow.opType == util.InstanceUpdate {
+		workflow, err = m.mc.opsManager.startShareCreateWorkflowSafe(ctx, targetShare)
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+		if err := m.mc.waitOnWorkflow(ctx, workflow); err != nil {
+			return nil, file.StatusError(fmt.Errorf("%v operation %q poll error: %w", workflow.opType, workflow.opName, err))
+		}
+	}
+
+	share, err = m.mc.cloud.File.GetShare(ctx, targetShare)
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	if share.State != file.ShareStateReady {
+		return nil, status.Errorf(codes.Aborted, "share %s not ready, state %s", share.Name, share.State)
+	}
+	return share, nil
+}
+
+// checkTargetInstance verifies the source share can be moved to the target instance: the
+// instance is ready, belongs to the same storage class, and can fit the share.
+func (m *shareMigrator) checkTargetInstance(ctx context.Context, sourceShare *file.Share, target *file.MultishareInstance) error {
+	if target.State != instanceStateReady {
+		return status.Errorf(codes.Unavailable, "target instance %s not ready, state %s", target.String(), target.State)
+	}
+	if sourceShare.Parent != nil && sourceShare.Parent.Labels[util.ParamMultishareInstanceScLabelKey] != target.Labels[util.ParamMultishareInstanceScLabelKey] {
+		return status.Errorf(codes.InvalidArgument, "target instance %s does not belong to the storage class of instance %s", target.String(), sourceShare.Parent.String())
+	}
+	counts, capacities, err := m.mc.opsManager.countSharesSafe(ctx, []*file.MultishareInstance{target})
+	if err != nil {
+		return file.StatusError(err)
+	}
+	if target.MaxShareCount > 0 && counts[0] >= target.MaxShareCount {
+		return status.Errorf(codes.FailedPrecondition, "target instance %s already holds the maximum number of shares %d", target.String(), target.MaxShareCount)
+	}
+	if _, maxInstanceSizeBytes := instanceSizeBounds(target); capacities[0]+sourceShare.CapacityBytes > maxInstanceSizeBytes {
+		return status.Errorf(codes.FailedPrecondition, "share %s of %d bytes does not fit in target instance %s", sourceShare.Name, sourceShare.CapacityBytes, target.String())
+	}
+	return nil
+}
+
+// ensureBackup creates a backup of the source share named after the new PV, unless it already
+// exists, and returns its URI.
+func (m *shareMigrator) ensureBackup(ctx context.Context, volumeID string, sourceShare *file.Share, volumeName string) (string, error) {
+	backupURI, backupRegion, err := file.CreateBackupURI(sourceShare.Parent.Location, sourceShare.Parent.Project, volumeName, "")
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	existingBackup, err := m.mc.cloud.File.GetBackup(ctx, backupURI)
+	backupExists, err := file.CheckBackupExists(existingBackup, err)
+	if err != nil {
+		return "", err
+	}
+	if backupExists {
+		return backupURI, nil
+	}
+
+	labels, err := extractBackupLabels(nil, m.mc.extraVolumeLabels, m.driverName, volumeName)
+	if err != nil {
+		return "", err
+	}
+	klog.Infof("Creating backup %s of share %s for its migration", backupURI, sourceShare.Name)
+	if _, err := m.mc.cloud.File.CreateBackup(ctx, &file.BackupInfo{
+		Name:               volumeName,
+		SourceVolumeId:     volumeID,
+		BackupURI:          backupURI,
+		SourceInstanceName: sourceShare.Parent.Name,
+		SourceShare:        sourceShare.Name,
+		Project:            sourceShare.Parent.Project,
+		Location:           backupRegion,
+		Labels:             labels,
+	}); err != nil {
+		return "", file.StatusError(err)
+	}
+	return backupURI, nil
+}
+
+// deleteBackup deletes the backup of the source share once it is restored, if it exists.
+func (m *shareMigrator) deleteBackup(ctx context.Context, volumeName, location, project string) error {
+	backupURI, _, err := file.CreateBackupURI(location, project, volumeName, "")
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	existingBackup, err := m.mc.cloud.File.GetBackup(ctx, backupURI)
+	backupExists, err := file.CheckBackupExists(existingBackup, err)
+	if err != nil || !backupExists {
+		return err
+	}
+	return file.StatusError(m.mc.cloud.File.DeleteBackup(ctx, backupURI))
+}
+
+// ensureTargetVolume creates the PV of the new share, pre-bound to the PVC, unless it already
+// exists. The PV holds the PVC to recreate once the original PVC is deleted.
+func (m *shareMigrator) ensureTargetVolume(ctx context.Context, pvc *v1.PersistentVolumeClaim, pv *v1.PersistentVolume, share *file.Share, instancePrefix, volumeName string) error {
+	_, err := m.kubeClient.CoreV1().PersistentVolumes().Get(ctx, volumeName, metav1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	volumeHandle, err := generateMultishareVolumeIdFromShare(instancePrefix, share)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	claim, err := json.Marshal(migratedClaim(pvc, pv.Name, volumeName))
+	if err != nil {
+		return err
+	}
+
+	newPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   volumeName,
+			Labels: pv.Labels,
+			Annotations: map[string]string{
+				annotationProvisionedBy:  m.driverName,
+				annotationMigratedFrom:   pv.Name,
+				annotationMigrationClaim: string(claim),
+			},
+		},
+		Spec: *pv.Spec.DeepCopy(),
+	}
+	newPV.Spec.CSI.VolumeHandle = volumeHandle
+	newPV.Spec.CSI.VolumeAttributes = make(map[string]string, len(pv.Spec.CSI.VolumeAttributes))
+	for k, v := range pv.Spec.CSI.VolumeAttributes {
+		newPV.Spec.CSI.VolumeAttributes[k] = v
+	}
+	newPV.Spec.CSI.VolumeAttributes[attrIP] = share.Parent.Network.Ip
+	newPV.Spec.ClaimRef = &v1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  pvc.Namespace,
+		Name:       pvc.Name,
+	}
+	klog.Infof("Creating PV %s of share %s migrated from PV %s", volumeName, volumeHandle, pv.Name)
+	_, err = m.kubeClient.CoreV1().PersistentVolumes().Create(ctx, newPV, metav1.CreateOptions{})
+	return err
+}
+
+// migratedClaim returns the PVC to recreate bound to the migrated PV.
+func migratedClaim(pvc *v1.PersistentVolumeClaim, sourceVolumeName, volumeName string) *v1.PersistentVolumeClaim {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pvc.Name,
+			Namespace:   pvc.Namespace,
+			Labels:      pvc.Labels,
+			Annotations: make(map[string]string),
+		},
+		Spec: *pvc.Spec.DeepCopy(),
+	}
+	for k, v := range pvc.Annotations {
+		claim.Annotations[k] = v
+	}
+	for _, k := range migrationClaimAnnotations {
+		delete(claim.Annotations, k)
+	}
+	claim.Annotations[annotationMigrationStatus] = migrationStatusCompleted
+	claim.Annotations[annotationMigratedFrom] = sourceVolumeName
+	claim.Spec.VolumeName = volumeName
+	return claim
+}
+
+// recreateClaim creates the PVC bound to a migrated PV once the original PVC is deleted.
+func recreateClaim(ctx context.Context, kubeClient kubernetes.Interface, recorder record.EventRecorder, pv *v1.PersistentVolume) error {
+	claim := &v1.PersistentVolumeClaim{}
+	if err := json.Unmarshal([]byte(pv.Annotations[annotationMigrationClaim]), claim); err != nil {
+		return fmt.Errorf("invalid %s annotation: %w", annotationMigrationClaim, err)
+	}
+
+	existing, err := kubeClient.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+	switch {
+	case err == nil && existing.Spec.VolumeName == pv.Name:
+		// Already recreated.
+	case err == nil:
+		// The original PVC is not deleted yet, or was replaced by the user.
+		if existing.DeletionTimestamp == nil && existing.Annotations[annotationMigrationVolume] != pv.Name {
+			klog.Warningf("PVC %s/%s was recreated without migrated PV %s", claim.Namespace, claim.Name, pv.Name)
+		}
+		return nil
+	case apierrors.IsNotFound(err):
+		klog.Infof("Recreating PVC %s/%s bound to migrated PV %s", claim.Namespace, claim.Name, pv.Name)
+		existing, err = kubeClient.CoreV1().PersistentVolumeClaims(claim.Namespace).Create(ctx, claim, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		recorder.Eventf(existing, v1.EventTypeNormal, eventReasonMigrationCompleted, "Filestore share moved from PV %s to PV %s", pv.Annotations[annotationMigratedFrom], pv.Name)
+	default:
+		return err
+	}
+
+	pv = pv.DeepCopy()
+	delete(pv.Annotations, annotationMigrationClaim)
+	_, err = kubeClient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{})
+	return err
+}
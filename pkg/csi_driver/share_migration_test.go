@@ -0,0 +1,239 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestParseTargetInstance(t *testing.T) {
+	tests := []struct {
+		target           string
+		expectedLocation string
+		expectedName     string
+		expectErr        bool
+	}{
+		{target: "instance-b", expectedLocation: testRegion, expectedName: "instance-b"},
+		{target: "us-east1/instance-b", expectedLocation: "us-east1", expectedName: "instance-b"},
+		{target: "", expectErr: true},
+		{target: "us-east1/", expectErr: true},
+		{target: "project/us-east1/instance-b", expectErr: true},
+	}
+	for _, tc := range tests {
+		location, name, err := parseTargetInstance(annotationMigrateToInstance, tc.target, testRegion)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("target %q: expected error, got none", tc.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("target %q: unexpected error: %v", tc.target, err)
+			continue
+		}
+		if location != tc.expectedLocation || name != tc.expectedName {
+			t.Errorf("target %q: got %s/%s, expected %s/%s", tc.target, location, name, tc.expectedLocation, tc.expectedName)
+		}
+	}
+}
+
+func TestShareMigrator(t *testing.T) {
+	labels := map[string]string{util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix}
+	source := &file.MultishareInstance{
+		Project:       testProject,
+		Location:      testRegion,
+		Name:          "instance-a",
+		CapacityBytes: util.Tb,
+		Labels:        labels,
+		State:         "READY",
+		Network:       file.Network{Ip: "10.0.0.1"},
+	}
+	target := &file.MultishareInstance{
+		Project:       testProject,
+		Location:      testRegion,
+		Name:          "instance-b",
+		CapacityBytes: util.Tb,
+		Labels:        labels,
+		State:         "READY",
+		Network:       file.Network{Ip: "10.0.0.2"},
+	}
+	sourceShare := &file.Share{
+		Name:          "pvc_old",
+		Parent:        source,
+		CapacityBytes: 100 * util.Gb,
+		Labels:        map[string]string{tagKeyCreatedForVolumeName: "pvc-old"},
+		State:         "READY",
+	}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{source, target}, []*file.Share{sourceShare}, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+	mc := NewMultishareController(&controllerServerConfig{
+		driver:      initTestDriver(t),
+		fileService: s,
+		cloud:       cloudProvider,
+		volumeLocks: util.NewVolumeLocks(),
+	})
+
+	pv := testPV("pvc-old", testDriverName, modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/instance-a/pvc_old", "claim")
+	pv.Spec.CSI.VolumeAttributes = map[string]string{attrIP: "10.0.0.1"}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "claim",
+			Namespace:   "default",
+			UID:         "claim-uid",
+			Annotations: map[string]string{annotationMigrateToInstance: "instance-b", "pv.kubernetes.io/bind-completed": "yes"},
+		},
+		Spec:   v1.PersistentVolumeClaimSpec{VolumeName: "pvc-old"},
+		Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: v1.PodSpec{Volumes: []v1.Volume{{
+			Name:         "data",
+			VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "claim"}},
+		}}},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	kubeClient := fake.NewSimpleClientset(pv, pvc, pod)
+	recorder := record.NewFakeRecorder(10)
+	m := newShareMigrator(testDriverName, time.Minute, kubeClient, recorder)
+	m.mc = mc
+	ctx := context.Background()
+
+	// The PVC is in use, the migration waits for the pod to stop.
+	if err := m.migrateAll(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	if got.Annotations[annotationMigrationStatus] != migrationStatusWaitingForPods {
+		t.Errorf("got migration status %q, expected %q", got.Annotations[annotationMigrationStatus], migrationStatusWaitingForPods)
+	}
+	expectedEvents := []string{"Warning FilestoreMigrationWaiting Waiting for the pods using the PVC to stop before moving its Filestore share"}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+
+	// Once the pod is deleted, the share is moved and the PVC is rebound.
+	if err := kubeClient.CoreV1().Pods("default").Delete(ctx, "pod", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+	if err := m.migrateAll(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err = kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	newVolumeName := got.Spec.VolumeName
+	if newVolumeName == "" || newVolumeName == "pvc-old" {
+		t.Fatalf("got PVC volume name %q, expected a new PV", newVolumeName)
+	}
+	expectedAnnotations := map[string]string{annotationMigrationStatus: migrationStatusCompleted, annotationMigratedFrom: "pvc-old"}
+	if !reflect.DeepEqual(got.Annotations, expectedAnnotations) {
+		t.Errorf("got PVC annotations %v, expected %v", got.Annotations, expectedAnnotations)
+	}
+
+	newPV, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, newVolumeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get new PV: %v", err)
+	}
+	newShareName := util.ConvertVolToShareName(newVolumeName)
+	expectedHandle := modeMultishare + "/" + testInstanceScPrefix + "/" + testProject + "/" + testRegion + "/instance-b/" + newShareName
+	if newPV.Spec.CSI.VolumeHandle != expectedHandle {
+		t.Errorf("got volume handle %q, expected %q", newPV.Spec.CSI.VolumeHandle, expectedHandle)
+	}
+	if newPV.Spec.CSI.VolumeAttributes[attrIP] != "10.0.0.2" {
+		t.Errorf("got volume IP %q, expected 10.0.0.2", newPV.Spec.CSI.VolumeAttributes[attrIP])
+	}
+	expectedAnnotations = map[string]string{annotationProvisionedBy: testDriverName, annotationMigratedFrom: "pvc-old"}
+	if !reflect.DeepEqual(newPV.Annotations, expectedAnnotations) {
+		t.Errorf("got PV annotations %v, expected %v", newPV.Annotations, expectedAnnotations)
+	}
+
+	newShare, err := s.GetShare(ctx, &file.Share{Name: newShareName, Parent: target})
+	if err != nil {
+		t.Fatalf("failed to get new share: %v", err)
+	}
+	expectedBackupURI := "projects/" + testProject + "/locations/" + testRegion + "/backups/" + newVolumeName
+	if newShare.Parent.Name != "instance-b" || newShare.CapacityBytes != 100*util.Gb || newShare.BackupId != expectedBackupURI || newShare.Labels[tagKeyCreatedForVolumeName] != newVolumeName {
+		t.Errorf("got new share %+v", newShare)
+	}
+	if _, err := s.GetBackup(ctx, expectedBackupURI); !file.IsNotFoundErr(err) {
+		t.Errorf("expected backup %s to be deleted, got error %v", expectedBackupURI, err)
+	}
+	expectedEvents = []string{"Normal FilestoreMigrationCompleted Filestore share moved from PV pvc-old to PV " + newVolumeName}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+}
+
+func TestShareMigratorFailure(t *testing.T) {
+	s, err := file.NewFakeServiceForMultishare(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	pv := testPV("pvc-old", testDriverName, modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/instance-a/pvc_old", "claim")
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "claim",
+			Namespace:   "default",
+			Annotations: map[string]string{annotationMigrateToInstance: testRegion + "/instance-a"},
+		},
+		Spec:   v1.PersistentVolumeClaimSpec{VolumeName: "pvc-old"},
+		Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+	kubeClient := fake.NewSimpleClientset(pv, pvc)
+	recorder := record.NewFakeRecorder(10)
+	m := newShareMigrator(testDriverName, time.Minute, kubeClient, recorder)
+	m.mc = &MultishareController{cloud: &cloud.Cloud{File: s}}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := m.migrateAll(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	got, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	if got.Annotations[annotationMigrationStatus] != migrationStatusFailed {
+		t.Errorf("got migration status %q, expected %q", got.Annotations[annotationMigrationStatus], migrationStatusFailed)
+	}
+	// A failed migration is not retried.
+	expectedEvents := []string{"Warning FilestoreMigrationFailed rpc error: code = InvalidArgument desc = PV pvc-old is already on instance us-central1/instance-a"}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+}
@@ -0,0 +1,453 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// shareRebalancer periodically looks for the multishare instances of the cluster whose shares
+// use less than a threshold of their capacity, and plans to move their shares to the other
+// instances of their pool, so that the drained instances are deleted. A plan is published as a
+// ConsolidationPlan resource per pool, and is executed with the share migration flow once the
+// operator sets its spec.approved, or right away if auto approval is enabled.
+type shareRebalancer struct {
+	mc           *MultishareController
+	driverName   string
+	period       time.Duration
+	threshold    float64
+	autoApprove  bool
+	namespace    string
+	kubeClient   kubernetes.Interface
+	driverClient clientset.Interface
+	// migrationEnabled is false if the share migrator does not run, in which case the
+	// approved plans can't be executed.
+	migrationEnabled bool
+}
+
+func newShareRebalancer(feature *FeatureRebalancer, driverName string, kubeClient kubernetes.Interface, driverClient clientset.Interface, migrationEnabled bool) *shareRebalancer {
+	return &shareRebalancer{
+		driverName:       driverName,
+		period:           feature.Period,
+		threshold:        feature.UtilizationThreshold,
+		autoApprove:      feature.AutoApprove,
+		namespace:        feature.Namespace,
+		kubeClient:       kubeClient,
+		driverClient:     driverClient,
+		migrationEnabled: migrationEnabled,
+	}
+}
+
+// Run plans and executes the consolidations every period until stopCh is closed.
+func (r *shareRebalancer) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting multishare rebalancer with period %v and utilization threshold %v", r.period, r.threshold)
+	wait.Until(func() {
+		if err := r.rebalance(context.Background()); err != nil {
+			klog.Errorf("Failed to rebalance the multishare instances: %v", err)
+		}
+	}, r.period, stopCh)
+}
+
+// rebalanceInstance is an instance of a pool, with the shares it holds in the plan being built.
+type rebalanceInstance struct {
+	instance *file.MultishareInstance
+	uri      string
+	shares   []*rebalanceShare
+	// usedBytes and shareCount are the total capacity and the number of the shares of the
+	// instance, including the shares moved to it by the plan.
+	usedBytes  int64
+	shareCount int
+	// drained is true if the plan moves all the shares off the instance.
+	drained bool
+}
+
+// rebalanceShare is a share and the PV and PVC it is bound to, if any.
+type rebalanceShare struct {
+	share *file.Share
+	pv    *v1.PersistentVolume
+}
+
+func (r *shareRebalancer) rebalance(ctx context.Context) error {
+	pools, err := r.listPools(ctx)
+	if err != nil {
+		return err
+	}
+	plans, err := r.driverClient.MultishareV1().ConsolidationPlans(r.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]*multisharev1.ConsolidationPlan, len(plans.Items))
+	for i := range plans.Items {
+		existing[plans.Items[i].Name] = &plans.Items[i]
+	}
+
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pool := pools[name]
+		plan := existing[name]
+		delete(existing, name)
+		if plan != nil && plan.Spec.Approved {
+			if err := r.execute(ctx, plan); err != nil {
+				klog.Errorf("Failed to execute consolidation plan %s: %v", name, err)
+			}
+			continue
+		}
+		if err := r.propose(ctx, name, pool, plan); err != nil {
+			klog.Errorf("Failed to propose consolidation plan %s: %v", name, err)
+		}
+	}
+	// The approved plans of the pools left without instances are still executed.
+	for name, plan := range existing {
+		if plan.Spec.Approved {
+			if err := r.execute(ctx, plan); err != nil {
+				klog.Errorf("Failed to execute consolidation plan %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// listPools returns the ready multishare instances of the cluster in its region, grouped by
+// consolidation plan name, i.e. by region and instance pool tag.
+func (r *shareRebalancer) listPools(ctx context.Context) (map[string][]*rebalanceInstance, error) {
+	region, err := util.GetRegionFromZone(r.mc.cloud.Zone)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := r.mc.cloud.File.ListMultishareInstances(ctx, &file.ListFilter{Project: r.mc.cloud.Project, Location: region})
+	if err != nil {
+		return nil, err
+	}
+	var owned []*file.MultishareInstance
+	for _, instance := range instances {
+		if instance.State != instanceStateReady || instance.Labels[util.ParamMultishareInstanceScLabelKey] == "" {
+			continue
+		}
+		if r.mc.sharedClusterGroup != "" {
+			if instance.Labels[TagKeySharedClusterGroup] != r.mc.sharedClusterGroup {
+				continue
+			}
+		} else if instance.Labels[TagKeyClusterName] != r.mc.clustername {
+			continue
+		}
+		owned = append(owned, instance)
+	}
+
+	sharesByInstance, err := r.listShares(ctx, owned)
+	if err != nil {
+		return nil, err
+	}
+	pools := make(map[string][]*rebalanceInstance)
+	for _, instance := range owned {
+		uri, err := file.GenerateMultishareInstanceURI(instance)
+		if err != nil {
+			return nil, err
+		}
+		i := &rebalanceInstance{instance: instance, uri: uri, shares: sharesByInstance[uri], shareCount: len(sharesByInstance[uri])}
+		for _, s := range i.shares {
+			i.usedBytes += s.share.CapacityBytes
+		}
+		name := consolidationPlanName(instance.Location, instance.Labels[util.ParamMultishareInstanceScLabelKey])
+		pools[name] = append(pools[name], i)
+	}
+	return pools, nil
+}
+
+// listShares returns the shares of the given instances by instance URI, with their PV if any.
+func (r *shareRebalancer) listShares(ctx context.Context, instances []*file.MultishareInstance) (map[string][]*rebalanceShare, error) {
+	pvs, err := r.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pvsByShare := make(map[string]*v1.PersistentVolume)
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != r.driverName || !isMultishareVolId(pv.Spec.CSI.VolumeHandle) {
+			continue
+		}
+		_, project, location, instanceName, shareName, err := parseMultishareVolId(pv.Spec.CSI.VolumeHandle)
+		if err != nil {
+			continue
+		}
+		pvsByShare[fmt.Sprintf("%s/%s/%s/%s", project, location, instanceName, shareName)] = pv
+	}
+
+	shares := make(map[string][]*rebalanceShare)
+	for _, instance := range instances {
+		list, err := r.mc.cloud.File.ListShares(ctx, &file.ListFilter{Project: instance.Project, Location: instance.Location, InstanceName: instance.Name})
+		if err != nil {
+			return nil, err
+		}
+		uri, err := file.GenerateMultishareInstanceURI(instance)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range list {
+			shares[uri] = append(shares[uri], &rebalanceShare{
+				share: s,
+				pv:    pvsByShare[fmt.Sprintf("%s/%s/%s/%s", instance.Project, instance.Location, instance.Name, s.Name)],
+			})
+		}
+	}
+	return shares, nil
+}
+
+// consolidationPlanName returns the name of the plan of an instance pool, a valid object name
+// since the instance pool tag is a label value.
+func consolidationPlanName(location, instancePoolTag string) string {
+	return location + "." + strings.ReplaceAll(instancePoolTag, "_", "-")
+}
+
+// planMoves plans the moves of the shares off the instances of a pool used below the
+// threshold, least used first. An instance is drained only if all of its shares are bound to a
+// PVC and fit in the other instances which are neither drained nor excluded from packing,
+// without exceeding their max size and share count. Shares are placed on the most used instances first, to keep packing them.
+func (r *shareRebalancer) planMoves(pool []*rebalanceInstance) []multisharev1.ShareMove {
+	sources := make([]*rebalanceInstance, 0, len(pool))
+	for _, i := range pool {
+		if i.instance.CapacityBytes > 0 && float64(i.usedBytes)/float64(i.instance.CapacityBytes) < r.threshold {
+			sources = append(sources, i)
+		}
+	}
+	sort.SliceStable(sources, func(a, b int) bool {
+		return float64(sources[a].usedBytes)/float64(sources[a].instance.CapacityBytes) < float64(sources[b].usedBytes)/float64(sources[b].instance.CapacityBytes)
+	})
+
+	var moves []multisharev1.ShareMove
+	// targets receiving moved shares can't be drained themselves.
+	targets := make(map[string]bool)
+	for _, source := range sources {
+		if targets[source.uri] {
+			continue
+		}
+		candidates := make([]*rebalanceInstance, 0, len(pool))
+		for _, i := range pool {
+			if i != source && !i.drained && !isExcludedFromPacking(i.instance) {
+				candidates = append(candidates, i)
+			}
+		}
+		sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].usedBytes > candidates[b].usedBytes })
+
+		shares := append([]*rebalanceShare(nil), source.shares...)
+		sort.SliceStable(shares, func(a, b int) bool { return shares[a].share.CapacityBytes > shares[b].share.CapacityBytes })
+		usedBytes := make(map[*rebalanceInstance]int64, len(candidates))
+		shareCounts := make(map[*rebalanceInstance]int, len(candidates))
+		var sourceMoves []multisharev1.ShareMove
+		for _, s := range shares {
+			if s.pv == nil || s.pv.Spec.ClaimRef == nil {
+				sourceMoves = nil
+				break
+			}
+			var target *rebalanceInstance
+			for _, c := range candidates {
+				_, maxInstanceSizeBytes := instanceSizeBounds(c.instance)
+				if c.usedBytes+usedBytes[c]+s.share.CapacityBytes <= maxInstanceSizeBytes && c.shareCount+shareCounts[c] < instanceMaxShareCount(c.instance) {
+					target = c
+					break
+				}
+			}
+			if target == nil {
+				sourceMoves = nil
+				break
+			}
+			usedBytes[target] += s.share.CapacityBytes
+			shareCounts[target]++
+			sourceMoves = append(sourceMoves, multisharev1.ShareMove{
+				PVCNamespace:   s.pv.Spec.ClaimRef.Namespace,
+				PVCName:        s.pv.Spec.ClaimRef.Name,
+				VolumeName:     s.pv.Name,
+				CapacityBytes:  s.share.CapacityBytes,
+				SourceInstance: source.uri,
+				TargetInstance: target.uri,
+			})
+		}
+		if len(sourceMoves) == 0 {
+			continue
+		}
+		// Commit the moves of the drained instance to its targets.
+		source.drained = true
+		for _, c := range candidates {
+			c.usedBytes += usedBytes[c]
+			c.shareCount += shareCounts[c]
+			if shareCounts[c] > 0 {
+				targets[c.uri] = true
+			}
+		}
+		moves = append(moves, sourceMoves...)
+	}
+	return moves
+}
+
+// propose creates, updates or deletes the unapproved plan of a pool to match the current usage
+// of its instances.
+func (r *shareRebalancer) propose(ctx context.Context, name string, pool []*rebalanceInstance, plan *multisharev1.ConsolidationPlan) error {
+	plans := r.driverClient.MultishareV1().ConsolidationPlans(r.namespace)
+	if plan != nil && plan.Status != nil && plan.Status.Phase == multisharev1.FAILED {
+		// Kept for the operator to inspect, no new plan is proposed until it is deleted.
+		return nil
+	}
+
+	moves := r.planMoves(pool)
+	if len(moves) == 0 {
+		if plan == nil {
+			return nil
+		}
+		klog.Infof("Deleting consolidation plan %s, no instance to drain", name)
+		err := plans.Delete(ctx, name, metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	spec := multisharev1.ConsolidationPlanSpec{
+		InstancePoolTag: pool[0].instance.Labels[util.ParamMultishareInstanceScLabelKey],
+		Region:          pool[0].instance.Location,
+		Approved:        r.autoApprove,
+		Moves:           moves,
+	}
+	for _, i := range pool {
+		if i.drained {
+			spec.DrainedInstances = append(spec.DrainedInstances, i.uri)
+		}
+	}
+	if plan == nil {
+		klog.Infof("Proposing consolidation plan %s draining instances %v with %d share moves", name, spec.DrainedInstances, len(moves))
+		_, err := plans.Create(ctx, &multisharev1.ConsolidationPlan{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: r.namespace},
+			Spec:       spec,
+			Status:     &multisharev1.ConsolidationPlanStatus{Phase: multisharev1.PROPOSED},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if reflect.DeepEqual(plan.Spec, spec) {
+		return nil
+	}
+	klog.Infof("Updating consolidation plan %s draining instances %v with %d share moves", name, spec.DrainedInstances, len(moves))
+	plan = plan.DeepCopy()
+	plan.Spec = spec
+	_, err := plans.Update(ctx, plan, metav1.UpdateOptions{})
+	return err
+}
+
+// execute starts the migration of the shares of an approved plan which are not moved yet, and
+// reports the progress of the plan in its status. A completed plan is deleted, so that a new
+// plan is proposed on the next period.
+func (r *shareRebalancer) execute(ctx context.Context, plan *multisharev1.ConsolidationPlan) error {
+	plans := r.driverClient.MultishareV1().ConsolidationPlans(r.namespace)
+	if plan.Status != nil {
+		switch plan.Status.Phase {
+		case multisharev1.COMPLETED:
+			klog.Infof("Deleting completed consolidation plan %s", plan.Name)
+			err := plans.Delete(ctx, plan.Name, metav1.DeleteOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		case multisharev1.FAILED:
+			return nil
+		}
+	}
+
+	status := &multisharev1.ConsolidationPlanStatus{Phase: multisharev1.EXECUTING}
+	if !r.migrationEnabled {
+		status.Error = "the ShareMigration feature is not enabled, the moves can't be executed"
+	}
+	for _, move := range plan.Spec.Moves {
+		if !r.migrationEnabled {
+			break
+		}
+		done, err := r.executeMove(ctx, move)
+		if err != nil {
+			status.Phase = multisharev1.FAILED
+			status.Error = err.Error()
+			break
+		}
+		if done {
+			status.CompletedMoves++
+		}
+	}
+	if status.Phase == multisharev1.EXECUTING && status.CompletedMoves == len(plan.Spec.Moves) {
+		klog.Infof("Consolidation plan %s completed", plan.Name)
+		status.Phase = multisharev1.COMPLETED
+	}
+	if reflect.DeepEqual(plan.Status, status) {
+		return nil
+	}
+	plan = plan.DeepCopy()
+	plan.Status = status
+	_, err := plans.UpdateStatus(ctx, plan, metav1.UpdateOptions{})
+	return err
+}
+
+// executeMove annotates the PVC of a move to migrate its share to the target instance, and
+// returns true once the migration is completed.
+func (r *shareRebalancer) executeMove(ctx context.Context, move multisharev1.ShareMove) (bool, error) {
+	pvc, err := r.kubeClient.CoreV1().PersistentVolumeClaims(move.PVCNamespace).Get(ctx, move.PVCName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// Deleted by the migration, and not recreated yet.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if pvc.Annotations[annotationMigratedFrom] == move.VolumeName {
+		return true, nil
+	}
+	if pvc.Spec.VolumeName != move.VolumeName {
+		return false, fmt.Errorf("PVC %s/%s is no longer bound to PV %s", move.PVCNamespace, move.PVCName, move.VolumeName)
+	}
+	if pvc.Annotations[annotationMigrationStatus] == migrationStatusFailed {
+		return false, fmt.Errorf("migration of PVC %s/%s failed, see its events", move.PVCNamespace, move.PVCName)
+	}
+	if pvc.Annotations[annotationMigrateToInstance] != "" {
+		return false, nil
+	}
+
+	_, location, name, err := util.ParseInstanceURI(move.TargetInstance)
+	if err != nil {
+		return false, err
+	}
+	klog.Infof("Migrating PVC %s/%s from instance %s to instance %s", move.PVCNamespace, move.PVCName, move.SourceInstance, move.TargetInstance)
+	pvc = pvc.DeepCopy()
+	if pvc.Annotations == nil {
+		pvc.Annotations = make(map[string]string)
+	}
+	pvc.Annotations[annotationMigrateToInstance] = location + "/" + name
+	_, err = r.kubeClient.CoreV1().PersistentVolumeClaims(move.PVCNamespace).Update(ctx, pvc, metav1.UpdateOptions{})
+	return false, err
+}
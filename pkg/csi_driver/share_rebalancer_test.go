@@ -0,0 +1,262 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// testRebalanceInstance returns a 1Ti instance holding shares of the given sizes in GiB, bound
+// to PVCs named after the shares unless unbound.
+func testRebalanceInstance(name string, maxShareCount int, unbound bool, shareSizesGb ...int64) *rebalanceInstance {
+	instance := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: name, CapacityBytes: util.Tb, MaxShareCount: maxShareCount}
+	i := &rebalanceInstance{instance: instance, uri: fmt.Sprintf(instanceUriFmt, testProject, testRegion, name), shareCount: len(shareSizesGb)}
+	for n, size := range shareSizesGb {
+		shareName := fmt.Sprintf("%s-share-%d", name, n)
+		s := &rebalanceShare{share: &file.Share{Name: shareName, Parent: instance, CapacityBytes: size * util.Gb}}
+		if !unbound {
+			s.pv = testPV("pv-"+shareName, testDriverName, "", "pvc-"+shareName)
+		}
+		i.shares = append(i.shares, s)
+		i.usedBytes += size * util.Gb
+	}
+	return i
+}
+
+func excludedFromPacking(i *rebalanceInstance) *rebalanceInstance {
+	i.instance.Labels = map[string]string{TagKeyExcludeFromPacking: "true"}
+	return i
+}
+
+func testShareMove(source, target string, share int, sizeGb int64) multisharev1.ShareMove {
+	shareName := fmt.Sprintf("%s-share-%d", source, share)
+	return multisharev1.ShareMove{
+		PVCNamespace:   "default",
+		PVCName:        "pvc-" + shareName,
+		VolumeName:     "pv-" + shareName,
+		CapacityBytes:  sizeGb * util.Gb,
+		SourceInstance: fmt.Sprintf(instanceUriFmt, testProject, testRegion, source),
+		TargetInstance: fmt.Sprintf(instanceUriFmt, testProject, testRegion, target),
+	}
+}
+
+func TestShareRebalancerPlanMoves(t *testing.T) {
+	tests := []struct {
+		name            string
+		pool            []*rebalanceInstance
+		expectedMoves   []multisharev1.ShareMove
+		expectedDrained []string
+	}{
+		{
+			name: "instances above threshold",
+			pool: []*rebalanceInstance{
+				testRebalanceInstance("a", 0, false, 400),
+				testRebalanceInstance("b", 0, false, 500),
+			},
+		},
+		{
+			name: "drain the least used instances to the most used one",
+			pool: []*rebalanceInstance{
+				testRebalanceInstance("a", 0, false, 100),
+				testRebalanceInstance("b", 0, false, 600),
+				testRebalanceInstance("c", 0, false, 150, 50),
+			},
+			expectedMoves: []multisharev1.ShareMove{
+				testShareMove("a", "b", 0, 100),
+				testShareMove("c", "b", 0, 150),
+				testShareMove("c", "b", 1, 50),
+			},
+			expectedDrained: []string{"a", "c"},
+		},
+		{
+			name: "instance receiving shares is not drained",
+			pool: []*rebalanceInstance{
+				testRebalanceInstance("a", 0, false, 100),
+				testRebalanceInstance("b", 0, false, 200),
+			},
+			expectedMoves:   []multisharev1.ShareMove{testShareMove("a", "b", 0, 100)},
+			expectedDrained: []string{"a"},
+		},
+		{
+			name: "instance excluded from packing receives no share",
+			pool: []*rebalanceInstance{
+				testRebalanceInstance("a", 0, false, 100),
+				excludedFromPacking(testRebalanceInstance("b", 0, false, 600)),
+			},
+		},
+		{
+			name: "instance excluded from packing is drained",
+			pool: []*rebalanceInstance{
+				excludedFromPacking(testRebalanceInstance("a", 0, false, 100)),
+				testRebalanceInstance("b", 0, false, 600),
+			},
+			expectedMoves:   []multisharev1.ShareMove{testShareMove("a", "b", 0, 100)},
+			expectedDrained: []string{"a"},
+		},
+		{
+			name: "share not bound to a PVC",
+			pool: []*rebalanceInstance{
+				testRebalanceInstance("a", 0, true, 100),
+				testRebalanceInstance("b", 0, false, 600),
+			},
+		},
+		{
+			name: "no room left in the other instances",
+			pool: []*rebalanceInstance{
+				testRebalanceInstance("a", 0, false, 100),
+				testRebalanceInstance("b", 1, false, 600),
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &shareRebalancer{threshold: 0.3}
+			moves := r.planMoves(tc.pool)
+			if !reflect.DeepEqual(moves, tc.expectedMoves) {
+				t.Errorf("got moves %+v, expected %+v", moves, tc.expectedMoves)
+			}
+			var drained []string
+			for _, i := range tc.pool {
+				if i.drained {
+					drained = append(drained, i.instance.Name)
+				}
+			}
+			if !reflect.DeepEqual(drained, tc.expectedDrained) {
+				t.Errorf("got drained instances %v, expected %v", drained, tc.expectedDrained)
+			}
+		})
+	}
+}
+
+func TestShareRebalancer(t *testing.T) {
+	labels := map[string]string{util.ParamMultishareInstanceScLabelKey: "enterprise_multishare", TagKeyClusterName: testClusterName}
+	a := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "a", CapacityBytes: util.Tb, Labels: labels, State: "READY"}
+	b := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "b", CapacityBytes: util.Tb, Labels: labels, State: "READY"}
+	other := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "other", CapacityBytes: util.Tb, State: "READY",
+		Labels: map[string]string{util.ParamMultishareInstanceScLabelKey: "enterprise_multishare", TagKeyClusterName: "other-cluster"}}
+	shares := []*file.Share{
+		{Name: "share_a", Parent: a, CapacityBytes: 100 * util.Gb},
+		{Name: "share_b", Parent: b, CapacityBytes: 600 * util.Gb},
+	}
+	s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{a, b, other}, shares, nil)
+	if err != nil {
+		t.Fatalf("failed to fake service: %v", err)
+	}
+	cloudProvider, _ := cloud.NewFakeCloud()
+	cloudProvider.File = s
+
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-a"},
+		Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+	kubeClient := kubefake.NewSimpleClientset(
+		testPV("pv-a", testDriverName, modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/a/share_a", "claim"),
+		pvc,
+	)
+	driverClient := fake.NewSimpleClientset()
+	feature := &FeatureRebalancer{Period: time.Hour, UtilizationThreshold: 0.3, Namespace: "gcp-filestore-csi-driver"}
+	r := newShareRebalancer(feature, testDriverName, kubeClient, driverClient, true)
+	r.mc = &MultishareController{cloud: cloudProvider, clustername: testClusterName}
+	ctx := context.Background()
+	plans := driverClient.MultishareV1().ConsolidationPlans(feature.Namespace)
+	planName := testRegion + ".enterprise-multishare"
+
+	// The plan is proposed, and not executed until approved.
+	for i := 0; i < 2; i++ {
+		if err := r.rebalance(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	plan, err := plans.Get(ctx, planName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get plan: %v", err)
+	}
+	expectedSpec := multisharev1.ConsolidationPlanSpec{
+		InstancePoolTag:  "enterprise_multishare",
+		Region:           testRegion,
+		DrainedInstances: []string{"projects/test-project/locations/us-central1/instances/a"},
+		Moves: []multisharev1.ShareMove{{
+			PVCNamespace:   "default",
+			PVCName:        "claim",
+			VolumeName:     "pv-a",
+			CapacityBytes:  100 * util.Gb,
+			SourceInstance: "projects/test-project/locations/us-central1/instances/a",
+			TargetInstance: "projects/test-project/locations/us-central1/instances/b",
+		}},
+	}
+	if !reflect.DeepEqual(plan.Spec, expectedSpec) {
+		t.Errorf("got plan spec %+v, expected %+v", plan.Spec, expectedSpec)
+	}
+	if plan.Status == nil || plan.Status.Phase != multisharev1.PROPOSED {
+		t.Errorf("got plan status %+v, expected phase %s", plan.Status, multisharev1.PROPOSED)
+	}
+
+	// Once approved, the PVC is annotated to migrate its share.
+	plan.Spec.Approved = true
+	if _, err := plans.Update(ctx, plan, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to approve plan: %v", err)
+	}
+	if err := r.rebalance(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	if target := got.Annotations[annotationMigrateToInstance]; target != testRegion+"/b" {
+		t.Errorf("got migration target %q, expected %q", target, testRegion+"/b")
+	}
+	plan, _ = plans.Get(ctx, planName, metav1.GetOptions{})
+	if plan.Status == nil || plan.Status.Phase != multisharev1.EXECUTING {
+		t.Errorf("got plan status %+v, expected phase %s", plan.Status, multisharev1.EXECUTING)
+	}
+
+	// The plan is completed once the PVC is migrated, and deleted on the next period.
+	got.Spec.VolumeName = "pv-new"
+	got.Annotations = map[string]string{annotationMigrationStatus: migrationStatusCompleted, annotationMigratedFrom: "pv-a"}
+	if _, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Update(ctx, got, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update PVC: %v", err)
+	}
+	if err := r.rebalance(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan, _ = plans.Get(ctx, planName, metav1.GetOptions{})
+	expectedStatus := &multisharev1.ConsolidationPlanStatus{Phase: multisharev1.COMPLETED, CompletedMoves: 1}
+	if !reflect.DeepEqual(plan.Status, expectedStatus) {
+		t.Errorf("got plan status %+v, expected %+v", plan.Status, expectedStatus)
+	}
+	if err := r.rebalance(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list, _ := plans.List(ctx, metav1.ListOptions{}); len(list.Items) != 0 {
+		t.Errorf("got plans %+v, expected none", list.Items)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/supportbundle"
+)
+
+// bundleInstance is the state of an instance in the support bundles.
+type bundleInstance struct {
+	Location      string            `json:"location"`
+	Name          string            `json:"name"`
+	Tier          string            `json:"tier"`
+	State         string            `json:"state"`
+	CapacityBytes int64             `json:"capacityBytes"`
+	IP            string            `json:"ip,omitempty"`
+	Network       string            `json:"network,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// bundleShare is the state of a share of a multishare instance in the support bundles.
+type bundleShare struct {
+	Location      string            `json:"location"`
+	Instance      string            `json:"instance"`
+	Name          string            `json:"name"`
+	State         string            `json:"state"`
+	CapacityBytes int64             `json:"capacityBytes"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// bundleOp is a running Filestore operation, or the operation of a pending CreateVolume
+// request, in the support bundles.
+type bundleOp struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Target     string    `json:"target,omitempty"`
+	Volume     string    `json:"volume,omitempty"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// SupportBundleCollectors returns the collectors of the state of the controller in the support
+// bundles, by file name: the instances created by the driver, the multishare instances and
+// shares, read through the list cache of the driver if enabled, the Filestore operations
+// running on them and the operations of the pending CreateVolume requests. It returns nil if
+// the driver doesn't run the controller.
+func (driver *GCFSDriver) SupportBundleCollectors() map[string]supportbundle.Collector {
+	s, ok := driver.cs.(*controllerServer)
+	if !ok {
+		return nil
+	}
+	collectors := map[string]supportbundle.Collector{
+		"state/instances.json": supportbundle.JSON(s.bundleInstances),
+	}
+	if mc := s.config.multiShareController; mc != nil {
+		collectors["state/multishare-instances.json"] = supportbundle.JSON(mc.bundleInstances)
+		collectors["state/shares.json"] = supportbundle.JSON(mc.bundleShares)
+		collectors["state/running-ops.json"] = supportbundle.JSON(mc.opsManager.bundleRunningOps)
+		collectors["state/pending-ops.json"] = supportbundle.JSON(mc.opsManager.bundlePendingOps)
+	}
+	return collectors
+}
+
+func (s *controllerServer) bundleInstances(ctx context.Context) (interface{}, error) {
+	instances, err := s.config.fileService.ListInstances(ctx, &file.ServiceInstance{Project: s.config.cloud.Project, Location: "-"})
+	if err != nil {
+		return nil, err
+	}
+	result := []bundleInstance{}
+	for _, instance := range instances {
+		if !CreatedByDriver(instance.Labels, s.config.driver.config.Name) {
+			continue
+		}
+		result = append(result, bundleInstance{
+			Location:      instance.Location,
+			Name:          instance.Name,
+			Tier:          instance.Tier,
+			State:         instance.State,
+			CapacityBytes: instance.Volume.SizeBytes,
+			IP:            instance.Network.Ip,
+			Network:       instance.Network.Name,
+			Labels:        instance.Labels,
+		})
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Location+"/"+result[a].Name < result[b].Location+"/"+result[b].Name
+	})
+	return result, nil
+}
+
+func (m *MultishareController) bundleInstances(ctx context.Context) (interface{}, error) {
+	instances, err := m.cloud.File.ListMultishareInstances(ctx, &file.ListFilter{Project: m.cloud.Project, Location: "-"})
+	if err != nil {
+		return nil, err
+	}
+	result := []bundleInstance{}
+	for _, instance := range instances {
+		result = append(result, bundleInstance{
+			Location:      instance.Location,
+			Name:          instance.Name,
+			Tier:          instance.Tier,
+			State:         instance.State,
+			CapacityBytes: instance.CapacityBytes,
+			IP:            instance.Network.Ip,
+			Network:       instance.Network.Name,
+			Labels:        instance.Labels,
+		})
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Location+"/"+result[a].Name < result[b].Location+"/"+result[b].Name
+	})
+	return result, nil
+}
+
+func (m *MultishareController) bundleShares(ctx context.Context) (interface{}, error) {
+	shares, err := m.cloud.File.ListShares(ctx, &file.ListFilter{Project: m.cloud.Project, Location: "-", InstanceName: "-"})
+	if err != nil {
+		return nil, err
+	}
+	result := []bundleShare{}
+	for _, share := range shares {
+		s := bundleShare{
+			Name:          share.Name,
+			State:         share.State,
+			CapacityBytes: share.CapacityBytes,
+			Labels:        share.Labels,
+		}
+		if share.Parent != nil {
+			s.Location, s.Instance = share.Parent.Location, share.Parent.Name
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Location+"/"+result[a].Instance+"/"+result[a].Name < result[b].Location+"/"+result[b].Instance+"/"+result[b].Name
+	})
+	return result, nil
+}
+
+func (m *MultishareOpsManager) bundleRunningOps(ctx context.Context) (interface{}, error) {
+	m.Lock()
+	defer m.Unlock()
+	ops, err := m.listMultishareResourceRunningOps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []bundleOp{}
+	for _, op := range ops {
+		result = append(result, bundleOp{Name: op.Id, Type: op.Type.String(), Target: op.Target, CreateTime: op.CreateTime})
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Name < result[b].Name })
+	return result, nil
+}
+
+func (m *MultishareOpsManager) bundlePendingOps(ctx context.Context) (interface{}, error) {
+	m.pendingWorkflowsLock.Lock()
+	defer m.pendingWorkflowsLock.Unlock()
+	result := []bundleOp{}
+	for volume, w := range m.pendingWorkflows {
+		result = append(result, bundleOp{Name: w.opName, Type: w.opType.String(), Volume: volume})
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Volume < result[b].Volume })
+	return result, nil
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSupportBundleCollectors(t *testing.T) {
+	if collectors := (&GCFSDriver{}).SupportBundleCollectors(); collectors != nil {
+		t.Errorf("got collectors %v of a node driver, expected none", collectors)
+	}
+
+	cs := initTestController(t).(*controllerServer)
+	collectors := (&GCFSDriver{cs: cs}).SupportBundleCollectors()
+	if len(collectors) != 1 {
+		t.Fatalf("got %d collectors without multishare, expected 1", len(collectors))
+	}
+	// The instances of the fake service are not created by the driver.
+	content, err := collectors["state/instances.json"](context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != "[]" {
+		t.Errorf("got instances %s, expected none", content)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tierAliases maps the legacy names of the tiers to the names they are equivalent to, so that
+// a policy can't be bypassed by naming a tier differently.
+var tierAliases = map[string]string{
+	basicHDDTier: defaultTier,
+	basicSSDTier: premiumTier,
+}
+
+// canonicalTier returns the lowercase name of a tier, its legacy names resolved.
+func canonicalTier(tier string) string {
+	tier = strings.ToLower(tier)
+	if alias, ok := tierAliases[tier]; ok {
+		return alias
+	}
+	return tier
+}
+
+// TierPolicy restricts the tiers of the volumes provisioned by the driver, e.g. to the
+// enterprise tier only, for cost and compliance governance.
+type TierPolicy struct {
+	// allowed, if non-empty, are the only tiers of the new volumes.
+	allowed map[string]bool
+	// denied are the tiers the new volumes can't be of.
+	denied map[string]bool
+	// flags describes the policy in the errors of the rejected volumes.
+	flags string
+}
+
+// NewTierPolicy parses the comma separated tiers of the --allowed-tiers and --denied-tiers
+// flags, and returns nil if neither is set.
+func NewTierPolicy(allowed, denied string) (*TierPolicy, error) {
+	p := &TierPolicy{}
+	var err error
+	if p.allowed, err = parseTiers(allowed); err != nil {
+		return nil, fmt.Errorf("allowed tiers: %w", err)
+	}
+	if p.denied, err = parseTiers(denied); err != nil {
+		return nil, fmt.Errorf("denied tiers: %w", err)
+	}
+	if len(p.allowed) == 0 && len(p.denied) == 0 {
+		return nil, nil
+	}
+	var flags []string
+	if len(p.allowed) != 0 {
+		flags = append(flags, "--allowed-tiers="+strings.Join(sortedKeys(p.allowed), ","))
+	}
+	if len(p.denied) != 0 {
+		flags = append(flags, "--denied-tiers="+strings.Join(sortedKeys(p.denied), ","))
+	}
+	p.flags = strings.Join(flags, " ")
+	return p, nil
+}
+
+func parseTiers(s string) (map[string]bool, error) {
+	tiers := map[string]bool{}
+	for _, tier := range strings.Split(s, ",") {
+		tier = strings.ToLower(strings.TrimSpace(tier))
+		if tier == "" {
+			continue
+		}
+		known := false
+		for _, t := range knownTiers {
+			known = known || tier == t
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown tier %q, expected one of %s", tier, strings.Join(knownTiers, ", "))
+		}
+		tiers[canonicalTier(tier)] = true
+	}
+	return tiers, nil
+}
+
+// sortedKeys returns the keys of a set, sorted.
+func sortedKeys(set map[string]bool) []string {
+	sorted := make([]string, 0, len(set))
+	for k := range set {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// check returns an InvalidArgument error naming the policy if the tier is not allowed.
+func (p *TierPolicy) check(tier string) error {
+	if p == nil {
+		return nil
+	}
+	canonical := canonicalTier(tier)
+	if (len(p.allowed) != 0 && !p.allowed[canonical]) || p.denied[canonical] {
+		return status.Errorf(codes.InvalidArgument, "tier %q is not allowed by the tier policy of the driver (%s)", tier, p.flags)
+	}
+	return nil
+}
+
+// effectiveTier returns the tier of a new volume: the tier parameter if set, enterprise for the
+// multishare volumes and the file shares of a parent instance, the default tier otherwise.
+func effectiveTier(params map[string]string) string {
+	if tier, ok := lookupParam(params, paramTier); ok {
+		return tier
+	}
+	if _, ok := lookupParam(params, paramParentInstance); ok || strings.EqualFold(params[paramMultishare], "true") {
+		return enterpriseTier
+	}
+	return defaultTier
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewTierPolicy(t *testing.T) {
+	p, err := NewTierPolicy("", " ")
+	if err != nil || p != nil {
+		t.Errorf("got policy %+v, error %v, expected no policy", p, err)
+	}
+	if _, err := NewTierPolicy("enterprise,gold", ""); err == nil {
+		t.Errorf("expected error for unknown allowed tier")
+	}
+	if _, err := NewTierPolicy("", "gold"); err == nil {
+		t.Errorf("expected error for unknown denied tier")
+	}
+}
+
+func TestTierPolicyCheck(t *testing.T) {
+	cases := []struct {
+		name           string
+		allowed        string
+		denied         string
+		tier           string
+		expectRejected bool
+	}{
+		{
+			name:    "allowed",
+			allowed: "enterprise, zonal",
+			tier:    "ENTERPRISE",
+		},
+		{
+			name:           "not allowed",
+			allowed:        "enterprise",
+			tier:           defaultTier,
+			expectRejected: true,
+		},
+		{
+			name:           "denied",
+			denied:         "high_scale_ssd",
+			tier:           highScaleTier,
+			expectRejected: true,
+		},
+		{
+			name:   "not denied",
+			denied: "high_scale_ssd",
+			tier:   premiumTier,
+		},
+		{
+			name:           "denied alias",
+			denied:         "standard",
+			tier:           basicHDDTier,
+			expectRejected: true,
+		},
+		{
+			name:    "allowed alias",
+			allowed: "basic_ssd",
+			tier:    premiumTier,
+		},
+		{
+			name:           "allowed and denied",
+			allowed:        "enterprise,zonal",
+			denied:         "zonal",
+			tier:           zonalTier,
+			expectRejected: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewTierPolicy(tc.allowed, tc.denied)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = p.check(tc.tier)
+			if !tc.expectRejected {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("got error %v, expected InvalidArgument", err)
+			}
+			if !strings.Contains(err.Error(), "tiers=") {
+				t.Errorf("got error %v, expected the policy to be named", err)
+			}
+		})
+	}
+}
+
+func TestEffectiveTier(t *testing.T) {
+	cases := []struct {
+		params   map[string]string
+		expected string
+	}{
+		{params: nil, expected: defaultTier},
+		{params: map[string]string{"Tier": premiumTier}, expected: premiumTier},
+		{params: map[string]string{paramMultishare: "true"}, expected: enterpriseTier},
+		{params: map[string]string{paramParentInstance: "us-central1/parent"}, expected: enterpriseTier},
+	}
+	for _, tc := range cases {
+		if got := effectiveTier(tc.params); got != tc.expected {
+			t.Errorf("effectiveTier(%v) = %q, expected %q", tc.params, got, tc.expected)
+		}
+	}
+}
+
+func TestCreateVolumeTierPolicy(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	policy, err := NewTierPolicy(enterpriseTier, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cs.config.tierPolicy = policy
+
+	_, err = cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("got error %v, expected InvalidArgument for the default tier", err)
+	}
+}
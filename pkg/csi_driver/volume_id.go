@@ -17,6 +17,7 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -80,6 +81,47 @@ func getFileInstanceFromID(id string) (*file.ServiceInstance, string, error) {
 	}, tokens[idProvisioningMode], nil
 }
 
+// ParseVolumeLocationAliases parses a comma separated list of <location>=<alias> pairs, e.g.
+// "us-central1-c=us-central1", into a map of volume ID location to alias location.
+func ParseVolumeLocationAliases(aliases string) (map[string]string, error) {
+	aliasMap := make(map[string]string)
+	if aliases == "" {
+		return aliasMap, nil
+	}
+	for _, pair := range strings.Split(aliases, ",") {
+		tokens := strings.Split(pair, "=")
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("location alias %q is invalid, correct format: '<location>=<alias>'", pair)
+		}
+		location, alias := strings.TrimSpace(tokens[0]), strings.TrimSpace(tokens[1])
+		if location == "" || alias == "" || location == alias {
+			return nil, fmt.Errorf("location alias %q is invalid, the location and its alias must be distinct and non-empty", pair)
+		}
+		if _, ok := aliasMap[location]; ok {
+			return nil, fmt.Errorf("location %q has more than one alias", location)
+		}
+		aliasMap[location] = alias
+	}
+	return aliasMap, nil
+}
+
+// getVolumeInstance gets the instance of an instance mode volume at the location of its volume
+// ID. If the instance is not found there and the location has an alias, e.g. after the
+// StorageClass of the volume moved between zonal and regional tiers and the instance was
+// recreated from a backup in the region, the instance is looked up at the alias location. The
+// location of the instance returned is the one it was found at.
+func (s *controllerServer) getVolumeInstance(ctx context.Context, fileService file.Service, filer *file.ServiceInstance) (*file.ServiceInstance, error) {
+	instance, err := fileService.GetInstance(ctx, filer)
+	alias, ok := s.config.volumeLocationAliases[filer.Location]
+	if !ok || !file.IsNotFoundErr(err) {
+		return instance, err
+	}
+	klog.V(4).Infof("Instance %s not found in location %s, looking it up in alias location %s", filer.Name, filer.Location, alias)
+	aliased := *filer
+	aliased.Location = alias
+	return fileService.GetInstance(ctx, &aliased)
+}
+
 func generateMultishareVolumeIdFromShare(instancePrefix string, s *file.Share) (string, error) {
 	if instancePrefix == "" {
 		return "", fmt.Errorf("invalid instance prefix")
@@ -92,6 +134,59 @@ func generateMultishareVolumeIdFromShare(instancePrefix string, s *file.Share) (
 	return fmt.Sprintf("%s/%s/%s/%s/%s/%s", modeMultishare, instancePrefix, s.Parent.Project, s.Parent.Location, s.Parent.Name, s.Name), nil
 }
 
+// VolumeHandle is a parsed volume handle of the driver, i.e. the volume ID of a PV.
+type VolumeHandle struct {
+	// Multishare is true for the volumes of the shares of multishare instances.
+	Multishare bool
+	// InstanceStorageClassLabel is the instance-storageclass-label of a multishare volume.
+	InstanceStorageClassLabel string
+	// Project is the project of a multishare volume or of some legacy volumes, the volume IDs
+	// of the instance mode volumes do not record their project.
+	Project  string
+	Location string
+	Instance string
+	Share    string
+	// Legacy is true for the volume handles of the legacy provisioner, whose Share is empty
+	// if they hold the resource name of the instance.
+	Legacy bool
+}
+
+// ParseVolumeHandle parses the volume handle of an instance mode or multishare volume, or of
+// a volume of the legacy provisioner.
+func ParseVolumeHandle(handle string) (*VolumeHandle, error) {
+	if legacy, ok := parseLegacyVolumeID(handle); ok {
+		return &VolumeHandle{Project: legacy.project, Location: legacy.location, Instance: legacy.instance, Share: legacy.share, Legacy: true}, nil
+	}
+	if isMultishareVolId(handle) {
+		prefix, project, location, instanceName, shareName, err := parseMultishareVolId(handle)
+		if err != nil {
+			return nil, err
+		}
+		return &VolumeHandle{
+			Multishare:                true,
+			InstanceStorageClassLabel: prefix,
+			Project:                   project,
+			Location:                  location,
+			Instance:                  instanceName,
+			Share:                     shareName,
+		}, nil
+	}
+	filer, mode, err := getFileInstanceFromID(handle)
+	if err != nil {
+		return nil, err
+	}
+	if mode != modeInstance || filer.Location == "" || filer.Name == "" || filer.Volume.Name == "" {
+		return nil, fmt.Errorf("invalid volume id %v", handle)
+	}
+	return &VolumeHandle{Location: filer.Location, Instance: filer.Name, Share: filer.Volume.Name}, nil
+}
+
+// CreatedByDriver returns true if the labels of a Filestore resource record its creation by
+// the driver.
+func CreatedByDriver(labels map[string]string, driverName string) bool {
+	return labels[tagKeyCreatedBy] == strings.ReplaceAll(driverName, ".", "_")
+}
+
 func parseSourceVolId(volId string) (string, string, string, string, error) {
 	tokens := strings.Split(volId, "/")
 	if len(tokens) != util.SourceVolumeIdSplitLen {
@@ -0,0 +1,45 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// volumeLockRetryDelay is the delay after which the operations aborted because another
+// operation on their volume is in progress are hinted to be retried, e.g. to tune the retry
+// interval of the sidecars.
+const volumeLockRetryDelay = 5 * time.Second
+
+// volumeOperationInProgressError returns the Aborted error of an operation of the method on
+// the volume key, whose lock is held by another operation, with volumeLockRetryDelay as
+// RetryInfo detail, and counts the lock contention.
+func volumeOperationInProgressError(mm *metrics.MetricsManager, method, key string) error {
+	mm.RecordLockContention(key, method)
+	st := status.Newf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, key)
+	if withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(volumeLockRetryDelay)}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeOperationInProgressError(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	if !cs.config.volumeLocks.TryAcquire(testVolumeID) {
+		t.Fatalf("failed to acquire the lock of volume %s", testVolumeID)
+	}
+	defer cs.config.volumeLocks.Release(testVolumeID)
+
+	_, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: testVolumeID})
+	st, _ := status.FromError(err)
+	if st.Code() != codes.Aborted {
+		t.Fatalf("expected Aborted error, got %v", err)
+	}
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	if retryInfo == nil {
+		t.Fatalf("expected RetryInfo detail, got details %v", st.Details())
+	}
+	if delay := retryInfo.GetRetryDelay().AsDuration(); delay != volumeLockRetryDelay {
+		t.Errorf("expected retry delay %v, got %v", volumeLockRetryDelay, delay)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// annotationRestoreFromBackup is set by the user on a bound PVC to restore the backup with
+	// the given URI, projects/<project>/locations/<region>/backups/<name>, in place to its volume.
+	annotationRestoreFromBackup = "filestore.csi.storage.gke.io/restore-from-backup"
+	// annotationRestoreStatus reports the progress of the restore on the PVC.
+	annotationRestoreStatus = "filestore.csi.storage.gke.io/restore-status"
+	// annotationRestoredBackup is the URI of the backup the restore status refers to, so that
+	// a new restore starts when the user annotates the PVC with another backup.
+	annotationRestoredBackup = "filestore.csi.storage.gke.io/restored-backup"
+
+	restoreStatusWaitingForPods = "WaitingForPods"
+	restoreStatusInProgress     = "InProgress"
+	restoreStatusCompleted      = "Completed"
+	restoreStatusFailed         = "Failed"
+
+	// Reasons of the events published on the PVCs being restored.
+	eventReasonRestoreWaiting   = "FilestoreRestoreWaiting"
+	eventReasonRestoreFailed    = "FilestoreRestoreFailed"
+	eventReasonRestoreCompleted = "FilestoreRestoreCompleted"
+)
+
+// volumeRestorer restores a backup in place to the volume of a PVC annotated with
+// annotationRestoreFromBackup, e.g. for disaster recovery drills, instead of restoring it to
+// a new PVC. The restore replaces the content of the volume, it waits until no pod uses the
+// PVC. Only the backups taken for the PVCs of the namespace of the PVC, labeled with their
+// namespace, are restored, so that the users annotating PVCs can't read the backups of the
+// other namespaces. Filestore restores backups in place to the file share of an instance only, the shares
+// of multishare instances are not supported.
+type volumeRestorer struct {
+	cs         *controllerServer
+	driverName string
+	period     time.Duration
+	kubeClient kubernetes.Interface
+	recorder   record.EventRecorder
+}
+
+func newVolumeRestorer(driverName string, period time.Duration, kubeClient kubernetes.Interface, recorder record.EventRecorder) *volumeRestorer {
+	return &volumeRestorer{
+		driverName: driverName,
+		period:     period,
+		kubeClient: kubeClient,
+		recorder:   recorder,
+	}
+}
+
+// Run restores the annotated PVCs every period until stopCh is closed.
+func (r *volumeRestorer) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting Filestore volume restorer with poll period %v", r.period)
+	wait.Until(func() {
+		if err := r.restoreAll(context.Background()); err != nil {
+			klog.Errorf("Failed to restore the annotated PVCs: %v", err)
+		}
+	}, r.period, stopCh)
+}
+
+func (r *volumeRestorer) restoreAll(ctx context.Context) error {
+	pvcs, err := r.kubeClient.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		backupURI := pvc.Annotations[annotationRestoreFromBackup]
+		if backupURI == "" || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		if s := pvc.Annotations[annotationRestoreStatus]; (s == restoreStatusCompleted || s == restoreStatusFailed) && pvc.Annotations[annotationRestoredBackup] == backupURI {
+			continue
+		}
+		if err := r.restore(ctx, pvc, backupURI); err != nil {
+			if !isPermanentMigrationErr(err) && status.Code(err) != codes.PermissionDenied {
+				klog.Warningf("Restore of backup %s to PVC %s/%s will be retried: %v", backupURI, pvc.Namespace, pvc.Name, err)
+				continue
+			}
+			klog.Errorf("Restore of backup %s to PVC %s/%s failed: %v", backupURI, pvc.Namespace, pvc.Name, err)
+			r.recorder.Event(pvc, v1.EventTypeWarning, eventReasonRestoreFailed, err.Error())
+			if _, err := r.setStatus(ctx, pvc, restoreStatusFailed, backupURI); err != nil {
+				klog.Errorf("Failed to set the restore status of PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restore restores the backup to the volume of the PVC once no pod uses the PVC. A restore
+// interrupted e.g. by a restart of the driver is started again, unless its operation is
+// still running.
+func (r *volumeRestorer) restore(ctx context.Context, pvc *v1.PersistentVolumeClaim, backupURI string) error {
+	if pvc.Status.Phase != v1.ClaimBound || pvc.Spec.VolumeName == "" {
+		return status.Errorf(codes.FailedPrecondition, "PVC %s/%s is not bound", pvc.Namespace, pvc.Name)
+	}
+	pv, err := r.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != r.driverName {
+		return status.Errorf(codes.InvalidArgument, "PV %s is not a volume of driver %s", pv.Name, r.driverName)
+	}
+	volumeID := pv.Spec.CSI.VolumeHandle
+	if isMultishareVolId(volumeID) {
+		return status.Errorf(codes.InvalidArgument, "PV %s is a multishare volume, Filestore does not restore backups in place to the shares of multishare instances", pv.Name)
+	}
+	filer, _, err := getFileInstanceFromID(volumeID)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	inUse, err := claimInUse(ctx, r.kubeClient, pvc)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		if pvc.Annotations[annotationRestoreStatus] != restoreStatusWaitingForPods || pvc.Annotations[annotationRestoredBackup] != backupURI {
+			r.recorder.Event(pvc, v1.EventTypeWarning, eventReasonRestoreWaiting, "Waiting for the pods using the PVC to stop before restoring the Filestore backup to its volume")
+			_, err = r.setStatus(ctx, pvc, restoreStatusWaitingForPods, backupURI)
+		}
+		return err
+	}
+
+	if acquired := r.cs.config.volumeLocks.TryAcquire(volumeID); !acquired {
+		return volumeOperationInProgressError(r.cs.config.metricsManager, methodRestoreVolume, volumeID)
+	}
+	defer r.cs.config.volumeLocks.Release(volumeID)
+
+	fileService, project := r.cs.config.fileService, r.cs.config.cloud.Project
+	backup, err := file.GetSourceBackup(ctx, fileService, backupURI, project)
+	if err != nil {
+		return err
+	}
+	if ns := backup.Backup.Labels[tagKeyCreatedForClaimNamespace]; ns != pvc.Namespace {
+		return status.Errorf(codes.PermissionDenied, "backup %s was not taken for a PVC of namespace %s", backupURI, pvc.Namespace)
+	}
+	if backup.Backup.State != "READY" {
+		return status.Errorf(codes.Unavailable, "backup %s not ready, state %s", backupURI, backup.Backup.State)
+	}
+	filer.Project = project
+	filer, err = r.cs.getVolumeInstance(ctx, fileService, filer)
+	if err != nil {
+		return file.StatusError(err)
+	}
+	if util.GbToBytes(backup.Backup.CapacityGb) > filer.Volume.SizeBytes {
+		return status.Errorf(codes.FailedPrecondition, "backup %s of %d GiB does not fit in PV %s of %d GiB", backupURI, backup.Backup.CapacityGb, pv.Name, util.BytesToGb(filer.Volume.SizeBytes))
+	}
+	hasPendingOps, err := fileService.HasOperations(ctx, filer, "restore", false /* done */)
+	if err != nil {
+		return file.StatusError(err)
+	}
+	if hasPendingOps {
+		return status.Errorf(codes.Aborted, "restore operation ongoing for volume %v", volumeID)
+	}
+	if filer.State != "READY" {
+		return status.Errorf(codes.Unavailable, "volume %v not ready, state %s", volumeID, filer.State)
+	}
+
+	if pvc.Annotations[annotationRestoreStatus] != restoreStatusInProgress || pvc.Annotations[annotationRestoredBackup] != backupURI {
+		if pvc, err = r.setStatus(ctx, pvc, restoreStatusInProgress, backupURI); err != nil {
+			return err
+		}
+	}
+	klog.Infof("Restoring backup %s in place to PV %s of PVC %s/%s", backupURI, pv.Name, pvc.Namespace, pvc.Name)
+	if r.cs.config.restoreProgress != nil {
+		r.cs.config.restoreProgress.track(volumeID, &trackedRestore{
+			fileService:  fileService,
+			instance:     filer,
+			restoreType:  restoreTypeInPlace,
+			backupURI:    backupURI,
+			pvcNamespace: pvc.Namespace,
+			pvcName:      pvc.Name,
+		})
+	}
+	if _, err := fileService.RestoreInstance(ctx, filer, backupURI); err != nil {
+		return file.StatusError(err)
+	}
+	if _, err := r.setStatus(ctx, pvc, restoreStatusCompleted, backupURI); err != nil {
+		return err
+	}
+	r.recorder.Eventf(pvc, v1.EventTypeNormal, eventReasonRestoreCompleted, "Filestore backup %s restored to PV %s", backupURI, pv.Name)
+	return nil
+}
+
+// setStatus sets the restore status of the PVC, along with the backup it refers to.
+func (r *volumeRestorer) setStatus(ctx context.Context, pvc *v1.PersistentVolumeClaim, restoreStatus, backupURI string) (*v1.PersistentVolumeClaim, error) {
+	pvc = pvc.DeepCopy()
+	if pvc.Annotations == nil {
+		pvc.Annotations = make(map[string]string)
+	}
+	pvc.Annotations[annotationRestoreStatus] = restoreStatus
+	pvc.Annotations[annotationRestoredBackup] = backupURI
+	return r.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(ctx, pvc, metav1.UpdateOptions{})
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestVolumeRestorer(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	ctx := context.Background()
+	if _, err := cs.config.fileService.CreateInstance(ctx, &file.ServiceInstance{
+		Name:   testCSIVolume,
+		Volume: file.Volume{Name: newInstanceVolume, SizeBytes: util.Tb},
+	}); err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	// The backup may be in another project than the volume.
+	backupURI := "projects/backup-project/locations/us-central1/backups/drill"
+	if _, err := cs.config.fileService.CreateBackup(ctx, &file.BackupInfo{
+		SourceInstanceName: "other-instance",
+		SourceShare:        newInstanceVolume,
+		SourceVolumeId:     "modeInstance/us-central1-c/other-instance/vol1",
+		BackupURI:          backupURI,
+		Labels:             map[string]string{tagKeyCreatedForClaimNamespace: "default"},
+	}); err != nil {
+		t.Fatalf("failed to create backup: %v", err)
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: v1.PodSpec{Volumes: []v1.Volume{{
+			Name:         "data",
+			VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "claim"}},
+		}}},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	kubeClient := fake.NewSimpleClientset(testPV("pv", testDriverName, testVolumeID, "claim"), testClaim("claim", "pv", map[string]string{annotationRestoreFromBackup: backupURI}, nil), pod)
+	recorder := record.NewFakeRecorder(10)
+	r := newVolumeRestorer(testDriverName, time.Minute, kubeClient, recorder)
+	r.cs = cs
+
+	// The PVC is in use, the restore waits for the pod to stop.
+	if err := r.restoreAll(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	if got.Annotations[annotationRestoreStatus] != restoreStatusWaitingForPods {
+		t.Errorf("got restore status %q, expected %q", got.Annotations[annotationRestoreStatus], restoreStatusWaitingForPods)
+	}
+	expectedEvents := []string{"Warning FilestoreRestoreWaiting Waiting for the pods using the PVC to stop before restoring the Filestore backup to its volume"}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+
+	// Once the pod is deleted, the backup is restored to the instance.
+	if err := kubeClient.CoreV1().Pods("default").Delete(ctx, "pod", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.restoreAll(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	got, err = kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	expectedAnnotations := map[string]string{
+		annotationRestoreFromBackup: backupURI,
+		annotationRestoreStatus:     restoreStatusCompleted,
+		annotationRestoredBackup:    backupURI,
+	}
+	if !reflect.DeepEqual(got.Annotations, expectedAnnotations) {
+		t.Errorf("got PVC annotations %v, expected %v", got.Annotations, expectedAnnotations)
+	}
+	instance, err := cs.config.fileService.GetInstance(ctx, &file.ServiceInstance{Name: testCSIVolume})
+	if err != nil {
+		t.Fatalf("failed to get instance: %v", err)
+	}
+	if instance.BackupSource != backupURI {
+		t.Errorf("got restored backup %q, expected %q", instance.BackupSource, backupURI)
+	}
+	// The completed restore is not repeated.
+	expectedEvents = []string{"Normal FilestoreRestoreCompleted Filestore backup " + backupURI + " restored to PV pv"}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+}
+
+func TestVolumeRestorerFailure(t *testing.T) {
+	backupURI := "projects/test-project/locations/us-central1/backups/drill"
+	tests := []struct {
+		name            string
+		pv              *v1.PersistentVolume
+		backupNamespace string
+		expectedEvent   string
+	}{
+		{
+			name:          "multishare volume",
+			pv:            testPV("pv", testDriverName, modeMultishare+"/"+testInstanceScPrefix+"/"+testProject+"/"+testRegion+"/instance-a/pvc_a", "claim"),
+			expectedEvent: "Warning FilestoreRestoreFailed rpc error: code = InvalidArgument desc = PV pv is a multishare volume, Filestore does not restore backups in place to the shares of multishare instances",
+		},
+		{
+			name:          "volume of another driver",
+			pv:            testPV("pv", "other-driver", testVolumeID, "claim"),
+			expectedEvent: "Warning FilestoreRestoreFailed rpc error: code = InvalidArgument desc = PV pv is not a volume of driver " + testDriverName,
+		},
+		{
+			name:            "backup of another namespace",
+			pv:              testPV("pv", testDriverName, testVolumeID, "claim"),
+			backupNamespace: "other-tenant",
+			expectedEvent:   "Warning FilestoreRestoreFailed rpc error: code = PermissionDenied desc = backup " + backupURI + " was not taken for a PVC of namespace default",
+		},
+		{
+			name:          "backup without namespace",
+			pv:            testPV("pv", testDriverName, testVolumeID, "claim"),
+			expectedEvent: "Warning FilestoreRestoreFailed rpc error: code = PermissionDenied desc = backup " + backupURI + " was not taken for a PVC of namespace default",
+		},
+		{
+			name:            "backup larger than the volume",
+			pv:              testPV("pv", testDriverName, testVolumeID, "claim"),
+			backupNamespace: "default",
+			expectedEvent:   "Warning FilestoreRestoreFailed rpc error: code = FailedPrecondition desc = backup " + backupURI + " of 1024 GiB does not fit in PV pv of 512 GiB",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := initTestController(t).(*controllerServer)
+			ctx := context.Background()
+			if _, err := cs.config.fileService.CreateInstance(ctx, &file.ServiceInstance{
+				Name:   testCSIVolume,
+				Volume: file.Volume{Name: newInstanceVolume, SizeBytes: 512 * util.Gb},
+			}); err != nil {
+				t.Fatalf("failed to create instance: %v", err)
+			}
+			if _, err := cs.config.fileService.CreateBackup(ctx, &file.BackupInfo{
+				SourceInstanceName: "other-instance",
+				SourceShare:        newInstanceVolume,
+				SourceVolumeId:     "modeInstance/us-central1-c/other-instance/vol1",
+				BackupURI:          backupURI,
+				Labels:             map[string]string{tagKeyCreatedForClaimNamespace: tc.backupNamespace},
+			}); err != nil {
+				t.Fatalf("failed to create backup: %v", err)
+			}
+			kubeClient := fake.NewSimpleClientset(tc.pv, testClaim("claim", "pv", map[string]string{annotationRestoreFromBackup: backupURI}, nil))
+			recorder := record.NewFakeRecorder(10)
+			r := newVolumeRestorer(testDriverName, time.Minute, kubeClient, recorder)
+			r.cs = cs
+
+			// A failed restore is not retried.
+			for i := 0; i < 2; i++ {
+				if err := r.restoreAll(ctx); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			got, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get PVC: %v", err)
+			}
+			if got.Annotations[annotationRestoreStatus] != restoreStatusFailed {
+				t.Errorf("got restore status %q, expected %q", got.Annotations[annotationRestoreStatus], restoreStatusFailed)
+			}
+			if events := drainEvents(recorder); !reflect.DeepEqual(events, []string{tc.expectedEvent}) {
+				t.Errorf("got events %v, expected %v", events, []string{tc.expectedEvent})
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// paramAllowedZones is the StorageClass parameter listing the comma separated zones the
+// instances of the volumes may be created in when the zone picked for them is out of capacity,
+// e.g. for the zonal tier, instead of failing the PVCs until the StorageClass is edited.
+const paramAllowedZones = "allowed-zones"
+
+// instanceStateError is the state of the instances whose creation failed.
+const instanceStateError = "ERROR"
+
+// instanceZones returns the zones the instance of a volume may be created in, in order: the
+// zone picked for the volume, then the other zones of the allowed-zones parameter, restricted to
+// the requisite zones of the topology requirement of the volume, if any.
+func instanceZones(instance *file.ServiceInstance, params map[string]string, top *csi.TopologyRequirement) ([]string, error) {
+	value, ok := lookupParam(params, paramAllowedZones)
+	if !ok {
+		return []string{instance.Location}, nil
+	}
+	region, err := util.GetRegionFromZone(instance.Location)
+	if err != nil {
+		return nil, fmt.Errorf("parameter %q is only supported for the zonal instances, the instances of tier %s are regional", paramAllowedZones, instance.Tier)
+	}
+	requisite, err := getZonesFromTopology(top.GetRequisite())
+	if err != nil {
+		return nil, err
+	}
+	zones := []string{instance.Location}
+	for _, zone := range strings.Split(value, ",") {
+		zone = strings.ToLower(strings.TrimSpace(zone))
+		if zone == "" || zone == instance.Location {
+			continue
+		}
+		if zoneRegion, err := util.GetRegionFromZone(zone); err != nil || zoneRegion != region {
+			return nil, fmt.Errorf("zone %q of parameter %q is not a zone of region %s of the volume", zone, paramAllowedZones, region)
+		}
+		if len(requisite) != 0 && !containsString(requisite, zone) {
+			klog.V(4).Infof("Skipping allowed zone %s of volume %s, not in its requisite topology %v", zone, instance.Name, requisite)
+			continue
+		}
+		if !containsString(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
+
+// getInstanceInZones returns the instance of the name of newFiler in the zones, nil if none,
+// and moves newFiler to its zone. The instances whose creation failed in a zone, e.g. out of
+// capacity, are skipped for the instances of the other zones. The zones the instance can still
+// be created in are returned, newFiler being moved to the first of them if no instance is found.
+func getInstanceInZones(ctx context.Context, fileService file.Service, newFiler *file.ServiceInstance, zones []string) (*file.ServiceInstance, []string, error) {
+	var failed *file.ServiceInstance
+	var failedZone string
+	var free []string
+	for _, zone := range zones {
+		newFiler.Location = zone
+		filer, err := fileService.GetInstance(ctx, newFiler)
+		if err != nil && !file.IsNotFoundErr(err) {
+			return nil, nil, err
+		}
+		switch {
+		case filer == nil:
+			free = append(free, zone)
+		case filer.State == instanceStateError && len(zones) > 1:
+			if failed == nil {
+				failed, failedZone = filer, zone
+			}
+		default:
+			return filer, nil, nil
+		}
+	}
+	if len(free) == 0 && failed != nil {
+		newFiler.Location = failedZone
+		return failed, nil, nil
+	}
+	if len(free) != 0 {
+		newFiler.Location = free[0]
+	}
+	return nil, free, nil
+}
+
+// createInstanceInZones creates the instance in the first of the zones not out of capacity, and
+// moves newFiler to its zone.
+func createInstanceInZones(ctx context.Context, fileService file.Service, newFiler *file.ServiceInstance, zones []string) (*file.ServiceInstance, error) {
+	var err error
+	for i, zone := range zones {
+		newFiler.Location = zone
+		var filer *file.ServiceInstance
+		filer, err = fileService.CreateInstance(ctx, newFiler)
+		if err == nil || !file.IsZonalCapacityErr(err) || i == len(zones)-1 {
+			return filer, err
+		}
+		klog.Warningf("Zone %s is out of capacity for instance %s of tier %s, creating it in zone %s: %v", zone, newFiler.Name, newFiler.Tier, zones[i+1], err)
+	}
+	return nil, err
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
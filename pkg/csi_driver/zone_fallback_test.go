@@ -0,0 +1,186 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+)
+
+// stockoutService fails the creation of the instances in the zones out of capacity, and
+// records the zones the instances are created in.
+type stockoutService struct {
+	file.Service
+	stockout map[string]bool
+	zones    []string
+}
+
+func (s *stockoutService) CreateInstance(ctx context.Context, obj *file.ServiceInstance) (*file.ServiceInstance, error) {
+	s.zones = append(s.zones, obj.Location)
+	if s.stockout[obj.Location] {
+		return nil, errors.New("operation failed: zone_resource_pool_exhausted")
+	}
+	instance, err := s.Service.CreateInstance(ctx, obj)
+	if err == nil {
+		instance.Location = obj.Location
+	}
+	return instance, err
+}
+
+func TestInstanceZones(t *testing.T) {
+	cases := []struct {
+		name          string
+		location      string
+		params        map[string]string
+		top           *csi.TopologyRequirement
+		expectedZones []string
+		expectErr     bool
+	}{
+		{
+			name:          "no allowed zones",
+			location:      testLocation,
+			params:        map[string]string{},
+			expectedZones: []string{testLocation},
+		},
+		{
+			name:          "allowed zones after the picked zone",
+			location:      testLocation,
+			params:        map[string]string{"Allowed-Zones": "us-central1-a, US-CENTRAL1-C,,us-central1-b,us-central1-a"},
+			expectedZones: []string{testLocation, "us-central1-a", "us-central1-b"},
+		},
+		{
+			name:     "allowed zones restricted to the requisite topology",
+			location: testLocation,
+			params:   map[string]string{paramAllowedZones: "us-central1-a,us-central1-b"},
+			top: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{Segments: map[string]string{TopologyKeyZone: testLocation}},
+					{Segments: map[string]string{TopologyKeyZone: "us-central1-b"}},
+				},
+			},
+			expectedZones: []string{testLocation, "us-central1-b"},
+		},
+		{
+			name:      "allowed zone of another region",
+			location:  testLocation,
+			params:    map[string]string{paramAllowedZones: "us-east1-b"},
+			expectErr: true,
+		},
+		{
+			name:      "regional instance",
+			location:  testRegion,
+			params:    map[string]string{paramAllowedZones: "us-central1-a"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			zones, err := instanceZones(&file.ServiceInstance{Name: testCSIVolume, Location: tc.location, Tier: enterpriseTier}, tc.params, tc.top)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got zones %v", zones)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(zones, tc.expectedZones) {
+				t.Errorf("got zones %v, expected %v", zones, tc.expectedZones)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeZoneFallback(t *testing.T) {
+	cases := []struct {
+		name                 string
+		params               map[string]string
+		stockout             map[string]bool
+		expectedZones        []string
+		expectedTopologyZone string
+		expectErr            bool
+	}{
+		{
+			name:                 "created in the picked zone",
+			params:               map[string]string{paramAllowedZones: "us-central1-a"},
+			expectedZones:        []string{testLocation},
+			expectedTopologyZone: testLocation,
+		},
+		{
+			name:                 "created in the next allowed zone",
+			params:               map[string]string{paramAllowedZones: "us-central1-a,us-central1-b"},
+			stockout:             map[string]bool{testLocation: true, "us-central1-a": true},
+			expectedZones:        []string{testLocation, "us-central1-a", "us-central1-b"},
+			expectedTopologyZone: "us-central1-b",
+		},
+		{
+			name:          "all allowed zones out of capacity",
+			params:        map[string]string{paramAllowedZones: "us-central1-a"},
+			stockout:      map[string]bool{testLocation: true, "us-central1-a": true},
+			expectedZones: []string{testLocation, "us-central1-a"},
+			expectErr:     true,
+		},
+		{
+			name:          "no allowed zones",
+			params:        map[string]string{},
+			stockout:      map[string]bool{testLocation: true},
+			expectedZones: []string{testLocation},
+			expectErr:     true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := initTestController(t).(*controllerServer)
+			cs.config.tagManager.(*cloud.FakeTagServiceManager).
+				On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+				Return(nil)
+			fs := &stockoutService{Service: cs.config.fileService, stockout: tc.stockout}
+			cs.config.fileService = fs
+			cs.config.cloud.File = fs
+
+			resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               testCSIVolume,
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+				Parameters:         tc.params,
+			})
+			if !reflect.DeepEqual(fs.zones, tc.expectedZones) {
+				t.Errorf("got instance created in zones %v, expected %v", fs.zones, tc.expectedZones)
+			}
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got volume %v", resp.GetVolume())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expectedTopology := []*csi.Topology{{Segments: map[string]string{TopologyKeyZone: tc.expectedTopologyZone}}}
+			if !reflect.DeepEqual(resp.GetVolume().GetAccessibleTopology(), expectedTopology) {
+				t.Errorf("got accessible topology %v, expected %v", resp.GetVolume().GetAccessibleTopology(), expectedTopology)
+			}
+		})
+	}
+}
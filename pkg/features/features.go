@@ -0,0 +1,281 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package features
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/component-base/featuregate"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Multishare enables the provisioning of volumes as shares of multishare Filestore instances.
+	Multishare featuregate.Feature = "Multishare"
+	// MultishareBackups enables the backups of multishare volumes. Requires Multishare.
+	MultishareBackups featuregate.Feature = "MultishareBackups"
+	// MaxSharesPerInstance allows the storage class to configure the max shares packed per
+	// multishare instance. Requires Multishare.
+	MaxSharesPerInstance featuregate.Feature = "MaxSharesPerInstance"
+	// StatefulMultishare runs the stateful multishare controller. Requires Multishare.
+	StatefulMultishare featuregate.Feature = "StatefulMultishare"
+	// LockRelease enables the release of the NFS locks held by nodes leaving the cluster.
+	LockRelease featuregate.Feature = "LockRelease"
+	// NFSExportOptionsOnCreate allows the storage class to configure IP access rules.
+	NFSExportOptionsOnCreate featuregate.Feature = "NFSExportOptionsOnCreate"
+	// MountHealthReporter enables the periodic health probes of the staged mounts.
+	MountHealthReporter featuregate.Feature = "MountHealthReporter"
+	// InstanceEvents enables the events published on the PVs whose Filestore instance becomes unavailable.
+	InstanceEvents featuregate.Feature = "InstanceEvents"
+	// TierRecommendations enables the right-sizing recommendations of the staged volumes based on their observed usage.
+	TierRecommendations featuregate.Feature = "TierRecommendations"
+	// DeleteRetryQueue enables the controller to keep retrying the failed volume deletions in the background.
+	DeleteRetryQueue featuregate.Feature = "DeleteRetryQueue"
+	// ShareMigration enables the moves of the shares of the annotated multishare PVCs to another instance. Requires Multishare.
+	ShareMigration featuregate.Feature = "ShareMigration"
+	// MultishareRebalancer enables the consolidation plans of the under-utilized multishare instances. Requires Multishare,
+	// and ShareMigration to execute the plans.
+	MultishareRebalancer featuregate.Feature = "MultishareRebalancer"
+	// VolumeRestore enables the in-place restores of backups to the volumes of the annotated PVCs.
+	VolumeRestore featuregate.Feature = "VolumeRestore"
+	// MultishareDeleteBatching enables the per-instance queues coalescing the deletions of the shares of multishare
+	// instances. Requires Multishare.
+	MultishareDeleteBatching featuregate.Feature = "MultishareDeleteBatching"
+	// InstanceLabelReconciler enables the periodic re-application of the instance pool tag and cluster labels removed
+	// or edited out of band on the multishare instances. Requires Multishare.
+	InstanceLabelReconciler featuregate.Feature = "InstanceLabelReconciler"
+	// ReplicaPromotion enables the failovers of the volumes of the annotated PVCs to a replica of their instance.
+	ReplicaPromotion featuregate.Feature = "ReplicaPromotion"
+	// BackupPolicy enables the backups of the PVCs scheduled by the BackupPolicy resources.
+	BackupPolicy featuregate.Feature = "BackupPolicy"
+	// RestoreProgress enables the progress events of the restores of backups on their PVCs, and the restore duration metric.
+	RestoreProgress featuregate.Feature = "RestoreProgress"
+	// NFSStats enables the metrics of the NFS client statistics of the staged mounts.
+	NFSStats featuregate.Feature = "NFSStats"
+	// InstanceIPRefresh enables the mounts of the volumes from the current IP of their instance, published by the
+	// controller, instead of the IP recorded on their PV.
+	InstanceIPRefresh featuregate.Feature = "InstanceIPRefresh"
+	// DeferredInstanceCreation enables the deferral of the creation of the instances of the PVCs no pod uses yet.
+	DeferredInstanceCreation featuregate.Feature = "DeferredInstanceCreation"
+	// CostEstimation enables the estimated spend metric of the instances created and expanded by the controller, and the
+	// events on the PVCs whose new instance is estimated to cost more than the alert threshold.
+	CostEstimation featuregate.Feature = "CostEstimation"
+	// ShareExportAnnotations enables the overrides of the NFS export options of the shares of the new multishare volumes
+	// by the annotations of their PVC. Requires Multishare and NFSExportOptionsOnCreate.
+	ShareExportAnnotations featuregate.Feature = "ShareExportAnnotations"
+	// CapacityWatermark enables the used capacity metrics of the staged volumes on the nodes, and the events on the PVCs
+	// whose volume is fuller than the capacity watermark.
+	CapacityWatermark featuregate.Feature = "CapacityWatermark"
+	// AutoExpansion enables the expansion of the PVCs annotated with filestore.csi/autoexpand when their used capacity
+	// crosses a threshold.
+	AutoExpansion featuregate.Feature = "AutoExpansion"
+)
+
+// defaultFeatureGates are the features known to this release of the driver, with their
+// default state and maturity. Update the defaults here when a feature graduates.
+var defaultFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
+	Multishare:               {Default: false, PreRelease: featuregate.Beta},
+	MultishareBackups:        {Default: false, PreRelease: featuregate.Alpha},
+	MaxSharesPerInstance:     {Default: false, PreRelease: featuregate.Alpha},
+	StatefulMultishare:       {Default: false, PreRelease: featuregate.Alpha},
+	LockRelease:              {Default: false, PreRelease: featuregate.Alpha},
+	NFSExportOptionsOnCreate: {Default: false, PreRelease: featuregate.Alpha},
+	MountHealthReporter:      {Default: false, PreRelease: featuregate.Alpha},
+	InstanceEvents:           {Default: false, PreRelease: featuregate.Alpha},
+	TierRecommendations:      {Default: false, PreRelease: featuregate.Alpha},
+	DeleteRetryQueue:         {Default: false, PreRelease: featuregate.Alpha},
+	ShareMigration:           {Default: false, PreRelease: featuregate.Alpha},
+	MultishareRebalancer:     {Default: false, PreRelease: featuregate.Alpha},
+	VolumeRestore:            {Default: false, PreRelease: featuregate.Alpha},
+	MultishareDeleteBatching: {Default: false, PreRelease: featuregate.Alpha},
+	InstanceLabelReconciler:  {Default: false, PreRelease: featuregate.Alpha},
+	ReplicaPromotion:         {Default: false, PreRelease: featuregate.Alpha},
+	BackupPolicy:             {Default: false, PreRelease: featuregate.Alpha},
+	RestoreProgress:          {Default: false, PreRelease: featuregate.Alpha},
+	NFSStats:                 {Default: false, PreRelease: featuregate.Alpha},
+	InstanceIPRefresh:        {Default: false, PreRelease: featuregate.Alpha},
+	DeferredInstanceCreation: {Default: false, PreRelease: featuregate.Alpha},
+	CostEstimation:           {Default: false, PreRelease: featuregate.Alpha},
+	ShareExportAnnotations:   {Default: false, PreRelease: featuregate.Alpha},
+	CapacityWatermark:        {Default: false, PreRelease: featuregate.Alpha},
+	AutoExpansion:            {Default: false, PreRelease: featuregate.Alpha},
+}
+
+// DeprecatedFlags maps the feature flags predating --feature-gates to the feature they set.
+var DeprecatedFlags = map[string]featuregate.Feature{
+	"enable-multishare":               Multishare,
+	"feature-multishare-backups":      MultishareBackups,
+	"feature-max-shares-per-instance": MaxSharesPerInstance,
+	"feature-stateful-multishare":     StatefulMultishare,
+	"feature-lock-release":            LockRelease,
+	"feature-nfs-export-options":      NFSExportOptionsOnCreate,
+	"feature-mount-health-reporter":   MountHealthReporter,
+}
+
+// FeatureGate is the feature gate of the driver.
+var FeatureGate = NewFeatureGate()
+
+// NewFeatureGate returns a feature gate knowing the features of the driver, set to their defaults.
+func NewFeatureGate() featuregate.MutableFeatureGate {
+	gate := featuregate.NewFeatureGate()
+	utilruntime.Must(gate.Add(defaultFeatureGates))
+	return gate
+}
+
+// Flag is the value of the --feature-gates flag. It sets the features of a feature gate from
+// a comma separated list of key=value pairs, and records the features set explicitly.
+type Flag struct {
+	gate featuregate.MutableFeatureGate
+	set  map[featuregate.Feature]bool
+}
+
+var _ flag.Value = &Flag{}
+
+func NewFlag(gate featuregate.MutableFeatureGate) *Flag {
+	return &Flag{
+		gate: gate,
+		set:  make(map[featuregate.Feature]bool),
+	}
+}
+
+func (f *Flag) String() string {
+	if f == nil {
+		return ""
+	}
+	var pairs []string
+	for k, v := range f.set {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *Flag) Set(value string) error {
+	m := make(map[string]bool)
+	for _, s := range strings.Split(value, ",") {
+		if len(strings.TrimSpace(s)) == 0 {
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		k := strings.TrimSpace(kv[0])
+		if len(kv) != 2 {
+			return fmt.Errorf("missing bool value for feature gate %s", k)
+		}
+		v, err := strconv.ParseBool(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return fmt.Errorf("invalid value of feature gate %s=%s: %w", k, kv[1], err)
+		}
+		m[k] = v
+	}
+	if err := f.gate.SetFromMap(m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		f.set[featuregate.Feature(k)] = v
+	}
+	return nil
+}
+
+// ApplyDeprecatedFlags sets the features of the deprecated boolean flags set in fs, e.g. on
+// the command line. It fails if a deprecated flag conflicts with the --feature-gates flag.
+func (f *Flag) ApplyDeprecatedFlags(fs *flag.FlagSet, deprecated map[string]featuregate.Feature) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		feature, ok := deprecated[fl.Name]
+		if !ok || err != nil {
+			return
+		}
+		getter, ok := fl.Value.(flag.Getter)
+		if !ok {
+			err = fmt.Errorf("flag %s is not a boolean flag", fl.Name)
+			return
+		}
+		enabled, ok := getter.Get().(bool)
+		if !ok {
+			err = fmt.Errorf("flag %s is not a boolean flag", fl.Name)
+			return
+		}
+		if v, ok := f.set[feature]; ok {
+			if v != enabled {
+				err = fmt.Errorf("--%s=%t conflicts with --feature-gates=%s=%t", fl.Name, enabled, feature, v)
+			}
+			return
+		}
+		klog.Warningf("Flag --%s is deprecated, use --feature-gates=%s=%t instead", fl.Name, feature, enabled)
+		err = f.gate.SetFromMap(map[string]bool{string(feature): enabled})
+	})
+	return err
+}
+
+// Enabled returns the names of the enabled features, sorted, e.g. to report the configuration
+// of the driver.
+func Enabled(gate featuregate.MutableFeatureGate) []string {
+	var enabled []string
+	for feature := range gate.GetAll() {
+		if feature == "AllAlpha" || feature == "AllBeta" {
+			continue
+		}
+		if gate.Enabled(feature) {
+			enabled = append(enabled, string(feature))
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+// Describe returns the state of the known features, one "<feature>=<enabled> (<stage> - default=<default>)"
+// line per feature, sorted by feature name.
+func Describe(gate featuregate.MutableFeatureGate) []string {
+	var lines []string
+	for feature, spec := range gate.GetAll() {
+		// Skip the gates enabling all the alpha or beta features at once.
+		if feature == "AllAlpha" || feature == "AllBeta" {
+			continue
+		}
+		stage := string(spec.PreRelease)
+		if stage == "" {
+			stage = "GA"
+		}
+		lines = append(lines, fmt.Sprintf("%s=%t (%s - default=%t)", feature, gate.Enabled(feature), stage, spec.Default))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// LogFeatureGates logs the state of the known features, e.g. at startup.
+func LogFeatureGates(gate featuregate.MutableFeatureGate) {
+	klog.Infof("Feature gates:")
+	for _, line := range Describe(gate) {
+		klog.Infof("  %s", line)
+	}
+}
+
+// Handler serves the state of the known features, e.g. on /featurez.
+func Handler(gate featuregate.MutableFeatureGate) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range Describe(gate) {
+			fmt.Fprintln(w, line)
+		}
+	})
+}
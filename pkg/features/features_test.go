@@ -0,0 +1,215 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package features
+
+import (
+	"flag"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/component-base/featuregate"
+)
+
+func TestFlagSet(t *testing.T) {
+	cases := []struct {
+		name           string
+		value          string
+		expectErr      bool
+		expectEnabled  []featuregate.Feature
+		expectedString string
+	}{
+		{
+			name:  "empty",
+			value: "",
+		},
+		{
+			name:           "single feature",
+			value:          "Multishare=true",
+			expectEnabled:  []featuregate.Feature{Multishare},
+			expectedString: "Multishare=true",
+		},
+		{
+			name:           "multiple features with spaces",
+			value:          " Multishare=true, LockRelease=true ,MountHealthReporter=false",
+			expectEnabled:  []featuregate.Feature{Multishare, LockRelease},
+			expectedString: "LockRelease=true,MountHealthReporter=false,Multishare=true",
+		},
+		{
+			name:          "all alpha features",
+			value:         "AllAlpha=true",
+			expectEnabled: []featuregate.Feature{LockRelease, MaxSharesPerInstance, MountHealthReporter},
+		},
+		{
+			name:      "unknown feature",
+			value:     "Unknown=true",
+			expectErr: true,
+		},
+		{
+			name:      "missing value",
+			value:     "Multishare",
+			expectErr: true,
+		},
+		{
+			name:      "invalid value",
+			value:     "Multishare=yes",
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gate := NewFeatureGate()
+			f := NewFlag(gate)
+			err := f.Set(tc.value)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, feature := range tc.expectEnabled {
+				if !gate.Enabled(feature) {
+					t.Errorf("expected feature %s to be enabled", feature)
+				}
+			}
+			if tc.expectedString != "" && f.String() != tc.expectedString {
+				t.Errorf("got flag string %q, expected %q", f.String(), tc.expectedString)
+			}
+		})
+	}
+}
+
+func TestApplyDeprecatedFlags(t *testing.T) {
+	cases := []struct {
+		name          string
+		args          []string
+		expectErr     bool
+		expectEnabled map[featuregate.Feature]bool
+	}{
+		{
+			name: "no flags",
+			expectEnabled: map[featuregate.Feature]bool{
+				Multishare:  false,
+				LockRelease: false,
+			},
+		},
+		{
+			name: "deprecated flag enables feature",
+			args: []string{"--enable-multishare=true"},
+			expectEnabled: map[featuregate.Feature]bool{
+				Multishare:  true,
+				LockRelease: false,
+			},
+		},
+		{
+			name: "deprecated flag and feature gates combined",
+			args: []string{"--enable-multishare=true", "--feature-gates=LockRelease=true"},
+			expectEnabled: map[featuregate.Feature]bool{
+				Multishare:  true,
+				LockRelease: true,
+			},
+		},
+		{
+			name: "deprecated flag agreeing with feature gates",
+			args: []string{"--feature-gates=Multishare=true", "--enable-multishare=true"},
+			expectEnabled: map[featuregate.Feature]bool{
+				Multishare: true,
+			},
+		},
+		{
+			name:      "deprecated flag conflicting with feature gates",
+			args:      []string{"--feature-gates=Multishare=false", "--enable-multishare=true"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gate := NewFeatureGate()
+			f := NewFlag(gate)
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Bool("enable-multishare", false, "")
+			fs.Bool("feature-lock-release", false, "")
+			fs.Var(f, "feature-gates", "")
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatalf("failed to parse flags: %v", err)
+			}
+
+			err := f.ApplyDeprecatedFlags(fs, DeprecatedFlags)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for feature, enabled := range tc.expectEnabled {
+				if gate.Enabled(feature) != enabled {
+					t.Errorf("got feature %s enabled %t, expected %t", feature, gate.Enabled(feature), enabled)
+				}
+			}
+		})
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	gate := NewFeatureGate()
+	if enabled := Enabled(gate); len(enabled) != 0 {
+		t.Errorf("got enabled features %v, expected none", enabled)
+	}
+	if err := NewFlag(gate).Set("Multishare=true,LockRelease=true"); err != nil {
+		t.Fatalf("failed to set feature gates: %v", err)
+	}
+	expected := []string{"LockRelease", "Multishare"}
+	if enabled := Enabled(gate); !reflect.DeepEqual(enabled, expected) {
+		t.Errorf("got enabled features %v, expected %v", enabled, expected)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	gate := NewFeatureGate()
+	if err := NewFlag(gate).Set("Multishare=true"); err != nil {
+		t.Fatalf("failed to set feature gates: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	Handler(gate).ServeHTTP(w, httptest.NewRequest("GET", "/featurez", nil))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != len(defaultFeatureGates) {
+		t.Errorf("got %d features, expected %d: %v", len(lines), len(defaultFeatureGates), lines)
+	}
+	for _, expected := range []string{
+		"Multishare=true (BETA - default=false)",
+		"LockRelease=false (ALPHA - default=false)",
+	} {
+		found := false
+		for _, line := range lines {
+			if line == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected line %q in %v", expected, lines)
+		}
+	}
+}
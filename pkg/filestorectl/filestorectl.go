@@ -0,0 +1,339 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package filestorectl implements the commands of filestorectl, which inspects the volume
+// handles of the driver and the Filestore instances and shares backing them.
+package filestorectl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	driver "sigs.k8s.io/gcp-filestore-csi-driver/pkg/csi_driver"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// excludeFromPackingLabel drains a multishare instance of new shares.
+	excludeFromPackingLabel = driver.TagKeyExcludeFromPacking
+
+	// provisionedByAnnotation records the provisioner of a PV, the one deleting its volume.
+	provisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+)
+
+// Inspector runs the commands against the Filestore resources of a project.
+type Inspector struct {
+	fileService file.Service
+	project     string
+	driverName  string
+	out         io.Writer
+}
+
+func NewInspector(fileService file.Service, project, driverName string, out io.Writer) *Inspector {
+	return &Inspector{
+		fileService: fileService,
+		project:     project,
+		driverName:  driverName,
+		out:         out,
+	}
+}
+
+// DescribeHandle prints the fields of a volume handle and the instance or share backing it.
+func (i *Inspector) DescribeHandle(ctx context.Context, handle string) error {
+	h, err := driver.ParseVolumeHandle(handle)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(i.out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	if !h.Multishare {
+		mode := "instance"
+		if h.Legacy {
+			mode = "legacy " + driver.LegacyProvisionerName
+		}
+		fmt.Fprintf(w, "Mode:\t%s\nLocation:\t%s\nInstance:\t%s\nFile share:\t%s\n", mode, h.Location, h.Instance, h.Share)
+		instance, err := i.fileService.GetInstance(ctx, &file.ServiceInstance{Project: i.project, Location: h.Location, Name: h.Instance})
+		if file.IsNotFoundErr(err) {
+			fmt.Fprintf(w, "Status:\tinstance not found in project %s\n", i.project)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "State:\t%s\nTier:\t%s\nIP:\t%s\nNetwork:\t%s\nCapacity:\t%d GiB\nCreated by driver:\t%t\n",
+			instance.State, instance.Tier, instance.Network.Ip, instance.Network.Name, util.BytesToGb(instance.Volume.SizeBytes), driver.CreatedByDriver(instance.Labels, i.driverName))
+		if h.Share != "" && instance.Volume.Name != h.Share {
+			fmt.Fprintf(w, "Warning:\tthe instance serves file share %q\n", instance.Volume.Name)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(w, "Mode:\tmultishare\nInstance storage class label:\t%s\nProject:\t%s\nLocation:\t%s\nInstance:\t%s\nShare:\t%s\n",
+		h.InstanceStorageClassLabel, h.Project, h.Location, h.Instance, h.Share)
+	share, err := i.fileService.GetShare(ctx, &file.Share{
+		Name:   h.Share,
+		Parent: &file.MultishareInstance{Project: h.Project, Location: h.Location, Name: h.Instance},
+	})
+	if file.IsNotFoundErr(err) {
+		fmt.Fprintf(w, "Status:\tshare not found\n")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "State:\t%s\nCapacity:\t%d GiB\nMount point:\t%s\n", share.State, util.BytesToGb(share.CapacityBytes), share.MountPointName)
+	if share.Parent != nil {
+		fmt.Fprintf(w, "Instance state:\t%s\nInstance IP:\t%s\n", share.Parent.State, share.Parent.Network.Ip)
+		if label := share.Parent.Labels[util.ParamMultishareInstanceScLabelKey]; label != h.InstanceStorageClassLabel {
+			fmt.Fprintf(w, "Warning:\tthe instance storage class label is %q\n", label)
+		}
+	}
+	return nil
+}
+
+// ListInstances prints the instances created by the driver in a location, "-" for all, and
+// the packing state of the multishare instances.
+func (i *Inspector) ListInstances(ctx context.Context, location string) error {
+	instances, multishareInstances, err := i.driverInstances(ctx, location)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(i.out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "LOCATION\tINSTANCE\tMODE\tTIER\tSTATE\tCAPACITY\tSHARES\tALLOCATED\tPACKING")
+	for _, instance := range instances {
+		fmt.Fprintf(w, "%s\t%s\tinstance\t%s\t%s\t%dGiB\t1\t%dGiB\t-\n",
+			instance.Location, instance.Name, instance.Tier, instance.State, util.BytesToGb(instance.Volume.SizeBytes), util.BytesToGb(instance.Volume.SizeBytes))
+	}
+	for _, instance := range multishareInstances {
+		shares, err := i.fileService.ListShares(ctx, &file.ListFilter{Project: instance.Project, Location: instance.Location, InstanceName: instance.Name})
+		if err != nil {
+			return err
+		}
+		var allocatedBytes int64
+		for _, share := range shares {
+			allocatedBytes += share.CapacityBytes
+		}
+		maxShareCount := instance.MaxShareCount
+		if maxShareCount == 0 {
+			maxShareCount = util.MaxSharesPerInstance
+		}
+		fmt.Fprintf(w, "%s\t%s\tmultishare\t%s\t%s\t%dGiB\t%d/%d\t%dGiB\t%s\n",
+			instance.Location, instance.Name, instance.Tier, instance.State, util.BytesToGb(instance.CapacityBytes),
+			len(shares), maxShareCount, util.BytesToGb(allocatedBytes), packingState(instance, len(shares), maxShareCount))
+	}
+	return nil
+}
+
+// packingState reports whether new shares can be placed on a multishare instance.
+func packingState(instance *file.MultishareInstance, shareCount, maxShareCount int) string {
+	switch {
+	case instance.State != "READY":
+		return "not-ready"
+	case isExcluded(instance):
+		return "excluded"
+	case shareCount >= maxShareCount:
+		return "full"
+	default:
+		return "eligible"
+	}
+}
+
+func isExcluded(instance *file.MultishareInstance) bool {
+	excluded, _ := strconv.ParseBool(instance.Labels[excludeFromPackingLabel])
+	return excluded
+}
+
+// FindOrphans prints the instances and shares created by the driver which back no PV of the
+// driver, and the PVs of the driver whose instance or share is missing.
+func (i *Inspector) FindOrphans(ctx context.Context, kubeClient kubernetes.Interface) error {
+	pvs, err := kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	// handles maps the location/instance/share key of each volume to its PV.
+	handles := make(map[string]string)
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != i.driverName {
+			continue
+		}
+		h, err := driver.ParseVolumeHandle(pv.Spec.CSI.VolumeHandle)
+		if err != nil {
+			fmt.Fprintf(i.out, "PV %s has an invalid volume handle %q: %v\n", pv.Name, pv.Spec.CSI.VolumeHandle, err)
+			continue
+		}
+		handles[volumeKey(h.Location, h.Instance, h.Share)] = pv.Name
+	}
+	// The legacy handles holding the resource name of an instance don't record its share.
+	backed := func(key, instanceKey string) bool {
+		_, ok := handles[key]
+		_, legacyOK := handles[instanceKey]
+		return ok || legacyOK
+	}
+
+	instances, multishareInstances, err := i.driverInstances(ctx, "-")
+	if err != nil {
+		return err
+	}
+	var orphans []string
+	found := make(map[string]bool)
+	for _, instance := range instances {
+		key, instanceKey := volumeKey(instance.Location, instance.Name, instance.Volume.Name), volumeKey(instance.Location, instance.Name, "")
+		found[key], found[instanceKey] = true, true
+		if !backed(key, instanceKey) {
+			orphans = append(orphans, fmt.Sprintf("instance %s/%s", instance.Location, instance.Name))
+		}
+	}
+	for _, instance := range multishareInstances {
+		shares, err := i.fileService.ListShares(ctx, &file.ListFilter{Project: instance.Project, Location: instance.Location, InstanceName: instance.Name})
+		if err != nil {
+			return err
+		}
+		if len(shares) == 0 {
+			orphans = append(orphans, fmt.Sprintf("multishare instance %s/%s without shares", instance.Location, instance.Name))
+		}
+		for _, share := range shares {
+			key := volumeKey(instance.Location, instance.Name, share.Name)
+			found[key] = true
+			if _, ok := handles[key]; !ok {
+				orphans = append(orphans, fmt.Sprintf("share %s/%s/%s", instance.Location, instance.Name, share.Name))
+			}
+		}
+	}
+	var dangling []string
+	for key, pvName := range handles {
+		if !found[key] {
+			dangling = append(dangling, fmt.Sprintf("PV %s (%s)", pvName, key))
+		}
+	}
+	sort.Strings(orphans)
+	sort.Strings(dangling)
+
+	fmt.Fprintf(i.out, "%d Filestore resources created by %s backing no PV:\n", len(orphans), i.driverName)
+	for _, orphan := range orphans {
+		fmt.Fprintf(i.out, "  %s\n", orphan)
+	}
+	fmt.Fprintf(i.out, "%d PVs of %s whose instance or share is not found in project %s:\n", len(dangling), i.driverName, i.project)
+	for _, pv := range dangling {
+		fmt.Fprintf(i.out, "  %s\n", pv)
+	}
+	return nil
+}
+
+// MigrateLegacyPVs prints the PVs provisioned by the legacy gcp-filestore provisioner. The
+// provisioned-by annotation of the CSI PVs of the driver is updated to the driver name if apply
+// is set, so that they are deleted by the driver, which serves their legacy volume handles. The
+// other PVs, e.g. the NFS PVs, can't be migrated in place.
+func (i *Inspector) MigrateLegacyPVs(ctx context.Context, kubeClient kubernetes.Interface, apply bool) error {
+	pvs, err := kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	sort.Slice(pvs.Items, func(a, b int) bool { return pvs.Items[a].Name < pvs.Items[b].Name })
+	for idx := range pvs.Items {
+		pv := &pvs.Items[idx]
+		legacyAnnotation := pv.Annotations[provisionedByAnnotation] == driver.LegacyProvisionerName
+		legacyHandle := pv.Spec.CSI != nil && driver.IsLegacyVolumeHandle(pv.Spec.CSI.VolumeHandle)
+		if !legacyAnnotation && !legacyHandle {
+			continue
+		}
+		switch {
+		case pv.Spec.CSI == nil || pv.Spec.CSI.Driver != i.driverName:
+			fmt.Fprintf(i.out, "PV %s: not a CSI volume of %s, it can't be migrated in place\n", pv.Name, i.driverName)
+		case !legacyAnnotation:
+			fmt.Fprintf(i.out, "PV %s: legacy volume handle %s served by %s\n", pv.Name, pv.Spec.CSI.VolumeHandle, i.driverName)
+		case !apply:
+			fmt.Fprintf(i.out, "PV %s: %s annotation would be set to %s\n", pv.Name, provisionedByAnnotation, i.driverName)
+		default:
+			pv.Annotations[provisionedByAnnotation] = i.driverName
+			if _, err := kubeClient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("failed to update PV %s: %w", pv.Name, err)
+			}
+			fmt.Fprintf(i.out, "PV %s: %s annotation set to %s\n", pv.Name, provisionedByAnnotation, i.driverName)
+		}
+	}
+	return nil
+}
+
+// driverInstances returns the instance mode and multishare instances created by the driver in
+// a location, "-" for all, sorted by location and name.
+func (i *Inspector) driverInstances(ctx context.Context, location string) ([]*file.ServiceInstance, []*file.MultishareInstance, error) {
+	allInstances, err := i.fileService.ListInstances(ctx, &file.ServiceInstance{Project: i.project, Location: location})
+	if err != nil {
+		return nil, nil, err
+	}
+	var instances []*file.ServiceInstance
+	for _, instance := range allInstances {
+		if driver.CreatedByDriver(instance.Labels, i.driverName) && inLocation(instance.Location, location) {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Slice(instances, func(a, b int) bool {
+		return volumeKey(instances[a].Location, instances[a].Name, "") < volumeKey(instances[b].Location, instances[b].Name, "")
+	})
+
+	allMultishareInstances, err := i.fileService.ListMultishareInstances(ctx, &file.ListFilter{Project: i.project, Location: location})
+	if err != nil {
+		return nil, nil, err
+	}
+	var multishareInstances []*file.MultishareInstance
+	for _, instance := range allMultishareInstances {
+		if driver.CreatedByDriver(instance.Labels, i.driverName) && inLocation(instance.Location, location) {
+			multishareInstances = append(multishareInstances, instance)
+		}
+	}
+	sort.Slice(multishareInstances, func(a, b int) bool {
+		return volumeKey(multishareInstances[a].Location, multishareInstances[a].Name, "") < volumeKey(multishareInstances[b].Location, multishareInstances[b].Name, "")
+	})
+	return instances, multishareInstances, nil
+}
+
+func inLocation(instanceLocation, location string) bool {
+	return location == "-" || location == "" || instanceLocation == location
+}
+
+func volumeKey(location, instance, share string) string {
+	return location + "/" + instance + "/" + share
+}
+
+// ParseCommand returns the command and its argument from the positional arguments of the
+// binary, e.g. "handle <volume handle>".
+func ParseCommand(args []string) (string, string, error) {
+	if len(args) == 0 {
+		return "", "", fmt.Errorf("missing command, one of handle, instances, orphans or migrate")
+	}
+	switch args[0] {
+	case "handle":
+		if len(args) != 2 {
+			return "", "", fmt.Errorf("usage: handle <volume handle>")
+		}
+		return args[0], args[1], nil
+	case "instances", "orphans", "migrate":
+		if len(args) != 1 {
+			return "", "", fmt.Errorf("command %s takes no argument, got %d", args[0], len(args)-1)
+		}
+		return args[0], "", nil
+	default:
+		return "", "", fmt.Errorf("unknown command %q, one of handle, instances, orphans or migrate", args[0])
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filestorectl
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	testProject    = "test-project"
+	testRegion     = "us-central1"
+	testDriverName = "filestore.csi.storage.gke.io"
+)
+
+var driverLabels = map[string]string{
+	"storage_gke_io_created-by":            "filestore_csi_storage_gke_io",
+	util.ParamMultishareInstanceScLabelKey: "sc-a",
+}
+
+// listService lists the given instance mode instances.
+type listService struct {
+	file.Service
+	instances []*file.ServiceInstance
+}
+
+func (s *listService) ListInstances(ctx context.Context, obj *file.ServiceInstance) ([]*file.ServiceInstance, error) {
+	return s.instances, nil
+}
+
+func multishareInstance(name string, maxShareCount int, labels map[string]string) *file.MultishareInstance {
+	return &file.MultishareInstance{
+		Project:       testProject,
+		Location:      testRegion,
+		Name:          name,
+		Tier:          "ENTERPRISE",
+		CapacityBytes: 1 * util.Tb,
+		MaxShareCount: maxShareCount,
+		Labels:        labels,
+		State:         "READY",
+		Network:       file.Network{Ip: "10.0.0.2"},
+	}
+}
+
+func share(name string, parent *file.MultishareInstance) *file.Share {
+	return &file.Share{Name: name, Parent: parent, CapacityBytes: 100 * util.Gb, MountPointName: name, State: "READY"}
+}
+
+func newTestInspector(t *testing.T, instances []*file.ServiceInstance, multishareInstances []*file.MultishareInstance, shares []*file.Share) (*Inspector, *bytes.Buffer) {
+	fileService, err := file.NewFakeServiceForMultishare(multishareInstances, shares, nil)
+	if err != nil {
+		t.Fatalf("failed to create fake service: %v", err)
+	}
+	out := &bytes.Buffer{}
+	return NewInspector(&listService{Service: fileService, instances: instances}, testProject, testDriverName, out), out
+}
+
+func TestDescribeHandle(t *testing.T) {
+	instance := multishareInstance("fs-a", 0, driverLabels)
+	cases := []struct {
+		name             string
+		handle           string
+		expectedErr      bool
+		expectedMessages []string
+	}{
+		{
+			name:             "multishare volume",
+			handle:           "modeMultishare/sc-a/test-project/us-central1/fs-a/pvc-a",
+			expectedMessages: []string{"Share:", "pvc-a", "Instance IP:", "10.0.0.2", "Mount point:"},
+		},
+		{
+			name:             "multishare volume of a deleted share",
+			handle:           "modeMultishare/sc-a/test-project/us-central1/fs-a/pvc-b",
+			expectedMessages: []string{"share not found"},
+		},
+		{
+			name:             "instance mode volume of a deleted instance",
+			handle:           "modeInstance/us-central1-c/pvc-c/vol1",
+			expectedMessages: []string{"Mode:", "instance", "instance not found in project test-project"},
+		},
+		{
+			name:        "invalid handle",
+			handle:      "modeInstance/us-central1-c",
+			expectedErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			inspector, out := newTestInspector(t, nil, []*file.MultishareInstance{instance}, []*file.Share{share("pvc-a", instance)})
+			err := inspector.DescribeHandle(context.Background(), tc.handle)
+			if gotErr := err != nil; gotErr != tc.expectedErr {
+				t.Fatalf("got error %v, expected error %v", err, tc.expectedErr)
+			}
+			for _, message := range tc.expectedMessages {
+				if !strings.Contains(out.String(), message) {
+					t.Errorf("got output %q, expected it to contain %q", out.String(), message)
+				}
+			}
+		})
+	}
+}
+
+func TestListInstances(t *testing.T) {
+	full := multishareInstance("fs-full", 1, driverLabels)
+	excluded := multishareInstance("fs-excluded", 0, map[string]string{
+		"storage_gke_io_created-by": "filestore_csi_storage_gke_io",
+		"exclude-from-packing":      "true",
+	})
+	eligible := multishareInstance("fs-eligible", 0, driverLabels)
+	other := multishareInstance("fs-other", 0, nil)
+	instances := []*file.ServiceInstance{
+		{Project: testProject, Location: "us-central1-c", Name: "pvc-i", Tier: "BASIC_HDD", State: "READY", Labels: driverLabels, Volume: file.Volume{Name: "vol1", SizeBytes: util.Tb}},
+		{Project: testProject, Location: "us-central1-c", Name: "manual", Tier: "BASIC_HDD", State: "READY"},
+	}
+	inspector, out := newTestInspector(t, instances, []*file.MultishareInstance{full, excluded, eligible, other}, []*file.Share{share("pvc-a", full)})
+	if err := inspector.ListInstances(context.Background(), "-"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := [][]string{
+		{"LOCATION", "INSTANCE"},
+		{"us-central1-c", "pvc-i", "instance", "1024GiB"},
+		{"us-central1", "fs-eligible", "multishare", "0/10", "eligible"},
+		{"us-central1", "fs-excluded", "multishare", "0/10", "excluded"},
+		{"us-central1", "fs-full", "multishare", "1/1", "100GiB", "full"},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("got output %q, expected %d lines", out.String(), len(expected))
+	}
+	for i, fields := range expected {
+		for _, field := range fields {
+			if !strings.Contains(lines[i], field) {
+				t.Errorf("got line %q, expected it to contain %q", lines[i], field)
+			}
+		}
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	instance := multishareInstance("fs-a", 0, driverLabels)
+	empty := multishareInstance("fs-empty", 0, driverLabels)
+	instances := []*file.ServiceInstance{
+		{Project: testProject, Location: "us-central1-c", Name: "pvc-i", Labels: driverLabels, Volume: file.Volume{Name: "vol1"}},
+		{Project: testProject, Location: "us-central1-c", Name: "pvc-j", Labels: driverLabels, Volume: file.Volume{Name: "vol1"}},
+	}
+	inspector, out := newTestInspector(t, instances, []*file.MultishareInstance{instance, empty}, []*file.Share{share("pvc-a", instance), share("pvc-b", instance)})
+	pv := func(name, driver, handle string) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: handle},
+			}},
+		}
+	}
+	kubeClient := fake.NewSimpleClientset(
+		pv("pv-a", testDriverName, "modeMultishare/sc-a/test-project/us-central1/fs-a/pvc-a"),
+		pv("pv-i", testDriverName, "modeInstance/us-central1-c/pvc-i/vol1"),
+		pv("pv-gone", testDriverName, "modeInstance/us-central1-c/pvc-gone/vol1"),
+		pv("pv-other", "pd.csi.storage.gke.io", "projects/test-project/zones/us-central1-c/disks/pvc-j"),
+	)
+	if err := inspector.FindOrphans(context.Background(), kubeClient); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `3 Filestore resources created by filestore.csi.storage.gke.io backing no PV:
+  instance us-central1-c/pvc-j
+  multishare instance us-central1/fs-empty without shares
+  share us-central1/fs-a/pvc-b
+1 PVs of filestore.csi.storage.gke.io whose instance or share is not found in project test-project:
+  PV pv-gone (us-central1-c/pvc-gone/vol1)
+`
+	if out.String() != expected {
+		t.Errorf("got output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestMigrateLegacyPVs(t *testing.T) {
+	inspector, out := newTestInspector(t, nil, nil, nil)
+	pv := func(name, provisioner string, source v1.PersistentVolumeSource) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{provisionedByAnnotation: provisioner}},
+			Spec:       v1.PersistentVolumeSpec{PersistentVolumeSource: source},
+		}
+	}
+	csiSource := func(handle string) v1.PersistentVolumeSource {
+		return v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: testDriverName, VolumeHandle: handle}}
+	}
+	kubeClient := fake.NewSimpleClientset(
+		pv("pv-legacy", "gcp-filestore", csiSource("projects/test-project/locations/us-central1-c/instances/pvc-l")),
+		pv("pv-handle", testDriverName, csiSource("us-central1-c/pvc-h/vol1")),
+		pv("pv-nfs", "gcp-filestore", v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "10.0.0.2", Path: "/vol1"}}),
+		pv("pv-new", testDriverName, csiSource("modeInstance/us-central1-c/pvc-i/vol1")),
+	)
+
+	if err := inspector.MigrateLegacyPVs(context.Background(), kubeClient, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `PV pv-handle: legacy volume handle us-central1-c/pvc-h/vol1 served by filestore.csi.storage.gke.io
+PV pv-legacy: pv.kubernetes.io/provisioned-by annotation would be set to filestore.csi.storage.gke.io
+PV pv-nfs: not a CSI volume of filestore.csi.storage.gke.io, it can't be migrated in place
+`
+	if out.String() != expected {
+		t.Errorf("got dry run output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	if err := inspector.MigrateLegacyPVs(context.Background(), kubeClient, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	migrated, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), "pv-legacy", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provisioner := migrated.Annotations[provisionedByAnnotation]; provisioner != testDriverName {
+		t.Errorf("got provisioner %q, expected %q", provisioner, testDriverName)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		args            []string
+		expectedCommand string
+		expectedArg     string
+		expectedErr     bool
+	}{
+		{args: []string{"handle", "modeInstance/us-central1-c/pvc-i/vol1"}, expectedCommand: "handle", expectedArg: "modeInstance/us-central1-c/pvc-i/vol1"},
+		{args: []string{"instances"}, expectedCommand: "instances"},
+		{args: []string{"orphans"}, expectedCommand: "orphans"},
+		{args: []string{"migrate"}, expectedCommand: "migrate"},
+		{args: []string{"handle"}, expectedErr: true},
+		{args: []string{"orphans", "extra"}, expectedErr: true},
+		{args: []string{"delete"}, expectedErr: true},
+		{expectedErr: true},
+	}
+	for _, tc := range cases {
+		command, arg, err := ParseCommand(tc.args)
+		if gotErr := err != nil; gotErr != tc.expectedErr {
+			t.Errorf("%v: got error %v, expected error %v", tc.args, err, tc.expectedErr)
+			continue
+		}
+		if command != tc.expectedCommand || arg != tc.expectedArg {
+			t.Errorf("%v: got command %q and argument %q, expected %q and %q", tc.args, command, arg, tc.expectedCommand, tc.expectedArg)
+		}
+	}
+}
@@ -16,9 +16,11 @@ package metrics
 import (
 	"fmt"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/component-base/metrics"
@@ -61,11 +63,100 @@ const (
 	ReconcilerOpSource  = "lock_release_reconciler"
 	// Label status_code indicates whether the lock release rpc call succeeds or not.
 	labelLockReleaseStatusCode = "status_code"
+
+	// Node mount health metrics.
+	mountHealthReachableMetricName = "mount_health_reachable"
+	mountHealthLatencyMetricName   = "mount_health_probe_latency_seconds"
+	mountHealthUsedBytesMetricName = "mount_health_used_bytes"
+	// Label volume_id indicates the CSI volume handle of the staged mount being probed.
+	labelVolumeID = "volume_id"
+
+	// Node tier recommendation metrics.
+	tierRecommendationMetricName = "tier_recommendation"
+	peakThroughputMetricName     = "volume_peak_throughput_bytes_per_second"
+	// Label tier indicates the provisioned tier of the volume.
+	labelTier = "tier"
+	// Label recommendation indicates the right-sizing recommendation for the volume.
+	labelRecommendation = "recommendation"
+
+	// Controller delete queue metrics.
+	deleteQueueDepthMetricName   = "delete_queue_depth"
+	deleteQueueStuckMetricName   = "delete_queue_stuck_volumes"
+	deleteQueueRetriesMetricName = "delete_queue_retries_count"
+
+	// Multishare excluded instances metrics.
+	excludedInstanceMetricName = "multishare_excluded_instance"
+	// Label instance_uri indicates the URI of the Filestore instance.
+	labelInstanceURI = "instance_uri"
+	// Label state indicates the state of the Filestore instance.
+	labelState = "state"
+
+	// Multishare running operations metrics.
+	runningOpsMetricName  = "multishare_running_operations"
+	stuckOpsMetricName    = "multishare_stuck_operations"
+	oldestOpAgeMetricName = "multishare_oldest_operation_age_seconds"
+	// Label operation_type indicates the type of the Filestore operation, e.g. sharecreate.
+	labelOperationType = "operation_type"
+
+	// Multishare delete batching metrics.
+	queuedShareDeletionsMetricName  = "multishare_queued_share_deletions"
+	batchedShareDeletionsMetricName = "multishare_batched_share_deletions_count"
+
+	// Multishare instance pool metrics.
+	instancePoolInflightOpsMetricName = "multishare_instance_pool_inflight_operations"
+	instancePoolQueuedOpsMetricName   = "multishare_instance_pool_queued_operations"
+	instancePoolRejectedOpsMetricName = "multishare_instance_pool_rejected_operations_count"
+	// Label instance_pool_tag indicates the instance-storageclass-label of the multishare instance pool.
+	labelInstancePoolTag = "instance_pool_tag"
+
+	// Multishare regional capacity limit metrics.
+	regionalCapacityRejectedOpsMetricName = "multishare_regional_capacity_rejected_operations_count"
+	// Label location indicates the region of the multishare instances.
+	labelLocation = "location"
+
+	// VPC network exhaustion metrics.
+	networkExhaustionErrorsMetricName = "network_exhaustion_errors_count"
+	// Label network indicates the VPC network of the instances.
+	labelNetwork = "network"
+	// Label reason indicates the reason of the exhaustion, e.g. RANGES_EXHAUSTED.
+	labelReason = "reason"
+
+	// Node NFS client statistics metrics.
+	nfsBytesMetricName           = "nfs_bytes"
+	nfsOperationsMetricName      = "nfs_operations"
+	nfsRetransmissionsMetricName = "nfs_retransmissions"
+	nfsRTTMetricName             = "nfs_rtt_seconds"
+	// Label direction indicates whether the bytes are read or written.
+	labelDirection = "direction"
+	// Label nfs_operation indicates the NFS operation, e.g. READ or GETATTR.
+	labelNFSOperation = "nfs_operation"
+
+	// Backup restore metrics.
+	restoreDurationMetricName = "restore_duration_seconds"
+	// Label restore_type indicates whether the backup is restored to a new volume or in place.
+	labelRestoreType = "restore_type"
+
+	// Instance cost estimation metrics.
+	estimatedSpendMetricName = "estimated_monthly_spend_dollars_count"
+	// Label instance_operation indicates whether the instance was created or expanded.
+	labelInstanceOperation = "instance_operation"
+
+	// Node capacity watermark metrics.
+	usedCapacityPercentMetricName = "volume_used_capacity_percent"
+	aboveWatermarkMetricName      = "volume_above_capacity_watermark"
+
+	// Volume lock metrics.
+	lockContentionsMetricName = "volume_lock_contentions_count"
+
+	// CSI RPC metrics.
+	rpcDurationMetricName = "rpc_duration_seconds"
 )
 
 var (
 	metricBuckets        = []float64{.1, .25, .5, 1, 2.5, 5, 10, 15, 30, 60, 120, 300, 600}
 	kubeAPIMetricBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024}
+	// Restores of multi-TiB backups take hours.
+	restoreMetricBuckets = []float64{60, 300, 600, 1800, 3600, 7200, 14400, 28800, 57600, 86400}
 
 	// This metric is exposed only from the controller driver component when GKE_FILESTORECSI_VERSION env variable is set.
 	gkeComponentVersion = metrics.NewGaugeVec(&metrics.GaugeOpts{
@@ -100,10 +191,273 @@ var (
 		},
 		[]string{labelOpStatusCode, labelResourceType, labelOpType, labelOpSource},
 	)
+
+	mountHealthReachable = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      mountHealthReachableMetricName,
+			Help:      "Metric to expose whether a staged Filestore mount responded to the last health probe (1) or not (0).",
+		},
+		[]string{labelVolumeID},
+	)
+
+	mountHealthLatencySeconds = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      mountHealthLatencyMetricName,
+			Help:      "Metric to expose the latency of the last health probe on a staged Filestore mount.",
+		},
+		[]string{labelVolumeID},
+	)
+
+	mountHealthUsedBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      mountHealthUsedBytesMetricName,
+			Help:      "Metric to expose the used bytes reported by the last successful health probe on a staged Filestore mount.",
+		},
+		[]string{labelVolumeID},
+	)
+
+	tierRecommendation = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      tierRecommendationMetricName,
+			Help:      "Metric to expose the right-sizing recommendation for a staged Filestore volume, based on the usage observed by the node. The value is always 1.",
+		},
+		[]string{labelVolumeID, labelTier, labelRecommendation},
+	)
+
+	peakThroughputBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      peakThroughputMetricName,
+			Help:      "Metric to expose the peak NFS throughput of a staged Filestore volume observed by the node over the analysis window.",
+		},
+		[]string{labelVolumeID},
+	)
+
+	deleteQueueDepth = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      deleteQueueDepthMetricName,
+			Help:      "Metric to expose the number of volumes whose deletion is being retried by the controller delete queue.",
+		},
+	)
+
+	deleteQueueStuck = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      deleteQueueStuckMetricName,
+			Help:      "Metric to expose the number of volumes whose deletion by the controller delete queue has been failing for longer than the stuck threshold.",
+		},
+	)
+
+	excludedInstance = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      excludedInstanceMetricName,
+			Help:      "Metric to expose the multishare instances excluded from packing because of their state, e.g. SUSPENDED. The value is always 1.",
+		},
+		[]string{labelInstanceURI, labelState},
+	)
+
+	runningOps = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      runningOpsMetricName,
+			Help:      "Metric to expose the number of running multishare instance and share operations observed by the controller.",
+		},
+		[]string{labelOperationType},
+	)
+
+	stuckOps = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      stuckOpsMetricName,
+			Help:      "Metric to expose the number of running multishare instance and share operations older than the stuck threshold.",
+		},
+		[]string{labelOperationType},
+	)
+
+	oldestOpAgeSeconds = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      oldestOpAgeMetricName,
+			Help:      "Metric to expose the age of the oldest running multishare instance or share operation observed by the controller.",
+		},
+		[]string{labelOperationType},
+	)
+
+	queuedShareDeletions = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      queuedShareDeletionsMetricName,
+			Help:      "Metric to expose the number of share deletions queued or running on a multishare instance.",
+		},
+		[]string{labelInstanceURI},
+	)
+
+	batchedShareDeletions = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: subSystem,
+			Name:      batchedShareDeletionsMetricName,
+			Help:      "Metric to expose count of share deletions completed by the multishare instance delete queues.",
+		},
+		[]string{labelStatusCode},
+	)
+
+	instancePoolInflightOps = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      instancePoolInflightOpsMetricName,
+			Help:      "Metric to expose the number of multishare volume operations of an instance pool being served.",
+		},
+		[]string{labelInstancePoolTag},
+	)
+
+	instancePoolQueuedOps = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      instancePoolQueuedOpsMetricName,
+			Help:      "Metric to expose the number of multishare volume operations of an instance pool waiting in its queue.",
+		},
+		[]string{labelInstancePoolTag},
+	)
+
+	instancePoolRejectedOps = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: subSystem,
+			Name:      instancePoolRejectedOpsMetricName,
+			Help:      "Metric to expose count of multishare volume operations of an instance pool rejected because its queue is full.",
+		},
+		[]string{labelInstancePoolTag},
+	)
+
+	regionalCapacityRejectedOps = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: subSystem,
+			Name:      regionalCapacityRejectedOpsMetricName,
+			Help:      "Metric to expose count of multishare instance creations and expansions rejected because the multishare instances of the region would exceed the regional capacity limit.",
+		},
+		[]string{labelLocation},
+	)
+
+	networkExhaustionErrors = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: subSystem,
+			Name:      networkExhaustionErrorsMetricName,
+			Help:      "Metric to expose count of instance creations failed because the private services access ranges of the VPC network are exhausted or the network reached its peering limits.",
+		},
+		[]string{labelNetwork, labelReason},
+	)
+
+	deleteQueueRetries = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: subSystem,
+			Name:      deleteQueueRetriesMetricName,
+			Help:      "Metric to expose count of volume deletions failed and retried by the controller delete queue.",
+		},
+		[]string{labelStatusCode},
+	)
+
+	nfsBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      nfsBytesMetricName,
+			Help:      "Metric to expose the bytes read or written by the applications through a staged Filestore mount since it was mounted.",
+		},
+		[]string{labelVolumeID, labelDirection},
+	)
+
+	nfsOperations = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      nfsOperationsMetricName,
+			Help:      "Metric to expose the number of NFS requests of an operation completed through a staged Filestore mount since it was mounted.",
+		},
+		[]string{labelVolumeID, labelNFSOperation},
+	)
+
+	nfsRetransmissions = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      nfsRetransmissionsMetricName,
+			Help:      "Metric to expose the number of NFS requests of an operation retransmitted through a staged Filestore mount since it was mounted.",
+		},
+		[]string{labelVolumeID, labelNFSOperation},
+	)
+
+	nfsRTTSeconds = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      nfsRTTMetricName,
+			Help:      "Metric to expose the cumulated round trip time of the NFS requests of an operation through a staged Filestore mount since it was mounted. Divided by the nfs_operations, it is their average latency on the network and the server.",
+		},
+		[]string{labelVolumeID, labelNFSOperation},
+	)
+
+	restoreDurationSeconds = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem: subSystem,
+			Name:      restoreDurationMetricName,
+			Buckets:   restoreMetricBuckets,
+			Help:      "Metric to expose duration of the Filestore operations restoring backups, from their start to their end.",
+		},
+		[]string{labelStatusCode, labelRestoreType},
+	)
+
+	estimatedSpend = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: subSystem,
+			Name:      estimatedSpendMetricName,
+			Help:      "Metric to expose cumulative estimated monthly cost in US dollars of the instances created and of the capacity added to the instances expanded by the driver.",
+		},
+		[]string{labelTier, labelInstanceOperation},
+	)
+
+	usedCapacityPercent = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      usedCapacityPercentMetricName,
+			Help:      "Metric to expose the percentage of the capacity of a staged Filestore volume used, as reported by statfs on the node.",
+		},
+		[]string{labelVolumeID},
+	)
+
+	aboveWatermark = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem: subSystem,
+			Name:      aboveWatermarkMetricName,
+			Help:      "Metric to expose whether the used capacity of a staged Filestore volume is above the capacity watermark of the node driver, 1 if above, 0 otherwise.",
+		},
+		[]string{labelVolumeID},
+	)
+
+	lockContentions = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem: subSystem,
+			Name:      lockContentionsMetricName,
+			Help:      "Metric to expose count of controller operations aborted because another operation on the same volume was in progress.",
+		},
+		[]string{labelVolumeID, labelMethodName},
+	)
+
+	rpcDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem: subSystem,
+			Name:      rpcDurationMetricName,
+			Help:      "Metric to expose the duration of the CSI RPCs handled by the driver, by full method name and status code, the panics of the handlers counted as Internal.",
+			Buckets:   metricBuckets,
+		},
+		[]string{labelStatusCode, labelMethodName},
+	)
 )
 
 type MetricsManager struct {
 	registry metrics.KubeRegistry
+	// profiling serves the pprof endpoints on the metrics listener, see EnableProfiling.
+	profiling bool
 }
 
 func NewMetricsManager() *MetricsManager {
@@ -118,10 +472,48 @@ func (mm *MetricsManager) GetRegistry() metrics.KubeRegistry {
 	return mm.registry
 }
 
+// EnableProfiling registers the Go runtime metrics, e.g. the goroutine count and the heap and
+// GC statistics, and serves the pprof endpoints under /debug/pprof/ on the metrics listener,
+// to diagnose goroutine leaks and memory growth of a running driver. It must be called before
+// InitializeHttpHandler.
+func (mm *MetricsManager) EnableProfiling() {
+	mm.profiling = true
+	mm.registry.RawMustRegister(collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsAll)))
+}
+
 func (mm *MetricsManager) RegisterOperationSecondsMetric() {
 	mm.registry.MustRegister(operationSeconds)
 }
 
+func (mm *MetricsManager) RegisterExcludedInstanceMetric() {
+	mm.registry.MustRegister(excludedInstance)
+}
+
+func (mm *MetricsManager) RegisterRunningOpsMetrics() {
+	mm.registry.MustRegister(runningOps)
+	mm.registry.MustRegister(stuckOps)
+	mm.registry.MustRegister(oldestOpAgeSeconds)
+}
+
+func (mm *MetricsManager) RegisterDeleteBatchingMetrics() {
+	mm.registry.MustRegister(queuedShareDeletions)
+	mm.registry.MustRegister(batchedShareDeletions)
+}
+
+func (mm *MetricsManager) RegisterInstancePoolMetrics() {
+	mm.registry.MustRegister(instancePoolInflightOps)
+	mm.registry.MustRegister(instancePoolQueuedOps)
+	mm.registry.MustRegister(instancePoolRejectedOps)
+}
+
+func (mm *MetricsManager) RegisterRegionalCapacityMetrics() {
+	mm.registry.MustRegister(regionalCapacityRejectedOps)
+}
+
+func (mm *MetricsManager) RegisterNetworkExhaustionMetrics() {
+	mm.registry.MustRegister(networkExhaustionErrors)
+}
+
 func (mm *MetricsManager) RegisterLockReleaseCountnMetric() {
 	mm.registry.MustRegister(lockReleaseCount)
 }
@@ -130,6 +522,51 @@ func (mm *MetricsManager) RegisterKubeAPIDurationMetric() {
 	mm.registry.MustRegister(kubeAPIDurationMilliseconds)
 }
 
+func (mm *MetricsManager) RegisterMountHealthMetrics() {
+	mm.registry.MustRegister(mountHealthReachable)
+	mm.registry.MustRegister(mountHealthLatencySeconds)
+	mm.registry.MustRegister(mountHealthUsedBytes)
+}
+
+func (mm *MetricsManager) RegisterTierRecommendationMetrics() {
+	mm.registry.MustRegister(tierRecommendation)
+	mm.registry.MustRegister(peakThroughputBytes)
+}
+
+func (mm *MetricsManager) RegisterDeleteQueueMetrics() {
+	mm.registry.MustRegister(deleteQueueDepth)
+	mm.registry.MustRegister(deleteQueueStuck)
+	mm.registry.MustRegister(deleteQueueRetries)
+}
+
+func (mm *MetricsManager) RegisterNFSStatsMetrics() {
+	mm.registry.MustRegister(nfsBytes)
+	mm.registry.MustRegister(nfsOperations)
+	mm.registry.MustRegister(nfsRetransmissions)
+	mm.registry.MustRegister(nfsRTTSeconds)
+}
+
+func (mm *MetricsManager) RegisterRestoreMetrics() {
+	mm.registry.MustRegister(restoreDurationSeconds)
+}
+
+func (mm *MetricsManager) RegisterCostEstimationMetrics() {
+	mm.registry.MustRegister(estimatedSpend)
+}
+
+func (mm *MetricsManager) RegisterCapacityWatermarkMetrics() {
+	mm.registry.MustRegister(usedCapacityPercent)
+	mm.registry.MustRegister(aboveWatermark)
+}
+
+func (mm *MetricsManager) RegisterLockContentionMetric() {
+	mm.registry.MustRegister(lockContentions)
+}
+
+func (mm *MetricsManager) RegisterRPCDurationMetric() {
+	mm.registry.MustRegister(rpcDuration)
+}
+
 func (mm *MetricsManager) registerComponentVersionMetric() {
 	mm.registry.MustRegister(gkeComponentVersion)
 }
@@ -170,6 +607,206 @@ func (mm *MetricsManager) RecordLockReleaseMetrics(opErr error) {
 	lockReleaseCount.WithLabelValues(statusCode).Inc()
 }
 
+// RecordMountHealthMetrics records the outcome of a single health probe on a staged mount.
+// The used bytes gauge is left untouched when the mount is unreachable so that the last
+// known usage is still visible while the mount is hung.
+func (mm *MetricsManager) RecordMountHealthMetrics(volumeID string, reachable bool, latency time.Duration, usedBytes int64) {
+	if reachable {
+		mountHealthReachable.WithLabelValues(volumeID).Set(1.0)
+		mountHealthUsedBytes.WithLabelValues(volumeID).Set(float64(usedBytes))
+	} else {
+		mountHealthReachable.WithLabelValues(volumeID).Set(0.0)
+	}
+	mountHealthLatencySeconds.WithLabelValues(volumeID).Set(latency.Seconds())
+}
+
+// DeleteMountHealthMetrics drops all mount health series of the given volume, it is
+// called once the volume is unstaged from the node.
+func (mm *MetricsManager) DeleteMountHealthMetrics(volumeID string) {
+	labels := map[string]string{labelVolumeID: volumeID}
+	mountHealthReachable.Delete(labels)
+	mountHealthLatencySeconds.Delete(labels)
+	mountHealthUsedBytes.Delete(labels)
+}
+
+// RecordTierRecommendationMetrics records the latest recommendation for a volume. The series
+// of the previous recommendation, if different, must be dropped with DeleteTierRecommendationMetrics.
+func (mm *MetricsManager) RecordTierRecommendationMetrics(volumeID, tier, recommendation string, peakThroughput float64) {
+	tierRecommendation.WithLabelValues(volumeID, tier, recommendation).Set(1.0)
+	peakThroughputBytes.WithLabelValues(volumeID).Set(peakThroughput)
+}
+
+// DeleteTierRecommendationMetrics drops the recommendation series of a volume. The peak
+// throughput series is dropped as well if the volume is unstaged from the node.
+func (mm *MetricsManager) DeleteTierRecommendationMetrics(volumeID, tier, recommendation string, unstaged bool) {
+	tierRecommendation.Delete(map[string]string{labelVolumeID: volumeID, labelTier: tier, labelRecommendation: recommendation})
+	if unstaged {
+		peakThroughputBytes.Delete(map[string]string{labelVolumeID: volumeID})
+	}
+}
+
+// RecordDeleteQueueRetry records a failed deletion that is retried by the delete queue.
+func (mm *MetricsManager) RecordDeleteQueueRetry(opErr error) {
+	deleteQueueRetries.WithLabelValues(getErrorCode(opErr)).Inc()
+}
+
+// RecordDeleteQueueDepth records the number of volumes in the delete queue, and how many of
+// them are stuck.
+func (mm *MetricsManager) RecordDeleteQueueDepth(depth, stuck int) {
+	deleteQueueDepth.Set(float64(depth))
+	deleteQueueStuck.Set(float64(stuck))
+}
+
+// NFSMountStats are the NFS client statistics of a mount, cumulated since it was mounted.
+type NFSMountStats struct {
+	ReadBytes  int64
+	WriteBytes int64
+	// Operations maps the NFS operations, e.g. READ, to their statistics.
+	Operations map[string]NFSOperationStats
+}
+
+// NFSOperationStats are the NFS client statistics of the requests of an operation.
+type NFSOperationStats struct {
+	Ops             int64
+	Retransmissions int64
+	RTT             time.Duration
+}
+
+// RecordNFSStatsMetrics records the NFS client statistics of a staged volume.
+func (mm *MetricsManager) RecordNFSStatsMetrics(volumeID string, stats *NFSMountStats) {
+	nfsBytes.WithLabelValues(volumeID, "read").Set(float64(stats.ReadBytes))
+	nfsBytes.WithLabelValues(volumeID, "write").Set(float64(stats.WriteBytes))
+	for op, s := range stats.Operations {
+		nfsOperations.WithLabelValues(volumeID, op).Set(float64(s.Ops))
+		nfsRetransmissions.WithLabelValues(volumeID, op).Set(float64(s.Retransmissions))
+		nfsRTTSeconds.WithLabelValues(volumeID, op).Set(s.RTT.Seconds())
+	}
+}
+
+// DeleteNFSStatsMetrics drops the NFS client statistics series of the given volume and
+// operations, it is called once the volume is unstaged from the node.
+func (mm *MetricsManager) DeleteNFSStatsMetrics(volumeID string, operations []string) {
+	nfsBytes.Delete(map[string]string{labelVolumeID: volumeID, labelDirection: "read"})
+	nfsBytes.Delete(map[string]string{labelVolumeID: volumeID, labelDirection: "write"})
+	for _, op := range operations {
+		labels := map[string]string{labelVolumeID: volumeID, labelNFSOperation: op}
+		nfsOperations.Delete(labels)
+		nfsRetransmissions.Delete(labels)
+		nfsRTTSeconds.Delete(labels)
+	}
+}
+
+// RecordRestoreMetrics records the duration of a finished restore operation of the given type.
+func (mm *MetricsManager) RecordRestoreMetrics(opErr error, restoreType string, duration time.Duration) {
+	restoreDurationSeconds.WithLabelValues(getErrorCode(opErr), restoreType).Observe(duration.Seconds())
+}
+
+// RecordEstimatedSpend adds the estimated monthly cost of an instance created, or of the
+// capacity added to an instance expanded, to the estimated spend of its tier.
+func (mm *MetricsManager) RecordEstimatedSpend(tier, instanceOperation string, monthlyCost float64) {
+	estimatedSpend.WithLabelValues(tier, instanceOperation).Add(monthlyCost)
+}
+
+// RecordCapacityWatermarkMetrics records the used capacity percentage of a staged volume and
+// whether it is above the watermark.
+func (mm *MetricsManager) RecordCapacityWatermarkMetrics(volumeID string, usedPercent float64, above bool) {
+	usedCapacityPercent.WithLabelValues(volumeID).Set(usedPercent)
+	value := 0.0
+	if above {
+		value = 1.0
+	}
+	aboveWatermark.WithLabelValues(volumeID).Set(value)
+}
+
+// DeleteCapacityWatermarkMetrics drops the series of a volume unstaged from the node.
+func (mm *MetricsManager) DeleteCapacityWatermarkMetrics(volumeID string) {
+	labels := map[string]string{labelVolumeID: volumeID}
+	usedCapacityPercent.Delete(labels)
+	aboveWatermark.Delete(labels)
+}
+
+// RecordRPCMetrics records the duration and status code of a CSI RPC of the method.
+func (mm *MetricsManager) RecordRPCMetrics(rpcErr error, fullMethod string, duration time.Duration) {
+	rpcDuration.WithLabelValues(getErrorCode(rpcErr), fullMethod).Observe(duration.Seconds())
+}
+
+// RecordLockContention records an operation of the method aborted because another operation
+// on the volume was in progress.
+func (mm *MetricsManager) RecordLockContention(volumeID, methodName string) {
+	lockContentions.WithLabelValues(volumeID, methodName).Inc()
+}
+
+// RecordExcludedInstanceMetric records a multishare instance excluded from packing in the given state.
+func (mm *MetricsManager) RecordExcludedInstanceMetric(instanceURI, state string) {
+	excludedInstance.WithLabelValues(instanceURI, state).Set(1.0)
+}
+
+// DeleteExcludedInstanceMetric drops the series of an instance which left the given state.
+func (mm *MetricsManager) DeleteExcludedInstanceMetric(instanceURI, state string) {
+	excludedInstance.Delete(map[string]string{labelInstanceURI: instanceURI, labelState: state})
+}
+
+// RecordQueuedShareDeletions records the number of share deletions queued or running on a
+// multishare instance.
+func (mm *MetricsManager) RecordQueuedShareDeletions(instanceURI string, queued int) {
+	queuedShareDeletions.WithLabelValues(instanceURI).Set(float64(queued))
+}
+
+// DeleteQueuedShareDeletions drops the series of an instance whose delete queue is drained.
+func (mm *MetricsManager) DeleteQueuedShareDeletions(instanceURI string) {
+	queuedShareDeletions.Delete(map[string]string{labelInstanceURI: instanceURI})
+}
+
+// RecordBatchedShareDeletion records a share deletion completed by a delete queue.
+func (mm *MetricsManager) RecordBatchedShareDeletion(opErr error) {
+	batchedShareDeletions.WithLabelValues(getErrorCode(opErr)).Inc()
+}
+
+// RecordInstancePoolOperations records the number of multishare volume operations of an
+// instance pool being served and waiting in its queue.
+func (mm *MetricsManager) RecordInstancePoolOperations(instancePoolTag string, inflight, queued int) {
+	instancePoolInflightOps.WithLabelValues(instancePoolTag).Set(float64(inflight))
+	instancePoolQueuedOps.WithLabelValues(instancePoolTag).Set(float64(queued))
+}
+
+// RecordInstancePoolRejectedOperation records a multishare volume operation of an instance
+// pool rejected because its queue is full.
+func (mm *MetricsManager) RecordInstancePoolRejectedOperation(instancePoolTag string) {
+	instancePoolRejectedOps.WithLabelValues(instancePoolTag).Inc()
+}
+
+// RecordRegionalCapacityRejectedOperation records a multishare instance creation or expansion
+// rejected because the multishare instances of the region would exceed the regional capacity limit.
+func (mm *MetricsManager) RecordRegionalCapacityRejectedOperation(location string) {
+	regionalCapacityRejectedOps.WithLabelValues(location).Inc()
+}
+
+// RecordNetworkExhaustionError records an instance creation failed because its VPC network
+// ran out of private services access ranges or reached its peering limits.
+func (mm *MetricsManager) RecordNetworkExhaustionError(network, reason string) {
+	networkExhaustionErrors.WithLabelValues(network, reason).Inc()
+}
+
+// RunningOpsStats are the running multishare operations of a type, observed on an op listing.
+type RunningOpsStats struct {
+	Running   int
+	Stuck     int
+	OldestAge time.Duration
+}
+
+// RecordRunningOpsMetrics records the running multishare operations keyed by operation type.
+// The series of the types with no running operation are dropped.
+func (mm *MetricsManager) RecordRunningOpsMetrics(stats map[string]RunningOpsStats) {
+	runningOps.Reset()
+	stuckOps.Reset()
+	oldestOpAgeSeconds.Reset()
+	for opType, s := range stats {
+		runningOps.WithLabelValues(opType).Set(float64(s.Running))
+		stuckOps.WithLabelValues(opType).Set(float64(s.Stuck))
+		oldestOpAgeSeconds.WithLabelValues(opType).Set(s.OldestAge.Seconds())
+	}
+}
+
 func getErrorCode(err error) string {
 	if err == nil {
 		return codes.OK.String()
@@ -209,10 +846,26 @@ func (mm *MetricsManager) registerToServer(s Server, metricsPath string) {
 			ErrorHandling: metrics.ContinueOnError}))
 }
 
-// InitializeHttpHandler sets up a server and creates a handler for metrics.
-func (mm *MetricsManager) InitializeHttpHandler(address, path string) {
+// newServeMux returns the mux of the metrics listener.
+func (mm *MetricsManager) newServeMux(path string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mm.registerToServer(mux, path)
+	if mm.profiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// InitializeHttpHandler sets up a server and creates a handler for metrics.
+func (mm *MetricsManager) InitializeHttpHandler(address, path string) {
+	mux := mm.newServeMux(path)
+	if mm.profiling {
+		klog.Warningf("Serving the pprof endpoints at %q/debug/pprof/", address)
+	}
 	go func() {
 		klog.Infof("Metric server listening at %q", address)
 		if err := http.ListenAndServe(address, mux); err != nil {
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -24,3 +26,36 @@ func TestProcessStartTimeMetricExist(t *testing.T) {
 
 	t.Fatalf("Metrics does not contain %v. Scraped content: %v", ProcessStartTimeMetric, metricsFamilies)
 }
+
+func TestEnableProfiling(t *testing.T) {
+	cases := []struct {
+		profiling      bool
+		expectedStatus int
+	}{
+		{profiling: false, expectedStatus: http.StatusNotFound},
+		{profiling: true, expectedStatus: http.StatusOK},
+	}
+	for _, tc := range cases {
+		mm := NewMetricsManager()
+		if tc.profiling {
+			mm.EnableProfiling()
+		}
+		metricsFamilies, err := mm.GetRegistry().Gather()
+		if err != nil {
+			t.Fatalf("Error fetching metrics: %v", err)
+		}
+		goroutines := false
+		for _, metricsFamily := range metricsFamilies {
+			goroutines = goroutines || metricsFamily.GetName() == "go_goroutines"
+		}
+		if goroutines != tc.profiling {
+			t.Errorf("profiling %t: got go_goroutines metric %t", tc.profiling, goroutines)
+		}
+
+		rec := httptest.NewRecorder()
+		mm.newServeMux("/metrics").ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil))
+		if rec.Code != tc.expectedStatus {
+			t.Errorf("profiling %t: got status %d of the goroutine profile, expected %d", tc.profiling, rec.Code, tc.expectedStatus)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package populator implements a volume populator seeding the Filestore volumes of the
+// PVCs whose spec.dataSourceRef is a GcsDataSource with the objects of a Cloud Storage
+// bucket, before the PVCs are bound.
+package populator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/populator"
+	populatorv1alpha1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/populator/v1alpha1"
+	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+)
+
+const (
+	// GcsDataSourceKind is the kind of the PVC data sources handled by the populator.
+	GcsDataSourceKind = "GcsDataSource"
+
+	// annotationSelectedNode is set by the scheduler on the PVCs of a WaitForFirstConsumer
+	// storage class once their first consumer is scheduled.
+	annotationSelectedNode = "volume.kubernetes.io/selected-node"
+	// annotationPopulatedFrom is set on the populated PVs, to the namespace and name of their
+	// GcsDataSource.
+	annotationPopulatedFrom = "populator.filestore.csi.storage.gke.io/populated-from"
+
+	// primePrefix is the prefix of the names of the prime PVC provisioned for a PVC and of
+	// its transfer job, in the namespace of the PVC, followed by the UID of the PVC.
+	primePrefix = "populate-"
+	// volumeMountPath is where the prime PVC is mounted in the transfer pod.
+	volumeMountPath = "/mnt/volume"
+	// transferBackoffLimit is the number of retries of a transfer pod before its job fails.
+	transferBackoffLimit = 3
+
+	// Reasons of the events published on the PVCs being populated.
+	eventReasonPopulateStarted   = "FilestorePopulateStarted"
+	eventReasonPopulateFailed    = "FilestorePopulateFailed"
+	eventReasonPopulateCompleted = "FilestorePopulateCompleted"
+)
+
+// GcsPopulatorConfig holds the options of the populator.
+type GcsPopulatorConfig struct {
+	// DriverName is the name of the Filestore CSI driver, the provisioner of the storage
+	// classes of the populated PVCs.
+	DriverName string
+	// TransferImage is the image of the transfer pods, which must provide gsutil.
+	TransferImage string
+	// SyncPeriod is the duration between two consecutive checks of the PVCs to populate.
+	SyncPeriod time.Duration
+}
+
+// GcsPopulator seeds the volumes of the PVCs referencing a GcsDataSource, following the
+// volume populator flow: for each pending PVC, it provisions a prime PVC of the same storage
+// class in the namespace of the PVC, runs a job copying the objects of the bucket to the prime
+// PVC with gsutil, and once the job succeeds, binds the PV of the prime PVC to the original PVC.
+// The job runs in the namespace of the PVC, so that it can only use the service accounts of
+// the namespace of the GcsDataSource.
+// The external provisioner ignores the PVCs whose data source is not a volume or a snapshot,
+// so the PVCs are only provisioned through their prime PVC.
+type GcsPopulator struct {
+	config       *GcsPopulatorConfig
+	kubeClient   kubernetes.Interface
+	driverClient clientset.Interface
+	recorder     record.EventRecorder
+}
+
+func NewGcsPopulator(kubeClient kubernetes.Interface, driverClient clientset.Interface, recorder record.EventRecorder, config *GcsPopulatorConfig) *GcsPopulator {
+	return &GcsPopulator{
+		config:       config,
+		kubeClient:   kubeClient,
+		driverClient: driverClient,
+		recorder:     recorder,
+	}
+}
+
+// Run populates the pending PVCs every sync period until the context is done.
+func (p *GcsPopulator) Run(ctx context.Context) {
+	klog.Infof("Starting GcsDataSource volume populator with sync period %v", p.config.SyncPeriod)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := p.populateAll(ctx); err != nil {
+			klog.Errorf("Failed to populate the PVCs: %v", err)
+		}
+	}, p.config.SyncPeriod)
+}
+
+func (p *GcsPopulator) populateAll(ctx context.Context) error {
+	pvcs, err := p.kubeClient.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if !isGcsDataSourceRef(pvc.Spec.DataSourceRef) || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		if err := p.populate(ctx, pvc); err != nil {
+			klog.Errorf("Failed to populate PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+			p.recorder.Event(pvc, v1.EventTypeWarning, eventReasonPopulateFailed, err.Error())
+		}
+	}
+	return nil
+}
+
+func isGcsDataSourceRef(ref *v1.TypedObjectReference) bool {
+	return ref != nil && ref.APIGroup != nil && *ref.APIGroup == populator.GroupName && ref.Kind == GcsDataSourceKind
+}
+
+// populate moves the population of a PVC one step forward. Each step is idempotent, so that
+// a population interrupted e.g. by a restart of the populator is resumed.
+func (p *GcsPopulator) populate(ctx context.Context, pvc *v1.PersistentVolumeClaim) error {
+	primeName := primePrefix + string(pvc.UID)
+	if pvc.Spec.VolumeName != "" {
+		// The PV is bound to the PVC, the prime PVC and the job are no longer needed.
+		return p.cleanup(ctx, pvc.Namespace, primeName)
+	}
+
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return fmt.Errorf("PVC %s/%s has no storage class", pvc.Namespace, pvc.Name)
+	}
+	sc, err := p.kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if sc.Provisioner != p.config.DriverName {
+		return fmt.Errorf("storage class %s of PVC %s/%s is not provisioned by driver %s", sc.Name, pvc.Namespace, pvc.Name, p.config.DriverName)
+	}
+	// Like the provisioner, wait for the first consumer of the PVC to be scheduled.
+	if sc.VolumeBindingMode != nil && *sc.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer && pvc.Annotations[annotationSelectedNode] == "" {
+		return nil
+	}
+
+	if ns := pvc.Spec.DataSourceRef.Namespace; ns != nil && *ns != "" && *ns != pvc.Namespace {
+		return fmt.Errorf("GcsDataSource %s/%s is not in the namespace of PVC %s/%s", *ns, pvc.Spec.DataSourceRef.Name, pvc.Namespace, pvc.Name)
+	}
+	source, err := p.driverClient.PopulatorV1alpha1().GcsDataSources(pvc.Namespace).Get(ctx, pvc.Spec.DataSourceRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if source.Spec.Bucket == "" {
+		return fmt.Errorf("GcsDataSource %s/%s has no bucket", source.Namespace, source.Name)
+	}
+
+	prime, err := p.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, primeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.Infof("Populating PVC %s/%s from %s", pvc.Namespace, pvc.Name, gcsURL(source))
+		prime, err = p.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(ctx, p.primeClaim(pvc, primeName), metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		p.recorder.Eventf(pvc, v1.EventTypeNormal, eventReasonPopulateStarted, "Populating the volume from %s", gcsURL(source))
+	}
+	if err != nil {
+		return err
+	}
+
+	job, err := p.kubeClient.BatchV1().Jobs(pvc.Namespace).Get(ctx, primeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = p.kubeClient.BatchV1().Jobs(pvc.Namespace).Create(ctx, p.transferJob(source, primeName), metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if jobFailed(job) {
+		// Deleted so that the transfer is retried on the next sync, after the user fixed e.g.
+		// the permissions of the service account on the bucket.
+		klog.Warningf("Transfer job %s/%s of PVC %s/%s failed, deleting it to retry", job.Namespace, job.Name, pvc.Namespace, pvc.Name)
+		if err := p.deleteJob(ctx, job.Namespace, job.Name); err != nil {
+			return err
+		}
+		return fmt.Errorf("transfer job %s/%s copying %s failed, see the logs of its pods, retrying", job.Namespace, job.Name, gcsURL(source))
+	}
+	if job.Status.Succeeded == 0 {
+		return nil
+	}
+
+	if prime.Spec.VolumeName == "" {
+		return fmt.Errorf("prime PVC %s/%s of a succeeded transfer job is not bound", prime.Namespace, prime.Name)
+	}
+	return p.rebind(ctx, pvc, prime, source)
+}
+
+// rebind binds the PV of the prime PVC to the original PVC. The PV controller then binds the
+// PVC to the PV, and the prime PVC is left lost until it is deleted by the cleanup.
+func (p *GcsPopulator) rebind(ctx context.Context, pvc, prime *v1.PersistentVolumeClaim, source *populatorv1alpha1.GcsDataSource) error {
+	pv, err := p.kubeClient.CoreV1().PersistentVolumes().Get(ctx, prime.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if ref := pv.Spec.ClaimRef; ref != nil && ref.UID == pvc.UID {
+		return nil
+	}
+	pv = pv.DeepCopy()
+	pv.Spec.ClaimRef = &v1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  pvc.Namespace,
+		Name:       pvc.Name,
+		UID:        pvc.UID,
+	}
+	if pv.Annotations == nil {
+		pv.Annotations = make(map[string]string)
+	}
+	pv.Annotations[annotationPopulatedFrom] = source.Namespace + "/" + source.Name
+	if _, err := p.kubeClient.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("Populated PV %s bound to PVC %s/%s", pv.Name, pvc.Namespace, pvc.Name)
+	p.recorder.Eventf(pvc, v1.EventTypeNormal, eventReasonPopulateCompleted, "Volume populated from %s", gcsURL(source))
+	return nil
+}
+
+// cleanup deletes the job and the prime PVC of a populated PVC of the namespace, if any.
+func (p *GcsPopulator) cleanup(ctx context.Context, namespace, primeName string) error {
+	if err := p.deleteJob(ctx, namespace, primeName); err != nil {
+		return err
+	}
+	err := p.kubeClient.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, primeName, metav1.DeleteOptions{})
+	if err == nil {
+		klog.Infof("Deleted prime PVC %s/%s", namespace, primeName)
+	}
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (p *GcsPopulator) deleteJob(ctx context.Context, namespace, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := p.kubeClient.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// primeClaim returns the prime PVC provisioning the volume of a PVC, in its namespace.
+func (p *GcsPopulator) primeClaim(pvc *v1.PersistentVolumeClaim, name string) *v1.PersistentVolumeClaim {
+	prime := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pvc.Namespace,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      pvc.Spec.AccessModes,
+			Resources:        pvc.Spec.Resources,
+			StorageClassName: pvc.Spec.StorageClassName,
+			VolumeMode:       pvc.Spec.VolumeMode,
+		},
+	}
+	if node := pvc.Annotations[annotationSelectedNode]; node != "" {
+		prime.Annotations = map[string]string{annotationSelectedNode: node}
+	}
+	return prime
+}
+
+// transferJob returns the job copying the objects of the data source to the prime PVC, in
+// the namespace of the data source and with one of its service accounts.
+func (p *GcsPopulator) transferJob(source *populatorv1alpha1.GcsDataSource, name string) *batchv1.Job {
+	backoffLimit := int32(transferBackoffLimit)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: source.Namespace,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy:      v1.RestartPolicyNever,
+					ServiceAccountName: source.Spec.ServiceAccountName,
+					Containers: []v1.Container{{
+						Name:    "transfer",
+						Image:   p.config.TransferImage,
+						Command: []string{"gsutil", "-m", "rsync", "-r", gcsURL(source), volumeMountPath},
+						VolumeMounts: []v1.VolumeMount{{
+							Name:      "volume",
+							MountPath: volumeMountPath,
+						}},
+					}},
+					Volumes: []v1.Volume{{
+						Name: "volume",
+						VolumeSource: v1.VolumeSource{
+							PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func jobFailed(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// gcsURL returns the gs:// URL of the objects of a data source.
+func gcsURL(source *populatorv1alpha1.GcsDataSource) string {
+	url := "gs://" + source.Spec.Bucket
+	if source.Spec.Prefix != "" {
+		url += "/" + source.Spec.Prefix
+	}
+	return url
+}
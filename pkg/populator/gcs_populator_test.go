@@ -0,0 +1,283 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package populator
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/populator"
+	populatorv1alpha1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/populator/v1alpha1"
+	driverfake "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+)
+
+const (
+	testDriverName = "filestore.csi.storage.gke.io"
+	testNamespace  = "default"
+	testImage      = "gsutil-image"
+)
+
+func testClaim(storageClass, source string) *v1.PersistentVolumeClaim {
+	group := populator.GroupName
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: testNamespace, UID: "uid"},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			StorageClassName: &storageClass,
+			DataSourceRef:    &v1.TypedObjectReference{APIGroup: &group, Kind: GcsDataSourceKind, Name: source},
+		},
+		Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending},
+	}
+}
+
+func testStorageClass(name, provisioner string, mode storagev1.VolumeBindingMode) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: name},
+		Provisioner:       provisioner,
+		VolumeBindingMode: &mode,
+	}
+}
+
+func testDataSource(bucket string) *populatorv1alpha1.GcsDataSource {
+	return &populatorv1alpha1.GcsDataSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "training-data", Namespace: testNamespace},
+		Spec:       populatorv1alpha1.GcsDataSourceSpec{Bucket: bucket, Prefix: "dataset/v1", ServiceAccountName: "reader"},
+	}
+}
+
+func testPopulator(kubeClient *fake.Clientset, driverClient *driverfake.Clientset, recorder record.EventRecorder) *GcsPopulator {
+	return NewGcsPopulator(kubeClient, driverClient, recorder, &GcsPopulatorConfig{
+		DriverName:    testDriverName,
+		TransferImage: testImage,
+		SyncPeriod:    time.Minute,
+	})
+}
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			sort.Strings(events)
+			return events
+		}
+	}
+}
+
+func TestGcsPopulator(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset(testClaim("filestore", "training-data"), testStorageClass("filestore", testDriverName, storagev1.VolumeBindingImmediate))
+	driverClient := driverfake.NewSimpleClientset(testDataSource("bucket"))
+	recorder := record.NewFakeRecorder(10)
+	p := testPopulator(kubeClient, driverClient, recorder)
+	primeName := primePrefix + "uid"
+
+	// The prime PVC and the transfer job are created in the namespace of the PVC.
+	for i := 0; i < 2; i++ {
+		if err := p.populateAll(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	prime, err := kubeClient.CoreV1().PersistentVolumeClaims(testNamespace).Get(ctx, primeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get prime PVC: %v", err)
+	}
+	if *prime.Spec.StorageClassName != "filestore" || prime.Spec.DataSourceRef != nil {
+		t.Errorf("got prime PVC spec %+v, expected storage class filestore and no data source", prime.Spec)
+	}
+	job, err := kubeClient.BatchV1().Jobs(testNamespace).Get(ctx, primeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get transfer job: %v", err)
+	}
+	pod := job.Spec.Template.Spec
+	expectedCommand := []string{"gsutil", "-m", "rsync", "-r", "gs://bucket/dataset/v1", volumeMountPath}
+	if !reflect.DeepEqual(pod.Containers[0].Command, expectedCommand) || pod.Containers[0].Image != testImage || pod.ServiceAccountName != "reader" {
+		t.Errorf("got transfer pod %+v, expected command %v with image %s and service account reader", pod, expectedCommand, testImage)
+	}
+	if claim := pod.Volumes[0].PersistentVolumeClaim; claim == nil || claim.ClaimName != primeName {
+		t.Errorf("got transfer pod volumes %+v, expected prime PVC %s", pod.Volumes, primeName)
+	}
+	expectedEvents := []string{"Normal FilestorePopulateStarted Populating the volume from gs://bucket/dataset/v1"}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+
+	// Once the job succeeds, the PV of the prime PVC is bound to the PVC.
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+		Spec:       v1.PersistentVolumeSpec{ClaimRef: &v1.ObjectReference{Namespace: testNamespace, Name: primeName, UID: "prime-uid"}},
+	}
+	if _, err := kubeClient.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create PV: %v", err)
+	}
+	prime.Spec.VolumeName = "pv"
+	if _, err := kubeClient.CoreV1().PersistentVolumeClaims(testNamespace).Update(ctx, prime, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update prime PVC: %v", err)
+	}
+	job.Status.Succeeded = 1
+	if _, err := kubeClient.BatchV1().Jobs(testNamespace).UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update job: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.populateAll(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	pv, err = kubeClient.CoreV1().PersistentVolumes().Get(ctx, "pv", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PV: %v", err)
+	}
+	expectedRef := &v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: "default", Name: "claim", UID: "uid"}
+	if !reflect.DeepEqual(pv.Spec.ClaimRef, expectedRef) {
+		t.Errorf("got PV claim ref %+v, expected %+v", pv.Spec.ClaimRef, expectedRef)
+	}
+	if got := pv.Annotations[annotationPopulatedFrom]; got != "default/training-data" {
+		t.Errorf("got PV populated from %q, expected %q", got, "default/training-data")
+	}
+	expectedEvents = []string{"Normal FilestorePopulateCompleted Volume populated from gs://bucket/dataset/v1"}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+
+	// Once the PVC is bound, the prime PVC and the job are deleted.
+	claim, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(ctx, "claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get PVC: %v", err)
+	}
+	claim.Spec.VolumeName = "pv"
+	if _, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Update(ctx, claim, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update PVC: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.populateAll(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := kubeClient.CoreV1().PersistentVolumeClaims(testNamespace).Get(ctx, primeName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("got prime PVC error %v, expected not found", err)
+	}
+	if _, err := kubeClient.BatchV1().Jobs(testNamespace).Get(ctx, primeName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("got job error %v, expected not found", err)
+	}
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Errorf("got events %v, expected none", events)
+	}
+}
+
+func TestGcsPopulatorTransferFailed(t *testing.T) {
+	ctx := context.Background()
+	primeName := primePrefix + "uid"
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: primeName, Namespace: testNamespace},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: v1.ConditionTrue},
+		}},
+	}
+	prime := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: primeName, Namespace: testNamespace}}
+	kubeClient := fake.NewSimpleClientset(testClaim("filestore", "training-data"), testStorageClass("filestore", testDriverName, storagev1.VolumeBindingImmediate), prime, job)
+	recorder := record.NewFakeRecorder(10)
+	p := testPopulator(kubeClient, driverfake.NewSimpleClientset(testDataSource("bucket")), recorder)
+
+	// The failed job is deleted, and created again on the next sync.
+	if err := p.populateAll(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := kubeClient.BatchV1().Jobs(testNamespace).Get(ctx, primeName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("got job error %v, expected not found", err)
+	}
+	expectedEvents := []string{"Warning FilestorePopulateFailed transfer job default/populate-uid copying gs://bucket/dataset/v1 failed, see the logs of its pods, retrying"}
+	if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("got events %v, expected %v", events, expectedEvents)
+	}
+	if err := p.populateAll(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := kubeClient.BatchV1().Jobs(testNamespace).Get(ctx, primeName, metav1.GetOptions{}); err != nil {
+		t.Errorf("failed to get the recreated job: %v", err)
+	}
+}
+
+func TestGcsPopulatorSkipped(t *testing.T) {
+	tests := []struct {
+		name          string
+		claim         *v1.PersistentVolumeClaim
+		storageClass  *storagev1.StorageClass
+		source        *populatorv1alpha1.GcsDataSource
+		expectedEvent string
+	}{
+		{
+			name:         "waiting for first consumer",
+			claim:        testClaim("filestore", "training-data"),
+			storageClass: testStorageClass("filestore", testDriverName, storagev1.VolumeBindingWaitForFirstConsumer),
+			source:       testDataSource("bucket"),
+		},
+		{
+			name:          "storage class of another driver",
+			claim:         testClaim("pd", "training-data"),
+			storageClass:  testStorageClass("pd", "pd.csi.storage.gke.io", storagev1.VolumeBindingImmediate),
+			source:        testDataSource("bucket"),
+			expectedEvent: "Warning FilestorePopulateFailed storage class pd of PVC default/claim is not provisioned by driver " + testDriverName,
+		},
+		{
+			name:          "missing data source",
+			claim:         testClaim("filestore", "other-data"),
+			storageClass:  testStorageClass("filestore", testDriverName, storagev1.VolumeBindingImmediate),
+			source:        testDataSource("bucket"),
+			expectedEvent: `Warning FilestorePopulateFailed gcsdatasources.populator.filestore.csi.storage.gke.io "other-data" not found`,
+		},
+		{
+			name:          "data source without bucket",
+			claim:         testClaim("filestore", "training-data"),
+			storageClass:  testStorageClass("filestore", testDriverName, storagev1.VolumeBindingImmediate),
+			source:        testDataSource(""),
+			expectedEvent: "Warning FilestorePopulateFailed GcsDataSource default/training-data has no bucket",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			kubeClient := fake.NewSimpleClientset(tc.claim, tc.storageClass)
+			recorder := record.NewFakeRecorder(10)
+			p := testPopulator(kubeClient, driverfake.NewSimpleClientset(tc.source), recorder)
+			if err := p.populateAll(ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := kubeClient.CoreV1().PersistentVolumeClaims(testNamespace).Get(ctx, primePrefix+"uid", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("got prime PVC error %v, expected not found", err)
+			}
+			var expectedEvents []string
+			if tc.expectedEvent != "" {
+				expectedEvents = []string{tc.expectedEvent}
+			}
+			if events := drainEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+				t.Errorf("got events %v, expected %v", events, expectedEvents)
+			}
+		})
+	}
+}
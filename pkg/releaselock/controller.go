@@ -59,6 +59,9 @@ type LockReleaseControllerConfig struct {
 	SyncPeriod time.Duration
 	// HTTP endpoint and path to emit NFS lock release metrics.
 	MetricEndpoint, MetricPath string
+	// MetricsManager, if set, is an already serving metrics manager shared with other
+	// node driver components. MetricEndpoint and MetricPath are ignored in that case.
+	MetricsManager *metrics.MetricsManager
 }
 
 func NewLockReleaseController(client kubernetes.Interface, config *LockReleaseControllerConfig) (*LockReleaseController, error) {
@@ -83,7 +86,11 @@ func NewLockReleaseController(client kubernetes.Interface, config *LockReleaseCo
 		config:   config,
 	}
 
-	if config.MetricEndpoint != "" {
+	if config.MetricsManager != nil {
+		config.MetricsManager.RegisterKubeAPIDurationMetric()
+		config.MetricsManager.RegisterLockReleaseCountnMetric()
+		lc.metricsManager = config.MetricsManager
+	} else if config.MetricEndpoint != "" {
 		mm := metrics.NewMetricsManager()
 		mm.InitializeHttpHandler(config.MetricEndpoint, config.MetricPath)
 		mm.RegisterKubeAPIDurationMetric()
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supportbundle
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+// LogRing keeps the last lines logged by the driver.
+type LogRing struct {
+	mux   sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+// NewLogRing returns a ring of maxLines lines.
+func NewLogRing(maxLines int) *LogRing {
+	return &LogRing{lines: make([]string, maxLines)}
+}
+
+// Add records a line, dropping the oldest line if the ring is full.
+func (r *LogRing) Add(line string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.lines[r.next] = strings.TrimSuffix(line, "\n")
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Lines returns the recorded lines, oldest first.
+func (r *LogRing) Lines() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	return append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+// CaptureKlog returns a ring of the last maxLines lines logged with klog, which are still
+// written to stderr, or nil if maxLines is not positive. The log file flags of klog are
+// ignored once the logs are captured.
+func CaptureKlog(maxLines int) *LogRing {
+	if maxLines <= 0 {
+		return nil
+	}
+	ring := NewLogRing(maxLines)
+	klog.SetLogger(logr.New(&ringSink{ring: ring, out: os.Stderr, mux: &sync.Mutex{}}))
+	return ring
+}
+
+// ringSink writes the lines formatted by klog to out and to its ring. The lines of the
+// structured logging calls, which klog doesn't format, get their key/value pairs appended.
+type ringSink struct {
+	ring *LogRing
+	out  io.Writer
+	// mux serializes the writes to out of the sink and of its derived sinks.
+	mux *sync.Mutex
+	kv  []interface{}
+}
+
+var _ logr.LogSink = &ringSink{}
+
+func (s *ringSink) Init(info logr.RuntimeInfo) {}
+
+func (s *ringSink) Enabled(level int) bool {
+	return true
+}
+
+func (s *ringSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.write(msg, keysAndValues)
+}
+
+func (s *ringSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	if err != nil {
+		keysAndValues = append([]interface{}{"err", err}, keysAndValues...)
+	}
+	s.write(msg, keysAndValues)
+}
+
+func (s *ringSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &ringSink{ring: s.ring, out: s.out, mux: s.mux, kv: append(append([]interface{}(nil), s.kv...), keysAndValues...)}
+}
+
+func (s *ringSink) WithName(name string) logr.LogSink {
+	return s.WithValues("logger", name)
+}
+
+func (s *ringSink) write(msg string, keysAndValues []interface{}) {
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(msg, "\n"))
+	keysAndValues = append(append([]interface{}(nil), s.kv...), keysAndValues...)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&b, " %v=%q", keysAndValues[i], fmt.Sprint(keysAndValues[i+1]))
+	}
+	line := b.String()
+	s.ring.Add(line)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	io.WriteString(s.out, line+"\n")
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package supportbundle serves the support bundles of the controller, tarballs of its recent
+// logs, configuration and state attached to the bug reports of provisioning failures.
+package supportbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Path is the path the support bundles are served on, on the debug endpoint.
+const Path = "/debug/supportbundle"
+
+// collectTimeout bounds the collection of a bundle, whose state files call the Filestore API.
+const collectTimeout = time.Minute
+
+// Collector returns the content of a file of the bundle.
+type Collector func(ctx context.Context) ([]byte, error)
+
+// JSON returns a collector of the indented JSON encoding of the value returned by f.
+func JSON(f func(ctx context.Context) (interface{}, error)) Collector {
+	return func(ctx context.Context) ([]byte, error) {
+		v, err := f(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(v, "", "  ")
+	}
+}
+
+// Lines returns a collector of the lines returned by f.
+func Lines(f func() []string) Collector {
+	return func(ctx context.Context) ([]byte, error) {
+		lines := f()
+		if len(lines) == 0 {
+			return nil, nil
+		}
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	}
+}
+
+// sensitiveFlagPattern matches the names of the flags whose values are redacted from the
+// bundles, e.g. the endpoints embedding tokens or the paths of credentials.
+var sensitiveFlagPattern = regexp.MustCompile(`(?i)token|secret|password|credential|key`)
+
+// Flags returns a collector of the values of the flags of fs, one "--<name>=<value>" line per
+// flag, sorted by name, the values of the sensitive flags redacted.
+func Flags(fs *flag.FlagSet) Collector {
+	return Lines(func() []string {
+		var lines []string
+		fs.VisitAll(func(f *flag.Flag) {
+			value := f.Value.String()
+			if value != "" && sensitiveFlagPattern.MatchString(f.Name) {
+				value = "<redacted>"
+			}
+			lines = append(lines, fmt.Sprintf("--%s=%s", f.Name, value))
+		})
+		sort.Strings(lines)
+		return lines
+	})
+}
+
+// Handler serves a gzipped tarball of the files returned by the collectors, by file name. The
+// error of a failed collector is written to the <file name>.error file of the bundle, so that
+// the bundle holds the other files.
+func Handler(collectors map[string]Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), collectTimeout)
+		defer cancel()
+		now := time.Now().UTC()
+		dir := "supportbundle-" + now.Format("20060102-150405")
+		klog.Infof("Collecting support bundle %s", dir)
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", dir+".tar.gz"))
+		if err := write(ctx, w, dir, now, collectors); err != nil {
+			// The headers are sent, the truncated tarball fails to extract.
+			klog.Errorf("Failed to write support bundle %s: %v", dir, err)
+		}
+	})
+}
+
+func write(ctx context.Context, w http.ResponseWriter, dir string, now time.Time, collectors map[string]Collector) error {
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		content, err := collectors[name](ctx)
+		if err != nil {
+			name, content = name+".error", []byte(err.Error()+"\n")
+		}
+		header := &tar.Header{Name: dir + "/" + name, Mode: 0644, Size: int64(len(content)), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supportbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("project", "test-project", "")
+	fs.String("token-body", "secret", "")
+	fs.String("resource-tags", "", "")
+	logs := NewLogRing(2)
+	for _, line := range []string{"I1 first\n", "I2 second\n", "E3 third\n"} {
+		logs.Add(line)
+	}
+	handler := Handler(map[string]Collector{
+		"flags.txt": Flags(fs),
+		"logs.txt":  Lines(logs.Lines),
+		"state/instances.json": JSON(func(ctx context.Context) (interface{}, error) {
+			return []string{"fs-a"}, nil
+		}),
+		"state/shares.json": JSON(func(ctx context.Context) (interface{}, error) {
+			return nil, errors.New("permission denied")
+		}),
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("got content type %q, expected application/gzip", ct)
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip stream: %v", err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid tarball: %v", err)
+		}
+		if !strings.HasPrefix(header.Name, "supportbundle-") {
+			t.Errorf("got file %s outside of the bundle directory", header.Name)
+		}
+		var content bytes.Buffer
+		if _, err := io.Copy(&content, tr); err != nil {
+			t.Fatalf("failed to read %s: %v", header.Name, err)
+		}
+		files[strings.SplitN(header.Name, "/", 2)[1]] = content.String()
+	}
+
+	expected := map[string]string{
+		"flags.txt":               "--project=test-project\n--resource-tags=\n--token-body=<redacted>\n",
+		"logs.txt":                "I2 second\nE3 third\n",
+		"state/instances.json":    "[\n  \"fs-a\"\n]",
+		"state/shares.json.error": "permission denied\n",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("got files %v, expected %v", files, expected)
+	}
+}
+
+func TestLogRing(t *testing.T) {
+	ring := NewLogRing(3)
+	if lines := ring.Lines(); len(lines) != 0 {
+		t.Errorf("got lines %v of an empty ring", lines)
+	}
+	for _, line := range []string{"a", "b", "c", "d"} {
+		ring.Add(line)
+	}
+	if lines, expected := ring.Lines(), []string{"b", "c", "d"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got lines %v, expected %v", lines, expected)
+	}
+}
+
+func TestRingSink(t *testing.T) {
+	ring := NewLogRing(10)
+	out := &bytes.Buffer{}
+	sink := &ringSink{ring: ring, out: out, mux: &sync.Mutex{}}
+	sink.Info(0, "I1017 formatted by klog\n")
+	sink.WithValues("volume", "pvc-a").Error(errors.New("not found"), "Structured", "op", "delete")
+
+	expected := []string{`I1017 formatted by klog`, `Structured volume="pvc-a" err="not found" op="delete"`}
+	if lines := ring.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got lines %q, expected %q", lines, expected)
+	}
+	if out.String() != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("got output %q", out.String())
+	}
+}
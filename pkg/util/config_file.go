@@ -0,0 +1,124 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ApplyConfigFile sets the flags of fs from the YAML config file at path, whose keys are the
+// flag names, e.g.
+//
+//	feature-gates:
+//	  Multishare: true
+//	extra-labels:
+//	  team: storage
+//	kube-api-qps: 10
+//	op-poll-interval: 10s
+//
+// Lists are joined with commas, and maps are joined as comma separated key=value pairs,
+// sorted by key. The flags set on the command line take precedence over the config file.
+func ApplyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q in config file %s", name, path)
+		}
+		if setOnCommandLine[name] {
+			continue
+		}
+		value, err := configValueToFlagValue(config[name])
+		if err != nil {
+			return fmt.Errorf("invalid value of flag %q in config file %s: %w", name, path, err)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value of flag %q in config file %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// configValueToFlagValue converts a value decoded from the config file to its command line form.
+func configValueToFlagValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			value, err := configScalarToFlagValue(item)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, value)
+		}
+		return strings.Join(values, ","), nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			value, err := configScalarToFlagValue(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+value)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v of type %T", v, v)
+	}
+}
+
+// configScalarToFlagValue converts an item of a list or map of the config file, which can't be nested.
+func configScalarToFlagValue(v interface{}) (string, error) {
+	switch v.(type) {
+	case []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("nested value %v not supported", v)
+	}
+	return configValueToFlagValue(v)
+}
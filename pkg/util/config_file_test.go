@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+	cases := []struct {
+		name      string
+		config    string
+		args      []string
+		expectErr bool
+		expected  map[string]string
+	}{
+		{
+			name:   "empty config",
+			config: "",
+			expected: map[string]string{
+				"extra-labels":     "",
+				"kube-api-qps":     "5",
+				"op-poll-interval": "5s",
+				"enable-feature":   "false",
+				"regions":          "",
+			},
+		},
+		{
+			name: "all value types",
+			config: `
+extra-labels:
+  team: storage
+  env: prod
+kube-api-qps: 7.5
+op-poll-interval: 10s
+enable-feature: true
+regions:
+- us-central1
+- us-east1
+`,
+			expected: map[string]string{
+				"extra-labels":     "env=prod,team=storage",
+				"kube-api-qps":     "7.5",
+				"op-poll-interval": "10s",
+				"enable-feature":   "true",
+				"regions":          "us-central1,us-east1",
+			},
+		},
+		{
+			name: "command line takes precedence",
+			config: `
+kube-api-qps: 20
+extra-labels: team=storage
+`,
+			args: []string{"--kube-api-qps=10"},
+			expected: map[string]string{
+				"extra-labels": "team=storage",
+				"kube-api-qps": "10",
+			},
+		},
+		{
+			name:      "unknown flag",
+			config:    "unknown: true",
+			expectErr: true,
+		},
+		{
+			name:      "invalid flag value",
+			config:    "op-poll-interval: 10",
+			expectErr: true,
+		},
+		{
+			name: "nested value",
+			config: `
+extra-labels:
+  team:
+    name: storage
+`,
+			expectErr: true,
+		},
+		{
+			name:      "malformed yaml",
+			config:    "extra-labels: [",
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.String("extra-labels", "", "")
+			fs.Float64("kube-api-qps", 5, "")
+			fs.Duration("op-poll-interval", 5*time.Second, "")
+			fs.Bool("enable-feature", false, "")
+			fs.String("regions", "", "")
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatalf("failed to parse flags: %v", err)
+			}
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+				t.Fatalf("failed to write config file: %v", err)
+			}
+
+			err := ApplyConfigFile(fs, path)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := make(map[string]string)
+			for name := range tc.expected {
+				got[name] = fs.Lookup(name).Value.String()
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got flags %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestApplyConfigFileMissing(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := ApplyConfigFile(fs, filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("expected error, got none")
+	}
+}
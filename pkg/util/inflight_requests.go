@@ -0,0 +1,88 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ErrConflictingInFlightRequest is returned by InFlightRequests.Do when a request with the
+// same key but different content is already in progress.
+var ErrConflictingInFlightRequest = errors.New("a different request with the same key is in progress")
+
+type inFlightRequest struct {
+	req  proto.Message
+	done chan struct{}
+	resp interface{}
+	err  error
+}
+
+// InFlightRequests tracks ongoing requests keyed by a request key. Unlike VolumeLocks, a
+// duplicate of an ongoing request does not fail, it waits for the ongoing request to
+// complete and receives the same result.
+type InFlightRequests struct {
+	requests map[string]*inFlightRequest
+	mux      sync.Mutex
+}
+
+func NewInFlightRequests() *InFlightRequests {
+	return &InFlightRequests{
+		requests: make(map[string]*inFlightRequest),
+	}
+}
+
+// Do runs fn for the request unless an identical request with the same key is already in
+// progress, in which case it waits for that request and returns its result. If a request
+// with the same key but different content is in progress, ErrConflictingInFlightRequest is
+// returned without running fn. A waiting duplicate returns the context error if ctx is done
+// before the ongoing request completes.
+func (r *InFlightRequests) Do(ctx context.Context, key string, req proto.Message, fn func() (interface{}, error)) (interface{}, error) {
+	r.mux.Lock()
+	if ongoing, ok := r.requests[key]; ok {
+		r.mux.Unlock()
+		if !proto.Equal(ongoing.req, req) {
+			return nil, ErrConflictingInFlightRequest
+		}
+		select {
+		case <-ongoing.done:
+			return ongoing.resp, ongoing.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	ongoing := &inFlightRequest{
+		req:  req,
+		done: make(chan struct{}),
+		// Overwritten once fn returns, waiters only observe it if fn panics.
+		err: errors.New("in-flight request did not complete"),
+	}
+	r.requests[key] = ongoing
+	r.mux.Unlock()
+
+	defer func() {
+		r.mux.Lock()
+		delete(r.requests, key)
+		r.mux.Unlock()
+		close(ongoing.done)
+	}()
+	ongoing.resp, ongoing.err = fn()
+	return ongoing.resp, ongoing.err
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestInFlightRequestsDuplicateJoinsOngoing(t *testing.T) {
+	r := NewInFlightRequests()
+	req := &csi.CreateVolumeRequest{Name: "pvc-1"}
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	calls := 0
+
+	var wg sync.WaitGroup
+	var firstResp interface{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		firstResp, _ = r.Do(context.Background(), "CreateVolume/pvc-1", req, func() (interface{}, error) {
+			calls++
+			close(started)
+			<-unblock
+			return "done", nil
+		})
+	}()
+	<-started
+
+	var dupResp interface{}
+	var dupErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dupResp, dupErr = r.Do(context.Background(), "CreateVolume/pvc-1", &csi.CreateVolumeRequest{Name: "pvc-1"}, func() (interface{}, error) {
+			calls++
+			return "duplicate", nil
+		})
+	}()
+	// Give the duplicate a chance to join before the ongoing request completes.
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected the request to run once, ran %d times", calls)
+	}
+	if dupErr != nil {
+		t.Errorf("unexpected error for duplicate request: %v", dupErr)
+	}
+	if firstResp != "done" || dupResp != "done" {
+		t.Errorf("expected both requests to get response %q, got %v and %v", "done", firstResp, dupResp)
+	}
+}
+
+func TestInFlightRequestsConflictingRequest(t *testing.T) {
+	r := NewInFlightRequests()
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	go r.Do(context.Background(), "CreateVolume/pvc-1", &csi.CreateVolumeRequest{Name: "pvc-1"}, func() (interface{}, error) {
+		close(started)
+		<-unblock
+		return nil, nil
+	})
+	<-started
+	defer close(unblock)
+
+	conflicting := &csi.CreateVolumeRequest{Name: "pvc-1", Parameters: map[string]string{"tier": "enterprise"}}
+	_, err := r.Do(context.Background(), "CreateVolume/pvc-1", conflicting, func() (interface{}, error) {
+		t.Errorf("conflicting request should not run")
+		return nil, nil
+	})
+	if !errors.Is(err, ErrConflictingInFlightRequest) {
+		t.Errorf("expected error %v, got %v", ErrConflictingInFlightRequest, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.Do(ctx, "CreateVolume/pvc-1", &csi.CreateVolumeRequest{Name: "pvc-1"}, func() (interface{}, error) {
+		t.Errorf("duplicate request should not run")
+		return nil, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error %v, got %v", context.Canceled, err)
+	}
+}
@@ -32,6 +32,9 @@ const (
 	MaxSharesPerInstance                    = 10
 	NewMultishareInstancePrefix             = "fs-"
 	ParamMultishareInstanceScLabelKey       = "storage_gke_io_storage-class-id"
+	// The min and max size bounds in GiB of a multishare instance, set from the storage class parameters.
+	ParamMultishareInstanceMinSizeLabelKey = "storage_gke_io_min-instance-size-gb"
+	ParamMultishareInstanceMaxSizeLabelKey = "storage_gke_io_max-instance-size-gb"
 
 	// This finalizer protects custom resource objects (shareInfo and instanceInfo) from being cleaned up by the API server.
 	// Clients will Delete the custom resource objects to express intent for filestore resource deletion and after the
@@ -21,6 +21,7 @@ import (
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/golang/protobuf/ptypes"
 	"google.golang.org/grpc/codes"
@@ -237,6 +238,24 @@ func CheckLabelValueRegex(value string) error {
 	return nil
 }
 
+// SanitizeLabelValue returns value as a valid label value, lowercased, with the invalid chars
+// replaced by _ and truncated to 63 characters.
+func SanitizeLabelValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsLower(r) || unicode.IsDigit(r) || r == '_' || r == '-' {
+			return r
+		}
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, value)
+	if runes := []rune(value); len(runes) > 63 {
+		value = string(runes[:63])
+	}
+	return value
+}
+
 func ParseInstanceURI(instanceURI string) (string, string, string, error) {
 	// Expected instance URI projects/<project-name>/locations/<location-name>/instances/<instance-name>
 	splitStr := strings.Split(instanceURI, "/")
@@ -293,6 +312,19 @@ func AlignBytes(currBytes int64, stepBytes int64) int64 {
 	return ((currBytes + stepBytes - 1) / stepBytes) * stepBytes
 }
 
+// AlignInstanceCapacityBytes rounds the capacity of a multishare instance up to its capacity
+// step, so that the capacity is accepted by the Filestore API, and bounds it to minBytes and
+// maxBytes rounded down to the step. The default step is used if the step of the instance
+// is unknown, e.g. before the instance is created.
+func AlignInstanceCapacityBytes(capacityBytes, stepSizeGb, minBytes, maxBytes int64) int64 {
+	if stepSizeGb <= 0 {
+		stepSizeGb = DefaultStepSizeGb
+	}
+	stepBytes := GbToBytes(stepSizeGb)
+	alignedBytes := AlignBytes(Max(capacityBytes, minBytes), stepBytes)
+	return Min(alignedBytes, maxBytes/stepBytes*stepBytes)
+}
+
 func IsAligned(curSizeBytes int64, expectedBytes int64) bool {
 	if curSizeBytes%expectedBytes == 0 {
 		return true
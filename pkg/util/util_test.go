@@ -316,6 +316,27 @@ func TestConvertVolToShareName(t *testing.T) {
 
 }
 
+func TestSanitizeLabelValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "v1.4.2-gke.0", expected: "v1_4_2-gke_0"},
+		{value: "PVC-1234", expected: "pvc-1234"},
+		{value: strings.Repeat("a", 70), expected: strings.Repeat("a", 63)},
+		{value: "", expected: ""},
+	}
+	for _, tc := range tests {
+		got := SanitizeLabelValue(tc.value)
+		if got != tc.expected {
+			t.Errorf("value %q: got %q, expected %q", tc.value, got, tc.expected)
+		}
+		if err := CheckLabelValueRegex(got); err != nil {
+			t.Errorf("value %q: got invalid label value: %v", tc.value, err)
+		}
+	}
+}
+
 func TestParseInstanceURI(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -468,6 +489,73 @@ func TestAlignBytes(t *testing.T) {
 
 }
 
+func TestAlignInstanceCapacityBytes(t *testing.T) {
+	tests := []struct {
+		name          string
+		capacityBytes int64
+		stepSizeGb    int64
+		minBytes      int64
+		maxBytes      int64
+		targetBytes   int64
+	}{
+		{
+			name:          "unknown step uses the default step",
+			capacityBytes: 1*Tb + 1,
+			minBytes:      1 * Tb,
+			maxBytes:      10 * Tb,
+			targetBytes:   2 * Tb,
+		},
+		{
+			name:          "rounded up to the step",
+			capacityBytes: 1124 * Gb,
+			stepSizeGb:    256,
+			minBytes:      1 * Tb,
+			maxBytes:      10 * Tb,
+			targetBytes:   1280 * Gb,
+		},
+		{
+			name:          "aligned capacity",
+			capacityBytes: 1280 * Gb,
+			stepSizeGb:    256,
+			minBytes:      1 * Tb,
+			maxBytes:      10 * Tb,
+			targetBytes:   1280 * Gb,
+		},
+		{
+			name:          "bounded by min",
+			capacityBytes: 100 * Gb,
+			stepSizeGb:    256,
+			minBytes:      1 * Tb,
+			maxBytes:      10 * Tb,
+			targetBytes:   1 * Tb,
+		},
+		{
+			name:          "bounded by max",
+			capacityBytes: 11 * Tb,
+			stepSizeGb:    256,
+			minBytes:      1 * Tb,
+			maxBytes:      10 * Tb,
+			targetBytes:   10 * Tb,
+		},
+		{
+			name:          "bounded by max rounded down to the step",
+			capacityBytes: 1400 * Gb,
+			stepSizeGb:    256,
+			minBytes:      1 * Tb,
+			maxBytes:      1300 * Gb,
+			targetBytes:   1280 * Gb,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			targetBytes := AlignInstanceCapacityBytes(tc.capacityBytes, tc.stepSizeGb, tc.minBytes, tc.maxBytes)
+			if targetBytes != tc.targetBytes {
+				t.Errorf("got %d bytes, want %d", targetBytes, tc.targetBytes)
+			}
+		})
+	}
+}
+
 func TestGetRegionFromZone(t *testing.T) {
 	tests := []struct {
 		name       string
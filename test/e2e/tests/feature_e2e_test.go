@@ -0,0 +1,172 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	filev1beta1 "google.golang.org/api/file/v1beta1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+	testutils "sigs.k8s.io/gcp-filestore-csi-driver/test/e2e/utils"
+)
+
+const (
+	enterpriseTier              = "enterprise"
+	multishareStorageClassLabel = "gcfs-csi-e2e"
+	multishareMaxVolumeSize     = "128Gi"
+	multishareMaxVolumeBytes    = 128 * util.Gb
+	lockReleaseAttr             = "supportLockRelease"
+)
+
+// knownTiers are the Filestore tiers single share volumes can be tested in, see --tiers.
+var knownTiers = []string{"standard", "premium", "basic_hdd", "basic_ssd", enterpriseTier, "high_scale_ssd", "zonal"}
+
+var _ = Describe("Google Cloud Filestore CSI Driver feature gates", func() {
+
+	for _, tier := range knownTiers {
+		tier := tier
+		It(fmt.Sprintf("Should create -> validate -> delete a %s tier volume", tier), func() {
+			if !isTierTested(tier) {
+				Skip(fmt.Sprintf("Tier %s is not in --tiers %q", tier, *tiers))
+			}
+			testContext := getRandomTestContext()
+
+			diskInfo, cleanupDisk := createDiskWithParams("", "", map[string]string{"tier": tier}, map[string]string{"test-label": "testing"}, testContext)
+			defer cleanupDisk()
+
+			validateTierDisk(diskInfo, tier)
+		})
+	}
+
+	It("Should create -> write/read -> delete multishare volumes packed on one instance", func() {
+		if !*enableMultishare {
+			Skip("Multishare is not enabled, see --enable-multishare")
+		}
+		testContext := getRandomTestContext()
+
+		var volumes []*csi.Volume
+		for i := 0; i < 2; i++ {
+			vol, err := testContext.Client.CreateVolume(testNamePrefix+string(uuid.NewUUID()), "", "", multishareStorageClassParameters())
+			Expect(err).To(BeNil(), "CreateVolume failed with error: %v", err)
+			volumes = append(volumes, vol)
+		}
+		instanceURI := multishareInstanceURI(volumes[0])
+		defer func() {
+			for _, vol := range volumes {
+				err := testContext.Client.DeleteVolume(vol.GetVolumeId())
+				Expect(err).To(BeNil(), "DeleteVolume failed")
+			}
+			// The instance is deleted along with its last share.
+			_, err := fileInstancesService.Get(instanceURI).Do()
+			Expect(err).NotTo(BeNil(), "Could get multishare instance from cloud directly after deleting all its shares")
+		}()
+		Expect(multishareInstanceURI(volumes[1])).To(Equal(instanceURI), "Volumes of the same storage class not packed on one instance")
+
+		validateMultishareInstance(instanceURI)
+		sharesService := filev1beta1.NewProjectsLocationsInstancesSharesService(fileService)
+		for _, vol := range volumes {
+			tokens := strings.Split(vol.GetVolumeId(), "/")
+			share, err := sharesService.Get(instanceURI + "/shares/" + tokens[len(tokens)-1]).Do()
+			Expect(err).To(BeNil(), "Could not get share from cloud directly")
+			Expect(share.State).To(Equal(readyState))
+		}
+
+		di := &DiskInfo{
+			TestCtx: testContext,
+			Name:    testNamePrefix + string(uuid.NewUUID()),
+			Volume:  volumes[0],
+		}
+		stageDir := filepath.Join("/tmp/", di.Name, "stage")
+		publishDir := filepath.Join("/tmp/", di.Name, "mount")
+		for _, dir := range []string{stageDir, publishDir} {
+			err := testutils.MkdirAll(testContext.Instance, dir)
+			Expect(err).To(BeNil(), "Mkdir failed with error")
+		}
+		defer func() {
+			err := testutils.RmAll(testContext.Instance, filepath.Join("/tmp/", di.Name))
+			Expect(err).To(BeNil(), "Failed to delete remote directory")
+		}()
+
+		cleanupMount := stageAndPublish(stageDir, publishDir, di)
+		defer cleanupMount()
+
+		testFileName := "testfile-multishare"
+		testFileContents := "test"
+		validateWrite(publishDir, testFileName, testFileContents, testContext.Instance)
+		validateRead(publishDir, testFileName, testFileContents, testContext.Instance)
+	})
+})
+
+func isTierTested(tier string) bool {
+	for _, t := range strings.Split(*tiers, ",") {
+		if strings.TrimSpace(t) == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// multishareStorageClassParameters returns the CreateVolume parameters of the multishare
+// storage class, depending on the feature gates the driver is run with.
+func multishareStorageClassParameters() map[string]string {
+	params := map[string]string{
+		"tier":                        enterpriseTier,
+		"multishare":                  "true",
+		"instance-storageclass-label": multishareStorageClassLabel,
+	}
+	if *featureMaxSharesPerInstance {
+		params["max-volume-size"] = multishareMaxVolumeSize
+	}
+	return params
+}
+
+// multishareInstanceURI returns the URI of the instance hosting a multishare volume, whose ID
+// is of the form modeMultishare/<storage class label>/<project>/<location>/<instance>/<share>.
+func multishareInstanceURI(vol *csi.Volume) string {
+	tokens := strings.Split(vol.GetVolumeId(), "/")
+	Expect(tokens).To(HaveLen(6), "Unexpected multishare volume ID %s", vol.GetVolumeId())
+	return fmt.Sprintf(instanceURIFormat, tokens[2], tokens[3], tokens[4])
+}
+
+func validateTierDisk(di *DiskInfo, tier string) {
+	inst, err := getDisk(di)
+	Expect(err).To(BeNil(), "Could not get disk from cloud directly")
+	Expect(inst.State).To(Equal(readyState))
+	Expect(inst.Tier).To(Equal(strings.ToUpper(tier)))
+	for k, v := range di.Labels {
+		Expect(inst.Labels[k]).To(Equal(v), "Expected custom label value does not match expected value")
+	}
+
+	_, lockRelease := di.Volume.GetVolumeContext()[lockReleaseAttr]
+	Expect(lockRelease).To(Equal(*featureLockRelease && tier == enterpriseTier), "Unexpected lock release support in volume context %v", di.Volume.GetVolumeContext())
+}
+
+func validateMultishareInstance(instanceURI string) {
+	inst, err := fileInstancesService.Get(instanceURI).Do()
+	Expect(err).To(BeNil(), "Could not get multishare instance from cloud directly")
+	Expect(inst.State).To(Equal(readyState))
+	Expect(inst.Tier).To(Equal(strings.ToUpper(enterpriseTier)))
+	Expect(inst.Labels[util.ParamMultishareInstanceScLabelKey]).To(Equal(multishareStorageClassLabel))
+	Expect(inst.Labels[clusterNameLabelKey]).To(Equal(e2eClusterName))
+	if *featureMaxSharesPerInstance {
+		Expect(inst.MaxShareCount).To(Equal(util.MaxMultishareInstanceSizeBytes / multishareMaxVolumeBytes))
+	}
+}
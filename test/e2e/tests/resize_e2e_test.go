@@ -219,21 +219,34 @@ type DiskInfo struct {
 }
 
 func createDisk(zone, snapshotID string, labels map[string]string, tc *remote.TestContext) (*DiskInfo, func()) {
+	return createDiskWithParams(zone, snapshotID, nil, labels, tc)
+}
+
+// createDiskWithParams creates a single share volume with the given storage class parameters,
+// e.g. its tier, in addition to the labels.
+func createDiskWithParams(zone, snapshotID string, params, labels map[string]string, tc *remote.TestContext) (*DiskInfo, func()) {
 	name := testNamePrefix + string(uuid.NewUUID())
-	params := make(map[string]string)
+	volParams := make(map[string]string)
+	for k, v := range params {
+		volParams[k] = v
+	}
 	if len(labels) > 0 {
 		var l []string
 		for k, v := range labels {
 			l = append(l, fmt.Sprintf("%s=%s", k, v))
 		}
-		params["labels"] = strings.Join(l, ",")
+		volParams["labels"] = strings.Join(l, ",")
 	}
-	vol, err := tc.Client.CreateVolume(name, zone, snapshotID, params)
+	vol, err := tc.Client.CreateVolume(name, zone, snapshotID, volParams)
 	Expect(err).To(BeNil(), "CreateVolume failed with error: %v", err)
 	if zone == "" {
-		// If disk zone is not set upon creation, it defaults to same zone as the instance
+		// If disk zone is not set upon creation, it defaults to same zone as the instance,
+		// or its region for regional tiers. Volume IDs are of the form modeInstance/<location>/<name>/<share>.
 		_, z, _ := tc.Instance.GetIdentity()
 		zone = z
+		if tokens := strings.Split(vol.GetVolumeId(), "/"); len(tokens) == 4 {
+			zone = tokens[1]
+		}
 	}
 	di := &DiskInfo{
 		TestCtx: tc,
@@ -19,6 +19,7 @@ import (
 	"flag"
 	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -34,6 +35,9 @@ import (
 
 const (
 	boskosResourceType = "gce-project"
+	// e2eClusterName is the cluster name the driver labels the multishare instances it creates with.
+	e2eClusterName      = "gcfs-csi-e2e"
+	clusterNameLabelKey = "storage_gke_io_cluster_name"
 )
 
 var (
@@ -42,6 +46,14 @@ var (
 	runInProw       = flag.Bool("run-in-prow", false, "If true, use a Boskos loaned project and special CI service accounts and ssh keys")
 	deleteInstances = flag.Bool("delete-instances", false, "Delete the instances after tests run")
 
+	// Driver feature gates and storage classes the suite is run with.
+	tiers                       = flag.String("tiers", "standard", "Comma separated list of Filestore tiers, e.g. standard,enterprise, in which single share volumes are tested")
+	enableMultishare            = flag.Bool("enable-multishare", false, "Run the driver with multishare enabled, and the multishare tests")
+	featureMaxSharesPerInstance = flag.Bool("feature-max-shares-per-instance", false, "Run the driver with the configurable max shares per instance feature enabled. Requires enable-multishare and driver-kubeconfig")
+	featureLockRelease          = flag.Bool("feature-lock-release", false, "Run the driver with the Filestore lock release feature enabled. Requires driver-kubeconfig")
+	driverKubeconfig            = flag.String("driver-kubeconfig", "", "Path, on the test instances, of the kubeconfig used by the driver for the features that need a Kubernetes API server")
+	verifyNoLeakedInstances     = flag.Bool("verify-no-leaked-instances", false, "Fail the suite if Filestore instances created by the tests are left in the project after teardown, and delete them")
+
 	testContexts         = []*remote.TestContext{}
 	zones                []string
 	computeService       *compute.Service
@@ -84,6 +96,13 @@ var _ = BeforeSuite(func() {
 
 	Expect(*project).ToNot(BeEmpty(), "Project should not be empty")
 	Expect(*serviceAccount).ToNot(BeEmpty(), "Service account should not be empty")
+	if *featureMaxSharesPerInstance {
+		Expect(*enableMultishare).To(BeTrue(), "feature-max-shares-per-instance requires enable-multishare")
+	}
+	if *featureMaxSharesPerInstance || *featureLockRelease {
+		Expect(*driverKubeconfig).ToNot(BeEmpty(), "driver-kubeconfig should be set for the features that need a Kubernetes API server")
+	}
+	klog.Infof("Running the driver with flags %v, testing tiers %q", driverFlags(), *tiers)
 
 	for _, zone := range zones {
 		go func(curZone string) {
@@ -97,7 +116,7 @@ var _ = BeforeSuite(func() {
 			Expect(err).To(BeNil(), "Set up Instance failed with error")
 
 			// Create new driver and client
-			testContext, err := testutils.GCFSClientAndDriverSetup(i)
+			testContext, err := testutils.GCFSClientAndDriverSetup(i, driverFlags())
 			tcc <- testContext
 			Expect(err).To(BeNil(), "Set up new Driver and Client failed with error")
 		}(zone)
@@ -127,8 +146,65 @@ var _ = AfterSuite(func() {
 		}(tc, &wg)
 	}
 	wg.Wait()
+
+	if *verifyNoLeakedInstances {
+		verifyNoLeakedFilestoreInstances()
+	}
 })
 
+// driverFlags returns the flags enabling the feature gates the driver under test is run with.
+func driverFlags() []string {
+	var flags, gates []string
+	if *enableMultishare {
+		gates = append(gates, "Multishare=true")
+		flags = append(flags, "--gke-cluster-name="+e2eClusterName)
+	}
+	if *featureMaxSharesPerInstance {
+		gates = append(gates, "MaxSharesPerInstance=true")
+	}
+	if *featureLockRelease {
+		gates = append(gates, "LockRelease=true")
+	}
+	if len(gates) > 0 {
+		flags = append(flags, "--feature-gates="+strings.Join(gates, ","))
+	}
+	if *driverKubeconfig != "" {
+		flags = append(flags, "--kubeconfig="+*driverKubeconfig)
+	}
+	return flags
+}
+
+// verifyNoLeakedFilestoreInstances fails if Filestore instances created by the tests are left
+// in the project, after deleting them so that they do not leak into the next runs.
+func verifyNoLeakedFilestoreInstances() {
+	var leaked []string
+	parent := fmt.Sprintf("projects/%s/locations/-", *project)
+	err := fileInstancesService.List(parent).Pages(context.Background(), func(resp *filev1beta1.ListInstancesResponse) error {
+		for _, instance := range resp.Instances {
+			if isTestFilestoreInstance(instance) {
+				leaked = append(leaked, instance.Name)
+			}
+		}
+		return nil
+	})
+	Expect(err).To(BeNil(), "Failed to list Filestore instances")
+
+	for _, name := range leaked {
+		klog.Warningf("Deleting leaked Filestore instance %s", name)
+		if _, err := fileInstancesService.Delete(name).Force(true).Do(); err != nil {
+			klog.Errorf("Failed to delete leaked Filestore instance %s: %v", name, err)
+		}
+	}
+	Expect(leaked).To(BeEmpty(), "Filestore instances leaked by the tests")
+}
+
+// isTestFilestoreInstance returns true for the single share instances and the multishare
+// instances created by the tests.
+func isTestFilestoreInstance(instance *filev1beta1.Instance) bool {
+	name := instance.Name[strings.LastIndex(instance.Name, "/")+1:]
+	return strings.HasPrefix(name, testNamePrefix) || instance.Labels[clusterNameLabelKey] == e2eClusterName
+}
+
 func getRandomTestContext() *remote.TestContext {
 	Expect(testContexts).ToNot(BeEmpty())
 	rn := rand.Intn(len(testContexts))
@@ -20,6 +20,7 @@ import (
 	"math/rand"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2/google"
@@ -33,7 +34,9 @@ var (
 	boskos, _ = boskosclient.NewClient(os.Getenv("JOB_NAME"), "http://boskos", "", "")
 )
 
-func GCFSClientAndDriverSetup(instance *remote.InstanceInfo) (*remote.TestContext, error) {
+// GCFSClientAndDriverSetup installs and starts the driver on the instance, passing it the
+// given extra flags, e.g. to enable feature gates, and returns a client connected to it.
+func GCFSClientAndDriverSetup(instance *remote.InstanceInfo, driverFlags []string) (*remote.TestContext, error) {
 	port := fmt.Sprintf("%v", 1024+rand.Intn(10000))
 	goPath, ok := os.LookupEnv("GOPATH")
 	if !ok {
@@ -50,8 +53,8 @@ func GCFSClientAndDriverSetup(instance *remote.InstanceInfo) (*remote.TestContex
 	endpoint := fmt.Sprintf("tcp://localhost:%s", port)
 
 	workspace := remote.NewWorkspaceDir("gcfs-csi-e2e-")
-	driverRunCmd := fmt.Sprintf("sh -c '/usr/bin/nohup %s/gcp-filestore-csi-driver --endpoint=%s --nodeid=%s --controller=true --node=true > %s/prog.out 2> %s/prog.err < /dev/null &'",
-		workspace, endpoint, instance.GetName(), workspace, workspace)
+	driverRunCmd := fmt.Sprintf("sh -c '/usr/bin/nohup %s/gcp-filestore-csi-driver --endpoint=%s --nodeid=%s --controller=true --node=true %s > %s/prog.out 2> %s/prog.err < /dev/null &'",
+		workspace, endpoint, instance.GetName(), strings.Join(driverFlags, " "), workspace, workspace)
 
 	config := &remote.ClientConfig{
 		PkgPath:      pkgPath,
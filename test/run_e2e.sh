@@ -4,5 +4,23 @@ set -e
 set -x
 
 readonly PKGDIR=sigs.k8s.io/gcp-filestore-csi-driver
+# Path, on the test instances, of the kubeconfig used by the driver for the feature gates that
+# need a Kubernetes API server. The combinations using them are skipped if it is not set.
+readonly E2E_DRIVER_KUBECONFIG=${E2E_DRIVER_KUBECONFIG:-}
 
-go test --mod=vendor --timeout 35m --v=true "${PKGDIR}/test/e2e/tests" --logtostderr --run-in-prow=true --delete-instances=true
+# Each entry is a combination of driver feature gates and tiers the suite is run with.
+feature_sets=(
+  "--tiers=standard,enterprise"
+  "--tiers=enterprise --enable-multishare=true"
+)
+if [[ -n "${E2E_DRIVER_KUBECONFIG}" ]]; then
+  feature_sets+=(
+    "--tiers=enterprise --feature-lock-release=true --driver-kubeconfig=${E2E_DRIVER_KUBECONFIG}"
+    "--tiers=enterprise --enable-multishare=true --feature-max-shares-per-instance=true --driver-kubeconfig=${E2E_DRIVER_KUBECONFIG}"
+  )
+fi
+
+for features in "${feature_sets[@]}"; do
+  # shellcheck disable=SC2086
+  go test --mod=vendor --timeout 35m --v=true "${PKGDIR}/test/e2e/tests" --logtostderr --run-in-prow=true --delete-instances=true --verify-no-leaked-instances=true ${features}
+done